@@ -0,0 +1,16 @@
+#!/bin/bash
+# Builds the browser wallet code into ./wasm:
+# coinkit.wasm, the wasm_exec.js it needs, and coinkit.js.
+
+set -e
+
+mkdir -p wasm
+GOOS=js GOARCH=wasm go build -o wasm/coinkit.wasm ./cmd/cwasm
+
+GOROOT=`go env GOROOT`
+if [ -f "$GOROOT/lib/wasm/wasm_exec.js" ]; then
+    cp "$GOROOT/lib/wasm/wasm_exec.js" wasm/
+else
+    cp "$GOROOT/misc/wasm/wasm_exec.js" wasm/
+fi
+cp cmd/cwasm/coinkit.js wasm/
@@ -0,0 +1,127 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/data"
+	"github.com/lacker/coinkit/util"
+)
+
+// The bus package publishes finalized blocks to a message bus, so that stream
+// processing pipelines can consume them without talking to a node directly.
+// Kafka and NATS are supported.
+
+const (
+	KindKafka = "kafka"
+	KindNATS  = "nats"
+)
+
+// Config says where to publish blocks to.
+type Config struct {
+	// Either KindKafka or KindNATS
+	Kind string
+
+	// For Kafka, these are broker addresses, like "127.0.0.1:9092".
+	// For NATS, these are server urls, like "nats://127.0.0.1:4222".
+	URLs []string
+
+	// The Kafka topic or NATS subject to publish to
+	Topic string
+}
+
+// Check returns an error if the config is not usable.
+func (c *Config) Check() error {
+	if c.Kind != KindKafka && c.Kind != KindNATS {
+		return fmt.Errorf("unknown bus kind: %q", c.Kind)
+	}
+	if len(c.URLs) == 0 {
+		return fmt.Errorf("a %s bus needs at least one url", c.Kind)
+	}
+	if c.Topic == "" {
+		return fmt.Errorf("a %s bus needs a topic", c.Kind)
+	}
+	return nil
+}
+
+// A Publisher sends messages to a single topic.
+// Publish may be slow, so it should not be called on the consensus path.
+type Publisher interface {
+	// Messages with the same key are delivered in the order they are published.
+	Publish(ctx context.Context, key string, value []byte) error
+
+	Close() error
+}
+
+// NewPublisher connects to the bus the config describes.
+func NewPublisher(c *Config) (Publisher, error) {
+	if err := c.Check(); err != nil {
+		return nil, err
+	}
+	switch c.Kind {
+	case KindKafka:
+		return newKafkaPublisher(c), nil
+	case KindNATS:
+		return newNATSPublisher(c)
+	default:
+		panic("coding error")
+	}
+}
+
+// A BlockMessage is what gets published for each block, encoded as JSON.
+type BlockMessage struct {
+	Slot int `json:"slot"`
+
+	// The operations in the block, in order
+	Operations []*OperationMessage `json:"operations"`
+
+	// The state of the accounts these operations changed, right after the block
+	Accounts map[string]*currency.Account `json:"accounts"`
+}
+
+// An OperationMessage is one operation, decoded.
+type OperationMessage struct {
+	Type        string         `json:"type"`
+	Signer      string         `json:"signer"`
+	Sequence    uint32         `json:"sequence"`
+	Fee         uint64         `json:"fee"`
+	Signature   string         `json:"signature"`
+	Description string         `json:"description"`
+	Operation   util.Operation `json:"operation"`
+}
+
+func NewBlockMessage(b *data.Block) *BlockMessage {
+	answer := &BlockMessage{
+		Slot:       b.Slot,
+		Operations: []*OperationMessage{},
+		Accounts:   make(map[string]*currency.Account),
+	}
+	if b.Chunk == nil {
+		return answer
+	}
+	for _, op := range b.Chunk.Operations {
+		answer.Operations = append(answer.Operations, &OperationMessage{
+			Type:        op.Type,
+			Signer:      op.GetSigner(),
+			Sequence:    op.GetSequence(),
+			Fee:         op.GetFee(),
+			Signature:   op.Signature,
+			Description: op.String(),
+			Operation:   op.Operation,
+		})
+	}
+	for owner, account := range b.Chunk.State {
+		answer.Accounts[owner] = account
+	}
+	return answer
+}
+
+func (m *BlockMessage) Encode() []byte {
+	bytes, err := json.Marshal(m)
+	if err != nil {
+		panic(err)
+	}
+	return bytes
+}
@@ -0,0 +1,69 @@
+package bus
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/data"
+	"github.com/lacker/coinkit/util"
+)
+
+func TestBlockMessage(t *testing.T) {
+	bob := util.NewKeyPairFromSecretPhrase("bob")
+	carol := util.NewKeyPairFromSecretPhrase("carol")
+	op := util.NewSignedOperation(&currency.SendOperation{
+		Signer:   bob.PublicKey().String(),
+		Sequence: 4,
+		To:       carol.PublicKey().String(),
+		Amount:   10,
+		Fee:      2,
+	}, bob)
+	chunk := currency.NewEmptyChunk()
+	chunk.Operations = []*util.SignedOperation{op}
+	chunk.State[carol.PublicKey().String()] = &currency.Account{Balance: 10}
+
+	encoded := NewBlockMessage(&data.Block{Slot: 3, Chunk: chunk}).Encode()
+	decoded := map[string]interface{}{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded["slot"] != 3.0 {
+		t.Fatalf("bad slot in %s", encoded)
+	}
+	ops := decoded["operations"].([]interface{})
+	if len(ops) != 1 {
+		t.Fatalf("bad operations in %s", encoded)
+	}
+	first := ops[0].(map[string]interface{})
+	if first["type"] != "Send" || first["signer"] != bob.PublicKey().String() ||
+		first["sequence"] != 4.0 || first["fee"] != 2.0 ||
+		first["signature"] != op.Signature {
+		t.Fatalf("bad operation in %s", encoded)
+	}
+	inner := first["operation"].(map[string]interface{})
+	if inner["To"] != carol.PublicKey().String() || inner["Amount"] != 10.0 {
+		t.Fatalf("the operation was not decoded in %s", encoded)
+	}
+	accounts := decoded["accounts"].(map[string]interface{})
+	if len(accounts) != 1 {
+		t.Fatalf("bad accounts in %s", encoded)
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	good := &Config{Kind: KindNATS, URLs: []string{"nats://127.0.0.1:4222"}, Topic: "blocks"}
+	if err := good.Check(); err != nil {
+		t.Fatal(err)
+	}
+	bad := []*Config{
+		{Kind: "rabbit", URLs: []string{"x"}, Topic: "blocks"},
+		{Kind: KindKafka, Topic: "blocks"},
+		{Kind: KindKafka, URLs: []string{"127.0.0.1:9092"}},
+	}
+	for _, c := range bad {
+		if c.Check() == nil {
+			t.Fatalf("expected an error for %+v", c)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package bus
+
+import (
+	"context"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NATS has no keys, but a single connection delivers messages in order,
+// so the key is passed along as a header.
+type natsPublisher struct {
+	conn    *nats.Conn
+	subject string
+}
+
+func newNATSPublisher(c *Config) (*natsPublisher, error) {
+	conn, err := nats.Connect(strings.Join(c.URLs, ","),
+		nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+	return &natsPublisher{
+		conn:    conn,
+		subject: c.Topic,
+	}, nil
+}
+
+func (p *natsPublisher) Publish(ctx context.Context, key string, value []byte) error {
+	message := nats.NewMsg(p.subject)
+	message.Header.Set("Key", key)
+	message.Data = value
+	if err := p.conn.PublishMsg(message); err != nil {
+		return err
+	}
+	// Publishing is buffered, so flush to find out if the server got it
+	return p.conn.FlushWithContext(ctx)
+}
+
+func (p *natsPublisher) Close() error {
+	return p.conn.Drain()
+}
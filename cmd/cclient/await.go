@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lacker/coinkit/network"
+	"github.com/lacker/coinkit/util"
+)
+
+// Exit codes for await, so that scripts can tell the outcomes apart. Any
+// other failure exits with 1, like the rest of cclient.
+const (
+	exitCleared  = 0
+	exitRejected = 2
+	exitTimeout  = 3
+)
+
+// parseAwaitArgs reads the arguments to await, which are a target and an
+// optional --timeout.
+func parseAwaitArgs(args []string) (string, time.Duration) {
+	target := ""
+	timeout := clearTimeout
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		value := ""
+		switch {
+		case strings.HasPrefix(arg, "--timeout="):
+			value = strings.TrimPrefix(arg, "--timeout=")
+		case arg == "--timeout" && i+1 < len(args):
+			i++
+			value = args[i]
+		case target == "" && !strings.HasPrefix(arg, "-"):
+			target = arg
+			continue
+		default:
+			util.Logger.Fatalf("unexpected argument: %s", arg)
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			util.Logger.Fatalf("invalid timeout: %s", value)
+		}
+		timeout = d
+	}
+	if target == "" {
+		util.Logger.Fatal("Usage: cclient await <account:seq> [--timeout 60s]")
+	}
+	return target, timeout
+}
+
+// parseTarget reads an account:seq target.
+func parseTarget(target string) (string, uint32) {
+	i := strings.LastIndex(target, ":")
+	if i < 0 {
+		util.Logger.Fatalf("expected account:seq but got %s", target)
+	}
+	seq, err := strconv.ParseUint(target[i+1:], 10, 32)
+	if err != nil || seq == 0 {
+		util.Logger.Fatalf("invalid sequence number: %s", target[i+1:])
+	}
+	return parseAddress(target[:i]), uint32(seq)
+}
+
+// await waits for an account's operation with a sequence number to clear,
+// and exits with a code that says how it went.
+func await(args []string) {
+	target, timeout := parseAwaitArgs(args)
+	user, seq := parseTarget(target)
+	client := newClient()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_, err := client.AwaitOperation(ctx, user, seq)
+	switch {
+	case err == nil:
+		util.Logger.Printf("op %d cleared", seq)
+		os.Exit(exitCleared)
+	case err == network.ErrRejected:
+		util.Logger.Printf("op %d was rejected", seq)
+		os.Exit(exitRejected)
+	case ctx.Err() != nil:
+		util.Logger.Printf("op %d did not clear within %s", seq, timeout)
+		os.Exit(exitTimeout)
+	default:
+		util.Logger.Fatalf("could not wait for op %d: %s", seq, err)
+	}
+}
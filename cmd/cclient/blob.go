@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/util"
+)
+
+// putBlob pins a file as a blob, waits for the pin to be saved in a block,
+// and then uploads the file to the node.
+func putBlob(filename string) {
+	blob, err := ioutil.ReadFile(filename)
+	if err != nil {
+		util.Logger.Fatal(err)
+	}
+	if len(blob) == 0 || len(blob) > currency.MaxBlobSize {
+		util.Logger.Fatalf("blobs must be between 1 and %d bytes", currency.MaxBlobSize)
+	}
+	kp := login()
+	user := kp.PublicKey().String()
+	client := newClient()
+	account := getAccount(client, user)
+	if account == nil {
+		util.Logger.Fatalf("%s needs an account to pin blobs", netConfig.FormatAddress(user))
+	}
+
+	seq := account.Sequence + 1
+	op := &currency.PinBlobOperation{
+		Signer:   user,
+		Sequence: seq,
+		Fee:      estimateFee(client) + currency.BlobStorageFee(len(blob)),
+		Hash:     currency.BlobHash(blob),
+		Size:     len(blob),
+	}
+	sop := util.NewSignedOperation(op, kp)
+	client.Send(util.NewSignedMessage(currency.NewTransactionMessage(sop), kp))
+	util.Logger.Printf("pinning %s for a fee of %s", op.Hash, netConfig.FormatAmount(op.Fee))
+
+	ctx, cancel := context.WithTimeout(context.Background(), clearTimeout)
+	defer cancel()
+	if _, err := client.WaitToClear(ctx, user, seq); err != nil {
+		util.Logger.Fatalf("op %d did not clear: %s", seq, err)
+	}
+	if err := client.UploadBlob(ctx, blob); err != nil {
+		util.Logger.Fatalf("could not upload the blob: %s", err)
+	}
+	util.Logger.Printf("saved blob %s", op.Hash)
+}
+
+// getBlob fetches a blob from the node and writes it to a file.
+func getBlob(hash string, filename string) {
+	client := newClient()
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	blob, err := client.GetBlob(ctx, hash)
+	if err != nil {
+		util.Logger.Fatalf("could not get the blob: %s", err)
+	}
+	if err := ioutil.WriteFile(filename, blob, 0644); err != nil {
+		util.Logger.Fatal(err)
+	}
+	util.Logger.Printf("wrote %d bytes to %s", len(blob), filename)
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/lacker/coinkit/network"
+	"github.com/lacker/coinkit/util"
+)
+
+// clientConfig is what cclient remembers between runs. It is stored as JSON
+// in ~/.coinkit/config, and changed with cclient config set.
+// Empty settings use the defaults.
+type clientConfig struct {
+	// The host:port of nodes to connect to, instead of a random node on the
+	// network. One of them is picked at random.
+	Nodes []string `json:"nodes,omitempty"`
+
+	// A key pair file, like the ones cclient generate writes, to use instead
+	// of asking for a passphrase.
+	Key string `json:"key,omitempty"`
+
+	// Which built-in network to talk to, like "testnet". The default is the
+	// local network.
+	Network string `json:"network,omitempty"`
+
+	// How amounts are written, "coins" or "units". The default is coins.
+	Units string `json:"units,omitempty"`
+
+	// Addresses that cclient portfolio shows without having their keys
+	Watch []string `json:"watch,omitempty"`
+}
+
+// The settings cclient config knows about, in the order they are shown
+var configKeys = []string{"key", "network", "nodes", "units", "watch"}
+
+// The settings from ~/.coinkit/config
+var settings = &clientConfig{}
+
+// homeDir is the ~/.coinkit directory, or COINKIT_HOME if that is set.
+func homeDir() (string, error) {
+	dir := os.Getenv("COINKIT_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".coinkit")
+	}
+	return dir, nil
+}
+
+// configPath is where the client config is stored.
+func configPath() (string, error) {
+	dir, err := homeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "config"), nil
+}
+
+// readClientConfig reads the client config. A missing file is an empty config.
+func readClientConfig() (*clientConfig, error) {
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &clientConfig{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &clientConfig{}
+	if err := json.Unmarshal(raw, c); err != nil {
+		return nil, fmt.Errorf("bad config in %s: %s", path, err)
+	}
+	if err := c.check(); err != nil {
+		return nil, fmt.Errorf("bad config in %s: %s", path, err)
+	}
+	return c, nil
+}
+
+// write saves the client config, creating its directory if needed.
+func (c *clientConfig) write() error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	raw, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(raw, '\n'), 0600)
+}
+
+// check returns an error if any setting is invalid.
+func (c *clientConfig) check() error {
+	for _, node := range c.Nodes {
+		if _, _, err := splitNode(node); err != nil {
+			return err
+		}
+	}
+	if c.Network != "" {
+		if _, err := network.LookupNetwork(c.Network); err != nil {
+			return err
+		}
+	}
+	if c.Units != "" && c.Units != "coins" && c.Units != "units" {
+		return fmt.Errorf("units must be coins or units, not %s", c.Units)
+	}
+	return nil
+}
+
+// get returns a setting as the user would type it.
+func (c *clientConfig) get(key string) (string, error) {
+	switch key {
+	case "key":
+		return c.Key, nil
+	case "network":
+		return c.Network, nil
+	case "nodes":
+		return strings.Join(c.Nodes, ","), nil
+	case "units":
+		return c.Units, nil
+	case "watch":
+		return strings.Join(c.Watch, ","), nil
+	}
+	return "", fmt.Errorf("unknown setting: %s", key)
+}
+
+// set changes a setting. An empty value goes back to the default.
+func (c *clientConfig) set(key string, value string) error {
+	switch key {
+	case "key":
+		if value != "" {
+			abs, err := filepath.Abs(value)
+			if err != nil {
+				return err
+			}
+			if _, err := util.ReadKeyPairFromFile(abs); err != nil {
+				return err
+			}
+			value = abs
+		}
+		c.Key = value
+	case "network":
+		c.Network = value
+	case "nodes":
+		c.Nodes = splitList(value)
+	case "units":
+		c.Units = value
+	case "watch":
+		// Addresses are checked when they are used, since they depend on
+		// the network
+		c.Watch = splitList(value)
+	default:
+		return fmt.Errorf("unknown setting: %s", key)
+	}
+	return c.check()
+}
+
+// splitList reads a comma-separated list, skipping empty entries.
+func splitList(value string) []string {
+	answer := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			answer = append(answer, item)
+		}
+	}
+	if len(answer) == 0 {
+		return nil
+	}
+	return answer
+}
+
+// splitNode reads a host:port.
+func splitNode(node string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(node)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid node address %s: %s", node, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 {
+		return "", 0, fmt.Errorf("invalid port: %s", portStr)
+	}
+	return host, port, nil
+}
+
+// loadSettings reads the client config and applies it to netConfig.
+// networkName overrides the network setting, unless it is empty.
+func loadSettings(networkName string) {
+	c, err := readClientConfig()
+	if err != nil {
+		util.Logger.Fatal(err)
+	}
+	settings = c
+	if networkName == "" {
+		networkName = settings.Network
+	}
+	if networkName != "" {
+		named, err := network.LookupNetwork(networkName)
+		if err != nil {
+			util.Logger.Fatal(err)
+		}
+		if netConfig, err = named.Config(); err != nil {
+			util.Logger.Fatal(err)
+		}
+	}
+	if settings.Units == "units" {
+		netConfig.Decimals = 0
+	}
+}
+
+// preferredNode picks one of the preferred nodes, along with its public key
+// if the network config knows it. It returns a nil address when there are no
+// preferred nodes.
+func preferredNode() (string, *network.Address) {
+	if len(settings.Nodes) == 0 {
+		return "", nil
+	}
+	host, port, err := splitNode(settings.Nodes[rand.Intn(len(settings.Nodes))])
+	if err != nil {
+		util.Logger.Fatal(err)
+	}
+	address := &network.Address{Host: host, Port: port}
+	for key, a := range netConfig.Servers {
+		if a.Host == host && a.Port == port {
+			return key, address
+		}
+	}
+	return "", address
+}
+
+// configCommand handles cclient config get and cclient config set.
+func configCommand(args []string) {
+	switch {
+	case len(args) == 1 && args[0] == "get":
+		for _, key := range configKeys {
+			value, _ := settings.get(key)
+			fmt.Printf("%s=%s\n", key, value)
+		}
+	case len(args) == 2 && args[0] == "get":
+		value, err := settings.get(args[1])
+		if err != nil {
+			util.Logger.Fatal(err)
+		}
+		fmt.Println(value)
+	case len(args) == 3 && args[0] == "set":
+		if err := settings.set(args[1], args[2]); err != nil {
+			util.Logger.Fatal(err)
+		}
+		if err := settings.write(); err != nil {
+			util.Logger.Fatalf("could not save the config: %s", err)
+		}
+	default:
+		util.Logger.Fatalf("Usage: cclient config {get [setting],set <setting> <value>}\n"+
+			"settings: %s", strings.Join(configKeys, ", "))
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/data"
+	"github.com/lacker/coinkit/util"
+)
+
+// The most messages that inbox displays
+const inboxSize = 100
+
+// sendMessage encrypts a message for another account and waits for it to be
+// saved in a block.
+func sendMessage(recipient string, text string) {
+	recipient = parseAddress(recipient)
+	kp := login()
+	user := kp.PublicKey().String()
+	client := newClient()
+	account := getAccount(client, user)
+	if account == nil {
+		util.Logger.Fatalf("%s needs an account to send messages",
+			netConfig.FormatAddress(user))
+	}
+
+	seq := account.Sequence + 1
+	op, err := currency.NewMessageOperation(user, seq, estimateFee(client), recipient, text)
+	if err != nil {
+		util.Logger.Fatal(err)
+	}
+	sop := util.NewSignedOperation(op, kp)
+	client.Send(util.NewSignedMessage(currency.NewTransactionMessage(sop), kp))
+	util.Logger.Printf("sending a message to %s", netConfig.FormatAddress(recipient))
+
+	ctx, cancel := context.WithTimeout(context.Background(), clearTimeout)
+	defer cancel()
+	if _, err := client.WaitToClear(ctx, user, seq); err != nil {
+		util.Logger.Fatalf("op %d did not clear: %s", seq, err)
+	}
+	util.Logger.Printf("op %d cleared", seq)
+}
+
+// inbox decrypts and displays the messages sent to our account, oldest first.
+// The node we ask needs a database, since that's where messages are stored.
+func inbox() {
+	kp := login()
+	client := newClient()
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	docs, err := client.GetDocuments(ctx, map[string]interface{}{
+		"collection": data.MessageCollection,
+		"to":         kp.PublicKey().String(),
+	}, inboxSize)
+	if err != nil {
+		util.Logger.Fatalf("could not get messages: %s", err)
+	}
+	util.Logger.Printf("%d messages", len(docs))
+	for _, doc := range docs {
+		message := struct {
+			From       string `json:"from"`
+			Ciphertext string `json:"ciphertext"`
+			Slot       int    `json:"slot"`
+		}{}
+		if err := doc.Data.Unmarshal(&message); err != nil {
+			util.Logger.Printf("bad message document %d: %s", doc.Id, err)
+			continue
+		}
+		plaintext, err := kp.Decrypt(message.Ciphertext)
+		if err != nil {
+			util.Logger.Printf("could not decrypt message %d: %s", doc.Id, err)
+			continue
+		}
+		util.Logger.Printf("from %s in slot %d: %s",
+			netConfig.FormatAddress(message.From), message.Slot, plaintext)
+	}
+}
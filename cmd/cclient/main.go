@@ -2,8 +2,13 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"errors"
+	"net"
 	"os"
 	"strconv"
+	"text/tabwriter"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 
@@ -15,35 +20,260 @@ import (
 	"strings"
 )
 
-func newConnection() network.Connection {
-	config := network.NewLocalNetworkConfig()
-	address := config.RandomAddress()
+// How long to wait for a node to answer a query
+const queryTimeout = 10 * time.Second
+
+// How long to wait for an operation to clear
+const clearTimeout = time.Minute
+
+// How many slots an operation can wait to get into a block, when cclient
+// picks its fee
+const feeSlots = 3
+
+// The network that cclient talks to. The --network flag and the network
+// setting can pick another one.
+var netConfig = network.NewLocalNetworkConfig()
+
+// Whether to log in with a passphrase instead of a mnemonic, the way
+// accounts used to be made. The --legacy-phrase flag turns it on.
+var legacyPhrase = false
+
+// parseFlags reads the --network and --legacy-phrase flags before the
+// command, returning the network name and the rest of the arguments.
+func parseFlags(args []string) (string, []string) {
+	networkName := ""
+	for len(args) > 0 {
+		switch {
+		case strings.HasPrefix(args[0], "--network="):
+			networkName = strings.TrimPrefix(args[0], "--network=")
+			args = args[1:]
+		case len(args) > 1 && args[0] == "--network":
+			networkName = args[1]
+			args = args[2:]
+		case args[0] == "--legacy-phrase":
+			legacyPhrase = true
+			args = args[1:]
+		default:
+			return networkName, args
+		}
+	}
+	return networkName, args
+}
+
+// newClient connects to one of the preferred nodes, or a random node if there
+// are none, and checks that account data is really signed by that node.
+// A preferred node that isn't in the network config can't be checked.
+func newClient() *network.Client {
+	key, address := preferredNode()
+	if address == nil {
+		key, address = netConfig.RandomServer()
+	}
 	c := network.NewRedialConnection(address, nil)
 	util.Logger.Printf("connecting to %s", address.String())
-	return c
+	if key == "" {
+		util.Logger.Printf("%s is not in the network config, so its answers are not verified",
+			address.String())
+		return network.NewClient(c)
+	}
+	return network.NewVerifiedClient(c, key)
+}
+
+// parseAddress reads an address or public key that the user typed, and
+// returns the public key.
+func parseAddress(s string) string {
+	publicKey, err := netConfig.ParseAddress(s)
+	if err != nil {
+		util.Logger.Fatal(err)
+	}
+	return publicKey
+}
+
+// parseRecipient is like parseAddress, but also accepts deposit addresses.
+func parseRecipient(s string) string {
+	account, err := netConfig.ParseRecipient(s)
+	if err != nil {
+		util.Logger.Fatal(err)
+	}
+	return account
+}
+
+// getAccount fetches an account, giving up after queryTimeout.
+func getAccount(client *network.Client, user string) *currency.Account {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	account, err := client.GetAccount(ctx, user)
+	if err != nil {
+		util.Logger.Fatalf("could not get account data for %s: %s", user, err)
+	}
+	return account
+}
+
+// estimateFee asks a node for the fee an operation needs to get into a block
+// within feeSlots slots.
+func estimateFee(client *network.Client) uint64 {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	fee, err := client.EstimateFee(ctx, feeSlots)
+	if err != nil {
+		util.Logger.Fatalf("could not estimate the fee: %s", err)
+	}
+	return fee
+}
+
+// showFee prints the fee estimates for getting into a block soon.
+func showFee() {
+	client := newClient()
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	for _, slots := range []int{1, feeSlots, 10} {
+		fee, err := client.EstimateFee(ctx, slots)
+		if err != nil {
+			util.Logger.Fatalf("could not estimate the fee: %s", err)
+		}
+		fmt.Printf("within %d slots: %s\n", slots, netConfig.FormatAmount(fee))
+	}
 }
 
 // Fetches, displays, and returns the status for a user.
 func status(user string) *currency.Account {
-	conn := newConnection()
-	account := network.GetAccount(conn, user)
+	client := newClient()
+	account := getAccount(client, user)
 
-	util.Logger.Printf("account data for %s:\n%s", user, spew.Sdump(account))
+	util.Logger.Printf("account data for %s:\n%s",
+		netConfig.FormatAddress(user), spew.Sdump(account))
+	if account != nil {
+		util.Logger.Printf("balance: %s", netConfig.FormatAmount(account.Balance))
+		util.Logger.Printf("documents: %d of %d bytes", account.Storage, account.DocumentQuota())
+		util.Logger.Printf("funded in slot %d, last active in slot %d",
+			account.Created, account.LastActive)
+	}
 	return account
 }
 
+// Fetches and displays the state of an account as of a past slot.
+func statusAtSlot(user string, slotStr string) {
+	slot, err := strconv.Atoi(slotStr)
+	if err != nil || slot < 1 {
+		util.Logger.Fatalf("invalid slot: %s", slotStr)
+	}
+	client := newClient()
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	account, err := client.GetAccountAtSlot(ctx, user, slot)
+	if err != nil {
+		util.Logger.Fatalf("could not get account data for %s: %s", user, err)
+	}
+	util.Logger.Printf("account data for %s as of slot %d:\n%s",
+		netConfig.FormatAddress(user), slot, spew.Sdump(account))
+	if account != nil {
+		util.Logger.Printf("balance: %s", netConfig.FormatAmount(account.Balance))
+	}
+}
+
+// Displays the documents that match a full-text search.
+func search(query string, collection string) {
+	client := newClient()
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	docs, err := client.SearchDocuments(ctx, query, collection, 10)
+	if err != nil {
+		util.Logger.Fatalf("search failed: %s", err)
+	}
+	util.Logger.Printf("%d documents found", len(docs))
+	for _, doc := range docs {
+		fmt.Printf("%s", doc)
+	}
+}
+
+// Displays a table of what one node knows about each of its peers.
+// node is the host:port the node listens on.
+func peers(node string) {
+	host, portStr, err := net.SplitHostPort(node)
+	if err != nil {
+		util.Logger.Fatalf("invalid node address %s: %s", node, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		util.Logger.Fatalf("invalid port: %s", portStr)
+	}
+	client := network.NewClient(network.NewRedialConnection(
+		&network.Address{Host: host, Port: port}, nil))
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	stats, err := client.GetPeerStats(ctx)
+	if err != nil {
+		util.Logger.Fatalf("could not get peer stats from %s: %s", node, err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PEER\tADDRESS\tCONNECTED\tMESSAGES\tBYTES\tINVALID\tREPLAYS\tLAST SEEN\tLATENCY\tBY TYPE")
+	for _, ps := range stats {
+		lastSeen := "never"
+		if !ps.LastSeen.IsZero() {
+			lastSeen = time.Since(ps.LastSeen).Round(time.Millisecond).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%t\t%d\t%d\t%d\t%d\t%s\t%s\t%s\n",
+			util.Shorten(ps.PublicKey), ps.Address, ps.Connected, ps.Total(),
+			ps.Bytes, ps.InvalidSignatures, ps.Replays, lastSeen, ps.Latency,
+			ps.MessageCounts())
+	}
+	w.Flush()
+}
+
 // Asks for a login then displays the status
 func ourStatus() {
 	kp := login()
 	status(kp.PublicKey().String())
 }
 
+// generate writes a new key pair to stdout. Normally it comes from a new
+// mnemonic, which is shown so the user can write it down. With
+// --legacy-phrase, it comes from a passphrase the user types, which has to
+// be strong enough.
 func generate() {
-	kp := login()
+	if !legacyPhrase {
+		mnemonic := util.NewMnemonic()
+		kp, err := util.NewKeyPairFromMnemonic(mnemonic)
+		if err != nil {
+			util.Logger.Fatal(err)
+		}
+		util.Logger.Printf("your mnemonic is:\n\n%s\n\n"+
+			"write it down and keep it secret. anyone who has it can spend "+
+			"from your account, and you need it to log in without the key pair file.",
+			mnemonic)
+		os.Stdout.Write(kp.Serialize())
+		util.Logger.Printf("key pair generation complete. your address is %s",
+			netConfig.FormatAddress(kp.PublicKey().String()))
+		return
+	}
+	util.Logger.Printf("please enter a passphrase for the new key:")
+	stdin.Scan()
+	phrase := stdin.Text()
+	strength := util.CheckPassphrase(phrase)
+	if strength.Weak() {
+		util.Logger.Fatalf("refusing to use a passphrase with about %.0f bits of entropy, "+
+			"since it could be guessed: %s. leave off --legacy-phrase to get a mnemonic",
+			strength.Bits, strings.Join(strength.Problems, ", "))
+	}
+	warnIfWeak(strength)
+	kp := util.NewKeyPairFromSecretPhrase(phrase)
 	os.Stdout.Write(kp.Serialize())
 	util.Logger.Printf("key pair generation complete")
 }
 
+// warnIfWeak warns about a passphrase that isn't strong.
+func warnIfWeak(strength *util.PassphraseStrength) {
+	if strength.Strong() {
+		return
+	}
+	problems := ""
+	if len(strength.Problems) > 0 {
+		problems = ": " + strings.Join(strength.Problems, ", ")
+	}
+	util.Logger.Printf("warning: this passphrase only has about %.0f bits of entropy%s. "+
+		"anyone who guesses it can spend from the account", strength.Bits, problems)
+}
+
 func validate(filename string) {
 	kp, err := util.ReadKeyPairFromFile(filename)
 	if err != nil {
@@ -52,36 +282,80 @@ func validate(filename string) {
 	util.Logger.Printf("key pair for %s is valid", kp.PublicKey().String())
 }
 
-// Ask the user for a passphrase to log in.
+// stdin reads lines the user types
+var stdin = bufio.NewScanner(os.Stdin)
+
+// Log in with the key pair file from the config, or ask for a passphrase if
+// there isn't one.
 func login() *util.KeyPair {
-	util.Logger.Printf("please enter your passphrase:")
-	scanner := bufio.NewScanner(os.Stdin)
-	scanner.Scan()
-	phrase := scanner.Text()
-	kp := util.NewKeyPairFromSecretPhrase(phrase)
-	util.Logger.Printf("hello. your name is %s", kp.PublicKey().String())
+	if settings.Key == "" {
+		return askPassphrase()
+	}
+	kp, err := util.ReadKeyPairFromFile(settings.Key)
+	if err != nil {
+		util.Logger.Fatal(err)
+	}
+	util.Logger.Printf("hello. your address is %s",
+		netConfig.FormatAddress(kp.PublicKey().String()))
 	return kp
 }
 
-func send(recipient string, amountStr string) {
-	amountInt, err := strconv.Atoi(amountStr)
+// Ask the user for their mnemonic to log in, or with --legacy-phrase, for
+// any passphrase.
+func askPassphrase() *util.KeyPair {
+	if legacyPhrase {
+		util.Logger.Printf("please enter your passphrase:")
+	} else {
+		util.Logger.Printf("please enter your %d-word mnemonic:", util.MnemonicWords)
+	}
+	stdin.Scan()
+	kp, err := keyPairFromSecret(stdin.Text())
 	if err != nil {
-		util.Logger.Fatalf("could not convert %s to a number", amountStr)
+		util.Logger.Fatal(err)
+	}
+	util.Logger.Printf("hello. your address is %s",
+		netConfig.FormatAddress(kp.PublicKey().String()))
+	return kp
+}
+
+// keyPairFromSecret logs in with a mnemonic, or with --legacy-phrase, a
+// passphrase. A weak passphrase gets a warning, but it still works, since it
+// may already have money in it.
+func keyPairFromSecret(secret string) (*util.KeyPair, error) {
+	if util.IsMnemonic(secret) {
+		return util.NewKeyPairFromMnemonic(secret)
 	}
-	if _, err := util.ReadPublicKey(recipient); err != nil {
-		util.Logger.Fatalf("invalid address: %s", recipient)
+	if !legacyPhrase {
+		if len(strings.Fields(secret)) > 1 {
+			// Probably a mnemonic with a typo, so explain what's wrong
+			if _, err := util.NewKeyPairFromMnemonic(secret); err != nil {
+				return nil, fmt.Errorf("%s. to log in with a passphrase, use --legacy-phrase", err)
+			}
+		}
+		return nil, errors.New("that is not a mnemonic. to log in with a passphrase, " +
+			"use --legacy-phrase")
 	}
-	amount := uint64(amountInt)
+	warnIfWeak(util.CheckPassphrase(secret))
+	return util.NewKeyPairFromSecretPhrase(secret), nil
+}
+
+func send(recipient string, amountStr string) {
+	amount, err := netConfig.ParseAmount(amountStr)
+	if err != nil {
+		util.Logger.Fatal(err)
+	}
+	recipient = parseRecipient(recipient)
 	kp := login()
 	user := kp.PublicKey().String()
-	conn := newConnection()
-	account := network.GetAccount(conn, user)
+	client := newClient()
+	account := getAccount(client, user)
 
 	util.Logger.Printf("account data for %s:\n%s", user, spew.Sdump(account))
 
-	if account.Balance < amount {
-		util.Logger.Fatalf("cannot send %d when our account only has %d",
-			amount, account.Balance)
+	fee := estimateFee(client)
+	if cost, ok := currency.AddAmounts(amount, fee); !ok || account.Balance < cost {
+		util.Logger.Fatalf("cannot send %s plus a fee of %s when our account only has %s",
+			amountStr, netConfig.FormatAmount(fee), netConfig.FormatAmount(account.Balance))
 	}
 
 	seq := account.Sequence + 1
@@ -90,21 +364,128 @@ func send(recipient string, amountStr string) {
 		Sequence: seq,
 		To:       recipient,
 		Amount:   amount,
-		Fee:      0,
+		Fee:      fee,
 	}
 
 	// Send our operation to the network
 	sop := util.NewSignedOperation(op, kp)
 	tm := currency.NewTransactionMessage(sop)
 	sm := util.NewSignedMessage(tm, kp)
-	conn.Send(sm)
-	util.Logger.Printf("sending %d to %s", amount, recipient)
+	client.Send(sm)
+	util.Logger.Printf("sending %s to %s with a fee of %s", amountStr,
+		netConfig.FormatAddress(recipient), netConfig.FormatAmount(fee))
 
 	// Wait for our send operation to clear
-	network.WaitToClear(conn, user, seq)
+	ctx, cancel := context.WithTimeout(context.Background(), clearTimeout)
+	defer cancel()
+	_, err = client.WaitToClear(ctx, user, seq)
+	if err != nil {
+		util.Logger.Fatalf("op %d did not clear: %s", op.GetSequence(), err)
+	}
 	util.Logger.Printf("op %d cleared", op.GetSequence())
 }
 
+// voteValidator signs a vote to add or remove a validator, using a
+// validator's key pair file, and waits for the vote to clear.
+func voteValidator(action string, validator string, thresholdStr string,
+	activationStr string, keyPairFilename string) {
+	threshold, err := strconv.Atoi(thresholdStr)
+	if err != nil {
+		util.Logger.Fatalf("invalid threshold: %s", thresholdStr)
+	}
+	activation, err := strconv.Atoi(activationStr)
+	if err != nil {
+		util.Logger.Fatalf("invalid activation slot: %s", activationStr)
+	}
+	kp, err := util.ReadKeyPairFromFile(keyPairFilename)
+	if err != nil {
+		util.Logger.Fatal(err)
+	}
+	user := kp.PublicKey().String()
+	client := newClient()
+	seq := uint32(1)
+	if account := getAccount(client, user); account != nil {
+		seq = account.Sequence + 1
+	}
+	op := &currency.ValidatorOperation{
+		Signer:     user,
+		Sequence:   seq,
+		Action:     action,
+		Validator:  validator,
+		Threshold:  threshold,
+		Activation: activation,
+	}
+	if !op.Verify() {
+		util.Logger.Fatalf("invalid validator operation: %s", op)
+	}
+
+	sop := util.NewSignedOperation(op, kp)
+	client.Send(util.NewSignedMessage(currency.NewTransactionMessage(sop), kp))
+	util.Logger.Printf("voting to %s", op)
+
+	ctx, cancel := context.WithTimeout(context.Background(), clearTimeout)
+	defer cancel()
+	if _, err := client.WaitToClear(ctx, user, seq); err != nil {
+		util.Logger.Fatalf("op %d did not clear: %s", seq, err)
+	}
+	util.Logger.Printf("op %d cleared", seq)
+}
+
+// depositAddress displays the deposit address that credits an account with a
+// tag.
+func depositAddress(address string, tagStr string) {
+	tag, err := strconv.ParseUint(tagStr, 10, 64)
+	if err != nil {
+		util.Logger.Fatalf("invalid tag: %s", tagStr)
+	}
+	pk, err := util.ReadPublicKey(parseAddress(address))
+	if err != nil {
+		util.Logger.Fatal(err)
+	}
+	fmt.Println(netConfig.FormatAddress(util.DepositAccount(pk, tag)))
+}
+
+// setAccountData sets metadata on our account from key=value pairs. An empty
+// value removes the key.
+func setAccountData(pairs []string) {
+	data := make(map[string]string)
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			util.Logger.Fatalf("expected key=value but got: %s", pair)
+		}
+		data[parts[0]] = parts[1]
+	}
+	kp := login()
+	user := kp.PublicKey().String()
+	client := newClient()
+	account := getAccount(client, user)
+	if account == nil {
+		util.Logger.Fatalf("%s has no account", netConfig.FormatAddress(user))
+	}
+	seq := account.Sequence + 1
+	op := &currency.SetAccountDataOperation{
+		Signer:   user,
+		Sequence: seq,
+		Fee:      estimateFee(client),
+		Data:     data,
+	}
+	if !op.Verify() {
+		util.Logger.Fatalf("invalid account data: %s", op)
+	}
+
+	sop := util.NewSignedOperation(op, kp)
+	client.Send(util.NewSignedMessage(currency.NewTransactionMessage(sop), kp))
+	util.Logger.Printf("%s", op)
+
+	ctx, cancel := context.WithTimeout(context.Background(), clearTimeout)
+	defer cancel()
+	if _, err := client.WaitToClear(ctx, user, seq); err != nil {
+		util.Logger.Fatalf("op %d did not clear: %s", seq, err)
+	}
+	util.Logger.Printf("op %d cleared", seq)
+}
+
 func handler(w http.ResponseWriter, r *http.Request) {
 	pass := strings.TrimLeft(r.URL.Path, "/")
 	kp := util.NewKeyPairFromSecretPhrase(pass)
@@ -118,22 +499,62 @@ func handler(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
-	if len(os.Args) < 2 {
-		util.Logger.Fatal("Usage: cclient {generate,proxy,send,status} ...")
+	networkName, args := parseFlags(os.Args[1:])
+	if len(args) < 1 {
+		util.Logger.Fatal(
+			"Usage: cclient [--network name] [--legacy-phrase] {await,config,deposit-address,fee,generate,get-blob,inbox,multisend,peers,portfolio,proxy,put-blob,search,send,send-message,set-data,sign-message,status,validate,validator,verify-message} ...")
 	}
-	op := os.Args[1]
-	rest := os.Args[2:]
+	op := args[0]
+	rest := args[1:]
+	loadSettings(networkName)
 	switch op {
 
+	case "config":
+		configCommand(rest)
+
 	case "status":
-		if len(rest) > 1 {
-			util.Logger.Fatal("Usage: cclient status [publickey]")
+		if len(rest) > 2 {
+			util.Logger.Fatal("Usage: cclient status [publickey] [slot]")
 		}
-		if len(rest) == 0 {
+		switch len(rest) {
+		case 0:
 			ourStatus()
-		} else {
-			status(rest[0])
+		case 1:
+			status(parseAddress(rest[0]))
+		case 2:
+			statusAtSlot(parseAddress(rest[0]), rest[1])
+		}
+
+	case "portfolio":
+		if len(rest) != 0 {
+			util.Logger.Fatal("Usage: cclient portfolio")
+		}
+		portfolio()
+
+	case "peers":
+		if len(rest) != 1 {
+			util.Logger.Fatal("Usage: cclient peers <host:port>")
+		}
+		peers(rest[0])
+
+	case "search":
+		if len(rest) < 1 || len(rest) > 2 {
+			util.Logger.Fatal("Usage: cclient search <query> [collection]")
+		}
+		collection := ""
+		if len(rest) == 2 {
+			collection = rest[1]
+		}
+		search(rest[0], collection)
+
+	case "fee":
+		if len(rest) != 0 {
+			util.Logger.Fatal("Usage: cclient fee")
 		}
+		showFee()
+
+	case "await":
+		await(rest)
 
 	case "send":
 		if len(rest) != 2 {
@@ -141,6 +562,60 @@ func main() {
 		}
 		send(rest[0], rest[1])
 
+	case "multisend":
+		if len(rest) != 1 {
+			util.Logger.Fatal("Usage: cclient multisend <payments.json>")
+		}
+		multisend(rest[0])
+
+	case "send-message":
+		if len(rest) != 2 {
+			util.Logger.Fatal("Usage: cclient send-message <user> <text>")
+		}
+		sendMessage(rest[0], rest[1])
+
+	case "inbox":
+		if len(rest) != 0 {
+			util.Logger.Fatal("Usage: cclient inbox")
+		}
+		inbox()
+
+	case "put-blob":
+		if len(rest) != 1 {
+			util.Logger.Fatal("Usage: cclient put-blob <file>")
+		}
+		putBlob(rest[0])
+
+	case "get-blob":
+		if len(rest) != 2 {
+			util.Logger.Fatal("Usage: cclient get-blob <hash> <file>")
+		}
+		getBlob(rest[0], rest[1])
+
+	case "deposit-address":
+		if len(rest) != 2 {
+			util.Logger.Fatal("Usage: cclient deposit-address <user> <tag>")
+		}
+		depositAddress(rest[0], rest[1])
+
+	case "set-data":
+		if len(rest) == 0 {
+			util.Logger.Fatal("Usage: cclient set-data <key=value> ...")
+		}
+		setAccountData(rest)
+
+	case "sign-message":
+		if len(rest) != 1 {
+			util.Logger.Fatal("Usage: cclient sign-message <text>")
+		}
+		signMessage(rest[0])
+
+	case "verify-message":
+		if len(rest) != 1 {
+			util.Logger.Fatal("Usage: cclient verify-message <signed.json>")
+		}
+		verifyMessage(rest[0])
+
 	case "generate":
 		if len(rest) != 0 {
 			util.Logger.Fatal("Usage: cclient generate")
@@ -153,6 +628,14 @@ func main() {
 		}
 		validate(rest[0])
 
+	case "validator":
+		if len(rest) != 5 || (rest[0] != currency.ValidatorAdd &&
+			rest[0] != currency.ValidatorRemove) {
+			util.Logger.Fatal("Usage: cclient validator {add,remove} <publickey> " +
+				"<threshold> <activation slot> <path/to/keypair.json>")
+		}
+		voteValidator(rest[0], parseAddress(rest[1]), rest[2], rest[3], rest[4])
+
 	default:
 		util.Logger.Fatalf("unrecognized operation: %s", op)
 	}
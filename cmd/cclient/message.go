@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/lacker/coinkit/util"
+)
+
+// signMessage signs some text, like a login challenge from a service, and
+// prints the signed text as JSON.
+func signMessage(text string) {
+	kp := login()
+	st := util.SignText(text, kp)
+	st.Signer = netConfig.FormatAddress(st.Signer)
+	bytes, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		util.Logger.Fatal(err)
+	}
+	fmt.Printf("%s\n", bytes)
+}
+
+// verifyMessage checks a signed text that signMessage printed, and displays
+// who signed it.
+func verifyMessage(filename string) {
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		util.Logger.Fatal(err)
+	}
+	st := &util.SignedText{}
+	if err := json.Unmarshal(raw, st); err != nil {
+		util.Logger.Fatalf("bad signed message: %s", err)
+	}
+	signer := parseAddress(st.Signer)
+	if _, err := st.Verify(); err != nil {
+		util.Logger.Fatalf("invalid signed message: %s", err)
+	}
+	util.Logger.Printf("%s signed: %s", netConfig.FormatAddress(signer), st.Text)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/util"
+)
+
+// A payment is one entry in a multisend manifest. The amount and fee are
+// written with the network's decimals, like 1.25. Payments without a fee get
+// the fee that a node estimates.
+// The memo is just for the sender's records. It isn't sent to the network.
+type payment struct {
+	To     string      `json:"to"`
+	Amount json.Number `json:"amount"`
+	Memo   string      `json:"memo"`
+	Fee    json.Number `json:"fee"`
+
+	// The public key, amount, and fee, once they are parsed
+	to     string
+	amount uint64
+	fee    uint64
+
+	// Whether the manifest set the fee
+	hasFee bool
+}
+
+// readManifest reads a JSON list of payments and checks each one.
+func readManifest(filename string) ([]*payment, error) {
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	payments := []*payment{}
+	if err := decoder.Decode(&payments); err != nil {
+		return nil, fmt.Errorf("bad manifest: %s", err)
+	}
+	if len(payments) == 0 {
+		return nil, fmt.Errorf("the manifest has no payments")
+	}
+	for i, p := range payments {
+		p.to, err = netConfig.ParseRecipient(p.To)
+		if err != nil {
+			return nil, fmt.Errorf("payment %d: %s", i+1, err)
+		}
+		p.amount, err = netConfig.ParseAmount(p.Amount.String())
+		if err != nil {
+			return nil, fmt.Errorf("payment %d: %s", i+1, err)
+		}
+		if p.amount == 0 {
+			return nil, fmt.Errorf("payment %d: the amount must be positive", i+1)
+		}
+		if p.Fee != "" {
+			p.hasFee = true
+			p.fee, err = netConfig.ParseAmount(p.Fee.String())
+			if err != nil {
+				return nil, fmt.Errorf("payment %d: bad fee: %s", i+1, err)
+			}
+		}
+	}
+	return payments, nil
+}
+
+// confirm asks the user a yes or no question.
+func confirm(question string) bool {
+	util.Logger.Printf("%s [y/N]", question)
+	stdin.Scan()
+	answer := strings.ToLower(strings.TrimSpace(stdin.Text()))
+	return answer == "y" || answer == "yes"
+}
+
+// multisend sends every payment in a manifest, one at a time.
+// Only the next sequence number can be in the queue, so each payment has to
+// clear before the next one is sent.
+func multisend(filename string) {
+	payments, err := readManifest(filename)
+	if err != nil {
+		util.Logger.Fatal(err)
+	}
+	kp := login()
+	user := kp.PublicKey().String()
+	client := newClient()
+	account := getAccount(client, user)
+	estimate := estimateFee(client)
+
+	var total, fees uint64
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tTO\tAMOUNT\tFEE\tMEMO")
+	for i, p := range payments {
+		if !p.hasFee {
+			p.fee = estimate
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", i+1, netConfig.FormatAddress(p.to),
+			netConfig.FormatAmount(p.amount), netConfig.FormatAmount(p.fee), p.Memo)
+		var ok bool
+		total, ok = currency.AddAmounts(total, p.amount)
+		if ok {
+			fees, ok = currency.AddAmounts(fees, p.fee)
+		}
+		if !ok {
+			util.Logger.Fatal("the payments add up to more than any account can hold")
+		}
+	}
+	w.Flush()
+	fmt.Printf("%d payments, %s in total plus %s in fees\n", len(payments),
+		netConfig.FormatAmount(total), netConfig.FormatAmount(fees))
+
+	cost, ok := currency.AddAmounts(total, fees)
+	if !ok {
+		util.Logger.Fatal("the payments add up to more than any account can hold")
+	}
+	if account == nil || account.Balance < cost {
+		balance := uint64(0)
+		if account != nil {
+			balance = account.Balance
+		}
+		util.Logger.Fatalf("cannot send %s when our account only has %s",
+			netConfig.FormatAmount(cost), netConfig.FormatAmount(balance))
+	}
+	if !confirm("send these payments?") {
+		util.Logger.Fatal("nothing was sent")
+	}
+
+	seq := account.Sequence
+	for i, p := range payments {
+		seq++
+		op := &currency.SendOperation{
+			Signer:   user,
+			Sequence: seq,
+			To:       p.to,
+			Amount:   p.amount,
+			Fee:      p.fee,
+		}
+		sop := util.NewSignedOperation(op, kp)
+		client.Send(util.NewSignedMessage(currency.NewTransactionMessage(sop), kp))
+		fmt.Printf("[%d/%d] sending %s to %s", i+1, len(payments),
+			netConfig.FormatAmount(p.amount), netConfig.FormatAddress(p.to))
+		if p.Memo != "" {
+			fmt.Printf(" (%s)", p.Memo)
+		}
+		fmt.Print("...")
+
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), clearTimeout)
+		_, err := client.WaitToClear(ctx, user, seq)
+		cancel()
+		if err != nil {
+			fmt.Println()
+			util.Logger.Fatalf("payment %d did not clear, so the rest were not sent: %s",
+				i+1, err)
+		}
+		fmt.Printf(" cleared in %s\n", time.Since(start).Round(time.Millisecond))
+	}
+	util.Logger.Printf("all %d payments cleared", len(payments))
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/util"
+)
+
+// A holding is one account in the portfolio, and where it came from.
+type holding struct {
+	// The key pair file for the account, or "watch" for a watch-only address
+	source string
+
+	owner string
+}
+
+// keysDir is the keystore, where cclient portfolio looks for key pair files.
+func keysDir() (string, error) {
+	dir, err := homeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "keys"), nil
+}
+
+// holdings lists the accounts in the portfolio: the key setting, every key
+// pair file in the keystore, and the watch setting. An account only shows up
+// once, from the first place it is found.
+func holdings() []*holding {
+	answer := []*holding{}
+	seen := make(map[string]bool)
+	add := func(source string, owner string) {
+		if !seen[owner] {
+			seen[owner] = true
+			answer = append(answer, &holding{source: source, owner: owner})
+		}
+	}
+	readKey := func(filename string) {
+		kp, err := util.ReadKeyPairFromFile(filename)
+		if err != nil {
+			util.Logger.Printf("skipping %s: %s", filename, err)
+			return
+		}
+		add(filepath.Base(filename), kp.PublicKey().String())
+	}
+
+	if settings.Key != "" {
+		readKey(settings.Key)
+	}
+	dir, err := keysDir()
+	if err != nil {
+		util.Logger.Fatal(err)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		util.Logger.Fatal(err)
+	}
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".json") {
+			readKey(filepath.Join(dir, file.Name()))
+		}
+	}
+	for _, address := range settings.Watch {
+		add("watch", parseAddress(address))
+	}
+	return answer
+}
+
+// portfolio prints the balance, recent activity, and pending operations of
+// every account in the portfolio, and their totals. The accounts are all
+// fetched together.
+func portfolio() {
+	list := holdings()
+	if len(list) == 0 {
+		dir, _ := keysDir()
+		util.Logger.Fatalf("no accounts. put key pair files in %s, or "+
+			"cclient config set watch <address>,...", dir)
+	}
+	owners := []string{}
+	for _, h := range list {
+		owners = append(owners, h.owner)
+	}
+	client := newClient()
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	reports, err := client.GetAccountReports(ctx, owners)
+	if err != nil {
+		util.Logger.Fatalf("could not get account data: %s", err)
+	}
+
+	// Biggest balances first
+	balance := func(h *holding) uint64 {
+		if account := reports[h.owner].Account; account != nil {
+			return account.Balance
+		}
+		return 0
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return balance(list[i]) > balance(list[j])
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SOURCE\tADDRESS\tBALANCE\tSEQUENCE\tRECENT\tLAST ACTIVE\tPENDING")
+	var total uint64
+	recent, pending := 0, 0
+	for _, h := range list {
+		report := reports[h.owner]
+		sequence, lastActive, changes := "-", "-", "-"
+		if report.Account != nil {
+			sequence = fmt.Sprintf("%d", report.Account.Sequence)
+			if report.Account.LastActive != 0 {
+				lastActive = fmt.Sprintf("slot %d", report.Account.LastActive)
+			}
+		}
+		if report.Activity != nil {
+			changes = fmt.Sprintf("%d", report.Activity.Recent)
+			recent += report.Activity.Recent
+		}
+		total += balance(h)
+		pending += len(report.Pending)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\n",
+			h.source, netConfig.FormatAddress(h.owner), netConfig.FormatAmount(balance(h)),
+			sequence, changes, lastActive, len(report.Pending))
+	}
+	fmt.Fprintf(w, "TOTAL\t%d accounts\t%s\t\t%d\t\t%d\n",
+		len(list), netConfig.FormatAmount(total), recent, pending)
+	w.Flush()
+	util.Logger.Printf("recent is how many of the last %d blocks changed each account",
+		currency.RecentActivitySlots)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lacker/coinkit/config"
+	"github.com/lacker/coinkit/consensus"
+	"github.com/lacker/coinkit/network"
+	"github.com/lacker/coinkit/util"
+)
+
+// analyzeQuorum checks whether a network's quorum slices are safe. The
+// slices come either from the config files of every validator, or with
+// --live, from what each validator in a network config last externalized.
+// It reports two quorums that don't intersect, the smallest sets of nodes
+// that could fork the network by lying, and the smallest sets of nodes that
+// could halt it by going down. It exits with status 1 if the network can
+// fork without anyone lying.
+func analyzeQuorum(args []string) {
+	flags := flag.NewFlagSet("analyze-quorum", flag.ExitOnError)
+	live := flags.Bool("live", false, "fetch each validator's slice from the network")
+	networkFilename := flags.String("network", "",
+		"with --live, the file to load the network config from")
+	timeout := flags.Duration("timeout", 10*time.Second,
+		"with --live, how long to wait for each validator")
+	flags.Parse(args)
+
+	var top consensus.Topology
+	var err error
+	switch {
+	case *live && *networkFilename != "" && flags.NArg() == 0:
+		top, err = fetchTopology(*networkFilename, *timeout)
+	case !*live && *networkFilename == "" && flags.NArg() > 0:
+		top, err = loadTopology(flags.Args())
+	default:
+		util.Logger.Fatal("usage: cserver analyze-quorum <config file>... or " +
+			"cserver analyze-quorum --live --network <file>")
+	}
+	if err != nil {
+		util.Logger.Fatal(err)
+	}
+
+	for _, node := range top.Nodes() {
+		qs := top[node]
+		fmt.Printf("%s trusts %d of %d nodes\n", node, qs.Threshold, len(qs.Members))
+		for _, member := range qs.Members {
+			if _, ok := top[member]; !ok {
+				fmt.Printf("  %s is not in the analysis, so it is never in a quorum\n", member)
+			}
+		}
+	}
+
+	a, b, err := top.DisjointQuorums()
+	if err != nil {
+		util.Logger.Fatal(err)
+	}
+	if a != nil {
+		fmt.Printf("\nthe network can fork. these quorums don't intersect:\n%s\n%s\n",
+			strings.Join(a, " "), strings.Join(b, " "))
+	} else {
+		fmt.Printf("\nevery two quorums intersect\n")
+	}
+
+	splitting, err := top.MinimalSplittingSets()
+	if err != nil {
+		fmt.Printf("\nskipping splitting sets: %s\n", err)
+	} else {
+		printSets("splitting", "fork the network by lying", splitting)
+	}
+	blocking, err := top.MinimalBlockingSets()
+	if err != nil {
+		util.Logger.Fatal(err)
+	}
+	printSets("blocking", "halt the network by going down", blocking)
+
+	if a != nil {
+		os.Exit(1)
+	}
+}
+
+// printSets prints the smallest sets of some kind, and what each of them can
+// do to the network.
+func printSets(kind string, effect string, sets [][]string) {
+	if len(sets) == 0 {
+		fmt.Printf("\nthere are no %s sets\n", kind)
+		return
+	}
+	fmt.Printf("\nthe smallest %s sets have %d nodes. each of these could %s:\n",
+		kind, len(sets[0]), effect)
+	for _, set := range sets {
+		if len(set) == 0 {
+			fmt.Printf("(no nodes)\n")
+			continue
+		}
+		fmt.Printf("%s\n", strings.Join(set, " "))
+	}
+}
+
+// loadTopology reads the quorum slice of each validator from its config
+// file.
+func loadTopology(filenames []string) (consensus.Topology, error) {
+	top := consensus.Topology{}
+	for _, filename := range filenames {
+		c, err := config.Load(filename)
+		if err != nil {
+			return nil, err
+		}
+		key := c.KeyPair().PublicKey().String()
+		if _, ok := top[key]; ok {
+			return nil, fmt.Errorf("%s has the same key as another config", filename)
+		}
+		top[key] = c.NetworkConfig().QuorumSlice()
+	}
+	return top, nil
+}
+
+// fetchTopology asks each validator in the network config for the quorum
+// slice it last externalized with, which may have changed since the config
+// was written.
+func fetchTopology(filename string, timeout time.Duration) (consensus.Topology, error) {
+	bytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	net := network.NewConfigFromSerialized(bytes)
+	top := consensus.Topology{}
+	for _, key := range net.QuorumSlice().Members {
+		qs, err := fetchQuorumSlice(key, net.Servers[key], timeout)
+		if err != nil {
+			return nil, fmt.Errorf("could not get the slice of %s: %s", key, err)
+		}
+		top[key] = qs
+	}
+	return top, nil
+}
+
+func fetchQuorumSlice(key string, address *network.Address,
+	timeout time.Duration) (consensus.QuorumSlice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	client := network.NewVerifiedClient(network.NewRedialConnection(address, nil), key)
+	defer client.Close()
+
+	head, err := client.GetHead(ctx, 0)
+	if err != nil {
+		return consensus.QuorumSlice{}, err
+	}
+	if head.Head < 1 {
+		return consensus.QuorumSlice{}, fmt.Errorf("%s has not externalized anything", address)
+	}
+	history, err := client.GetHistory(ctx, head.Head)
+	if err != nil {
+		return consensus.QuorumSlice{}, err
+	}
+	return history.E.QuorumSlice(), nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/lacker/coinkit/data"
+	"github.com/lacker/coinkit/network"
+	"github.com/lacker/coinkit/util"
+)
+
+// archive runs an archive server, which answers queries about history straight
+// from a database without joining consensus. Run as many as you like against
+// the same database, or its read replicas.
+func archive(args []string) {
+	flags := flag.NewFlagSet("archive", flag.ExitOnError)
+	databaseFilename := flags.String("database", "",
+		"the file to load database config from. defaults to the prod database")
+	port := flags.Int("port", 9500, "the port to serve queries on")
+	httpPort := flags.Int("http", 0, "the port to serve /healthz, /graphql etc on")
+	logToStdOut := flags.Bool("logtostdout", false, "whether to log to stdout")
+	flags.Parse(args)
+
+	if *logToStdOut {
+		util.Logger = log.New(os.Stdout, "", log.LstdFlags)
+	}
+
+	var dbConfig *data.Config
+	if *databaseFilename != "" {
+		bytes, err := ioutil.ReadFile(*databaseFilename)
+		if err != nil {
+			util.Logger.Fatal(err)
+		}
+		dbConfig = data.NewConfigFromSerialized(bytes)
+	} else {
+		dbConfig = data.NewProdConfig()
+	}
+	if dbConfig == nil {
+		util.Logger.Fatal("an archive server needs a database. use the --database flag")
+	}
+	dbConfig.ReadOnly = true
+
+	a := network.NewArchiveServer(*port, data.NewDatabase(dbConfig))
+	if *httpPort != 0 {
+		a.ServeHttpInBackground(*httpPort)
+	}
+	a.ServeForever()
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/lacker/coinkit/config"
+	"github.com/lacker/coinkit/data"
+	"github.com/lacker/coinkit/network"
+	"github.com/lacker/coinkit/util"
+)
+
+// devnet runs a local network of cservers, one process per node, with all of
+// their logs combined on stdout. It returns once every node has exited.
+// Each node is a separate process because a Server assumes it has the process
+// to itself, for things like the http handlers.
+//
+// With the default flags the devnet is the same network that cclient talks to
+// when it has no --network flag, so cclient works against it out of the box.
+// All the money starts in the account for the secret phrase "mint".
+func devnet(args []string) {
+	flags := flag.NewFlagSet("devnet", flag.ExitOnError)
+	nodes := flags.Int("nodes", 4, "how many nodes to run")
+	port := flags.Int("port", 9000, "the port for the first node. nodes use consecutive ports")
+	httpPort := flags.Int("http", 8000,
+		"the http port for the first node. nodes use consecutive ports. 0 to not serve http")
+	useDatabase := flags.Bool("database", false,
+		"give each node its own database, devnet0 and so on. "+
+			"they are created if they don't exist, and cleared on startup")
+	seed := flags.Int("seed", 0, "the seed to generate node keys from")
+	dir := flags.String("dir", "",
+		"where to write node config. by default a temporary directory is used")
+	flags.Parse(args)
+
+	if *nodes < 1 {
+		util.Logger.Fatal("--nodes must be positive")
+	}
+
+	configDir := *dir
+	if configDir == "" {
+		tmp, err := ioutil.TempDir("", "devnet")
+		if err != nil {
+			util.Logger.Fatal(err)
+		}
+		defer os.RemoveAll(tmp)
+		configDir = tmp
+	} else if err := os.MkdirAll(configDir, 0755); err != nil {
+		util.Logger.Fatal(err)
+	}
+
+	net, kps := network.NewLocalhostNetwork(*port, *nodes, *seed)
+
+	// With the default flags this is the local network that clients know
+	if local, _ := network.LookupNetwork(network.Local); net.NetworkID() == local.ID {
+		net.Name = network.Local
+	}
+	filenames := []string{}
+	for i := range kps {
+		var dbConfig *data.Config
+		if *useDatabase {
+			dbConfig = data.NewDevnetConfig(i)
+		}
+		nodeHTTPPort := 0
+		if *httpPort != 0 {
+			nodeHTTPPort = *httpPort + i
+		}
+		filename, err := writeNodeConfig(configDir, i, kps, net, dbConfig, nodeHTTPPort)
+		if err != nil {
+			util.Logger.Fatal(err)
+		}
+		filenames = append(filenames, filename)
+	}
+
+	// Each node starts from an empty chain
+	if *useDatabase {
+		for i := range kps {
+			data.CreateDatabase(data.NewDevnetConfig(i))
+			data.DropData(data.NewDevnetConfig(i))
+		}
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		util.Logger.Fatal(err)
+	}
+
+	// Each node's output goes through a pipe into the combined log. Wait only
+	// returns once the node's output has all been copied into the pipe.
+	logs := &combinedLog{out: os.Stdout}
+	exited := make(chan error, len(filenames))
+	commands := []*exec.Cmd{}
+	for i, filename := range filenames {
+		cmd := exec.Command(self, "--config="+filename, "--logtostdout")
+		reader, writer := io.Pipe()
+		cmd.Stdout = writer
+		cmd.Stderr = writer
+		if err := cmd.Start(); err != nil {
+			util.Logger.Fatal(err)
+		}
+		go logs.copy(fmt.Sprintf("node%d", i), reader)
+		go func() {
+			err := cmd.Wait()
+			writer.Close()
+			exited <- err
+		}()
+		commands = append(commands, cmd)
+	}
+
+	util.Logger.Printf("started %d nodes on ports %d-%d, with config in %s",
+		*nodes, *port, *port+*nodes-1, configDir)
+	if *httpPort != 0 {
+		util.Logger.Printf("node0 serves http on http://localhost:%d/graphql", *httpPort)
+	}
+	util.Logger.Printf("the mint account is %s",
+		util.NewKeyPairFromSecretPhrase("mint").PublicKey())
+
+	// When one node dies or we get interrupted, stop the whole devnet
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	running := len(commands)
+	select {
+	case sig := <-signals:
+		util.Logger.Printf("got %s, stopping the devnet", sig)
+	case err := <-exited:
+		util.Logger.Printf("a node exited (%v), stopping the devnet", err)
+		running--
+	}
+	for _, cmd := range commands {
+		cmd.Process.Signal(syscall.SIGTERM)
+	}
+	for ; running > 0; running-- {
+		<-exited
+	}
+}
+
+// writeNodeConfig writes the key pair and config files for node i of a devnet.
+// It returns the config filename.
+func writeNodeConfig(dir string, i int, kps []*util.KeyPair, net *network.Config,
+	dbConfig *data.Config, httpPort int) (string, error) {
+
+	kp := kps[i]
+	keyPairFilename := fmt.Sprintf("keypair%d.json", i)
+	err := ioutil.WriteFile(filepath.Join(dir, keyPairFilename), kp.Serialize(), 0600)
+	if err != nil {
+		return "", err
+	}
+
+	lines := []string{
+		fmt.Sprintf("# Configuration for node %d of a devnet. See cserver devnet.", i),
+		"",
+		fmt.Sprintf("keypair = %q", keyPairFilename),
+		"",
+		"[network]",
+	}
+	if net.Name != "" {
+		lines = append(lines, fmt.Sprintf("name = %q", net.Name))
+	}
+	lines = append(lines, fmt.Sprintf("threshold = %d", net.Threshold))
+	for _, server := range kps {
+		key := server.PublicKey().String()
+		address := net.Servers[key]
+		lines = append(lines,
+			"",
+			"[[network.servers]]",
+			fmt.Sprintf("publicKey = %q", key),
+			fmt.Sprintf("host = %q", address.Host),
+			fmt.Sprintf("port = %d", address.Port))
+	}
+	if dbConfig != nil {
+		lines = append(lines,
+			"",
+			"[database]",
+			fmt.Sprintf("database = %q", dbConfig.Database),
+			fmt.Sprintf("user = %q", dbConfig.User),
+			fmt.Sprintf("host = %q", dbConfig.Host),
+			fmt.Sprintf("port = %d", dbConfig.Port))
+	}
+	if httpPort != 0 {
+		lines = append(lines,
+			"",
+			"[api]",
+			fmt.Sprintf("httpPort = %d", httpPort))
+	}
+
+	filename := filepath.Join(dir, fmt.Sprintf("node%d.toml", i))
+	source := strings.Join(lines, "\n") + "\n"
+	if err := ioutil.WriteFile(filename, []byte(source), 0644); err != nil {
+		return "", err
+	}
+
+	// Catch any problem now, rather than in the node process
+	if _, err := config.Load(filename); err != nil {
+		return "", err
+	}
+	return filename, nil
+}
+
+// A combinedLog interleaves the output of several nodes, line by line,
+// labeling each line with the node it came from.
+type combinedLog struct {
+	out   io.Writer
+	mutex sync.Mutex
+}
+
+func (c *combinedLog) copy(label string, r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		c.mutex.Lock()
+		fmt.Fprintf(c.out, "%-6s | %s\n", label, scanner.Text())
+		c.mutex.Unlock()
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/data"
+	"github.com/lacker/coinkit/util"
+)
+
+// journal writes the money movements in a range of blocks to stdout as
+// double-entry journal entries, for importing into accounting systems.
+func journal(args []string) {
+	flags := flag.NewFlagSet("journal", flag.ExitOnError)
+	databaseFilename := flags.String("database", "",
+		"the file to load database config from. defaults to the prod database")
+	format := flags.String("format", data.JournalCSV, "csv or ledger")
+	from := flags.Int("from", 0, "the first slot to export. defaults to the first block")
+	to := flags.Int("to", 0, "the last slot to export. defaults to the last block")
+	decimals := flags.Int("decimals", currency.Decimals, "how many decimal places to write amounts with")
+	commodity := flags.String("commodity", "COIN", "the commodity for ledger amounts")
+	date := flags.String("date", "", "the YYYY-MM-DD date for ledger transactions. defaults to today")
+	flags.Parse(args)
+
+	if flags.NArg() != 0 {
+		util.Logger.Fatal("usage: cserver journal [--format csv|ledger] [--from <slot>] [--to <slot>]")
+	}
+
+	when := time.Now()
+	if *date != "" {
+		var err error
+		when, err = time.Parse("2006-01-02", *date)
+		if err != nil {
+			util.Logger.Fatalf("bad --date: %s", err)
+		}
+	}
+
+	var dbConfig *data.Config
+	if *databaseFilename != "" {
+		bytes, err := ioutil.ReadFile(*databaseFilename)
+		if err != nil {
+			util.Logger.Fatal(err)
+		}
+		dbConfig = data.NewConfigFromSerialized(bytes)
+	} else {
+		dbConfig = data.NewProdConfig()
+	}
+	if dbConfig == nil {
+		util.Logger.Fatal("journal needs a database. use the --database flag")
+	}
+	dbConfig.ReadOnly = true
+	db := data.NewDatabase(dbConfig)
+
+	w, err := data.NewJournalWriter(os.Stdout, *format, *decimals, *commodity, when)
+	if err != nil {
+		util.Logger.Fatal(err)
+	}
+	options := data.DefaultForBlocksOptions()
+	options.From = *from
+	options.To = *to
+	_, err = db.ForBlocksWithOptions(context.Background(), options, func(b *data.Block) {
+		for _, entry := range b.Journal() {
+			if err := w.Write(entry); err != nil {
+				util.Logger.Fatal(err)
+			}
+		}
+	})
+	if err != nil {
+		util.Logger.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		util.Logger.Fatal(err)
+	}
+}
@@ -6,31 +6,106 @@ import (
 	"log"
 	"os"
 
+	"github.com/lacker/coinkit/bus"
+	"github.com/lacker/coinkit/config"
 	"github.com/lacker/coinkit/data"
 	"github.com/lacker/coinkit/network"
 	"github.com/lacker/coinkit/util"
 )
 
 // cserver runs a coinkit server.
+// "cserver devnet" runs a whole local network instead. See devnet.go.
+// "cserver archive" serves history without joining consensus. See archive.go.
+// "cserver replay" replays a recorded trace. See replay.go.
+// "cserver soak" runs a local network under load and chaos for a long time.
+// See soak.go.
+// "cserver analyze-quorum" checks whether quorum slices are safe. See analyze.go.
+// "cserver rekey" encrypts a key pair file, or changes how it's encrypted.
+// See rekey.go.
+// "cserver signer" holds a validator's key pair for it. See signer.go.
+// "cserver journal" exports blocks as accounting entries. See journal.go.
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "devnet" {
+		devnet(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "archive" {
+		archive(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		replay(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "soak" {
+		soak(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "analyze-quorum" {
+		analyzeQuorum(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "rekey" {
+		rekey(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "signer" {
+		signer(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "journal" {
+		journal(os.Args[2:])
+		return
+	}
+
+	var configFilename string
 	var databaseFilename string
 	var keyPairFilename string
+	var kmsCommand string
 	var networkFilename string
 	var httpPort int
 	var logToStdOut bool
+	var otlpEndpoint string
 
+	flag.StringVar(&configFilename,
+		"config", "", "the file to load node config from. replaces the other config flags")
 	flag.StringVar(&databaseFilename,
 		"database", "", "optional. the file to load database config from")
 	flag.StringVar(&keyPairFilename,
 		"keypair", "", "the file to load keypair config from")
+	flag.StringVar(&kmsCommand, "kms", "",
+		"optional. the KMS command that unwraps an encrypted keypair file")
 	flag.StringVar(&networkFilename,
 		"network", "", "the file to load network config from")
 	flag.IntVar(&httpPort, "http", 0, "the port to serve /healthz etc on")
 	flag.BoolVar(&logToStdOut, "logtostdout", false, "whether to log to stdout")
+	flag.StringVar(&otlpEndpoint, "otlp", "",
+		"optional. the OTLP/HTTP url to export tracing spans to")
 
 	flag.Parse()
 
+	if logToStdOut {
+		util.Logger = log.New(os.Stdout, "", log.LstdFlags)
+	}
+
+	if configFilename != "" {
+		if databaseFilename != "" || keyPairFilename != "" || networkFilename != "" ||
+			httpPort != 0 || otlpEndpoint != "" || kmsCommand != "" {
+			util.Logger.Fatal("--config cannot be combined with other config flags")
+		}
+		c, err := config.Load(configFilename)
+		if err != nil {
+			util.Logger.Fatal(err)
+		}
+		util.Logger.Printf("loaded config: %s", c)
+		serve(c.KeyPair(), c.NetworkConfig(), c.DatabaseConfig(),
+			c.API.HTTPPort, c.API.OTLPEndpoint, c.WebhookConfigs(), c.Bus,
+			c.ACL(), c.API.ClientPort, c.AlertConfigs(), c.HealthThresholds(),
+			c.AdminAddress(), c.GraphDir(), c.TraceFile())
+		return
+	}
+
 	if keyPairFilename == "" {
 		util.Logger.Fatal("the --keypair flag must be set")
 	}
@@ -39,11 +114,6 @@ func main() {
 		util.Logger.Fatal("the --network flag must be set")
 	}
 
-	if logToStdOut {
-		util.Logger = log.New(os.Stdout, "", log.LstdFlags)
-	}
-
-	var db *data.Database
 	dbConfig := data.NewProdConfig()
 	if dbConfig == nil && databaseFilename != "" {
 		bytes, err := ioutil.ReadFile(databaseFilename)
@@ -52,11 +122,8 @@ func main() {
 		}
 		dbConfig = data.NewConfigFromSerialized(bytes)
 	}
-	if dbConfig != nil {
-		db = data.NewDatabase(dbConfig)
-	}
 
-	kp, err := util.ReadKeyPairFromFile(keyPairFilename)
+	kp, err := newUnlocker(kmsCommand).ReadKeyPairFromFile(keyPairFilename)
 	if err != nil {
 		util.Logger.Fatal(err)
 	}
@@ -67,9 +134,61 @@ func main() {
 	}
 	net := network.NewConfigFromSerialized(bytes)
 
+	serve(kp, net, dbConfig, httpPort, otlpEndpoint, nil, nil, nil, 0,
+		nil, network.DefaultHealthThresholds(), "", "", "")
+}
+
+// serve runs a server forever. dbConfig and busConfig can be nil, for no
+// database and no message bus. acl can be nil to accept connections from
+// anywhere, and clientPort can be zero for no separate client port.
+// Health checks only run when there are alert sinks. adminAddress can be
+// empty to not serve diagnostics, graphDir can be empty to not write
+// consensus graphs, and traceFile can be empty to not record a trace.
+func serve(kp util.Signer, net *network.Config, dbConfig *data.Config,
+	httpPort int, otlpEndpoint string, webhooks []*network.WebhookConfig,
+	busConfig *bus.Config, acl *network.ACL, clientPort int,
+	alerts []*network.AlertConfig, health network.HealthThresholds,
+	adminAddress string, graphDir string, traceFile string) {
+	if err := net.CheckName(); err != nil {
+		util.Logger.Fatal(err)
+	}
+	if otlpEndpoint != "" {
+		util.SetSpanExporter(util.NewOTLPExporter(otlpEndpoint, "cserver"))
+	}
+
+	var db *data.Database
+	if dbConfig != nil {
+		db = data.NewDatabase(dbConfig)
+	}
+
 	s := network.NewServer(kp, net, db)
+	s.ACL = acl
+	s.ClientPort = clientPort
+	s.GraphDir = graphDir
+	if traceFile != "" {
+		if err := s.RecordTrace(traceFile); err != nil {
+			util.Logger.Fatalf("could not record a trace: %s", err)
+		}
+	}
+	for _, webhook := range webhooks {
+		s.AddWebhook(webhook)
+	}
+	s.Health = health
+	for _, alert := range alerts {
+		s.AddAlertSink(alert)
+	}
+	if busConfig != nil {
+		publisher, err := bus.NewPublisher(busConfig)
+		if err != nil {
+			util.Logger.Fatalf("could not connect to the %s bus: %s", busConfig.Kind, err)
+		}
+		s.AddPublisher(publisher)
+	}
 	if httpPort != 0 {
 		s.ServeHttpInBackground(httpPort)
 	}
+	if adminAddress != "" {
+		s.ServeAdminInBackground(adminAddress)
+	}
 	s.ServeForever()
 }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lacker/coinkit/util"
+)
+
+// newKeyPassphraseEnv holds the new passphrase for rekey, so it can run
+// without a terminal.
+const newKeyPassphraseEnv = "COINKIT_NEW_KEY_PASSPHRASE"
+
+// newUnlocker makes an unlocker for key pair files. kmsCommand is the KMS
+// command and its arguments, separated by spaces, or empty for no KMS.
+func newUnlocker(kmsCommand string) *util.KeyUnlocker {
+	u := &util.KeyUnlocker{}
+	if kmsCommand != "" {
+		u.KMS = util.CommandKMS(strings.Fields(kmsCommand))
+	}
+	return u
+}
+
+// rekey encrypts a key pair file in place, or re-encrypts one that is
+// already encrypted, with a new passphrase or KMS. The old file is only
+// replaced once the new one is known to unlock.
+func rekey(args []string) {
+	flags := flag.NewFlagSet("rekey", flag.ExitOnError)
+	kmsCommand := flags.String("kms", "", "the KMS command that unwraps the file now, if any")
+	newKMSCommand := flags.String("new-kms", "",
+		"the KMS command to wrap the new data key with. without it, a new passphrase is used")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		util.Logger.Fatal("usage: cserver rekey [--kms <command>] [--new-kms <command>] <keypair file>")
+	}
+	filename := flags.Arg(0)
+
+	kp, err := newUnlocker(*kmsCommand).ReadKeyPairFromFile(filename)
+	if err != nil {
+		util.Logger.Fatal(err)
+	}
+
+	var encrypted *util.EncryptedKeyPair
+	unlocker := newUnlocker(*newKMSCommand)
+	if *newKMSCommand != "" {
+		encrypted, err = util.EncryptKeyPairWithKMS(kp, unlocker.KMS)
+		if err != nil {
+			util.Logger.Fatal(err)
+		}
+	} else {
+		passphrase := newPassphrase()
+		encrypted = util.EncryptKeyPair(kp, passphrase)
+		unlocker.Passphrase = func(string) (string, error) {
+			return passphrase, nil
+		}
+	}
+	check, err := unlocker.Unlock(encrypted, filename)
+	if err != nil || check.PublicKey() != kp.PublicKey() {
+		util.Logger.Fatalf("the re-encrypted key pair does not unlock: %v", err)
+	}
+
+	// Write a new file and rename it over the old one, so a crash can't
+	// leave half a key
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), ".rekey")
+	if err != nil {
+		util.Logger.Fatal(err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(encrypted.Serialize()); err != nil {
+		util.Logger.Fatal(err)
+	}
+	if err := tmp.Sync(); err != nil {
+		util.Logger.Fatal(err)
+	}
+	if err := tmp.Close(); err != nil {
+		util.Logger.Fatal(err)
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		util.Logger.Fatal(err)
+	}
+	util.Logger.Printf("encrypted the key pair for %s in %s", kp.PublicKey(), filename)
+}
+
+// newPassphrase gets the passphrase to encrypt with, from the environment
+// or by asking twice. A weak one is refused.
+func newPassphrase() string {
+	passphrase, ok := os.LookupEnv(newKeyPassphraseEnv)
+	if !ok {
+		var err error
+		passphrase, err = util.AskSecret("enter a new passphrase: ")
+		if err != nil {
+			util.Logger.Fatalf("%s. or set %s", err, newKeyPassphraseEnv)
+		}
+		again, err := util.AskSecret("enter it again: ")
+		if err != nil {
+			util.Logger.Fatal(err)
+		}
+		if again != passphrase {
+			util.Logger.Fatal("the passphrases do not match")
+		}
+	}
+	strength := util.CheckPassphrase(passphrase)
+	if strength.Weak() {
+		util.Logger.Fatalf("refusing to use a passphrase with about %.0f bits of entropy: %s",
+			strength.Bits, strings.Join(strength.Problems, ", "))
+	}
+	if !strength.Strong() {
+		util.Logger.Printf("warning: the passphrase only has about %.0f bits of entropy",
+			strength.Bits)
+	}
+	return passphrase
+}
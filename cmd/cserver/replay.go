@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/lacker/coinkit/config"
+	"github.com/lacker/coinkit/network"
+	"github.com/lacker/coinkit/util"
+)
+
+// replay feeds a trace that a server recorded into a fresh node, to
+// reproduce a bug without the rest of the network. It needs the config of
+// the server that recorded the trace, for its key pair and network.
+func replay(args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	configFilename := flags.String("config", "", "the config of the server that recorded the trace")
+	logToStdOut := flags.Bool("logtostdout", false, "whether to log to stdout")
+	flags.Parse(args)
+
+	if *logToStdOut {
+		util.Logger = log.New(os.Stdout, "", log.LstdFlags)
+	}
+	if *configFilename == "" || flags.NArg() != 1 {
+		util.Logger.Fatal("usage: cserver replay --config <file> <trace file>")
+	}
+
+	c, err := config.Load(*configFilename)
+	if err != nil {
+		util.Logger.Fatal(err)
+	}
+	trace, err := network.LoadTrace(flags.Arg(0))
+	if err != nil {
+		util.Logger.Fatal(err)
+	}
+	if trace.PublicKey != c.KeyPair().PublicKey().String() {
+		util.Logger.Fatalf("the trace was recorded by %s, not by this config's server",
+			trace.PublicKey)
+	}
+
+	node := network.NewReplayNode(c.KeyPair(), c.NetworkConfig())
+	result := network.ReplayTrace(trace, node)
+	fmt.Printf("handled %d messages from %d trace entries, ending on slot %d\n",
+		result.Handled, len(trace.Entries), result.Slot)
+	if result.Divergence == nil {
+		fmt.Printf("every message the server sent was reproduced\n")
+		return
+	}
+	fmt.Printf("the replay diverged. the server sent:\n%s\n", result.Divergence.Sent)
+	if result.Cause != nil && result.Cause.NextRound() {
+		fmt.Printf("after a nomination round timed out\n")
+	} else if result.Cause != nil {
+		fmt.Printf("after handling this message from %s:\n%s\n",
+			result.Cause.Message.Signer(), result.Cause.Message.Message())
+	}
+	os.Exit(1)
+}
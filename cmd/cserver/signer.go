@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+
+	"github.com/lacker/coinkit/network"
+	"github.com/lacker/coinkit/util"
+)
+
+// signer runs a remote signer, which holds a validator's key pair and signs
+// for a server whose config has signer and signerSecret set. It can run on
+// another host, or as another user, so the server never sees the key.
+func signer(args []string) {
+	flags := flag.NewFlagSet("signer", flag.ExitOnError)
+	keyPairFilename := flags.String("keypair", "", "the key pair file to sign with")
+	kmsCommand := flags.String("kms", "", "optional. the KMS command that unwraps the keypair file")
+	listen := flags.String("listen", "",
+		"where to listen, like unix:/run/coinkit/signer.sock or 127.0.0.1:9200")
+	secretFilename := flags.String("secret", "", "the file with the secret shared with the server")
+	logToStdOut := flags.Bool("logtostdout", false, "whether to log to stdout")
+	flags.Parse(args)
+
+	if *logToStdOut {
+		util.Logger = log.New(os.Stdout, "", log.LstdFlags)
+	}
+	if *keyPairFilename == "" || *listen == "" || *secretFilename == "" || flags.NArg() != 0 {
+		util.Logger.Fatal("usage: cserver signer --keypair <file> --listen <address> --secret <file>")
+	}
+
+	kp, err := newUnlocker(*kmsCommand).ReadKeyPairFromFile(*keyPairFilename)
+	if err != nil {
+		util.Logger.Fatal(err)
+	}
+	secret, err := ioutil.ReadFile(*secretFilename)
+	if err != nil {
+		util.Logger.Fatal(err)
+	}
+	secret = bytes.TrimSpace(secret)
+	if len(secret) < 16 {
+		util.Logger.Fatal("the secret should be at least 16 bytes")
+	}
+
+	kind, address := network.SignerAddress(*listen)
+	if kind == "unix" {
+		// A socket left over from a previous run would stop us listening
+		os.Remove(address)
+	}
+	listener, err := net.Listen(kind, address)
+	if err != nil {
+		util.Logger.Fatal(err)
+	}
+	if kind == "unix" {
+		if err := os.Chmod(address, 0600); err != nil {
+			util.Logger.Fatal(err)
+		}
+	}
+	util.Logger.Printf("signing for %s on %s", kp.PublicKey(), *listen)
+	util.Logger.Fatal(network.ServeSigner(listener, kp, secret))
+}
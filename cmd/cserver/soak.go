@@ -0,0 +1,609 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/data"
+	"github.com/lacker/coinkit/network"
+	"github.com/lacker/coinkit/util"
+)
+
+// soak runs a local network of cservers for a long time, like devnet, while
+// sending operations to it and, with the chaos flags, killing, partitioning,
+// and starving the nodes one thing at a time. It fails if two nodes ever
+// externalize different values for a slot, if the network stops making
+// progress, or if a disturbed node takes too long to catch up afterwards.
+// It exits with status 1 on the first failure.
+//
+// Only one node is disturbed at a time, so a network where any threshold
+// nodes make a quorum should keep going throughout. Each node's output goes
+// to its own log file in the config directory.
+// The nodes reach each other through proxies that the soak runs, so that it
+// can cut a node off from its peers. The soak itself talks to each node
+// directly, so a node that is cut off still answers it.
+func soak(args []string) {
+	flags := flag.NewFlagSet("soak", flag.ExitOnError)
+	nodes := flags.Int("nodes", 4, "how many nodes to run")
+	port := flags.Int("port", 9100, "the port for the first node. nodes use consecutive ports, "+
+		"and the proxies between them use the ports after that")
+	useDatabase := flags.Bool("database", false,
+		"give each node its own database, devnet0 and so on. they are cleared on startup")
+	seed := flags.Int("seed", 0, "the seed to generate node keys from")
+	dir := flags.String("dir", "",
+		"where to write node config and logs. by default a temporary directory is used, "+
+			"which is kept if the soak fails")
+	duration := flags.Duration("duration", time.Hour, "how long to run for")
+	senders := flags.Int("senders", 4, "how many accounts send operations at once")
+	kill := flags.Bool("kill", false, "randomly kill nodes and restart them")
+	partition := flags.Bool("partition", false,
+		"randomly cut nodes off from their peers, and reconnect them")
+	fillDir := flags.String("fill-disk", "",
+		"randomly fill up the disk this directory is on, then free it again")
+	interval := flags.Duration("chaos-interval", time.Minute,
+		"the most time between the start of one disturbance and the next")
+	maxCatchUp := flags.Duration("max-catchup", 2*time.Minute,
+		"how long a disturbed node has to catch up, and the network to make progress")
+	flags.Parse(args)
+
+	if *nodes < 1 || *senders < 1 {
+		util.Logger.Fatal("--nodes and --senders must be positive")
+	}
+
+	configDir := *dir
+	if configDir == "" {
+		tmp, err := ioutil.TempDir("", "soak")
+		if err != nil {
+			util.Logger.Fatal(err)
+		}
+		defer os.RemoveAll(tmp)
+		configDir = tmp
+	} else if err := os.MkdirAll(configDir, 0755); err != nil {
+		util.Logger.Fatal(err)
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		util.Logger.Fatal(err)
+	}
+	s := &soaker{
+		self:       self,
+		maxCatchUp: *maxCatchUp,
+		exits:      make(chan *soakNode, *nodes),
+		failed:     make(chan struct{}),
+	}
+	net, kps := network.NewLocalhostNetwork(*port, *nodes, *seed)
+	for i, kp := range kps {
+		s.nodes = append(s.nodes, &soakNode{
+			index:   i,
+			key:     kp.PublicKey().String(),
+			address: net.Servers[kp.PublicKey().String()],
+		})
+	}
+	nodeNets, err := s.startProxies(net, *port+*nodes)
+	if err != nil {
+		util.Logger.Fatal(err)
+	}
+	for i, node := range s.nodes {
+		var dbConfig *data.Config
+		if *useDatabase {
+			dbConfig = data.NewDevnetConfig(i)
+			data.DropData(dbConfig)
+		}
+		node.filename, err = writeNodeConfig(configDir, i, kps, nodeNets[i], dbConfig, 0)
+		if err != nil {
+			util.Logger.Fatal(err)
+		}
+		node.log, err = os.OpenFile(filepath.Join(configDir, fmt.Sprintf("node%d.log", i)),
+			os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			util.Logger.Fatal(err)
+		}
+		defer node.log.Close()
+	}
+	for _, node := range s.nodes {
+		if err := s.start(node); err != nil {
+			util.Logger.Fatal(err)
+		}
+	}
+	util.Logger.Printf("started %d nodes on ports %d-%d, with config and logs in %s",
+		*nodes, *port, *port+*nodes-1, configDir)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+	go s.load(ctx, *senders)
+
+	actions := []func(context.Context, time.Duration){}
+	if *kill {
+		actions = append(actions, s.killNode)
+	}
+	if *partition {
+		actions = append(actions, s.partitionNode)
+	}
+	if *fillDir != "" {
+		actions = append(actions, func(ctx context.Context, hold time.Duration) {
+			s.fillDisk(ctx, *fillDir, hold)
+		})
+	}
+	chaosDone := make(chan struct{})
+	go func() {
+		s.chaos(ctx, actions, *interval)
+		close(chaosDone)
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	s.watch(ctx, signals)
+	cancel()
+	<-chaosDone
+	s.stopAll()
+	for _, node := range s.nodes {
+		for _, link := range node.links {
+			link.close()
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	util.Logger.Printf("%d operations cleared, %d were dropped, %d disturbances, "+
+		"slowest catch-up %s, network head %d",
+		s.cleared, s.dropped, s.disturbances, s.slowest, s.head)
+	if s.failure != "" {
+		util.Logger.Printf("the soak failed: %s", s.failure)
+		os.Exit(1)
+	}
+	util.Logger.Printf("the soak passed")
+}
+
+// A soakNode is one cserver process in a soak, which can be restarted.
+type soakNode struct {
+	index    int
+	filename string
+	key      string
+	address  *network.Address
+	log      *os.File
+
+	// The proxies for the links between this node and the others, both ways
+	links []*soakProxy
+
+	// The current process, which closes done when it exits, and whether the
+	// soak is stopping it on purpose. Guarded by the soaker's mutex.
+	cmd      *exec.Cmd
+	done     chan struct{}
+	stopping bool
+}
+
+func (n *soakNode) String() string {
+	return fmt.Sprintf("node%d", n.index)
+}
+
+// client connects to the node, checking that its answers are signed by it.
+func (n *soakNode) client() *network.Client {
+	return network.NewVerifiedClient(network.NewRedialConnection(n.address, nil), n.key)
+}
+
+// head returns the node's last externalized slot, and what it externalized
+// for slot, if it knows.
+func (n *soakNode) head(ctx context.Context, slot int) (*network.HeadMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	client := n.client()
+	defer client.Close()
+	return client.GetHead(ctx, slot)
+}
+
+type soaker struct {
+	self       string
+	nodes      []*soakNode
+	maxCatchUp time.Duration
+
+	// Nodes whose process exits without the soak stopping it are sent here
+	exits chan *soakNode
+
+	// Closed on the first failure
+	failed chan struct{}
+
+	mutex        sync.Mutex
+	failure      string
+	cleared      int
+	dropped      int
+	disturbances int
+	slowest      time.Duration
+	head         int
+
+	// Set while the disk is full, when nodes are allowed to exit
+	filling bool
+}
+
+// fail records the first failure and stops the soak.
+func (s *soaker) fail(format string, args ...interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.failure != "" {
+		return
+	}
+	s.failure = fmt.Sprintf(format, args...)
+	util.Logger.Printf("failure: %s", s.failure)
+	close(s.failed)
+}
+
+func (s *soaker) start(node *soakNode) error {
+	cmd := exec.Command(s.self, "--config="+node.filename, "--logtostdout")
+	cmd.Stdout = node.log
+	cmd.Stderr = node.log
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	s.mutex.Lock()
+	node.cmd = cmd
+	node.done = done
+	node.stopping = false
+	s.mutex.Unlock()
+	go func() {
+		cmd.Wait()
+		s.mutex.Lock()
+		expected := node.stopping || s.filling
+		s.mutex.Unlock()
+		close(done)
+		if !expected {
+			s.exits <- node
+		}
+	}()
+	return nil
+}
+
+// exited returns whether the node's current process has exited.
+func (s *soaker) exited(node *soakNode) bool {
+	s.mutex.Lock()
+	done := node.done
+	s.mutex.Unlock()
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+// stop sends a signal to a node's process and waits for it to exit.
+func (s *soaker) stop(node *soakNode, sig os.Signal) {
+	s.mutex.Lock()
+	cmd, done := node.cmd, node.done
+	node.stopping = true
+	s.mutex.Unlock()
+	cmd.Process.Signal(sig)
+	<-done
+}
+
+func (s *soaker) stopAll() {
+	for _, node := range s.nodes {
+		if !s.exited(node) {
+			s.stop(node, syscall.SIGTERM)
+		}
+	}
+}
+
+// watch checks the network until the soak is over or fails, which is when
+// ctx is done, a check fails, a node exits on its own, or we get a signal.
+func (s *soaker) watch(ctx context.Context, signals chan os.Signal) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	progress := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.failed:
+			return
+		case sig := <-signals:
+			util.Logger.Printf("got %s, stopping the soak", sig)
+			return
+		case node := <-s.exits:
+			s.fail("%s exited on its own. see %s", node, node.log.Name())
+		case <-ticker.C:
+			head := s.checkDivergence(ctx)
+			s.mutex.Lock()
+			if head > s.head {
+				s.head = head
+				progress = time.Now()
+			}
+			s.mutex.Unlock()
+			if time.Since(progress) > s.maxCatchUp {
+				s.fail("the network made no progress past slot %d for %s", head, s.maxCatchUp)
+			}
+		}
+	}
+}
+
+// checkDivergence compares what the nodes that answer externalized for the
+// last slot they all have, and returns the highest head among them.
+func (s *soaker) checkDivergence(ctx context.Context) int {
+	heads := map[*soakNode]int{}
+	low, high := 0, 0
+	for _, node := range s.nodes {
+		head, err := node.head(ctx, 0)
+		if err != nil {
+			continue
+		}
+		heads[node] = head.Head
+		if low == 0 || head.Head < low {
+			low = head.Head
+		}
+		if head.Head > high {
+			high = head.Head
+		}
+	}
+	if low < 1 {
+		return high
+	}
+
+	var first *soakNode
+	var value string
+	for node := range heads {
+		head, err := node.head(ctx, low)
+		if err != nil || head.X == "" {
+			continue
+		}
+		if first == nil {
+			first, value = node, string(head.X)
+		} else if string(head.X) != value {
+			s.fail("%s and %s externalized different values for slot %d", first, node, low)
+		}
+	}
+	return high
+}
+
+// networkHead returns the highest slot that any node has externalized.
+func (s *soaker) networkHead(ctx context.Context) int {
+	high := 0
+	for _, node := range s.nodes {
+		if head, err := node.head(ctx, 0); err == nil && head.Head > high {
+			high = head.Head
+		}
+	}
+	return high
+}
+
+// awaitCatchUp waits for a node to externalize slot, and fails the soak if
+// that takes longer than maxCatchUp. A node that doesn't catch up is sent
+// SIGQUIT, so that its log ends with what each of its goroutines was doing.
+func (s *soaker) awaitCatchUp(ctx context.Context, node *soakNode, slot int) {
+	start := time.Now()
+	for time.Since(start) < s.maxCatchUp {
+		if head, err := node.head(ctx, 0); err == nil && head.Head >= slot {
+			elapsed := time.Since(start)
+			util.Logger.Printf("%s caught up to slot %d in %s", node, slot, elapsed)
+			s.mutex.Lock()
+			if elapsed > s.slowest {
+				s.slowest = elapsed
+			}
+			s.mutex.Unlock()
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+	s.fail("%s did not catch up to slot %d within %s. its goroutines are dumped to %s",
+		node, slot, s.maxCatchUp, node.log.Name())
+	s.stop(node, syscall.SIGQUIT)
+}
+
+// chaos runs a random action every so often, until ctx is done. Each action
+// disturbs the network for a random time, then lets it recover.
+func (s *soaker) chaos(ctx context.Context, actions []func(context.Context, time.Duration),
+	interval time.Duration) {
+	if len(actions) == 0 {
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.failed:
+			return
+		case <-time.After(time.Duration(rand.Int63n(int64(interval/2) + 1))):
+		}
+		hold := time.Duration(rand.Int63n(int64(interval/2) + 1))
+		actions[rand.Intn(len(actions))](ctx, hold)
+		s.mutex.Lock()
+		s.disturbances++
+		s.mutex.Unlock()
+	}
+}
+
+func (s *soaker) randomNode() *soakNode {
+	return s.nodes[rand.Intn(len(s.nodes))]
+}
+
+func sleepContext(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+// killNode kills a node without letting it shut down cleanly, and restarts it
+// after hold.
+func (s *soaker) killNode(ctx context.Context, hold time.Duration) {
+	node := s.randomNode()
+	util.Logger.Printf("killing %s for %s", node, hold)
+	s.stop(node, syscall.SIGKILL)
+	sleepContext(ctx, hold)
+	if err := s.start(node); err != nil {
+		s.fail("could not restart %s: %s", node, err)
+		return
+	}
+	s.awaitCatchUp(ctx, node, s.networkHead(ctx))
+}
+
+// startProxies starts a proxy for each node to reach each other node
+// through, on consecutive ports from port. It returns the network config for
+// each node, which has the real address for the node itself and its
+// proxies' addresses for the others.
+func (s *soaker) startProxies(net *network.Config, port int) ([]*network.Config, error) {
+	answer := []*network.Config{}
+	for _, from := range s.nodes {
+		servers := make(map[string]*network.Address)
+		for _, to := range s.nodes {
+			if to == from {
+				servers[to.key] = to.address
+				continue
+			}
+			address := &network.Address{Host: "127.0.0.1", Port: port}
+			port++
+			link, err := newSoakProxy(address.String(), to.address.String())
+			if err != nil {
+				return nil, err
+			}
+			from.links = append(from.links, link)
+			to.links = append(to.links, link)
+			servers[to.key] = address
+		}
+		nodeNet := *net
+		nodeNet.Servers = servers
+		answer = append(answer, &nodeNet)
+	}
+	return answer, nil
+}
+
+// partitionNode cuts a node off from the rest of the network for hold, and
+// then reconnects it.
+func (s *soaker) partitionNode(ctx context.Context, hold time.Duration) {
+	node := s.randomNode()
+	util.Logger.Printf("cutting %s off for %s", node, hold)
+	for _, link := range node.links {
+		link.setCut(true)
+	}
+	sleepContext(ctx, hold)
+	for _, link := range node.links {
+		link.setCut(false)
+	}
+	s.awaitCatchUp(ctx, node, s.networkHead(ctx))
+}
+
+// fillDisk writes to a file in dir until the disk is full, keeps it full for
+// hold, and then deletes the file. Nodes may exit while the disk is full, so
+// any that did are restarted, and then every node has to catch up.
+func (s *soaker) fillDisk(ctx context.Context, dir string, hold time.Duration) {
+	util.Logger.Printf("filling the disk of %s for %s", dir, hold)
+	s.mutex.Lock()
+	s.filling = true
+	s.mutex.Unlock()
+
+	f, err := ioutil.TempFile(dir, "soak-fill")
+	if err != nil {
+		s.fail("could not fill %s: %s", dir, err)
+		return
+	}
+	defer os.Remove(f.Name())
+	chunk := make([]byte, 1024*1024)
+	for ctx.Err() == nil {
+		if _, err := f.Write(chunk); err != nil {
+			break
+		}
+	}
+	sleepContext(ctx, hold)
+	f.Close()
+	os.Remove(f.Name())
+
+	s.mutex.Lock()
+	s.filling = false
+	s.mutex.Unlock()
+	for _, node := range s.nodes {
+		if s.exited(node) {
+			util.Logger.Printf("restarting %s, which exited while the disk was full", node)
+			if err := s.start(node); err != nil {
+				s.fail("could not restart %s: %s", node, err)
+				return
+			}
+		}
+	}
+	slot := s.networkHead(ctx)
+	for _, node := range s.nodes {
+		s.awaitCatchUp(ctx, node, slot)
+	}
+}
+
+// load funds the sender accounts from the mint, and then has each of them
+// keep sending money until ctx is done.
+func (s *soaker) load(ctx context.Context, senders int) {
+	mint := util.NewKeyPairFromSecretPhrase("mint")
+	kps := []*util.KeyPair{}
+	recipients := []string{}
+	for i := 0; i < senders; i++ {
+		kp := util.NewKeyPairFromSecretPhrase(fmt.Sprintf("soak%d", i))
+		kps = append(kps, kp)
+		recipients = append(recipients, kp.PublicKey().String())
+	}
+	for _, to := range recipients {
+		for ctx.Err() == nil && !s.send(ctx, mint, to, 1000000) {
+			sleepContext(ctx, time.Second)
+		}
+	}
+	for _, kp := range kps {
+		go func(kp *util.KeyPair) {
+			for ctx.Err() == nil {
+				if !s.send(ctx, kp, recipients[rand.Intn(len(recipients))], 1) {
+					sleepContext(ctx, time.Second)
+				}
+			}
+		}(kp)
+	}
+}
+
+// send sends money through a random node, and returns whether it cleared.
+// While nodes are being disturbed, some operations are dropped.
+func (s *soaker) send(ctx context.Context, kp *util.KeyPair, to string, amount uint64) bool {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	client := s.randomNode().client()
+	defer client.Close()
+
+	user := kp.PublicKey().String()
+	account, err := client.GetAccount(ctx, user)
+	if err != nil {
+		return false
+	}
+	fee, err := client.EstimateFee(ctx, 3)
+	if err != nil {
+		return false
+	}
+	sequence := uint32(1)
+	if account != nil {
+		sequence = account.Sequence + 1
+	}
+	op := &currency.SendOperation{
+		Signer:   user,
+		Sequence: sequence,
+		To:       to,
+		Amount:   amount,
+		Fee:      fee,
+	}
+	tm := currency.NewTransactionMessage(util.NewSignedOperation(op, kp))
+	client.Send(util.NewSignedMessage(tm, kp))
+	_, err = client.AwaitOperation(ctx, user, sequence)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err != nil {
+		s.dropped++
+		return false
+	}
+	s.cleared++
+	return true
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io"
+	"net"
+	"sync"
+)
+
+// A soakProxy forwards the connections one soak node makes to another, so
+// that the soak can cut the link between them. Each pair of nodes has its
+// own proxy in each direction.
+type soakProxy struct {
+	listener net.Listener
+	target   string
+
+	mutex sync.Mutex
+	cut   bool
+	conns map[net.Conn]bool
+}
+
+// newSoakProxy listens on address and forwards connections to target, in
+// the background.
+func newSoakProxy(address string, target string) (*soakProxy, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	p := &soakProxy{
+		listener: listener,
+		target:   target,
+		conns:    make(map[net.Conn]bool),
+	}
+	go p.acceptForever()
+	return p, nil
+}
+
+func (p *soakProxy) acceptForever() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		go p.forward(conn)
+	}
+}
+
+// forward copies data both ways between conn and the target, until either
+// side closes or the link is cut.
+func (p *soakProxy) forward(conn net.Conn) {
+	target, err := net.Dial("tcp", p.target)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	if !p.track(conn, target) {
+		conn.Close()
+		target.Close()
+		return
+	}
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(target, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, target)
+		done <- struct{}{}
+	}()
+	<-done
+	conn.Close()
+	target.Close()
+	p.mutex.Lock()
+	delete(p.conns, conn)
+	delete(p.conns, target)
+	p.mutex.Unlock()
+}
+
+// track remembers the connections, so cutting the link can close them. It
+// returns false if the link is already cut.
+func (p *soakProxy) track(conns ...net.Conn) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.cut {
+		return false
+	}
+	for _, conn := range conns {
+		p.conns[conn] = true
+	}
+	return true
+}
+
+// setCut cuts the link, closing its connections and refusing new ones, or
+// restores it.
+func (p *soakProxy) setCut(cut bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.cut = cut
+	if cut {
+		for conn := range p.conns {
+			conn.Close()
+		}
+		p.conns = make(map[net.Conn]bool)
+	}
+}
+
+func (p *soakProxy) close() {
+	p.listener.Close()
+	p.setCut(true)
+}
@@ -0,0 +1,41 @@
+// A thin wrapper around cwasm for browser wallets. Load wasm_exec.js from the
+// Go distribution first, then:
+//
+//   const coinkit = await loadCoinkit("coinkit.wasm");
+//   const key = coinkit.newKeyFromPhrase("my secret phrase");
+//   const signed = coinkit.signSend(key.serialized, to, "1.25", "0", sequence);
+//   await coinkit.submit("http://127.0.0.1:8000", signed);
+//
+// Amounts are decimal strings in coins. Keys are passed around in their
+// serialized form, which includes the private key, so store them carefully.
+
+async function loadCoinkit(url) {
+  const go = new Go();
+  const { instance } = await WebAssembly.instantiateStreaming(
+    fetch(url), go.importObject);
+  go.run(instance);
+
+  const coinkit = {};
+  for (const name of Object.keys(coinkitWasm)) {
+    coinkit[name] = function (...args) {
+      const answer = coinkitWasm[name](...args);
+      if (answer.error !== undefined) {
+        throw new Error(answer.error);
+      }
+      return answer.value;
+    };
+  }
+
+  // submit POSTs a signed message to a node's http port
+  coinkit.submit = async function (node, signed) {
+    const response = await fetch(node + "/operations", {
+      method: "POST",
+      body: signed,
+    });
+    if (response.status !== 202) {
+      throw new Error(await response.text());
+    }
+  };
+
+  return coinkit;
+}
@@ -0,0 +1,90 @@
+//go:build js && wasm
+// +build js,wasm
+
+// cwasm exposes key management and signing to browser wallets. Build it with
+// build-wasm.sh, and use it through coinkit.js rather than directly.
+// Every function returns an object with either a value or an error, which
+// coinkit.js turns into a return value or an exception.
+package main
+
+import (
+	"syscall/js"
+
+	"github.com/lacker/coinkit/mobile"
+)
+
+func result(value interface{}, err error) interface{} {
+	if err != nil {
+		return map[string]interface{}{"error": err.Error()}
+	}
+	return map[string]interface{}{"value": value}
+}
+
+// readKey reads a key that was saved with its serialized form.
+func readKey(serialized js.Value) (*mobile.Key, error) {
+	return mobile.ReadKey(serialized.String())
+}
+
+func keyInfo(key *mobile.Key) interface{} {
+	return map[string]interface{}{
+		"address":    key.Address(),
+		"publicKey":  key.PublicKey(),
+		"serialized": key.Serialize(),
+	}
+}
+
+var functions = map[string]func(args []js.Value) interface{}{
+	"newKey": func(args []js.Value) interface{} {
+		return result(keyInfo(mobile.NewKey()), nil)
+	},
+	"newKeyFromPhrase": func(args []js.Value) interface{} {
+		return result(keyInfo(mobile.NewKeyFromPhrase(args[0].String())), nil)
+	},
+	"readKey": func(args []js.Value) interface{} {
+		key, err := readKey(args[0])
+		if err != nil {
+			return result(nil, err)
+		}
+		return result(keyInfo(key), nil)
+	},
+	"setAddressPrefix": func(args []js.Value) interface{} {
+		return result(nil, mobile.SetAddressPrefix(args[0].String()))
+	},
+	"validAddress": func(args []js.Value) interface{} {
+		return result(mobile.ValidAddress(args[0].String()), nil)
+	},
+	"shortAddress": func(args []js.Value) interface{} {
+		return result(mobile.ShortAddress(args[0].String()), nil)
+	},
+	"parseAmount": func(args []js.Value) interface{} {
+		return result(mobile.ParseAmount(args[0].String()))
+	},
+	"formatAmount": func(args []js.Value) interface{} {
+		return result(mobile.FormatAmount(args[0].String()))
+	},
+	"signSend": func(args []js.Value) interface{} {
+		key, err := readKey(args[0])
+		if err != nil {
+			return result(nil, err)
+		}
+		return result(key.SignSend(args[1].String(), args[2].String(),
+			args[3].String(), int64(args[4].Int())))
+	},
+	"verifyMessage": func(args []js.Value) interface{} {
+		return result(mobile.VerifyMessage(args[0].String()))
+	},
+}
+
+func main() {
+	exports := make(map[string]interface{})
+	for name, f := range functions {
+		f := f
+		exports[name] = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			return f(args)
+		})
+	}
+	js.Global().Set("coinkitWasm", js.ValueOf(exports))
+
+	// The functions stop working if main returns
+	select {}
+}
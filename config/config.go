@@ -0,0 +1,737 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/lacker/coinkit/bus"
+	"github.com/lacker/coinkit/data"
+	"github.com/lacker/coinkit/network"
+	"github.com/lacker/coinkit/util"
+)
+
+// A Config is everything a node needs to run, loaded from a single TOML file.
+// See local/node0.toml for an example.
+type Config struct {
+	// The path to this node's key pair file.
+	// Relative paths are relative to the directory the config file is in.
+	KeyPairFile string `toml:"keypair"`
+
+	// Optional. The command for a KMS that unwraps the key pair file's data
+	// key, like ["/usr/local/bin/unwrap-key", "validator"]. See util.CommandKMS.
+	// An encrypted key pair file that isn't wrapped by a KMS is unlocked with
+	// a passphrase from $COINKIT_KEY_PASSPHRASE or the terminal.
+	KMS []string `toml:"kms"`
+
+	// Optional, instead of keypair. The address of a remote signer that
+	// holds the key pair, like "unix:/run/coinkit/signer.sock", and the file
+	// with the secret shared with it. See network.RemoteSigner.
+	Signer       string `toml:"signer"`
+	SignerSecret string `toml:"signerSecret"`
+
+	Network NetworkConfig `toml:"network"`
+
+	// The database is optional. Without one, the node keeps everything in memory.
+	// Keys are the data.Config field names, like database, host, and durability.
+	Database *data.Config `toml:"database"`
+
+	API APIConfig `toml:"api"`
+
+	Webhooks []WebhookConfig `toml:"webhooks"`
+
+	// Optional. Alerts the operator about problems with this node.
+	Alerting *AlertingConfig `toml:"alerting"`
+
+	// Optional. Keys are the bus.Config field names: kind, urls, and topic.
+	Bus *bus.Config `toml:"bus"`
+
+	// The directory the config file was in
+	dir string
+
+	// The key pair or remote signer, once it is loaded
+	keyPair util.Signer
+}
+
+type NetworkConfig struct {
+	// Which built-in network this is, like "testnet". The servers have to
+	// match that network's genesis. Empty means any network.
+	Name string `toml:"name"`
+
+	// How many servers make a quorum
+	Threshold int `toml:"threshold"`
+
+	Servers []ServerConfig `toml:"servers"`
+
+	// Whether to encrypt connections to the other servers with Noise
+	Encrypt bool `toml:"encrypt"`
+
+	// CIDRs that can or can't connect to this node's port in the servers
+	// list. If allow is set, only addresses in it can connect. Deny wins.
+	Allow []string `toml:"allow"`
+	Deny  []string `toml:"deny"`
+
+	// A SOCKS5 url to dial the other servers through, like
+	// "socks5://127.0.0.1:9050" for Tor
+	Proxy string `toml:"proxy"`
+
+	// Experimental: whether servers talk to each other over QUIC
+	QUIC bool `toml:"quic"`
+
+	// The operation types to accept into this node's mempool, like "Send".
+	// Empty means all of them.
+	Operations []string `toml:"operations"`
+
+	// For private deployments, the public keys or addresses of the clients
+	// that can submit operations. Servers always can. Empty means anyone can.
+	Clients []string `toml:"clients"`
+
+	// Whether only clients and servers can query the node
+	PrivateQueries bool `toml:"privateQueries"`
+
+	// The public keys or addresses of accounts in the priority lane, whose
+	// operations are always admitted and go first in this node's proposals
+	Priority []string `toml:"priority"`
+
+	// The most of a proposed block the priority lane can take, as a
+	// percentage. Zero means the default
+	PriorityShare int `toml:"priorityShare"`
+}
+
+type ServerConfig struct {
+	PublicKey string `toml:"publicKey"`
+	Host      string `toml:"host"`
+	Port      int    `toml:"port"`
+
+	// A standby server isn't a validator at first, but it gets promoted if a
+	// validator stops responding
+	Standby bool `toml:"standby"`
+
+	// An onion address for the server, on the same port. Servers that dial
+	// through a proxy use it instead of host.
+	Onion string `toml:"onion"`
+}
+
+type APIConfig struct {
+	// The port to serve /healthz, /graphql, etc on. Zero means not to serve them.
+	HTTPPort int `toml:"httpPort"`
+
+	// A port for clients to connect to, which network.allow and network.deny
+	// don't apply to. Zero means clients use the validator port.
+	ClientPort int `toml:"clientPort"`
+
+	// The OTLP/HTTP url to export tracing spans to. Empty means no tracing.
+	OTLPEndpoint string `toml:"otlpEndpoint"`
+
+	// The port to serve pprof and other diagnostics on. Zero means not to
+	// serve them.
+	AdminPort int `toml:"adminPort"`
+
+	// The interface to serve diagnostics on. The default is 127.0.0.1, since
+	// anyone who can reach it can profile the node.
+	AdminHost string `toml:"adminHost"`
+
+	// A directory to write a DOT and a JSON graph of the current slot's
+	// consensus state to, every second. Empty means not to write them.
+	// Relative paths are relative to the directory the config file is in.
+	GraphDir string `toml:"graphDir"`
+
+	// A file to record a trace of every message the node handles and sends
+	// to, for replaying with "cserver replay". Empty means not to record.
+	// Relative paths are relative to the directory the config file is in.
+	TraceFile string `toml:"traceFile"`
+}
+
+type WebhookConfig struct {
+	URL string `toml:"url"`
+
+	// The key used to sign requests with HMAC-SHA256
+	Secret string `toml:"secret"`
+
+	// If set, the webhook is only notified when these accounts change
+	Accounts []string `toml:"accounts"`
+}
+
+type AlertingConfig struct {
+	// The directory whose disk to watch, like postgres's data directory.
+	// Empty means not to watch any disk.
+	DiskPath string `toml:"diskPath"`
+
+	// Zero means the default, 10
+	MinFreeDiskPercent int `toml:"minFreeDiskPercent"`
+
+	// Zero means not to alert about peers
+	MinPeers int `toml:"minPeers"`
+
+	// How many invalid signatures from one peer, per minute, to alert on.
+	// Zero means the default, 100
+	MaxInvalidSignatures int `toml:"maxInvalidSignatures"`
+
+	Sinks []AlertSinkConfig `toml:"sinks"`
+}
+
+type AlertSinkConfig struct {
+	// webhook, smtp, or pagerduty
+	Kind string `toml:"kind"`
+
+	// info, warning, or critical. Empty means all alerts.
+	MinSeverity string `toml:"minSeverity"`
+
+	// For webhooks, and optionally for pagerduty
+	URL    string `toml:"url"`
+	Secret string `toml:"secret"`
+
+	// For pagerduty
+	RoutingKey string `toml:"routingKey"`
+
+	// For smtp
+	Host     string   `toml:"host"`
+	Port     int      `toml:"port"`
+	Username string   `toml:"username"`
+	Password string   `toml:"password"`
+	From     string   `toml:"from"`
+	To       []string `toml:"to"`
+}
+
+// Load reads and validates a config file.
+// Errors include the line number of the problem whenever possible.
+func Load(filename string) (*Config, error) {
+	source, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	c, err := Parse(source, filepath.Dir(filename))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	return c, nil
+}
+
+// Parse parses and validates the contents of a config file.
+// dir is the directory that relative paths are relative to.
+func Parse(source []byte, dir string) (*Config, error) {
+	c := &Config{dir: dir}
+	md, err := toml.Decode(string(source), c)
+	if err != nil {
+		if pe, ok := err.(toml.ParseError); ok {
+			return nil, &Error{Line: pe.Position.Line, Message: pe.Message}
+		}
+		// Type errors already mention the line
+		return nil, err
+	}
+
+	lines := newLineIndex(source)
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		key := undecoded[0].String()
+		return nil, lines.errorf(key, "unknown key %q", key)
+	}
+
+	if err := c.validate(lines); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// An Error is a problem with a config file.
+type Error struct {
+	// The line the problem is on. Zero when we don't know the line.
+	Line int
+
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Line == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+func validPort(port int) bool {
+	return 0 < port && port < 65536
+}
+
+func validURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func (c *Config) validate(lines *lineIndex) error {
+	var kp util.Signer
+	switch {
+	case c.KeyPairFile != "" && c.Signer != "":
+		return lines.errorf("signer", "keypair and signer cannot both be set")
+	case c.Signer != "":
+		if c.SignerSecret == "" {
+			return lines.errorf("signer", "signerSecret must be set to use a signer")
+		}
+		secret, err := ioutil.ReadFile(c.resolve(c.SignerSecret))
+		if err != nil {
+			return lines.errorf("signerSecret", "could not read the signer secret: %s", err)
+		}
+		secret = bytes.TrimSpace(secret)
+		if len(secret) < 16 {
+			return lines.errorf("signerSecret", "the signer secret should be at least 16 bytes")
+		}
+		signer, err := network.NewRemoteSigner(c.resolveSigner(), secret)
+		if err != nil {
+			return lines.errorf("signer", "could not reach the signer: %s", err)
+		}
+		kp = signer
+	case c.KeyPairFile != "":
+		unlocker := &util.KeyUnlocker{}
+		if len(c.KMS) > 0 {
+			unlocker.KMS = util.CommandKMS(c.KMS)
+		}
+		keyPair, err := unlocker.ReadKeyPairFromFile(c.resolve(c.KeyPairFile))
+		if err != nil {
+			return lines.errorf("keypair", "could not read key pair: %s", err)
+		}
+		kp = keyPair
+	default:
+		return lines.errorf("", "keypair or signer must be set")
+	}
+	c.keyPair = kp
+
+	if len(c.Network.Servers) == 0 {
+		return lines.errorf("network", "network.servers must list at least one server")
+	}
+	seen := make(map[string]bool)
+	for i, server := range c.Network.Servers {
+		prefix := fmt.Sprintf("network.servers.%d", i)
+		if _, err := util.ReadPublicKey(server.PublicKey); err != nil {
+			return lines.errorf(prefix+".publicKey", "invalid public key: %q", server.PublicKey)
+		}
+		if seen[server.PublicKey] {
+			return lines.errorf(prefix+".publicKey",
+				"public key is listed twice: %s", server.PublicKey)
+		}
+		seen[server.PublicKey] = true
+		if server.Host == "" {
+			return lines.errorf(prefix, "host must be set")
+		}
+		if !validPort(server.Port) {
+			return lines.errorf(prefix+".port", "invalid port: %d", server.Port)
+		}
+		if server.Onion != "" && !network.IsOnion(server.Onion) {
+			return lines.errorf(prefix+".onion", "not an onion address: %q", server.Onion)
+		}
+		if network.IsOnion(server.Host) && c.Network.Proxy == "" &&
+			server.PublicKey != kp.PublicKey().String() {
+			return lines.errorf(prefix+".host",
+				"reaching an onion address needs network.proxy to be set")
+		}
+	}
+	if c.Network.Proxy != "" {
+		if _, err := network.NewProxyDialer(c.Network.Proxy); err != nil {
+			return lines.errorf("network.proxy", "%s", err)
+		}
+	}
+	for _, t := range c.Network.Operations {
+		if _, ok := util.OperationTypeMap[t]; !ok {
+			return lines.errorf("network.operations", "unknown operation type: %q", t)
+		}
+	}
+	for _, client := range c.Network.Clients {
+		if _, err := c.NetworkConfig().ParseAddress(client); err != nil {
+			return lines.errorf("network.clients", "invalid client: %s", err)
+		}
+	}
+	for _, account := range c.Network.Priority {
+		if _, err := c.NetworkConfig().ParseAddress(account); err != nil {
+			return lines.errorf("network.priority", "invalid priority account: %s", err)
+		}
+	}
+	if c.Network.PriorityShare < 0 || c.Network.PriorityShare > 100 {
+		return lines.errorf("network.priorityShare",
+			"priorityShare must be between 0 and 100")
+	}
+	if c.Network.PrivateQueries && len(c.Network.Clients) == 0 {
+		return lines.errorf("network.privateQueries",
+			"privateQueries needs network.clients to be set")
+	}
+	if err := c.NetworkConfig().Check(); err != nil {
+		return lines.errorf("network.quic", "%s", err)
+	}
+	if err := c.NetworkConfig().CheckName(); err != nil {
+		return lines.errorf("network.name", "%s", err)
+	}
+	if !seen[kp.PublicKey().String()] {
+		return lines.errorf("keypair",
+			"the key pair's public key %s is not in network.servers", kp.PublicKey())
+	}
+	n := 0
+	for _, server := range c.Network.Servers {
+		if !server.Standby {
+			n++
+		}
+	}
+	if c.Network.Threshold < 1 || c.Network.Threshold > n {
+		return lines.errorf("network.threshold",
+			"threshold must be between 1 and the number of validators, %d", n)
+	}
+
+	if db := c.Database; db != nil {
+		if db.Database == "" {
+			return lines.errorf("database", "database.database must be set")
+		}
+		if db.Host == "" {
+			return lines.errorf("database", "database.host must be set")
+		}
+		if !validPort(db.Port) {
+			return lines.errorf("database.port", "invalid port: %d", db.Port)
+		}
+		counts := []struct {
+			key   string
+			value int
+		}{
+			{"maxOpenConns", db.MaxOpenConns},
+			{"maxIdleConns", db.MaxIdleConns},
+			{"statementTimeoutMillis", db.StatementTimeoutMillis},
+			{"commitInterval", db.CommitInterval},
+			{"accountCacheSize", db.AccountCacheSize},
+			{"documentRevisions", db.DocumentRevisions},
+		}
+		for _, count := range counts {
+			if count.value < 0 {
+				return lines.errorf("database."+count.key, "%s cannot be negative", count.key)
+			}
+		}
+		if err := db.CheckDurability(); err != nil {
+			return lines.errorf("database.durability", "%s", err)
+		}
+	}
+
+	if _, err := network.NewACL(c.Network.Allow, nil); err != nil {
+		return lines.errorf("network.allow", "%s", err)
+	}
+	if _, err := network.NewACL(nil, c.Network.Deny); err != nil {
+		return lines.errorf("network.deny", "%s", err)
+	}
+
+	if c.API.HTTPPort != 0 && !validPort(c.API.HTTPPort) {
+		return lines.errorf("api.httpPort", "invalid port: %d", c.API.HTTPPort)
+	}
+	if c.API.ClientPort != 0 {
+		if !validPort(c.API.ClientPort) {
+			return lines.errorf("api.clientPort", "invalid port: %d", c.API.ClientPort)
+		}
+		for _, server := range c.Network.Servers {
+			if server.PublicKey == kp.PublicKey().String() && server.Port == c.API.ClientPort {
+				return lines.errorf("api.clientPort",
+					"clientPort must be different from the validator port, %d", server.Port)
+			}
+		}
+		if c.API.ClientPort == c.API.HTTPPort {
+			return lines.errorf("api.clientPort",
+				"clientPort must be different from httpPort, %d", c.API.HTTPPort)
+		}
+	}
+	if c.API.AdminPort != 0 {
+		if !validPort(c.API.AdminPort) {
+			return lines.errorf("api.adminPort", "invalid port: %d", c.API.AdminPort)
+		}
+		for _, server := range c.Network.Servers {
+			if server.PublicKey == kp.PublicKey().String() && server.Port == c.API.AdminPort {
+				return lines.errorf("api.adminPort",
+					"adminPort must be different from the validator port, %d", server.Port)
+			}
+		}
+		if c.API.AdminPort == c.API.HTTPPort || c.API.AdminPort == c.API.ClientPort {
+			return lines.errorf("api.adminPort",
+				"adminPort must be different from httpPort and clientPort")
+		}
+	}
+	if c.API.GraphDir != "" {
+		if info, err := os.Stat(c.GraphDir()); err != nil || !info.IsDir() {
+			return lines.errorf("api.graphDir", "graphDir is not a directory: %q", c.API.GraphDir)
+		}
+	}
+	if c.API.OTLPEndpoint != "" && !validURL(c.API.OTLPEndpoint) {
+		return lines.errorf("api.otlpEndpoint",
+			"otlpEndpoint must be an http or https url: %q", c.API.OTLPEndpoint)
+	}
+
+	for i, webhook := range c.Webhooks {
+		prefix := fmt.Sprintf("webhooks.%d", i)
+		if !validURL(webhook.URL) {
+			return lines.errorf(prefix+".url",
+				"webhook url must be an http or https url: %q", webhook.URL)
+		}
+		if webhook.Secret == "" {
+			return lines.errorf(prefix, "webhook secret must be set")
+		}
+		for _, account := range webhook.Accounts {
+			if _, err := c.NetworkConfig().ParseAddress(account); err != nil {
+				return lines.errorf(prefix+".accounts", "invalid account: %q", account)
+			}
+		}
+	}
+
+	if c.Alerting != nil {
+		if err := c.Alerting.validate(lines); err != nil {
+			return err
+		}
+	}
+
+	if c.Bus != nil {
+		if err := c.Bus.Check(); err != nil {
+			return lines.errorf("bus", "%s", err)
+		}
+	}
+	return nil
+}
+
+func (a *AlertingConfig) validate(lines *lineIndex) error {
+	if a.MinFreeDiskPercent < 0 || a.MinFreeDiskPercent >= 100 {
+		return lines.errorf("alerting.minFreeDiskPercent",
+			"minFreeDiskPercent must be between 0 and 100: %d", a.MinFreeDiskPercent)
+	}
+	if a.MinPeers < 0 {
+		return lines.errorf("alerting.minPeers", "minPeers cannot be negative")
+	}
+	if a.MaxInvalidSignatures < 0 {
+		return lines.errorf("alerting.maxInvalidSignatures",
+			"maxInvalidSignatures cannot be negative")
+	}
+	for i, sink := range a.Sinks {
+		prefix := fmt.Sprintf("alerting.sinks.%d", i)
+		if sink.MinSeverity != "" && !network.ValidSeverity(sink.MinSeverity) {
+			return lines.errorf(prefix+".minSeverity", "unknown severity: %q", sink.MinSeverity)
+		}
+		switch sink.Kind {
+		case network.AlertSinkWebhook:
+			if !validURL(sink.URL) {
+				return lines.errorf(prefix+".url",
+					"alert webhook url must be an http or https url: %q", sink.URL)
+			}
+			if sink.Secret == "" {
+				return lines.errorf(prefix, "alert webhook secret must be set")
+			}
+		case network.AlertSinkSMTP:
+			if sink.Host == "" {
+				return lines.errorf(prefix, "smtp alerts need a host")
+			}
+			if !validPort(sink.Port) {
+				return lines.errorf(prefix+".port", "invalid port: %d", sink.Port)
+			}
+			if sink.From == "" || len(sink.To) == 0 {
+				return lines.errorf(prefix, "smtp alerts need from and to addresses")
+			}
+		case network.AlertSinkPagerDuty:
+			if sink.RoutingKey == "" {
+				return lines.errorf(prefix, "pagerduty alerts need a routingKey")
+			}
+			if sink.URL != "" && !validURL(sink.URL) {
+				return lines.errorf(prefix+".url",
+					"pagerduty url must be an http or https url: %q", sink.URL)
+			}
+		default:
+			return lines.errorf(prefix, "unknown alert sink kind: %q", sink.Kind)
+		}
+	}
+	return nil
+}
+
+// KeyPair returns this node's key pair, or the remote signer that holds it.
+func (c *Config) KeyPair() util.Signer {
+	return c.keyPair
+}
+
+// NetworkConfig returns the network config in the form the network package uses.
+func (c *Config) NetworkConfig() *network.Config {
+	answer := &network.Config{
+		Name:       c.Network.Name,
+		Servers:    make(map[string]*network.Address),
+		Threshold:  c.Network.Threshold,
+		Encrypt:    c.Network.Encrypt,
+		Proxy:      c.Network.Proxy,
+		QUIC:       c.Network.QUIC,
+		Operations: c.Network.Operations,
+	}
+	if named, err := network.LookupNetwork(c.Network.Name); err == nil {
+		answer.AddressPrefix = named.AddressPrefix
+		answer.Decimals = named.Decimals
+	}
+	for _, client := range c.Network.Clients {
+		// validate already checked these
+		publicKey, _ := answer.ParseAddress(client)
+		answer.Clients = append(answer.Clients, publicKey)
+	}
+	answer.PrivateQueries = c.Network.PrivateQueries
+	for _, account := range c.Network.Priority {
+		publicKey, _ := answer.ParseAddress(account)
+		answer.Priority = append(answer.Priority, publicKey)
+	}
+	answer.PriorityShare = c.Network.PriorityShare
+	for _, server := range c.Network.Servers {
+		answer.Servers[server.PublicKey] = &network.Address{
+			Host:  server.Host,
+			Port:  server.Port,
+			Onion: server.Onion,
+		}
+		if server.Standby {
+			answer.Standby = append(answer.Standby, server.PublicKey)
+		}
+	}
+	return answer
+}
+
+// ACL returns which addresses can connect to the validator port, or nil if
+// any address can.
+func (c *Config) ACL() *network.ACL {
+	if len(c.Network.Allow) == 0 && len(c.Network.Deny) == 0 {
+		return nil
+	}
+	acl, err := network.NewACL(c.Network.Allow, c.Network.Deny)
+	if err != nil {
+		// validate already checked these
+		panic(err)
+	}
+	return acl
+}
+
+// DatabaseConfig returns the database config, or nil if there is no database.
+func (c *Config) DatabaseConfig() *data.Config {
+	return c.Database
+}
+
+// WebhookConfigs returns the webhooks in the form the network package uses.
+func (c *Config) WebhookConfigs() []*network.WebhookConfig {
+	answer := []*network.WebhookConfig{}
+	for _, webhook := range c.Webhooks {
+		// Accounts can be addresses, but webhooks match public keys
+		accounts := []string{}
+		for _, account := range webhook.Accounts {
+			publicKey, _ := c.NetworkConfig().ParseAddress(account)
+			accounts = append(accounts, publicKey)
+		}
+		answer = append(answer, &network.WebhookConfig{
+			URL:      webhook.URL,
+			Secret:   webhook.Secret,
+			Accounts: accounts,
+		})
+	}
+	return answer
+}
+
+// AdminAddress returns the address to serve diagnostics on, or "" if they
+// are off.
+func (c *Config) AdminAddress() string {
+	if c.API.AdminPort == 0 {
+		return ""
+	}
+	host := c.API.AdminHost
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	return fmt.Sprintf("%s:%d", host, c.API.AdminPort)
+}
+
+// resolve makes a path from the config file relative to its directory.
+func (c *Config) resolve(path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(c.dir, path)
+}
+
+// resolveSigner is the signer address, with a relative unix socket path
+// resolved like other paths.
+func (c *Config) resolveSigner() string {
+	if kind, address := network.SignerAddress(c.Signer); kind == "unix" {
+		return "unix:" + c.resolve(address)
+	}
+	return c.Signer
+}
+
+// GraphDir returns the directory to write consensus graphs to, or "" if
+// there is none.
+func (c *Config) GraphDir() string {
+	return c.resolve(c.API.GraphDir)
+}
+
+// TraceFile returns the file to record a trace to, or "" if there is none.
+func (c *Config) TraceFile() string {
+	return c.resolve(c.API.TraceFile)
+}
+
+// AlertConfigs returns the alert sinks in the form the network package uses.
+func (c *Config) AlertConfigs() []*network.AlertConfig {
+	answer := []*network.AlertConfig{}
+	if c.Alerting == nil {
+		return answer
+	}
+	for _, sink := range c.Alerting.Sinks {
+		answer = append(answer, &network.AlertConfig{
+			Kind:        sink.Kind,
+			MinSeverity: sink.MinSeverity,
+			URL:         sink.URL,
+			Secret:      sink.Secret,
+			RoutingKey:  sink.RoutingKey,
+			SMTPHost:    sink.Host,
+			SMTPPort:    sink.Port,
+			Username:    sink.Username,
+			Password:    sink.Password,
+			From:        sink.From,
+			To:          sink.To,
+		})
+	}
+	return answer
+}
+
+// HealthThresholds returns when to raise alerts, filling in defaults.
+func (c *Config) HealthThresholds() network.HealthThresholds {
+	answer := network.DefaultHealthThresholds()
+	if c.Alerting == nil {
+		return answer
+	}
+	answer.DiskPath = c.Alerting.DiskPath
+	answer.MinPeers = c.Alerting.MinPeers
+	if c.Alerting.MinFreeDiskPercent != 0 {
+		answer.MinFreeDiskPercent = c.Alerting.MinFreeDiskPercent
+	}
+	if c.Alerting.MaxInvalidSignatures != 0 {
+		answer.MaxInvalidSignatures = c.Alerting.MaxInvalidSignatures
+	}
+	return answer
+}
+
+// String describes the config without revealing any secrets.
+func (c *Config) String() string {
+	parts := []string{fmt.Sprintf("keypair=%s", c.KeyPairFile)}
+	if c.Signer != "" {
+		parts[0] = fmt.Sprintf("signer=%s", c.Signer)
+	}
+	if len(c.KMS) > 0 {
+		parts = append(parts, fmt.Sprintf("kms=%s", c.KMS[0]))
+	}
+	keys := []string{}
+	for _, server := range c.Network.Servers {
+		keys = append(keys, fmt.Sprintf("%s:%d", server.Host, server.Port))
+	}
+	sort.Strings(keys)
+	parts = append(parts, fmt.Sprintf("servers=%s threshold=%d",
+		strings.Join(keys, ","), c.Network.Threshold))
+	if c.Database != nil {
+		parts = append(parts, fmt.Sprintf("database=%s@%s:%d",
+			c.Database.Database, c.Database.Host, c.Database.Port))
+	}
+	if c.Bus != nil {
+		parts = append(parts, fmt.Sprintf("bus=%s:%s", c.Bus.Kind, c.Bus.Topic))
+	}
+	if len(c.Webhooks) > 0 {
+		parts = append(parts, fmt.Sprintf("webhooks=%d", len(c.Webhooks)))
+	}
+	if c.Alerting != nil && len(c.Alerting.Sinks) > 0 {
+		parts = append(parts, fmt.Sprintf("alerts=%d", len(c.Alerting.Sinks)))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,292 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lacker/coinkit/network"
+	"github.com/lacker/coinkit/util"
+)
+
+func TestLoadLocalConfigs(t *testing.T) {
+	for i := 0; i < 4; i++ {
+		c, err := Load(fmt.Sprintf("../local/node%d.toml", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		net := c.NetworkConfig()
+		if len(net.Servers) != 4 || net.Threshold != 3 {
+			t.Fatalf("bad network config: %+v", net)
+		}
+		if net.Servers[c.KeyPair().PublicKey().String()] == nil {
+			t.Fatalf("node %d is not in its own network", i)
+		}
+		db := c.DatabaseConfig()
+		if db == nil || db.Database != fmt.Sprintf("local%d", i) || db.Port != 5432 {
+			t.Fatalf("bad database config: %+v", db)
+		}
+		if c.API.HTTPPort != 8000+i {
+			t.Fatalf("bad api config: %+v", c.API)
+		}
+	}
+}
+
+const validConfig = `keypair = "keypair0.json"
+
+[network]
+threshold = 1
+
+[[network.servers]]
+publicKey = "0xf5138d1de5046cdd3810a8d597b7781c00f5533120d7c958d48a319eb4f776e66da7"
+host = "127.0.0.1"
+port = 9000
+`
+
+func TestNoDatabase(t *testing.T) {
+	c, err := Parse([]byte(validConfig), "../local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.DatabaseConfig() != nil {
+		t.Fatalf("expected no database")
+	}
+}
+
+func TestStandbyServers(t *testing.T) {
+	standby := validConfig + `
+[[network.servers]]
+publicKey = "0x16671098b839751225b5656910585a3a3f92f34903f78570413aa5db70d4972b7bff"
+host = "127.0.0.1"
+port = 9001
+standby = true
+`
+	c, err := Parse([]byte(standby), "../local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	net := c.NetworkConfig()
+	qs := net.QuorumSlice()
+	if len(net.Servers) != 2 || len(net.Standby) != 1 || len(qs.Members) != 1 {
+		t.Fatalf("bad network config: %+v", net)
+	}
+
+	// Standby servers don't count toward the threshold
+	expectError(t, strings.Replace(standby, "threshold = 1", "threshold = 2", 1),
+		4, "number of validators")
+}
+
+func TestAccessControl(t *testing.T) {
+	source := strings.Replace(validConfig, "threshold = 1",
+		"threshold = 1\nallow = [\"10.0.0.0/8\"]\ndeny = [\"10.0.0.1/32\"]", 1) +
+		"\n[api]\nclientPort = 9100\n"
+	c, err := Parse([]byte(source), "../local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.API.ClientPort != 9100 || c.ACL() == nil {
+		t.Fatalf("bad access control config: %s", c.ACL())
+	}
+
+	c, err = Parse([]byte(validConfig), "../local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ACL() != nil {
+		t.Fatal("with no allow or deny, every address should be allowed")
+	}
+}
+
+func TestAdminAddress(t *testing.T) {
+	c, err := Parse([]byte(validConfig+"\n[api]\nadminPort = 6060\n"), "../local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.AdminAddress() != "127.0.0.1:6060" {
+		t.Fatalf("diagnostics should default to localhost but got %q", c.AdminAddress())
+	}
+
+	c, err = Parse([]byte(validConfig), "../local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.AdminAddress() != "" || c.GraphDir() != "" {
+		t.Fatal("diagnostics should be off by default")
+	}
+
+	c, err = Parse([]byte(validConfig+"\n[api]\ngraphDir = \".\"\n"), "../local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.GraphDir() != "../local" {
+		t.Fatalf("graphDir should be relative to the config file but got %q", c.GraphDir())
+	}
+}
+
+func TestEncryptedKeyPair(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	kp, err := util.ReadKeyPairFromFile("../local/keypair0.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A KMS that wraps keys by not doing anything to them
+	script := filepath.Join(dir, "kms.sh")
+	if err := ioutil.WriteFile(script, []byte("cat\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	e, err := util.EncryptKeyPairWithKMS(kp, util.CommandKMS{"sh", script})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "keypair0.json")
+	if err := ioutil.WriteFile(filename, e.Serialize(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Parse([]byte(validConfig), dir); err == nil ||
+		!strings.Contains(err.Error(), "no KMS") {
+		t.Fatalf("expected an error about the missing KMS but got: %v", err)
+	}
+	kms := fmt.Sprintf("kms = [\"sh\", %q]\n", script)
+	c, err := Parse([]byte(kms+validConfig), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.KeyPair().PublicKey() != kp.PublicKey() {
+		t.Fatal("the KMS unlocked the wrong key pair")
+	}
+}
+
+func TestRemoteSigner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	kp, err := util.ReadKeyPairFromFile("../local/keypair0.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := "a secret that is long enough"
+	if err := ioutil.WriteFile(filepath.Join(dir, "secret"), []byte(secret+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("unix", filepath.Join(dir, "signer.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go network.ServeSigner(listener, kp, []byte(secret))
+
+	source := strings.Replace(validConfig, `keypair = "keypair0.json"`,
+		"signer = \"unix:signer.sock\"\nsignerSecret = \"secret\"", 1)
+	c, err := Parse([]byte(source), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.KeyPair().PublicKey() != kp.PublicKey() {
+		t.Fatal("the signer has the wrong key pair")
+	}
+	if strings.Contains(c.String(), secret) {
+		t.Fatal("the config should not show the secret")
+	}
+
+	expectError(t, "signer = \"unix:/nonexistent\"\n"+validConfig, 1, "both")
+	expectError(t, strings.Replace(source, "signerSecret = \"secret\"", "", 1), 1,
+		"signerSecret must be set")
+}
+
+// expectError checks that parsing fails on the expected line, with an error
+// message containing substring.
+func expectError(t *testing.T, source string, line int, substring string) {
+	_, err := Parse([]byte(source), "../local")
+	if err == nil {
+		t.Fatalf("expected an error for:\n%s", source)
+	}
+	if !strings.Contains(err.Error(), fmt.Sprintf("line %d", line)) ||
+		!strings.Contains(err.Error(), substring) {
+		t.Fatalf("expected an error on line %d about %q but got: %s",
+			line, substring, err)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	// Syntax errors
+	expectError(t, strings.Replace(validConfig, "threshold = 1", "threshold = 1 1", 1), 4, "")
+
+	// Type errors
+	expectError(t, strings.Replace(validConfig, "threshold = 1", `threshold = "one"`, 1),
+		4, "threshold")
+
+	// Unknown keys
+	expectError(t, validConfig+"colour = \"blue\"\n", 10, "colour")
+	expectError(t, validConfig+"\n[api]\nhtppPort = 8000\n", 12, "htppPort")
+
+	// Values out of range
+	expectError(t, strings.Replace(validConfig, "port = 9000", "port = 99999", 1),
+		9, "invalid port")
+	expectError(t, strings.Replace(validConfig, "threshold = 1", "threshold = 2", 1),
+		4, "threshold")
+	expectError(t, strings.Replace(validConfig, `"0xf5`, `"0xf6`, 1),
+		7, "public key")
+	expectError(t, validConfig+"\n[database]\ndatabase = \"x\"\nhost = \"h\"\nport = 1\n"+
+		"durability = \"sometimes\"\n", 15, "durability")
+	expectError(t, validConfig+"\n[api]\notlpEndpoint = \"localhost:4318\"\n", 12, "otlp")
+
+	expectError(t, validConfig+"\n[[webhooks]]\nurl = \"http://example.com\"\n", 11, "secret")
+	expectError(t, validConfig+"\n[[webhooks]]\nurl = \"example.com\"\n", 12, "webhook url")
+
+	expectError(t, validConfig+"\n[bus]\nkind = \"rabbit\"\n", 11, "unknown bus kind")
+
+	expectError(t, validConfig+"\n[[alerting.sinks]]\nkind = \"sms\"\n", 11, "unknown alert sink")
+	expectError(t, validConfig+"\n[[alerting.sinks]]\nkind = \"pagerduty\"\n"+
+		"routingKey = \"k\"\nminSeverity = \"bad\"\n", 14, "severity")
+	expectError(t, validConfig+"\n[[alerting.sinks]]\nkind = \"smtp\"\nhost = \"h\"\n"+
+		"port = 25\n", 11, "from and to")
+	expectError(t, validConfig+"\n[alerting]\nminFreeDiskPercent = 100\n", 12, "minFreeDiskPercent")
+
+	expectError(t, strings.Replace(validConfig, "threshold = 1",
+		"threshold = 1\ndeny = [\"10.0.0.0/33\"]", 1), 5, "invalid CIDR")
+	expectError(t, validConfig+"\n[api]\nclientPort = 9000\n", 12, "validator port")
+	expectError(t, validConfig+"\n[api]\nhttpPort = 8000\nadminPort = 8000\n", 13, "adminPort")
+	expectError(t, validConfig+"\n[api]\ngraphDir = \"nonexistent\"\n", 12, "graphDir")
+	expectError(t, strings.Replace(validConfig, "threshold = 1",
+		"threshold = 1\nproxy = \"tor\"", 1), 5, "socks5")
+	expectError(t, strings.Replace(validConfig, "threshold = 1",
+		"threshold = 1\nproxy = \"socks5://127.0.0.1:9050\"\nquic = true", 1), 6, "quic")
+	expectError(t, strings.Replace(validConfig, "threshold = 1",
+		"threshold = 1\noperations = [\"Send\", \"Document\"]", 1), 5, "Document")
+	expectError(t, validConfig+"onion = \"example.com\"\n", 10, "onion address")
+	expectError(t, validConfig+`
+[[network.servers]]
+publicKey = "0x16671098b839751225b5656910585a3a3f92f34903f78570413aa5db70d4972b7bff"
+host = "coinkitexample.onion"
+port = 9001
+`, 13, "network.proxy")
+
+	expectError(t, strings.Replace(validConfig, "threshold = 1",
+		"name = \"local\"\nthreshold = 1", 1), 4, "network ID")
+	expectError(t, strings.Replace(validConfig, "threshold = 1",
+		"name = \"testnet\"\nthreshold = 1", 1), 4, "not launched")
+
+	expectError(t, strings.Replace(validConfig, "threshold = 1",
+		"threshold = 1\nclients = [\"0x1234\"]", 1), 5, "invalid client")
+	expectError(t, strings.Replace(validConfig, "threshold = 1",
+		"threshold = 1\nprivateQueries = true", 1), 5, "clients")
+	expectError(t, strings.Replace(validConfig, "threshold = 1",
+		"threshold = 1\npriority = [\"0x1234\"]", 1), 5, "invalid priority")
+	expectError(t, strings.Replace(validConfig, "threshold = 1",
+		"threshold = 1\npriorityShare = 200", 1), 5, "priorityShare")
+
+	// The node has to be in its own network
+	expectError(t, strings.Replace(validConfig, "keypair0", "keypair1", 1),
+		1, "not in network.servers")
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A lineIndex maps dotted key paths to the line they are defined on, so that
+// validation errors can point at the right line.
+// It only understands as much TOML as we need for that: table headers, array
+// table headers, and key/value lines. Keys inside the nth entry of an array
+// table are indexed both as "table.n.key" and as "table.key".
+type lineIndex struct {
+	lines map[string]int
+}
+
+func newLineIndex(source []byte) *lineIndex {
+	index := &lineIndex{lines: make(map[string]int)}
+	arrayCounts := make(map[string]int)
+	prefixes := []string{}
+	for i, line := range strings.Split(string(source), "\n") {
+		number := i + 1
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[[") {
+			end := strings.Index(line, "]]")
+			if end < 0 {
+				continue
+			}
+			name := strings.TrimSpace(line[2:end])
+			n := arrayCounts[name]
+			arrayCounts[name] = n + 1
+			prefixes = []string{fmt.Sprintf("%s.%d", name, n), name}
+			index.add(prefixes, number)
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			end := strings.Index(line, "]")
+			if end < 0 {
+				continue
+			}
+			prefixes = []string{strings.TrimSpace(line[1:end])}
+			index.add(prefixes, number)
+			continue
+		}
+
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			continue
+		}
+		key := strings.Trim(strings.TrimSpace(line[:eq]), `"'`)
+		if len(prefixes) == 0 {
+			index.add([]string{key}, number)
+			continue
+		}
+		paths := []string{}
+		for _, prefix := range prefixes {
+			paths = append(paths, prefix+"."+key)
+		}
+		index.add(paths, number)
+	}
+	return index
+}
+
+// add records the line for paths that have not been seen yet.
+func (index *lineIndex) add(paths []string, line int) {
+	for _, path := range paths {
+		if _, ok := index.lines[path]; !ok {
+			index.lines[path] = line
+		}
+	}
+}
+
+// line returns the line where path is defined. If path isn't defined, it
+// falls back to the closest parent that is. It returns zero if there is
+// nothing to point at.
+func (index *lineIndex) line(path string) int {
+	for path != "" {
+		if line, ok := index.lines[path]; ok {
+			return line
+		}
+		dot := strings.LastIndex(path, ".")
+		if dot < 0 {
+			break
+		}
+		path = path[:dot]
+	}
+	return 0
+}
+
+// errorf makes an error that points at the line for path.
+func (index *lineIndex) errorf(path string, format string, a ...interface{}) *Error {
+	return &Error{
+		Line:    index.line(path),
+		Message: fmt.Sprintf(format, a...),
+	}
+}
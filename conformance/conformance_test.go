@@ -0,0 +1,90 @@
+package conformance
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"testing"
+
+	"github.com/lacker/coinkit/util"
+)
+
+var update = flag.Bool("update", false,
+	"rewrite testdata/fixtures.json and the wire fixtures for the current version")
+
+const fixturesFile = "testdata/fixtures.json"
+
+func TestFixtures(t *testing.T) {
+	generated, err := json.MarshalIndent(Generate(), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	generated = append(generated, '\n')
+	if *update {
+		if err := ioutil.WriteFile(fixturesFile, generated, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bytes, err := ioutil.ReadFile(fixturesFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &Fixtures{}
+	if err := json.Unmarshal(bytes, f); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if string(bytes) != string(generated) {
+		t.Fatalf("%s is out of date. if the protocol changed on purpose, "+
+			"regenerate it with go test ./conformance -update", fixturesFile)
+	}
+}
+
+func readWire(t *testing.T, version int) []*MessageFixture {
+	bytes, err := ioutil.ReadFile(WireFile(version))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixtures := []*MessageFixture{}
+	if err := json.Unmarshal(bytes, &fixtures); err != nil {
+		t.Fatal(err)
+	}
+	return fixtures
+}
+
+func TestWire(t *testing.T) {
+	generated, err := json.MarshalIndent(GenerateWire(), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	generated = append(generated, '\n')
+	current := WireFile(util.ProtocolVersion)
+	if *update {
+		if err := ioutil.WriteFile(current, generated, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bytes, err := ioutil.ReadFile(current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bytes) != string(generated) {
+		t.Fatalf("%s is out of date. if the protocol changed on purpose, bump "+
+			"util.ProtocolVersion and regenerate it with go test ./conformance -update", current)
+	}
+
+	for version := 1; version <= util.ProtocolVersion; version++ {
+		fixtures := readWire(t, version)
+		if version < util.MinProtocolVersion {
+			err = CheckWireUnsupported(fixtures)
+		} else {
+			err = CheckWire(fixtures)
+		}
+		if err != nil {
+			t.Fatalf("version %d: %s", version, err)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package conformance
+
+// Fixtures for checking that other implementations, like wallets in other
+// languages, agree with this one. testdata/fixtures.json has the current
+// fixtures. Everything in them is deterministic, so regenerating them only
+// changes them when the protocol changes.
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/util"
+)
+
+// All fixture messages are signed at this time, in milliseconds since the
+// epoch.
+const Timestamp = int64(1700000000000)
+
+type KeyPairFixture struct {
+	// util.NewKeyPairFromSecretPhrase uses the SHA-512/256 of the phrase as
+	// the ed25519 seed.
+	Phrase string `json:"phrase"`
+
+	// The public key, hex-encoded with a "0x" prefix and a checksum
+	PublicKey string `json:"publicKey"`
+
+	// The ed25519 private key, base64-encoded without padding
+	PrivateKey string `json:"privateKey"`
+}
+
+type OperationFixture struct {
+	Description string `json:"description"`
+
+	// A signed operation as it appears in transaction messages and blocks
+	SignedOperation json.RawMessage `json:"signedOperation"`
+}
+
+type MessageFixture struct {
+	Description string `json:"description"`
+
+	// Who signed the message
+	Signer string `json:"signer"`
+
+	// The message as it is sent over the network, without the newline
+	Serialized string `json:"serialized"`
+}
+
+type ChunkFixture struct {
+	Description string `json:"description"`
+
+	// The accounts before the chunk is processed
+	Before map[string]*currency.Account `json:"before"`
+
+	// The ledger chunk, which is the value that gets externalized for a block
+	Chunk json.RawMessage `json:"chunk"`
+
+	// The chunk's hash, which blocks are identified by. It's the SHA-512/256
+	// of each operation's signature in order, then each account in State
+	// sorted by key, as the key followed by the little-endian uint32 sequence
+	// and uint64 balance. Accounts with data then have each data key and value,
+	// sorted by key, each as a little-endian uint32 length and the bytes.
+	// It is base64-encoded without padding.
+	Hash string `json:"hash"`
+}
+
+type Fixtures struct {
+	KeyPairs   []*KeyPairFixture   `json:"keyPairs"`
+	Operations []*OperationFixture `json:"operations"`
+	Messages   []*MessageFixture   `json:"messages"`
+	Chunks     []*ChunkFixture     `json:"chunks"`
+}
+
+func mustMarshal(v interface{}) json.RawMessage {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return bytes
+}
+
+// signAt signs a message the way util.NewSignedMessage does, but at a fixed
+// time, and serializes it.
+func signAt(m util.Message, kp *util.KeyPair, timestamp int64) string {
+	ms := util.EncodeMessage(m)
+	canonical, err := util.CanonicalJSON([]byte(ms))
+	if err != nil {
+		panic(err)
+	}
+	signature := kp.Sign(fmt.Sprintf("%d:%s", timestamp, canonical))
+	return fmt.Sprintf("c:%s:%s:%d:%s", kp.PublicKey(), signature, timestamp, ms)
+}
+
+// Generate creates the fixtures.
+func Generate() *Fixtures {
+	f := &Fixtures{}
+	keyPairs := make(map[string]*util.KeyPair)
+	for _, phrase := range []string{"mint", "alice", "bob"} {
+		kp := util.NewKeyPairFromSecretPhrase(phrase)
+		keyPairs[phrase] = kp
+		serialized := &util.SerializedKeyPair{}
+		if err := json.Unmarshal(kp.Serialize(), serialized); err != nil {
+			panic(err)
+		}
+		f.KeyPairs = append(f.KeyPairs, &KeyPairFixture{
+			Phrase:     phrase,
+			PublicKey:  serialized.Public,
+			PrivateKey: serialized.Private,
+		})
+	}
+	alice := keyPairs["alice"]
+	bob := keyPairs["bob"]
+
+	send := util.NewSignedOperation(&currency.SendOperation{
+		Signer:   alice.PublicKey().String(),
+		Sequence: 1,
+		To:       bob.PublicKey().String(),
+		Amount:   1250000000,
+		Fee:      1,
+	}, alice)
+	vote := util.NewSignedOperation(&currency.ValidatorOperation{
+		Signer:     bob.PublicKey().String(),
+		Sequence:   1,
+		Action:     currency.ValidatorAdd,
+		Validator:  alice.PublicKey().String(),
+		Threshold:  2,
+		Activation: 100,
+	}, bob)
+	f.Operations = []*OperationFixture{
+		{"alice sends 1.25 coins to bob with a fee of 1 nanocoin", mustMarshal(send)},
+		{"bob votes to add alice as a validator", mustMarshal(vote)},
+	}
+
+	f.Messages = []*MessageFixture{
+		{
+			"alice sends her operation to a node",
+			alice.PublicKey().String(),
+			signAt(currency.NewTransactionMessage(send), alice, Timestamp),
+		},
+		{
+			"bob asks a node for alice's account",
+			bob.PublicKey().String(),
+			signAt(&util.InfoMessage{Account: alice.PublicKey().String()}, bob, Timestamp),
+		},
+	}
+
+	before := map[string]*currency.Account{
+		alice.PublicKey().String(): {Sequence: 0, Balance: 5000000000},
+	}
+	accounts := currency.NewAccountMapFromState(before)
+	if !accounts.Process(send.Operation) {
+		panic("the send fixture is invalid")
+	}
+	chunk := &currency.LedgerChunk{
+		Operations: []*util.SignedOperation{send},
+		State: map[string]*currency.Account{
+			alice.PublicKey().String(): accounts.Get(alice.PublicKey().String()),
+			bob.PublicKey().String():   accounts.Get(bob.PublicKey().String()),
+		},
+	}
+	f.Chunks = []*ChunkFixture{{
+		Description: "a block with alice's send in it",
+		Before:      before,
+		Chunk:       mustMarshal(chunk),
+		Hash:        string(chunk.Hash()),
+	}}
+	return f
+}
+
+// Check returns an error if this implementation disagrees with any of the
+// fixtures.
+func (f *Fixtures) Check() error {
+	for _, k := range f.KeyPairs {
+		kp := util.NewKeyPairFromSecretPhrase(k.Phrase)
+		if kp.PublicKey().String() != k.PublicKey {
+			return fmt.Errorf("phrase %q should have public key %s", k.Phrase, k.PublicKey)
+		}
+		serialized := mustMarshal(&util.SerializedKeyPair{
+			Public:  k.PublicKey,
+			Private: k.PrivateKey,
+		})
+		if _, err := util.DeserializeKeyPair(serialized); err != nil {
+			return fmt.Errorf("bad key pair for %q: %s", k.Phrase, err)
+		}
+	}
+
+	for _, o := range f.Operations {
+		op := &util.SignedOperation{}
+		if err := json.Unmarshal(o.SignedOperation, op); err != nil {
+			return fmt.Errorf("%s: %s", o.Description, err)
+		}
+		if !op.Verify() {
+			return fmt.Errorf("%s: the operation does not verify", o.Description)
+		}
+	}
+
+	for _, m := range f.Messages {
+		sm, err := util.NewSignedMessageFromSerialized(m.Serialized)
+		if err != nil {
+			return fmt.Errorf("%s: %s", m.Description, err)
+		}
+		if sm.Signer() != m.Signer {
+			return fmt.Errorf("%s: signed by %s, not %s", m.Description, sm.Signer(), m.Signer)
+		}
+	}
+
+	for _, c := range f.Chunks {
+		chunk := &currency.LedgerChunk{}
+		if err := json.Unmarshal(c.Chunk, chunk); err != nil {
+			return fmt.Errorf("%s: %s", c.Description, err)
+		}
+		if string(chunk.Hash()) != c.Hash {
+			return fmt.Errorf("%s: the hash is %s, not %s", c.Description, chunk.Hash(), c.Hash)
+		}
+		if !currency.NewAccountMapFromState(c.Before).ProcessChunk(chunk) {
+			return fmt.Errorf("%s: the chunk does not apply to the accounts before it",
+				c.Description)
+		}
+	}
+	return nil
+}
@@ -44,6 +44,12 @@ type BallotMessage interface {
 	// provides information for.
 	RelevantRange(x SlotValue) (int, int)
 
+	// Boundaries returns the ballot numbers where what this message says
+	// about this slot value can change. Between two adjacent boundaries,
+	// every ballot number gets the same answer from AcceptAsPrepared,
+	// VoteToPrepare, AcceptAsCommitted, and VoteToCommit.
+	Boundaries(x SlotValue) []int
+
 	// Returns the highest ballot number that this message says anything about.
 	MaxN() int
 
@@ -74,7 +80,7 @@ func (p Phase) String() string {
 	case Externalize:
 		return "Externalize"
 	default:
-		panic(fmt.Sprintf("unknown phase: %+v", p))
+		panic(fmt.Sprintf("unknown phase: %d", int(p)))
 	}
 }
 
@@ -161,6 +167,10 @@ func (m *PrepareMessage) QuorumSlice() QuorumSlice {
 	return m.D
 }
 
+func (m *PrepareMessage) CheckLimits() error {
+	return m.D.checkLimits(m)
+}
+
 func (m *PrepareMessage) Phase() Phase {
 	return Prepare
 }
@@ -208,17 +218,21 @@ func (m *PrepareMessage) CouldEverVoteFor(n int, x SlotValue) bool {
 }
 
 func (m *PrepareMessage) RelevantRange(x SlotValue) (int, int) {
-	min, max := 0, 0
+	return MakeRange(m.Boundaries(x)...)
+}
+
+func (m *PrepareMessage) Boundaries(x SlotValue) []int {
+	answer := []int{}
 	if x == m.Bx {
-		min, max = MakeRange(m.Bn, m.Cn, m.Hn)
+		answer = append(answer, m.Bn, m.Cn, m.Hn)
 	}
 	if x == m.Px {
-		min, max = RangeUnion(min, max, m.Pn, m.Pn)
+		answer = append(answer, m.Pn)
 	}
 	if x == m.Ppx {
-		min, max = RangeUnion(min, max, m.Ppn, m.Ppn)
+		answer = append(answer, m.Ppn)
 	}
-	return min, max
+	return answer
 }
 
 func (m *PrepareMessage) MaxN() int {
@@ -249,7 +263,11 @@ type ConfirmMessage struct {
 	// The value that we are accepting a commit for.
 	X SlotValue
 
-	// state.p.n
+	// state.b.n, the ballot number we are working on. Its value is X.
+	// It's never below Hn. Older nodes don't send it.
+	Bn int `json:",omitempty"`
+
+	// state.p.n, the highest ballot number we accept as prepared for X
 	Pn int
 
 	// The range of ballot numbers we accept a commit for.
@@ -260,14 +278,18 @@ type ConfirmMessage struct {
 }
 
 func (m *ConfirmMessage) String() string {
-	return fmt.Sprintf("confirm i=%d x=%s p=%d ch=%d,%d",
-		m.I, util.Shorten(string(m.X)), m.Pn, m.Cn, m.Hn)
+	return fmt.Sprintf("confirm i=%d x=%s b=%d p=%d ch=%d,%d",
+		m.I, util.Shorten(string(m.X)), m.Bn, m.Pn, m.Cn, m.Hn)
 }
 
 func (m *ConfirmMessage) QuorumSlice() QuorumSlice {
 	return m.D
 }
 
+func (m *ConfirmMessage) CheckLimits() error {
+	return m.D.checkLimits(m)
+}
+
 func (m *ConfirmMessage) Phase() Phase {
 	return Confirm
 }
@@ -276,20 +298,24 @@ func (m *ConfirmMessage) MessageType() string {
 	return "C"
 }
 
+// A confirm message accepts that (p.n, x) is prepared, and votes to prepare
+// every ballot for x, since it will never vote for anything else.
+// See page 24 of the Mazieres paper.
 func (m *ConfirmMessage) AcceptAsPrepared(n int, x SlotValue) bool {
-	return m.X == x
+	return m.X == x && n <= m.Pn
 }
 
 func (m *ConfirmMessage) VoteToPrepare(n int, x SlotValue) bool {
-	return false
+	return m.X == x
 }
 
 func (m *ConfirmMessage) AcceptAsCommitted(n int, x SlotValue) bool {
 	return m.X == x && m.Cn <= n && n <= m.Hn
 }
 
+// A confirm message votes to commit every ballot for x from c.n up.
 func (m *ConfirmMessage) VoteToCommit(n int, x SlotValue) bool {
-	return m.X == x
+	return m.X == x && m.Cn <= n
 }
 
 func (m *ConfirmMessage) CouldEverVoteFor(n int, x SlotValue) bool {
@@ -297,20 +323,27 @@ func (m *ConfirmMessage) CouldEverVoteFor(n int, x SlotValue) bool {
 }
 
 func (m *ConfirmMessage) RelevantRange(x SlotValue) (int, int) {
+	return MakeRange(m.Boundaries(x)...)
+}
+
+func (m *ConfirmMessage) Boundaries(x SlotValue) []int {
 	if x == m.X {
-		return MakeRange(m.Pn, m.Cn, m.Hn)
+		return []int{m.Pn, m.Cn, m.Hn}
 	}
-	return 0, 0
+	return nil
 }
 
 func (m *ConfirmMessage) MaxN() int {
-	ns := []int{m.Pn, m.Cn, m.Hn}
+	ns := []int{m.Bn, m.Pn, m.Cn, m.Hn}
 	sort.Ints(ns)
 	return ns[len(ns)-1]
 }
 
 func (m *ConfirmMessage) BallotNumber() int {
-	return m.Hn
+	if m.Bn < m.Hn {
+		return m.Hn
+	}
+	return m.Bn
 }
 
 func (m *ConfirmMessage) Slot() int {
@@ -342,6 +375,10 @@ func (m *ExternalizeMessage) QuorumSlice() QuorumSlice {
 	return m.D
 }
 
+func (m *ExternalizeMessage) CheckLimits() error {
+	return m.D.checkLimits(m)
+}
+
 func (m *ExternalizeMessage) Phase() Phase {
 	return Externalize
 }
@@ -371,10 +408,14 @@ func (m *ExternalizeMessage) CouldEverVoteFor(n int, x SlotValue) bool {
 }
 
 func (m *ExternalizeMessage) RelevantRange(x SlotValue) (int, int) {
+	return MakeRange(m.Boundaries(x)...)
+}
+
+func (m *ExternalizeMessage) Boundaries(x SlotValue) []int {
 	if x == m.X {
-		return MakeRange(m.Cn, m.Hn)
+		return []int{m.Cn, m.Hn}
 	}
-	return 0, 0
+	return nil
 }
 
 func (m *ExternalizeMessage) MaxN() int {
@@ -394,6 +435,7 @@ func (m *ExternalizeMessage) Slot() int {
 // 1 if ballot1 > ballot2
 // Ballots are ordered by:
 // (phase, b, p, p prime, h)
+// Confirm messages have no p prime.
 // This is only intended to be used to compare messages coming from the same node.
 func Compare(ballot1 BallotMessage, ballot2 BallotMessage) int {
 	phase1 := ballot1.Phase()
@@ -434,6 +476,12 @@ func Compare(ballot1 BallotMessage, ballot2 BallotMessage) int {
 		return 0
 	case *ConfirmMessage:
 		b2 := ballot2.(*ConfirmMessage)
+		if b1.BallotNumber() < b2.BallotNumber() {
+			return -1
+		}
+		if b1.BallotNumber() > b2.BallotNumber() {
+			return 1
+		}
 		if b1.Pn < b2.Pn {
 			return -1
 		}
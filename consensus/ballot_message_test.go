@@ -0,0 +1,16 @@
+package consensus
+
+import (
+	"testing"
+)
+
+// Formatting an unknown phase with %v would call String again and recurse
+// until the stack overflows, instead of panicking with the phase.
+func TestUnknownPhaseString(t *testing.T) {
+	defer func() {
+		if r := recover(); r != "unknown phase: 7" {
+			t.Fatalf("expected a panic about phase 7 but got %v", r)
+		}
+	}()
+	t.Fatalf("an unknown phase should not have a name: %s", Phase(7).String())
+}
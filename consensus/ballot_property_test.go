@@ -0,0 +1,216 @@
+package consensus
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+
+	"github.com/lacker/coinkit/util"
+)
+
+// These are property tests for the ballot state. testing/quick generates
+// scripts of well-formed but arbitrary ballot messages, and the tests check
+// invariants that have to hold no matter what other nodes send.
+
+// The values that generated messages use. There are only a few, so that
+// messages conflict with each other a lot.
+var propertyValues = []SlotValue{"apple", "banana", "cherry"}
+
+func randomValue(r *rand.Rand) SlotValue {
+	return propertyValues[r.Intn(len(propertyValues))]
+}
+
+// randomBallotMessage makes a well-formed ballot message with small ballot
+// numbers, so that different messages are often about the same ballots.
+func randomBallotMessage(r *rand.Rand, qs QuorumSlice) BallotMessage {
+	bn := 1 + r.Intn(5)
+	switch r.Intn(4) {
+	case 0, 1:
+		m := &PrepareMessage{I: 1, Bn: bn, Bx: randomValue(r), D: qs}
+		if r.Intn(2) == 0 {
+			m.Pn = 1 + r.Intn(bn)
+			m.Px = randomValue(r)
+			if m.Pn > 1 && r.Intn(2) == 0 {
+				m.Ppn = 1 + r.Intn(m.Pn-1)
+				for m.Ppx = randomValue(r); m.Ppx == m.Px; m.Ppx = randomValue(r) {
+				}
+			}
+		}
+		if r.Intn(2) == 0 {
+			m.Hn = 1 + r.Intn(bn)
+			if r.Intn(2) == 0 {
+				m.Cn = 1 + r.Intn(m.Hn)
+			}
+		}
+		return m
+	case 2:
+		hn := 1 + r.Intn(5)
+		return &ConfirmMessage{
+			I: 1, X: randomValue(r), Bn: hn + r.Intn(3), Pn: hn + r.Intn(3),
+			Cn: 1 + r.Intn(hn), Hn: hn, D: qs,
+		}
+	default:
+		hn := 1 + r.Intn(5)
+		return &ExternalizeMessage{I: 1, X: randomValue(r), Cn: 1 + r.Intn(hn), Hn: hn, D: qs}
+	}
+}
+
+type ballotStep struct {
+	// Which member of the quorum slice sends the message
+	sender int
+
+	message BallotMessage
+}
+
+// A ballotScript is a sequence of messages for the first member of a four
+// node quorum slice to handle, from the other three.
+type ballotScript struct {
+	seed  int64
+	steps []*ballotStep
+}
+
+// Generate implements quick.Generator.
+func (ballotScript) Generate(r *rand.Rand, size int) reflect.Value {
+	qs, _ := MakeTestQuorumSlice(4)
+	script := ballotScript{seed: r.Int63()}
+	for i := 0; i < 10+size; i++ {
+		script.steps = append(script.steps, &ballotStep{
+			sender:  1 + r.Intn(3),
+			message: randomBallotMessage(r, qs),
+		})
+	}
+	return reflect.ValueOf(script)
+}
+
+// ballotSnapshot is the part of a ballot state the invariants compare over
+// time.
+type ballotSnapshot struct {
+	phase Phase
+	b     *Ballot
+	p     *Ballot
+}
+
+func snapshot(s *BallotState) ballotSnapshot {
+	return ballotSnapshot{phase: s.phase, b: s.b, p: s.p}
+}
+
+// checkBallotInvariants returns a description of the first invariant that
+// the state breaks, given what it was before handling a message, or "" if
+// it breaks none.
+func checkBallotInvariants(before ballotSnapshot, s *BallotState) string {
+	if s.cn > s.hn {
+		return "c is above h"
+	}
+	if s.p != nil && s.pPrime != nil {
+		if s.p.x == s.pPrime.x {
+			return "p and p prime are compatible"
+		}
+		if s.pPrime.n > s.p.n {
+			return "p prime is above p"
+		}
+	}
+	if s.pPrime != nil && s.p == nil {
+		return "there is a p prime without a p"
+	}
+	if s.phase < before.phase {
+		return "the phase went backwards"
+	}
+	if before.p != nil && (s.p == nil || s.p.n < before.p.n) {
+		return "p went down"
+	}
+	if before.phase != Prepare && s.b.x != before.b.x {
+		return "the value changed after accepting a commit"
+	}
+	if s.phase != Prepare && s.cn == 0 {
+		return "a commit was accepted for an empty range"
+	}
+	if s.phase == Confirm {
+		if s.p == nil || s.p.x != s.b.x || s.p.n < s.hn {
+			return "p is not a prepared ballot for our value in the Confirm phase"
+		}
+		if s.pPrime != nil {
+			return "there is a p prime in the Confirm phase"
+		}
+		if s.b.n < s.hn {
+			return "b is below h in the Confirm phase"
+		}
+	}
+	if before.b != nil && s.b != nil && s.b.n < before.b.n {
+		return "b went down"
+	}
+	return ""
+}
+
+func TestBallotStateInvariants(t *testing.T) {
+	property := func(script ballotScript) bool {
+		qs, names := MakeTestQuorumSlice(4)
+		vs := NewTestValueStore(0)
+		nState := NewNominationState(names[0], qs, vs)
+		nState.NominateNewValue(randomValue(rand.New(rand.NewSource(script.seed))))
+		s := NewBallotState(names[0], qs, nState)
+		s.GoToNextBallot()
+
+		for i, step := range script.steps {
+			before := snapshot(s)
+			s.Handle(names[step.sender].String(), step.message)
+			if problem := checkBallotInvariants(before, s); problem != "" {
+				t.Logf("after step %d, %s handling %s", i, problem, step.message)
+				s.Show()
+				return false
+			}
+		}
+		return true
+	}
+	config := &quick.Config{MaxCount: int(util.GetTestLoopLength(200, 20000))}
+	if err := quick.Check(property, config); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A clusterScript decides the order messages get delivered in among three
+// honest blocks, and what a fourth, faulty one sends them.
+type clusterScript struct {
+	seed int64
+}
+
+// Generate implements quick.Generator.
+func (clusterScript) Generate(r *rand.Rand, size int) reflect.Value {
+	return reflect.ValueOf(clusterScript{seed: r.Int63()})
+}
+
+func TestBallotSafetyWithFaultyNode(t *testing.T) {
+	property := func(script clusterScript) bool {
+		r := rand.New(rand.NewSource(script.seed))
+		blocks := blockCluster(4)
+		honest, faulty := blocks[0:3], blocks[3]
+		for i := 0; i < 300 && !allDone(honest); i++ {
+			target := honest[r.Intn(3)]
+			if r.Intn(4) == 0 {
+				m := util.EncodeThenDecodeMessage(randomBallotMessage(r, faulty.D))
+				target.Handle(faulty.publicKey.String(), m)
+			} else {
+				blockSend(honest[r.Intn(3)], target)
+			}
+		}
+
+		// Two honest nodes must never externalize different values
+		var value SlotValue
+		for _, block := range honest {
+			if !block.Done() {
+				continue
+			}
+			if value == "" {
+				value = block.external.X
+			} else if block.external.X != value {
+				t.Logf("externalized both %s and %s", value, block.external.X)
+				return false
+			}
+		}
+		return true
+	}
+	config := &quick.Config{MaxCount: int(util.GetTestLoopLength(50, 5000))}
+	if err := quick.Check(property, config); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -121,7 +121,12 @@ func (s *BallotState) QuorumSlice(node string) (*QuorumSlice, bool) {
 
 // MaybeAcceptAsPrepared returns true if the ballot state changes.
 func (s *BallotState) MaybeAcceptAsPrepared(n int, x SlotValue) bool {
-	if s.phase != Prepare {
+	if s.phase == Externalize {
+		return false
+	}
+	if s.phase == Confirm && x != s.b.x {
+		// In the Confirm phase, p only matters for our own value, since
+		// we have accepted the abort of everything else
 		return false
 	}
 	if n == 0 {
@@ -147,8 +152,9 @@ func (s *BallotState) MaybeAcceptAsPrepared(n int, x SlotValue) bool {
 	// Or, if a local blocking set has accepted, we can accept.
 	votedOrAccepted := []string{}
 	accepted := []string{}
-	if s.b != nil && s.b.n >= n && s.b.x == x {
-		// We have voted for this
+	if s.b != nil && s.b.x == x && (s.b.n >= n || s.phase == Confirm) {
+		// We have voted for this. In the Confirm phase we vote to prepare
+		// every ballot for our value.
 		votedOrAccepted = append(votedOrAccepted, s.publicKey.String())
 	}
 
@@ -319,6 +325,11 @@ func (s *BallotState) MaybeAcceptAsCommitted(n int, x SlotValue) bool {
 		// We already do accept this commit
 		return false
 	}
+	if s.AcceptedAbort(n, x) {
+		// We can't accept both a commit and its abort, even if a blocking
+		// set that is lying to us does
+		return false
+	}
 
 	votedOrAccepted := []string{}
 	accepted := []string{}
@@ -346,16 +357,21 @@ func (s *BallotState) MaybeAcceptAsCommitted(n int, x SlotValue) bool {
 	s.Logf("accepts as committed: %s", &Ballot{n: n, x: x})
 
 	// We accept this commit
-	s.phase = Confirm
-	if s.b == nil || s.b.x != x {
-		// Totally replace our old target value
-		s.b = &Ballot{
-			n: n,
-			x: x,
-		}
+	if s.phase == Prepare {
+		// In the Prepare phase, cn and hn were the range we voted to
+		// commit, so the range of acceptance starts over.
+		s.phase = Confirm
 		s.cn = n
 		s.hn = n
 		s.z = &x
+
+		// Accepting a commit means accepting that it's prepared. p prime
+		// only mattered for aborting our votes to commit, and that can't
+		// happen any more.
+		if s.p == nil || s.p.x != x || s.p.n < n {
+			s.p = &Ballot{n: n, x: x}
+		}
+		s.pPrime = nil
 	} else {
 		// Just update our range of acceptance
 		if n < s.cn {
@@ -364,6 +380,18 @@ func (s *BallotState) MaybeAcceptAsCommitted(n int, x SlotValue) bool {
 		if n > s.hn {
 			s.hn = n
 		}
+		if s.p.n < n {
+			s.p = &Ballot{n: n, x: x}
+		}
+	}
+
+	// Our ballot is never below the highest commit we accept
+	if s.b == nil || s.b.x != x || s.b.n < s.hn {
+		bn := s.hn
+		if s.b != nil && s.b.n > bn {
+			bn = s.b.n
+		}
+		s.b = &Ballot{n: bn, x: x}
 	}
 	return true
 }
@@ -417,6 +445,11 @@ func (s *BallotState) MaybeConfirmAsCommitted(n int, x SlotValue) bool {
 
 // GoToNextBallot returns whether we could actually go to the next ballot.
 func (s *BallotState) GoToNextBallot() bool {
+	if s.phase == Externalize {
+		// There are no more ballots once we externalize
+		return false
+	}
+
 	b := &Ballot{}
 
 	if s.b == nil {
@@ -426,7 +459,10 @@ func (s *BallotState) GoToNextBallot() bool {
 		b.n = s.b.n + 1
 	}
 
-	if s.z != nil {
+	if s.phase == Confirm {
+		// Once we accept a commit, we can only vote for its value
+		b.x = s.b.x
+	} else if s.z != nil {
 		b.x = *s.z
 	} else {
 		if !s.nState.HasNomination() {
@@ -456,9 +492,15 @@ func (s *BallotState) CheckForBlockedBallot() bool {
 	// Nodes that could never vote for our ballot
 	blockers := []string{}
 
+	// The highest ballot number the blockers say anything about
+	maxN := 0
+
 	for node, m := range s.M {
 		if !m.CouldEverVoteFor(s.b.n, s.b.x) {
 			blockers = append(blockers, node)
+			if m.MaxN() > maxN {
+				maxN = m.MaxN()
+			}
 		}
 	}
 
@@ -466,6 +508,14 @@ func (s *BallotState) CheckForBlockedBallot() bool {
 		return false
 	}
 
+	if s.b.n > maxN {
+		// We are past every ballot the blockers mention, so they are
+		// committed to other values, and no ballot number would unblock us.
+		// Without this check, conflicting confirm messages from a blocking
+		// set would make Handle bump the ballot number forever.
+		return false
+	}
+
 	return s.GoToNextBallot()
 }
 
@@ -515,14 +565,16 @@ func (s *BallotState) CheckIfStale() {
 // Update the stage of this ballot as needed
 // See the handling algorithm on page 24 of the Mazieres paper.
 // The investigate method does steps 1-8
-func (s *BallotState) InvestigateBallot(n int, x SlotValue) {
+// Returns whether anything in the ballot state changed.
+func (s *BallotState) InvestigateBallot(n int, x SlotValue) bool {
 	if n < 1 {
-		return
+		return false
 	}
-	s.MaybeAcceptAsPrepared(n, x)
-	s.MaybeConfirmAsPrepared(n, x)
-	s.MaybeAcceptAsCommitted(n, x)
-	s.MaybeConfirmAsCommitted(n, x)
+	changed := s.MaybeAcceptAsPrepared(n, x)
+	changed = s.MaybeConfirmAsPrepared(n, x) || changed
+	changed = s.MaybeAcceptAsCommitted(n, x) || changed
+	changed = s.MaybeConfirmAsCommitted(n, x) || changed
+	return changed
 }
 
 // RelevantRange returns the range of ballots that at least one of our
@@ -566,21 +618,56 @@ func (s *BallotState) MaxActionableBallotNumber() int {
 	return 0
 }
 
+// Boundaries returns the ballot numbers where either our own votes or what
+// our peers say about this slot value can change.
+func (s *BallotState) Boundaries(x SlotValue) []int {
+	answer := []int{s.cn, s.hn}
+	for _, ballot := range []*Ballot{s.b, s.p, s.pPrime} {
+		if ballot != nil {
+			answer = append(answer, ballot.n)
+		}
+	}
+	for _, message := range s.M {
+		answer = append(answer, message.Boundaries(x)...)
+	}
+	return answer
+}
+
 // InvestigateValue checks if any information can be updated for this value.
+// Messages can talk about long ranges of ballot numbers, like a Confirm
+// message that accepts the commit of everything from cn to hn. Rather than
+// checking every number in the range, we split it into intervals that every
+// message treats the same way, and check the ends of each interval. The low
+// end extends any range we accept or confirm downward, the high end extends
+// it upward.
+// Changing our state can move our own boundaries, so we repeat until nothing
+// changes.
 func (s *BallotState) InvestigateValue(x SlotValue) {
-	min, max := s.RelevantRange(x)
-	maxActionable := s.MaxActionableBallotNumber()
-	if max > maxActionable {
-		max = maxActionable
-	}
+	for {
+		min, max := s.RelevantRange(x)
+		maxActionable := s.MaxActionableBallotNumber()
+		if max > maxActionable {
+			max = maxActionable
+		}
+		if min < 1 {
+			min = 1
+		}
 
-	i := min
-	for ; i <= max; i++ {
-		s.InvestigateBallot(i, x)
-	}
+		changed := false
+		for _, interval := range Partition(min, max, s.Boundaries(x)) {
+			changed = s.InvestigateBallot(interval.Min, x) || changed
+			if interval.Max > interval.Min {
+				changed = s.InvestigateBallot(interval.Max, x) || changed
+			}
+		}
 
-	if s.b != nil && i <= s.b.n {
-		s.InvestigateBallot(s.b.n, x)
+		if s.b != nil && s.b.n > max {
+			changed = s.InvestigateBallot(s.b.n, x) || changed
+		}
+
+		if !changed {
+			return
+		}
 	}
 }
 
@@ -706,17 +793,15 @@ func (s *BallotState) Message(slot int, qs QuorumSlice) BallotMessage {
 		return m
 
 	case Confirm:
-		m := &ConfirmMessage{
+		return &ConfirmMessage{
 			I:  slot,
 			X:  s.b.x,
+			Bn: s.b.n,
+			Pn: s.p.n,
 			Cn: s.cn,
 			Hn: s.hn,
 			D:  qs,
 		}
-		if s.p != nil {
-			m.Pn = s.p.n
-		}
-		return m
 
 	case Externalize:
 		return &ExternalizeMessage{
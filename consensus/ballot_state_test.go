@@ -0,0 +1,72 @@
+package consensus
+
+import (
+	"testing"
+)
+
+// Peers can talk about ballot ranges far too long to check one number at a
+// time. The ballot state should still act on the whole range.
+func TestLongBallotRange(t *testing.T) {
+	// With seven nodes, three are a blocking set but not enough for a quorum,
+	// so we accept the commit without confirming it.
+	qs, names := MakeTestQuorumSlice(7)
+	vs := NewTestValueStore(0)
+	nState := NewNominationState(names[0], qs, vs)
+	nState.NominateNewValue("apple")
+	s := NewBallotState(names[0], qs, nState)
+	s.GoToNextBallot()
+
+	hn := 1000000000
+	for _, name := range names[1:4] {
+		s.Handle(name.String(), &ConfirmMessage{I: 1, X: "apple", Pn: hn, Cn: 5, Hn: hn, D: qs})
+	}
+	if s.phase != Confirm {
+		t.Fatalf("expected to accept the commit, but the phase is %s", s.phase)
+	}
+	if s.cn != 5 || s.hn != hn {
+		t.Fatalf("expected to accept the commit of 5..%d, but got %d..%d", hn, s.cn, s.hn)
+	}
+
+	for _, name := range names[4:] {
+		s.Handle(name.String(), &ConfirmMessage{I: 1, X: "apple", Pn: hn, Cn: 7, Hn: hn, D: qs})
+	}
+	if s.phase != Externalize {
+		t.Fatalf("expected to externalize, but the phase is %s", s.phase)
+	}
+	if s.b.x != "apple" || s.cn < 7 || s.hn > hn {
+		t.Fatalf("confirmed a commit of %s %d..%d", s.b.x, s.cn, s.hn)
+	}
+}
+
+// The Confirm phase keeps track of b and p for its value, and sends them.
+func TestConfirmMessageFields(t *testing.T) {
+	qs, names := MakeTestQuorumSlice(7)
+	vs := NewTestValueStore(0)
+	nState := NewNominationState(names[0], qs, vs)
+	nState.NominateNewValue("apple")
+	s := NewBallotState(names[0], qs, nState)
+	s.GoToNextBallot()
+
+	for _, name := range names[1:4] {
+		s.Handle(name.String(), &ConfirmMessage{I: 1, X: "apple", Bn: 3, Pn: 3, Cn: 2, Hn: 3, D: qs})
+	}
+	m, ok := s.Message(1, qs).(*ConfirmMessage)
+	if !ok {
+		t.Fatalf("expected a confirm message but got %s", s.Message(1, qs))
+	}
+	if m.X != "apple" || m.Bn != 3 || m.Pn != 3 || m.Cn != 2 || m.Hn != 3 {
+		t.Fatalf("bad confirm message: %s", m)
+	}
+	if s.pPrime != nil {
+		t.Fatalf("there should be no p prime in the Confirm phase, but it is %s", s.pPrime)
+	}
+
+	// A blocking set accepting a higher prepare should raise p
+	for _, name := range names[1:4] {
+		s.Handle(name.String(), &ConfirmMessage{I: 1, X: "apple", Bn: 8, Pn: 8, Cn: 2, Hn: 3, D: qs})
+	}
+	m = s.Message(1, qs).(*ConfirmMessage)
+	if m.Pn != 8 || m.Cn != 2 || m.Hn != 3 {
+		t.Fatalf("bad confirm message: %s", m)
+	}
+}
@@ -34,7 +34,14 @@ type Block struct {
 
 func NewBlock(
 	publicKey util.PublicKey, qs QuorumSlice, slot int, vs ValueStore) *Block {
-	nState := NewNominationState(publicKey, qs, vs)
+	return newBlock(publicKey, qs, slot, vs, nil)
+}
+
+// newBlock makes a block whose leaders are picked by VRF priority if there
+// is a prover, and by hash priority otherwise.
+func newBlock(publicKey util.PublicKey, qs QuorumSlice, slot int, vs ValueStore,
+	prover util.Signer) *Block {
+	nState := newNominationState(publicKey, qs, vs, vs.Last(), slot, prover)
 	nState.MaybeNominateNewValue()
 	block := &Block{
 		slot:      slot,
@@ -47,6 +54,39 @@ func NewBlock(
 	return block
 }
 
+// newPipelinedBlock makes a block for the slot after one that hasn't been
+// finalized yet, but that has accepted a commit for prev. It only listens to
+// nominations until Resume is called.
+func newPipelinedBlock(publicKey util.PublicKey, qs QuorumSlice, slot int, vs ValueStore,
+	prev SlotValue, prover util.Signer) *Block {
+	nState := newNominationState(publicKey, qs, vs, prev, slot, prover)
+	nState.waiting = true
+	return &Block{
+		slot:      slot,
+		nState:    nState,
+		bState:    NewBallotState(publicKey, qs, nState),
+		values:    vs,
+		D:         qs,
+		publicKey: publicKey,
+	}
+}
+
+// Resume starts work on a pipelined block once the previous slot is
+// finalized.
+func (b *Block) Resume() {
+	b.nState.Resume()
+}
+
+// committed returns the value this block has accepted a commit for, if any.
+// Once we accept a commit, that value is the only one the block can
+// externalize.
+func (b *Block) committed() (SlotValue, bool) {
+	if b.bState.phase < Confirm || b.bState.b == nil {
+		return "", false
+	}
+	return b.bState.b.x, true
+}
+
 func (block *Block) AssertValid() {
 	block.nState.AssertValid()
 	block.bState.AssertValid()
@@ -68,6 +108,10 @@ func (b *Block) OutgoingMessages() []util.Message {
 	// because other nodes use that to figure out when they should start
 	// nominating something.
 	answer := []util.Message{b.nState.Message(b.slot, b.D)}
+	if b.nState.waiting {
+		// We can't ballot until the previous slot is finalized
+		return answer
+	}
 
 	// If we aren't working on any ballot, try to start working on a ballot
 	if b.bState.b == nil {
@@ -86,9 +130,16 @@ func (b *Block) Done() bool {
 	return b.external != nil
 }
 
+// NextRound starts the next nomination round, unless the block is done.
+func (b *Block) NextRound() {
+	if b.external == nil {
+		b.nState.NextRound()
+	}
+}
+
 // ValueStoreUpdated should be called when the value store is updated.
 func (b *Block) ValueStoreUpdated() {
-	b.nState.MaybeNominateNewValue()
+	b.nState.ValueStoreUpdated()
 }
 
 // Handle handles an incoming message
@@ -97,6 +148,11 @@ func (b *Block) Handle(sender string, message util.Message) {
 		// It's one of our own returning to us, we can ignore it
 		return
 	}
+	if _, ok := message.(*NominationMessage); !ok && b.nState.waiting {
+		// Ballots can't be judged until the previous slot is finalized.
+		// Peers keep sending their latest ballot, so it will come again.
+		return
+	}
 	switch m := message.(type) {
 	case *NominationMessage:
 		b.nState.Handle(sender, m)
@@ -0,0 +1,73 @@
+package consensus
+
+// A BlockState is a snapshot of the consensus state of a block, for debugging
+// stuck ballots. Ballots are rendered like they are in the logs, as (n,x)
+// with x shortened. Empty means the ballot is nil.
+type BlockState struct {
+	Slot  int
+	Phase string
+
+	// The nomination state
+	X       []SlotValue
+	Y       []SlotValue
+	Z       []SlotValue
+	Round   int
+	Leaders []string
+
+	// The ballot state
+	B      string
+	P      string
+	PPrime string
+	Cn     int
+	Hn     int
+
+	// The value for the next ballot, if there is a confirmed prepared one
+	NextValue SlotValue `json:",omitempty"`
+
+	// The last nomination and ballot messages from each peer
+	Nominations map[string]*NominationMessage
+	Ballots     map[string]BallotMessage
+}
+
+func ballotString(b *Ballot) string {
+	if b == nil {
+		return ""
+	}
+	return b.String()
+}
+
+// State returns a snapshot of the block's state. It copies everything that
+// the block modifies later, so it is safe to read from another goroutine.
+func (b *Block) State() *BlockState {
+	answer := &BlockState{
+		Slot:        b.slot,
+		Phase:       b.bState.phase.String(),
+		X:           append([]SlotValue{}, b.nState.X...),
+		Y:           append([]SlotValue{}, b.nState.Y...),
+		Z:           append([]SlotValue{}, b.nState.Z...),
+		Round:       b.nState.round,
+		Leaders:     append([]string{}, b.nState.leaders...),
+		B:           ballotString(b.bState.b),
+		P:           ballotString(b.bState.p),
+		PPrime:      ballotString(b.bState.pPrime),
+		Cn:          b.bState.cn,
+		Hn:          b.bState.hn,
+		Nominations: make(map[string]*NominationMessage),
+		Ballots:     make(map[string]BallotMessage),
+	}
+	if b.bState.z != nil {
+		answer.NextValue = *b.bState.z
+	}
+	for peer, m := range b.nState.N {
+		answer.Nominations[peer] = m
+	}
+	for peer, m := range b.bState.M {
+		answer.Ballots[peer] = m
+	}
+	return answer
+}
+
+// State returns a snapshot of the block this chain is working on.
+func (c *Chain) State() *BlockState {
+	return c.current.State()
+}
@@ -24,71 +24,117 @@ func TestSolipsistQuorum(t *testing.T) {
 }
 
 func TestConsensus(t *testing.T) {
-	apk := util.NewKeyPairFromSecretPhrase("amy").PublicKey()
-	bpk := util.NewKeyPairFromSecretPhrase("bob").PublicKey()
-	cpk := util.NewKeyPairFromSecretPhrase("cal").PublicKey()
-	dpk := util.NewKeyPairFromSecretPhrase("dan").PublicKey()
-	members := []string{
-		apk.String(),
-		bpk.String(),
-		cpk.String(),
-		dpk.String(),
+	keys := []util.PublicKey{}
+	members := []string{}
+	for _, name := range []string{"amy", "bob", "cal", "dan"} {
+		pk := util.NewKeyPairFromSecretPhrase(name).PublicKey()
+		keys = append(keys, pk)
+		members = append(members, pk.String())
 	}
 	qs := MakeQuorumSlice(members, 3)
 	vs := NewTestValueStore(0)
-	amy := NewBlock(apk, qs, 1, vs)
-	bob := NewBlock(bpk, qs, 1, vs)
-	cal := NewBlock(cpk, qs, 1, vs)
-	dan := NewBlock(dpk, qs, 1, vs)
 
-	// Let everyone receive an initial nomination from Amy
-	amy.nState.NominateNewValue(SlotValue("hello its amy"))
-	a := amy.OutgoingMessages()[0]
+	// Put the leader of the first nomination round first, since the others
+	// only echo what leaders nominate
+	leader := Leader(string(vs.Last()), 1, qs, members[0])
+	for i, pk := range keys {
+		if pk.String() == leader {
+			keys[0], keys[i] = keys[i], keys[0]
+		}
+	}
+	blocks := []*Block{}
+	for _, pk := range keys {
+		blocks = append(blocks, NewBlock(pk, qs, 1, vs))
+	}
+	first, second, third, fourth := blocks[0], blocks[1], blocks[2], blocks[3]
+
+	// The leader nominates right away
+	if !first.nState.HasNomination() {
+		t.Fatal("the leader should nominate right away")
+	}
+	if second.nState.HasNomination() {
+		t.Fatal("only the leader should nominate right away")
+	}
 
-	bob.Handle(apk.String(), a)
-	if len(bob.nState.N) != 1 {
-		t.Fatal("len(bob.nState.N) != 1")
+	// Let everyone receive an initial nomination from the leader
+	a := first.OutgoingMessages()[0]
+	second.Handle(first.publicKey.String(), a)
+	if len(second.nState.N) != 1 {
+		t.Fatal("len(second.nState.N) != 1")
 	}
-	cal.Handle(apk.String(), a)
-	dan.Handle(apk.String(), a)
+	third.Handle(first.publicKey.String(), a)
+	fourth.Handle(first.publicKey.String(), a)
 
 	// At this point everyone should have a nomination
-	if !amy.nState.HasNomination() {
-		t.Fatal("!amy.nState.HasNomination()")
+	for i, block := range blocks {
+		if !block.nState.HasNomination() {
+			t.Fatalf("block %d has no nomination", i)
+		}
 	}
-	if !bob.nState.HasNomination() {
-		t.Fatal("!bob.nState.HasNomination()")
+
+	// Once the second and third broadcast, everyone should have one accepted
+	// value, but still no candidates. This works even without the fourth,
+	// who has nothing accepted.
+	b := second.OutgoingMessages()[0]
+	first.Handle(second.publicKey.String(), b)
+	if len(first.nState.N) != 1 {
+		t.Fatalf("first.nState.N = %#v", first.nState.N)
 	}
-	if !cal.nState.HasNomination() {
-		t.Fatal("!cal.nState.HasNomination()")
+	third.Handle(second.publicKey.String(), b)
+	c := third.OutgoingMessages()[0]
+	first.Handle(third.publicKey.String(), c)
+	second.Handle(third.publicKey.String(), c)
+	for i, block := range blocks[:3] {
+		if len(block.nState.Y) != 1 {
+			t.Fatalf("block %d has %d accepted values", i, len(block.nState.Y))
+		}
+	}
+	if len(fourth.nState.Y) != 0 {
+		t.Fatal("len(fourth.nState.Y) != 0")
+	}
+}
+
+// Nodes that aren't leaders don't echo nominations until the sender leads a
+// round. Rounds are timed by received messages, so repeating a message
+// eventually gets it echoed.
+func TestNominationRounds(t *testing.T) {
+	qs, names := MakeTestQuorumSlice(4)
+	vs := NewTestValueStore(0)
+	leader := Leader(string(vs.Last()), 1, qs, names[0].String())
+	others := []util.PublicKey{}
+	for _, pk := range names {
+		if pk.String() != leader {
+			others = append(others, pk)
+		}
 	}
-	if !dan.nState.HasNomination() {
-		t.Fatal("!dan.nState.HasNomination()")
+	sender, receiver := others[0], others[1]
+
+	s := NewNominationState(receiver, qs, vs)
+	m := &NominationMessage{I: 1, Nom: []SlotValue{"foo"}, D: qs}
+	s.Handle(sender.String(), m)
+	if s.HasNomination() {
+		t.Fatal("should not echo a nomination from someone who is not a leader")
 	}
 
-	// Once bob and cal broadcast, everyone should have one accepted value,
-	// but still no candidates. This works even without dan, who has nothing
-	// accepted.
-	b := bob.OutgoingMessages()[0]
-	amy.Handle(bpk.String(), b)
-	if len(amy.nState.N) != 1 {
-		t.Fatalf("amy.nState.N = %#v", amy.nState.N)
+	// Repeating a message doesn't end the round
+	for i := 0; i < 1000; i++ {
+		s.Handle(sender.String(), m)
 	}
-	cal.Handle(bpk.String(), b)
-	c := cal.OutgoingMessages()[0]
-	amy.Handle(cpk.String(), c)
-	bob.Handle(cpk.String(), c)
-	if len(amy.nState.Y) != 1 {
-		t.Fatal("len(amy.nState.Y) != 1")
+	if s.Round() != 1 || s.HasNomination() {
+		t.Fatalf("messages should not advance the rounds, but we are on round %d", s.Round())
+	}
+
+	for i := 0; i < 1000 && !s.IsLeader(sender.String()); i++ {
+		s.NextRound()
 	}
-	if len(bob.nState.Y) != 1 {
-		t.Fatal("len(bob.nState.Y) != 1")
+	if !s.IsLeader(sender.String()) {
+		t.Fatal("the sender never got to lead a round")
 	}
-	if len(cal.nState.Y) != 1 {
-		t.Fatal("len(cal.nState.Y) != 1")
+	if !HasSlotValue(s.X, "foo") {
+		t.Fatal("should echo a leader's nomination")
 	}
-	if len(dan.nState.Y) != 0 {
-		t.Fatal("len(dan.nState.Y) != 0")
+	if RoundTimeout(s.Round()) <= RoundTimeout(1) {
+		t.Fatal("later rounds should last longer")
 	}
 }
 
@@ -210,13 +256,47 @@ func nominationConverged(blocks []*Block) bool {
 	return true
 }
 
+// roundTimer times out nomination rounds for tests, on a clock where each
+// step takes a hundredth of NominationTimeout.
+type roundTimer struct {
+	step    int
+	rounds  map[interface{}][2]int
+	started map[interface{}]int
+}
+
+func newRoundTimer() *roundTimer {
+	return &roundTimer{
+		rounds:  make(map[interface{}][2]int),
+		started: make(map[interface{}]int),
+	}
+}
+
+// timedOut returns whether the node with this id has been on this round of
+// this slot for long enough that the round is over.
+func (r *roundTimer) timedOut(id interface{}, slot int, round int) bool {
+	key := [2]int{slot, round}
+	if r.rounds[id] != key {
+		r.rounds[id] = key
+		r.started[id] = r.step
+		return false
+	}
+	return r.step-r.started[id] >= 100*round
+}
+
 func blockFuzzTest(blocks []*Block, seed int64, t *testing.T) {
 	rand.Seed(seed ^ 1234569)
 	util.Logger.Printf("fuzz testing blocks with seed %d", seed)
+	timer := newRoundTimer()
 	for i := 0; i < 10000; i++ {
 		j := rand.Intn(len(blocks))
 		k := rand.Intn(len(blocks))
 		blockSend(blocks[j], blocks[k])
+		timer.step++
+		for _, block := range blocks {
+			if timer.timedOut(block, block.slot, block.nState.Round()) {
+				block.NextRound()
+			}
+		}
 
 		if allDone(blocks) {
 			return
@@ -270,3 +350,44 @@ func TestBlockOneNodeKnockedOut(t *testing.T) {
 		blockFuzzTest(knockout, i, t)
 	}
 }
+
+// pickyValueStore only validates the values it knows.
+type pickyValueStore struct {
+	*TestValueStore
+	known map[SlotValue]bool
+}
+
+func (p *pickyValueStore) ValidateValue(v SlotValue) bool {
+	return p.known[v]
+}
+
+func TestNominationValidation(t *testing.T) {
+	qs, names := MakeTestQuorumSlice(4)
+	vs := &pickyValueStore{TestValueStore: NewTestValueStore(0), known: make(map[SlotValue]bool)}
+	leader := Leader(string(vs.Last()), 1, qs, names[0].String())
+	others := []util.PublicKey{}
+	for _, pk := range names {
+		if pk.String() != leader {
+			others = append(others, pk)
+		}
+	}
+	self := others[0]
+	s := NewNominationState(self, qs, vs)
+
+	// Neither the leader nor a blocking set can get us to support a value
+	// we can't validate
+	s.Handle(leader, &NominationMessage{I: 1, Nom: []SlotValue{"garbage"}, D: qs})
+	for _, pk := range others[1:] {
+		s.Handle(pk.String(), &NominationMessage{I: 1, Acc: []SlotValue{"garbage"}, D: qs})
+	}
+	if len(s.X) != 0 || len(s.Y) != 0 {
+		t.Fatalf("X = %v, Y = %v", s.X, s.Y)
+	}
+
+	// Once the value checks out, we should catch up
+	vs.known["garbage"] = true
+	s.ValueStoreUpdated()
+	if !HasSlotValue(s.X, "garbage") || !HasSlotValue(s.Y, "garbage") {
+		t.Fatalf("X = %v, Y = %v", s.X, s.Y)
+	}
+}
@@ -13,10 +13,27 @@ type Chain struct {
 	// The block we are currently working on
 	current *Block
 
+	// When pipelining, the block for the slot after current, which we start
+	// nominating once current has accepted a commit. Nil otherwise.
+	next *Block
+
+	// Whether we start nominating the next slot early
+	pipeline bool
+
+	// Proves our VRF output for each slot, when nomination leaders are picked
+	// by VRF priority. Nil picks them by hash priority
+	prover util.Signer
+
 	// history tracks blocks that have already been externalized
-	// TODO: just hold a single past block
 	history map[int]*ExternalizeMessage
 
+	// The oldest slot that history may still have
+	oldest int
+
+	// How many of the most recently externalized blocks we keep in history,
+	// to help other nodes catch up. Zero keeps all of them.
+	retention int
+
 	// The quorum logic we use for future blocks
 	D QuorumSlice
 
@@ -24,6 +41,13 @@ type Chain struct {
 	publicKey util.PublicKey
 
 	values ValueStore
+
+	// Told about each slot we externalize, after the value store
+	handlers []ExternalizeHandler
+
+	// Who took part in the last block we externalized. Nil if we didn't
+	// take part in it ourselves, because we caught up on it.
+	participation *Participation
 }
 
 func (c *Chain) Logf(format string, a ...interface{}) {
@@ -53,15 +77,17 @@ func (c *Chain) Handle(sender string, message util.Message) (util.Message, bool)
 		return nil, false
 	}
 
+	if c.next != nil && slot == c.next.slot {
+		c.next.Handle(sender, message)
+		return nil, false
+	}
+
 	if slot == c.current.slot {
 		c.current.Handle(sender, message)
 		if c.current.Done() && c.values.CanFinalize(c.current.external.X) {
-			// This block is done, let's move on to the next one
-			c.Logf("advancing to slot %d", slot+1)
-			c.values.Finalize(c.current.external.X)
-			c.history[slot] = c.current.external
-			c.current = NewBlock(c.publicKey, c.D, slot+1, c.values)
+			c.advance()
 		}
+		c.maybeStartNext()
 		return nil, false
 	}
 
@@ -82,6 +108,74 @@ func (c *Chain) Handle(sender string, message util.Message) (util.Message, bool)
 	return nil, false
 }
 
+// advance finalizes the current block and moves on to the next one.
+func (c *Chain) advance() {
+	slot := c.current.slot
+	x := c.current.external.X
+	c.Logf("advancing to slot %d", slot+1)
+	c.values.Externalized(slot, x)
+	c.history[slot] = c.current.external
+	c.participation = c.current.Participation()
+	if c.next != nil && c.next.nState.seed == string(x) {
+		// The pipelined block picked its leaders with the right value
+		c.current = c.next
+		c.current.Resume()
+	} else {
+		c.current = c.newBlock(slot + 1)
+	}
+	c.next = nil
+	c.prune()
+	for _, h := range c.handlers {
+		h.Externalized(slot, x)
+	}
+}
+
+// newBlock makes a block for a slot with the chain's quorum slice.
+func (c *Chain) newBlock(slot int) *Block {
+	return newBlock(c.publicKey, c.D, slot, c.values, c.prover)
+}
+
+// SetVRF makes the chain pick nomination leaders by VRF priority, proving
+// its own priority with kp, which must be the chain's key pair. Nil goes
+// back to hash priority. It should only be called before the current block
+// has handled any messages.
+// Every validator should turn it on at once, since validators without it
+// don't send proofs, so validators with it never pick them to lead.
+func (c *Chain) SetVRF(kp util.Signer) {
+	if kp != nil && !kp.PublicKey().Equal(c.publicKey) {
+		util.Logger.Fatal("the VRF key pair must be the chain's own")
+	}
+	c.prover = kp
+	c.current = c.newBlock(c.current.slot)
+	c.next = nil
+}
+
+// SetPipelining sets whether the chain starts nominating the next slot while
+// the current one finishes balloting. Only one slot is ever in flight past
+// the current one, and it can't ballot until the current one is finalized,
+// so slots are still externalized in order.
+// It saves a round trip on each slot, which matters on high latency networks.
+func (c *Chain) SetPipelining(on bool) {
+	c.pipeline = on
+	if !on {
+		c.next = nil
+	}
+}
+
+// maybeStartNext starts nominating the next slot if we are pipelining and
+// the current one has accepted a commit, so we know what it will finalize.
+func (c *Chain) maybeStartNext() {
+	if !c.pipeline || c.next != nil {
+		return
+	}
+	x, ok := c.current.committed()
+	if !ok {
+		return
+	}
+	c.Logf("starting to nominate slot %d", c.current.slot+1)
+	c.next = newPipelinedBlock(c.publicKey, c.D, c.current.slot+1, c.values, x, c.prover)
+}
+
 func (c *Chain) AssertValid() {
 	c.current.AssertValid()
 }
@@ -95,26 +189,90 @@ func (c *Chain) GetLast() *ExternalizeMessage {
 	return c.history[c.Slot()-1]
 }
 
+// History returns the externalize message for an old slot, or nil if we
+// don't have it in memory.
+func (c *Chain) History(slot int) *ExternalizeMessage {
+	return c.history[slot]
+}
+
+// LastParticipation returns who took part in the last block this chain
+// externalized, or nil if the chain caught up on it instead.
+func (c *Chain) LastParticipation() *Participation {
+	return c.participation
+}
+
 // AlreadyExternalized handles the case where the slot we are working on is
 // already externalized. The caller must know this.
+// Neither the value store nor the externalize handlers are told about it,
+// since the caller already has the value.
 func (c *Chain) AlreadyExternalized(m *ExternalizeMessage) {
 	if m.I != c.Slot() {
 		panic("slot mismatch")
 	}
 	c.history[m.I] = m
-	c.current = NewBlock(c.publicKey, c.D, m.I+1, c.values)
+	c.participation = nil
+	c.next = nil
+	c.current = c.newBlock(m.I + 1)
+	c.prune()
+}
+
+// SetRetention sets how many of the most recently externalized blocks to keep
+// in history. Zero keeps all of them.
+func (c *Chain) SetRetention(slots int) {
+	c.retention = slots
+	c.prune()
+}
+
+// prune drops the history that is older than the retention window.
+func (c *Chain) prune() {
+	if c.retention <= 0 {
+		return
+	}
+	for c.oldest <= c.Slot()-1-c.retention {
+		delete(c.history, c.oldest)
+		c.oldest++
+	}
+}
+
+// Retained returns the oldest slot we still keep history for, and how many
+// slots of history we keep.
+func (c *Chain) Retained() (int, int) {
+	return c.oldest, len(c.history)
 }
 
 func NewEmptyChain(publicKey util.PublicKey, qs QuorumSlice, vs ValueStore) *Chain {
 	return &Chain{
 		current:   NewBlock(publicKey, qs, 1, vs),
 		history:   make(map[int]*ExternalizeMessage),
+		oldest:    1,
 		D:         qs,
 		values:    vs,
 		publicKey: publicKey,
 	}
 }
 
+// SetQuorumSlice changes the quorum logic, starting with the block we are
+// currently working on. It should only be called right after advancing to a
+// new block, before that block has handled any messages.
+func (c *Chain) SetQuorumSlice(qs QuorumSlice) {
+	c.D = qs
+	c.current = c.newBlock(c.current.slot)
+	c.next = nil
+}
+
+// NominationRound returns the slot we are working on, and the nomination
+// round it is in.
+func (c *Chain) NominationRound() (int, int) {
+	return c.current.slot, c.current.nState.Round()
+}
+
+// NextNominationRound starts the next nomination round for the slot we are
+// working on. It should be called once the round has lasted RoundTimeout.
+// A pipelined next slot doesn't start timing its rounds until it is current.
+func (c *Chain) NextNominationRound() {
+	c.current.NextRound()
+}
+
 // ValueStoreUpdated should be called when the value store is updated
 func (c *Chain) ValueStoreUpdated() {
 	c.current.ValueStoreUpdated()
@@ -122,6 +280,9 @@ func (c *Chain) ValueStoreUpdated() {
 
 func (c *Chain) OutgoingMessages() []util.Message {
 	answer := c.current.OutgoingMessages()
+	if c.next != nil {
+		answer = append(answer, c.next.OutgoingMessages()...)
+	}
 
 	prev := c.history[c.current.slot-1]
 	if prev != nil {
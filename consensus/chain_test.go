@@ -1,7 +1,11 @@
 package consensus
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"math/rand"
+	"strings"
 	"testing"
 
 	"github.com/lacker/coinkit/util"
@@ -25,6 +29,18 @@ func chainSend(source *Chain, target *Chain) {
 	}
 }
 
+// timeOutRounds takes one step on the timer, and starts the next nomination
+// round on any chain whose round is over.
+func timeOutRounds(timer *roundTimer, chains []*Chain) {
+	timer.step++
+	for _, chain := range chains {
+		slot, round := chain.NominationRound()
+		if timer.timedOut(chain, slot, round) {
+			chain.NextNominationRound()
+		}
+	}
+}
+
 // Makes a cluster of chains that requires a consensus of more than two thirds.
 func chainCluster(size int) []*Chain {
 	qs, names := MakeTestQuorumSlice(size)
@@ -72,10 +88,12 @@ func chainFuzzTest(chains []*Chain, seed int64, t *testing.T) {
 	limit := 10
 	rand.Seed(seed ^ 46372837824)
 	util.Logger.Printf("fuzz testing chains with seed %d", seed)
+	timer := newRoundTimer()
 	for i := 1; i <= 10000; i++ {
 		j := rand.Intn(len(chains))
 		k := rand.Intn(len(chains))
 		chainSend(chains[j], chains[k])
+		timeOutRounds(timer, chains)
 		if progress(chains) >= limit {
 			break
 		}
@@ -111,3 +129,292 @@ func TestChainOneNodeKnockedOut(t *testing.T) {
 		chainFuzzTest(knockout, i, t)
 	}
 }
+
+func TestPipelinedChainFullCluster(t *testing.T) {
+	var i int64
+	for i = 0; i < util.GetTestLoopLength(10, 10000); i++ {
+		c := chainCluster(4)
+		for _, chain := range c {
+			chain.SetPipelining(true)
+		}
+		chainFuzzTest(c, i, t)
+	}
+}
+
+// vrfChainCluster is a chainCluster that picks leaders by VRF priority.
+func vrfChainCluster(size int) []*Chain {
+	chains := chainCluster(size)
+	for i, chain := range chains {
+		chain.SetVRF(util.NewKeyPairFromSecretPhrase(fmt.Sprintf("node%d", i)))
+	}
+	return chains
+}
+
+func TestVRFChainFullCluster(t *testing.T) {
+	var i int64
+	for i = 0; i < util.GetTestLoopLength(10, 10000); i++ {
+		chainFuzzTest(vrfChainCluster(4), i, t)
+	}
+}
+
+func TestVRFPipelinedChainOneNodeKnockedOut(t *testing.T) {
+	var i int64
+	for i = 0; i < util.GetTestLoopLength(10, 10000); i++ {
+		c := vrfChainCluster(4)
+		for _, chain := range c {
+			chain.SetPipelining(true)
+		}
+		chainFuzzTest(c[0:3], i, t)
+	}
+}
+
+func TestVRFLeaders(t *testing.T) {
+	chains := vrfChainCluster(4)
+	n := chains[0].current.nState
+	if len(n.leaders) != 0 {
+		t.Fatal("nobody should lead the first round")
+	}
+
+	// Proofs only count for the slot they were made for
+	forged, _ := util.NewKeyPairFromSecretPhrase("node1").ProveVRF(VRFInput("", 2))
+	m := chains[1].current.nState.Message(1, chains[1].D)
+	m.P = base64.RawStdEncoding.EncodeToString(forged)
+	n.Handle(chains[1].publicKey.String(), m)
+	if len(n.outputs) != 1 {
+		t.Fatal("a proof for another slot should not count")
+	}
+
+	// Repeating a proof doesn't end the first round, but proofs from a quorum
+	// do
+	message := func(i int) *NominationMessage {
+		return chains[i].current.nState.Message(1, chains[i].D)
+	}
+	for i := 0; i < 100; i++ {
+		n.Handle(chains[1].publicKey.String(), message(1))
+	}
+	if len(n.outputs) != 2 || n.Round() != 1 {
+		t.Fatalf("expected round 1 with 2 outputs but got round %d with %d",
+			n.Round(), len(n.outputs))
+	}
+	n.Handle(chains[2].publicKey.String(), message(2))
+	if n.Round() != 2 {
+		t.Fatalf("expected round 2 but got round %d", n.Round())
+	}
+	leader := VRFLeader(n.seed, 2, n.D, n.publicKey.String(), n.outputs)
+	if len(n.leaders) != 1 || n.leaders[0] != leader {
+		t.Fatalf("expected %s to lead round 2, but the leaders are %v", leader, n.leaders)
+	}
+	n.Handle(chains[3].publicKey.String(), message(3))
+	if len(n.outputs) != 4 {
+		t.Fatalf("expected 4 outputs but got %d", len(n.outputs))
+	}
+}
+
+func TestPipelining(t *testing.T) {
+	chains := chainCluster(4)
+	for _, chain := range chains {
+		chain.SetPipelining(true)
+	}
+	// Find a chain that is pipelining slot 2
+	var target *Chain
+	for i := 0; target == nil; i++ {
+		if i == 1000 || progress(chains) > 0 {
+			t.Fatal("nobody pipelined slot 2")
+		}
+		chainSend(chains[i%4], chains[(i+1+i/4)%4])
+		for _, chain := range chains {
+			if chain.next != nil && chain.Slot() == 1 {
+				target = chain
+			}
+		}
+	}
+	if _, ok := target.current.committed(); !ok {
+		t.Fatal("started the next slot before accepting a commit")
+	}
+
+	// Nominations for the next slot are kept, but not voted for yet
+	for _, chain := range chains {
+		if chain != target {
+			m := &NominationMessage{I: 2, Nom: []SlotValue{"value9"}, D: target.D}
+			target.Handle(chain.publicKey.String(), m)
+		}
+	}
+	next := target.next
+	if len(next.nState.N) != 3 || len(next.nState.X) > 0 {
+		t.Fatalf("bad pipelined nomination state: %+v", next.nState)
+	}
+	for _, m := range target.OutgoingMessages() {
+		if _, ok := m.(*NominationMessage); !ok && m.Slot() == 2 {
+			t.Fatalf("a pipelined block should not ballot: %s", m)
+		}
+	}
+
+	for i := 0; target.Slot() == 1; i++ {
+		if i == 1000 {
+			t.Fatal("slot 1 never finished")
+		}
+		chainSend(chains[i%4], chains[(i+1+i/4)%4])
+	}
+	if target.current != next || next.nState.waiting {
+		t.Fatal("the pipelined block should be resumed once slot 1 is done")
+	}
+	if len(next.nState.X) == 0 {
+		t.Fatal("a resumed block should nominate")
+	}
+}
+
+func TestChainRetention(t *testing.T) {
+	chains := chainCluster(4)
+	for _, chain := range chains {
+		chain.SetRetention(2)
+	}
+	for i := 0; i < 1000 && progress(chains) < 5; i++ {
+		for _, source := range chains {
+			for _, target := range chains {
+				chainSend(source, target)
+			}
+		}
+	}
+	for _, chain := range chains {
+		last := chain.Slot() - 1
+		if last < 5 {
+			t.Fatalf("only got to slot %d", last)
+		}
+		oldest, slots := chain.Retained()
+		if slots != 2 || oldest != last-1 {
+			t.Fatalf("at slot %d, kept %d slots starting at %d", last, slots, oldest)
+		}
+		if chain.GetLast() == nil || chain.history[last-2] != nil {
+			t.Fatalf("kept the wrong slots: %v", chain.history)
+		}
+	}
+}
+
+// recordingHandler remembers what it was told, and what the chain's last
+// externalized slot was at the time.
+type recordingHandler struct {
+	chain  *Chain
+	slots  []int
+	values []SlotValue
+}
+
+func (r *recordingHandler) Externalized(slot int, v SlotValue) {
+	if r.chain.GetLast() == nil || r.chain.GetLast().I != slot {
+		panic("a handler was called before the chain moved on")
+	}
+	r.slots = append(r.slots, slot)
+	r.values = append(r.values, v)
+}
+
+func TestExternalizeHandler(t *testing.T) {
+	chains := chainCluster(4)
+	handlers := []*recordingHandler{}
+	for _, chain := range chains {
+		h := &recordingHandler{chain: chain}
+		chain.AddExternalizeHandler(h)
+		handlers = append(handlers, h)
+	}
+	for i := 0; i < 1000 && progress(chains) < 3; i++ {
+		for _, source := range chains {
+			for _, target := range chains {
+				chainSend(source, target)
+			}
+		}
+	}
+	for i, h := range handlers {
+		chain := chains[i]
+		if len(h.slots) != chain.Slot()-1 || len(h.slots) < 3 {
+			t.Fatalf("told about %v at slot %d", h.slots, chain.Slot())
+		}
+		for j, slot := range h.slots {
+			if slot != j+1 {
+				t.Fatalf("slots out of order: %v", h.slots)
+			}
+			if h.values[j] != chain.history[slot].X {
+				t.Fatalf("told %s for slot %d but externalized %s",
+					h.values[j], slot, chain.history[slot].X)
+			}
+		}
+		if chain.values.Last() != h.values[len(h.values)-1] {
+			t.Fatal("the value store should finalize before the handlers are told")
+		}
+	}
+}
+
+func TestParticipation(t *testing.T) {
+	chains := chainCluster(4)
+	live := chains[0:3]
+	timer := newRoundTimer()
+	for i := 0; progress(live) < 1; i++ {
+		if i == 1000 {
+			t.Fatal("slot 1 never finished")
+		}
+		chainSend(live[i%3], live[(i+1)%3])
+		chainSend(live[(i+1)%3], live[i%3])
+		timeOutRounds(timer, live)
+	}
+	missing := chains[3].publicKey.String()
+	for _, chain := range live {
+		p := chain.LastParticipation()
+		if p == nil {
+			t.Fatal("a chain that externalized should know who took part")
+		}
+		if len(p.Missed) != 1 || p.Missed[0] != missing {
+			t.Fatalf("only the knocked out node should have missed: %+v", p)
+		}
+		if len(p.Balloted) != 3 || len(p.Committed) != 3 || len(p.Nominated) == 0 {
+			t.Fatalf("bad participation: %+v", p)
+		}
+	}
+}
+
+func TestChainState(t *testing.T) {
+	chains := chainCluster(4)
+	for i := 0; i < 2; i++ {
+		for _, source := range chains {
+			chainSend(source, chains[0])
+			chainSend(chains[0], source)
+		}
+	}
+	state := chains[0].State()
+	if state.Slot != 1 || state.Phase != "Prepare" || len(state.X) == 0 {
+		t.Fatalf("bad state: %+v", state)
+	}
+	if len(state.Nominations) == 0 {
+		t.Fatal("the state should include the peers' nominations")
+	}
+	if _, err := json.Marshal(state); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChainGraph(t *testing.T) {
+	chains := chainCluster(4)
+	live := chains[0:3]
+	timer := newRoundTimer()
+	for i := 0; i < 6 || len(chains[0].Graph().Values) == 0; i++ {
+		if i == 1000 {
+			t.Fatal("nothing was nominated")
+		}
+		chainSend(live[i%3], live[(i+1)%3])
+		chainSend(live[(i+1)%3], live[i%3])
+		timeOutRounds(timer, live)
+	}
+	g := chains[0].Graph()
+	if g.Slot != 1 || len(g.Validators) != 4 || len(g.Values) == 0 {
+		t.Fatalf("bad graph: %+v", g)
+	}
+	missing := chains[3].publicKey.String()
+	if g.Phases[missing] != "" {
+		t.Fatal("we never heard a ballot from the knocked out node")
+	}
+	for _, e := range g.Edges {
+		if e.Validator == missing {
+			t.Fatalf("the knocked out node should have no edges: %+v", e)
+		}
+	}
+	dot := g.Dot()
+	if !strings.HasPrefix(dot, "digraph slot1 {") || !strings.Contains(dot, "votes nominate") {
+		t.Fatalf("bad dot:\n%s", dot)
+	}
+}
@@ -0,0 +1,17 @@
+package consensus
+
+// An ExternalizeHandler is told about each value the chain externalizes, in
+// slot order. This is how an application learns what consensus decided,
+// without the consensus code knowing what the values mean.
+type ExternalizeHandler interface {
+	Externalized(slot int, v SlotValue)
+}
+
+// AddExternalizeHandler registers a handler to be told about every slot the
+// chain externalizes from now on. Handlers are called in the order they were
+// added, after the value store has finalized the value and the chain has
+// moved on to the next slot, so GetLast and LastParticipation describe the
+// externalized slot.
+func (c *Chain) AddExternalizeHandler(h ExternalizeHandler) {
+	c.handlers = append(c.handlers, h)
+}
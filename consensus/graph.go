@@ -0,0 +1,162 @@
+package consensus
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lacker/coinkit/util"
+)
+
+// A Graph shows who voted for, accepted, or confirmed which values in one
+// slot, according to the last messages one node got from each validator.
+// It is for inspecting protocol problems, like a ballot that never commits.
+type Graph struct {
+	Slot int
+
+	// The node whose view this is
+	Self string
+
+	// The validators in the quorum slice, in its order
+	Validators []string
+
+	// What each validator's last ballot message was, like "prepare", or ""
+	// if we have none
+	Phases map[string]string
+
+	// Every value that any edge points to, sorted
+	Values []SlotValue
+
+	Edges []*GraphEdge
+}
+
+// A GraphEdge says a validator took some step for a value, like
+// "votes prepare 2" or "accepts nominated".
+type GraphEdge struct {
+	Validator string
+	Value     SlotValue
+	Label     string
+}
+
+func (g *Graph) addEdge(validator string, value SlotValue, format string, a ...interface{}) {
+	g.Edges = append(g.Edges, &GraphEdge{
+		Validator: validator,
+		Value:     value,
+		Label:     fmt.Sprintf(format, a...),
+	})
+}
+
+// addMessages adds the edges for one validator's last messages.
+// Either message can be nil.
+func (g *Graph) addMessages(validator string, n *NominationMessage, m BallotMessage) {
+	if n != nil {
+		for _, x := range n.Nom {
+			g.addEdge(validator, x, "votes nominate")
+		}
+		for _, x := range n.Acc {
+			g.addEdge(validator, x, "accepts nominated")
+		}
+	}
+	switch m := m.(type) {
+	case *PrepareMessage:
+		g.Phases[validator] = "prepare"
+		g.addEdge(validator, m.Bx, "votes prepare %d", m.Bn)
+		if m.Pn > 0 {
+			g.addEdge(validator, m.Px, "accepts prepared %d", m.Pn)
+		}
+		if m.Ppn > 0 {
+			g.addEdge(validator, m.Ppx, "accepts prepared %d", m.Ppn)
+		}
+		if m.Hn > 0 {
+			g.addEdge(validator, m.Bx, "confirms prepared %d", m.Hn)
+		}
+		if m.Cn > 0 {
+			g.addEdge(validator, m.Bx, "votes commit %d-%d", m.Cn, m.Hn)
+		}
+	case *ConfirmMessage:
+		g.Phases[validator] = "confirm"
+		g.addEdge(validator, m.X, "accepts commit %d-%d", m.Cn, m.Hn)
+	case *ExternalizeMessage:
+		g.Phases[validator] = "externalize"
+		g.addEdge(validator, m.X, "confirms commit %d-%d", m.Cn, m.Hn)
+	}
+}
+
+// Graph returns this block's view of the validators' progress.
+func (b *Block) Graph() *Graph {
+	self := b.publicKey.String()
+	g := &Graph{
+		Slot:       b.slot,
+		Self:       self,
+		Validators: append([]string{}, b.D.Members...),
+		Phases:     make(map[string]string),
+		Edges:      []*GraphEdge{},
+	}
+	for _, member := range b.D.Members {
+		g.Phases[member] = ""
+		if member == self {
+			var ballot BallotMessage
+			if b.external != nil {
+				ballot = b.external
+			} else if b.bState.HasMessage() {
+				ballot = b.bState.Message(b.slot, b.D)
+			}
+			g.addMessages(member, b.nState.Message(b.slot, b.D), ballot)
+		} else {
+			g.addMessages(member, b.nState.N[member], b.bState.M[member])
+		}
+	}
+
+	seen := make(map[SlotValue]bool)
+	for _, edge := range g.Edges {
+		if !seen[edge.Value] {
+			seen[edge.Value] = true
+			g.Values = append(g.Values, edge.Value)
+		}
+	}
+	sort.Slice(g.Values, func(i, j int) bool { return g.Values[i] < g.Values[j] })
+	return g
+}
+
+// Graph returns the view of the block this chain is working on.
+func (c *Chain) Graph() *Graph {
+	return c.current.Graph()
+}
+
+func shortKey(key string) string {
+	return util.Shorten(strings.TrimPrefix(key, "0x"))
+}
+
+// Dot renders the graph in the DOT language, for Graphviz.
+// Validators are boxes, with ours in bold and the ones we haven't heard a
+// ballot from dashed. Values are ellipses.
+func (g *Graph) Dot() string {
+	lines := []string{
+		fmt.Sprintf("digraph slot%d {", g.Slot),
+		"  rankdir=LR;",
+	}
+	for _, v := range g.Validators {
+		style := "solid"
+		if v == g.Self {
+			style = "bold"
+		} else if g.Phases[v] == "" {
+			style = "dashed"
+		}
+		label := shortKey(v)
+		if g.Phases[v] != "" {
+			label += "\\n" + g.Phases[v]
+		}
+		lines = append(lines, fmt.Sprintf("  %q [shape=box style=%s label=\"%s\"];",
+			v, style, label))
+	}
+	for _, x := range g.Values {
+		lines = append(lines, fmt.Sprintf("  %q [shape=ellipse label=%q];",
+			string(x), util.Shorten(string(x))))
+	}
+	for _, e := range g.Edges {
+		lines = append(lines, fmt.Sprintf("  %q -> %q [label=%q];",
+			e.Validator, string(e.Value), e.Label))
+	}
+	lines = append(lines, "}")
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,103 @@
+package consensus
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+)
+
+// Nomination leaders are picked the way section 6.1 of the SCP paper
+// describes. Each round, some of the members of our quorum slice are
+// neighbors, chosen by a hash, with a probability that is their weight. The
+// neighbor with the highest priority, also a hash, leads the round.
+// We only vote to nominate what leaders nominate, so early on just a few
+// values get nominated, and it takes later rounds to hear from anyone else.
+// The seed is the last value we finalized, so that every slot has different
+// leaders.
+
+// leaderHash hashes the parts of a leader election into a number.
+// The kind keeps the neighbor and priority hashes independent.
+func leaderHash(kind string, seed string, round int, node string) uint64 {
+	h := sha512.Sum512_256([]byte(fmt.Sprintf("%s %d %s %s", kind, round, seed, node)))
+	return binary.BigEndian.Uint64(h[:8])
+}
+
+// IsNeighbor returns whether node is one of our neighbors in this round.
+// The weight of a node is the fraction of our quorum slices that contain it.
+// We are in all of them, and a node in a threshold-of-n slice is in t/n of
+// them.
+func IsNeighbor(seed string, round int, qs QuorumSlice, self string, node string) bool {
+	if !qs.Contains(node) {
+		return false
+	}
+	if node == self {
+		return true
+	}
+	n := uint64(len(qs.Members))
+	return leaderHash("N", seed, round, node)%n < uint64(qs.Threshold)
+}
+
+// Priority ranks the nodes in a round. Higher priority nodes lead.
+func Priority(seed string, round int, node string) uint64 {
+	return leaderHash("P", seed, round, node)
+}
+
+// Leader returns the neighbor with the highest priority in this round, or the
+// empty string if we have no neighbors.
+func Leader(seed string, round int, qs QuorumSlice, self string) string {
+	answer := ""
+	var best uint64
+	for _, node := range qs.Members {
+		if !IsNeighbor(seed, round, qs, self, node) {
+			continue
+		}
+		p := Priority(seed, round, node)
+		if answer == "" || p > best || (p == best && node < answer) {
+			answer = node
+			best = p
+		}
+	}
+	return answer
+}
+
+// With VRF priorities, a node's priority comes from the output of a
+// verifiable random function keyed by its own key, instead of a hash anyone
+// can compute. Neighbors are picked the same way, but nobody knows who will
+// lead a round until the neighbors send their proofs along with their
+// nominations, so attackers can't knock out the next leader ahead of time.
+// Each node has one output per slot, and every round hashes it again.
+// Since we only learn priorities from messages, the first round has no
+// leader, and just collects proofs.
+
+// VRFInput is what every node proves its VRF output for in a slot.
+func VRFInput(previous SlotValue, slot int) []byte {
+	return []byte("nominate " + NewSeed(previous, slot).String())
+}
+
+// VRFPriority ranks the nodes in a round, like Priority, given the VRF
+// output a node proved for the slot.
+func VRFPriority(output []byte, round int, node string) uint64 {
+	return leaderHash("V", base64.RawStdEncoding.EncodeToString(output), round, node)
+}
+
+// VRFLeader returns the neighbor with the highest VRF priority in this
+// round, out of the neighbors whose outputs we know, or the empty string if
+// we don't know any.
+func VRFLeader(seed string, round int, qs QuorumSlice, self string,
+	outputs map[string][]byte) string {
+	answer := ""
+	var best uint64
+	for _, node := range qs.Members {
+		output, ok := outputs[node]
+		if !ok || !IsNeighbor(seed, round, qs, self, node) {
+			continue
+		}
+		p := VRFPriority(output, round, node)
+		if answer == "" || p > best || (p == best && node < answer) {
+			answer = node
+			best = p
+		}
+	}
+	return answer
+}
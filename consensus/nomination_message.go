@@ -1,11 +1,19 @@
 package consensus
 
 import (
+	"encoding/base64"
 	"strings"
 	
 	"github.com/lacker/coinkit/util"
 )
 
+// MaxNominationValues is the most values a nomination message can vote for,
+// or accept. Each leader nominates one value at a time, so this is plenty.
+const MaxNominationValues = MaxQuorumSliceSize
+
+// encodedProofSize is how long a VRF proof is in a nomination message
+var encodedProofSize = base64.RawStdEncoding.EncodedLen(util.VRFProofSize)
+
 // The nomination message format of the Stellar Consensus Protocol.
 // Implements Message.
 // See:
@@ -21,6 +29,10 @@ type NominationMessage struct {
 	Acc []SlotValue
 
 	D QuorumSlice
+
+	// With VRF priorities, the proof of the sender's VRF output for the
+	// slot, base64-encoded. See leader.go
+	P string `json:",omitempty"`
 }
 
 func (m *NominationMessage) MessageType() string {
@@ -31,6 +43,19 @@ func (m *NominationMessage) Slot() int {
 	return m.I
 }
 
+func (m *NominationMessage) CheckLimits() error {
+	if err := util.CheckLimit(m, "nominated values", len(m.Nom), MaxNominationValues); err != nil {
+		return err
+	}
+	if err := util.CheckLimit(m, "accepted values", len(m.Acc), MaxNominationValues); err != nil {
+		return err
+	}
+	if err := util.CheckLimit(m, "VRF proof", len(m.P), encodedProofSize); err != nil {
+		return err
+	}
+	return m.D.checkLimits(m)
+}
+
 func (m *NominationMessage) String() string {
 	shortNom := []string{}
 	shortAcc := []string{}
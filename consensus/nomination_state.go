@@ -1,9 +1,22 @@
 package consensus
 
 import (
+	"encoding/base64"
+	"time"
+
 	"github.com/lacker/coinkit/util"
 )
 
+// NominationTimeout is how long the first nomination round lasts. Round n
+// lasts n times as long, like the timeouts in the SCP paper, so eventually
+// every round is long enough to hear from its leader.
+const NominationTimeout = time.Second
+
+// RoundTimeout returns how long a nomination round lasts.
+func RoundTimeout(round int) time.Duration {
+	return time.Duration(round) * NominationTimeout
+}
+
 // The nomination state for the Stellar Consensus Protocol.
 // See page 21 of:
 // https://www.stellar.org/papers/stellar-consensus-protocol.pdf
@@ -26,31 +39,66 @@ type NominationState struct {
 	// Who we listen to for quorum
 	D QuorumSlice
 
-	// The number of messages this state has processed
-	received int
+	// What we hash to pick the leaders of each round
+	seed string
+
+	// The nomination round we are in, starting at 1.
+	// The state doesn't keep time. Whoever runs it calls NextRound when a
+	// round has lasted RoundTimeout, so no number of messages from peers can
+	// hurry the rounds along.
+	round int
 
-	// Which priority we think we are for creating a nomination
-	// 0 is the first priority
-	// Negative means we should never create a nomination
-	priority int
+	// The nodes whose nominations we echo. Each round adds its leader.
+	leaders []string
+
+	// With VRF priorities, the key we prove our output with, the input every
+	// node proves its output for, our proof, and the verified output of each
+	// node we have a proof from. prover is nil with hash priorities.
+	// See leader.go
+	prover   util.Signer
+	vrfInput []byte
+	proof    string
+	outputs  map[string][]byte
 
 	// The value store we use to validate or combine values
 	values ValueStore
+
+	// While waiting, the slot before ours isn't finalized, so the value store
+	// can't judge values for our slot yet. We keep track of what we hear and
+	// time the rounds, but we don't vote for anything.
+	waiting bool
 }
 
 func NewNominationState(
 	publicKey util.PublicKey, qs QuorumSlice, vs ValueStore) *NominationState {
+	return newNominationState(publicKey, qs, vs, vs.Last(), 0, nil)
+}
+
+// newNominationState makes a nomination state whose leaders are picked with
+// the provided seed, which should be the value of the previous slot.
+// With a prover, leaders are picked by VRF priority for the slot instead.
+func newNominationState(publicKey util.PublicKey, qs QuorumSlice, vs ValueStore,
+	seed SlotValue, slot int, prover util.Signer) *NominationState {
 
-	return &NominationState{
+	s := &NominationState{
 		X:         make([]SlotValue, 0),
 		Y:         make([]SlotValue, 0),
 		Z:         make([]SlotValue, 0),
 		N:         make(map[string]*NominationMessage),
 		publicKey: publicKey,
 		D:         qs,
-		priority:  SeedPriority(string(vs.Last()), qs.Members, publicKey.String()),
+		seed:      string(seed),
 		values:    vs,
 	}
+	if prover != nil {
+		s.prover = prover
+		s.vrfInput = VRFInput(seed, slot)
+		proof, output := prover.ProveVRF(s.vrfInput)
+		s.proof = base64.RawStdEncoding.EncodeToString(proof)
+		s.outputs = map[string][]byte{publicKey.String(): output}
+	}
+	s.startRound(1)
+	return s
 }
 
 func (s *NominationState) Logf(format string, a ...interface{}) {
@@ -74,12 +122,16 @@ func (s *NominationState) HasNomination() bool {
 
 // Returns whether we nominated a new value
 func (s *NominationState) MaybeNominateNewValue() bool {
+	if s.waiting {
+		return false
+	}
+
 	if len(s.X) > 0 {
 		// We already nominated a value
 		return false
 	}
 
-	if s.priority < 0 || s.D.Threshold*s.priority > s.received {
+	if !s.WantsToNominateNewValue() {
 		// We don't think it's our turn
 		return false
 	}
@@ -95,12 +147,146 @@ func (s *NominationState) MaybeNominateNewValue() bool {
 	return true
 }
 
-// WantsToNominateNewValue is a heuristic. If we already have some value, we don't
-// want to nominate a new one. We also want to wait some time, according to our
-// priority, before we are willing to make a nomination.
+// WantsToNominateNewValue returns whether it's our turn to nominate, which is
+// once we have led a round.
 func (s *NominationState) WantsToNominateNewValue() bool {
+	return s.IsLeader(s.publicKey.String())
+}
 
-	return s.D.Threshold*s.priority <= s.received
+// IsLeader returns whether node has led any round so far.
+func (s *NominationState) IsLeader(node string) bool {
+	for _, leader := range s.leaders {
+		if leader == node {
+			return true
+		}
+	}
+	return false
+}
+
+// Round returns the nomination round we are in.
+func (s *NominationState) Round() int {
+	return s.round
+}
+
+// NextRound starts the next nomination round, once this one has timed out.
+// Returns whether we nominated a new value.
+func (s *NominationState) NextRound() bool {
+	s.startRound(s.round + 1)
+
+	// Echoing the new leader might complete a quorum
+	for _, v := range s.X {
+		s.MaybeAdvance(v)
+	}
+	return s.MaybeNominateNewValue()
+}
+
+// startRound moves to a new nomination round, and echoes anything its leader
+// already nominated.
+func (s *NominationState) startRound(round int) {
+	s.round = round
+	leader := s.leader(round)
+	if leader == "" || s.IsLeader(leader) {
+		return
+	}
+	s.Logf("round %d is led by %s", round, util.Shorten(leader))
+	s.leaders = append(s.leaders, leader)
+	s.echoLeaders()
+}
+
+// leader returns who leads a round, or the empty string if nobody does.
+func (s *NominationState) leader(round int) string {
+	if s.prover == nil {
+		return Leader(s.seed, round, s.D, s.publicKey.String())
+	}
+	if round == 1 {
+		return ""
+	}
+	return VRFLeader(s.seed, round, s.D, s.publicKey.String(), s.outputs)
+}
+
+// handleProof records the VRF output a node proved, the first time it sends
+// a valid proof. The first round, which has no leader, ends early once the
+// nodes we have proofs from satisfy our quorum slice. Each node's proof only
+// counts once, so this can't be hurried along by repeating messages either.
+func (s *NominationState) handleProof(node string, proof string) {
+	if s.prover == nil || proof == "" {
+		return
+	}
+	if _, ok := s.outputs[node]; ok {
+		return
+	}
+	pk, err := util.ReadPublicKey(node)
+	if err != nil {
+		return
+	}
+	bytes, err := base64.RawStdEncoding.DecodeString(proof)
+	if err != nil {
+		return
+	}
+	output, ok := util.VerifyVRF(pk, s.vrfInput, bytes)
+	if !ok {
+		s.Logf("%s sent an invalid VRF proof", util.Shorten(node))
+		return
+	}
+	s.outputs[node] = output
+
+	if s.round == 1 {
+		proven := []string{}
+		for n := range s.outputs {
+			proven = append(proven, n)
+		}
+		if s.D.SatisfiedWith(proven) {
+			s.startRound(2)
+		}
+	}
+}
+
+// echoLeaders echoes everything the leaders have nominated so far.
+func (s *NominationState) echoLeaders() {
+	for _, leader := range s.leaders {
+		if m, ok := s.N[leader]; ok {
+			for _, value := range m.Nom {
+				s.echo(value)
+			}
+		}
+	}
+}
+
+// ValueStoreUpdated should be called when the value store learns something.
+// Values that didn't validate before might now, so we check the nominations
+// we have heard again.
+func (s *NominationState) ValueStoreUpdated() {
+	s.echoLeaders()
+	checked := []SlotValue{}
+	for _, m := range s.N {
+		for _, values := range [][]SlotValue{m.Nom, m.Acc} {
+			for _, v := range values {
+				if !HasSlotValue(checked, v) {
+					checked = append(checked, v)
+					s.MaybeAdvance(v)
+				}
+			}
+		}
+	}
+	s.MaybeNominateNewValue()
+}
+
+// Resume stops waiting, and votes for whatever we heard while waiting that
+// the value store now validates.
+func (s *NominationState) Resume() {
+	s.waiting = false
+	s.ValueStoreUpdated()
+}
+
+// echo votes to nominate a value a leader nominated, if it's valid.
+// Once we confirm a nomination we stop voting for new values, so that the
+// set of candidates can stop growing.
+func (s *NominationState) echo(value SlotValue) {
+	if s.waiting || len(s.Z) > 0 || HasSlotValue(s.X, value) || !s.values.ValidateValue(value) {
+		return
+	}
+	s.Logf("supports the nomination of %s", util.Shorten(string(value)))
+	s.X = append(s.X, value)
 }
 
 func (s *NominationState) NominateNewValue(v SlotValue) {
@@ -151,8 +337,14 @@ func (s *NominationState) AssertValid() {
 // It also checks whether we should confirm the nomination.
 // Returns whether we made any changes.
 func (s *NominationState) MaybeAdvance(v SlotValue) bool {
-	if HasSlotValue(s.Z, v) {
-		// We already confirmed this, so we can't do anything more
+	if s.waiting || HasSlotValue(s.Z, v) {
+		// We can't judge this value yet, or we already confirmed it
+		return false
+	}
+
+	if !s.values.ValidateValue(v) {
+		// Even if a blocking set accepts this, we can't use a value we
+		// can't validate. It might be garbage that peers are pushing.
 		return false
 	}
 
@@ -203,7 +395,7 @@ func (s *NominationState) MaybeAdvance(v SlotValue) bool {
 
 // Handles an incoming nomination message from a peer node
 func (s *NominationState) Handle(node string, m *NominationMessage) {
-	s.received++
+	s.handleProof(node, m.P)
 
 	// What nodes we have seen new information about
 	touched := []SlotValue{}
@@ -237,11 +429,9 @@ func (s *NominationState) Handle(node string, m *NominationMessage) {
 			touched = append(touched, value)
 		}
 
-		// If we don't have a candidate, and the value is valid,
-		// we can support this new nomination
-		if !HasSlotValue(s.X, value) && s.values.ValidateValue(value) {
-			s.Logf("supports the nomination of %s", util.Shorten(string(value)))
-			s.X = append(s.X, value)
+		// We support the nominations of leaders
+		if s.IsLeader(node) {
+			s.echo(value)
 		}
 	}
 
@@ -261,5 +451,6 @@ func (s *NominationState) Message(slot int, qs QuorumSlice) *NominationMessage {
 		Nom: s.X,
 		Acc: s.Y,
 		D:   qs,
+		P:   s.proof,
 	}
 }
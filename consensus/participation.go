@@ -0,0 +1,64 @@
+package consensus
+
+// Participation records which validators took part in deciding a slot, as
+// one node saw it when the slot was externalized. Each list is in the order
+// of the quorum slice's members.
+type Participation struct {
+	// The validators for the slot
+	Validators []string
+
+	// The validators that voted to nominate a value
+	Nominated []string
+
+	// The validators that sent any ballot message
+	Balloted []string
+
+	// The validators that had accepted a commit, which makes them part of the
+	// quorum that externalized the slot
+	Committed []string
+
+	// The validators we didn't hear from at all
+	Missed []string
+}
+
+// Participation returns who took part in this block, or nil if it isn't
+// externalized yet.
+func (b *Block) Participation() *Participation {
+	if b.external == nil {
+		return nil
+	}
+	self := b.publicKey.String()
+	answer := &Participation{
+		Validators: append([]string{}, b.D.Members...),
+	}
+	for _, member := range b.D.Members {
+		nominated, balloted, committed := false, false, false
+		if member == self {
+			// Our own messages aren't in the maps, but we externalized
+			nominated = len(b.nState.X) > 0
+			balloted, committed = true, true
+		} else {
+			if m, ok := b.nState.N[member]; ok && len(m.Nom) > 0 {
+				nominated = true
+			}
+			if m, ok := b.bState.M[member]; ok {
+				balloted = true
+				committed = m.Phase() == Confirm || m.Phase() == Externalize
+			}
+		}
+		_, heard := b.nState.N[member]
+		if nominated {
+			answer.Nominated = append(answer.Nominated, member)
+		}
+		if balloted {
+			answer.Balloted = append(answer.Balloted, member)
+		}
+		if committed {
+			answer.Committed = append(answer.Committed, member)
+		}
+		if !heard && !balloted {
+			answer.Missed = append(answer.Missed, member)
+		}
+	}
+	return answer
+}
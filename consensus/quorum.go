@@ -1,7 +1,8 @@
 package consensus
 
 import (
-	"fmt"
+	"encoding/json"
+	"sync"
 
 	"github.com/lacker/coinkit/util"
 )
@@ -18,6 +19,51 @@ type QuorumSlice struct {
 	Threshold int
 }
 
+// MaxQuorumSliceSize is the most members a quorum slice in a message can have.
+const MaxQuorumSliceSize = 1000
+
+// checkLimits checks the quorum slice in message m.
+func (qs QuorumSlice) checkLimits(m util.Message) error {
+	return util.CheckLimit(m, "quorum slice members", len(qs.Members), MaxQuorumSliceSize)
+}
+
+// plainQuorumSlice encodes like a QuorumSlice would without MarshalJSON.
+type plainQuorumSlice QuorumSlice
+
+// quorumSliceCache holds the encoding of the last quorum slice that was
+// encoded. A node puts its own quorum slice into nearly every message it
+// sends, so this saves encoding the same slice over and over. Members are
+// never modified in place, so a slice with the same backing array and
+// threshold encodes the same way.
+var quorumSliceCache struct {
+	sync.Mutex
+	first     *string
+	size      int
+	threshold int
+	encoded   []byte
+}
+
+func (qs QuorumSlice) MarshalJSON() ([]byte, error) {
+	if len(qs.Members) == 0 {
+		return json.Marshal(plainQuorumSlice(qs))
+	}
+	c := &quorumSliceCache
+	c.Lock()
+	defer c.Unlock()
+	if c.first == &qs.Members[0] && c.size == len(qs.Members) && c.threshold == qs.Threshold {
+		return c.encoded, nil
+	}
+	encoded, err := json.Marshal(plainQuorumSlice(qs))
+	if err != nil {
+		return nil, err
+	}
+	c.first = &qs.Members[0]
+	c.size = len(qs.Members)
+	c.threshold = qs.Threshold
+	c.encoded = encoded
+	return encoded, nil
+}
+
 func MakeQuorumSlice(members []string, threshold int) QuorumSlice {
 	return QuorumSlice{
 		Members:   members,
@@ -49,19 +95,22 @@ func (qs *QuorumSlice) SatisfiedWith(nodes []string) bool {
 	return qs.atLeast(nodes, qs.Threshold)
 }
 
+func (qs *QuorumSlice) Contains(node string) bool {
+	for _, member := range qs.Members {
+		if member == node {
+			return true
+		}
+	}
+	return false
+}
+
 // Makes data for a test quorum slice that requires a consensus of more
 // than two thirds of the given size.
 // Also returns a list of public keys of the quorum members.
+// See topology.go for networks where nodes have different slices.
 func MakeTestQuorumSlice(size int) (QuorumSlice, []util.PublicKey) {
-	threshold := 2*size/3 + 1
-	pks := []util.PublicKey{}
-	names := []string{}
-	for i := 0; i < size; i++ {
-		pk := util.NewKeyPairFromSecretPhrase(fmt.Sprintf("node%d", i)).PublicKey()
-		pks = append(pks, pk)
-		names = append(names, pk.String())
-	}
-	qs := MakeQuorumSlice(names, threshold)
+	pks, names := makeTestKeys(size)
+	qs := MakeQuorumSlice(names, 2*size/3+1)
 	return qs, pks
 }
 
@@ -0,0 +1,35 @@
+package consensus
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestQuorumSliceJSON(t *testing.T) {
+	qs := MakeQuorumSlice([]string{"alice", "bob", "carol"}, 2)
+	plain, err := json.Marshal(plainQuorumSlice(qs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		encoded, err := json.Marshal(&ExternalizeMessage{I: 1, X: "x", D: qs})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := `{"I":1,"X":"x","Cn":0,"Hn":0,"D":` + string(plain) + `}`
+		if string(encoded) != expected {
+			t.Fatalf("expected %s but got %s", expected, encoded)
+		}
+	}
+
+	// A different slice with the same backing array is not the same slice
+	shorter := QuorumSlice{Members: qs.Members[:2], Threshold: 2}
+	encoded, _ := json.Marshal(shorter)
+	if string(encoded) != `{"Members":["alice","bob"],"Threshold":2}` {
+		t.Fatalf("bad encoding: %s", encoded)
+	}
+	encoded, _ = json.Marshal(QuorumSlice{})
+	if string(encoded) != `{"Members":null,"Threshold":0}` {
+		t.Fatalf("bad encoding: %s", encoded)
+	}
+}
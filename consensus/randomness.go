@@ -0,0 +1,63 @@
+package consensus
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+)
+
+// A Seed is randomness that every node agrees on for a slot. It is the
+// SHA-512/256 of the slot number and the value externalized for the slot
+// before it, which is the previous block's hash, so nobody knows it until
+// the previous block is done. Nodes that need to make the same random choice,
+// like picking a lottery winner, should all derive it from the slot's seed.
+//
+// Each use of the seed has a purpose string, which keeps the numbers drawn
+// for different purposes independent. Two draws with the same purpose and
+// index are always the same number.
+type Seed [sha512.Size256]byte
+
+// NewSeed returns the seed for a slot. previous is the value externalized for
+// the slot before it, or empty for the first slot.
+func NewSeed(previous SlotValue, slot int) Seed {
+	return Seed(sha512.Sum512_256([]byte(fmt.Sprintf("seed %d %s", slot, previous))))
+}
+
+// String is the seed base64-encoded without padding, like a SlotValue.
+func (s Seed) String() string {
+	return base64.RawStdEncoding.EncodeToString(s[:])
+}
+
+// Uint64 draws the index'th number for a purpose.
+func (s Seed) Uint64(purpose string, index int) uint64 {
+	h := sha512.Sum512_256([]byte(fmt.Sprintf("%s %s %d", s, purpose, index)))
+	return binary.BigEndian.Uint64(h[:8])
+}
+
+// Intn draws a number from 0 to n-1 for a purpose, with every number equally
+// likely. It panics if n is not positive.
+func (s Seed) Intn(purpose string, n int) int {
+	if n <= 0 {
+		panic("Intn needs a positive n")
+	}
+	// Numbers at or above limit would make the low answers more likely, so
+	// they are skipped. That almost never happens more than once.
+	limit := ^uint64(0) - ^uint64(0)%uint64(n)
+	for index := 0; ; index++ {
+		x := s.Uint64(purpose, index)
+		if x < limit {
+			return int(x % uint64(n))
+		}
+	}
+}
+
+// Shuffle returns a copy of items in a random order for a purpose.
+func (s Seed) Shuffle(purpose string, items []string) []string {
+	answer := append([]string{}, items...)
+	for i := len(answer) - 1; i > 0; i-- {
+		j := s.Intn(fmt.Sprintf("%s %d", purpose, i), i+1)
+		answer[i], answer[j] = answer[j], answer[i]
+	}
+	return answer
+}
@@ -0,0 +1,73 @@
+package consensus
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// Other implementations have to draw the same numbers, so these are fixed.
+var seedVectors = []struct {
+	previous SlotValue
+	slot     int
+	seed     string
+	draws    []uint64
+	intn     int
+	shuffled []string
+}{
+	{
+		"", 1,
+		"dVek5XKBOGPsw4D8D/clGOiyImPlydRu8DirxRchrnM",
+		[]uint64{6148575882689263770, 16744073374260902175},
+		0,
+		[]string{"a", "d", "b", "c"},
+	},
+	{
+		"GWXCpe6XsbyMfd5Rl2ud0G0cGEd1Uu05frtNtaBL2Xo", 2,
+		"KA0XE3S6bByelOjbpLiiUHecp1eLLoFwch9ePWiG6H4",
+		[]uint64{9138172653462612020, 3998149649512711068},
+		0,
+		[]string{"d", "c", "b", "a"},
+	},
+	{
+		"GWXCpe6XsbyMfd5Rl2ud0G0cGEd1Uu05frtNtaBL2Xo", 3,
+		"2L6zdWtx3c3v+3yrEdz+LSfzPsdGgpeFdNwJfwOHRvo",
+		[]uint64{12078802003927255769, 16312799243968333736},
+		9,
+		[]string{"c", "a", "b", "d"},
+	},
+}
+
+func TestSeedVectors(t *testing.T) {
+	for _, v := range seedVectors {
+		s := NewSeed(v.previous, v.slot)
+		if s.String() != v.seed {
+			t.Fatalf("slot %d should have seed %s but got %s", v.slot, v.seed, s)
+		}
+		for i, draw := range v.draws {
+			if x := s.Uint64("lottery", i); x != draw {
+				t.Fatalf("slot %d draw %d should be %d but got %d", v.slot, i, draw, x)
+			}
+		}
+		if x := s.Intn("lottery", 10); x != v.intn {
+			t.Fatalf("slot %d should draw %d of 10 but got %d", v.slot, v.intn, x)
+		}
+		shuffled := s.Shuffle("order", []string{"a", "b", "c", "d"})
+		if !reflect.DeepEqual(shuffled, v.shuffled) {
+			t.Fatalf("slot %d should shuffle to %v but got %v", v.slot, v.shuffled, shuffled)
+		}
+	}
+}
+
+func TestSeedIntnIsUniform(t *testing.T) {
+	s := NewSeed("", 1)
+	counts := make([]int, 6)
+	for i := 0; i < 6000; i++ {
+		counts[s.Intn(fmt.Sprintf("roll %d", i), 6)]++
+	}
+	for i, count := range counts {
+		if count < 850 || count > 1150 {
+			t.Fatalf("drew %d %d times out of 6000", i, count)
+		}
+	}
+}
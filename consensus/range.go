@@ -1,5 +1,9 @@
 package consensus
 
+import (
+	"sort"
+)
+
 // Simple utilities for dealing with ranges of numbers
 // In general, ranges are inclusive of their endpoints and 0, 0 is
 // used for the null range.
@@ -37,3 +41,37 @@ func MakeRange(numbers ...int) (int, int) {
 	}
 	return min, max
 }
+
+// An Interval is the range of numbers from Min to Max, inclusive.
+type Interval struct {
+	Min int
+	Max int
+}
+
+// Partition splits the range min..max into intervals, so that each boundary
+// inside the range is an interval of its own, and so is each gap between
+// boundaries. Anything that can only change at a boundary is the same for
+// every number in an interval, so it only needs to be checked at the ends,
+// no matter how long the range is.
+func Partition(min int, max int, boundaries []int) []Interval {
+	if min > max {
+		return nil
+	}
+	points := []int{min}
+	sorted := append([]int{max}, boundaries...)
+	sort.Ints(sorted)
+	for _, n := range sorted {
+		if points[len(points)-1] < n && n <= max {
+			points = append(points, n)
+		}
+	}
+
+	answer := []Interval{}
+	for i, n := range points {
+		answer = append(answer, Interval{Min: n, Max: n})
+		if i+1 < len(points) && n+1 < points[i+1] {
+			answer = append(answer, Interval{Min: n + 1, Max: points[i+1] - 1})
+		}
+	}
+	return answer
+}
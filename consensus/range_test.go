@@ -0,0 +1,28 @@
+package consensus
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPartition(t *testing.T) {
+	cases := []struct {
+		min        int
+		max        int
+		boundaries []int
+		expected   []Interval
+	}{
+		{1, 1, nil, []Interval{{1, 1}}},
+		{1, 10, nil, []Interval{{1, 1}, {2, 9}, {10, 10}}},
+		{1, 10, []int{0, 3, 3, 4, 12}, []Interval{{1, 1}, {2, 2}, {3, 3}, {4, 4}, {5, 9}, {10, 10}}},
+		{5, 1000000000, []int{7}, []Interval{{5, 5}, {6, 6}, {7, 7}, {8, 999999999}, {1000000000, 1000000000}}},
+		{3, 2, []int{1}, nil},
+	}
+	for _, c := range cases {
+		actual := Partition(c.min, c.max, c.boundaries)
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("Partition(%d, %d, %v) = %v, expected %v",
+				c.min, c.max, c.boundaries, actual, c.expected)
+		}
+	}
+}
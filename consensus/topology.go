@@ -0,0 +1,280 @@
+package consensus
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/lacker/coinkit/util"
+)
+
+// A Topology is the quorum slice of every node in a network, keyed by the
+// node's public key. MakeTestQuorumSlice gives every node the same slice, but
+// real networks don't have to, and the protocol is only safe when any two
+// quorums share a node. The generators here make networks with different
+// slices for testing, and the analysis says whether they are safe.
+type Topology map[string]QuorumSlice
+
+// MaxAnalyzedNodes is the most nodes a topology can have for
+// DisjointQuorums, since it checks every subset of the nodes.
+const MaxAnalyzedNodes = 20
+
+// makeTestKeys makes the public keys for n test nodes. They are the same keys
+// MakeTestQuorumSlice uses, so the servers in tests can find their key pairs.
+func makeTestKeys(n int) ([]util.PublicKey, []string) {
+	pks := []util.PublicKey{}
+	names := []string{}
+	for i := 0; i < n; i++ {
+		pk := util.NewKeyPairFromSecretPhrase(fmt.Sprintf("node%d", i)).PublicKey()
+		pks = append(pks, pk)
+		names = append(names, pk.String())
+	}
+	return pks, names
+}
+
+// MakeTieredTopology makes a network of core validators that all trust each
+// other, like MakeTestQuorumSlice, plus leaf nodes that trust the core but
+// aren't trusted by anyone. The core nodes come first in the returned keys.
+func MakeTieredTopology(core int, leaves int) (Topology, []util.PublicKey) {
+	pks, names := makeTestKeys(core + leaves)
+	coreSlice := MakeQuorumSlice(names[:core], 2*core/3+1)
+	t := Topology{}
+	for _, name := range names[:core] {
+		t[name] = coreSlice
+	}
+	for _, name := range names[core:] {
+		members := append([]string{name}, names[:core]...)
+		t[name] = MakeQuorumSlice(members, coreSlice.Threshold+1)
+	}
+	return t, pks
+}
+
+// MakeOrganizationTopology makes a network of organizations that each run
+// perOrg nodes. Every node trusts its own organization and the next one,
+// wrapping around, with a threshold of more than two thirds. So each
+// organization's slices overlap with its neighbors' but not with the rest.
+// The keys are in order of organization.
+func MakeOrganizationTopology(orgs int, perOrg int) (Topology, []util.PublicKey) {
+	pks, names := makeTestKeys(orgs * perOrg)
+	t := Topology{}
+	for org := 0; org < orgs; org++ {
+		next := (org + 1) % orgs
+		members := append([]string{}, names[org*perOrg:(org+1)*perOrg]...)
+		if next != org {
+			members = append(members, names[next*perOrg:(next+1)*perOrg]...)
+		}
+		slice := MakeQuorumSlice(members, 2*len(members)/3+1)
+		for _, name := range names[org*perOrg : (org+1)*perOrg] {
+			t[name] = slice
+		}
+	}
+	return t, pks
+}
+
+// MakeSplitTopology makes a network that is two groups of size nodes which
+// only trust themselves. Each group is a quorum on its own, so the network
+// can fork. It is for testing that a split is caught.
+func MakeSplitTopology(size int) (Topology, []util.PublicKey) {
+	pks, names := makeTestKeys(2 * size)
+	t := Topology{}
+	for _, group := range [][]string{names[:size], names[size:]} {
+		slice := MakeQuorumSlice(group, 2*size/3+1)
+		for _, name := range group {
+			t[name] = slice
+		}
+	}
+	return t, pks
+}
+
+// Nodes returns the nodes in the topology, sorted.
+func (t Topology) Nodes() []string {
+	answer := []string{}
+	for node := range t {
+		answer = append(answer, node)
+	}
+	sort.Strings(answer)
+	return answer
+}
+
+// IsQuorum returns whether a nonempty set of nodes is a quorum: every node in
+// it has a slice that the set satisfies.
+func (t Topology) IsQuorum(nodes []string) bool {
+	if len(nodes) == 0 {
+		return false
+	}
+	for _, node := range nodes {
+		qs, ok := t[node]
+		if !ok || !qs.SatisfiedWith(nodes) {
+			return false
+		}
+	}
+	return true
+}
+
+// largestQuorum returns the largest quorum made of these nodes, or an empty
+// list if there is none. It drops nodes whose slices aren't satisfied until
+// the rest are a quorum, like MeetsQuorum.
+func (t Topology) largestQuorum(nodes []string) []string {
+	for {
+		filtered := []string{}
+		for _, node := range nodes {
+			if qs, ok := t[node]; ok && qs.SatisfiedWith(nodes) {
+				filtered = append(filtered, node)
+			}
+		}
+		if len(filtered) == len(nodes) {
+			return filtered
+		}
+		nodes = filtered
+	}
+}
+
+// DisjointQuorums looks for two quorums that don't share a node. It returns
+// nil slices when every pair of quorums intersects. It returns an error if the
+// topology has more than MaxAnalyzedNodes nodes.
+func (t Topology) DisjointQuorums() ([]string, []string, error) {
+	nodes := t.Nodes()
+	if len(nodes) > MaxAnalyzedNodes {
+		return nil, nil, fmt.Errorf("can only analyze %d nodes, not %d",
+			MaxAnalyzedNodes, len(nodes))
+	}
+
+	// Any two disjoint quorums split the nodes into a set that contains one
+	// and a set that contains the other, so it is enough to check every way
+	// to split the nodes in two. Each split is checked twice, which is fine.
+	for mask := 1; mask < 1<<uint(len(nodes)); mask++ {
+		in, out := []string{}, []string{}
+		for i, node := range nodes {
+			if mask&(1<<uint(i)) != 0 {
+				in = append(in, node)
+			} else {
+				out = append(out, node)
+			}
+		}
+		a := t.largestQuorum(in)
+		if len(a) == 0 {
+			continue
+		}
+		if b := t.largestQuorum(out); len(b) > 0 {
+			return a, b, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+// HasQuorumIntersection returns whether every two quorums in the topology
+// share a node, which is what keeps the network from forking.
+func (t Topology) HasQuorumIntersection() (bool, error) {
+	a, _, err := t.DisjointQuorums()
+	if err != nil {
+		return false, err
+	}
+	return a == nil, nil
+}
+
+// MaxSplitAnalyzedNodes is the most nodes a topology can have for
+// MinimalSplittingSets, which is slower than DisjointQuorums.
+const MaxSplitAnalyzedNodes = 12
+
+// subsetsOfSize calls f with every subset of nodes that has k members, until
+// f returns false.
+func subsetsOfSize(nodes []string, k int, f func(subset []string) bool) bool {
+	var helper func(start int, subset []string) bool
+	helper = func(start int, subset []string) bool {
+		if len(subset) == k {
+			return f(subset)
+		}
+		for i := start; i <= len(nodes)-(k-len(subset)); i++ {
+			if !helper(i+1, append(subset, nodes[i])) {
+				return false
+			}
+		}
+		return true
+	}
+	return helper(0, make([]string, 0, k))
+}
+
+// without returns the nodes that aren't in remove.
+func without(nodes []string, remove []string) []string {
+	removed := map[string]bool{}
+	for _, node := range remove {
+		removed[node] = true
+	}
+	answer := []string{}
+	for _, node := range nodes {
+		if !removed[node] {
+			answer = append(answer, node)
+		}
+	}
+	return answer
+}
+
+// splits returns whether there are two quorums that only share nodes in s.
+// If the nodes in s lie, the rest of the network can fork.
+func (t Topology) splits(s []string) bool {
+	rest := without(t.Nodes(), s)
+	for mask := 0; mask < 1<<uint(len(rest)); mask++ {
+		a, b := append([]string{}, s...), append([]string{}, s...)
+		for i, node := range rest {
+			if mask&(1<<uint(i)) != 0 {
+				a = append(a, node)
+			} else {
+				b = append(b, node)
+			}
+		}
+		if len(t.largestQuorum(a)) > 0 && len(t.largestQuorum(b)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// MinimalSplittingSets returns the smallest sets of nodes that, by lying,
+// could get two quorums to confirm different values. A network is only as
+// safe as its smallest splitting set is large. It returns an empty list if
+// there are no quorums to split, and an error if the topology has more than
+// MaxSplitAnalyzedNodes nodes.
+func (t Topology) MinimalSplittingSets() ([][]string, error) {
+	nodes := t.Nodes()
+	if len(nodes) > MaxSplitAnalyzedNodes {
+		return nil, fmt.Errorf("can only look for splitting sets among %d nodes, not %d",
+			MaxSplitAnalyzedNodes, len(nodes))
+	}
+	for k := 0; k <= len(nodes); k++ {
+		answer := [][]string{}
+		subsetsOfSize(nodes, k, func(s []string) bool {
+			if t.splits(s) {
+				answer = append(answer, append([]string{}, s...))
+			}
+			return true
+		})
+		if len(answer) > 0 {
+			return answer, nil
+		}
+	}
+	return [][]string{}, nil
+}
+
+// MinimalBlockingSets returns the smallest sets of nodes that, by going
+// down, leave no quorum among the rest, so the network stops making
+// progress. It returns an error if the topology has more than
+// MaxAnalyzedNodes nodes.
+func (t Topology) MinimalBlockingSets() ([][]string, error) {
+	nodes := t.Nodes()
+	if len(nodes) > MaxAnalyzedNodes {
+		return nil, fmt.Errorf("can only analyze %d nodes, not %d",
+			MaxAnalyzedNodes, len(nodes))
+	}
+	for k := 0; k <= len(nodes); k++ {
+		answer := [][]string{}
+		subsetsOfSize(nodes, k, func(s []string) bool {
+			if len(t.largestQuorum(without(nodes, s))) == 0 {
+				answer = append(answer, append([]string{}, s...))
+			}
+			return true
+		})
+		if len(answer) > 0 {
+			return answer, nil
+		}
+	}
+	return [][]string{}, nil
+}
@@ -0,0 +1,124 @@
+package consensus
+
+import (
+	"testing"
+)
+
+func TestSymmetricTopologyIntersects(t *testing.T) {
+	qs, pks := MakeTestQuorumSlice(4)
+	top := Topology{}
+	for _, pk := range pks {
+		top[pk.String()] = qs
+	}
+	ok, err := top.HasQuorumIntersection()
+	if err != nil || !ok {
+		t.Fatalf("a 3-of-4 network should have quorum intersection: %v", err)
+	}
+	if !top.IsQuorum(qs.Members[:3]) || top.IsQuorum(qs.Members[:2]) {
+		t.Fatal("any 3 nodes should be a quorum, and 2 should not")
+	}
+}
+
+func TestTieredTopology(t *testing.T) {
+	top, pks := MakeTieredTopology(4, 3)
+	if len(top) != 7 || len(pks) != 7 {
+		t.Fatalf("expected 7 nodes but got %d", len(top))
+	}
+	leaf := pks[4].String()
+	slice := top[leaf]
+	if !slice.Contains(leaf) || slice.Threshold != 4 {
+		t.Fatalf("bad leaf slice: %+v", slice)
+	}
+	ok, err := top.HasQuorumIntersection()
+	if err != nil || !ok {
+		t.Fatalf("leaves that trust the core should not break intersection: %v", err)
+	}
+	core := []string{pks[0].String(), pks[1].String(), pks[2].String()}
+	if !top.IsQuorum(core) {
+		t.Fatal("3 of the core should be a quorum without any leaves")
+	}
+	if top.IsQuorum(append(core, leaf)[1:]) {
+		t.Fatal("2 of the core plus a leaf should not be a quorum")
+	}
+}
+
+func TestOrganizationTopology(t *testing.T) {
+	top, _ := MakeOrganizationTopology(3, 3)
+	ok, err := top.HasQuorumIntersection()
+	if err != nil || !ok {
+		t.Fatalf("3 overlapping organizations should intersect: %v", err)
+	}
+
+	// Each organization needs its neighbor, so a quorum goes all the way
+	// around the ring
+	top, pks := MakeOrganizationTopology(4, 2)
+	names := []string{}
+	for _, pk := range pks[:6] {
+		names = append(names, pk.String())
+	}
+	if top.IsQuorum(names) {
+		t.Fatal("3 of 4 organizations should not be a quorum")
+	}
+	a, _, err := top.DisjointQuorums()
+	if err != nil || a != nil {
+		t.Fatalf("a ring of organizations should intersect: %v %v", a, err)
+	}
+}
+
+func TestSplitTopology(t *testing.T) {
+	top, pks := MakeSplitTopology(3)
+	a, b, err := top.DisjointQuorums()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !top.IsQuorum(a) || !top.IsQuorum(b) {
+		t.Fatalf("the quorums should be quorums: %v %v", a, b)
+	}
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				t.Fatalf("the quorums should be disjoint: %v %v", a, b)
+			}
+		}
+	}
+	if len(pks) != 6 {
+		t.Fatalf("expected 6 keys but got %d", len(pks))
+	}
+}
+
+func TestTopologyTooBig(t *testing.T) {
+	top, _ := MakeTieredTopology(4, MaxAnalyzedNodes)
+	if _, err := top.HasQuorumIntersection(); err == nil {
+		t.Fatal("a topology this big should not be analyzed")
+	}
+}
+
+func TestMinimalSplittingSets(t *testing.T) {
+	// 3-of-4 tolerates one liar but not two
+	top, _ := MakeTieredTopology(4, 0)
+	sets, err := top.MinimalSplittingSets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sets) != 6 || len(sets[0]) != 2 {
+		t.Fatalf("every pair of nodes should split 3-of-4: %v", sets)
+	}
+
+	// A network that is already split doesn't need any liars
+	top, _ = MakeSplitTopology(2)
+	sets, err = top.MinimalSplittingSets()
+	if err != nil || len(sets) != 1 || len(sets[0]) != 0 {
+		t.Fatalf("the empty set should split a split network: %v %v", sets, err)
+	}
+}
+
+func TestMinimalBlockingSets(t *testing.T) {
+	top, _ := MakeTieredTopology(4, 2)
+	sets, err := top.MinimalBlockingSets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sets) != 6 || len(sets[0]) != 2 {
+		t.Fatalf("any 2 core nodes should block a 3-of-4 core: %v", sets)
+	}
+}
@@ -37,14 +37,18 @@ func HasSlotValue(a []SlotValue, b SlotValue) bool {
 // use a value manager to have a unique id for every possible value.
 // This also helps test the consensus protocol with test values.
 type ValueStore interface {
+	// The value store is told about each finalized value before the chain
+	// starts on the next slot
+	ExternalizeHandler
+
+	// Combine merges values into one. It must only depend on the set of
+	// values, not on their order or on repeats, so that every node that
+	// combines the same values gets the same result.
 	Combine(list []SlotValue) SlotValue
 
 	// Whether the ValueStore is ready to finalize this value
 	CanFinalize(v SlotValue) bool
 
-	// Called when a value is finalized
-	Finalize(v SlotValue)
-
 	// The last finalized slot value
 	Last() SlotValue
 
@@ -54,7 +58,10 @@ type ValueStore interface {
 	SuggestValue() (SlotValue, bool)
 
 	// ValidateValue returns whether a value can be used by the consensus
-	// mechanism.
+	// mechanism. Nodes only vote to nominate, or accept the nomination of,
+	// values that validate. It should check whatever the application needs,
+	// so that a bad peer can't get garbage nominated with the help of honest
+	// nodes.
 	ValidateValue(v SlotValue) bool
 }
 
@@ -90,7 +97,7 @@ func (t *TestValueStore) CanFinalize(v SlotValue) bool {
 	return true
 }
 
-func (t *TestValueStore) Finalize(v SlotValue) {
+func (t *TestValueStore) Externalized(slot int, v SlotValue) {
 	t.last = v
 }
 
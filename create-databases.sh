@@ -9,3 +9,8 @@ createdb local0
 createdb local1
 createdb local2
 createdb local3
+
+createdb devnet0
+createdb devnet1
+createdb devnet2
+createdb devnet3
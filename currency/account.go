@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"sort"
 )
 
 // Accounts are stored in units of nanocoins.
@@ -20,8 +21,36 @@ type Account struct {
 
 	// The current balance of this account.
 	Balance uint64
+
+	// Metadata the owner attached with SetAccountDataOperations, like a
+	// display name. Nil when there is none.
+	Data map[string]string `json:",omitempty"`
+
+	// How many bytes of documents this account has written. See
+	// DocumentQuota.
+	Storage uint64 `json:",omitempty"`
+
+	// The first slot that left the account with money. 0 if none has, like
+	// for an account funded at genesis that hasn't changed since.
+	Created int `json:",omitempty"`
+
+	// The last slot in which the account changed. 0 if none has.
+	LastActive int `json:",omitempty"`
 }
 
+// AccountActivity is how active an account has been lately, which nodes
+// with a database work out from the account's history. It isn't part of the
+// account state that blocks agree on.
+type AccountActivity struct {
+	Owner string
+
+	// How many of the last RecentActivitySlots blocks changed the account
+	Recent int
+}
+
+// How many blocks count as recent, for AccountActivity
+const RecentActivitySlots = 1000
+
 // For debugging
 func StringifyAccount(a *Account) string {
 	if a == nil {
@@ -30,8 +59,50 @@ func StringifyAccount(a *Account) string {
 	return fmt.Sprintf("s%d:b%d", a.Sequence, a.Balance)
 }
 
+// Bytes is the form of the account that gets hashed. Accounts without data,
+// storage, or activity are just the sequence and the balance, so their
+// hashes are the same as before accounts could have data.
 func (a Account) Bytes() []byte {
 	var buffer bytes.Buffer
-	binary.Write(&buffer, binary.LittleEndian, a)
+	binary.Write(&buffer, binary.LittleEndian, a.Sequence)
+	binary.Write(&buffer, binary.LittleEndian, a.Balance)
+	keys := []string{}
+	for key := range a.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		writeString(&buffer, key)
+		writeString(&buffer, a.Data[key])
+	}
+	if a.Storage > 0 || a.LastActive > 0 {
+		// Data keys can't be empty, so an empty key marks the storage
+		writeString(&buffer, "")
+		binary.Write(&buffer, binary.LittleEndian, a.Storage)
+	}
+	if a.LastActive > 0 {
+		binary.Write(&buffer, binary.LittleEndian, uint32(a.Created))
+		binary.Write(&buffer, binary.LittleEndian, uint32(a.LastActive))
+	}
 	return buffer.Bytes()
 }
+
+// writeString writes a string prefixed with its length, so that the end of
+// one string can't be confused with the start of the next.
+func writeString(buffer *bytes.Buffer, s string) {
+	binary.Write(buffer, binary.LittleEndian, uint32(len(s)))
+	buffer.WriteString(s)
+}
+
+// SameData returns whether two accounts have the same data.
+func (a *Account) SameData(other *Account) bool {
+	if len(a.Data) != len(other.Data) {
+		return false
+	}
+	for key, value := range a.Data {
+		if other.Data[key] != value {
+			return false
+		}
+	}
+	return true
+}
@@ -1,6 +1,10 @@
 package currency
 
 import (
+	"crypto/sha512"
+	"encoding/base64"
+	"sort"
+
 	"github.com/lacker/coinkit/util"
 )
 
@@ -12,6 +16,21 @@ type AccountMap struct {
 	// We use the fallback when we don't have data on an account
 	// Can be nil
 	fallback *AccountMap
+
+	// The reserve every account has to keep. See SetReserve
+	reserve uint64
+
+	// Where fees go. See fee_distribution.go
+	feePool          string
+	feesToValidators bool
+
+	// The validators for the slot the next chunk is for
+	validators []string
+
+	// The emission schedule, and the slot the next chunk is for. See
+	// emission.go
+	emission *Emission
+	slot     int
 }
 
 func NewAccountMap() *AccountMap {
@@ -24,27 +43,79 @@ func NewAccountMap() *AccountMap {
 // made won't be visible in the original
 func (m *AccountMap) CowCopy() *AccountMap {
 	return &AccountMap{
-		data:     make(map[string]*Account),
-		fallback: m,
+		data:             make(map[string]*Account),
+		fallback:         m,
+		reserve:          m.reserve,
+		feePool:          m.feePool,
+		feesToValidators: m.feesToValidators,
+		validators:       m.validators,
+		emission:         m.emission,
+		slot:             m.slot,
 	}
 }
 
-func (m *AccountMap) MaxBalance() uint64 {
-	answer := uint64(0)
-	for _, account := range m.data {
-		if account.Balance > answer {
-			answer = account.Balance
-		}
+// SetReserve sets the least balance an account can have, other than zero.
+// An operation is invalid if it leaves an account it touches with less than
+// the reserve, so money can't be split up into lots of dust accounts. A new
+// account has to be sent at least the reserve, and an account can't spend
+// its reserve, except by sending everything it has.
+// Every node on a network has to use the same reserve. Zero means there is
+// none.
+func (m *AccountMap) SetReserve(reserve uint64) {
+	m.reserve = reserve
+}
+
+// Reserve is the least balance an account can have, other than zero.
+func (m *AccountMap) Reserve() uint64 {
+	return m.reserve
+}
+
+// keepsReserve returns whether an account can be left with this balance.
+func (m *AccountMap) keepsReserve(balance uint64) bool {
+	return balance == 0 || balance >= m.reserve
+}
+
+// NewAccountMapFromState makes an account map holding the given accounts.
+func NewAccountMapFromState(state map[string]*Account) *AccountMap {
+	m := NewAccountMap()
+	for owner, account := range state {
+		m.Set(owner, account)
 	}
+	return m
+}
+
+// Snapshot returns every account in the map, including the fallback's.
+// Accounts are never modified in place, so the snapshot stays valid as the
+// map changes.
+func (m *AccountMap) Snapshot() map[string]*Account {
+	answer := make(map[string]*Account)
 	if m.fallback != nil {
-		b := m.fallback.MaxBalance()
-		if b > answer {
-			answer = b
-		}
+		answer = m.fallback.Snapshot()
+	}
+	for owner, account := range m.data {
+		answer[owner] = account
 	}
 	return answer
 }
 
+// StateRoot hashes the state of every account, so that two nodes with the
+// same accounts have the same root.
+func StateRoot(state map[string]*Account) string {
+	keys := []string{}
+	for key, account := range state {
+		if account != nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	h := sha512.New512_256()
+	for _, key := range keys {
+		h.Write([]byte(key))
+		h.Write(state[key].Bytes())
+	}
+	return base64.RawStdEncoding.EncodeToString(h.Sum(nil))
+}
+
 // Checks that the data in the account map is what we expect
 func (m *AccountMap) CheckEqual(key string, account *Account) bool {
 	a := m.Get(key)
@@ -54,7 +125,14 @@ func (m *AccountMap) CheckEqual(key string, account *Account) bool {
 	if a == nil || account == nil {
 		return false
 	}
-	return a.Sequence == account.Sequence && a.Balance == account.Balance
+	if account.LastActive != 0 &&
+		(a.Created != account.Created || a.LastActive != account.LastActive) {
+		// Chunks from before accounts kept track of their activity don't
+		// have it, so it's only checked when it's there
+		return false
+	}
+	return a.Sequence == account.Sequence && a.Balance == account.Balance &&
+		a.SameData(account)
 }
 
 func (m *AccountMap) Get(key string) *Account {
@@ -69,60 +147,228 @@ func (m *AccountMap) Set(key string, account *Account) {
 	m.data[key] = account
 }
 
+// setActive sets an account that changed in the slot the next chunk is for.
+// The first time the account has money is when it was created.
+func (m *AccountMap) setActive(key string, account *Account) {
+	if account.Created == 0 && account.Balance > 0 {
+		account.Created = m.slot
+	}
+	account.LastActive = m.slot
+	m.Set(key, account)
+}
+
 // Validate returns whether this operation is valid
 func (m *AccountMap) Validate(op util.Operation) bool {
-	t, ok := op.(*SendOperation)
-	if !ok {
-		panic("AccountMap cannot validate non-SendOperation operations")
-	}
-	account := m.Get(t.Signer)
-	if account == nil {
-		return false
-	}
-	if account.Sequence+1 != t.Sequence {
-		return false
-	}
-	cost := t.Amount + t.Fee
-	if cost > account.Balance {
-		return false
+	switch t := op.(type) {
+	case *SendOperation:
+		account := m.Get(t.Signer)
+		if account == nil {
+			return false
+		}
+		if account.Sequence+1 != t.Sequence {
+			return false
+		}
+		cost, ok := AddAmounts(t.Amount, t.Fee)
+		if !ok || cost > account.Balance {
+			return false
+		}
+		if t.Recipient() == t.Signer {
+			return m.keepsReserve(account.Balance - t.Fee)
+		}
+		if !m.keepsReserve(account.Balance - cost) {
+			return false
+		}
+		target := m.Get(t.Recipient())
+		if target == nil {
+			target = &Account{}
+		}
+		balance, ok := AddAmounts(target.Balance, t.Amount)
+		return ok && m.keepsReserve(balance)
+
+	case *ValidatorOperation:
+		// Validators may not have an account, as long as they don't pay a fee
+		account := m.Get(t.Signer)
+		if account == nil {
+			account = &Account{}
+		}
+		return account.Sequence+1 == t.Sequence && m.canPay(account, t.Fee)
+
+	case *MessageOperation:
+		account := m.Get(t.Signer)
+		if account == nil {
+			return false
+		}
+		return account.Sequence+1 == t.Sequence && m.canPay(account, t.Fee)
+
+	case *UpdateDocumentOperation:
+		// Whether the signer owns the document is up to the document store
+		account := m.Get(t.Signer)
+		if account == nil || account.Sequence+1 != t.Sequence || !m.canPay(account, t.Fee) {
+			return false
+		}
+		after := &Account{Balance: account.Balance - t.Fee}
+		return account.Storage+t.Size() <= after.DocumentQuota()
+
+	case *PinBlobOperation:
+		account := m.Get(t.Signer)
+		if account == nil {
+			return false
+		}
+		return account.Sequence+1 == t.Sequence && m.canPay(account, t.Fee)
+
+	case *SetAccountDataOperation:
+		account := m.Get(t.Signer)
+		if account == nil || account.Sequence+1 != t.Sequence || !m.canPay(account, t.Fee) {
+			return false
+		}
+		_, ok := t.apply(account.Data)
+		return ok
+
+	default:
+		panic("AccountMap cannot validate this operation type")
 	}
+}
 
-	return true
+// canPay returns whether an account can pay a fee and keep its reserve.
+func (m *AccountMap) canPay(account *Account, fee uint64) bool {
+	return fee <= account.Balance && m.keepsReserve(account.Balance-fee)
 }
 
 func (m *AccountMap) SetBalance(owner string, amount uint64) {
 	oldAccount := m.Get(owner)
-	sequence := uint32(0)
-	if oldAccount != nil {
-		sequence = oldAccount.Sequence
+	if oldAccount == nil {
+		oldAccount = &Account{}
 	}
-	m.Set(owner, &Account{Sequence: sequence, Balance: amount})
+	m.Set(owner, &Account{
+		Sequence:   oldAccount.Sequence,
+		Balance:    amount,
+		Data:       oldAccount.Data,
+		Storage:    oldAccount.Storage,
+		Created:    oldAccount.Created,
+		LastActive: oldAccount.LastActive,
+	})
 }
 
 // Process returns false if the transaction cannot be processed
 func (m *AccountMap) Process(op util.Operation) bool {
-	t, ok := op.(*SendOperation)
-	if !ok {
-		panic("AccountMap cannot process non-SendOperation operations")
-	}
-	if !m.Validate(t) {
+	if !m.Validate(op) {
 		return false
 	}
-	source := m.Get(t.Signer)
-	target := m.Get(t.To)
-	if target == nil {
-		target = &Account{}
-	}
-	newSource := &Account{
-		Sequence: t.Sequence,
-		Balance:  source.Balance - t.Amount - t.Fee,
-	}
-	newTarget := &Account{
-		Sequence: target.Sequence,
-		Balance:  target.Balance + t.Amount,
+	switch t := op.(type) {
+	case *SendOperation:
+		source := m.Get(t.Signer)
+		cost, _ := AddAmounts(t.Amount, t.Fee)
+		sourceBalance, ok := SubtractAmounts(source.Balance, cost)
+		if !ok {
+			return false
+		}
+		m.setActive(t.Signer, &Account{
+			Sequence: t.Sequence,
+			Balance:  sourceBalance,
+			Data:     source.Data,
+			Storage:  source.Storage,
+			Created:  source.Created,
+		})
+
+		// The target is read after the source is updated, in case they are
+		// the same account
+		target := m.Get(t.Recipient())
+		if target == nil {
+			target = &Account{}
+		}
+		targetBalance, ok := AddAmounts(target.Balance, t.Amount)
+		if !ok {
+			return false
+		}
+		m.setActive(t.Recipient(), &Account{
+			Sequence: target.Sequence,
+			Balance:  targetBalance,
+			Data:     target.Data,
+			Storage:  target.Storage,
+			Created:  target.Created,
+		})
+
+	case *ValidatorOperation:
+		// The vote itself is counted by the ValidatorSet
+		source := m.Get(t.Signer)
+		if source == nil {
+			source = &Account{}
+		}
+		balance, ok := SubtractAmounts(source.Balance, t.Fee)
+		if !ok {
+			return false
+		}
+		m.setActive(t.Signer, &Account{
+			Sequence: t.Sequence,
+			Balance:  balance,
+			Data:     source.Data,
+			Storage:  source.Storage,
+			Created:  source.Created,
+		})
+
+	case *MessageOperation:
+		// The message itself is only stored in the document store
+		source := m.Get(t.Signer)
+		balance, ok := SubtractAmounts(source.Balance, t.Fee)
+		if !ok {
+			return false
+		}
+		m.setActive(t.Signer, &Account{
+			Sequence: t.Sequence,
+			Balance:  balance,
+			Data:     source.Data,
+			Storage:  source.Storage,
+			Created:  source.Created,
+		})
+
+	case *UpdateDocumentOperation:
+		// The document itself is only stored in the document store
+		source := m.Get(t.Signer)
+		balance, ok := SubtractAmounts(source.Balance, t.Fee)
+		if !ok {
+			return false
+		}
+		m.setActive(t.Signer, &Account{
+			Sequence: t.Sequence,
+			Balance:  balance,
+			Data:     source.Data,
+			Storage:  source.Storage + t.Size(),
+			Created:  source.Created,
+		})
+
+	case *PinBlobOperation:
+		// The pin itself is only stored by nodes with a database
+		source := m.Get(t.Signer)
+		balance, ok := SubtractAmounts(source.Balance, t.Fee)
+		if !ok {
+			return false
+		}
+		m.setActive(t.Signer, &Account{
+			Sequence: t.Sequence,
+			Balance:  balance,
+			Data:     source.Data,
+			Storage:  source.Storage,
+			Created:  source.Created,
+		})
+
+	case *SetAccountDataOperation:
+		source := m.Get(t.Signer)
+		balance, ok := SubtractAmounts(source.Balance, t.Fee)
+		if !ok {
+			return false
+		}
+		data, ok := t.apply(source.Data)
+		if !ok {
+			return false
+		}
+		m.setActive(t.Signer, &Account{
+			Sequence: t.Sequence,
+			Balance:  balance,
+			Data:     data,
+			Storage:  source.Storage,
+			Created:  source.Created,
+		})
 	}
-	m.Set(t.Signer, newSource)
-	m.Set(t.To, newTarget)
 	return true
 }
 
@@ -137,11 +383,15 @@ func (m *AccountMap) ProcessChunk(chunk *LedgerChunk) bool {
 		return false
 	}
 
-	for _, op := range chunk.SendOperations() {
-		if op == nil || !op.Verify() || !m.Process(op) {
+	for _, op := range chunk.Operations {
+		if op == nil || op.Operation == nil || !op.Operation.Verify() ||
+			!m.Process(op.Operation) {
 			return false
 		}
 	}
+	if _, ok := m.creditBlock(chunk.Operations); !ok {
+		return false
+	}
 
 	for owner, account := range chunk.State {
 		if !m.CheckEqual(owner, account) {
@@ -157,3 +407,20 @@ func (m *AccountMap) ValidateChunk(chunk *LedgerChunk) bool {
 	copy := m.CowCopy()
 	return copy.ProcessChunk(chunk)
 }
+
+// ApplyChunk processes a chunk only if the whole chunk can be processed, and
+// returns whether it was. Unlike calling ValidateChunk and then
+// ProcessChunk, it only processes the operations once.
+// Operations are processed in order, since each one can depend on the ones
+// before it. Their signatures aren't checked here; that happens when they
+// are decoded, or in LedgerChunk.CheckProposal.
+func (m *AccountMap) ApplyChunk(chunk *LedgerChunk) bool {
+	copy := m.CowCopy()
+	if !copy.ProcessChunk(chunk) {
+		return false
+	}
+	for owner, account := range copy.data {
+		m.Set(owner, account)
+	}
+	return true
+}
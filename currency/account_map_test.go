@@ -0,0 +1,51 @@
+package currency
+
+import (
+	"testing"
+)
+
+// Process used to read the recipient before it debited the sender, so a
+// send to yourself kept the amount and didn't advance the sequence.
+func TestSendToSelf(t *testing.T) {
+	m := NewAccountMap()
+	m.SetBalance("alice", 200)
+	op := &SendOperation{Signer: "alice", Sequence: 1, To: "alice", Amount: 100, Fee: 3}
+	if !m.Process(op) {
+		t.Fatal("sending to yourself should work")
+	}
+	alice := m.Get("alice")
+	if alice.Sequence != 1 || alice.Balance != 197 {
+		t.Fatalf("sending to yourself should only cost the fee: %+v", alice)
+	}
+}
+
+func TestAccountActivity(t *testing.T) {
+	m := NewAccountMap()
+	m.SetBalance("alice", 200)
+	m.SetSlot(3)
+	if !m.Process(&SendOperation{Signer: "alice", Sequence: 1, To: "bob", Amount: 100}) {
+		t.Fatal("the send should work")
+	}
+	m.SetSlot(5)
+	if !m.Process(&SendOperation{Signer: "bob", Sequence: 1, To: "carol", Amount: 10}) {
+		t.Fatal("the send should work")
+	}
+	alice, bob, carol := m.Get("alice"), m.Get("bob"), m.Get("carol")
+	if alice.Created != 3 || alice.LastActive != 3 {
+		t.Fatalf("bad activity for alice: %+v", alice)
+	}
+	if bob.Created != 3 || bob.LastActive != 5 {
+		t.Fatalf("bad activity for bob: %+v", bob)
+	}
+	if carol.Created != 5 || carol.LastActive != 5 {
+		t.Fatalf("bad activity for carol: %+v", carol)
+	}
+
+	// The activity is part of the state that blocks agree on
+	if m.CheckEqual("bob", &Account{Sequence: 1, Balance: 90, Created: 3, LastActive: 4}) {
+		t.Fatal("a different last active slot should not check as equal")
+	}
+	if string(bob.Bytes()) == string((&Account{Sequence: 1, Balance: 90}).Bytes()) {
+		t.Fatal("the activity should be hashed")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/lacker/coinkit/consensus"
 	"github.com/lacker/coinkit/util"
 )
 
@@ -19,6 +20,20 @@ type AccountMessage struct {
 	// The state of accounts as of the provided slot.
 	// Nil values mean it is unknown.
 	State map[string]*Account
+
+	// The hash of the last block reflected in State, which is the value that
+	// was externalized for it. Since the whole message is signed, a node
+	// vouches for the state along with the block it comes from.
+	// Empty when unknown.
+	Hash consensus.SlotValue `json:",omitempty"`
+
+	// How active the accounts in State have been lately. Only nodes with a
+	// database fill this in.
+	Activity map[string]*AccountActivity `json:",omitempty"`
+
+	// The sequence numbers of each account's operations that are waiting in
+	// the node's queue, in order. Only filled in for the current state.
+	Pending map[string][]uint32 `json:",omitempty"`
 }
 
 func (m *AccountMessage) Slot() int {
@@ -34,6 +49,9 @@ func (m *AccountMessage) String() string {
 	if m.I != 0 {
 		parts = append(parts, fmt.Sprintf("i=%d", m.I))
 	}
+	if m.Hash != "" {
+		parts = append(parts, fmt.Sprintf("hash=%s", util.Shorten(string(m.Hash))))
+	}
 	for user, account := range m.State {
 		parts = append(parts, fmt.Sprintf("%s=%s",
 			util.Shorten(user), StringifyAccount(account)))
@@ -2,6 +2,8 @@ package currency
 
 import (
 	"testing"
+
+	"github.com/lacker/coinkit/util"
 )
 
 func TestTransactionProcessing(t *testing.T) {
@@ -31,3 +33,144 @@ func TestTransactionProcessing(t *testing.T) {
 		t.Fatalf("validation should reject replay attacks")
 	}
 }
+
+func TestDepositSend(t *testing.T) {
+	exchange := util.NewKeyPairFromSecretPhrase("exchange").PublicKey()
+	deposit := util.DepositAccount(exchange, 12)
+	m := NewAccountMap()
+	m.SetBalance("alice", 200)
+	op := &SendOperation{Signer: "alice", Sequence: 1, To: deposit, Amount: 100}
+	if !op.Verify() || op.Recipient() != exchange.String() {
+		t.Fatalf("bad deposit send: %s", op)
+	}
+	if !m.Process(op) {
+		t.Fatal("the deposit should work")
+	}
+	if m.Get(exchange.String()).Balance != 100 || m.Get(deposit) != nil {
+		t.Fatal("the deposit should credit the exchange's account")
+	}
+}
+
+func TestOverflowingSend(t *testing.T) {
+	m := NewAccountMap()
+	m.SetBalance("alice", 200)
+	m.SetBalance("bob", 10)
+
+	// Amount + Fee wraps around to 100
+	wrap := &SendOperation{
+		Sequence: 1,
+		Amount:   ^uint64(0),
+		Fee:      101,
+		Signer:   "alice",
+		To:       "carol",
+	}
+	if m.Validate(wrap) || m.Process(wrap) {
+		t.Fatal("a send whose cost overflows should be rejected")
+	}
+
+	// Bob's balance would wrap around
+	m.SetBalance("alice", ^uint64(0))
+	overflow := &SendOperation{
+		Sequence: 1,
+		Amount:   ^uint64(0),
+		Signer:   "alice",
+		To:       "bob",
+	}
+	if m.Validate(overflow) || m.Process(overflow) {
+		t.Fatal("a send that overflows the recipient should be rejected")
+	}
+	if m.Get("alice").Balance != ^uint64(0) || m.Get("bob").Balance != 10 {
+		t.Fatal("rejected sends should not change any balances")
+	}
+}
+
+func TestStateRoot(t *testing.T) {
+	m := NewAccountMap()
+	m.SetBalance("alice", 200)
+	copy := m.CowCopy()
+	copy.SetBalance("bob", 10)
+	snapshot := copy.Snapshot()
+	if len(snapshot) != 2 || snapshot["alice"].Balance != 200 {
+		t.Fatalf("bad snapshot: %+v", snapshot)
+	}
+
+	other := NewAccountMapFromState(snapshot)
+	if StateRoot(other.Snapshot()) != StateRoot(snapshot) {
+		t.Fatal("the same accounts should have the same root")
+	}
+	other.SetBalance("bob", 11)
+	if StateRoot(other.Snapshot()) == StateRoot(snapshot) {
+		t.Fatal("different accounts should have different roots")
+	}
+	if snapshot["bob"].Balance != 10 {
+		t.Fatal("a snapshot should not change when the map does")
+	}
+}
+
+func TestApplyChunk(t *testing.T) {
+	bob := util.NewKeyPairFromSecretPhrase("bob").PublicKey().String()
+	m := NewAccountMap()
+	m.SetBalance("alice", 100)
+	pay := func(seq uint32, amount uint64) *util.SignedOperation {
+		return &util.SignedOperation{Operation: &SendOperation{
+			Signer: "alice", Sequence: seq, To: bob, Amount: amount}}
+	}
+	chunk := &LedgerChunk{
+		Operations: []*util.SignedOperation{pay(1, 30), pay(2, 80)},
+		State: map[string]*Account{
+			"alice": &Account{Sequence: 2, Balance: 0},
+			bob:     &Account{Balance: 110},
+		},
+	}
+	if m.ApplyChunk(chunk) {
+		t.Fatal("alice can't afford the second payment")
+	}
+	if m.Get("alice").Balance != 100 || m.Get(bob) != nil {
+		t.Fatal("a chunk that fails should not change anything")
+	}
+	chunk.Operations[1] = pay(2, 70)
+	chunk.State[bob] = &Account{Balance: 100}
+	if !m.ApplyChunk(chunk) {
+		t.Fatal("both payments should work")
+	}
+	if m.Get("alice").Sequence != 2 || m.Get(bob).Balance != 100 {
+		t.Fatalf("bad accounts: %+v %+v", m.Get("alice"), m.Get(bob))
+	}
+}
+
+func TestReserve(t *testing.T) {
+	m := NewAccountMap()
+	m.SetReserve(100)
+	m.SetBalance("alice", 1000)
+	if m.Validate(&SendOperation{Signer: "alice", Sequence: 1, To: "bob", Amount: 99, Fee: 1}) {
+		t.Fatal("a new account should need the reserve")
+	}
+	if !m.Process(&SendOperation{Signer: "alice", Sequence: 1, To: "bob", Amount: 100, Fee: 1}) {
+		t.Fatal("a new account with the reserve should work")
+	}
+	if !m.Process(&SendOperation{Signer: "bob", Sequence: 1, To: "alice", Amount: 100}) {
+		t.Fatal("an account should be able to send everything it has")
+	}
+	if !m.Validate(&SendOperation{Signer: "alice", Sequence: 2, To: "bob", Amount: 100, Fee: 1}) {
+		t.Fatal("an emptied account should take the reserve again")
+	}
+
+	// alice has 999, so she can spend 899 of it
+	if m.Validate(&SendOperation{Signer: "alice", Sequence: 2, To: "bob", Amount: 800, Fee: 100}) {
+		t.Fatal("a send should not dip into the reserve")
+	}
+	if m.Validate(&MessageOperation{Signer: "alice", Sequence: 2, Fee: 900}) {
+		t.Fatal("a fee should not dip into the reserve")
+	}
+	if !m.Validate(&SendOperation{Signer: "alice", Sequence: 2, To: "alice", Amount: 50, Fee: 899}) {
+		t.Fatal("sending to yourself only has to keep the reserve after the fee")
+	}
+	if !m.Validate(&SendOperation{Signer: "alice", Sequence: 2, To: "bob", Amount: 998, Fee: 1}) {
+		t.Fatal("an account should be able to send everything it has")
+	}
+
+	if !m.CowCopy().Validate(&SendOperation{Signer: "alice", Sequence: 2, To: "carol", Amount: 100}) ||
+		m.CowCopy().Validate(&SendOperation{Signer: "alice", Sequence: 2, To: "carol", Amount: 99}) {
+		t.Fatal("copies should keep the reserve")
+	}
+}
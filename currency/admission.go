@@ -0,0 +1,182 @@
+package currency
+
+import (
+	"fmt"
+
+	"github.com/lacker/coinkit/util"
+)
+
+// An AdmissionCheck is the result of one of the checks an operation has to
+// pass to get into the queue.
+type AdmissionCheck struct {
+	// What is being checked, like "sequence"
+	Name string `json:"name"`
+
+	Passed bool `json:"passed"`
+
+	// Why the check failed, or what it checked when it passed
+	Reason string `json:"reason"`
+}
+
+func newCheck(name string, passed bool, format string, a ...interface{}) *AdmissionCheck {
+	return &AdmissionCheck{Name: name, Passed: passed, Reason: fmt.Sprintf(format, a...)}
+}
+
+// AllPassed returns whether every check passed.
+func AllPassed(checks []*AdmissionCheck) bool {
+	for _, check := range checks {
+		if !check.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckAdmission runs the checks that Add would make on an operation, without
+// needing it to be signed, and reports each of them. The signature check is
+// the only one that is skipped.
+// The operation isn't added to the queue.
+func (q *OperationQueue) CheckAdmission(op util.Operation) []*AdmissionCheck {
+	checks := []*AdmissionCheck{}
+	opType := op.OperationType()
+
+	priority := q.IsPriority(op.GetSigner())
+	if priority {
+		checks = append(checks, newCheck("type", true,
+			"this node admits every type of operation from the priority lane"))
+	} else if q.admits(op) {
+		checks = append(checks, newCheck("type", true, "this node admits %s operations", opType))
+	} else {
+		checks = append(checks, newCheck("type", false,
+			"this node does not admit %s operations", opType))
+	}
+
+	if q.Allowed(op.GetSigner()) {
+		checks = append(checks, newCheck("signer", true, "this node admits operations from the signer"))
+	} else {
+		checks = append(checks, newCheck("signer", false,
+			"this node only admits operations from allowed clients, and the signer is not one"))
+	}
+
+	if op.Verify() {
+		checks = append(checks, newCheck("format", true, "the operation is well formed"))
+	} else {
+		checks = append(checks, newCheck("format", false,
+			"the operation is malformed, like an invalid recipient or a field over its limit"))
+	}
+
+	account := q.accounts.Get(op.GetSigner())
+	current := uint32(0)
+	balance := uint64(0)
+	if account != nil {
+		current = account.Sequence
+		balance = account.Balance
+	}
+	if op.GetSequence() == current+1 {
+		checks = append(checks, newCheck("sequence", true, "the sequence is %d", current+1))
+	} else {
+		checks = append(checks, newCheck("sequence", false,
+			"the sequence should be %d but it is %d", current+1, op.GetSequence()))
+	}
+
+	cost, ok := op.GetFee(), true
+	if send, isSend := op.(*SendOperation); isSend {
+		cost, ok = AddAmounts(send.Amount, send.Fee)
+	}
+	if !ok {
+		checks = append(checks, newCheck("funds", false, "the amount plus the fee overflows"))
+	} else if cost <= balance {
+		checks = append(checks, newCheck("funds", true,
+			"the cost is %d and the balance is %d", cost, balance))
+	} else {
+		checks = append(checks, newCheck("funds", false,
+			"the cost is %d but the balance is only %d", cost, balance))
+	}
+
+	if reserve := q.accounts.Reserve(); reserve > 0 {
+		checks = append(checks, q.checkReserve(op, balance, reserve))
+	}
+
+	if q.accounts.Validate(op) {
+		checks = append(checks, newCheck("state", true, "the operation applies to the current accounts"))
+	} else {
+		checks = append(checks, newCheck("state", false,
+			"the operation does not apply to the current accounts"))
+	}
+
+	if v, ok := op.(*ValidatorOperation); ok {
+		if q.validators.IsValidator(v.Signer) {
+			checks = append(checks, newCheck("validator", true, "the signer is a validator"))
+		} else {
+			checks = append(checks, newCheck("validator", false,
+				"only validators can vote on the validator set"))
+		}
+	}
+
+	// When the queue is full, an operation has to beat the lowest fee to get
+	// in, unless it is in the priority lane
+	if priority {
+		checks = append(checks, newCheck("fee", true,
+			"the signer is in the priority lane, so any fee gets in"))
+	} else if q.set.Size() >= QueueLimit {
+		it := q.set.Iterator()
+		it.Last()
+		floor := it.Value().(*util.SignedOperation).GetFee()
+		if op.GetFee() > floor {
+			checks = append(checks, newCheck("fee", true,
+				"the queue is full, and the fee beats the lowest pending fee of %d", floor))
+		} else {
+			checks = append(checks, newCheck("fee", false,
+				"the queue is full, so the fee has to be more than %d", floor))
+		}
+	} else {
+		checks = append(checks, newCheck("fee", true, "the queue has room for any fee"))
+	}
+
+	// A pending operation with the same sequence number can only be replaced
+	// by a higher fee
+	if old := q.Conflicting(op); old != nil {
+		if op.GetFee() > old.GetFee() {
+			checks = append(checks, newCheck("replacement", true,
+				"the fee beats the pending operation it replaces, with fee %d", old.GetFee()))
+		} else {
+			checks = append(checks, newCheck("replacement", false,
+				"an operation with this sequence is already pending, so the fee has to be more than %d",
+				old.GetFee()))
+		}
+	}
+	return checks
+}
+
+// checkReserve checks that an operation leaves the accounts it touches with
+// either nothing or at least the reserve.
+func (q *OperationQueue) checkReserve(op util.Operation, balance uint64, reserve uint64) *AdmissionCheck {
+	send, isSend := op.(*SendOperation)
+	if !isSend || send.Recipient() == send.Signer {
+		after, _ := SubtractAmounts(balance, op.GetFee())
+		if !q.accounts.keepsReserve(after) {
+			return newCheck("reserve", false,
+				"the fee would leave %d, but the signer has to keep a reserve of %d", after, reserve)
+		}
+		return newCheck("reserve", true, "the signer keeps a reserve of %d", reserve)
+	}
+
+	cost, _ := AddAmounts(send.Amount, send.Fee)
+	after, _ := SubtractAmounts(balance, cost)
+	if !q.accounts.keepsReserve(after) {
+		return newCheck("reserve", false,
+			"the send would leave the signer with %d, less than the reserve of %d. "+
+				"send it all, or keep the reserve", after, reserve)
+	}
+	received := uint64(0)
+	if target := q.accounts.Get(send.Recipient()); target != nil {
+		received = target.Balance
+	}
+	total, _ := AddAmounts(received, send.Amount)
+	if !q.accounts.keepsReserve(total) {
+		return newCheck("reserve", false,
+			"the recipient would have %d, but an account below the reserve of %d has to be sent enough to reach it",
+			total, reserve)
+	}
+	return newCheck("reserve", true, "both accounts keep the reserve of %d", reserve)
+}
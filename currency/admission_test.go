@@ -0,0 +1,103 @@
+package currency
+
+import (
+	"testing"
+
+	"github.com/lacker/coinkit/util"
+)
+
+func failedChecks(checks []*AdmissionCheck) []string {
+	answer := []string{}
+	for _, check := range checks {
+		if !check.Passed {
+			answer = append(answer, check.Name)
+		}
+	}
+	return answer
+}
+
+func TestCheckAdmission(t *testing.T) {
+	kp := util.NewKeyPair()
+	q := NewOperationQueue(kp.PublicKey())
+	tr := makeTestSendOperation(1).Operation.(*SendOperation)
+	if failed := failedChecks(q.CheckAdmission(tr)); len(failed) != 2 ||
+		failed[0] != "funds" || failed[1] != "state" {
+		t.Fatalf("a send without money should fail funds and state: %v", failed)
+	}
+
+	q.accounts.SetBalance(tr.Signer, 10)
+	checks := q.CheckAdmission(tr)
+	if !AllPassed(checks) {
+		t.Fatalf("the send should pass: %v", failedChecks(checks))
+	}
+	if q.Size() != 0 {
+		t.Fatal("checking should not add to the queue")
+	}
+
+	q.Admit = map[string]bool{"Validator": true}
+	if failed := failedChecks(q.CheckAdmission(tr)); len(failed) != 1 || failed[0] != "type" {
+		t.Fatalf("only the type check should fail: %v", failed)
+	}
+	q.Admit = nil
+
+	q.Allow = map[string]bool{kp.PublicKey().String(): true}
+	if failed := failedChecks(q.CheckAdmission(tr)); len(failed) != 1 || failed[0] != "signer" {
+		t.Fatalf("only the signer check should fail: %v", failed)
+	}
+	q.Allow[tr.Signer] = true
+	if !AllPassed(q.CheckAdmission(tr)) {
+		t.Fatalf("an allowed signer should pass")
+	}
+	q.Allow = nil
+
+	v := &ValidatorOperation{
+		Signer:     tr.Signer,
+		Sequence:   1,
+		Action:     ValidatorAdd,
+		Validator:  kp.PublicKey().String(),
+		Threshold:  1,
+		Activation: 1,
+	}
+	if failed := failedChecks(q.CheckAdmission(v)); len(failed) != 1 || failed[0] != "validator" {
+		t.Fatalf("only the validator check should fail: %v", failed)
+	}
+}
+
+func TestCheckAdmissionFeeFloor(t *testing.T) {
+	kp := util.NewKeyPair()
+	q := NewOperationQueue(kp.PublicKey())
+	for i := 1; i <= QueueLimit; i++ {
+		op := makeTestSendOperation(i + 1)
+		q.accounts.SetBalance(op.GetSigner(), 10*uint64(i+1))
+		q.Add(op)
+	}
+	if q.Size() != QueueLimit {
+		t.Fatalf("expected a full queue but got %d", q.Size())
+	}
+	tr := makeTestSendOperation(1).Operation.(*SendOperation)
+	q.accounts.SetBalance(tr.Signer, 10)
+	if failed := failedChecks(q.CheckAdmission(tr)); len(failed) != 1 || failed[0] != "fee" {
+		t.Fatalf("only the fee check should fail: %v", failed)
+	}
+}
+
+func TestCheckAdmissionReserve(t *testing.T) {
+	q := NewOperationQueue(util.NewKeyPair().PublicKey())
+	q.SetReserve(100)
+	tr := makeTestSendOperation(1).Operation.(*SendOperation)
+	q.accounts.SetBalance(tr.Signer, 1000)
+	tr.Amount = 10
+	if failed := failedChecks(q.CheckAdmission(tr)); len(failed) != 2 ||
+		failed[0] != "reserve" || failed[1] != "state" {
+		t.Fatalf("a new account without the reserve should fail: %v", failed)
+	}
+	tr.Amount = 950
+	if failed := failedChecks(q.CheckAdmission(tr)); len(failed) != 2 ||
+		failed[0] != "reserve" || failed[1] != "state" {
+		t.Fatalf("the signer dipping into the reserve should fail: %v", failed)
+	}
+	tr.Amount = 500
+	if !AllPassed(q.CheckAdmission(tr)) {
+		t.Fatalf("the send should pass: %v", failedChecks(q.CheckAdmission(tr)))
+	}
+}
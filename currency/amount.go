@@ -0,0 +1,81 @@
+package currency
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Decimals is how many decimal places a coin has. Accounts hold nanocoins.
+const Decimals = 9
+
+// MaxDecimals is the most decimal places an amount can be written with,
+// since 10^19 is the largest power of ten that fits in a uint64.
+const MaxDecimals = 19
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseAmount converts a decimal string like "1.25" to a number of units,
+// where a unit is 10^-decimals. It's an error for the string to be more
+// precise than a unit, or for the amount not to fit in a uint64.
+func ParseAmount(s string, decimals int) (uint64, error) {
+	if decimals < 0 || decimals > MaxDecimals {
+		return 0, fmt.Errorf("invalid number of decimals: %d", decimals)
+	}
+	whole, frac := s, ""
+	dot := strings.IndexByte(s, '.')
+	if dot >= 0 {
+		whole, frac = s[:dot], s[dot+1:]
+		if frac == "" {
+			return 0, fmt.Errorf("invalid amount: %q", s)
+		}
+	}
+	if whole == "" || !isDigits(whole) || !isDigits(frac) {
+		return 0, fmt.Errorf("invalid amount: %q", s)
+	}
+	if len(frac) > decimals {
+		return 0, fmt.Errorf("%q has more than %d decimal places", s, decimals)
+	}
+	frac += strings.Repeat("0", decimals-len(frac))
+	units, err := strconv.ParseUint(whole+frac, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is too large to be an amount", s)
+	}
+	return units, nil
+}
+
+// FormatAmount writes a number of units as a decimal string, without
+// trailing zeros after the decimal point.
+func FormatAmount(units uint64, decimals int) string {
+	s := strconv.FormatUint(units, 10)
+	if decimals <= 0 {
+		return s
+	}
+	if len(s) <= decimals {
+		s = strings.Repeat("0", decimals-len(s)+1) + s
+	}
+	point := len(s) - decimals
+	frac := strings.TrimRight(s[point:], "0")
+	if frac == "" {
+		return s[:point]
+	}
+	return s[:point] + "." + frac
+}
+
+// AddAmounts returns a + b, and whether that fits in a uint64.
+func AddAmounts(a uint64, b uint64) (uint64, bool) {
+	sum := a + b
+	return sum, sum >= a
+}
+
+// SubtractAmounts returns a - b, and whether that is nonnegative.
+func SubtractAmounts(a uint64, b uint64) (uint64, bool) {
+	return a - b, b <= a
+}
@@ -0,0 +1,92 @@
+package currency
+
+import (
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	valid := []struct {
+		s        string
+		decimals int
+		units    uint64
+	}{
+		{"1.25", 2, 125},
+		{"1.25", 9, 1250000000},
+		{"0.000000001", 9, 1},
+		{"7", 0, 7},
+		{"007.50", 3, 7500},
+		{"18446744073709551615", 0, 18446744073709551615},
+		{"18446744073.709551615", 9, 18446744073709551615},
+	}
+	for _, v := range valid {
+		units, err := ParseAmount(v.s, v.decimals)
+		if err != nil || units != v.units {
+			t.Fatalf("ParseAmount(%q, %d) = %d, %v", v.s, v.decimals, units, err)
+		}
+	}
+
+	invalid := []struct {
+		s        string
+		decimals int
+	}{
+		{"", 9},
+		{".5", 9},
+		{"1.", 9},
+		{"-1", 9},
+		{"1e9", 9},
+		{"1.2.3", 9},
+		{" 1", 9},
+		{"1.5", 0},
+		{"0.0000000001", 9},
+		{"18446744073709551616", 0},
+		{"18446744074", 9},
+		{"1", 20},
+	}
+	for _, v := range invalid {
+		if units, err := ParseAmount(v.s, v.decimals); err == nil {
+			t.Fatalf("ParseAmount(%q, %d) should fail but got %d", v.s, v.decimals, units)
+		}
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	cases := []struct {
+		units    uint64
+		decimals int
+		s        string
+	}{
+		{125, 2, "1.25"},
+		{1250000000, 9, "1.25"},
+		{1, 9, "0.000000001"},
+		{0, 9, "0"},
+		{3000, 3, "3"},
+		{42, 0, "42"},
+		{TotalMoney, Decimals, "21000000"},
+	}
+	for _, c := range cases {
+		s := FormatAmount(c.units, c.decimals)
+		if s != c.s {
+			t.Fatalf("FormatAmount(%d, %d) = %q, expected %q", c.units, c.decimals, s, c.s)
+		}
+		units, err := ParseAmount(s, c.decimals)
+		if err != nil || units != c.units {
+			t.Fatalf("%q did not parse back to %d", s, c.units)
+		}
+	}
+}
+
+func TestCheckedArithmetic(t *testing.T) {
+	const max = ^uint64(0)
+	if sum, ok := AddAmounts(max-1, 1); !ok || sum != max {
+		t.Fatalf("max-1 + 1 should be max but got %d %t", sum, ok)
+	}
+	if _, ok := AddAmounts(max, 1); ok {
+		t.Fatal("max + 1 should overflow")
+	}
+	if diff, ok := SubtractAmounts(5, 5); !ok || diff != 0 {
+		t.Fatalf("5 - 5 should be 0 but got %d %t", diff, ok)
+	}
+	if _, ok := SubtractAmounts(5, 6); ok {
+		t.Fatal("5 - 6 should underflow")
+	}
+}
@@ -0,0 +1,56 @@
+package currency
+
+import (
+	"github.com/lacker/coinkit/util"
+)
+
+// The cost of an operation is roughly how much work it makes for every node:
+// its encoded size in bytes, plus a weight for checking and applying it.
+// MaxChunkSize limits how many operations a block can have, and MaxChunkCost
+// limits how much work they can add up to, so a block full of heavy
+// operations can't slow down a slot.
+
+// MaxChunkCost is the most that the operations in a proposed chunk can cost.
+// A chunk of MaxChunkSize sends fits with plenty of room.
+const MaxChunkCost = 200000
+
+// baseOperationWeight is the weight of an operation type without its own
+// entry in operationWeights. It is mostly checking the signature.
+const baseOperationWeight = 1000
+
+// operationWeights are the weights of the operation types that do more work
+// than checking a signature and updating a balance. Setting account data,
+// delivering messages, updating documents, and pinning blobs all write to the
+// database on nodes that have one, and a validator vote can change the
+// validator set.
+var operationWeights = map[string]int{
+	"Message":        2000,
+	"PinBlob":        2000,
+	"SetAccountData": 2000,
+	"UpdateDocument": 2000,
+	"Validator":      2000,
+}
+
+// OperationCost is the cost of a signed operation.
+func OperationCost(op *util.SignedOperation) int {
+	weight, ok := operationWeights[op.Operation.OperationType()]
+	if !ok {
+		weight = baseOperationWeight
+	}
+	return weight + encodedSize(op)
+}
+
+// Cost is the total cost of the chunk's operations.
+func (c *LedgerChunk) Cost() int {
+	answer := 0
+	for _, op := range c.Operations {
+		answer += OperationCost(op)
+	}
+	return answer
+}
+
+// Full returns whether the chunk has as many operations as a chunk can have,
+// or close to as much cost, so that a block with it had no room to spare.
+func (c *LedgerChunk) Full() bool {
+	return len(c.Operations) >= MaxChunkSize || c.Cost() > MaxChunkCost*9/10
+}
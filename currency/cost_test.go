@@ -0,0 +1,62 @@
+package currency
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/lacker/coinkit/util"
+)
+
+// makeHeavyOperation makes an operation that sets close to the most account
+// data an account can have.
+func makeHeavyOperation(n int) *util.SignedOperation {
+	kp := util.NewKeyPairFromSecretPhrase(fmt.Sprintf("heavy %d", n))
+	data := make(map[string]string)
+	for i := 0; i < 10; i++ {
+		data[fmt.Sprintf("key%d", i)] = strings.Repeat("x", 95)
+	}
+	op := &SetAccountDataOperation{
+		Signer:   kp.PublicKey().String(),
+		Sequence: 1,
+		Fee:      uint64(1000 + n),
+		Data:     data,
+	}
+	return util.NewSignedOperation(op, kp)
+}
+
+func TestChunkCost(t *testing.T) {
+	if cost := makeFullChunk().Cost(); cost > MaxChunkCost {
+		t.Fatalf("a chunk full of sends costs %d, which is over the limit", cost)
+	}
+
+	heavy := NewEmptyChunk()
+	for i := 1; i <= MaxChunkSize; i++ {
+		heavy.Operations = append(heavy.Operations, makeHeavyOperation(i))
+	}
+	if err := heavy.CheckProposal(); err == nil || !strings.Contains(err.Error(), "costs") {
+		t.Fatalf("a chunk full of heavy operations should cost too much but got: %v", err)
+	}
+
+	// A cheap send can still go in after the heavy operations stop fitting
+	q := NewOperationQueue(util.NewKeyPair().PublicKey())
+	ops := append([]*util.SignedOperation{}, heavy.Operations...)
+	send := makeTestSendOperation(1)
+	ops = append(ops, send)
+	for _, op := range ops {
+		q.SetBalance(op.GetSigner(), 10000)
+	}
+	sort.Slice(ops, func(i, j int) bool { return util.HighestFeeFirst(ops[i], ops[j]) < 0 })
+	_, chunk := q.NewChunk(ops)
+	if len(chunk.Operations) >= MaxChunkSize || chunk.Operations[len(chunk.Operations)-1] != send {
+		t.Fatalf("expected some heavy operations and the send but got %d operations",
+			len(chunk.Operations))
+	}
+	if err := chunk.CheckProposal(); err != nil {
+		t.Fatal(err)
+	}
+	if !chunk.Full() {
+		t.Fatalf("a chunk that used up its budget should be full")
+	}
+}
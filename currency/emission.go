@@ -0,0 +1,93 @@
+package currency
+
+import (
+	"fmt"
+)
+
+// An Emission mints new money on a schedule, so that test economies can
+// simulate ongoing issuance. It is part of a network's genesis, so every node
+// has to use the same one.
+// Minting happens in every slot that is a multiple of Interval, after the
+// block's operations are processed, the same way fees are distributed. See
+// fee_distribution.go.
+type Emission struct {
+	// How much is minted each time, in raw units
+	Amount uint64
+
+	// How many slots apart minting happens
+	Interval int
+
+	// The account minted money goes to. Empty means it is split evenly
+	// among the validators for the slot, and whatever doesn't divide evenly
+	// isn't minted.
+	Pool string `json:",omitempty"`
+
+	// The last slot that can mint. Zero means minting never stops.
+	Until int `json:",omitempty"`
+}
+
+func (e *Emission) String() string {
+	to := e.Pool
+	if to == "" {
+		to = "validators"
+	}
+	answer := fmt.Sprintf("%d/%d:%s", e.Amount, e.Interval, to)
+	if e.Until > 0 {
+		answer += fmt.Sprintf(":%d", e.Until)
+	}
+	return answer
+}
+
+// Check returns an error if the schedule can't work.
+func (e *Emission) Check() error {
+	if e.Amount == 0 {
+		return fmt.Errorf("an emission has to mint something")
+	}
+	if e.Interval < 1 {
+		return fmt.Errorf("the emission interval must be at least one slot")
+	}
+	if e.Until < 0 {
+		return fmt.Errorf("the emission can't end before the first slot")
+	}
+	return nil
+}
+
+// Mints returns whether money is minted in a slot.
+func (e *Emission) Mints(slot int) bool {
+	if e == nil || slot < 1 || slot%e.Interval != 0 {
+		return false
+	}
+	return e.Until == 0 || slot <= e.Until
+}
+
+// Mintings returns how many times money has been minted, through a slot.
+func (e *Emission) Mintings(slot int) int {
+	if e == nil || slot < 1 {
+		return 0
+	}
+	if e.Until > 0 && slot > e.Until {
+		slot = e.Until
+	}
+	return slot / e.Interval
+}
+
+// SetEmission sets the schedule for minting money. Nil means no money is
+// ever minted.
+func (m *AccountMap) SetEmission(e *Emission) {
+	m.emission = e
+}
+
+// SetSlot sets the slot the next chunk processed is for. Accounts record it
+// as when they were last active, and it decides when an emission mints.
+func (m *AccountMap) SetSlot(slot int) {
+	m.slot = slot
+}
+
+// emissionShares returns how the money minted for the current slot is
+// credited.
+func (m *AccountMap) emissionShares() map[string]uint64 {
+	if !m.emission.Mints(m.slot) {
+		return map[string]uint64{}
+	}
+	return Shares(m.emission.Amount, m.emission.Pool, m.validators)
+}
@@ -0,0 +1,120 @@
+package currency
+
+import (
+	"math"
+	"testing"
+
+	"github.com/lacker/coinkit/consensus"
+	"github.com/lacker/coinkit/util"
+)
+
+func TestEmissionSchedule(t *testing.T) {
+	e := &Emission{Amount: 10, Interval: 3, Until: 7}
+	if err := e.Check(); err != nil {
+		t.Fatal(err)
+	}
+	for slot, mints := range map[int]bool{1: false, 3: true, 4: false, 6: true, 9: false} {
+		if e.Mints(slot) != mints {
+			t.Fatalf("slot %d should mint: %v", slot, mints)
+		}
+	}
+	if e.Mintings(5) != 1 || e.Mintings(100) != 2 {
+		t.Fatalf("bad mintings: %d %d", e.Mintings(5), e.Mintings(100))
+	}
+	var none *Emission
+	if none.Mints(3) || none.Mintings(3) != 0 {
+		t.Fatal("no emission should never mint")
+	}
+	if (&Emission{Amount: 10}).Check() == nil {
+		t.Fatal("an emission needs an interval")
+	}
+}
+
+func TestEmission(t *testing.T) {
+	q := NewOperationQueue(util.NewKeyPair().PublicKey())
+	q.SetQuorumSlice(consensus.MakeQuorumSlice([]string{"v1", "v2"}, 2))
+	q.SetEmission(&Emission{Amount: 101, Interval: 2})
+	for i := 1; i <= 4; i++ {
+		op := makeTestSendOperation(i)
+		q.SetBalance(op.GetSigner(), 100)
+		q.Add(op)
+		v, ok := q.SuggestValue()
+		if !ok {
+			t.Fatal("there should be a suggestion")
+		}
+		q.Finalize(v)
+		expected := uint64(50 * (i / 2))
+		if q.Account("v1") == nil && expected == 0 {
+			continue
+		}
+		if q.Account("v1").Balance != expected || q.Account("v2").Balance != expected {
+			t.Fatalf("after slot %d each validator should have %d: %+v %+v",
+				i, expected, q.Account("v1"), q.Account("v2"))
+		}
+	}
+
+	// A node that doesn't mint should reject a minting chunk
+	other := NewAccountMap()
+	other.SetSlot(6)
+	op := makeTestSendOperation(6)
+	other.SetBalance(op.GetSigner(), 100)
+	q.SetBalance(op.GetSigner(), 100)
+	q.Add(op)
+	v, _ := q.SuggestValue()
+	if other.ValidateChunk(q.chunks[v]) {
+		t.Fatal("nodes with different emissions should not agree on a chunk")
+	}
+}
+
+func TestSupply(t *testing.T) {
+	q := NewOperationQueue(util.NewKeyPair().PublicKey())
+	q.SetQuorumSlice(consensus.MakeQuorumSlice([]string{"v1", "v2", "v3"}, 2))
+	q.SetFeesToValidators(true)
+	q.SetEmission(&Emission{Amount: 10, Interval: 1})
+	op := makeTestSendOperation(8)
+	q.SetBalance(op.GetSigner(), 100)
+	q.SetBalance("other", 50)
+	if q.Supply() != 150 || q.LastIssuance() != nil {
+		t.Fatalf("the supply should start at 150, not %d", q.Supply())
+	}
+	q.Add(op)
+	v, _ := q.SuggestValue()
+	q.Finalize(v)
+
+	// The fee of 8 splits into 6 distributed and 2 burned, and the emission
+	// of 10 splits into 9 minted
+	i := q.LastIssuance()
+	if i.Slot != 1 || i.Fees != 8 || i.FeesDistributed != 6 || i.Minted != 9 ||
+		i.Supply != 157 || q.Supply() != 157 {
+		t.Fatalf("bad issuance: %+v", i)
+	}
+	total := uint64(0)
+	for _, account := range q.Snapshot() {
+		total += account.Balance
+	}
+	if total != q.Supply() {
+		t.Fatalf("the accounts hold %d but the supply is %d", total, q.Supply())
+	}
+}
+
+func TestSupplyOutOfRange(t *testing.T) {
+	q := NewOperationQueue(util.NewKeyPair().PublicKey())
+	q.SetQuorumSlice(consensus.MakeQuorumSlice([]string{"v1", "v2", "v3"}, 2))
+	q.SetEmission(&Emission{Amount: 10, Interval: 1})
+	op := makeTestSendOperation(8)
+	q.SetBalance(op.GetSigner(), 100)
+
+	// Minting more than fits keeps the old supply instead of wrapping around
+	q.supply = math.MaxUint64 - 5
+	q.Add(op)
+	v, _ := q.SuggestValue()
+	q.Finalize(v)
+	if q.Supply() != math.MaxUint64-5 || q.LastIssuance().Supply != q.Supply() {
+		t.Fatalf("the supply should not have changed but it is %d", q.Supply())
+	}
+
+	// So does burning more than there is
+	if supply, ok := (&Issuance{Fees: 8}).apply(1); ok || supply != 1 {
+		t.Fatalf("burning 8 out of 1 should fail but got %d", supply)
+	}
+}
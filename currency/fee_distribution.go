@@ -0,0 +1,123 @@
+package currency
+
+import (
+	"github.com/lacker/coinkit/util"
+)
+
+// The fees paid in a block are burned, unless the network distributes them.
+// A network can have every fee credited to a fee pool account, or split
+// evenly among the validators for the block's slot, in which case whatever
+// doesn't divide evenly is burned. The fees are credited after every
+// operation in the block has been processed, along with any newly minted
+// money, so nothing in a block can spend what it pays or mints. The credits
+// are part of the block's state, so they show up in account history like any
+// other change.
+// Every node on a network has to distribute fees the same way.
+
+// SetFeePool makes every fee get credited to the pool account. Empty means
+// there is no pool.
+func (m *AccountMap) SetFeePool(pool string) {
+	m.feePool = pool
+}
+
+// SetFeesToValidators makes fees get split among the validators, when there
+// is no fee pool.
+func (m *AccountMap) SetFeesToValidators(on bool) {
+	m.feesToValidators = on
+}
+
+// SetValidators sets the validators for the slot the next chunk is for, which
+// fees and minted money can be split among. It has to be updated whenever
+// they change.
+func (m *AccountMap) SetValidators(validators []string) {
+	m.validators = validators
+}
+
+// Shares returns how much each account is credited when an amount goes to a
+// pool, or is split among the validators for a block's slot if the pool is
+// empty. Whatever doesn't divide evenly isn't credited to anyone.
+func Shares(total uint64, pool string, validators []string) map[string]uint64 {
+	answer := make(map[string]uint64)
+	if total == 0 {
+		return answer
+	}
+	if pool != "" {
+		answer[pool] = total
+		return answer
+	}
+	if len(validators) == 0 {
+		return answer
+	}
+	share := total / uint64(len(validators))
+	if share == 0 {
+		return answer
+	}
+	for _, validator := range validators {
+		answer[validator] = share
+	}
+	return answer
+}
+
+// chunkFees returns the total fees paid by some operations, and whether that
+// fits in a uint64.
+func chunkFees(ops []*util.SignedOperation) (uint64, bool) {
+	total := uint64(0)
+	for _, op := range ops {
+		var ok bool
+		total, ok = AddAmounts(total, op.GetFee())
+		if !ok {
+			return 0, false
+		}
+	}
+	return total, true
+}
+
+// feeShares returns how the fees paid by some operations are credited.
+func (m *AccountMap) feeShares(ops []*util.SignedOperation) (map[string]uint64, bool) {
+	total, ok := chunkFees(ops)
+	if !ok {
+		return nil, false
+	}
+	if m.feePool == "" && !m.feesToValidators {
+		return map[string]uint64{}, true
+	}
+	return Shares(total, m.feePool, m.validators), true
+}
+
+// creditBlock credits the fees paid by the operations of a chunk that were
+// just processed, and the money minted for its slot. It returns the accounts
+// it credited, or false if a balance would overflow. Credits don't have to
+// meet the reserve.
+func (m *AccountMap) creditBlock(ops []*util.SignedOperation) ([]string, bool) {
+	fees, ok := m.feeShares(ops)
+	if !ok {
+		return nil, false
+	}
+	credits := []map[string]uint64{fees, m.emissionShares()}
+	credited := []string{}
+	seen := make(map[string]bool)
+	for _, shares := range credits {
+		for owner, amount := range shares {
+			account := m.Get(owner)
+			if account == nil {
+				account = &Account{}
+			}
+			balance, ok := AddAmounts(account.Balance, amount)
+			if !ok {
+				return nil, false
+			}
+			m.setActive(owner, &Account{
+				Sequence: account.Sequence,
+				Balance:  balance,
+				Data:     account.Data,
+				Storage:  account.Storage,
+				Created:  account.Created,
+			})
+			if !seen[owner] {
+				seen[owner] = true
+				credited = append(credited, owner)
+			}
+		}
+	}
+	return credited, true
+}
@@ -0,0 +1,73 @@
+package currency
+
+import (
+	"testing"
+
+	"github.com/lacker/coinkit/consensus"
+	"github.com/lacker/coinkit/util"
+)
+
+func TestShares(t *testing.T) {
+	shares := Shares(10, "pool", []string{"a", "b"})
+	if len(shares) != 1 || shares["pool"] != 10 {
+		t.Fatalf("the pool should get everything: %v", shares)
+	}
+	shares = Shares(10, "", []string{"a", "b", "c"})
+	if len(shares) != 3 || shares["a"] != 3 || shares["b"] != 3 || shares["c"] != 3 {
+		t.Fatalf("the validators should split the fees: %v", shares)
+	}
+	if len(Shares(2, "", []string{"a", "b", "c"})) != 0 {
+		t.Fatal("fees too small to split should be burned")
+	}
+	if len(Shares(10, "", nil)) != 0 {
+		t.Fatal("with nowhere to go, fees should be burned")
+	}
+}
+
+func TestFeePool(t *testing.T) {
+	q := NewOperationQueue(util.NewKeyPair().PublicKey())
+	q.SetFeePool("pool")
+	ops := []*util.SignedOperation{makeTestSendOperation(3), makeTestSendOperation(4)}
+	for _, op := range ops {
+		q.SetBalance(op.GetSigner(), 100)
+		q.Add(op)
+	}
+	v, ok := q.SuggestValue()
+	if !ok {
+		t.Fatal("there should be a suggestion")
+	}
+	chunk := q.chunks[v]
+	if chunk.State["pool"] == nil || chunk.State["pool"].Balance != 7 {
+		t.Fatalf("the chunk state should credit the pool: %+v", chunk.State["pool"])
+	}
+	q.Finalize(v)
+	if q.Account("pool").Balance != 7 {
+		t.Fatalf("the pool should have the fees, not %d", q.Account("pool").Balance)
+	}
+
+	// A node that burns fees should reject the chunk
+	other := NewAccountMap()
+	for _, op := range ops {
+		other.SetBalance(op.GetSigner(), 100)
+	}
+	if other.ValidateChunk(chunk) {
+		t.Fatal("nodes that distribute fees differently should not agree on a chunk")
+	}
+}
+
+func TestFeesToValidators(t *testing.T) {
+	q := NewOperationQueue(util.NewKeyPair().PublicKey())
+	q.SetQuorumSlice(consensus.MakeQuorumSlice([]string{"v1", "v2"}, 2))
+	q.SetFeesToValidators(true)
+	op := makeTestSendOperation(5)
+	q.SetBalance(op.GetSigner(), 100)
+	q.Add(op)
+	v, ok := q.SuggestValue()
+	if !ok {
+		t.Fatal("there should be a suggestion")
+	}
+	q.Finalize(v)
+	if q.Account("v1").Balance != 2 || q.Account("v2").Balance != 2 {
+		t.Fatalf("each validator should get 2: %+v %+v", q.Account("v1"), q.Account("v2"))
+	}
+}
@@ -0,0 +1,77 @@
+package currency
+
+import (
+	"sort"
+)
+
+// FeeHistory is how many recent blocks the queue's fee estimator looks at
+const FeeHistory = 20
+
+// A FeeEstimator estimates the fee an operation needs to get into a block
+// within some number of slots. It looks at two things: how deep the queue is,
+// since blocks take the highest fees first, and the lowest fee that got into
+// each recent block that was full.
+// FeeEstimator is not threadsafe.
+type FeeEstimator struct {
+	// The most blocks to remember
+	limit int
+
+	// For each recent block, oldest first, the lowest fee it included if it
+	// was full, or 0 if it had room to spare.
+	clearing []uint64
+}
+
+func NewFeeEstimator(limit int) *FeeEstimator {
+	return &FeeEstimator{
+		limit:    limit,
+		clearing: []uint64{},
+	}
+}
+
+// AddChunk records the fees in a block that just got finalized.
+func (e *FeeEstimator) AddChunk(chunk *LedgerChunk) {
+	clearing := uint64(0)
+	if chunk.Full() {
+		for i, op := range chunk.Operations {
+			if i == 0 || op.GetFee() < clearing {
+				clearing = op.GetFee()
+			}
+		}
+	}
+	e.clearing = append(e.clearing, clearing)
+	if len(e.clearing) > e.limit {
+		e.clearing = e.clearing[len(e.clearing)-e.limit:]
+	}
+}
+
+// Estimate returns the fee an operation needs to get into a block within the
+// given number of slots, given the fees of the pending operations.
+//
+// From the queue, an operation has to beat enough pending fees that it would
+// make it into one of the next slots blocks if no more operations arrived.
+// From history, it has to match the lowest fee that got into all but
+// slots - 1 of the recent blocks, so asking for a block sooner costs more when
+// blocks have been full. The estimate is the higher of the two.
+func (e *FeeEstimator) Estimate(pending []uint64, slots int) uint64 {
+	if slots < 1 {
+		slots = 1
+	}
+	fees := append([]uint64{}, pending...)
+	sort.Slice(fees, func(i, j int) bool { return fees[i] > fees[j] })
+
+	answer := uint64(0)
+	if room := slots * MaxChunkSize; len(fees) >= room {
+		answer = fees[room-1] + 1
+	}
+	if len(fees) >= QueueLimit && fees[len(fees)-1]+1 > answer {
+		// A full queue only takes operations that beat its lowest fee
+		answer = fees[len(fees)-1] + 1
+	}
+
+	clearing := append([]uint64{}, e.clearing...)
+	sort.Slice(clearing, func(i, j int) bool { return clearing[i] > clearing[j] })
+	if slots <= len(clearing) && clearing[slots-1] > answer {
+		answer = clearing[slots-1]
+	}
+	return answer
+}
@@ -0,0 +1,66 @@
+package currency
+
+import (
+	"testing"
+
+	"github.com/lacker/coinkit/util"
+)
+
+// chunkWithFees makes a chunk with one operation for each fee.
+func chunkWithFees(fees ...uint64) *LedgerChunk {
+	chunk := NewEmptyChunk()
+	for _, fee := range fees {
+		chunk.Operations = append(chunk.Operations,
+			&util.SignedOperation{Operation: &SendOperation{Fee: fee}})
+	}
+	return chunk
+}
+
+func TestFeeEstimateFromQueue(t *testing.T) {
+	e := NewFeeEstimator(FeeHistory)
+	if fee := e.Estimate([]uint64{5, 3}, 1); fee != 0 {
+		t.Fatalf("a short queue should need no fee but got %d", fee)
+	}
+
+	// Two blocks' worth of operations, the first with fee 10, the second with fee 2
+	pending := []uint64{}
+	for i := 0; i < MaxChunkSize; i++ {
+		pending = append(pending, 2, 10)
+	}
+	if fee := e.Estimate(pending, 1); fee != 11 {
+		t.Fatalf("getting into the next block should take 11 but got %d", fee)
+	}
+	if fee := e.Estimate(pending, 2); fee != 3 {
+		t.Fatalf("getting into the second block should take 3 but got %d", fee)
+	}
+	if fee := e.Estimate(pending, 3); fee != 0 {
+		t.Fatalf("waiting three blocks should be free but got %d", fee)
+	}
+}
+
+func TestFeeEstimateFromHistory(t *testing.T) {
+	e := NewFeeEstimator(3)
+	full := func(lowest uint64) *LedgerChunk {
+		fees := []uint64{lowest}
+		for len(fees) < MaxChunkSize {
+			fees = append(fees, lowest+5)
+		}
+		return chunkWithFees(fees...)
+	}
+	e.AddChunk(full(100))
+	e.AddChunk(chunkWithFees(1, 2, 3))
+	e.AddChunk(full(7))
+	e.AddChunk(full(4))
+	if fee := e.Estimate(nil, 1); fee != 7 {
+		t.Fatalf("the oldest block should be forgotten, expected 7 but got %d", fee)
+	}
+	if fee := e.Estimate(nil, 2); fee != 4 {
+		t.Fatalf("expected 4 but got %d", fee)
+	}
+	if fee := e.Estimate(nil, 3); fee != 0 {
+		t.Fatalf("one block had room, so expected 0 but got %d", fee)
+	}
+	if fee := e.Estimate([]uint64{50}, 10); fee != 0 {
+		t.Fatalf("waiting longer than the history should be free but got %d", fee)
+	}
+}
@@ -0,0 +1,49 @@
+package currency
+
+import (
+	"fmt"
+
+	"github.com/lacker/coinkit/util"
+)
+
+// A FeeMessage asks a node for the fee an operation needs to get into a block
+// within Slots slots. A client sends one with I = 0, and the node answers
+// with I set to its current slot.
+type FeeMessage struct {
+	// The slot the node was working on when it made the estimate.
+	// 0 means this is a query.
+	I int
+
+	// How many slots the operation can wait
+	Slots int
+
+	// The estimated fee. Only set in answers.
+	Fee uint64
+
+	// How many operations were pending. Only set in answers.
+	Pending int
+}
+
+func (m *FeeMessage) Slot() int {
+	return m.I
+}
+
+func (m *FeeMessage) MessageType() string {
+	return "F"
+}
+
+func (m *FeeMessage) IsQuery() bool {
+	return m.I == 0
+}
+
+func (m *FeeMessage) String() string {
+	if m.IsQuery() {
+		return fmt.Sprintf("fee query for %d slots", m.Slots)
+	}
+	return fmt.Sprintf("fee i=%d: %d within %d slots, %d pending",
+		m.I, m.Fee, m.Slots, m.Pending)
+}
+
+func init() {
+	util.RegisterMessageType(&FeeMessage{})
+}
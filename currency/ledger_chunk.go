@@ -6,6 +6,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"sort"
 
 	"github.com/lacker/coinkit/consensus"
@@ -15,6 +16,9 @@ import (
 // MaxChunkSize defines how many items can be put in a chunk
 const MaxChunkSize = 100
 
+// MaxChunkBytes limits how big a proposed chunk can be, serialized
+const MaxChunkBytes = 1024 * 1024
+
 // A LedgerChunk is the information in one block of the blockchain.
 type LedgerChunk struct {
 	Operations []*util.SignedOperation
@@ -23,6 +27,10 @@ type LedgerChunk struct {
 	// This only includes account information for the accounts that are
 	// mentioned in the transactions.
 	State map[string]*Account
+
+	// The proposals a combined chunk was made from, sorted. Empty for a
+	// chunk that was proposed directly.
+	Sources []consensus.SlotValue `json:",omitempty"`
 }
 
 func NewEmptyChunk() *LedgerChunk {
@@ -47,9 +55,54 @@ func (c *LedgerChunk) Hash() consensus.SlotValue {
 		account := c.State[key]
 		h.Write(account.Bytes())
 	}
+	if len(c.Sources) > 0 {
+		h.Write([]byte("sources"))
+		for _, source := range c.Sources {
+			h.Write([]byte(source))
+		}
+	}
 	return consensus.SlotValue(base64.RawStdEncoding.EncodeToString(h.Sum(nil)))
 }
 
+// CheckProposal returns an error if this chunk isn't something an honest node
+// could propose for a new block. It doesn't check the chunk against any
+// account balances, just its shape and signatures.
+// Chunks already in the blockchain aren't held to this, so an empty chunk is
+// fine there, but nobody should be proposing one.
+func (c *LedgerChunk) CheckProposal() error {
+	if len(c.Operations) == 0 {
+		return errors.New("the chunk has no operations")
+	}
+	if len(c.Operations) > MaxChunkSize {
+		return fmt.Errorf("the chunk has %d operations but the limit is %d",
+			len(c.Operations), MaxChunkSize)
+	}
+	seen := make(map[string]bool)
+	for _, op := range c.Operations {
+		if op == nil {
+			return errors.New("the chunk has a nil operation")
+		}
+		if seen[op.Signature] {
+			return errors.New("the chunk has a duplicate operation")
+		}
+		seen[op.Signature] = true
+	}
+	if cost := c.Cost(); cost > MaxChunkCost {
+		return fmt.Errorf("the chunk costs %d but the limit is %d", cost, MaxChunkCost)
+	}
+	if !util.VerifyOperations(c.Operations) {
+		return errors.New("the chunk has an operation that does not verify")
+	}
+	bytes, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if len(bytes) > MaxChunkBytes {
+		return fmt.Errorf("the chunk is %d bytes but the limit is %d", len(bytes), MaxChunkBytes)
+	}
+	return nil
+}
+
 func (c *LedgerChunk) String() string {
 	return util.StringifyOperations(c.Operations)
 }
@@ -70,16 +123,3 @@ func (c *LedgerChunk) Scan(src interface{}) error {
 	}
 	return nil
 }
-
-// Returns only the operations that are send operations
-// TODO: get rid of this
-func (c *LedgerChunk) SendOperations() []*SendOperation {
-	answer := []*SendOperation{}
-	for _, op := range c.Operations {
-		t, ok := op.Operation.(*SendOperation)
-		if ok {
-			answer = append(answer, t)
-		}
-	}
-	return answer
-}
@@ -68,3 +68,63 @@ func TestLedgerChunkHashing(t *testing.T) {
 		t.Fatal("chunk1 should != chunk4")
 	}
 }
+
+func TestCheckProposal(t *testing.T) {
+	t1 := makeTestSendOperation(1)
+	t2 := makeTestSendOperation(2)
+	if (&LedgerChunk{Operations: []*util.SignedOperation{t1, t2}}).CheckProposal() != nil {
+		t.Fatal("a chunk with two sends should be a fine proposal")
+	}
+	if NewEmptyChunk().CheckProposal() == nil {
+		t.Fatal("an empty chunk should not be a proposal")
+	}
+	if (&LedgerChunk{Operations: []*util.SignedOperation{t1, t1}}).CheckProposal() == nil {
+		t.Fatal("duplicate operations should not be allowed")
+	}
+	forged := *t2
+	forged.Signature = t1.Signature
+	if (&LedgerChunk{Operations: []*util.SignedOperation{&forged}}).CheckProposal() == nil {
+		t.Fatal("an operation with the wrong signature should not be allowed")
+	}
+}
+
+// makeFullChunk makes a chunk with MaxChunkSize sends from different signers.
+func makeFullChunk() *LedgerChunk {
+	chunk := NewEmptyChunk()
+	for i := 1; i <= MaxChunkSize; i++ {
+		chunk.Operations = append(chunk.Operations, makeTestSendOperation(i))
+	}
+	return chunk
+}
+
+// Signature checks are split among the cores, so compare
+// go test ./currency -run=zzz -bench=BenchmarkCheckProposal -cpu=1,2,4
+func BenchmarkCheckProposal(b *testing.B) {
+	chunk := makeFullChunk()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := chunk.CheckProposal(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Applying a chunk is sequential, so it should take the same time at any -cpu.
+func BenchmarkApplyChunk(b *testing.B) {
+	chunk := makeFullChunk()
+	m := NewAccountMap()
+	for _, op := range chunk.Operations {
+		m.SetBalance(op.GetSigner(), 1000)
+	}
+	copy := m.CowCopy()
+	copy.ProcessChunk(chunk)
+	for owner, account := range copy.data {
+		chunk.State[owner] = account
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !m.CowCopy().ApplyChunk(chunk) {
+			b.Fatal("the chunk should apply")
+		}
+	}
+}
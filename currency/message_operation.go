@@ -0,0 +1,94 @@
+package currency
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/lacker/coinkit/util"
+)
+
+// The longest message, in bytes, before it is encrypted
+const MaxMessageSize = 1024
+
+// A MessageOperation sends a message to another account. The message is
+// encrypted to the recipient's public key with util.Encrypt, so only the
+// recipient can read it.
+// Once the operation is in a block, nodes with a database save it as a
+// document in the "messages" collection.
+type MessageOperation struct {
+	// Who is sending this message
+	Signer string
+
+	// The sequence number for this operation
+	Sequence uint32
+
+	// How much the sender is willing to pay to get this message registered
+	Fee uint64
+
+	// Who the message is for
+	To string
+
+	// The encrypted message
+	Ciphertext string
+}
+
+func (op *MessageOperation) String() string {
+	return fmt.Sprintf("message from %s -> %s, seq %d fee %d",
+		util.Shorten(op.Signer), util.Shorten(op.To), op.Sequence, op.Fee)
+}
+
+func (op *MessageOperation) OperationType() string {
+	return "Message"
+}
+
+func (op *MessageOperation) GetSigner() string {
+	return op.Signer
+}
+
+func (op *MessageOperation) GetFee() uint64 {
+	return op.Fee
+}
+
+func (op *MessageOperation) GetSequence() uint32 {
+	return op.Sequence
+}
+
+func (op *MessageOperation) Verify() bool {
+	if _, err := util.ReadPublicKey(op.To); err != nil {
+		return false
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(op.Ciphertext)
+	if err != nil {
+		return false
+	}
+	size := len(sealed) - util.EncryptionOverhead
+	return size >= 0 && size <= MaxMessageSize
+}
+
+// NewMessageOperation encrypts text for the recipient and makes an operation
+// to send it.
+func NewMessageOperation(signer string, sequence uint32, fee uint64,
+	to string, text string) (*MessageOperation, error) {
+	if len(text) > MaxMessageSize {
+		return nil, fmt.Errorf("messages can be at most %d bytes", MaxMessageSize)
+	}
+	pk, err := util.ReadPublicKey(to)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := util.Encrypt(pk, []byte(text))
+	if err != nil {
+		return nil, err
+	}
+	return &MessageOperation{
+		Signer:     signer,
+		Sequence:   sequence,
+		Fee:        fee,
+		To:         to,
+		Ciphertext: ciphertext,
+	}, nil
+}
+
+func init() {
+	util.RegisterOperationType(&MessageOperation{})
+}
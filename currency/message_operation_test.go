@@ -0,0 +1,51 @@
+package currency
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lacker/coinkit/util"
+)
+
+func TestMessageOperation(t *testing.T) {
+	alice := util.NewKeyPairFromSecretPhrase("alice")
+	bob := util.NewKeyPairFromSecretPhrase("bob")
+	op, err := NewMessageOperation(alice.PublicKey().String(), 1, 2,
+		bob.PublicKey().String(), "hi bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !op.Verify() {
+		t.Fatal("the message should verify")
+	}
+	plaintext, err := bob.Decrypt(op.Ciphertext)
+	if err != nil || string(plaintext) != "hi bob" {
+		t.Fatalf("bob could not read the message: %q %v", plaintext, err)
+	}
+
+	m := NewAccountMap()
+	if m.Validate(op) {
+		t.Fatal("alice should need an account to send a message")
+	}
+	m.SetBalance(alice.PublicKey().String(), 10)
+	if !m.Process(op) {
+		t.Fatal("the message should process")
+	}
+	account := m.Get(alice.PublicKey().String())
+	if account.Sequence != 1 || account.Balance != 8 {
+		t.Fatalf("the fee was not charged: %+v", account)
+	}
+	if m.Validate(op) {
+		t.Fatal("messages should not be replayable")
+	}
+
+	_, err = NewMessageOperation(alice.PublicKey().String(), 2, 0,
+		bob.PublicKey().String(), strings.Repeat("x", MaxMessageSize+1))
+	if err == nil {
+		t.Fatal("long messages should be rejected")
+	}
+	op.Ciphertext = "not base64!"
+	if op.Verify() {
+		t.Fatal("a bad ciphertext should not verify")
+	}
+}
@@ -1,6 +1,11 @@
 package currency
 
 import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
 	"github.com/emirpasic/gods/sets/treeset"
 
 	"github.com/lacker/coinkit/consensus"
@@ -20,6 +25,14 @@ type OperationQueue struct {
 	// The pool of pending transactions.
 	set *treeset.Set
 
+	// What we know about each pending operation, keyed by signature
+	pending map[string]*pendingInfo
+
+	// The pending operation for each signer and sequence number, keyed by
+	// replacementKey. There is at most one, since a new operation with the
+	// same sequence number replaces the old one if it has a higher fee.
+	bySequence map[string]*util.SignedOperation
+
 	// The ledger chunks that are being considered
 	// They are indexed by their hash
 	chunks map[consensus.SlotValue]*LedgerChunk
@@ -28,11 +41,21 @@ type OperationQueue struct {
 	// They are indexed by slot
 	oldChunks map[int]*LedgerChunk
 
+	// The oldest slot that oldChunks may still have
+	oldest int
+
+	// How many of the most recently finalized chunks we keep in oldChunks.
+	// Zero keeps all of them.
+	retention int
+
 	// accounts is used to validate transactions
 	// For now this is the actual authentic store of account data
 	// TODO: get this into a real database
 	accounts *AccountMap
 
+	// Which nodes are validators, and their votes to change that
+	validators *ValidatorSet
+
 	// The key of the last chunk to get finalized
 	last consensus.SlotValue
 
@@ -41,19 +64,59 @@ type OperationQueue struct {
 
 	// A count of the number of transactions this queue has finalized
 	finalized int
+
+	// Tracks the fees in recent blocks
+	fees *FeeEstimator
+
+	// If OnAdmit is set, it is called with every operation that gets added
+	// to the queue. It must not block.
+	OnAdmit func(op *util.SignedOperation)
+
+	// If Admit is set, only operations of these types are added to the queue.
+	// Chunks are validated the same way either way, so a node still accepts
+	// blocks with any type of operation in them.
+	Admit map[string]bool
+
+	// If Allow is set, only operations signed by these public keys are added
+	// to the queue. Like Admit, it doesn't affect which chunks are accepted.
+	Allow map[string]bool
+
+	// Operations signed by these public keys are in the priority lane. See
+	// priority.go
+	Priority map[string]bool
+
+	// The most of a proposed block the priority lane can take, as a
+	// percentage of MaxChunkSize. Zero means DefaultPriorityShare
+	PriorityShare int
+
+	// The lane accounting for the last block we finalized, nil before the
+	// first one
+	lastBlock *BlockStats
+
+	// The money supply, and what the last block we finalized did to it. See
+	// supply.go
+	supply       uint64
+	lastIssuance *Issuance
 }
 
 func NewOperationQueue(publicKey util.PublicKey) *OperationQueue {
-	return &OperationQueue{
-		publicKey: publicKey,
-		set:       treeset.NewWith(util.HighestFeeFirst),
-		chunks:    make(map[consensus.SlotValue]*LedgerChunk),
-		oldChunks: make(map[int]*LedgerChunk),
-		accounts:  NewAccountMap(),
-		last:      consensus.SlotValue(""),
-		slot:      1,
-		finalized: 0,
+	q := &OperationQueue{
+		publicKey:  publicKey,
+		set:        treeset.NewWith(util.HighestFeeFirst),
+		pending:    make(map[string]*pendingInfo),
+		bySequence: make(map[string]*util.SignedOperation),
+		chunks:     make(map[consensus.SlotValue]*LedgerChunk),
+		oldChunks:  make(map[int]*LedgerChunk),
+		oldest:     1,
+		accounts:   NewAccountMap(),
+		validators: NewValidatorSet(consensus.QuorumSlice{}),
+		last:       consensus.SlotValue(""),
+		slot:       1,
+		finalized:  0,
+		fees:       NewFeeEstimator(FeeHistory),
 	}
+	q.accounts.SetSlot(q.slot)
+	return q
 }
 
 // Returns the top n items in the queue
@@ -75,6 +138,29 @@ func (q *OperationQueue) Remove(op *util.SignedOperation) {
 		return
 	}
 	q.set.Remove(op)
+	delete(q.pending, op.Signature)
+	key := replacementKey(op)
+	if pending := q.bySequence[key]; pending != nil && pending.Signature == op.Signature {
+		delete(q.bySequence, key)
+	}
+}
+
+// replacementKey identifies the operations that conflict with each other,
+// because they have the same signer and sequence number.
+func replacementKey(op util.Operation) string {
+	return fmt.Sprintf("%s %d", op.GetSigner(), op.GetSequence())
+}
+
+// Conflicting returns the pending operation that has the same signer and
+// sequence number as op, or nil if there is none.
+func (q *OperationQueue) Conflicting(op util.Operation) *util.SignedOperation {
+	return q.bySequence[replacementKey(op)]
+}
+
+// Allowed returns whether this queue admits operations signed by signer.
+// Priority signers are always allowed.
+func (q *OperationQueue) Allowed(signer string) bool {
+	return q.Allow == nil || q.Allow[signer] || q.IsPriority(signer)
 }
 
 func (q *OperationQueue) Logf(format string, a ...interface{}) {
@@ -83,28 +169,47 @@ func (q *OperationQueue) Logf(format string, a ...interface{}) {
 
 // Add adds an operation to the queue
 // If it isn't valid, we just discard it.
-// We don't constantly revalidate so it's possible we have invalid
-// operations in the queue, if a higher-fee operation that conflicts with a particular
-// operation is added after it is.
+// If there is already a pending operation with the same signer and sequence
+// number, the new one replaces it only when its fee is strictly higher, so that
+// a stuck payment can be bumped. Otherwise the new one is discarded.
 // Returns whether any changes were made.
 func (q *OperationQueue) Add(op *util.SignedOperation) bool {
 	if !q.Validate(op) || q.Contains(op) {
 		return false
 	}
+	if !q.admits(op.Operation) {
+		return false
+	}
+	if !q.Allowed(op.GetSigner()) {
+		return false
+	}
+	if old := q.Conflicting(op.Operation); old != nil {
+		if op.GetFee() <= old.GetFee() {
+			return false
+		}
+		q.Logf("replacing %s with %s", old.Operation, op.Operation)
+		q.Remove(old)
+	}
 
 	q.Logf("saw a new operation: %s", op.Operation)
 	q.set.Add(op)
+	q.bySequence[replacementKey(op)] = op
+	q.pending[op.Signature] = &pendingInfo{
+		added: time.Now(),
+		size:  encodedSize(op),
+	}
 
 	if q.set.Size() > QueueLimit {
-		it := q.set.Iterator()
-		if !it.Last() {
-			util.Logger.Fatal("logical failure with treeset")
-		}
-		worst := it.Value()
-		q.set.Remove(worst)
+		q.Remove(q.evictable())
 	}
 
-	return q.Contains(op)
+	if !q.Contains(op) {
+		return false
+	}
+	if q.OnAdmit != nil {
+		q.OnAdmit(op)
+	}
+	return true
 }
 
 func (q *OperationQueue) Contains(op *util.SignedOperation) bool {
@@ -131,13 +236,63 @@ func (q *OperationQueue) TransactionMessage() *TransactionMessage {
 	}
 }
 
-// MaxBalance is used for testing
-func (q *OperationQueue) MaxBalance() uint64 {
-	return q.accounts.MaxBalance()
+// Account returns the current state of an account, or nil if it has never
+// been touched.
+func (q *OperationQueue) Account(owner string) *Account {
+	return q.accounts.Get(owner)
+}
+
+// Snapshot returns the current state of every account
+func (q *OperationQueue) Snapshot() map[string]*Account {
+	return q.accounts.Snapshot()
+}
+
+// SetQuorumSlice sets the validators we start with, before any chunks are
+// finalized.
+func (q *OperationQueue) SetQuorumSlice(qs consensus.QuorumSlice) {
+	q.validators = NewValidatorSet(qs)
+	q.accounts.SetValidators(qs.Members)
+}
+
+// QuorumSlice returns the validators for the slot we are working on, which
+// validator operations can change.
+func (q *OperationQueue) QuorumSlice() consensus.QuorumSlice {
+	return q.validators.QuorumSlice()
+}
+
+// SetReserve sets the least balance an account can have, other than zero.
+// It is part of the genesis, so it should be set before the first block.
+// See AccountMap.SetReserve.
+func (q *OperationQueue) SetReserve(reserve uint64) {
+	q.accounts.SetReserve(reserve)
+}
+
+// SetFeePool makes every fee get credited to the pool account. Like the
+// reserve, it is part of the genesis. See fee_distribution.go.
+func (q *OperationQueue) SetFeePool(pool string) {
+	q.accounts.SetFeePool(pool)
+}
+
+// SetFeesToValidators makes fees get split among the validators for each
+// slot, when there is no fee pool. Like the reserve, it is part of the
+// genesis. See fee_distribution.go.
+func (q *OperationQueue) SetFeesToValidators(on bool) {
+	q.accounts.SetFeesToValidators(on)
+}
+
+// SetEmission sets the schedule for minting money. Like the reserve, it is
+// part of the genesis. See emission.go.
+func (q *OperationQueue) SetEmission(e *Emission) {
+	q.accounts.SetEmission(e)
 }
 
-// SetBalance is used for testing
+// SetBalance is used to set up the mint, and for testing. The balance counts
+// toward the money supply.
 func (q *OperationQueue) SetBalance(owner string, balance uint64) {
+	if old := q.accounts.Get(owner); old != nil {
+		q.supply -= old.Balance
+	}
+	q.supply += balance
 	q.accounts.SetBalance(owner, balance)
 }
 
@@ -162,18 +317,95 @@ func (q *OperationQueue) OldChunkMessage(slot int) *TransactionMessage {
 	}
 }
 
+// SetRetention sets how many of the most recently finalized chunks to keep.
+// Zero keeps all of them.
+func (q *OperationQueue) SetRetention(slots int) {
+	q.retention = slots
+	q.prune()
+}
+
+// prune drops the old chunks that are older than the retention window.
+func (q *OperationQueue) prune() {
+	if q.retention <= 0 {
+		return
+	}
+	for q.oldest <= q.slot-1-q.retention {
+		delete(q.oldChunks, q.oldest)
+		q.oldest++
+	}
+}
+
+// RetainedChunks returns how many finalized chunks we keep in memory, and how
+// many operations are in them.
+func (q *OperationQueue) RetainedChunks() (int, int) {
+	ops := 0
+	for _, chunk := range q.oldChunks {
+		ops += len(chunk.Operations)
+	}
+	return len(q.oldChunks), ops
+}
+
+// AccountAtSlot returns the state of an account right after the provided slot
+// was finalized, using the chunks we have kept in memory.
+// It returns nil if the account was not touched by any chunk we have kept, up
+// to that slot.
+func (q *OperationQueue) AccountAtSlot(owner string, slot int) *Account {
+	for i := slot; i >= q.oldest; i-- {
+		chunk := q.OldChunk(i)
+		if chunk == nil {
+			continue
+		}
+		if account, ok := chunk.State[owner]; ok {
+			return account
+		}
+	}
+	return nil
+}
+
 func (q *OperationQueue) HandleInfoMessage(m *util.InfoMessage) *AccountMessage {
-	if m == nil || m.Account == "" {
+	if m == nil {
+		return nil
+	}
+	owners := m.Owners()
+	if len(owners) == 0 {
 		return nil
 	}
 	output := &AccountMessage{
 		I:     q.slot,
 		State: make(map[string]*Account),
 	}
-	output.State[m.Account] = q.accounts.Get(m.Account)
+	for _, owner := range owners {
+		output.State[owner] = q.Account(owner)
+	}
+	output.Pending = q.pendingSequences(output.State)
+	if chunk := q.OldChunk(q.slot - 1); chunk != nil {
+		output.Hash = chunk.Hash()
+	}
 	return output
 }
 
+// pendingSequences returns the sequence numbers of the waiting operations
+// for each of the owners that has any, in order. It returns nil if none of
+// them do.
+func (q *OperationQueue) pendingSequences(owners map[string]*Account) map[string][]uint32 {
+	var answer map[string][]uint32
+	for _, item := range q.set.Values() {
+		op := item.(*util.SignedOperation)
+		owner := op.GetSigner()
+		if _, ok := owners[owner]; !ok {
+			continue
+		}
+		if answer == nil {
+			answer = make(map[string][]uint32)
+		}
+		answer[owner] = append(answer[owner], op.GetSequence())
+	}
+	for _, list := range answer {
+		sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	}
+	return answer
+}
+
 // Handles a transaction message from another node.
 // Returns whether it made any internal updates.
 func (q *OperationQueue) HandleTransactionMessage(m *TransactionMessage) bool {
@@ -184,34 +416,93 @@ func (q *OperationQueue) HandleTransactionMessage(m *TransactionMessage) bool {
 	updated := false
 	if m.Operations != nil {
 		for _, op := range m.Operations {
-			updated = updated || q.Add(op)
+			if q.Add(op) {
+				updated = true
+			}
 		}
 	}
 	if m.Chunks != nil {
-		for key, chunk := range m.Chunks {
-			if _, ok := q.chunks[key]; ok {
-				continue
-			}
-			if !q.accounts.ValidateChunk(chunk) {
-				continue
-			}
-			if chunk.Hash() != key {
-				continue
+		// Combined chunks are checked against their sources, so the
+		// proposals have to be learned first
+		for _, combined := range []bool{false, true} {
+			for key, chunk := range m.Chunks {
+				if chunk == nil || (len(chunk.Sources) > 0) != combined {
+					continue
+				}
+				if q.learnChunk(key, chunk) {
+					updated = true
+				}
 			}
-			q.Logf("learned that %s = %s", util.Shorten(string(key)), chunk)
-			q.chunks[key] = chunk
-			updated = true
 		}
 	}
 	return updated
 }
 
+// learnChunk adds a chunk from another node, if it's valid and new.
+// Returns whether it was added.
+func (q *OperationQueue) learnChunk(key consensus.SlotValue, chunk *LedgerChunk) bool {
+	if _, ok := q.chunks[key]; ok {
+		return false
+	}
+	if err := chunk.CheckProposal(); err != nil {
+		q.Logf("rejected chunk %s: %s", util.Shorten(string(key)), err)
+		return false
+	}
+	if !q.accounts.ValidateChunk(chunk) {
+		return false
+	}
+	if chunk.Hash() != key {
+		return false
+	}
+	if len(chunk.Sources) > 0 {
+		if err := q.checkSources(chunk); err != nil {
+			q.Logf("rejected chunk %s: %s", util.Shorten(string(key)), err)
+			return false
+		}
+	}
+	q.Logf("learned that %s = %s", util.Shorten(string(key)), chunk)
+	q.chunks[key] = chunk
+	return true
+}
+
+// checkSources returns an error unless a combined chunk is what combining
+// its sources makes. The sources have to be proposals we already know.
+func (q *OperationQueue) checkSources(chunk *LedgerChunk) error {
+	if len(chunk.Sources) < 2 {
+		return errors.New("a combined chunk needs at least two sources")
+	}
+	for i, v := range chunk.Sources {
+		if i > 0 && chunk.Sources[i-1] >= v {
+			return errors.New("the sources are not sorted and distinct")
+		}
+		source, ok := q.chunks[v]
+		if !ok {
+			return fmt.Errorf("unknown source %s", util.Shorten(string(v)))
+		}
+		if len(source.Sources) > 0 {
+			return fmt.Errorf("source %s is itself combined", util.Shorten(string(v)))
+		}
+	}
+	combined := q.combine(chunk.Sources)
+	if combined == nil || combined.Hash() != chunk.Hash() {
+		return errors.New("the chunk does not match its sources")
+	}
+	return nil
+}
+
 func (q *OperationQueue) Size() int {
 	return q.set.Size()
 }
 
 func (q *OperationQueue) Validate(op *util.SignedOperation) bool {
-	return op != nil && op.Verify() && q.accounts.Validate(op.Operation)
+	if op == nil || !op.Verify() || !q.accounts.Validate(op.Operation) {
+		return false
+	}
+	if v, ok := op.Operation.(*ValidatorOperation); ok {
+		// Only validators get a vote
+		return q.validators.IsValidator(v.Signer)
+	}
+	return true
 }
 
 // Revalidate checks all pending transactions to see if they are still valid
@@ -230,34 +521,60 @@ func (q *OperationQueue) Revalidate() {
 // This adds a cache entry to q.chunks
 func (q *OperationQueue) NewChunk(
 	ops []*util.SignedOperation) (consensus.SlotValue, *LedgerChunk) {
+	for i := 1; i < len(ops); i++ {
+		if util.HighestFeeFirst(ops[i-1], ops[i]) >= 0 {
+			panic("NewLedgerChunk called on non-sorted list")
+		}
+	}
+	return q.newChunkInOrder(ops)
+}
+
+// newChunkInOrder is NewChunk for operations in any order. Operations are
+// tried in the order they are given.
+func (q *OperationQueue) newChunkInOrder(
+	ops []*util.SignedOperation) (consensus.SlotValue, *LedgerChunk) {
 
-	var last *util.SignedOperation
 	validOps := []*util.SignedOperation{}
 	validator := q.accounts.CowCopy()
 	state := make(map[string]*Account)
+	cost := 0
 	for _, op := range ops {
-		if last != nil && util.HighestFeeFirst(last, op) >= 0 {
-			panic("NewLedgerChunk called on non-sorted list")
+
+		// An operation that doesn't fit in the budget is left for a later
+		// block, but a cheaper one after it still can go in
+		opCost := OperationCost(op)
+		if cost+opCost > MaxChunkCost {
+			continue
 		}
-		last = op
 		if validator.Process(op.Operation) {
 			validOps = append(validOps, op)
+			cost += opCost
 		}
 		state[op.GetSigner()] = validator.Get(op.GetSigner())
 
 		if t, ok := op.Operation.(*SendOperation); ok {
-			state[t.To] = validator.Get(t.To)
+			state[t.Recipient()] = validator.Get(t.Recipient())
 		}
 
 		if len(validOps) == MaxChunkSize {
 			break
 		}
 	}
-	if len(ops) == 0 {
+	if len(validOps) == 0 {
+		return consensus.SlotValue(""), nil
+	}
+	credited, ok := validator.creditBlock(validOps)
+	if !ok {
 		return consensus.SlotValue(""), nil
 	}
+	for _, owner := range credited {
+		state[owner] = validator.Get(owner)
+	}
+	// Operations that conflict with an earlier one, like the loser of a
+	// replace-by-fee that another node hasn't seen yet, are left out. Since
+	// ops are sorted, every node resolves conflicts the same way.
 	chunk := &LedgerChunk{
-		Operations: ops,
+		Operations: validOps,
 		State:      state,
 	}
 	key := chunk.Hash()
@@ -269,12 +586,39 @@ func (q *OperationQueue) NewChunk(
 	return key, chunk
 }
 
+// Combine merges chunks into one. The operations of all of them are put into
+// one set, keyed by signature and sorted highest fee first, and conflicts
+// are resolved the way NewChunk does. Since that only depends on the set of
+// chunks, every node combining the same chunks gets the same value, no matter
+// what order it heard about them in.
+// The combined chunk lists the proposals it was made from in Sources, and
+// combining it again starts from those proposals. So combining in stages gets
+// the same value as combining everything at once. Otherwise an operation that
+// lost a conflict in an early stage couldn't come back when a later stage
+// knocks out the winner. Sources are part of the hash, so this only depends
+// on the chunks themselves, not on which node did the combining.
 func (q *OperationQueue) Combine(list []consensus.SlotValue) consensus.SlotValue {
+	chunk := q.combine(q.proposals(list))
+	if chunk == nil {
+		panic("combining valid chunks led to nothing")
+	}
+	value := chunk.Hash()
+	if _, ok := q.chunks[value]; !ok {
+		q.Logf("i=%d, combined chunk %s -> %s", q.slot, util.Shorten(string(value)), chunk)
+		q.chunks[value] = chunk
+	}
+	return value
+}
+
+// combine makes the chunk for a sorted list of distinct proposals, without
+// caching it. A single proposal combines to itself.
+// Returns nil if the proposals are unknown or lead to nothing.
+func (q *OperationQueue) combine(parts []consensus.SlotValue) *LedgerChunk {
 	set := treeset.NewWith(util.HighestFeeFirst)
-	for _, v := range list {
+	for _, v := range parts {
 		chunk := q.chunks[v]
 		if chunk == nil {
-			util.Logger.Fatalf("%s cannot combine unknown chunk %s", q.publicKey, v)
+			return nil
 		}
 		for _, op := range chunk.Operations {
 			set.Add(op)
@@ -284,11 +628,39 @@ func (q *OperationQueue) Combine(list []consensus.SlotValue) consensus.SlotValue
 	for _, op := range set.Values() {
 		ops = append(ops, op.(*util.SignedOperation))
 	}
-	value, chunk := q.NewChunk(ops)
-	if chunk == nil {
-		panic("combining valid chunks led to nothing")
+	_, chunk := q.NewChunk(ops)
+	if chunk == nil || len(parts) < 2 {
+		return chunk
+	}
+	return &LedgerChunk{
+		Operations: chunk.Operations,
+		State:      chunk.State,
+		Sources:    parts,
 	}
-	return value
+}
+
+// proposals replaces combined chunks in list with the proposals they were
+// made from, and returns the distinct values in sorted order.
+func (q *OperationQueue) proposals(list []consensus.SlotValue) []consensus.SlotValue {
+	seen := make(map[consensus.SlotValue]bool)
+	for _, v := range list {
+		chunk := q.chunks[v]
+		if chunk == nil {
+			util.Logger.Fatalf("%s cannot combine unknown chunk %s", q.publicKey, v)
+		}
+		if len(chunk.Sources) == 0 {
+			seen[v] = true
+		}
+		for _, part := range chunk.Sources {
+			seen[part] = true
+		}
+	}
+	answer := []consensus.SlotValue{}
+	for v := range seen {
+		answer = append(answer, v)
+	}
+	sort.Slice(answer, func(i, j int) bool { return answer[i] < answer[j] })
+	return answer
 }
 
 func (q *OperationQueue) CanFinalize(v consensus.SlotValue) bool {
@@ -302,25 +674,52 @@ func (q *OperationQueue) FinalizeChunk(chunk *LedgerChunk) {
 	q.Finalize(v)
 }
 
+// Externalized finalizes the chunk that consensus chose for a slot.
+func (q *OperationQueue) Externalized(slot int, v consensus.SlotValue) {
+	if slot != q.slot {
+		panic(fmt.Sprintf("externalized slot %d but the queue is on slot %d", slot, q.slot))
+	}
+	q.Finalize(v)
+}
+
 func (q *OperationQueue) Finalize(v consensus.SlotValue) {
 	chunk, ok := q.chunks[v]
 	if !ok {
 		panic("We are finalizing a chunk but we don't know its data.")
 	}
 
-	if !q.accounts.ValidateChunk(chunk) {
-		panic("We could not validate a finalized chunk.")
-	}
-
-	if !q.accounts.ProcessChunk(chunk) {
+	issuance := q.accounts.issuance(q.slot, chunk.Operations)
+	if !q.accounts.ApplyChunk(chunk) {
 		panic("We could not process a finalized chunk.")
 	}
-
+	// The block is final either way, so a supply that goes out of range can
+	// only be reported
+	supply, ok := issuance.apply(q.supply)
+	if !ok {
+		q.Logf("slot %d would take the supply of %d out of range, burning %d and minting %d",
+			q.slot, q.supply, issuance.Burned(), issuance.Minted)
+	}
+	q.supply = supply
+	issuance.Supply = q.supply
+	q.lastIssuance = issuance
+
+	q.validators.ProcessChunk(q.slot, chunk)
+	q.fees.AddChunk(chunk)
+	q.lastBlock = q.newBlockStats(q.slot, chunk)
+	if q.lastBlock.Priority > 0 {
+		q.Logf("%s", q.lastBlock)
+	}
 	q.oldChunks[q.slot] = chunk
 	q.finalized += len(chunk.Operations)
 	q.last = v
 	q.chunks = make(map[consensus.SlotValue]*LedgerChunk)
 	q.slot += 1
+	q.accounts.SetSlot(q.slot)
+	q.prune()
+	if q.validators.Advance(q.slot) {
+		q.Logf("the validators for slot %d are %v", q.slot, q.QuorumSlice())
+		q.accounts.SetValidators(q.QuorumSlice().Members)
+	}
 	q.Revalidate()
 }
 
@@ -328,9 +727,10 @@ func (q *OperationQueue) Last() consensus.SlotValue {
 	return q.last
 }
 
-// SuggestValue returns a chunk that is keyed by its hash
+// SuggestValue returns a chunk that is keyed by its hash. The priority lane
+// goes first, and the rest are by fee.
 func (q *OperationQueue) SuggestValue() (consensus.SlotValue, bool) {
-	key, chunk := q.NewChunk(q.Operations())
+	key, chunk := q.newChunkInOrder(q.prioritized(q.Operations()))
 	if chunk == nil {
 		q.Logf("has no suggestion")
 		return consensus.SlotValue(""), false
@@ -339,13 +739,73 @@ func (q *OperationQueue) SuggestValue() (consensus.SlotValue, bool) {
 	return key, true
 }
 
+// ValidateValue returns whether we know the chunk for a value. We only learn
+// chunks that are well-formed proposals and apply to the current accounts,
+// and we forget them all when a block is finalized.
 func (q *OperationQueue) ValidateValue(v consensus.SlotValue) bool {
 	_, ok := q.chunks[v]
 	return ok
 }
 
+// EstimateFee returns the fee an operation needs to get into a block within
+// the given number of slots.
+func (q *OperationQueue) EstimateFee(slots int) uint64 {
+	pending := []uint64{}
+	for _, op := range q.Operations() {
+		pending = append(pending, op.GetFee())
+	}
+	return q.fees.Estimate(pending, slots)
+}
+
+// HandleFeeMessage answers a fee estimate query.
+func (q *OperationQueue) HandleFeeMessage(m *FeeMessage) *FeeMessage {
+	if !m.IsQuery() {
+		return nil
+	}
+	return &FeeMessage{
+		I:       q.slot,
+		Slots:   m.Slots,
+		Fee:     q.EstimateFee(m.Slots),
+		Pending: q.Size(),
+	}
+}
+
+// QueueStats returns statistics about the pending operations.
+// Ages are measured relative to now.
+func (q *OperationQueue) QueueStats(now time.Time) *QueueStats {
+	answer := &QueueStats{}
+	senders := make(map[string]bool)
+	fees := []uint64{}
+	for _, op := range q.Operations() {
+		answer.Pending++
+		senders[op.GetSigner()] = true
+		if q.IsPriority(op.GetSigner()) {
+			answer.PriorityPending++
+		}
+		fees = append(fees, op.GetFee())
+		info := q.pending[op.Signature]
+		if info == nil {
+			continue
+		}
+		answer.Bytes += info.size
+		if age := now.Sub(info.added); age > answer.OldestAge {
+			answer.OldestAge = age
+		}
+	}
+	answer.Senders = len(senders)
+	answer.LastBlock = q.lastBlock
+	if len(fees) > 0 {
+		sort.Slice(fees, func(i, j int) bool { return fees[i] < fees[j] })
+		answer.MinFee = fees[0]
+		answer.MedianFee = fees[len(fees)/2]
+		answer.MaxFee = fees[len(fees)-1]
+	}
+	return answer
+}
+
 func (q *OperationQueue) Stats() {
 	q.Logf("%d transactions finalized", q.finalized)
+	q.Logf("queue: %s", q.QueueStats(time.Now()))
 }
 
 func (q *OperationQueue) Log() {
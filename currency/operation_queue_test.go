@@ -1,8 +1,11 @@
 package currency
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
+	"github.com/lacker/coinkit/consensus"
 	"github.com/lacker/coinkit/util"
 )
 
@@ -46,3 +49,429 @@ func TestTransactionMessage(t *testing.T) {
 		t.Fatal("there should be a transaction message after we add one operation")
 	}
 }
+
+func TestAccountAtSlot(t *testing.T) {
+	kp := util.NewKeyPair()
+	q := NewOperationQueue(kp.PublicKey())
+	for slot := 1; slot <= 3; slot++ {
+		chunk := NewEmptyChunk()
+		if slot != 2 {
+			chunk.State["bob"] = &Account{Sequence: uint32(slot), Balance: uint64(10 * slot)}
+		}
+		q.oldChunks[slot] = chunk
+	}
+	if q.AccountAtSlot("bob", 1).Balance != 10 {
+		t.Fatal("expected balance 10 at slot 1")
+	}
+	if q.AccountAtSlot("bob", 2).Balance != 10 {
+		t.Fatal("slot 2 did not touch bob so the balance should still be 10")
+	}
+	if q.AccountAtSlot("bob", 3).Balance != 30 {
+		t.Fatal("expected balance 30 at slot 3")
+	}
+	if q.AccountAtSlot("alice", 3) != nil {
+		t.Fatal("alice was never touched")
+	}
+}
+
+func TestChunkRetention(t *testing.T) {
+	kp := util.NewKeyPair()
+	q := NewOperationQueue(kp.PublicKey())
+	q.SetRetention(3)
+	for slot := 1; slot <= 10; slot++ {
+		q.FinalizeChunk(NewEmptyChunk())
+	}
+	if chunks, _ := q.RetainedChunks(); chunks != 3 {
+		t.Fatalf("expected 3 chunks but there are %d", chunks)
+	}
+	if q.OldChunk(7) != nil || q.OldChunk(8) == nil || q.OldChunk(10) == nil {
+		t.Fatal("expected to keep slots 8 through 10")
+	}
+}
+
+func TestQueueStats(t *testing.T) {
+	kp := util.NewKeyPair()
+	q := NewOperationQueue(kp.PublicKey())
+	start := time.Now()
+	stats := q.QueueStats(start)
+	if stats.Pending != 0 || stats.Bytes != 0 || stats.MaxFee != 0 {
+		t.Fatalf("expected empty stats but got %+v", stats)
+	}
+
+	for i := 1; i <= 5; i++ {
+		op := makeTestSendOperation(i)
+		tr := op.Operation.(*SendOperation)
+		q.accounts.SetBalance(tr.Signer, 10*tr.Amount)
+		q.Add(op)
+	}
+	stats = q.QueueStats(start.Add(time.Hour))
+	if stats.Pending != 5 {
+		t.Fatalf("expected 5 pending but got %d", stats.Pending)
+	}
+	if stats.Senders != 5 {
+		t.Fatalf("expected 5 senders but got %d", stats.Senders)
+	}
+	if stats.MinFee != 1 || stats.MedianFee != 3 || stats.MaxFee != 5 {
+		t.Fatalf("bad fee distribution: %s", stats)
+	}
+	if stats.OldestAge < 59*time.Minute || stats.OldestAge > time.Hour {
+		t.Fatalf("bad oldest age: %s", stats.OldestAge)
+	}
+	if stats.Bytes <= 0 {
+		t.Fatalf("expected a positive size but got %d", stats.Bytes)
+	}
+	size := stats.Bytes
+
+	q.Remove(makeTestSendOperation(5))
+	stats = q.QueueStats(start)
+	if stats.Pending != 4 || stats.MaxFee != 4 || stats.Bytes >= size {
+		t.Fatalf("removing an operation was not reflected: %s", stats)
+	}
+}
+
+func TestPending(t *testing.T) {
+	kp := util.NewKeyPair()
+	q := NewOperationQueue(kp.PublicKey())
+	start := time.Now()
+	for i := 1; i <= 5; i++ {
+		op := makeTestSendOperation(i)
+		tr := op.Operation.(*SendOperation)
+		q.accounts.SetBalance(tr.Signer, 10*tr.Amount)
+		q.Add(op)
+	}
+
+	pending := q.Pending(start.Add(time.Hour), nil)
+	if len(pending) != 5 {
+		t.Fatalf("expected 5 pending but got %d", len(pending))
+	}
+	for i, p := range pending {
+		if p.Fee != uint64(5-i) || p.Ahead != i {
+			t.Fatalf("expected highest fee first but got %+v", p)
+		}
+		if p.Type != "Send" || p.Amount == 0 || p.Recipient == "" {
+			t.Fatalf("expected the send to be described but got %+v", p)
+		}
+		if p.Age < 59*time.Minute || p.Age > time.Hour {
+			t.Fatalf("bad age: %s", p.Age)
+		}
+		if i > 0 && !p.After(pending[i-1].Fee, pending[i-1].Signature) {
+			t.Fatalf("%+v should come after %+v", p, pending[i-1])
+		}
+	}
+
+	// Filtering keeps the position in the whole queue
+	signer := pending[2].Signer
+	matching := q.Pending(start, func(op *util.SignedOperation) bool {
+		return op.GetSigner() == signer
+	})
+	if len(matching) != 1 || matching[0].Ahead != 2 {
+		t.Fatalf("expected one operation with two ahead but got %+v", matching)
+	}
+}
+
+// A send to yourself goes through a whole block, not just the account map.
+// The sender used to keep the amount and not advance its sequence.
+func TestSendToSelfInBlock(t *testing.T) {
+	kp := util.NewKeyPairFromSecretPhrase("alice")
+	alice := kp.PublicKey().String()
+	q := NewOperationQueue(util.NewKeyPair().PublicKey())
+	q.accounts.SetBalance(alice, 200)
+	op := util.NewSignedOperation(&SendOperation{
+		Signer:   alice,
+		Sequence: 1,
+		To:       alice,
+		Amount:   100,
+		Fee:      3,
+	}, kp)
+	if !q.Add(op) {
+		t.Fatal("the send should be added")
+	}
+	_, chunk := q.NewChunk([]*util.SignedOperation{op})
+	if chunk == nil || chunk.State[alice].Balance != 197 {
+		t.Fatalf("bad chunk: %+v", chunk)
+	}
+	q.FinalizeChunk(chunk)
+	if account := q.Account(alice); account.Sequence != 1 || account.Balance != 197 {
+		t.Fatalf("sending to yourself should only cost the fee: %+v", account)
+	}
+}
+
+func TestHandleTransactionMessageAddsEveryOperation(t *testing.T) {
+	kp := util.NewKeyPair()
+	q := NewOperationQueue(kp.PublicKey())
+	ops := []*util.SignedOperation{}
+	for i := 1; i <= 3; i++ {
+		op := makeTestSendOperation(i)
+		tr := op.Operation.(*SendOperation)
+		q.accounts.SetBalance(tr.Signer, 10*tr.Amount)
+		ops = append(ops, op)
+	}
+	if !q.HandleTransactionMessage(NewTransactionMessage(ops...)) {
+		t.Fatal("the message should have updated the queue")
+	}
+	if q.Size() != 3 {
+		t.Fatalf("expected 3 pending operations but got %d", q.Size())
+	}
+}
+
+func TestAdmit(t *testing.T) {
+	kp := util.NewKeyPair()
+	q := NewOperationQueue(kp.PublicKey())
+	q.Admit = map[string]bool{"Validator": true}
+	op := makeTestSendOperation(1)
+	tr := op.Operation.(*SendOperation)
+	q.accounts.SetBalance(tr.Signer, 10*tr.Amount)
+	if q.Add(op) || q.Size() != 0 {
+		t.Fatal("a send should not be admitted")
+	}
+
+	// Chunks with sends in them are still fine
+	other := NewOperationQueue(kp.PublicKey())
+	other.accounts.SetBalance(tr.Signer, 10*tr.Amount)
+	key, chunk := other.NewChunk([]*util.SignedOperation{op})
+	m := &TransactionMessage{
+		Chunks: map[consensus.SlotValue]*LedgerChunk{key: chunk},
+	}
+	if !q.HandleTransactionMessage(m) || q.chunks[key] == nil {
+		t.Fatal("the chunk should have been accepted")
+	}
+
+	// Empty chunks aren't
+	empty := NewEmptyChunk()
+	m = &TransactionMessage{
+		Chunks: map[consensus.SlotValue]*LedgerChunk{empty.Hash(): empty},
+	}
+	if q.HandleTransactionMessage(m) || q.ValidateValue(empty.Hash()) {
+		t.Fatal("an empty chunk should have been rejected")
+	}
+}
+
+func TestReplaceByFee(t *testing.T) {
+	kp := util.NewKeyPairFromSecretPhrase("alice")
+	send := func(fee uint64) *util.SignedOperation {
+		return util.NewSignedOperation(&SendOperation{
+			Signer:   kp.PublicKey().String(),
+			Sequence: 1,
+			To:       util.NewKeyPairFromSecretPhrase("bob").PublicKey().String(),
+			Amount:   10,
+			Fee:      fee,
+		}, kp)
+	}
+	low, high := send(1), send(2)
+	q := NewOperationQueue(kp.PublicKey())
+	q.accounts.SetBalance(kp.PublicKey().String(), 100)
+	if !q.Add(low) {
+		t.Fatal("the first send should be added")
+	}
+	if q.Add(send(1)) || q.Conflicting(low) != low {
+		t.Fatal("a replacement with the same fee should be rejected")
+	}
+	if !q.Add(high) || q.Size() != 1 || q.Contains(low) || q.Conflicting(low) != high {
+		t.Fatal("a higher fee should replace the pending send")
+	}
+	if q.Add(low) {
+		t.Fatal("the replaced send should not come back")
+	}
+	if failed := failedChecks(q.CheckAdmission(low.Operation)); len(failed) != 1 ||
+		failed[0] != "replacement" {
+		t.Fatalf("only the replacement check should fail: %v", failed)
+	}
+
+	// Another node that only saw the low fee send picks up the replacement
+	// when the queues are shared
+	other := NewOperationQueue(kp.PublicKey())
+	other.accounts.SetBalance(kp.PublicKey().String(), 100)
+	other.Add(low)
+	other.HandleTransactionMessage(q.TransactionMessage())
+	if other.Size() != 1 || !other.Contains(high) {
+		t.Fatal("the other queue should have replaced its send")
+	}
+
+	// If the conflicting sends end up in chunks anyway, combining them keeps
+	// only the higher fee one
+	third := NewOperationQueue(kp.PublicKey())
+	third.accounts.SetBalance(kp.PublicKey().String(), 100)
+	lowKey, _ := third.NewChunk([]*util.SignedOperation{low})
+	highKey, _ := third.NewChunk([]*util.SignedOperation{high})
+	combined := third.chunks[third.Combine([]consensus.SlotValue{lowKey, highKey})]
+	if len(combined.Operations) != 1 || combined.Operations[0] != high {
+		t.Fatalf("bad combined chunk: %s", combined)
+	}
+	if !third.accounts.ValidateChunk(combined) {
+		t.Fatal("the combined chunk should be valid")
+	}
+}
+
+func TestCombine(t *testing.T) {
+	kp := util.NewKeyPairFromSecretPhrase("alice")
+	carol := util.NewKeyPairFromSecretPhrase("carol")
+	bob := util.NewKeyPairFromSecretPhrase("bob").PublicKey().String()
+	sendFrom := func(from *util.KeyPair, seq uint32, amount uint64, fee uint64) *util.SignedOperation {
+		return util.NewSignedOperation(&SendOperation{
+			Signer:   from.PublicKey().String(),
+			Sequence: seq,
+			To:       bob,
+			Amount:   amount,
+			Fee:      fee,
+		}, from)
+	}
+	send := func(seq uint32, amount uint64, fee uint64) *util.SignedOperation {
+		return sendFrom(kp, seq, amount, fee)
+	}
+	// Alice has 10 to spend. Big spends 9 of it, which leaves too little for
+	// second, but tiny beats big and leaves enough.
+	big := send(1, 6, 3)
+	small := send(1, 1, 2)
+	second := send(2, 4, 1)
+	tiny := send(1, 1, 4)
+	other := sendFrom(carol, 1, 1, 5)
+	proposals := [][]*util.SignedOperation{
+		{big},
+		{other, small, second},
+		{tiny},
+	}
+
+	newQueue := func() (*OperationQueue, []consensus.SlotValue) {
+		q := NewOperationQueue(kp.PublicKey())
+		q.accounts.SetBalance(kp.PublicKey().String(), 10)
+		q.accounts.SetBalance(carol.PublicKey().String(), 10)
+		values := []consensus.SlotValue{}
+		for _, ops := range proposals {
+			v, chunk := q.NewChunk(ops)
+			if chunk == nil || len(chunk.Operations) != len(ops) {
+				t.Fatalf("bad proposal %s", chunk)
+			}
+			values = append(values, v)
+		}
+		return q, values
+	}
+	q, v := newQueue()
+	a, b, c := v[0], v[1], v[2]
+	all := q.Combine([]consensus.SlotValue{a, b, c})
+	chunk := q.chunks[all]
+	if len(chunk.Operations) != 3 || chunk.Operations[0] != other ||
+		chunk.Operations[1] != tiny || chunk.Operations[2] != second {
+		t.Fatalf("bad combined chunk: %s", chunk)
+	}
+
+	// Order and duplicates don't matter
+	orders := [][]consensus.SlotValue{
+		{c, b, a}, {b, a, c}, {a, a, b, c, c}, {all, a}, {all},
+	}
+	for _, order := range orders {
+		if q.Combine(order) != all {
+			t.Fatalf("combining %v got a different value", order)
+		}
+	}
+
+	// Combining in stages gets the same value. Without the proposals, the
+	// second send would have been lost when big beat small.
+	ab := q.Combine([]consensus.SlotValue{a, b})
+	bc := q.Combine([]consensus.SlotValue{b, c})
+	if len(q.chunks[ab].Operations) != 2 {
+		t.Fatalf("bad partial combination: %s", q.chunks[ab])
+	}
+	if q.Combine([]consensus.SlotValue{ab, c}) != all ||
+		q.Combine([]consensus.SlotValue{a, bc}) != all {
+		t.Fatal("Combine should be associative")
+	}
+
+	// Another node that learned the proposals in a different order agrees
+	q2, _ := newQueue()
+	if q2.Combine([]consensus.SlotValue{c, a, b}) != all {
+		t.Fatal("nodes should agree on the combined value")
+	}
+
+	// So does a node that only learns the combined chunk from a peer
+	q3, _ := newQueue()
+	q3.HandleTransactionMessage(&TransactionMessage{
+		Chunks: map[consensus.SlotValue]*LedgerChunk{ab: q.chunks[ab]},
+	})
+	if q3.chunks[ab] == nil {
+		t.Fatal("the combined chunk should be learned")
+	}
+	if q3.Combine([]consensus.SlotValue{ab, c}) != all {
+		t.Fatal("a node that didn't do the combining should agree too")
+	}
+
+	// A combined chunk that doesn't match its sources is rejected
+	bogus := &LedgerChunk{
+		Operations: q.chunks[a].Operations,
+		State:      q.chunks[a].State,
+		Sources:    []consensus.SlotValue{a, b},
+	}
+	if a > b {
+		bogus.Sources = []consensus.SlotValue{b, a}
+	}
+	q4, _ := newQueue()
+	q4.HandleTransactionMessage(&TransactionMessage{
+		Chunks: map[consensus.SlotValue]*LedgerChunk{bogus.Hash(): bogus},
+	})
+	if q4.chunks[bogus.Hash()] != nil {
+		t.Fatal("a combined chunk should have to match its sources")
+	}
+}
+
+func TestPendingSequences(t *testing.T) {
+	kp := util.NewKeyPair()
+	q := NewOperationQueue(kp.PublicKey())
+	op := makeTestSendOperation(0)
+	tr := op.Operation.(*SendOperation)
+	q.accounts.SetBalance(tr.Signer, 10*tr.Amount)
+	q.Add(op)
+	m := q.HandleInfoMessage(&util.InfoMessage{Account: tr.Signer})
+	pending := m.Pending[tr.Signer]
+	if len(pending) != 1 || pending[0] != tr.Sequence {
+		t.Fatalf("bad pending sequences: %+v", m.Pending)
+	}
+	m = q.HandleInfoMessage(&util.InfoMessage{Account: tr.To})
+	if m.Pending != nil {
+		t.Fatalf("nothing is pending for the recipient: %+v", m.Pending)
+	}
+}
+
+func TestBatchInfoMessage(t *testing.T) {
+	kp := util.NewKeyPair()
+	q := NewOperationQueue(kp.PublicKey())
+	q.SetBalance("bob", 10)
+	q.SetBalance("carol", 20)
+	m := q.HandleInfoMessage(&util.InfoMessage{
+		Account:  "bob",
+		Accounts: []string{"carol", "dave", "bob"},
+	})
+	if len(m.State) != 3 || m.State["bob"].Balance != 10 ||
+		m.State["carol"].Balance != 20 || m.State["dave"] != nil {
+		t.Fatalf("bad state: %+v", m.State)
+	}
+
+	many := []string{}
+	for i := 0; i < util.MaxAccountsPerQuery+10; i++ {
+		many = append(many, fmt.Sprintf("user%d", i))
+	}
+	m = q.HandleInfoMessage(&util.InfoMessage{Accounts: many})
+	if len(m.State) != util.MaxAccountsPerQuery {
+		t.Fatalf("expected %d accounts but got %d", util.MaxAccountsPerQuery, len(m.State))
+	}
+}
+
+// Each account can only have one operation in the queue at a time, since
+// only the next sequence number is admitted, so one account can't flood it.
+func TestOnePendingPerAccount(t *testing.T) {
+	q := NewOperationQueue(util.NewKeyPair().PublicKey())
+	first := makeTestSendOperation(1)
+	tr := first.Operation.(*SendOperation)
+	q.accounts.SetBalance(tr.Signer, 1000*tr.Amount)
+	if !q.Add(first) {
+		t.Fatal("the first operation should be admitted")
+	}
+	for seq := uint32(2); seq < 5; seq++ {
+		next := *tr
+		next.Sequence = seq
+		if q.Add(util.NewSignedOperation(&next, util.NewKeyPairFromSecretPhrase("blorp 1"))) {
+			t.Fatalf("sequence %d should not be admitted while 1 is pending", seq)
+		}
+	}
+	if q.Size() != 1 {
+		t.Fatalf("expected one pending operation but got %d", q.Size())
+	}
+}
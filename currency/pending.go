@@ -0,0 +1,71 @@
+package currency
+
+import (
+	"time"
+
+	"github.com/lacker/coinkit/util"
+)
+
+// PendingOperation describes one operation waiting in an OperationQueue, for
+// wallets that want to show their users a payment is queued.
+type PendingOperation struct {
+	// The signature identifies the operation
+	Signature string `json:"signature"`
+
+	// The operation type, like "Send"
+	Type string `json:"type"`
+
+	Signer   string `json:"signer"`
+	Sequence uint32 `json:"sequence"`
+	Fee      uint64 `json:"fee"`
+
+	// Only set for sends
+	Recipient string `json:"recipient,omitempty"`
+	Amount    uint64 `json:"amount,omitempty"`
+
+	// How long the operation has been waiting
+	Age time.Duration `json:"age"`
+
+	// How many pending operations pay a higher fee, and so come first when
+	// the next block is proposed
+	Ahead int `json:"ahead"`
+}
+
+// Pending describes the pending operations that match, highest fee first,
+// which is the order they get into blocks. A nil match matches everything.
+// Ages are measured relative to now.
+func (q *OperationQueue) Pending(now time.Time,
+	match func(*util.SignedOperation) bool) []*PendingOperation {
+	answer := []*PendingOperation{}
+	for i, op := range q.Operations() {
+		if match != nil && !match(op) {
+			continue
+		}
+		p := &PendingOperation{
+			Signature: op.Signature,
+			Type:      op.Type,
+			Signer:    op.GetSigner(),
+			Sequence:  op.GetSequence(),
+			Fee:       op.GetFee(),
+			Ahead:     i,
+		}
+		if send, ok := op.Operation.(*SendOperation); ok {
+			p.Recipient = send.Recipient()
+			p.Amount = send.Amount
+		}
+		if info := q.pending[op.Signature]; info != nil {
+			p.Age = now.Sub(info.added)
+		}
+		answer = append(answer, p)
+	}
+	return answer
+}
+
+// After returns whether this operation comes after the one with the given
+// fee and signature, in the order Pending returns them.
+func (p *PendingOperation) After(fee uint64, signature string) bool {
+	if p.Fee != fee {
+		return p.Fee < fee
+	}
+	return p.Signature > signature
+}
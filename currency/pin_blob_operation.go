@@ -0,0 +1,98 @@
+package currency
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/lacker/coinkit/util"
+)
+
+// The biggest blob, in bytes
+const MaxBlobSize = 1024 * 1024
+
+// BlobFeePerKilobyte is the least fee a pin has to pay for each kilobyte of
+// the blob, or part of one, on top of what it takes to get into a block.
+const BlobFeePerKilobyte = 100
+
+// A PinBlobOperation asks nodes with a database to store a blob, which is too
+// big for a document. Only the blob's hash goes on chain. The blob itself is
+// uploaded to nodes after the pin is in a block, and they only accept the
+// blob that has this hash.
+// Nodes keep a blob as long as some account pins it. Documents refer to
+// blobs by their hash.
+type PinBlobOperation struct {
+	// Who is pinning the blob
+	Signer string
+
+	// The sequence number for this operation
+	Sequence uint32
+
+	// How much the signer is willing to pay. A pin has to pay the storage
+	// fee for its size.
+	Fee uint64
+
+	// The BlobHash of the blob
+	Hash string
+
+	// How big the blob is, in bytes
+	Size int
+
+	// Unpin removes the signer's pin instead of adding one. An unpin doesn't
+	// need a size or a storage fee.
+	Unpin bool
+}
+
+// BlobHash is the hash that blobs are stored and pinned by.
+func BlobHash(blob []byte) string {
+	h := sha512.Sum512_256(blob)
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+// BlobStorageFee is the least fee for pinning a blob of this size.
+func BlobStorageFee(size int) uint64 {
+	return uint64((size+1023)/1024) * BlobFeePerKilobyte
+}
+
+func (op *PinBlobOperation) String() string {
+	action := "pin"
+	if op.Unpin {
+		action = "unpin"
+	}
+	return fmt.Sprintf("%s blob %s by %s, seq %d fee %d",
+		action, util.Shorten(op.Hash), util.Shorten(op.Signer), op.Sequence, op.Fee)
+}
+
+func (op *PinBlobOperation) OperationType() string {
+	return "PinBlob"
+}
+
+func (op *PinBlobOperation) GetSigner() string {
+	return op.Signer
+}
+
+func (op *PinBlobOperation) GetFee() uint64 {
+	return op.Fee
+}
+
+func (op *PinBlobOperation) GetSequence() uint32 {
+	return op.Sequence
+}
+
+func (op *PinBlobOperation) Verify() bool {
+	h, err := base64.RawURLEncoding.DecodeString(op.Hash)
+	if err != nil || len(h) != sha512.Size256 {
+		return false
+	}
+	if op.Unpin {
+		return true
+	}
+	if op.Size < 1 || op.Size > MaxBlobSize {
+		return false
+	}
+	return op.Fee >= BlobStorageFee(op.Size)
+}
+
+func init() {
+	util.RegisterOperationType(&PinBlobOperation{})
+}
@@ -0,0 +1,56 @@
+package currency
+
+import (
+	"testing"
+
+	"github.com/lacker/coinkit/util"
+)
+
+func TestPinBlobOperation(t *testing.T) {
+	alice := util.NewKeyPairFromSecretPhrase("alice")
+	blob := make([]byte, 3000)
+	op := &PinBlobOperation{
+		Signer:   alice.PublicKey().String(),
+		Sequence: 1,
+		Fee:      BlobStorageFee(len(blob)),
+		Hash:     BlobHash(blob),
+		Size:     len(blob),
+	}
+	if op.Fee != 3*BlobFeePerKilobyte {
+		t.Fatalf("3000 bytes should pay for 3 kilobytes, not %d", op.Fee)
+	}
+	if !op.Verify() {
+		t.Fatal("the pin should verify")
+	}
+
+	m := NewAccountMap()
+	if m.Validate(op) {
+		t.Fatal("alice should need an account to pin a blob")
+	}
+	m.SetBalance(alice.PublicKey().String(), 1000)
+	if !m.Process(op) {
+		t.Fatal("the pin should process")
+	}
+	if m.Get(alice.PublicKey().String()).Balance != 700 {
+		t.Fatal("the storage fee was not charged")
+	}
+
+	op.Fee--
+	if op.Verify() {
+		t.Fatal("a pin that doesn't pay the storage fee should not verify")
+	}
+	op.Size = MaxBlobSize + 1
+	op.Fee = BlobStorageFee(op.Size)
+	if op.Verify() {
+		t.Fatal("a pin that is too big should not verify")
+	}
+	op.Unpin = true
+	op.Fee = 0
+	if !op.Verify() {
+		t.Fatal("an unpin doesn't need a size or a storage fee")
+	}
+	op.Hash = "not a hash"
+	if op.Verify() {
+		t.Fatal("a bad hash should not verify")
+	}
+}
@@ -0,0 +1,119 @@
+package currency
+
+import (
+	"fmt"
+
+	"github.com/lacker/coinkit/util"
+)
+
+// A node operator can put some accounts, like a faucet or a system service,
+// in a priority lane. Their operations are always admitted to the queue, as
+// long as they are valid, and they never get pushed out of a full queue.
+// When the node proposes a block, the lane goes first, ahead of higher fees,
+// but it can only take PriorityShare percent of the block.
+//
+// The lane is the node's own policy, not a consensus rule. When consensus
+// combines the proposals of several nodes, every node has to get the same
+// block, so the combined block is ordered by fee like any other.
+
+// DefaultPriorityShare is how much of a block the priority lane can take,
+// as a percentage, when a queue doesn't say.
+const DefaultPriorityShare = 25
+
+// IsPriority returns whether operations signed by signer are in the
+// priority lane.
+func (q *OperationQueue) IsPriority(signer string) bool {
+	return q.Priority[signer]
+}
+
+// priorityLimit is how many operations from the priority lane can go in a
+// block we propose.
+func (q *OperationQueue) priorityLimit() int {
+	share := q.PriorityShare
+	if share <= 0 {
+		share = DefaultPriorityShare
+	}
+	if share > 100 {
+		share = 100
+	}
+	return MaxChunkSize * share / 100
+}
+
+// admits returns whether the queue takes operations of this type from this
+// signer. The priority lane takes every type.
+func (q *OperationQueue) admits(op util.Operation) bool {
+	return q.Admit == nil || q.Admit[op.OperationType()] || q.IsPriority(op.GetSigner())
+}
+
+// evictable returns the operation to drop when the queue is too full, which
+// is the lowest fee operation outside the priority lane. If the whole queue
+// is in the lane, it's the lowest fee one of those.
+func (q *OperationQueue) evictable() *util.SignedOperation {
+	it := q.set.Iterator()
+	if !it.Last() {
+		util.Logger.Fatal("logical failure with treeset")
+	}
+	lowest := it.Value().(*util.SignedOperation)
+	for ok := true; ok; ok = it.Prev() {
+		op := it.Value().(*util.SignedOperation)
+		if !q.IsPriority(op.GetSigner()) {
+			return op
+		}
+	}
+	return lowest
+}
+
+// prioritized reorders operations that are sorted by fee so that the
+// priority lane goes first, up to its limit, and the rest follow by fee.
+func (q *OperationQueue) prioritized(ops []*util.SignedOperation) []*util.SignedOperation {
+	lane := []*util.SignedOperation{}
+	rest := []*util.SignedOperation{}
+	limit := q.priorityLimit()
+	for _, op := range ops {
+		if len(lane) < limit && q.IsPriority(op.GetSigner()) {
+			lane = append(lane, op)
+		} else {
+			rest = append(rest, op)
+		}
+	}
+	return append(lane, rest...)
+}
+
+// BlockStats accounts for what went into a finalized block, and how much of
+// it came from the priority lane.
+type BlockStats struct {
+	Slot int `json:"slot"`
+
+	// How many operations the block has, and the fees they paid
+	Operations int    `json:"operations"`
+	Fees       uint64 `json:"fees"`
+
+	// How many of them were from priority accounts, and the fees those paid
+	Priority     int    `json:"priority"`
+	PriorityFees uint64 `json:"priorityFees"`
+}
+
+func (q *OperationQueue) newBlockStats(slot int, chunk *LedgerChunk) *BlockStats {
+	stats := &BlockStats{Slot: slot}
+	for _, op := range chunk.Operations {
+		stats.Operations++
+		stats.Fees += op.GetFee()
+		if q.IsPriority(op.GetSigner()) {
+			stats.Priority++
+			stats.PriorityFees += op.GetFee()
+		}
+	}
+	return stats
+}
+
+func (s *BlockStats) String() string {
+	return fmt.Sprintf("slot %d had %d operations paying %d in fees, "+
+		"%d of them in the priority lane paying %d",
+		s.Slot, s.Operations, s.Fees, s.Priority, s.PriorityFees)
+}
+
+// LastBlock returns the lane accounting for the last block the queue
+// finalized, or nil if it hasn't finalized one.
+func (q *OperationQueue) LastBlock() *BlockStats {
+	return q.lastBlock
+}
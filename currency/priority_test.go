@@ -0,0 +1,118 @@
+package currency
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lacker/coinkit/util"
+)
+
+// priorityQueue makes a full queue where the senders of the 30 cheapest
+// operations are in the priority lane.
+func priorityQueue() *OperationQueue {
+	q := NewOperationQueue(util.NewKeyPair().PublicKey())
+	q.Priority = make(map[string]bool)
+	for i := 1; i <= 30; i++ {
+		q.Priority[makeTestSendOperation(i).GetSigner()] = true
+	}
+	for i := 1; i <= QueueLimit+10; i++ {
+		op := makeTestSendOperation(i)
+		tr := op.Operation.(*SendOperation)
+		q.accounts.SetBalance(tr.Signer, 10*tr.Amount)
+		q.Add(op)
+	}
+	return q
+}
+
+func TestPriorityNeverEvicted(t *testing.T) {
+	q := priorityQueue()
+	if q.Size() != QueueLimit {
+		t.Fatalf("q.Size() was %d", q.Size())
+	}
+	for i := 1; i <= 30; i++ {
+		if !q.Contains(makeTestSendOperation(i)) {
+			t.Fatalf("priority operation %d was evicted", i)
+		}
+	}
+	if q.Contains(makeTestSendOperation(31)) {
+		t.Fatalf("the cheapest operation outside the lane should have been evicted")
+	}
+	stats := q.QueueStats(time.Now())
+	if stats.PriorityPending != 30 {
+		t.Fatalf("expected 30 in the priority lane but got %d", stats.PriorityPending)
+	}
+}
+
+func TestPriorityGoesFirst(t *testing.T) {
+	q := priorityQueue()
+	v, ok := q.SuggestValue()
+	if !ok {
+		t.Fatal("expected a suggestion")
+	}
+	chunk := q.chunks[v]
+	limit := MaxChunkSize * DefaultPriorityShare / 100
+	for i, op := range chunk.Operations {
+		if (i < limit) != q.IsPriority(op.GetSigner()) {
+			t.Fatalf("operation %d had the wrong lane", i)
+		}
+	}
+	// Inside the lane it's still by fee
+	if chunk.Operations[0].GetFee() != 30 {
+		t.Fatalf("the lane should start with the highest fee, got %d",
+			chunk.Operations[0].GetFee())
+	}
+	if chunk.Operations[limit].GetFee() != QueueLimit+10 {
+		t.Fatalf("the rest should start with the highest fee, got %d",
+			chunk.Operations[limit].GetFee())
+	}
+
+	q.Finalize(v)
+	stats := q.LastBlock()
+	if stats == nil || stats.Priority != limit || stats.Operations != len(chunk.Operations) {
+		t.Fatalf("bad block stats: %s", stats)
+	}
+	var fees uint64
+	for i := 30 - limit + 1; i <= 30; i++ {
+		fees += uint64(i)
+	}
+	if stats.PriorityFees != fees {
+		t.Fatalf("expected %d priority fees but got %d", fees, stats.PriorityFees)
+	}
+	if q.QueueStats(time.Now()).LastBlock != stats {
+		t.Fatal("the queue stats should have the last block")
+	}
+}
+
+func TestPriorityShare(t *testing.T) {
+	q := NewOperationQueue(util.NewKeyPair().PublicKey())
+	if q.priorityLimit() != MaxChunkSize*DefaultPriorityShare/100 {
+		t.Fatalf("bad default limit %d", q.priorityLimit())
+	}
+	q.PriorityShare = 10
+	if q.priorityLimit() != MaxChunkSize/10 {
+		t.Fatalf("bad limit %d", q.priorityLimit())
+	}
+	q.PriorityShare = 500
+	if q.priorityLimit() != MaxChunkSize {
+		t.Fatalf("the lane can't take more than the whole block, got %d", q.priorityLimit())
+	}
+}
+
+func TestPriorityBypassesPolicy(t *testing.T) {
+	q := NewOperationQueue(util.NewKeyPair().PublicKey())
+	q.Admit = map[string]bool{"Validator": true}
+	q.Allow = map[string]bool{}
+	op := makeTestSendOperation(1)
+	tr := op.Operation.(*SendOperation)
+	q.accounts.SetBalance(tr.Signer, 10*tr.Amount)
+	if q.Add(op) {
+		t.Fatal("the send should not be admitted yet")
+	}
+	q.Priority = map[string]bool{tr.Signer: true}
+	if failed := failedChecks(q.CheckAdmission(op.Operation)); len(failed) != 0 {
+		t.Fatalf("failed checks: %v", failed)
+	}
+	if !q.Add(op) {
+		t.Fatal("the priority lane should be admitted")
+	}
+}
@@ -0,0 +1,66 @@
+package currency
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lacker/coinkit/util"
+)
+
+// QueueStats describes the operations waiting in an OperationQueue.
+type QueueStats struct {
+	// How many operations are pending
+	Pending int `json:"pending"`
+
+	// How many different accounts signed the pending operations
+	Senders int `json:"senders"`
+
+	// The distribution of fees among pending operations.
+	// These are all zero when nothing is pending.
+	MinFee    uint64 `json:"minFee"`
+	MedianFee uint64 `json:"medianFee"`
+	MaxFee    uint64 `json:"maxFee"`
+
+	// How long the operation that has been pending the longest has waited
+	OldestAge time.Duration `json:"oldestAge"`
+
+	// The total size of the pending operations, encoded as JSON
+	Bytes int `json:"bytes"`
+
+	// How many pending operations are in the priority lane
+	PriorityPending int `json:"priorityPending,omitempty"`
+
+	// What went into the last block, and how much of it was from the
+	// priority lane. Nil before the first block
+	LastBlock *BlockStats `json:"lastBlock,omitempty"`
+}
+
+func (s *QueueStats) String() string {
+	answer := fmt.Sprintf(
+		"%d pending from %d senders, fees %d/%d/%d (min/median/max), "+
+			"oldest %.1fs, %d bytes",
+		s.Pending, s.Senders, s.MinFee, s.MedianFee, s.MaxFee,
+		s.OldestAge.Seconds(), s.Bytes)
+	if s.PriorityPending > 0 {
+		answer += fmt.Sprintf(", %d in the priority lane", s.PriorityPending)
+	}
+	return answer
+}
+
+// pendingInfo is what the queue keeps track of for each pending operation,
+// beyond the operation itself.
+type pendingInfo struct {
+	// When the operation was added to the queue
+	added time.Time
+
+	size int
+}
+
+func encodedSize(op *util.SignedOperation) int {
+	bytes, err := json.Marshal(op)
+	if err != nil {
+		panic(err)
+	}
+	return len(bytes)
+}
@@ -0,0 +1,23 @@
+package currency
+
+// Nodes keep every revision of a document, so every document an account
+// writes uses up storage for good. To keep one account from filling up every
+// node's disk, an account can only write as many bytes of documents as its
+// quota, which grows with the balance it holds.
+
+// FreeDocumentBytes is the document quota of an account with no balance.
+const FreeDocumentBytes = 16 * 1024
+
+// DocumentBytesPerCoin is how much each whole coin in an account's balance
+// adds to its document quota.
+const DocumentBytesPerCoin = 4 * 1024
+
+// MinDocumentSize is the least an update counts against the quota, so that
+// tiny documents aren't free.
+const MinDocumentSize = 64
+
+// DocumentQuota is how many bytes of documents the account can write in
+// total. An update that would take Storage over the quota is invalid.
+func (a *Account) DocumentQuota() uint64 {
+	return FreeDocumentBytes + a.Balance/OneBillion*DocumentBytesPerCoin
+}
@@ -0,0 +1,57 @@
+package currency
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDocumentQuota(t *testing.T) {
+	m := NewAccountMap()
+	m.SetBalance("alice", 0)
+	update := func(sequence uint32, size int) *UpdateDocumentOperation {
+		return &UpdateDocumentOperation{
+			Signer:   "alice",
+			Sequence: sequence,
+			Id:       uint64(sequence),
+			Data:     map[string]interface{}{"x": strings.Repeat("x", size)},
+		}
+	}
+
+	// Each of these counts for 4008 bytes, so the free quota has room for 4
+	for seq := uint32(1); seq <= 4; seq++ {
+		if !m.Process(update(seq, 4000)) {
+			t.Fatalf("update %d should fit in the free quota", seq)
+		}
+	}
+	if m.Get("alice").Storage != 4*4008 {
+		t.Fatalf("bad storage: %d", m.Get("alice").Storage)
+	}
+	if m.Validate(update(5, 4000)) {
+		t.Fatal("the free quota should be used up")
+	}
+	if update(5, 0).Size() != MinDocumentSize {
+		t.Fatal("small updates should count as MinDocumentSize")
+	}
+	if !m.Validate(update(5, 0)) {
+		t.Fatal("a small update should still fit")
+	}
+
+	m.SetBalance("alice", OneBillion)
+	if !m.Process(update(5, 4000)) {
+		t.Fatal("holding a coin should raise the quota")
+	}
+	if m.Get("alice").Storage != 5*4008 {
+		t.Fatalf("bad storage: %d", m.Get("alice").Storage)
+	}
+}
+
+func TestAccountStorageHash(t *testing.T) {
+	a := Account{Sequence: 1, Balance: 2}
+	b := Account{Sequence: 1, Balance: 2, Storage: 3}
+	if string(a.Bytes()) == string(b.Bytes()) {
+		t.Fatal("storage should change the account hash")
+	}
+	if len(a.Bytes()) != 12 {
+		t.Fatal("accounts without storage should hash the same as before")
+	}
+}
@@ -13,7 +13,8 @@ type SendOperation struct {
 	// The sequence number for this transaction
 	Sequence uint32
 
-	// Who is receiving this money
+	// Who is receiving this money. This can be a deposit account, which
+	// credits the account of its public key.
 	To string
 
 	// The amount of currency to transfer
@@ -46,12 +47,22 @@ func (t *SendOperation) GetSequence() uint32 {
 }
 
 func (t *SendOperation) Verify() bool {
-	if _, err := util.ReadPublicKey(t.To); err != nil {
+	if _, _, _, err := util.ReadAccount(t.To); err != nil {
 		return false
 	}
 	return true
 }
 
+// Recipient returns the public key of the account that gets credited, which
+// is different from To when To is a deposit account.
+func (t *SendOperation) Recipient() string {
+	pk, _, isDeposit, err := util.ReadAccount(t.To)
+	if err != nil || !isDeposit {
+		return t.To
+	}
+	return pk.String()
+}
+
 func makeTestSendOperation(n int) *util.SignedOperation {
 	kp := util.NewKeyPairFromSecretPhrase(fmt.Sprintf("blorp %d", n))
 	dest := util.NewKeyPairFromSecretPhrase("destination")
@@ -1,7 +1,12 @@
 package currency
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"testing"
+
+	"github.com/lacker/coinkit/util"
 )
 
 func TestMakeTestSendOperation(t *testing.T) {
@@ -10,3 +15,28 @@ func TestMakeTestSendOperation(t *testing.T) {
 		t.Fatal("should verify")
 	}
 }
+
+func TestSigningVectors(t *testing.T) {
+	bytes, err := ioutil.ReadFile("../util/testdata/signing.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vectors := struct {
+		Operations       []struct{ Type, Operation, Signature string }
+		LegacyOperations []struct{ Type, Operation, Signature string }
+	}{}
+	if err := json.Unmarshal(bytes, &vectors); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range append(vectors.Operations, vectors.LegacyOperations...) {
+		encoded := fmt.Sprintf(`{"Operation":%s,"Type":%q,"Signature":%q}`,
+			v.Operation, v.Type, v.Signature)
+		op := &util.SignedOperation{}
+		if err := json.Unmarshal([]byte(encoded), op); err != nil {
+			t.Fatalf("could not decode %s: %s", encoded, err)
+		}
+		if !op.Verify() {
+			t.Fatalf("%s should verify", op.Operation)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package currency
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lacker/coinkit/util"
+)
+
+// Limits on account data, to keep account state small
+const (
+	// The most keys an account can have
+	MaxAccountDataKeys = 16
+
+	// The longest a key can be
+	MaxAccountDataKeyLength = 32
+
+	// The most bytes of keys and values an account can have in total
+	MaxAccountDataSize = 1024
+)
+
+// A SetAccountDataOperation attaches metadata to the signer's account, like a
+// display name, a hash of an avatar, or a url to request payments from.
+// Each key in Data is set to its value, and keys with an empty value are
+// removed. Keys that aren't mentioned are left alone.
+type SetAccountDataOperation struct {
+	// Whose account this is
+	Signer string
+
+	// The sequence number for this operation
+	Sequence uint32
+
+	// How much the signer is willing to pay to get this registered
+	Fee uint64
+
+	// The keys to set or remove
+	Data map[string]string
+}
+
+func (op *SetAccountDataOperation) String() string {
+	keys := []string{}
+	for key := range op.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return fmt.Sprintf("set %s on %s, seq %d fee %d",
+		strings.Join(keys, ","), util.Shorten(op.Signer), op.Sequence, op.Fee)
+}
+
+func (op *SetAccountDataOperation) OperationType() string {
+	return "SetAccountData"
+}
+
+func (op *SetAccountDataOperation) GetSigner() string {
+	return op.Signer
+}
+
+func (op *SetAccountDataOperation) GetFee() uint64 {
+	return op.Fee
+}
+
+func (op *SetAccountDataOperation) GetSequence() uint32 {
+	return op.Sequence
+}
+
+// validAccountDataKey returns whether a key is made of letters, digits, and
+// underscores, and isn't too long.
+func validAccountDataKey(key string) bool {
+	if len(key) == 0 || len(key) > MaxAccountDataKeyLength {
+		return false
+	}
+	for _, c := range key {
+		if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') &&
+			!(c >= '0' && c <= '9') && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+func (op *SetAccountDataOperation) Verify() bool {
+	if len(op.Data) == 0 {
+		return false
+	}
+	for key, value := range op.Data {
+		if !validAccountDataKey(key) || len(value) > MaxAccountDataSize {
+			return false
+		}
+	}
+	return true
+}
+
+// apply returns what an account's data is after the operation, or false if
+// it would be over the limits. It returns nil for no data.
+func (op *SetAccountDataOperation) apply(data map[string]string) (map[string]string, bool) {
+	answer := make(map[string]string)
+	for key, value := range data {
+		answer[key] = value
+	}
+	for key, value := range op.Data {
+		if value == "" {
+			delete(answer, key)
+		} else {
+			answer[key] = value
+		}
+	}
+	size := 0
+	for key, value := range answer {
+		size += len(key) + len(value)
+	}
+	if len(answer) > MaxAccountDataKeys || size > MaxAccountDataSize {
+		return nil, false
+	}
+	if len(answer) == 0 {
+		return nil, true
+	}
+	return answer, true
+}
+
+func init() {
+	util.RegisterOperationType(&SetAccountDataOperation{})
+}
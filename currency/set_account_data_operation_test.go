@@ -0,0 +1,90 @@
+package currency
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestSetAccountData(t *testing.T) {
+	m := NewAccountMap()
+	op := &SetAccountDataOperation{
+		Signer:   "alice",
+		Sequence: 1,
+		Fee:      1,
+		Data:     map[string]string{"name": "Alice", "avatar": "abc123"},
+	}
+	if !op.Verify() {
+		t.Fatal("the operation should verify")
+	}
+	if m.Validate(op) {
+		t.Fatal("alice should need an account")
+	}
+	m.SetBalance("alice", 10)
+	if !m.Process(op) {
+		t.Fatal("setting data should work")
+	}
+	alice := m.Get("alice")
+	if alice.Sequence != 1 || alice.Balance != 9 || alice.Data["name"] != "Alice" {
+		t.Fatalf("bad account: %+v", alice)
+	}
+
+	// Data should survive other operations
+	send := &SendOperation{Signer: "alice", Sequence: 2, To: "bob", Amount: 5}
+	if !m.Process(send) || m.Get("alice").Data["avatar"] != "abc123" {
+		t.Fatalf("the send lost the data: %+v", m.Get("alice"))
+	}
+
+	// Empty values remove keys, and other keys are left alone
+	op = &SetAccountDataOperation{
+		Signer:   "alice",
+		Sequence: 3,
+		Data:     map[string]string{"name": ""},
+	}
+	if !m.Process(op) {
+		t.Fatal("removing a key should work")
+	}
+	alice = m.Get("alice")
+	if _, ok := alice.Data["name"]; ok || alice.Data["avatar"] != "abc123" {
+		t.Fatalf("bad data after removing a key: %+v", alice.Data)
+	}
+
+	op = &SetAccountDataOperation{
+		Signer:   "alice",
+		Sequence: 4,
+		Data:     map[string]string{"bio": strings.Repeat("x", MaxAccountDataSize)},
+	}
+	if !op.Verify() || m.Validate(op) {
+		t.Fatal("data over the size limit should be rejected")
+	}
+
+	for _, key := range []string{"", "no spaces", strings.Repeat("k", MaxAccountDataKeyLength+1)} {
+		op.Data = map[string]string{key: "v"}
+		if op.Verify() {
+			t.Fatalf("the key %q should not verify", key)
+		}
+	}
+}
+
+func TestAccountBytes(t *testing.T) {
+	// Accounts without data hash the way they did before accounts had data
+	a := Account{Sequence: 3, Balance: 100}
+	var old bytes.Buffer
+	binary.Write(&old, binary.LittleEndian, struct {
+		Sequence uint32
+		Balance  uint64
+	}{3, 100})
+	if !bytes.Equal(a.Bytes(), old.Bytes()) {
+		t.Fatalf("%x != %x", a.Bytes(), old.Bytes())
+	}
+
+	b := Account{Sequence: 3, Balance: 100, Data: map[string]string{"name": "bob"}}
+	if bytes.Equal(a.Bytes(), b.Bytes()) {
+		t.Fatal("data should change the bytes")
+	}
+	c := NewAccountMapFromState(map[string]*Account{"bob": &b})
+	if c.CheckEqual("bob", &a) {
+		t.Fatal("accounts with different data should not be equal")
+	}
+}
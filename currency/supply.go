@@ -0,0 +1,78 @@
+package currency
+
+import (
+	"fmt"
+
+	"github.com/lacker/coinkit/util"
+)
+
+// The money supply starts out as whatever SetBalance puts in accounts before
+// the first block. After that, it only changes when blocks burn fees or mint
+// money, so the queue keeps track of it block by block.
+
+// Issuance describes what a finalized block did to the money supply, besides
+// moving money between accounts.
+type Issuance struct {
+	Slot int `json:"slot"`
+
+	// The fees the block paid, and how much of them went to a fee pool or the
+	// validators instead of being burned
+	Fees            uint64 `json:"fees"`
+	FeesDistributed uint64 `json:"feesDistributed"`
+
+	// How much money the block minted
+	Minted uint64 `json:"minted"`
+
+	// The money supply after the block
+	Supply uint64 `json:"supply"`
+}
+
+// Burned returns how much of the fees were burned.
+func (i *Issuance) Burned() uint64 {
+	return i.Fees - i.FeesDistributed
+}
+
+// apply returns the supply after the issuance, and whether it stays in
+// range. When it doesn't, the supply is returned unchanged.
+func (i *Issuance) apply(supply uint64) (uint64, bool) {
+	minted, ok := AddAmounts(supply, i.Minted)
+	if !ok {
+		return supply, false
+	}
+	answer, ok := SubtractAmounts(minted, i.Burned())
+	if !ok {
+		return supply, false
+	}
+	return answer, true
+}
+
+func (i *Issuance) String() string {
+	return fmt.Sprintf("slot %d burned %d and minted %d, leaving a supply of %d",
+		i.Slot, i.Burned(), i.Minted, i.Supply)
+}
+
+// issuance returns what processing some operations as the next chunk does to
+// the money supply. The operations should already be valid.
+func (m *AccountMap) issuance(slot int, ops []*util.SignedOperation) *Issuance {
+	fees, _ := chunkFees(ops)
+	answer := &Issuance{Slot: slot, Fees: fees}
+	shares, _ := m.feeShares(ops)
+	for _, amount := range shares {
+		answer.FeesDistributed += amount
+	}
+	for _, amount := range m.emissionShares() {
+		answer.Minted += amount
+	}
+	return answer
+}
+
+// Supply returns the money supply as of the last finalized block.
+func (q *OperationQueue) Supply() uint64 {
+	return q.supply
+}
+
+// LastIssuance returns what the last block the queue finalized did to the
+// money supply, or nil if it hasn't finalized one.
+func (q *OperationQueue) LastIssuance() *Issuance {
+	return q.lastIssuance
+}
@@ -14,6 +14,15 @@ import (
 // used not just to inform the network you would like to make a transaction,
 // but also for nodes to share a set of known transaction messages.
 
+// MaxOperationsPerMessage is the most operations a transaction message can
+// have. Nodes share their whole queue, so this is the queue limit.
+const MaxOperationsPerMessage = QueueLimit
+
+// MaxChunksPerMessage is the most chunks a transaction message can have.
+// Nodes share the chunks nominated for the current slot, which is at most one
+// per validator.
+const MaxChunksPerMessage = consensus.MaxQuorumSliceSize
+
 type TransactionMessage struct {
 	// Should be sorted and non-nil
 	// Only contains operations that were not previously sent
@@ -31,6 +40,13 @@ func (m *TransactionMessage) MessageType() string {
 	return "Operation"
 }
 
+func (m *TransactionMessage) CheckLimits() error {
+	if err := util.CheckLimit(m, "operations", len(m.Operations), MaxOperationsPerMessage); err != nil {
+		return err
+	}
+	return util.CheckLimit(m, "chunks", len(m.Chunks), MaxChunksPerMessage)
+}
+
 func (m *TransactionMessage) String() string {
 	cnames := []string{}
 	for name, _ := range m.Chunks {
@@ -39,3 +39,29 @@ func TestTransactionMessages(t *testing.T) {
 	}
 
 }
+
+func TestTransactionMessageLimits(t *testing.T) {
+	kp := util.NewKeyPairFromSecretPhrase("key pair 1")
+	op := util.NewSignedOperation(&SendOperation{
+		Sequence: 1,
+		Amount:   100,
+		Fee:      2,
+		Signer:   kp.PublicKey().String(),
+		To:       kp.PublicKey().String(),
+	}, kp)
+	ops := []*util.SignedOperation{}
+	for i := 0; i < MaxOperationsPerMessage; i++ {
+		ops = append(ops, op)
+	}
+	message := NewTransactionMessage(ops...)
+	if _, err := util.DecodeMessage(util.EncodeMessage(message)); err != nil {
+		t.Fatalf("a message at the limit should decode: %s", err)
+	}
+
+	message.Operations = append(message.Operations, op)
+	_, err := util.DecodeMessage(util.EncodeMessage(message))
+	limit, ok := err.(*util.LimitError)
+	if !ok || limit.Type != "Operation" || limit.Size != MaxOperationsPerMessage+1 {
+		t.Fatalf("expected a limit error but got: %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package currency
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/lacker/coinkit/util"
+)
+
+// Document ids from 1 to MaxDocumentId can be written by operations. Larger
+// ids are saved for documents that nodes derive from the chain, like messages.
+const MaxDocumentId = uint64(1)<<48 - 1
+
+// The most bytes of JSON a document written by an operation can have
+const MaxDocumentSize = 4096
+
+// The most fields of a document that can be checked as references
+const MaxDocumentReferences = 16
+
+// An UpdateDocumentOperation writes a document in the document store.
+// The first account to write a document id owns it, and after that only the
+// owner can update it. Updates by anyone else are skipped, so they still use
+// up a sequence number and pay the fee.
+// An update also says which revision of the document it expects to replace.
+// If someone else's update got there first, the revision has moved on, and
+// this update is skipped too, rather than silently undoing the other one.
+// Each update replaces the whole document. Nodes with a database keep the
+// earlier revisions, so that there is a history of who changed what.
+type UpdateDocumentOperation struct {
+	// Who is writing the document
+	Signer string
+
+	// The sequence number for this operation
+	Sequence uint32
+
+	// How much the signer is willing to pay to get this registered
+	Fee uint64
+
+	// Which document to write
+	Id uint64
+
+	// The current revision of the document, as far as the signer knows.
+	// It is zero to create a new document.
+	Revision int
+
+	// The new contents of the document. "id", "owner", and "expiresAtSlot"
+	// are set by the document store, so they can't be in here.
+	Data map[string]interface{}
+
+	// The slot the document expires at, or zero for a document that doesn't
+	// expire. An update whose expiry has already passed is skipped.
+	ExpiresAtSlot int
+
+	// Fields of Data whose values are the ids of other documents. The update
+	// is skipped unless all of those documents exist. A document can refer to
+	// other documents without listing the fields here, it just isn't checked.
+	References []string
+}
+
+func (op *UpdateDocumentOperation) String() string {
+	return fmt.Sprintf("update document %d from revision %d by %s, seq %d fee %d",
+		op.Id, op.Revision, util.Shorten(op.Signer), op.Sequence, op.Fee)
+}
+
+func (op *UpdateDocumentOperation) OperationType() string {
+	return "UpdateDocument"
+}
+
+func (op *UpdateDocumentOperation) GetSigner() string {
+	return op.Signer
+}
+
+func (op *UpdateDocumentOperation) GetFee() uint64 {
+	return op.Fee
+}
+
+func (op *UpdateDocumentOperation) GetSequence() uint32 {
+	return op.Sequence
+}
+
+func (op *UpdateDocumentOperation) Verify() bool {
+	if op.Id == 0 || op.Id > MaxDocumentId || op.Revision < 0 || op.ExpiresAtSlot < 0 {
+		return false
+	}
+	for _, key := range []string{"id", "owner", "expiresAtSlot"} {
+		if _, ok := op.Data[key]; ok {
+			return false
+		}
+	}
+	if len(op.References) > MaxDocumentReferences {
+		return false
+	}
+	for _, field := range op.References {
+		if _, ok := DocumentReference(op.Data[field]); !ok {
+			return false
+		}
+	}
+	bytes, err := json.Marshal(op.Data)
+	if err != nil {
+		return false
+	}
+	return len(bytes) <= MaxDocumentSize
+}
+
+// Size is how many bytes the update counts against the signer's document
+// quota: the size of its data as JSON, but at least MinDocumentSize.
+// It is zero for data that can't be encoded, which doesn't verify anyway.
+func (op *UpdateDocumentOperation) Size() uint64 {
+	bytes, err := json.Marshal(op.Data)
+	if err != nil {
+		return 0
+	}
+	if len(bytes) < MinDocumentSize {
+		return MinDocumentSize
+	}
+	return uint64(len(bytes))
+}
+
+// DocumentReference returns the document id that a field value refers to.
+// Ids are positive whole numbers. Once a document has been through JSON,
+// they are float64, so ids above 2^53 can't be referred to.
+func DocumentReference(value interface{}) (uint64, bool) {
+	switch v := value.(type) {
+	case float64:
+		if v < 1 || v > 1<<53 || v != float64(uint64(v)) {
+			return 0, false
+		}
+		return uint64(v), true
+	case uint64:
+		return v, v > 0 && v <= 1<<53
+	case int:
+		return uint64(v), v > 0 && v <= 1<<53
+	}
+	return 0, false
+}
+
+func init() {
+	util.RegisterOperationType(&UpdateDocumentOperation{})
+}
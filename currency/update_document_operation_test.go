@@ -0,0 +1,78 @@
+package currency
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lacker/coinkit/util"
+)
+
+func TestUpdateDocumentOperation(t *testing.T) {
+	alice := util.NewKeyPairFromSecretPhrase("alice")
+	op := &UpdateDocumentOperation{
+		Signer:   alice.PublicKey().String(),
+		Sequence: 1,
+		Fee:      2,
+		Id:       7,
+		Data:     map[string]interface{}{"title": "hello"},
+	}
+	if !op.Verify() {
+		t.Fatal("the update should verify")
+	}
+
+	m := NewAccountMap()
+	if m.Validate(op) {
+		t.Fatal("alice should need an account to update a document")
+	}
+	m.SetBalance(alice.PublicKey().String(), 10)
+	if !m.Process(op) {
+		t.Fatal("the update should process")
+	}
+	account := m.Get(alice.PublicKey().String())
+	if account.Sequence != 1 || account.Balance != 8 {
+		t.Fatalf("the fee was not charged: %+v", account)
+	}
+
+	op.Id = MaxDocumentId + 1
+	if op.Verify() {
+		t.Fatal("ids above MaxDocumentId should not verify")
+	}
+	op.Id = 7
+	op.Revision = -1
+	if op.Verify() {
+		t.Fatal("negative revisions should not verify")
+	}
+	op.Revision = 0
+	op.Data["owner"] = "bob"
+	if op.Verify() {
+		t.Fatal("the owner should not be settable")
+	}
+	delete(op.Data, "owner")
+	op.Data["expiresAtSlot"] = 10
+	if op.Verify() {
+		t.Fatal("the expiry should only be set with ExpiresAtSlot")
+	}
+	op.Data = map[string]interface{}{"title": strings.Repeat("x", MaxDocumentSize)}
+	if op.Verify() {
+		t.Fatal("big documents should not verify")
+	}
+
+	op.Data = map[string]interface{}{"author": float64(3), "title": "hi"}
+	op.References = []string{"author"}
+	if !op.Verify() {
+		t.Fatal("a numeric reference should verify")
+	}
+	op.References = []string{"title"}
+	if op.Verify() {
+		t.Fatal("a string is not a reference")
+	}
+	op.References = []string{"editor"}
+	if op.Verify() {
+		t.Fatal("a missing field is not a reference")
+	}
+	for _, value := range []interface{}{float64(0), float64(1.5), float64(-2)} {
+		if _, ok := DocumentReference(value); ok {
+			t.Fatalf("%v should not be a reference", value)
+		}
+	}
+}
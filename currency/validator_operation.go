@@ -0,0 +1,105 @@
+package currency
+
+import (
+	"fmt"
+
+	"github.com/lacker/coinkit/util"
+)
+
+const (
+	ValidatorAdd     = "add"
+	ValidatorRemove  = "remove"
+	ValidatorReplace = "replace"
+)
+
+// A ValidatorOperation is one validator's vote to change the validator set.
+// Once votes for the same change from enough current validators to meet the
+// quorum threshold are finalized, every node switches to the new validator
+// set at the activation slot, or in the slot after the last vote if that is
+// later.
+// Votes from accounts that aren't current validators are ignored.
+type ValidatorOperation struct {
+	// The validator voting for this change
+	Signer string
+
+	// The sequence number for this operation
+	Sequence uint32
+
+	// How much the signer is willing to pay to get this vote registered
+	Fee uint64
+
+	// ValidatorAdd, ValidatorRemove, or ValidatorReplace
+	Action string
+
+	// The public key of the validator to add or remove
+	Validator string
+
+	// For ValidatorReplace, the public key of the validator that takes the
+	// place of Validator
+	Replacement string `json:",omitempty"`
+
+	// The quorum threshold for the new validator set
+	Threshold int
+
+	// The first slot that should use the new validator set
+	Activation int
+}
+
+func (op *ValidatorOperation) String() string {
+	validator := util.Shorten(op.Validator)
+	if op.Action == ValidatorReplace {
+		validator += " with " + util.Shorten(op.Replacement)
+	}
+	return fmt.Sprintf("%s validator %s, threshold %d at slot %d, by %s seq %d fee %d",
+		op.Action, validator, op.Threshold, op.Activation,
+		util.Shorten(op.Signer), op.Sequence, op.Fee)
+}
+
+func (op *ValidatorOperation) OperationType() string {
+	return "Validator"
+}
+
+func (op *ValidatorOperation) GetSigner() string {
+	return op.Signer
+}
+
+func (op *ValidatorOperation) GetFee() uint64 {
+	return op.Fee
+}
+
+func (op *ValidatorOperation) GetSequence() uint32 {
+	return op.Sequence
+}
+
+func (op *ValidatorOperation) Verify() bool {
+	switch op.Action {
+	case ValidatorAdd, ValidatorRemove:
+		if op.Replacement != "" {
+			return false
+		}
+	case ValidatorReplace:
+		if _, err := util.ReadPublicKey(op.Replacement); err != nil {
+			return false
+		}
+		if op.Replacement == op.Validator {
+			return false
+		}
+	default:
+		return false
+	}
+	if _, err := util.ReadPublicKey(op.Validator); err != nil {
+		return false
+	}
+	return op.Threshold >= 1 && op.Activation >= 1
+}
+
+// proposal identifies the change being voted for, so that votes for the same
+// change can be counted together.
+func (op *ValidatorOperation) proposal() string {
+	return fmt.Sprintf("%s %s %s %d %d",
+		op.Action, op.Validator, op.Replacement, op.Threshold, op.Activation)
+}
+
+func init() {
+	util.RegisterOperationType(&ValidatorOperation{})
+}
@@ -0,0 +1,155 @@
+package currency
+
+import (
+	"sort"
+
+	"github.com/lacker/coinkit/consensus"
+	"github.com/lacker/coinkit/util"
+)
+
+// ValidatorSet keeps track of which validators are in the quorum slice, and of
+// the votes to change that.
+// Everything here is derived from finalized chunks, so every node that has
+// finalized the same chunks agrees on it.
+type ValidatorSet struct {
+	// The quorum slice for the slot being worked on
+	current consensus.QuorumSlice
+
+	// Votes for changes that haven't gotten enough votes yet.
+	// Keyed by proposal, then by voter.
+	votes map[string]map[string]bool
+
+	// Changes that got enough votes but haven't taken effect yet
+	scheduled []*scheduledChange
+}
+
+type scheduledChange struct {
+	slot int
+	op   *ValidatorOperation
+}
+
+func NewValidatorSet(qs consensus.QuorumSlice) *ValidatorSet {
+	return &ValidatorSet{
+		current: qs,
+		votes:   make(map[string]map[string]bool),
+	}
+}
+
+// Copy returns a copy that changes separately from this one.
+func (vs *ValidatorSet) Copy() *ValidatorSet {
+	votes := make(map[string]map[string]bool)
+	for key, voters := range vs.votes {
+		votes[key] = make(map[string]bool)
+		for voter, _ := range voters {
+			votes[key][voter] = true
+		}
+	}
+	return &ValidatorSet{
+		current:   vs.current,
+		votes:     votes,
+		scheduled: append([]*scheduledChange{}, vs.scheduled...),
+	}
+}
+
+// QuorumSlice returns the quorum slice for the slot being worked on.
+func (vs *ValidatorSet) QuorumSlice() consensus.QuorumSlice {
+	return vs.current
+}
+
+func (vs *ValidatorSet) IsValidator(key string) bool {
+	for _, member := range vs.current.Members {
+		if member == key {
+			return true
+		}
+	}
+	return false
+}
+
+// ProcessChunk counts the votes in a chunk that was finalized for slot.
+// Changes with enough votes are scheduled for their activation slot, or for
+// the next slot if the activation slot has already started.
+func (vs *ValidatorSet) ProcessChunk(slot int, chunk *LedgerChunk) {
+	for _, signed := range chunk.Operations {
+		op, ok := signed.Operation.(*ValidatorOperation)
+		if !ok || !vs.IsValidator(op.Signer) {
+			continue
+		}
+		key := op.proposal()
+		if vs.votes[key] == nil {
+			vs.votes[key] = make(map[string]bool)
+		}
+		vs.votes[key][op.Signer] = true
+		voters := []string{}
+		for voter, _ := range vs.votes[key] {
+			voters = append(voters, voter)
+		}
+		if !vs.current.SatisfiedWith(voters) {
+			continue
+		}
+		delete(vs.votes, key)
+		activation := op.Activation
+		if activation <= slot {
+			activation = slot + 1
+		}
+		vs.scheduled = append(vs.scheduled, &scheduledChange{
+			slot: activation,
+			op:   op,
+		})
+	}
+}
+
+// Advance applies the changes that take effect by the given slot, in the
+// order they got enough votes. It returns whether the quorum slice changed.
+func (vs *ValidatorSet) Advance(slot int) bool {
+	changed := false
+	remaining := []*scheduledChange{}
+	for _, change := range vs.scheduled {
+		if change.slot > slot {
+			remaining = append(remaining, change)
+			continue
+		}
+		if vs.apply(change.op) {
+			changed = true
+		}
+	}
+	vs.scheduled = remaining
+	if changed {
+		// Votes were counted against the old validators, so they don't apply
+		// to the new ones
+		vs.votes = make(map[string]map[string]bool)
+	}
+	return changed
+}
+
+// apply returns false if the change would leave an unusable quorum slice.
+func (vs *ValidatorSet) apply(op *ValidatorOperation) bool {
+	members := []string{}
+	for _, member := range vs.current.Members {
+		if member != op.Validator {
+			members = append(members, member)
+		}
+	}
+	switch op.Action {
+	case ValidatorAdd:
+		members = append(members, op.Validator)
+	case ValidatorReplace:
+		if len(members) == len(vs.current.Members) {
+			util.Logger.Printf("ignoring validator change %s: nothing to replace", op)
+			return false
+		}
+		for _, member := range members {
+			if member == op.Replacement {
+				util.Logger.Printf("ignoring validator change %s: already a validator", op)
+				return false
+			}
+		}
+		members = append(members, op.Replacement)
+	}
+	if op.Threshold > len(members) {
+		util.Logger.Printf("ignoring validator change %s: the threshold is too high", op)
+		return false
+	}
+	sort.Strings(members)
+	vs.current = consensus.MakeQuorumSlice(members, op.Threshold)
+	return true
+}
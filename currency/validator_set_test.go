@@ -0,0 +1,118 @@
+package currency
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/lacker/coinkit/consensus"
+	"github.com/lacker/coinkit/util"
+)
+
+func makeValidatorVote(voter string, validator string, activation int) *util.SignedOperation {
+	kp := util.NewKeyPairFromSecretPhrase(voter)
+	op := &ValidatorOperation{
+		Signer:     kp.PublicKey().String(),
+		Sequence:   1,
+		Action:     ValidatorAdd,
+		Validator:  validator,
+		Threshold:  4,
+		Activation: activation,
+	}
+	return util.NewSignedOperation(op, kp)
+}
+
+func TestValidatorSet(t *testing.T) {
+	qs, _ := consensus.MakeTestQuorumSlice(4)
+	vs := NewValidatorSet(qs)
+	newcomer := util.NewKeyPairFromSecretPhrase("node4").PublicKey().String()
+
+	chunk := NewEmptyChunk()
+	for _, voter := range []string{"node0", "node1", "not a validator"} {
+		chunk.Operations = append(chunk.Operations, makeValidatorVote(voter, newcomer, 10))
+	}
+	vs.ProcessChunk(1, chunk)
+	if vs.Advance(10) {
+		t.Fatal("two validators should not be enough to change the set")
+	}
+
+	chunk = NewEmptyChunk()
+	chunk.Operations = append(chunk.Operations, makeValidatorVote("node2", newcomer, 10))
+	vs.ProcessChunk(2, chunk)
+	if vs.Advance(9) {
+		t.Fatal("the change should wait for its activation slot")
+	}
+	if !vs.Advance(10) {
+		t.Fatal("the change should take effect at its activation slot")
+	}
+	if !vs.IsValidator(newcomer) || vs.QuorumSlice().Threshold != 4 {
+		t.Fatalf("bad quorum slice: %+v", vs.QuorumSlice())
+	}
+}
+
+func TestLateValidatorChange(t *testing.T) {
+	qs, _ := consensus.MakeTestQuorumSlice(4)
+	vs := NewValidatorSet(qs)
+	newcomer := util.NewKeyPairFromSecretPhrase("node4").PublicKey().String()
+	chunk := NewEmptyChunk()
+	for i := 0; i < 3; i++ {
+		vote := makeValidatorVote(fmt.Sprintf("node%d", i), newcomer, 2)
+		chunk.Operations = append(chunk.Operations, vote)
+	}
+
+	// The votes are finalized after the activation slot has passed, so the
+	// change should happen in the next slot
+	vs.ProcessChunk(5, chunk)
+	if vs.Advance(5) {
+		t.Fatal("the change should not apply to a slot that already started")
+	}
+	if !vs.Advance(6) {
+		t.Fatal("the change should apply to the next slot")
+	}
+}
+
+func TestValidatorOperationWithoutAccount(t *testing.T) {
+	m := NewAccountMap()
+	vote := makeValidatorVote("node0", "whoever", 1).Operation
+	if vote.Verify() {
+		t.Fatal("the validator should have to be a valid public key")
+	}
+	vote = makeValidatorVote("node0", util.NewKeyPair().PublicKey().String(), 1).Operation
+	if !m.Process(vote) {
+		t.Fatal("a validator without an account should be able to vote for free")
+	}
+	if m.Get(vote.GetSigner()).Sequence != 1 || m.Validate(vote) {
+		t.Fatal("the vote should not be replayable")
+	}
+}
+
+func TestReplaceValidator(t *testing.T) {
+	qs, pks := consensus.MakeTestQuorumSlice(4)
+	vs := NewValidatorSet(qs)
+	dead := pks[3].String()
+	standby := util.NewKeyPairFromSecretPhrase("standby").PublicKey().String()
+	chunk := NewEmptyChunk()
+	for i := 0; i < 3; i++ {
+		kp := util.NewKeyPairFromSecretPhrase(fmt.Sprintf("node%d", i))
+		op := &ValidatorOperation{
+			Signer:      kp.PublicKey().String(),
+			Sequence:    1,
+			Action:      ValidatorReplace,
+			Validator:   dead,
+			Replacement: standby,
+			Threshold:   3,
+			Activation:  2,
+		}
+		if !op.Verify() {
+			t.Fatalf("%s should verify", op)
+		}
+		chunk.Operations = append(chunk.Operations, util.NewSignedOperation(op, kp))
+	}
+	vs.ProcessChunk(1, chunk)
+	if !vs.Advance(2) {
+		t.Fatal("the replacement should take effect")
+	}
+	if vs.IsValidator(dead) || !vs.IsValidator(standby) ||
+		len(vs.QuorumSlice().Members) != 4 {
+		t.Fatalf("bad quorum slice: %+v", vs.QuorumSlice())
+	}
+}
@@ -0,0 +1,200 @@
+package data
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+
+	"github.com/lacker/coinkit/currency"
+)
+
+// An AccountCache keeps the current state of recently used accounts in memory,
+// so that balance lookups don't need to hit Postgres.
+// It is write-through: the database updates the cache right after each block
+// is committed, so cached data is never stale.
+// A database that another process writes to can't see those commits, so it
+// catches the cache up to newer blocks before reading from it.
+// When the cache is full, the least recently used account is evicted.
+// It is threadsafe.
+type AccountCache struct {
+	mutex    sync.Mutex
+	capacity int
+
+	// The cache reflects every block up to and including this slot.
+	// Zero means it hasn't seen any blocks yet.
+	slot int
+
+	// Maps owner to an element of order
+	entries map[string]*list.Element
+
+	// The front of the list is the most recently used
+	order *list.List
+
+	hits   uint64
+	misses uint64
+}
+
+type accountCacheEntry struct {
+	owner string
+
+	// A nil account means that we know the account does not exist
+	account *currency.Account
+}
+
+// AccountCacheStats is a snapshot of how well the cache is working.
+type AccountCacheStats struct {
+	Hits   uint64
+	Misses uint64
+	Size   int
+}
+
+// HitRate returns the fraction of lookups that were served from the cache.
+func (s AccountCacheStats) HitRate() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+func (s AccountCacheStats) String() string {
+	return fmt.Sprintf("%d accounts cached, %d hits, %d misses, %.1f%% hit rate",
+		s.Size, s.Hits, s.Misses, 100*s.HitRate())
+}
+
+func NewAccountCache(capacity int) *AccountCache {
+	if capacity < 1 {
+		panic("account cache capacity must be positive")
+	}
+	return &AccountCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Get returns the cached state of an account. ok is false if the account
+// is not in the cache. If ok is true and the account is nil, the account is
+// known not to exist.
+func (c *AccountCache) Get(owner string) (account *currency.Account, ok bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, ok := c.entries[owner]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.order.MoveToFront(element)
+	return copyAccount(element.Value.(*accountCacheEntry).account), true
+}
+
+// Set updates the cache with new account data.
+func (c *AccountCache) Set(owner string, account *currency.Account) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.set(owner, account)
+}
+
+// Commit writes through the accounts that blocks up to slot changed, once
+// those blocks are committed.
+func (c *AccountCache) Commit(slot int, accounts map[string]*currency.Account) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for owner, account := range accounts {
+		c.set(owner, account)
+	}
+	c.advance(slot)
+}
+
+// CatchUp is like Commit for blocks that another process committed. It only
+// changes accounts that are already cached, so that catching up doesn't
+// evict the accounts that are actually being looked up.
+func (c *AccountCache) CatchUp(slot int, accounts map[string]*currency.Account) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for owner, account := range accounts {
+		if _, ok := c.entries[owner]; ok {
+			c.set(owner, account)
+		}
+	}
+	c.advance(slot)
+}
+
+// Fill adds account data that was read from the database after a miss.
+// slot is what Slot returned before the read. If the cache has moved on
+// since then, a newer block may have changed the account after the read,
+// so Fill drops the data.
+// If the cache already has data for this account, it was written through
+// by a block commit, so it's at least as new and Fill leaves it alone.
+func (c *AccountCache) Fill(owner string, account *currency.Account, slot int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.slot != slot {
+		return
+	}
+	if _, ok := c.entries[owner]; ok {
+		return
+	}
+	c.set(owner, account)
+}
+
+// Slot returns the last slot whose block the cache reflects.
+func (c *AccountCache) Slot() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.slot
+}
+
+// Reset empties the cache, and records that it reflects every block up to
+// slot, since there is nothing left in it to be stale.
+func (c *AccountCache) Reset(slot int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+	c.advance(slot)
+}
+
+// advance must be called while holding the mutex. The slot never goes
+// backwards.
+func (c *AccountCache) advance(slot int) {
+	if slot > c.slot {
+		c.slot = slot
+	}
+}
+
+// set must be called while holding the mutex.
+func (c *AccountCache) set(owner string, account *currency.Account) {
+	entry := &accountCacheEntry{owner: owner, account: copyAccount(account)}
+	if element, ok := c.entries[owner]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[owner] = c.order.PushFront(entry)
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*accountCacheEntry).owner)
+	}
+}
+
+func (c *AccountCache) Stats() AccountCacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return AccountCacheStats{
+		Hits:   c.hits,
+		Misses: c.misses,
+		Size:   c.order.Len(),
+	}
+}
+
+// Callers get their own copy, so that they can't modify the cached data.
+func copyAccount(account *currency.Account) *currency.Account {
+	if account == nil {
+		return nil
+	}
+	copy := *account
+	return &copy
+}
@@ -0,0 +1,93 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/lacker/coinkit/currency"
+)
+
+func TestAccountCacheEviction(t *testing.T) {
+	c := NewAccountCache(2)
+	c.Set("a", &currency.Account{Balance: 1})
+	c.Set("b", &currency.Account{Balance: 2})
+
+	// Using a makes b the least recently used
+	if _, ok := c.Get("a"); !ok {
+		t.Fatalf("expected a to be cached")
+	}
+	c.Set("c", &currency.Account{Balance: 3})
+	if _, ok := c.Get("b"); ok {
+		t.Fatalf("expected b to be evicted")
+	}
+	if a, ok := c.Get("a"); !ok || a.Balance != 1 {
+		t.Fatalf("expected a to be cached but got %+v", a)
+	}
+
+	stats := c.Stats()
+	if stats.Hits != 2 || stats.Misses != 1 || stats.Size != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if stats.HitRate() < 0.66 || stats.HitRate() > 0.67 {
+		t.Fatalf("unexpected hit rate: %f", stats.HitRate())
+	}
+}
+
+func TestAccountCacheFill(t *testing.T) {
+	c := NewAccountCache(10)
+
+	// A missing account can be cached as nil
+	c.Fill("a", nil, 0)
+	if a, ok := c.Get("a"); !ok || a != nil {
+		t.Fatalf("expected a known-missing account but got %+v, %v", a, ok)
+	}
+
+	// Written-through data should not be overwritten by a fill
+	c.Set("b", &currency.Account{Sequence: 2})
+	c.Fill("b", &currency.Account{Sequence: 1}, 0)
+	if b, _ := c.Get("b"); b.Sequence != 2 {
+		t.Fatalf("fill overwrote newer data: %+v", b)
+	}
+}
+
+func TestAccountCacheCatchUp(t *testing.T) {
+	c := NewAccountCache(10)
+	c.Commit(3, map[string]*currency.Account{"a": {Balance: 1}})
+	if c.Slot() != 3 {
+		t.Fatalf("expected slot 3 but got %d", c.Slot())
+	}
+
+	// Catching up changes cached accounts, but doesn't add new ones
+	c.CatchUp(5, map[string]*currency.Account{
+		"a": {Balance: 2},
+		"b": {Balance: 3},
+	})
+	if a, _ := c.Get("a"); a.Balance != 2 {
+		t.Fatalf("expected a to catch up but got %+v", a)
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Fatalf("expected b not to be cached")
+	}
+
+	// Data read before the cache caught up may be stale
+	c.Fill("b", &currency.Account{Balance: 4}, 3)
+	if _, ok := c.Get("b"); ok {
+		t.Fatalf("expected a stale fill to be dropped")
+	}
+
+	c.Reset(4)
+	if _, ok := c.Get("a"); ok || c.Slot() != 5 {
+		t.Fatalf("expected an empty cache at slot 5 but got slot %d", c.Slot())
+	}
+}
+
+func TestAccountCacheCopies(t *testing.T) {
+	c := NewAccountCache(10)
+	original := &currency.Account{Balance: 5}
+	c.Set("a", original)
+	original.Balance = 6
+	a, _ := c.Get("a")
+	a.Balance = 7
+	if again, _ := c.Get("a"); again.Balance != 5 {
+		t.Fatalf("cached data was modified: %+v", again)
+	}
+}
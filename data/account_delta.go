@@ -0,0 +1,96 @@
+package data
+
+import (
+	"encoding/json"
+
+	"github.com/jmoiron/sqlx/types"
+
+	"github.com/lacker/coinkit/currency"
+)
+
+// An AccountDelta is the state of one account right after a block that
+// changed it. The deltas for a block are saved along with the block, which
+// lets us look up the state of an account as of any slot.
+type AccountDelta struct {
+	Owner    string
+	Slot     int
+	Sequence uint32
+	Balance  uint64
+
+	// The account's data, as a JSON object
+	Data types.JSONText
+
+	// How many bytes of documents the account has written
+	Storage uint64
+
+	// When the account was created and last active. See currency.Account
+	Created    int
+	LastActive int `db:"last_active"`
+}
+
+func (d *AccountDelta) Account() *currency.Account {
+	account := &currency.Account{
+		Sequence:   d.Sequence,
+		Balance:    d.Balance,
+		Storage:    d.Storage,
+		Created:    d.Created,
+		LastActive: d.LastActive,
+	}
+	data := map[string]string{}
+	if err := d.Data.Unmarshal(&data); err != nil {
+		panic(err)
+	}
+	if len(data) > 0 {
+		account.Data = data
+	}
+	return account
+}
+
+// AccountDeltas returns the deltas for every account this block changed.
+func (b *Block) AccountDeltas() []*AccountDelta {
+	answer := []*AccountDelta{}
+	if b.Chunk == nil {
+		return answer
+	}
+	for owner, account := range b.Chunk.State {
+		if account == nil {
+			continue
+		}
+		data := types.JSONText("{}")
+		if len(account.Data) > 0 {
+			bytes, err := json.Marshal(account.Data)
+			if err != nil {
+				panic(err)
+			}
+			data = types.JSONText(bytes)
+		}
+		answer = append(answer, &AccountDelta{
+			Owner:      owner,
+			Slot:       b.Slot,
+			Sequence:   account.Sequence,
+			Balance:    account.Balance,
+			Data:       data,
+			Storage:    account.Storage,
+			Created:    account.Created,
+			LastActive: account.LastActive,
+		})
+	}
+	return answer
+}
+
+// An AccountChange is how a block changed the balance or sequence number of
+// one account.
+type AccountChange struct {
+	Owner string `json:"owner"`
+
+	// The account right before the block. Zero if the block created it.
+	SequenceBefore uint32 `db:"sequence_before" json:"sequenceBefore"`
+	BalanceBefore  uint64 `db:"balance_before" json:"balanceBefore"`
+
+	// The account right after the block
+	SequenceAfter uint32 `db:"sequence_after" json:"sequenceAfter"`
+	BalanceAfter  uint64 `db:"balance_after" json:"balanceAfter"`
+
+	// Whether the account existed before the block
+	Existed bool `json:"existed"`
+}
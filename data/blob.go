@@ -0,0 +1,117 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/lacker/coinkit/currency"
+)
+
+// Blobs are stored by nodes with a database, outside of the chunks. A
+// PinBlobOperation puts the hash of a blob on chain, and then anyone can
+// upload the blob to a node with a BlobMessage. A node only accepts a blob
+// that some account pins, that has the pinned hash, and that is no bigger
+// than a pin paid for. When the last pin on a blob is removed, the node
+// deletes it.
+
+var ErrBlobNotPinned = errors.New("no account pins a blob with this hash")
+
+// A BlobPin is a PinBlobOperation from a block.
+type BlobPin struct {
+	Slot  int
+	Owner string
+	Hash  string
+	Size  int
+	Unpin bool
+}
+
+// BlobPins returns the blob pins and unpins in this block, in order.
+func (b *Block) BlobPins() []*BlobPin {
+	answer := []*BlobPin{}
+	if b.Chunk == nil {
+		return answer
+	}
+	for _, op := range b.Chunk.Operations {
+		pin, ok := op.Operation.(*currency.PinBlobOperation)
+		if !ok {
+			continue
+		}
+		answer = append(answer, &BlobPin{
+			Slot:  b.Slot,
+			Owner: pin.Signer,
+			Hash:  pin.Hash,
+			Size:  pin.Size,
+			Unpin: pin.Unpin,
+		})
+	}
+	return answer
+}
+
+// applyBlobPin adds or removes a pin within a transaction. Removing the last
+// pin on a blob deletes the blob.
+// Pinning a blob twice from the same account keeps the larger size.
+func applyBlobPin(ctx context.Context, tx *sqlx.Tx, pin *BlobPin) error {
+	if !pin.Unpin {
+		_, err := tx.ExecContext(ctx, `
+INSERT INTO blob_pins (hash, owner, slot, size) VALUES ($1, $2, $3, $4)
+ON CONFLICT (hash, owner) DO UPDATE SET size=GREATEST(blob_pins.size, EXCLUDED.size)`,
+			pin.Hash, pin.Owner, pin.Slot, pin.Size)
+		return checkError(ctx, err)
+	}
+	_, err := tx.ExecContext(ctx,
+		"DELETE FROM blob_pins WHERE hash=$1 AND owner=$2", pin.Hash, pin.Owner)
+	if err = checkError(ctx, err); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+DELETE FROM blobs
+WHERE hash=$1 AND NOT EXISTS (SELECT 1 FROM blob_pins WHERE hash=$1)`, pin.Hash)
+	return checkError(ctx, err)
+}
+
+// SaveBlob stores an uploaded blob. It returns an error if no pin allows
+// the blob, if the database is read-only, or if the context is done.
+// Saving a blob that is already stored does nothing.
+func (db *Database) SaveBlob(ctx context.Context, blob []byte) error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	hash := currency.BlobHash(blob)
+	var size sql.NullInt64
+	err := db.postgres.GetContext(ctx, &size,
+		"SELECT MAX(size) FROM blob_pins WHERE hash=$1", hash)
+	if err = checkError(ctx, err); err != nil {
+		return err
+	}
+	if !size.Valid {
+		return ErrBlobNotPinned
+	}
+	if int64(len(blob)) > size.Int64 {
+		return fmt.Errorf("the blob is %d bytes but it is only pinned for %d",
+			len(blob), size.Int64)
+	}
+	_, err = db.postgres.ExecContext(ctx,
+		"INSERT INTO blobs (hash, data) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+		hash, blob)
+	return checkError(ctx, err)
+}
+
+// GetBlob returns the blob with this hash, or nil if this database doesn't
+// have it.
+// It only returns an error if the context is done, or ErrQueryCanceled if
+// the statement timeout cancels the query.
+func (db *Database) GetBlob(ctx context.Context, hash string) ([]byte, error) {
+	blob := []byte{}
+	err := db.postgres.GetContext(ctx, &blob, "SELECT data FROM blobs WHERE hash=$1", hash)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err = checkError(ctx, err); err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
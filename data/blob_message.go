@@ -0,0 +1,58 @@
+package data
+
+import (
+	"fmt"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/util"
+)
+
+// A BlobMessage uploads a blob to a node, or fetches one. Like a
+// DocumentMessage, it is client-server rather than peer-peer.
+// To fetch a blob, the client sends just the Hash. To upload one, it sends
+// the Data too. The server answers with Response set, and with the Data when
+// it has the blob.
+// Clients should check that the data they get has the hash they asked for.
+type BlobMessage struct {
+	Hash string
+	Data []byte
+
+	Response bool
+
+	// Error is set by the server when it could not store or fetch the blob.
+	Error string
+}
+
+// IsQuery returns whether this message is a fetch or an upload, rather than a
+// response.
+func (m *BlobMessage) IsQuery() bool {
+	return !m.Response
+}
+
+func (m *BlobMessage) Slot() int {
+	return 0
+}
+
+func (m *BlobMessage) MessageType() string {
+	return "L"
+}
+
+func (m *BlobMessage) String() string {
+	answer := fmt.Sprintf("blob %s", util.Shorten(m.Hash))
+	if m.Data != nil {
+		answer = fmt.Sprintf("%s size=%d", answer, len(m.Data))
+	}
+	if m.Error != "" {
+		answer = fmt.Sprintf("%s error=%q", answer, m.Error)
+	}
+	return answer
+}
+
+// CheckLimits keeps a blob message from carrying more than a blob.
+func (m *BlobMessage) CheckLimits() error {
+	return util.CheckLimit(m, "data", len(m.Data), currency.MaxBlobSize)
+}
+
+func init() {
+	util.RegisterMessageType(&BlobMessage{})
+}
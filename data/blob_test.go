@@ -0,0 +1,62 @@
+package data
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/util"
+)
+
+func TestBlobs(t *testing.T) {
+	DropTestData(0)
+	db := NewTestDatabase(0)
+	ctx := context.Background()
+	blob := []byte("a picture of a cat")
+	if err := db.SaveBlob(ctx, blob); err != ErrBlobNotPinned {
+		t.Fatalf("an unpinned blob should not be saved: %v", err)
+	}
+
+	kp := util.NewKeyPairFromSecretPhrase("alice")
+	pin := func(sequence uint32, size int, unpin bool) *util.SignedOperation {
+		return util.NewSignedOperation(&currency.PinBlobOperation{
+			Signer:   kp.PublicKey().String(),
+			Sequence: sequence,
+			Fee:      currency.BlobStorageFee(size),
+			Hash:     currency.BlobHash(blob),
+			Size:     size,
+			Unpin:    unpin,
+		}, kp)
+	}
+	chunk := currency.NewEmptyChunk()
+	chunk.Operations = []*util.SignedOperation{pin(1, 5, false)}
+	if err := db.InsertBlock(ctx, &Block{Slot: 1, Chunk: chunk}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveBlob(ctx, blob); err == nil {
+		t.Fatal("a blob bigger than its pin should not be saved")
+	}
+
+	chunk = currency.NewEmptyChunk()
+	chunk.Operations = []*util.SignedOperation{pin(2, len(blob), false)}
+	if err := db.InsertBlock(ctx, &Block{Slot: 2, Chunk: chunk}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveBlob(ctx, blob); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := db.GetBlob(ctx, currency.BlobHash(blob))
+	if err != nil || string(saved) != string(blob) {
+		t.Fatalf("bad blob: %q %v", saved, err)
+	}
+
+	chunk = currency.NewEmptyChunk()
+	chunk.Operations = []*util.SignedOperation{pin(3, 0, true)}
+	if err := db.InsertBlock(ctx, &Block{Slot: 3, Chunk: chunk}); err != nil {
+		t.Fatal(err)
+	}
+	saved, err = db.GetBlob(ctx, currency.BlobHash(blob))
+	if err != nil || saved != nil {
+		t.Fatalf("the blob should be deleted once it is unpinned: %q %v", saved, err)
+	}
+}
@@ -1,7 +1,11 @@
 package data
 
 import (
+	"crypto/sha512"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"time"
 
 	"github.com/lacker/coinkit/consensus"
 	"github.com/lacker/coinkit/currency"
@@ -18,6 +22,79 @@ type Block struct {
 	// The ballot numbers this node confirmed.
 	C int
 	H int
+
+	// Who took part in deciding this block, as this node saw it. Nil when the
+	// node caught up on the block instead. This isn't stored in the blocks
+	// table, but in the participation table.
+	Participation *consensus.Participation `db:"-" json:",omitempty"`
+
+	// What the block did to the money supply, and when this node finalized
+	// it. Nil and zero when the node doesn't know. Like Participation, these
+	// aren't stored in the blocks table, but they go into the network_stats
+	// table.
+	Issuance    *currency.Issuance `db:"-" json:",omitempty"`
+	FinalizedAt time.Time          `db:"-" json:",omitempty"`
+
+	// The fields below are derived from the others. They are filled in by
+	// InsertBlock, so that queries don't need to decode the chunk. Blocks
+	// saved before these fields existed get them when the database is opened.
+	//
+	// There is no proposer column. A chunk is the combination of every
+	// candidate value that was confirmed nominated, and those can come from
+	// several leaders over several rounds, so no single validator proposed
+	// it. Which validators nominated is in the participation table.
+
+	// How many operations are in the chunk
+	NumOperations int `db:"num_operations"`
+
+	// The sum of the fees for all operations in the chunk
+	TotalFees uint64 `db:"total_fees"`
+
+	// The hash of this block, which covers the previous block's hash.
+	BlockHash string `db:"block_hash"`
+
+	// The hash of the block for the previous slot.
+	// Empty for the first block.
+	PreviousHash string `db:"previous_hash"`
+
+	// The hash of the chunk, which is how the chunk is stored.
+	// Empty for blocks saved before chunks were stored by hash.
+	ChunkHash string `db:"chunk_hash"`
+}
+
+// ComputeHash hashes the slot, the chunk, and the previous block's hash.
+// So the hash of a block commits to the entire chain before it.
+func (b *Block) ComputeHash() string {
+	h := sha512.New512_256()
+	h.Write([]byte(fmt.Sprintf("%d:%s:", b.Slot, b.PreviousHash)))
+	if b.Chunk != nil {
+		h.Write([]byte(b.Chunk.Hash()))
+	}
+	return base64.RawStdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// FillDerivedFields sets all the derived fields, given the hash of the
+// previous block.
+// It returns an error if the fees in the chunk add up to more than fits in
+// a uint64.
+func (b *Block) FillDerivedFields(previousHash string) error {
+	b.NumOperations = 0
+	b.TotalFees = 0
+	b.ChunkHash = ""
+	if b.Chunk != nil {
+		b.ChunkHash = string(b.Chunk.Hash())
+		b.NumOperations = len(b.Chunk.Operations)
+		for _, op := range b.Chunk.Operations {
+			total, ok := currency.AddAmounts(b.TotalFees, op.GetFee())
+			if !ok {
+				return fmt.Errorf("the fees in block %d overflow", b.Slot)
+			}
+			b.TotalFees = total
+		}
+	}
+	b.PreviousHash = previousHash
+	b.BlockHash = b.ComputeHash()
+	return nil
 }
 
 func (b *Block) ExternalizeMessage(d consensus.QuorumSlice) *consensus.ExternalizeMessage {
@@ -0,0 +1,184 @@
+package data
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/util"
+)
+
+// ForBlocksOptions configures how ForBlocksWithOptions streams blocks.
+type ForBlocksOptions struct {
+	// How many blocks to fetch from the database per query
+	BatchSize int
+
+	// How many goroutines decode chunks in parallel
+	Workers int
+
+	// If Progress is not nil, it is called after each batch of blocks has
+	// been processed, with the number of blocks processed so far.
+	Progress func(count int)
+
+	// The first and last slots to stream. Zero From starts at the first
+	// block, and zero To goes through the last one.
+	From int
+	To   int
+}
+
+func DefaultForBlocksOptions() *ForBlocksOptions {
+	return &ForBlocksOptions{
+		BatchSize: 1000,
+		Workers:   runtime.NumCPU(),
+	}
+}
+
+// rawBlock is a block whose chunk has not been decoded yet.
+// Decoding the chunk is the slow part of loading a block, so we fetch rows
+// without decoding and decode them in parallel afterwards.
+type rawBlock struct {
+	Block
+	RawChunk []byte `db:"chunk"`
+}
+
+// A blockBatch is a list of blocks in order, or the error that prevented
+// fetching them.
+type blockBatch struct {
+	blocks []*Block
+	err    error
+}
+
+// fetchRawBlocks returns up to limit blocks with slots after the provided one,
+// in order, without decoding their chunks.
+// It only returns an error if the context is done, or ErrQueryCanceled if
+// the statement timeout cancels the query.
+func (db *Database) fetchRawBlocks(
+	ctx context.Context, after int, limit int) ([]*rawBlock, error) {
+	answer := []*rawBlock{}
+	err := db.postgres.SelectContext(ctx, &answer,
+		blockSelect+"WHERE blocks.slot>$1 ORDER BY blocks.slot LIMIT $2", after, limit)
+	if err = checkError(ctx, err); err != nil {
+		return nil, err
+	}
+	return answer, nil
+}
+
+// decodeBlocks decodes the chunks for a list of blocks, using several
+// goroutines. The output is in the same order as the input.
+// A chunk that cannot be decoded is a fundamental database problem, so
+// decodeBlocks panics.
+func decodeBlocks(raw []*rawBlock, workers int) []*Block {
+	blocks := make([]*Block, len(raw))
+	errs := make([]error, len(raw))
+	next := int64(-1)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= len(raw) {
+					return
+				}
+				b := raw[i].Block
+				b.Chunk = &currency.LedgerChunk{}
+				errs[i] = b.Chunk.Scan(raw[i].RawChunk)
+				blocks[i] = &b
+			}
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			panic(err)
+		}
+	}
+	return blocks
+}
+
+// streamBlocks fetches and decodes batches of blocks, sending them to output
+// in order. While one batch is being processed, the next one is fetched.
+// output is closed when there are no more blocks, or after an error is sent.
+func (db *Database) streamBlocks(
+	ctx context.Context, options *ForBlocksOptions, output chan<- *blockBatch) {
+	defer close(output)
+	after := 0
+	if options.From > 0 {
+		after = options.From - 1
+	}
+	for {
+		limit := options.BatchSize
+		if options.To > 0 {
+			if after >= options.To {
+				return
+			}
+			if options.To-after < limit {
+				limit = options.To - after
+			}
+		}
+		raw, err := db.fetchRawBlocks(ctx, after, limit)
+		batch := &blockBatch{err: err}
+		if err == nil {
+			if len(raw) == 0 {
+				return
+			}
+			batch.blocks = decodeBlocks(raw, options.Workers)
+			after = raw[len(raw)-1].Slot
+		}
+		select {
+		case output <- batch:
+		case <-ctx.Done():
+			return
+		}
+		if err != nil || len(raw) < limit {
+			return
+		}
+	}
+}
+
+// ForBlocksWithOptions is like ForBlocks, but lets the caller tune batching
+// and parallelism, and get progress updates. f is always called from the
+// calling goroutine, on one block at a time, in order.
+// If options is nil, the defaults are used.
+func (db *Database) ForBlocksWithOptions(
+	ctx context.Context, options *ForBlocksOptions, f func(b *Block)) (int, error) {
+	if options == nil {
+		options = DefaultForBlocksOptions()
+	}
+	if options.BatchSize < 1 || options.Workers < 1 {
+		util.Logger.Fatalf("invalid ForBlocks options: %+v", options)
+	}
+
+	// Canceling makes sure the streaming goroutine exits if we stop early
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	batches := make(chan *blockBatch, 1)
+	go db.streamBlocks(ctx, options, batches)
+
+	count := 0
+	first := 1
+	if options.From > 0 {
+		first = options.From
+	}
+	for batch := range batches {
+		if batch.err != nil {
+			return count, batch.err
+		}
+		for _, b := range batch.blocks {
+			if b.Slot != first+count {
+				util.Logger.Fatalf("missing block with slot %d", first+count)
+			}
+			count++
+			f(b)
+		}
+		if options.Progress != nil {
+			options.Progress(count)
+		}
+	}
+
+	// The stream may have stopped because the context finished
+	return count, ctx.Err()
+}
@@ -0,0 +1,27 @@
+package data
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/lacker/coinkit/currency"
+)
+
+func TestDecodeBlocks(t *testing.T) {
+	raw := []*rawBlock{}
+	for i := 1; i <= 20; i++ {
+		chunk := currency.NewEmptyChunk()
+		chunk.State["bob"] = &currency.Account{Sequence: uint32(i)}
+		bytes, err := json.Marshal(chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw = append(raw, &rawBlock{Block: Block{Slot: i}, RawChunk: bytes})
+	}
+	blocks := decodeBlocks(raw, 3)
+	for i, b := range blocks {
+		if b.Slot != i+1 || b.Chunk.State["bob"].Sequence != uint32(i+1) {
+			t.Fatalf("block %d decoded wrong: %+v", i, b)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package data
+
+import (
+	"math"
+	"testing"
+
+	"github.com/lacker/coinkit/consensus"
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/util"
+)
+
+func TestFillDerivedFields(t *testing.T) {
+	kp := util.NewKeyPairFromSecretPhrase("alice")
+	op := &currency.SendOperation{
+		Signer:   kp.PublicKey().String(),
+		Sequence: 1,
+		To:       util.NewKeyPairFromSecretPhrase("bob").PublicKey().String(),
+		Amount:   10,
+		Fee:      3,
+	}
+	chunk := currency.NewEmptyChunk()
+	chunk.Operations = append(chunk.Operations, util.NewSignedOperation(op, kp))
+	b1 := &Block{Slot: 1, Chunk: chunk}
+	if err := b1.FillDerivedFields(""); err != nil {
+		t.Fatal(err)
+	}
+	if b1.NumOperations != 1 || b1.TotalFees != 3 {
+		t.Fatalf("bad derived fields: %+v", b1)
+	}
+
+	b2 := &Block{Slot: 2, Chunk: currency.NewEmptyChunk()}
+	b2.FillDerivedFields(b1.BlockHash)
+	b2copy := &Block{Slot: 2, Chunk: currency.NewEmptyChunk()}
+	b2copy.FillDerivedFields("something else")
+	if b2.BlockHash == b2copy.BlockHash {
+		t.Fatal("the block hash should depend on the previous hash")
+	}
+	if b2.ChunkHash != b2copy.ChunkHash || b2.ChunkHash == b1.ChunkHash ||
+		b1.ChunkHash != string(chunk.Hash()) {
+		t.Fatal("the chunk hash should only depend on the chunk")
+	}
+
+	// The fees are summed with overflow checks
+	rich := util.NewKeyPairFromSecretPhrase("rich")
+	expensive := currency.NewEmptyChunk()
+	for i := 1; i <= 2; i++ {
+		expensive.Operations = append(expensive.Operations,
+			util.NewSignedOperation(&currency.SendOperation{
+				Signer:   rich.PublicKey().String(),
+				Sequence: uint32(i),
+				To:       op.To,
+				Fee:      math.MaxUint64 - 1,
+			}, rich))
+	}
+	if (&Block{Slot: 3, Chunk: expensive}).FillDerivedFields("") == nil {
+		t.Fatal("fees that overflow should be an error")
+	}
+}
+
+func TestParticipationRows(t *testing.T) {
+	b := &Block{Slot: 4}
+	if len(b.ParticipationRows()) != 0 {
+		t.Fatal("a block without participation should have no rows")
+	}
+	b.Participation = &consensus.Participation{
+		Validators: []string{"alice", "bob"},
+		Balloted:   []string{"alice"},
+		Committed:  []string{"alice"},
+		Missed:     []string{"bob"},
+	}
+	rows := b.ParticipationRows()
+	if len(rows) != 2 || rows[0].Validator != "alice" || !rows[0].Committed ||
+		rows[0].Nominated || rows[1].Validator != "bob" || !rows[1].Missed ||
+		rows[1].Slot != 4 {
+		t.Fatalf("bad rows: %+v %+v", rows[0], rows[1])
+	}
+}
+
+func TestBlocksMessage(t *testing.T) {
+	// An empty page is still an answer once it goes over the wire
+	answer := &BlocksMessage{Limit: 3, Blocks: []*Block{}}
+	decoded := util.EncodeThenDecodeMessage(answer).(*BlocksMessage)
+	if decoded.IsQuery() {
+		t.Fatal("an empty page should not look like a query")
+	}
+	if !util.EncodeThenDecodeMessage(&BlocksMessage{Limit: 3}).(*BlocksMessage).IsQuery() {
+		t.Fatal("a query should stay a query")
+	}
+
+	blocks := []*Block{}
+	for i := 0; i <= MaxBlocksPerPage; i++ {
+		blocks = append(blocks, &Block{Slot: i + 1, Chunk: currency.NewEmptyChunk()})
+	}
+	if (&BlocksMessage{Blocks: blocks}).CheckLimits() == nil {
+		t.Fatal("a page should not hold more than MaxBlocksPerPage blocks")
+	}
+}
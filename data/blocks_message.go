@@ -0,0 +1,83 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lacker/coinkit/util"
+)
+
+// A BlocksMessage lists the finalized blocks in a node's database, a page at
+// a time. Like a DocumentMessage, it is client-server rather than peer-peer.
+// The client sends a BlocksMessage with just the query, and the server sends
+// one back with the blocks filled in.
+type BlocksMessage struct {
+	// When Owner is nonempty, this lists the blocks in which that account
+	// changed, most recent first. Otherwise it lists every block, in order.
+	Owner string `json:",omitempty"`
+
+	// Where the page starts. Empty for the first page
+	Cursor Cursor `json:",omitempty"`
+
+	// The most blocks to return, which the server keeps to MaxBlocksPerPage
+	Limit int
+
+	// Blocks is filled in by the server.
+	Blocks []*Block
+
+	// Next is the cursor for the page after this one, or empty when there
+	// are no more blocks.
+	Next Cursor `json:",omitempty"`
+
+	// Error is set by the server when it could not answer the query.
+	Error string `json:",omitempty"`
+}
+
+// The most blocks one BlocksMessage can hold. Each chunk can be up to
+// currency.MaxChunkBytes, so this keeps a page well under util.MaxLineSize.
+const MaxBlocksPerPage = 10
+
+// IsQuery returns whether this message is a query, rather than a response.
+func (m *BlocksMessage) IsQuery() bool {
+	return m.Blocks == nil && m.Error == ""
+}
+
+func (m *BlocksMessage) Slot() int {
+	return 0
+}
+
+func (m *BlocksMessage) MessageType() string {
+	return "G"
+}
+
+func (m *BlocksMessage) String() string {
+	parts := []string{"blocks"}
+	if m.Owner != "" {
+		parts = append(parts, fmt.Sprintf("owner=%s", util.Shorten(m.Owner)))
+	}
+	if m.Cursor != "" {
+		parts = append(parts, fmt.Sprintf("cursor=%s", m.Cursor))
+	}
+	if m.Limit != 0 {
+		parts = append(parts, fmt.Sprintf("limit=%d", m.Limit))
+	}
+	if m.Blocks != nil {
+		parts = append(parts, fmt.Sprintf("blocks=%d", len(m.Blocks)))
+	}
+	if m.Next != "" {
+		parts = append(parts, fmt.Sprintf("next=%s", m.Next))
+	}
+	if m.Error != "" {
+		parts = append(parts, fmt.Sprintf("error=%q", m.Error))
+	}
+	return strings.Join(parts, " ")
+}
+
+// CheckLimits keeps a blocks message to one page.
+func (m *BlocksMessage) CheckLimits() error {
+	return util.CheckLimit(m, "blocks", len(m.Blocks), MaxBlocksPerPage)
+}
+
+func init() {
+	util.RegisterMessageType(&BlocksMessage{})
+}
@@ -22,6 +22,79 @@ type Config struct {
 
 	// The database password
 	Password string
+
+	// Which document fields are indexed for full-text search.
+	// Only string values of these fields are indexed. When this is empty,
+	// there is no search indexing.
+	SearchFields []string
+
+	// The maximum number of open connections in the pool.
+	// Zero means there is no limit.
+	MaxOpenConns int
+
+	// The maximum number of idle connections to keep in the pool.
+	// Zero means to use the database/sql default.
+	MaxIdleConns int
+
+	// Statements that take longer than this many milliseconds are canceled
+	// by Postgres, and return ErrQueryCanceled. Zero means there is no
+	// timeout. Saving blocks is never canceled.
+	StatementTimeoutMillis int
+
+	// Durability controls when finalized blocks are committed, which trades
+	// speed for how much can be lost in an unclean shutdown.
+	// It is one of the Durability constants. Empty means DurabilitySlot.
+	// After an unclean shutdown, a node starts from its last committed block,
+	// and catches up on the slots it lost from its peers, the same way a node
+	// that fell behind does.
+	Durability string
+
+	// With DurabilityBatch, blocks are committed once this many are pending.
+	CommitInterval int
+
+	// How many accounts to keep in the in-memory account cache.
+	// Zero means to use DefaultAccountCacheSize.
+	AccountCacheSize int
+
+	// A read-only database doesn't set up the schema and refuses writes, so
+	// it can point at a read replica.
+	ReadOnly bool
+
+	// How many revisions of each document to keep for GetDocumentHistory.
+	// Older revisions are pruned as new ones are written.
+	// Zero means to keep every revision.
+	DocumentRevisions int
+}
+
+const DefaultAccountCacheSize = 100000
+
+const (
+	// Commit each block as soon as it is finalized.
+	DurabilitySlot = "slot"
+
+	// Commit blocks together, once every CommitInterval slots.
+	// Up to CommitInterval blocks can be lost in an unclean shutdown.
+	DurabilityBatch = "batch"
+
+	// Commit each block as soon as it is finalized, but don't wait for
+	// Postgres to flush the commit to disk. The most recent blocks can be lost
+	// in an unclean shutdown, but the database stays consistent.
+	DurabilityAsync = "async"
+)
+
+// CheckDurability returns an error if the durability settings are invalid.
+func (c *Config) CheckDurability() error {
+	switch c.Durability {
+	case "", DurabilitySlot, DurabilityAsync:
+		return nil
+	case DurabilityBatch:
+		if c.CommitInterval < 1 {
+			return fmt.Errorf("batch durability needs a positive CommitInterval")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown durability: %q", c.Durability)
+	}
 }
 
 func NewTestConfig(i int) *Config {
@@ -33,6 +106,17 @@ func NewTestConfig(i int) *Config {
 	}
 }
 
+// Devnet databases are separate from the test databases, so that running the
+// unit tests doesn't clobber a devnet.
+func NewDevnetConfig(i int) *Config {
+	return &Config{
+		Database: fmt.Sprintf("devnet%d", i),
+		User:     "$USER",
+		Host:     "127.0.0.1",
+		Port:     5432,
+	}
+}
+
 // Prod databases are configured via environment variables.
 // Returns nil if the environment variables are not set.
 func NewProdConfig() *Config {
@@ -0,0 +1,39 @@
+package data
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// A Cursor marks where a page of results ended. Passing it back in to the
+// same query fetches the next page. Clients should treat it as opaque.
+// The empty cursor means to start from the beginning, and a query returns
+// the empty cursor when there are no more results.
+type Cursor string
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// NewCursor makes a cursor for a page that ended on the row with this key.
+// The key is whatever column the query is ordered by, like id or slot.
+func NewCursor(key int64) Cursor {
+	return Cursor(base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("k%d", key))))
+}
+
+// Key returns the key this cursor was made from.
+// ok is false for the empty cursor.
+func (c Cursor) Key() (key int64, ok bool, err error) {
+	if c == "" {
+		return 0, false, nil
+	}
+	bytes, err := base64.RawURLEncoding.DecodeString(string(c))
+	if err != nil {
+		return 0, false, ErrInvalidCursor
+	}
+	var rest string
+	n, _ := fmt.Sscanf(string(bytes), "k%d%s", &key, &rest)
+	if n != 1 {
+		return 0, false, ErrInvalidCursor
+	}
+	return key, true, nil
+}
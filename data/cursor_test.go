@@ -0,0 +1,33 @@
+package data
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	for _, key := range []int64{0, 1, -1, 1234567890123} {
+		c := NewCursor(key)
+		k, ok, err := c.Key()
+		if err != nil || !ok || k != key {
+			t.Fatalf("cursor for %d decoded to %d, %v, %v", key, k, ok, err)
+		}
+	}
+}
+
+func TestEmptyCursor(t *testing.T) {
+	_, ok, err := Cursor("").Key()
+	if ok || err != nil {
+		t.Fatalf("the empty cursor should have no key")
+	}
+}
+
+func TestInvalidCursor(t *testing.T) {
+	trailing := Cursor(base64.RawURLEncoding.EncodeToString([]byte("k5x")))
+	for _, c := range []Cursor{"bogus", "!!!", trailing} {
+		_, _, err := c.Key()
+		if err != ErrInvalidCursor {
+			t.Fatalf("expected %s to be invalid but got: %v", c, err)
+		}
+	}
+}
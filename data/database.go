@@ -1,45 +1,139 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"os/user"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 
+	"github.com/lacker/coinkit/currency"
 	"github.com/lacker/coinkit/util"
 )
 
+var ErrReadOnly = errors.New("this database is read-only")
+
+// ErrQueryCanceled is returned when Postgres cancels a statement for running
+// past the statement timeout. Any method that runs a query can return it,
+// even while its context is still live.
+var ErrQueryCanceled = errors.New("the query took too long")
+
+// The Postgres error code for a canceled statement
+const queryCanceledCode = "57014"
+
 // A Database encapsulates a connection to a Postgres database.
 type Database struct {
 	name     string
 	postgres *sqlx.DB
+
+	// Which document fields get indexed for full-text search
+	searchFields []string
+
+	// The current state of recently used accounts
+	accounts *AccountCache
+
+	// The durability policy, and the blocks saved but not yet committed
+	// when the policy is DurabilityBatch
+	durability     string
+	commitInterval int
+	pendingMutex   sync.Mutex
+	pending        []*Block
+
+	// Read-only databases return ErrReadOnly for any write
+	readOnly bool
+
+	// Whether statements have a timeout
+	statementTimeout bool
+
+	// How many revisions of each document to keep. Zero keeps all of them.
+	documentRevisions int
+
+	// Prepared versions of the statements we run the most, so that Postgres
+	// doesn't need to parse them every time
+	blockInsertStmt         *sqlx.NamedStmt
+	accountDeltaInsertStmt  *sqlx.NamedStmt
+	eventInsertStmt         *sqlx.NamedStmt
+	chunkInsertStmt         *sqlx.Stmt
+	documentInsertStmt      *sqlx.Stmt
+	participationInsertStmt *sqlx.NamedStmt
+	networkStatsInsertStmt  *sqlx.NamedStmt
+
+	// The last network report, cached until the next block
+	report      *NetworkStatsMessage
+	reportMutex sync.Mutex
 }
 
-func NewDatabase(config *Config) *Database {
+// connectionInfo returns the lib/pq connection string for a database on the
+// server a config points at.
+func connectionInfo(config *Config, database string) string {
 	user, err := user.Current()
 	if err != nil {
 		panic(err)
 	}
 	username := strings.Replace(config.User, "$USER", user.Username, 1)
-	info := fmt.Sprintf("host=%s port=%d user=%s dbname=%s sslmode=disable",
-		config.Host, config.Port, username, config.Database)
+	return fmt.Sprintf("host=%s port=%d user=%s dbname=%s sslmode=disable",
+		config.Host, config.Port, username, database)
+}
+
+func NewDatabase(config *Config) *Database {
+	info := connectionInfo(config, config.Database)
+	if err := config.CheckDurability(); err != nil {
+		panic(err)
+	}
+	if config.Durability == DurabilityAsync {
+		// Postgres acknowledges commits before they are flushed to disk
+		info = fmt.Sprintf("%s synchronous_commit=off", info)
+	}
+	if config.ReadOnly {
+		info = fmt.Sprintf("%s default_transaction_read_only=on", info)
+	}
+	if config.StatementTimeoutMillis > 0 {
+		// lib/pq passes unrecognized options along as runtime parameters
+		info = fmt.Sprintf("%s statement_timeout=%d", info, config.StatementTimeoutMillis)
+	}
 	util.Logger.Printf("connecting to postgres with %s", info)
 	if len(config.Password) > 0 {
 		util.Logger.Printf("(password hidden)")
 		info = fmt.Sprintf("%s password=%s", info, config.Password)
 	}
 	postgres := sqlx.MustConnect("postgres", info)
+	postgres.SetMaxOpenConns(config.MaxOpenConns)
+	if config.MaxIdleConns > 0 {
+		postgres.SetMaxIdleConns(config.MaxIdleConns)
+	}
 
+	cacheSize := config.AccountCacheSize
+	if cacheSize == 0 {
+		cacheSize = DefaultAccountCacheSize
+	}
 	db := &Database{
-		postgres: postgres,
-		name:     config.Database,
+		postgres:     postgres,
+		name:         config.Database,
+		searchFields: config.SearchFields,
+		accounts:     NewAccountCache(cacheSize),
+
+		durability:     config.Durability,
+		commitInterval: config.CommitInterval,
+		readOnly:       config.ReadOnly,
+
+		statementTimeout: config.StatementTimeoutMillis > 0,
+
+		documentRevisions: config.DocumentRevisions,
+	}
+	if !db.readOnly {
+		db.initialize()
+		db.prepare()
+		db.backfillBlocks()
+		db.backfillAccountDeltas()
 	}
-	db.initialize()
 	return db
 }
 
@@ -58,13 +152,112 @@ CREATE TABLE IF NOT EXISTS blocks (
 
 CREATE UNIQUE INDEX IF NOT EXISTS block_slot_idx ON blocks (slot);
 
+ALTER TABLE blocks ADD COLUMN IF NOT EXISTS num_operations integer NOT NULL DEFAULT 0;
+ALTER TABLE blocks ADD COLUMN IF NOT EXISTS total_fees bigint NOT NULL DEFAULT 0;
+ALTER TABLE blocks ADD COLUMN IF NOT EXISTS block_hash text NOT NULL DEFAULT '';
+ALTER TABLE blocks ADD COLUMN IF NOT EXISTS previous_hash text NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS block_hash_idx ON blocks (block_hash);
+
 CREATE TABLE IF NOT EXISTS documents (
     id bigint,
     data jsonb NOT NULL
 );
 
+CREATE TABLE IF NOT EXISTS account_deltas (
+    owner text NOT NULL,
+    slot integer NOT NULL,
+    sequence bigint NOT NULL,
+    balance bigint NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS account_delta_owner_slot_idx ON account_deltas (owner, slot);
+
+ALTER TABLE account_deltas ADD COLUMN IF NOT EXISTS data jsonb NOT NULL DEFAULT '{}';
+ALTER TABLE account_deltas ADD COLUMN IF NOT EXISTS storage bigint NOT NULL DEFAULT 0;
+ALTER TABLE account_deltas ADD COLUMN IF NOT EXISTS created integer NOT NULL DEFAULT 0;
+ALTER TABLE account_deltas ADD COLUMN IF NOT EXISTS last_active integer NOT NULL DEFAULT 0;
+
 CREATE UNIQUE INDEX IF NOT EXISTS document_id_idx ON documents (id);
 CREATE INDEX IF NOT EXISTS document_data_idx ON documents USING gin (data jsonb_path_ops);
+
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS search tsvector;
+CREATE INDEX IF NOT EXISTS document_search_idx ON documents USING gin (search);
+
+CREATE TABLE IF NOT EXISTS events (
+    seq bigserial PRIMARY KEY,
+    slot integer NOT NULL,
+    type text NOT NULL,
+    data jsonb NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS event_type_seq_idx ON events (type, seq);
+
+CREATE TABLE IF NOT EXISTS chunks (
+    hash text PRIMARY KEY,
+    chunk json NOT NULL
+);
+
+ALTER TABLE blocks ADD COLUMN IF NOT EXISTS chunk_hash text;
+ALTER TABLE blocks ALTER COLUMN chunk DROP NOT NULL;
+
+CREATE TABLE IF NOT EXISTS participation (
+    slot integer NOT NULL,
+    validator text NOT NULL,
+    nominated boolean NOT NULL,
+    balloted boolean NOT NULL,
+    committed boolean NOT NULL,
+    missed boolean NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS participation_slot_validator_idx ON participation (slot, validator);
+
+CREATE TABLE IF NOT EXISTS forks (
+    seq bigserial PRIMARY KEY,
+    slot integer NOT NULL,
+    data jsonb NOT NULL
+);
+
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS owner text;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS revision integer NOT NULL DEFAULT 1;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS expires_at_slot integer;
+
+CREATE INDEX IF NOT EXISTS document_expires_at_slot_idx ON documents (expires_at_slot);
+
+CREATE TABLE IF NOT EXISTS blob_pins (
+    hash text NOT NULL,
+    owner text NOT NULL,
+    slot integer NOT NULL,
+    size integer NOT NULL,
+    PRIMARY KEY (hash, owner)
+);
+
+CREATE TABLE IF NOT EXISTS blobs (
+    hash text PRIMARY KEY,
+    data bytea NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS document_revisions (
+    id bigint NOT NULL,
+    revision integer NOT NULL,
+    slot integer NOT NULL,
+    updater text NOT NULL,
+    data jsonb NOT NULL,
+    PRIMARY KEY (id, revision)
+);
+
+CREATE TABLE IF NOT EXISTS network_stats (
+    slot integer PRIMARY KEY,
+    finalized_at timestamptz NOT NULL,
+    supply bigint NOT NULL,
+    fees bigint NOT NULL,
+    fees_distributed bigint NOT NULL,
+    minted bigint NOT NULL,
+    operations bigint NOT NULL,
+    accounts integer NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS network_stats_finalized_at_idx ON network_stats (finalized_at);
 `
 
 // initialize makes sure the schemas are set up right and panics if not
@@ -91,9 +284,145 @@ func (db *Database) initialize() {
 	}
 }
 
-func (db *Database) TotalSizeInfo() string {
+// prepare prepares the hot statements. It must be called after initialize,
+// because preparing a statement requires its tables to exist.
+func (db *Database) prepare() {
+	var err error
+	db.blockInsertStmt, err = db.postgres.PrepareNamed(blockInsert)
+	if err != nil {
+		panic(err)
+	}
+	db.accountDeltaInsertStmt, err = db.postgres.PrepareNamed(accountDeltaInsert)
+	if err != nil {
+		panic(err)
+	}
+	db.eventInsertStmt, err = db.postgres.PrepareNamed(eventInsert)
+	if err != nil {
+		panic(err)
+	}
+	db.chunkInsertStmt, err = db.postgres.Preparex(chunkInsert)
+	if err != nil {
+		panic(err)
+	}
+	db.documentInsertStmt, err = db.postgres.Preparex(documentInsert)
+	if err != nil {
+		panic(err)
+	}
+	db.participationInsertStmt, err = db.postgres.PrepareNamed(participationInsert)
+	if err != nil {
+		panic(err)
+	}
+	db.networkStatsInsertStmt, err = db.postgres.PrepareNamed(networkStatsInsert)
+	if err != nil {
+		panic(err)
+	}
+}
+
+const blockBackfill = `
+UPDATE blocks SET num_operations=:num_operations, total_fees=:total_fees,
+    block_hash=:block_hash, previous_hash=:previous_hash
+WHERE slot=:slot
+`
+
+// backfillBlocks fills in the derived fields of blocks that were saved before
+// those fields existed. The hash of every later block covers the hashes of
+// those blocks, so once one block is missing its fields, every block after it
+// is recomputed too. It does nothing when every block has its hash.
+// It panics if there is a fundamental database problem.
+func (db *Database) backfillBlocks() {
+	ctx := context.Background()
+	missing := 0
+	err := db.postgres.GetContext(ctx, &missing,
+		"SELECT COUNT(*) FROM blocks WHERE block_hash=''")
+	if err != nil {
+		panic(err)
+	}
+	if missing == 0 {
+		return
+	}
+	util.Logger.Printf("filling in the derived fields of %d old blocks", missing)
+
+	// The blocks are updated once they have all been read, so the updates
+	// don't compete with the stream for connections
+	changed := []*Block{}
+	previousHash := ""
+	_, err = db.ForBlocks(ctx, func(b *Block) {
+		stored := *b
+		if err := b.FillDerivedFields(previousHash); err != nil {
+			panic(err)
+		}
+		previousHash = b.BlockHash
+		if b.NumOperations != stored.NumOperations || b.TotalFees != stored.TotalFees ||
+			b.BlockHash != stored.BlockHash || b.PreviousHash != stored.PreviousHash {
+			// The chunk isn't needed for the update
+			b.Chunk = nil
+			changed = append(changed, b)
+		}
+	})
+	if err != nil {
+		panic(err)
+	}
+	tx := db.postgres.MustBegin()
+	defer tx.Rollback()
+	for _, b := range changed {
+		if _, err := tx.NamedExecContext(ctx, blockBackfill, b); err != nil {
+			panic(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		panic(err)
+	}
+	util.Logger.Printf("updated %d old blocks", len(changed))
+}
+
+// Every delta is for a slot in which the account changed, so a delta's
+// account was last active in its own slot, and created in the first slot
+// that left it with money.
+const accountDeltaBackfill = `
+UPDATE account_deltas d SET last_active=d.slot, created=COALESCE((
+    SELECT MIN(p.slot) FROM account_deltas p
+    WHERE p.owner=d.owner AND p.slot<=d.slot AND p.balance>0), 0)
+WHERE d.last_active=0
+`
+
+// backfillAccountDeltas fills in when accounts were created and last active
+// for the deltas saved before accounts kept track. It does nothing when every
+// delta has them.
+// It panics if there is a fundamental database problem.
+func (db *Database) backfillAccountDeltas() {
+	result := db.postgres.MustExec(accountDeltaBackfill)
+	if n, err := result.RowsAffected(); err == nil && n > 0 {
+		util.Logger.Printf("filled in the activity of %d old account deltas", n)
+	}
+}
+
+// checkError is used to handle a database error when a context is involved.
+// If the context is done, it returns the context's error, so that the caller
+// can pass it along. If the statement ran past the statement timeout, it
+// returns ErrQueryCanceled. Any other error is a fundamental database
+// problem, so checkError panics.
+func checkError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if e, ok := err.(*pq.Error); ok && e.Code == queryCanceledCode {
+		return ErrQueryCanceled
+	}
+	panic(err)
+}
+
+// Ping checks that postgres can be reached.
+func (db *Database) Ping(ctx context.Context) error {
+	return db.postgres.PingContext(ctx)
+}
+
+func (db *Database) TotalSizeInfo(ctx context.Context) string {
 	var answer string
-	err := db.postgres.Get(
+	err := db.postgres.GetContext(
+		ctx,
 		&answer,
 		"SELECT pg_size_pretty(pg_database_size($1))",
 		db.name)
@@ -103,121 +432,905 @@ func (db *Database) TotalSizeInfo() string {
 	return answer
 }
 
+// Chunks are stored separately from blocks, keyed by their hash, so that
+// identical chunks are only stored once.
+// Blocks saved before that have their chunk inline instead, with no chunk_hash.
 const blockInsert = `
-INSERT INTO blocks (slot, chunk, c, h)
-VALUES (:slot, :chunk, :c, :h)
+INSERT INTO blocks (slot, chunk_hash, c, h, num_operations, total_fees, block_hash, previous_hash)
+VALUES (:slot, :chunk_hash, :c, :h, :num_operations, :total_fees, :block_hash, :previous_hash)
+`
+
+const chunkInsert = `
+INSERT INTO chunks (hash, chunk)
+VALUES ($1, $2)
+ON CONFLICT (hash) DO NOTHING
+`
+
+// blockSelect selects blocks along with their chunks, wherever the chunks are.
+const blockSelect = `
+SELECT blocks.slot, COALESCE(blocks.chunk, chunks.chunk) AS chunk, blocks.c, blocks.h,
+    blocks.num_operations, blocks.total_fees, blocks.block_hash, blocks.previous_hash,
+    COALESCE(blocks.chunk_hash, '') AS chunk_hash
+FROM blocks LEFT JOIN chunks ON chunks.hash = blocks.chunk_hash
+`
+
+const accountDeltaInsert = `
+INSERT INTO account_deltas (owner, slot, sequence, balance, data, storage, created, last_active)
+VALUES (:owner, :slot, :sequence, :balance, :data, :storage, :created, :last_active)
+`
+
+const eventInsert = `
+INSERT INTO events (slot, type, data)
+VALUES (:slot, :type, :data)
+`
+
+const participationInsert = `
+INSERT INTO participation (slot, validator, nominated, balloted, committed, missed)
+VALUES (:slot, :validator, :nominated, :balloted, :committed, :missed)
 `
 
 func isUniquenessError(e error) bool {
 	return strings.Contains(e.Error(), "duplicate key value violates unique constraint")
 }
 
-// InsertBlock returns an error if it failed because this block is already saved.
+// InsertBlock returns an error if it failed because this block is already saved,
+// if the database is read-only, or if the context is done.
+// The account deltas, events, and documents for the block are saved in the same
+// transaction, and the deltas are written through to the account cache once
+// it commits.
+// This fills in the derived fields of the block.
 // It panics if there is a fundamental database problem.
-func (db *Database) InsertBlock(b *Block) error {
-	_, err := db.postgres.NamedExec(blockInsert, b)
+func (db *Database) InsertBlock(ctx context.Context, b *Block) error {
+	return db.InsertBlocks(ctx, []*Block{b})
+}
+
+// InsertBlocks is like InsertBlock, but inserts a list of consecutive blocks
+// in a single transaction.
+func (db *Database) InsertBlocks(ctx context.Context, blocks []*Block) error {
+	_, span := util.StartSpan(ctx, "db.InsertBlock")
+	defer span.End()
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	if len(blocks) == 0 {
+		return nil
+	}
+	tx, err := db.postgres.BeginTxx(ctx, nil)
+	if err = checkError(ctx, err); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if db.statementTimeout {
+		// The timeout is there to cut off slow queries. A finalized block
+		// has to be saved however long it takes.
+		_, err = tx.ExecContext(ctx, "SET LOCAL statement_timeout = 0")
+		if err = checkError(ctx, err); err != nil {
+			return err
+		}
+	}
+
+	previousHash := ""
+	err = tx.GetContext(ctx, &previousHash,
+		"SELECT block_hash FROM blocks WHERE slot=$1", blocks[0].Slot-1)
+	if err != sql.ErrNoRows {
+		if err = checkError(ctx, err); err != nil {
+			return err
+		}
+	}
+
+	blockInsert := tx.NamedStmtContext(ctx, db.blockInsertStmt)
+	deltaInsert := tx.NamedStmtContext(ctx, db.accountDeltaInsertStmt)
+	eventInsert := tx.NamedStmtContext(ctx, db.eventInsertStmt)
+	chunkInsert := tx.StmtxContext(ctx, db.chunkInsertStmt)
+	documentInsert := tx.StmtxContext(ctx, db.documentInsertStmt)
+	participationInsert := tx.NamedStmtContext(ctx, db.participationInsertStmt)
+	statsInsert := tx.NamedStmtContext(ctx, db.networkStatsInsertStmt)
+	stats, err := getNetworkStats(ctx, tx, blocks[0].Slot-1)
 	if err != nil {
-		if isUniquenessError(err) {
+		return err
+	}
+	deltas := []*AccountDelta{}
+	for _, b := range blocks {
+		if err = b.FillDerivedFields(previousHash); err != nil {
 			return err
 		}
-		panic(err)
+		previousHash = b.BlockHash
+
+		_, err = chunkInsert.ExecContext(ctx, b.ChunkHash, b.Chunk)
+		if err = checkError(ctx, err); err != nil {
+			return err
+		}
+		_, err = blockInsert.ExecContext(ctx, b)
+		if err != nil && isUniquenessError(err) {
+			return err
+		}
+		if err = checkError(ctx, err); err != nil {
+			return err
+		}
+		// The stats compare the deltas to the balances before them
+		stats, err = nextNetworkStats(ctx, tx, stats, b)
+		if err != nil {
+			return err
+		}
+		_, err = statsInsert.ExecContext(ctx, stats)
+		if err = checkError(ctx, err); err != nil {
+			return err
+		}
+		for _, delta := range b.AccountDeltas() {
+			_, err = deltaInsert.ExecContext(ctx, delta)
+			if err = checkError(ctx, err); err != nil {
+				return err
+			}
+			deltas = append(deltas, delta)
+		}
+		for _, event := range b.Events() {
+			_, err = eventInsert.ExecContext(ctx, event)
+			if err = checkError(ctx, err); err != nil {
+				return err
+			}
+		}
+		for _, row := range b.ParticipationRows() {
+			_, err = participationInsert.ExecContext(ctx, row)
+			if err = checkError(ctx, err); err != nil {
+				return err
+			}
+		}
+		for _, d := range b.Documents() {
+			_, err = documentInsert.ExecContext(ctx,
+				d.Id, d.Data, d.SearchText(db.searchFields))
+			if err != nil && isUniquenessError(err) {
+				return err
+			}
+			if err = checkError(ctx, err); err != nil {
+				return err
+			}
+			event := newEvent(b.Slot, EventDocumentCreated, d.Data)
+			_, err = eventInsert.ExecContext(ctx, event)
+			if err = checkError(ctx, err); err != nil {
+				return err
+			}
+		}
+		for _, u := range b.DocumentUpdates() {
+			event, err := db.applyDocumentUpdate(ctx, tx, u)
+			if err != nil {
+				return err
+			}
+			if event == nil {
+				continue
+			}
+			_, err = eventInsert.ExecContext(ctx, event)
+			if err = checkError(ctx, err); err != nil {
+				return err
+			}
+		}
+		for _, pin := range b.BlobPins() {
+			if err = applyBlobPin(ctx, tx, pin); err != nil {
+				return err
+			}
+		}
+		purged, err := purgeExpiredDocuments(ctx, tx, b.Slot)
+		if err != nil {
+			return err
+		}
+		for _, event := range purged {
+			_, err = eventInsert.ExecContext(ctx, event)
+			if err = checkError(ctx, err); err != nil {
+				return err
+			}
+		}
 	}
+	if err = checkError(ctx, tx.Commit()); err != nil {
+		return err
+	}
+
+	db.accounts.Commit(blocks[len(blocks)-1].Slot, deltaAccounts(deltas))
 	return nil
 }
 
+// deltaAccounts returns the state of each account after a list of deltas in
+// slot order, keyed by owner.
+func deltaAccounts(deltas []*AccountDelta) map[string]*currency.Account {
+	answer := make(map[string]*currency.Account)
+	for _, delta := range deltas {
+		// Later deltas overwrite earlier ones
+		answer[delta.Owner] = delta.Account()
+	}
+	return answer
+}
+
+// SaveBlock saves a newly finalized block, following the durability policy.
+// Depending on the policy, the block may not be committed by the time SaveBlock
+// returns. GetBlock and the account lookups answer from pending blocks, but
+// other queries don't see them until they are committed.
+// SaveBlock returns the same errors as InsertBlock.
+func (db *Database) SaveBlock(ctx context.Context, b *Block) error {
+	if db.durability != DurabilityBatch {
+		return db.InsertBlock(ctx, b)
+	}
+	db.pendingMutex.Lock()
+	db.pending = append(db.pending, b)
+	full := len(db.pending) >= db.commitInterval
+	db.pendingMutex.Unlock()
+	if full {
+		return db.Flush(ctx)
+	}
+	return nil
+}
+
+// Flush commits any blocks that SaveBlock has not committed yet.
+// It returns the same errors as InsertBlock. If there is an error, the blocks
+// remain pending.
+func (db *Database) Flush(ctx context.Context) error {
+	db.pendingMutex.Lock()
+	defer db.pendingMutex.Unlock()
+	if err := db.InsertBlocks(ctx, db.pending); err != nil {
+		return err
+	}
+	db.pending = nil
+	return nil
+}
+
+// pendingBlock returns the block for a slot that SaveBlock hasn't committed
+// yet, or nil if there is none.
+func (db *Database) pendingBlock(slot int) *Block {
+	db.pendingMutex.Lock()
+	defer db.pendingMutex.Unlock()
+	for _, b := range db.pending {
+		if b.Slot != slot {
+			continue
+		}
+		// The derived fields are only filled in when the block is committed
+		answer := *b
+		if answer.Chunk != nil {
+			answer.ChunkHash = string(answer.Chunk.Hash())
+		}
+		return &answer
+	}
+	return nil
+}
+
+// pendingAccount returns the state of an account right after slot, from the
+// blocks SaveBlock hasn't committed yet. ok is false when none of them up to
+// slot changed the account.
+func (db *Database) pendingAccount(owner string, slot int) (*currency.Account, bool) {
+	db.pendingMutex.Lock()
+	defer db.pendingMutex.Unlock()
+	for i := len(db.pending) - 1; i >= 0; i-- {
+		b := db.pending[i]
+		if b.Slot > slot || b.Chunk == nil {
+			continue
+		}
+		if account := b.Chunk.State[owner]; account != nil {
+			return account, true
+		}
+	}
+	return nil, false
+}
+
+// GetAccount returns the current state of an account, or nil if no block has
+// touched the account. Recently used accounts are served from memory.
+// "Current" means as of the last block this process committed, or the last
+// slot CatchUpAccounts caught up to, whichever is later.
+// It only returns an error if the context is done, or ErrQueryCanceled if
+// the statement timeout cancels the query.
+func (db *Database) GetAccount(ctx context.Context, owner string) (*currency.Account, error) {
+	if account, ok := db.pendingAccount(owner, math.MaxInt32); ok {
+		return account, nil
+	}
+	if account, ok := db.accounts.Get(owner); ok {
+		return account, nil
+	}
+	slot := db.accounts.Slot()
+	delta := &AccountDelta{}
+	err := db.postgres.GetContext(ctx, delta,
+		"SELECT * FROM account_deltas WHERE owner=$1 AND ($2=0 OR slot<=$2) "+
+			"ORDER BY slot DESC LIMIT 1",
+		owner, slot)
+	var account *currency.Account
+	if err != sql.ErrNoRows {
+		if err = checkError(ctx, err); err != nil {
+			return nil, err
+		}
+		account = delta.Account()
+	}
+	db.accounts.Fill(owner, account, slot)
+	return account, nil
+}
+
+// GetAccounts is like GetAccount for many accounts at once, keyed by owner.
+// Accounts that aren't in the cache are all loaded in a single query.
+// It only returns an error if the context is done, or ErrQueryCanceled if
+// the statement timeout cancels the query.
+func (db *Database) GetAccounts(
+	ctx context.Context, owners []string) (map[string]*currency.Account, error) {
+	answer := make(map[string]*currency.Account)
+	missing := []string{}
+	for _, owner := range owners {
+		if _, ok := answer[owner]; ok {
+			continue
+		}
+		if account, ok := db.pendingAccount(owner, math.MaxInt32); ok {
+			answer[owner] = account
+			continue
+		}
+		account, ok := db.accounts.Get(owner)
+		answer[owner] = account
+		if !ok {
+			missing = append(missing, owner)
+		}
+	}
+	if len(missing) == 0 {
+		return answer, nil
+	}
+	slot := db.accounts.Slot()
+	deltas := []*AccountDelta{}
+	err := db.postgres.SelectContext(ctx, &deltas,
+		"SELECT DISTINCT ON (owner) * FROM account_deltas "+
+			"WHERE owner=ANY($1) AND ($2=0 OR slot<=$2) "+
+			"ORDER BY owner, slot DESC",
+		pq.Array(missing), slot)
+	if err = checkError(ctx, err); err != nil {
+		return nil, err
+	}
+	for _, delta := range deltas {
+		answer[delta.Owner] = delta.Account()
+	}
+	for _, owner := range missing {
+		db.accounts.Fill(owner, answer[owner], slot)
+	}
+	return answer, nil
+}
+
+// How many slots CatchUpAccounts reads the changes from. When the cache is
+// further behind than this, it's cheaper to empty it and start over.
+const maxAccountCatchUp = 100
+
+// CatchUpAccounts brings the account cache up to date with the blocks up to
+// slot. A database only writes its own commits through to the cache, so a
+// process that reads blocks another process wrote, like an archive server,
+// calls this with the last slot before it looks up accounts.
+// It only returns an error if the context is done, or ErrQueryCanceled if
+// the statement timeout cancels the query.
+func (db *Database) CatchUpAccounts(ctx context.Context, slot int) error {
+	from := db.accounts.Slot()
+	if slot <= from {
+		return nil
+	}
+	if from == 0 || slot-from > maxAccountCatchUp {
+		db.accounts.Reset(slot)
+		return nil
+	}
+	deltas := []*AccountDelta{}
+	err := db.postgres.SelectContext(ctx, &deltas,
+		"SELECT * FROM account_deltas WHERE slot>$1 AND slot<=$2 ORDER BY slot",
+		from, slot)
+	if err = checkError(ctx, err); err != nil {
+		return err
+	}
+	db.accounts.CatchUp(slot, deltaAccounts(deltas))
+	return nil
+}
+
+// AccountCacheStats reports how well the account cache is working.
+func (db *Database) AccountCacheStats() AccountCacheStats {
+	return db.accounts.Stats()
+}
+
+// GetAccountAtSlot returns the state of an account right after the block for
+// the provided slot. It returns nil if no block up to that slot touched the
+// account.
+// It only returns an error if the context is done, or ErrQueryCanceled if
+// the statement timeout cancels the query.
+func (db *Database) GetAccountAtSlot(
+	ctx context.Context, owner string, slot int) (*currency.Account, error) {
+	if account, ok := db.pendingAccount(owner, slot); ok {
+		return account, nil
+	}
+	delta := &AccountDelta{}
+	err := db.postgres.GetContext(ctx, delta,
+		"SELECT * FROM account_deltas WHERE owner=$1 AND slot<=$2 "+
+			"ORDER BY slot DESC LIMIT 1",
+		owner, slot)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err = checkError(ctx, err); err != nil {
+		return nil, err
+	}
+	return delta.Account(), nil
+}
+
 // GetBlock returns nil if there is no block for the provided slot.
-func (db *Database) GetBlock(slot int) *Block {
+// It only returns an error if the context is done, or ErrQueryCanceled if
+// the statement timeout cancels the query.
+func (db *Database) GetBlock(ctx context.Context, slot int) (*Block, error) {
+	if b := db.pendingBlock(slot); b != nil {
+		return b, nil
+	}
 	answer := &Block{}
-	err := db.postgres.Get(answer, "SELECT * FROM blocks WHERE slot=$1", slot)
+	err := db.postgres.GetContext(ctx, answer, blockSelect+"WHERE blocks.slot=$1", slot)
 	if err == sql.ErrNoRows {
-		return nil
+		return nil, nil
 	}
-	if err != nil {
-		panic(err)
+	if err = checkError(ctx, err); err != nil {
+		return nil, err
 	}
-	return answer
+	return answer, nil
+}
+
+// GetChunk returns the chunk with the provided hash, or nil if there is none.
+// Chunks in blocks saved before chunks were stored by hash can't be found
+// this way.
+// It only returns an error if the context is done, or ErrQueryCanceled if
+// the statement timeout cancels the query.
+func (db *Database) GetChunk(ctx context.Context, hash string) (*currency.LedgerChunk, error) {
+	answer := &currency.LedgerChunk{}
+	err := db.postgres.GetContext(ctx, answer, "SELECT chunk FROM chunks WHERE hash=$1", hash)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err = checkError(ctx, err); err != nil {
+		return nil, err
+	}
+	return answer, nil
 }
 
 // LastBlock returns nil if the database has no blocks in it yet.
-func (db *Database) LastBlock() *Block {
+// It only returns an error if the context is done, or ErrQueryCanceled if
+// the statement timeout cancels the query.
+func (db *Database) LastBlock(ctx context.Context) (*Block, error) {
 	answer := &Block{}
-	err := db.postgres.Get(answer, "SELECT * FROM blocks ORDER BY slot DESC LIMIT 1")
+	err := db.postgres.GetContext(
+		ctx, answer, blockSelect+"ORDER BY blocks.slot DESC LIMIT 1")
 	if err == sql.ErrNoRows {
-		return nil
+		return nil, nil
+	}
+	if err = checkError(ctx, err); err != nil {
+		return nil, err
+	}
+	return answer, nil
+}
+
+// GetBlocks returns up to limit blocks, in order, starting at fromSlot.
+// It only returns an error if the context is done, or ErrQueryCanceled if
+// the statement timeout cancels the query.
+func (db *Database) GetBlocks(ctx context.Context, fromSlot int, limit int) ([]*Block, error) {
+	answer := []*Block{}
+	err := db.postgres.SelectContext(ctx, &answer,
+		blockSelect+"WHERE blocks.slot>=$1 ORDER BY blocks.slot LIMIT $2", fromSlot, limit)
+	if err = checkError(ctx, err); err != nil {
+		return nil, err
 	}
+	return answer, nil
+}
+
+const blockDeltaSelect = `
+SELECT d.owner, d.sequence AS sequence_after, d.balance AS balance_after,
+COALESCE(p.sequence, 0) AS sequence_before, COALESCE(p.balance, 0) AS balance_before,
+p.owner IS NOT NULL AS existed
+FROM account_deltas d
+LEFT JOIN LATERAL (
+  SELECT owner, sequence, balance FROM account_deltas
+  WHERE owner=d.owner AND slot<d.slot ORDER BY slot DESC LIMIT 1
+) p ON true
+WHERE d.slot=$1 AND (p.owner IS NULL OR p.sequence<>d.sequence OR p.balance<>d.balance)
+ORDER BY d.owner
+`
+
+// GetBlockDelta returns the accounts whose balance or sequence number
+// changed in the block for a slot, with their values before and after it,
+// in order of owner. It is empty if there is no such block.
+// It only returns an error if the context is done, or ErrQueryCanceled if
+// the statement timeout cancels the query.
+func (db *Database) GetBlockDelta(ctx context.Context, slot int) ([]*AccountChange, error) {
+	answer := []*AccountChange{}
+	err := db.postgres.SelectContext(ctx, &answer, blockDeltaSelect, slot)
+	if err = checkError(ctx, err); err != nil {
+		return nil, err
+	}
+	return answer, nil
+}
+
+// GetAccountSlotsPage returns up to limit of the slots in which this account
+// changed, most recent first, starting after the provided cursor.
+// It returns an error if the cursor is invalid or if the context is done.
+func (db *Database) GetAccountSlotsPage(
+	ctx context.Context, owner string, after Cursor, limit int) ([]int, Cursor, error) {
+	before, ok, err := after.Key()
 	if err != nil {
-		panic(err)
+		return nil, "", err
 	}
-	return answer
+	if !ok {
+		before = math.MaxInt32
+	}
+	answer := []int{}
+	err = db.postgres.SelectContext(ctx, &answer,
+		"SELECT slot FROM account_deltas WHERE owner=$1 AND slot<$2 "+
+			"ORDER BY slot DESC LIMIT $3",
+		owner, before, limit+1)
+	if err = checkError(ctx, err); err != nil {
+		return nil, "", err
+	}
+	if len(answer) <= limit {
+		return answer, "", nil
+	}
+	answer = answer[:limit]
+	return answer, NewCursor(int64(answer[limit-1])), nil
 }
 
-// ForBlocks calls f on each block in the db, from lowest to highest number.
-// It returns the number of blocks that were processed.
-func (db *Database) ForBlocks(f func(b *Block)) int {
-	slot := 0
-	rows, err := db.postgres.Queryx("SELECT * FROM blocks ORDER BY slot")
+var accountActivitySelect = fmt.Sprintf(`
+SELECT owner,
+COUNT(*) FILTER (WHERE slot > (SELECT COALESCE(MAX(slot), 0) FROM blocks) - %d) AS recent
+FROM account_deltas
+`, currency.RecentActivitySlots)
+
+// GetAccountActivity returns how active an account has been lately, or nil
+// if no block has changed it.
+// It only returns an error if the context is done, or ErrQueryCanceled if
+// the statement timeout cancels the query.
+func (db *Database) GetAccountActivity(
+	ctx context.Context, owner string) (*currency.AccountActivity, error) {
+	answer := &currency.AccountActivity{}
+	err := db.postgres.GetContext(ctx, answer,
+		accountActivitySelect+"WHERE owner=$1 GROUP BY owner", owner)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err = checkError(ctx, err); err != nil {
+		return nil, err
+	}
+	return answer, nil
+}
+
+// GetAccountsActivity is like GetAccountActivity for many accounts at once.
+// Accounts that no block has changed are left out.
+// It only returns an error if the context is done, or ErrQueryCanceled if
+// the statement timeout cancels the query.
+func (db *Database) GetAccountsActivity(ctx context.Context,
+	owners []string) (map[string]*currency.AccountActivity, error) {
+	rows := []*currency.AccountActivity{}
+	err := db.postgres.SelectContext(ctx, &rows,
+		accountActivitySelect+"WHERE owner = ANY($1) GROUP BY owner", pq.Array(owners))
+	if err = checkError(ctx, err); err != nil {
+		return nil, err
+	}
+	answer := make(map[string]*currency.AccountActivity)
+	for _, activity := range rows {
+		answer[activity.Owner] = activity
+	}
+	return answer, nil
+}
+
+// GetInactiveAccounts returns the latest deltas of up to limit accounts that
+// have not changed since the provided slot, least recently active first.
+// It only returns an error if the context is done, or ErrQueryCanceled if
+// the statement timeout cancels the query.
+func (db *Database) GetInactiveAccounts(
+	ctx context.Context, since int, limit int) ([]*AccountDelta, error) {
+	answer := []*AccountDelta{}
+	err := db.postgres.SelectContext(ctx, &answer,
+		"SELECT * FROM (SELECT DISTINCT ON (owner) * FROM account_deltas "+
+			"ORDER BY owner, slot DESC) latest "+
+			"WHERE last_active <= $1 ORDER BY last_active, owner LIMIT $2",
+		since, limit)
+	if err = checkError(ctx, err); err != nil {
+		return nil, err
+	}
+	return answer, nil
+}
+
+// GetScoreboard sums up how each validator took part in the slots after the
+// provided one, using the slots this node recorded participation for.
+// Validators that were part of the most externalizing quorums come first.
+// It only returns an error if the context is done, or ErrQueryCanceled if
+// the statement timeout cancels the query.
+func (db *Database) GetScoreboard(ctx context.Context, since int) ([]*ValidatorScore, error) {
+	answer := []*ValidatorScore{}
+	err := db.postgres.SelectContext(ctx, &answer, `
+SELECT validator, COUNT(*) AS slots,
+COUNT(*) FILTER (WHERE nominated) AS nominated,
+COUNT(*) FILTER (WHERE balloted) AS balloted,
+COUNT(*) FILTER (WHERE committed) AS committed,
+COUNT(*) FILTER (WHERE missed) AS missed
+FROM participation
+WHERE slot > $1
+GROUP BY validator
+ORDER BY committed DESC, validator
+`, since)
+	if err = checkError(ctx, err); err != nil {
+		return nil, err
+	}
+	return answer, nil
+}
+
+// GetBlocksPage returns up to limit blocks, in order, starting after the
+// provided cursor.
+// It returns an error if the cursor is invalid or if the context is done.
+func (db *Database) GetBlocksPage(
+	ctx context.Context, after Cursor, limit int) ([]*Block, Cursor, error) {
+	last, _, err := after.Key()
 	if err != nil {
-		panic(err)
+		return nil, "", err
 	}
-	for rows.Next() {
-		b := &Block{}
-		err := rows.StructScan(b)
-		if err != nil {
-			panic(err)
-		}
-		if b.Slot != slot+1 {
-			util.Logger.Fatal("missing block with slot %d", slot+1)
-		}
-		slot += 1
-		f(b)
+	answer, err := db.GetBlocks(ctx, int(last)+1, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(answer) <= limit {
+		return answer, "", nil
 	}
-	return slot
+	answer = answer[:limit]
+	return answer, NewCursor(int64(answer[limit-1].Slot)), nil
+}
+
+// ForBlocks calls f on each block in the db, from lowest to highest number.
+// It returns the number of blocks that were processed.
+// If the context is done partway through, it stops and returns the context's
+// error along with the number of blocks processed so far.
+func (db *Database) ForBlocks(ctx context.Context, f func(b *Block)) (int, error) {
+	return db.ForBlocksWithOptions(ctx, nil, f)
 }
 
 const documentInsert = `
-INSERT INTO documents (id, data)
-VALUES (:id, :data)
+INSERT INTO documents (id, data, search)
+VALUES ($1, $2, to_tsvector('english', $3))
 `
 
 // InsertDocument returns an error if it failed because there is already a document with
-// this id.
+// this id, if the database is read-only, or if the context is done.
+// A document_created event is saved in the same transaction.
 // It panics if there is a fundamental database problem.
-func (db *Database) InsertDocument(d *Document) error {
-	_, err := db.postgres.NamedExec(documentInsert, d)
+func (db *Database) InsertDocument(ctx context.Context, d *Document) error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	tx, err := db.postgres.BeginTxx(ctx, nil)
+	if err = checkError(ctx, err); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.StmtxContext(ctx, db.documentInsertStmt).ExecContext(ctx,
+		d.Id, d.Data, d.SearchText(db.searchFields))
+	if err != nil && isUniquenessError(err) {
+		return err
+	}
+	if err = checkError(ctx, err); err != nil {
+		return err
+	}
+	event := &Event{Type: EventDocumentCreated, Data: d.Data}
+	_, err = tx.NamedStmtContext(ctx, db.eventInsertStmt).ExecContext(ctx, event)
+	if err = checkError(ctx, err); err != nil {
+		return err
+	}
+	return checkError(ctx, tx.Commit())
+}
+
+// TailEvents returns up to limit events that happened after the provided
+// cursor, oldest first. If types is nonempty, only events of those types are
+// returned.
+// Unlike the other paged queries, the returned cursor is never empty once
+// there has been an event, so a consumer can keep passing it back in to wait
+// for new events. When there are no new events, it returns the same cursor.
+// It returns an error if the cursor is invalid or if the context is done.
+func (db *Database) TailEvents(ctx context.Context,
+	after Cursor, types []string, limit int) ([]*Event, Cursor, error) {
+	last, _, err := after.Key()
 	if err != nil {
-		if isUniquenessError(err) {
-			return err
-		}
-		panic(err)
+		return nil, "", err
 	}
-	return nil
+	answer := []*Event{}
+	query := "SELECT seq, slot, type, data FROM events WHERE seq>$1 ORDER BY seq LIMIT $2"
+	args := []interface{}{last, limit}
+	if len(types) > 0 {
+		query = "SELECT seq, slot, type, data FROM events " +
+			"WHERE seq>$1 AND type = ANY($3) ORDER BY seq LIMIT $2"
+		args = append(args, pq.Array(types))
+	}
+	err = db.postgres.SelectContext(ctx, &answer, query, args...)
+	if err = checkError(ctx, err); err != nil {
+		return nil, "", err
+	}
+	if len(answer) == 0 {
+		return answer, after, nil
+	}
+	return answer, NewCursor(answer[len(answer)-1].Seq), nil
 }
 
-func (db *Database) GetDocuments(match map[string]interface{}, limit int) []*Document {
-	bytes, err := json.Marshal(match)
+// GetDocuments returns up to limit documents whose data contains match,
+// in order of id.
+// It only returns an error if the context is done, or ErrQueryCanceled if
+// the statement timeout cancels the query.
+func (db *Database) GetDocuments(
+	ctx context.Context, match map[string]interface{}, limit int) ([]*Document, error) {
+	answer, _, err := db.GetDocumentsPage(ctx, match, "", limit)
+	return answer, err
+}
+
+// GetDocumentsPage returns up to limit documents whose data contains match,
+// in order of id, starting after the provided cursor.
+// It returns an error if the cursor is invalid or if the context is done.
+func (db *Database) GetDocumentsPage(ctx context.Context,
+	match map[string]interface{}, after Cursor, limit int) ([]*Document, Cursor, error) {
+	last, ok, err := after.Key()
 	if err != nil {
-		panic(err)
+		return nil, "", err
+	}
+	if !ok {
+		last = -1
 	}
-	rows, err := db.postgres.Queryx(
-		"SELECT * FROM documents WHERE data @> $1 LIMIT $2", string(bytes), limit)
+	bytes, err := json.Marshal(match)
 	if err != nil {
 		panic(err)
 	}
+	rows, err := db.postgres.QueryxContext(ctx,
+		"SELECT id, data FROM documents WHERE data @> $1 AND id>$2 AND "+liveDocuments+
+			" ORDER BY id LIMIT $3",
+		string(bytes), last, limit+1)
+	if err = checkError(ctx, err); err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
 	answer := []*Document{}
 	for rows.Next() {
 		d := &Document{}
 		err := rows.StructScan(d)
-		if err != nil {
-			panic(err)
+		if err = checkError(ctx, err); err != nil {
+			return nil, "", err
 		}
 		answer = append(answer, d)
 	}
-	return answer
+	if err = checkError(ctx, rows.Err()); err != nil {
+		return nil, "", err
+	}
+	if len(answer) <= limit {
+		return answer, "", nil
+	}
+	answer = answer[:limit]
+	return answer, NewCursor(int64(answer[limit-1].Id)), nil
+}
+
+// GetReferencedDocuments returns the documents that the provided fields of
+// docs refer to, in order of id, so that a query and the documents it refers
+// to take two queries rather than one per document. Referenced documents that
+// don't exist are left out.
+// It only returns an error if the context is done, or ErrQueryCanceled if
+// the statement timeout cancels the query.
+func (db *Database) GetReferencedDocuments(
+	ctx context.Context, docs []*Document, fields []string) ([]*Document, error) {
+	seen := map[uint64]bool{}
+	ids := []int64{}
+	for _, d := range docs {
+		for _, id := range d.References(fields) {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, int64(id))
+			}
+		}
+	}
+	answer := []*Document{}
+	if len(ids) == 0 {
+		return answer, nil
+	}
+	err := db.postgres.SelectContext(ctx, &answer,
+		"SELECT id, data FROM documents WHERE id = ANY($1) AND "+liveDocuments+
+			" ORDER BY id", pq.Array(ids))
+	if err = checkError(ctx, err); err != nil {
+		return nil, err
+	}
+	return answer, nil
+}
+
+// SearchDocuments returns up to limit documents that match a full-text query,
+// best matches first. Only the fields in the config's SearchFields are
+// searched. If collection is nonempty, only documents in that collection are
+// returned.
+// It only returns an error if the context is done, or ErrQueryCanceled if
+// the statement timeout cancels the query.
+func (db *Database) SearchDocuments(
+	ctx context.Context, query string, collection string, limit int) ([]*Document, error) {
+	match := map[string]interface{}{}
+	if collection != "" {
+		match["collection"] = collection
+	}
+	bytes, err := json.Marshal(match)
+	if err != nil {
+		panic(err)
+	}
+	answer := []*Document{}
+	err = db.postgres.SelectContext(ctx, &answer, `
+SELECT id, data FROM documents
+WHERE search @@ plainto_tsquery('english', $1) AND data @> $2 AND `+liveDocuments+`
+ORDER BY ts_rank(search, plainto_tsquery('english', $1)) DESC, id
+LIMIT $3`, query, string(bytes), limit)
+	if err = checkError(ctx, err); err != nil {
+		return nil, err
+	}
+	return answer, nil
+}
+
+// CountDocuments returns how many documents have data that contains match.
+// It only returns an error if the context is done, or ErrQueryCanceled if
+// the statement timeout cancels the query.
+func (db *Database) CountDocuments(
+	ctx context.Context, match map[string]interface{}) (int, error) {
+	stats, err := db.AggregateDocuments(ctx, match, "")
+	if err != nil {
+		return 0, err
+	}
+	return stats.Count, nil
+}
+
+// AggregateDocuments computes statistics over the documents whose data
+// contains match. If field is nonempty, the sum, min, and max are computed over
+// the documents where that field is a number.
+// It only returns an error if the context is done, or ErrQueryCanceled if
+// the statement timeout cancels the query.
+func (db *Database) AggregateDocuments(ctx context.Context,
+	match map[string]interface{}, field string) (*DocumentStats, error) {
+	bytes, err := json.Marshal(match)
+	if err != nil {
+		panic(err)
+	}
+	stats := &DocumentStats{}
+	var sum, min, max sql.NullFloat64
+	row := db.postgres.QueryRowxContext(ctx, `
+SELECT COUNT(*), COUNT(*) FILTER (WHERE jsonb_typeof(data->$2) = 'number'),
+  SUM((data->>$2)::numeric) FILTER (WHERE jsonb_typeof(data->$2) = 'number'),
+  MIN((data->>$2)::numeric) FILTER (WHERE jsonb_typeof(data->$2) = 'number'),
+  MAX((data->>$2)::numeric) FILTER (WHERE jsonb_typeof(data->$2) = 'number')
+FROM documents WHERE data @> $1 AND `+liveDocuments, string(bytes), field)
+	err = row.Scan(&stats.Count, &stats.NumValues, &sum, &min, &max)
+	if err = checkError(ctx, err); err != nil {
+		return nil, err
+	}
+	stats.Sum = sum.Float64
+	stats.Min = min.Float64
+	stats.Max = max.Float64
+	return stats, nil
 }
 
 func DropTestData(i int) {
-	db := NewTestDatabase(i)
-	util.Logger.Printf("clearing test database %s", db.name)
+	DropData(NewTestConfig(i))
+}
+
+// CreateDatabase creates the database a config points at, if it doesn't exist
+// yet. It connects to the server's postgres database to do so.
+func CreateDatabase(config *Config) {
+	info := connectionInfo(config, "postgres")
+	if len(config.Password) > 0 {
+		info = fmt.Sprintf("%s password=%s", info, config.Password)
+	}
+	postgres := sqlx.MustConnect("postgres", info)
+	defer postgres.Close()
+	exists := false
+	err := postgres.Get(&exists,
+		"SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname=$1)", config.Database)
+	if err != nil {
+		panic(err)
+	}
+	if exists {
+		return
+	}
+	util.Logger.Printf("creating database %s", config.Database)
+	postgres.MustExec("CREATE DATABASE " + pq.QuoteIdentifier(config.Database))
+}
+
+// DropData clears out all the tables in a database, so that a node using it
+// starts over from an empty chain.
+func DropData(config *Config) {
+	db := NewDatabase(config)
+	defer db.postgres.Close()
+	util.Logger.Printf("clearing database %s", db.name)
 	db.postgres.MustExec("DROP TABLE IF EXISTS blocks")
 	db.postgres.MustExec("DROP TABLE IF EXISTS documents")
+	db.postgres.MustExec("DROP TABLE IF EXISTS account_deltas")
+	db.postgres.MustExec("DROP TABLE IF EXISTS events")
+	db.postgres.MustExec("DROP TABLE IF EXISTS chunks")
+	db.postgres.MustExec("DROP TABLE IF EXISTS participation")
+	db.postgres.MustExec("DROP TABLE IF EXISTS forks")
+	db.postgres.MustExec("DROP TABLE IF EXISTS document_revisions")
+	db.postgres.MustExec("DROP TABLE IF EXISTS blob_pins")
+	db.postgres.MustExec("DROP TABLE IF EXISTS blobs")
+	db.postgres.MustExec("DROP TABLE IF EXISTS network_stats")
 }
@@ -1,32 +1,61 @@
 package data
 
 import (
+	"context"
 	"log"
 	"os"
 	"testing"
 
+	"github.com/lacker/coinkit/consensus"
 	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/util"
 )
 
 func TestInsertAndGet(t *testing.T) {
 	db := NewTestDatabase(0)
+	ctx := context.Background()
 	block := &Block{
 		Slot:  3,
 		Chunk: currency.NewEmptyChunk(),
 	}
-	err := db.InsertBlock(block)
+	err := db.InsertBlock(ctx, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b2, err := db.GetBlock(ctx, 3)
 	if err != nil {
 		t.Fatal(err)
 	}
-	b2 := db.GetBlock(3)
 	if b2.C != block.C {
-		t.Fatal("block changed: %+v -> %+v", block, b2)
+		t.Fatalf("block changed: %+v -> %+v", block, b2)
+	}
+}
+
+func TestCreateExistingDatabase(t *testing.T) {
+	// Creating a database that already exists leaves its data alone
+	DropTestData(0)
+	db := NewTestDatabase(0)
+	ctx := context.Background()
+	if err := db.InsertBlock(ctx, &Block{Slot: 1, Chunk: currency.NewEmptyChunk()}); err != nil {
+		t.Fatal(err)
+	}
+	CreateDatabase(NewTestConfig(0))
+	b, err := db.GetBlock(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b == nil {
+		t.Fatal("expected the block to still be there")
 	}
 }
 
 func TestGetNonexistentBlock(t *testing.T) {
 	db := NewTestDatabase(0)
-	b := db.GetBlock(4)
+	ctx := context.Background()
+	b, err := db.GetBlock(ctx, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if b != nil {
 		t.Fatal("block should be nonexistent")
 	}
@@ -34,17 +63,18 @@ func TestGetNonexistentBlock(t *testing.T) {
 
 func TestCantInsertTwice(t *testing.T) {
 	db := NewTestDatabase(0)
+	ctx := context.Background()
 	block := &Block{
 		Slot:  4,
 		Chunk: currency.NewEmptyChunk(),
 		C:     1,
 		H:     2,
 	}
-	err := db.InsertBlock(block)
+	err := db.InsertBlock(ctx, block)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = db.InsertBlock(block)
+	err = db.InsertBlock(ctx, block)
 	if err == nil {
 		t.Fatal("a block should not save twice")
 	}
@@ -53,102 +83,816 @@ func TestCantInsertTwice(t *testing.T) {
 func TestLastBlock(t *testing.T) {
 	DropTestData(0)
 	db := NewTestDatabase(0)
-	b := db.LastBlock()
+	ctx := context.Background()
+	b, err := db.LastBlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if b != nil {
-		t.Fatal("expected last block nil but got %+v", b)
+		t.Fatalf("expected last block nil but got %+v", b)
 	}
 	b = &Block{
 		Slot:  5,
 		Chunk: currency.NewEmptyChunk(),
 	}
-	err := db.InsertBlock(b)
+	err = db.InsertBlock(ctx, b)
 	if err != nil {
 		t.Fatal(err)
 	}
 	b.Slot = 6
-	err = db.InsertBlock(b)
+	err = db.InsertBlock(ctx, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b2, err := db.LastBlock(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
-	b2 := db.LastBlock()
 	if b2.Slot != b.Slot {
-		t.Fatal("b2: %+v", b2)
+		t.Fatalf("b2: %+v", b2)
 	}
 }
 
 func TestForBlocks(t *testing.T) {
 	DropTestData(0)
 	db := NewTestDatabase(0)
+	ctx := context.Background()
 	for i := 1; i <= 5; i++ {
 		b := &Block{
 			Slot:  i,
 			Chunk: currency.NewEmptyChunk(),
 			C:     7,
 		}
-		if db.InsertBlock(b) != nil {
+		if db.InsertBlock(ctx, b) != nil {
 			t.Fatal("block could not save")
 		}
 	}
-	count := db.ForBlocks(func(b *Block) {
+	count, err := db.ForBlocks(ctx, func(b *Block) {
 		if b.C != 7 {
 			t.Fatal("expected C = 7")
 		}
 	})
+	if err != nil {
+		t.Fatal(err)
+	}
 	if count != 5 {
 		t.Fatal("expected count = 5")
 	}
 }
 
+func TestForBlocksWithOptions(t *testing.T) {
+	DropTestData(0)
+	db := NewTestDatabase(0)
+	ctx := context.Background()
+	for i := 1; i <= 7; i++ {
+		if err := db.InsertBlock(ctx, &Block{Slot: i, Chunk: currency.NewEmptyChunk()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	progress := []int{}
+	options := &ForBlocksOptions{
+		BatchSize: 3,
+		Workers:   2,
+		Progress: func(count int) {
+			progress = append(progress, count)
+		},
+	}
+	last := 0
+	count, err := db.ForBlocksWithOptions(ctx, options, func(b *Block) {
+		if b.Slot != last+1 {
+			t.Fatalf("got slot %d after slot %d", b.Slot, last)
+		}
+		if b.Chunk == nil || b.Chunk.State == nil {
+			t.Fatalf("chunk was not decoded: %+v", b)
+		}
+		last = b.Slot
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 7 {
+		t.Fatalf("expected count = 7 but got %d", count)
+	}
+	if len(progress) != 3 || progress[0] != 3 || progress[2] != 7 {
+		t.Fatalf("unexpected progress updates: %+v", progress)
+	}
+
+	// Just part of the chain
+	slots := []int{}
+	options = &ForBlocksOptions{BatchSize: 2, Workers: 1, From: 3, To: 6}
+	count, err = db.ForBlocksWithOptions(ctx, options, func(b *Block) {
+		slots = append(slots, b.Slot)
+	})
+	if err != nil || count != 4 || slots[0] != 3 || slots[3] != 6 {
+		t.Fatalf("expected slots 3 through 6 but got %v: %v", slots, err)
+	}
+}
+
+func TestForBlocksCanceled(t *testing.T) {
+	DropTestData(0)
+	db := NewTestDatabase(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i := 1; i <= 5; i++ {
+		if err := db.InsertBlock(ctx, &Block{Slot: i, Chunk: currency.NewEmptyChunk()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	options := &ForBlocksOptions{BatchSize: 1, Workers: 1}
+	count, err := db.ForBlocksWithOptions(ctx, options, func(b *Block) {
+		if b.Slot == 2 {
+			cancel()
+		}
+	})
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled but got: %+v", err)
+	}
+	if count < 2 || count >= 5 {
+		t.Fatalf("expected to stop partway through but got count = %d", count)
+	}
+}
+
+func TestGetAccount(t *testing.T) {
+	DropTestData(0)
+	db := NewTestDatabase(0)
+	ctx := context.Background()
+	account, err := db.GetAccount(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account != nil {
+		t.Fatalf("expected no account but got %+v", account)
+	}
+
+	// Inserting a block should write through to the cache
+	chunk := currency.NewEmptyChunk()
+	chunk.State["bob"] = &currency.Account{Sequence: 1, Balance: 10}
+	if err := db.InsertBlock(ctx, &Block{Slot: 1, Chunk: chunk}); err != nil {
+		t.Fatal(err)
+	}
+	before := db.AccountCacheStats()
+	account, err = db.GetAccount(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account == nil || account.Balance != 10 {
+		t.Fatalf("expected balance 10 but got %+v", account)
+	}
+	after := db.AccountCacheStats()
+	if after.Hits != before.Hits+1 || after.Misses != before.Misses {
+		t.Fatalf("expected a cache hit: %+v -> %+v", before, after)
+	}
+}
+
+func TestPoolConfig(t *testing.T) {
+	config := NewTestConfig(0)
+	config.MaxOpenConns = 3
+	config.MaxIdleConns = 2
+	config.StatementTimeoutMillis = 5000
+	db := NewDatabase(config)
+	if db.postgres.Stats().MaxOpenConnections != 3 {
+		t.Fatalf("unexpected pool stats: %+v", db.postgres.Stats())
+	}
+	timeout := ""
+	if err := db.postgres.Get(&timeout, "SHOW statement_timeout"); err != nil {
+		t.Fatal(err)
+	}
+	if timeout != "5s" {
+		t.Fatalf("expected a 5s statement timeout but got %s", timeout)
+	}
+}
+
+func TestStatementTimeout(t *testing.T) {
+	DropTestData(0)
+	NewTestDatabase(0)
+	config := NewTestConfig(0)
+	config.StatementTimeoutMillis = 100
+	db := NewDatabase(config)
+	ctx := context.Background()
+
+	// A slow query fails instead of panicking
+	_, err := db.postgres.ExecContext(ctx, "SELECT pg_sleep(1)")
+	if checkError(ctx, err) != ErrQueryCanceled {
+		t.Fatalf("expected the query to be canceled but got %v", err)
+	}
+
+	// Saving a block doesn't have the timeout
+	if err := db.InsertBlock(ctx, &Block{Slot: 1, Chunk: currency.NewEmptyChunk()}); err != nil {
+		t.Fatal(err)
+	}
+	timeout := ""
+	if err := db.postgres.Get(&timeout, "SHOW statement_timeout"); err != nil {
+		t.Fatal(err)
+	}
+	if timeout != "100ms" {
+		t.Fatalf("the block's transaction changed the timeout to %s", timeout)
+	}
+}
+
+func TestBatchDurability(t *testing.T) {
+	DropTestData(0)
+	config := NewTestConfig(0)
+	config.Durability = DurabilityBatch
+	config.CommitInterval = 3
+	db := NewDatabase(config)
+	ctx := context.Background()
+	blocks := []*Block{}
+	for i := 1; i <= 4; i++ {
+		b := &Block{Slot: i, Chunk: currency.NewEmptyChunk()}
+		blocks = append(blocks, b)
+		if err := db.SaveBlock(ctx, b); err != nil {
+			t.Fatal(err)
+		}
+		last, err := db.LastBlock(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i < 3 && last != nil {
+			t.Fatalf("block %d was committed too early", last.Slot)
+		}
+		if i >= 3 && (last == nil || last.Slot != 3) {
+			t.Fatalf("expected the first three blocks to be committed but got %+v", last)
+		}
+	}
+	if blocks[2].PreviousHash != blocks[1].BlockHash {
+		t.Fatalf("a batch should still be hash-chained")
+	}
+
+	// The pending block and its accounts are answered from memory
+	bob := util.NewKeyPairFromSecretPhrase("bob").PublicKey().String()
+	pending := &Block{Slot: 5, Chunk: currency.NewEmptyChunk()}
+	pending.Chunk.State[bob] = &currency.Account{Sequence: 1, Balance: 7}
+	if err := db.SaveBlock(ctx, pending); err != nil {
+		t.Fatal(err)
+	}
+	b, err := db.GetBlock(ctx, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b == nil || b.ChunkHash != string(pending.Chunk.Hash()) {
+		t.Fatalf("expected the pending block but got %+v", b)
+	}
+	for _, slot := range []int{5, 6} {
+		account, err := db.GetAccountAtSlot(ctx, bob, slot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if account == nil || account.Balance != 7 {
+			t.Fatalf("expected bob's pending balance at slot %d but got %+v", slot, account)
+		}
+	}
+	if account, err := db.GetAccountAtSlot(ctx, bob, 4); err != nil || account != nil {
+		t.Fatalf("bob had no account at slot 4 but got %+v %v", account, err)
+	}
+	if account, err := db.GetAccount(ctx, bob); err != nil || account == nil || account.Balance != 7 {
+		t.Fatalf("expected bob's pending balance but got %+v %v", account, err)
+	}
+	if err := db.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	last, err := db.LastBlock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.Slot != 5 || last.PreviousHash != blocks[3].BlockHash {
+		t.Fatalf("flush did not commit the last blocks: %+v", last)
+	}
+}
+
+func TestBlockHashChain(t *testing.T) {
+	DropTestData(0)
+	db := NewTestDatabase(0)
+	ctx := context.Background()
+	for i := 1; i <= 2; i++ {
+		err := db.InsertBlock(ctx, &Block{Slot: i, Chunk: currency.NewEmptyChunk()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	b1, err := db.GetBlock(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b2, err := db.GetBlock(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b1.BlockHash == "" || b2.PreviousHash != b1.BlockHash {
+		t.Fatalf("bad hash chain: %+v %+v", b1, b2)
+	}
+}
+
+// Blocks saved before the derived fields existed get them when the database
+// is opened, along with every block after them.
+func TestBackfillBlocks(t *testing.T) {
+	DropTestData(0)
+	db := NewTestDatabase(0)
+	ctx := context.Background()
+	for i := 1; i <= 3; i++ {
+		err := db.InsertBlock(ctx, &Block{Slot: i, Chunk: currency.NewEmptyChunk()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := db.GetBlock(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.postgres.MustExec("UPDATE blocks SET block_hash='', previous_hash='' WHERE slot=1")
+	db.postgres.MustExec("UPDATE blocks SET previous_hash='' WHERE slot=2")
+
+	db = NewTestDatabase(0)
+	b3, err := db.GetBlock(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b3.BlockHash != want.BlockHash || b3.PreviousHash != want.PreviousHash {
+		t.Fatalf("expected %+v but got %+v", want, b3)
+	}
+}
+
+func TestChunksAreDeduplicated(t *testing.T) {
+	DropTestData(0)
+	db := NewTestDatabase(0)
+	ctx := context.Background()
+	for slot := 1; slot <= 3; slot++ {
+		b := &Block{Slot: slot, Chunk: currency.NewEmptyChunk()}
+		if err := db.InsertBlock(ctx, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var count int
+	if err := db.postgres.Get(&count, "SELECT COUNT(*) FROM chunks"); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("expected identical chunks to be stored once but got %d", count)
+	}
+
+	b, err := db.GetBlock(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Chunk == nil || b.ChunkHash != string(b.Chunk.Hash()) {
+		t.Fatalf("bad block: %+v", b)
+	}
+	chunk, err := db.GetChunk(ctx, b.ChunkHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chunk == nil || chunk.Hash() != b.Chunk.Hash() {
+		t.Fatalf("could not get the chunk by hash: %+v", chunk)
+	}
+	chunk, err = db.GetChunk(ctx, "nonexistent")
+	if chunk != nil || err != nil {
+		t.Fatalf("expected no chunk but got %+v, %v", chunk, err)
+	}
+
+	// Blocks from before chunks were stored by hash still load
+	db.postgres.MustExec("INSERT INTO blocks (slot, chunk, c, h) VALUES (4, $1, 0, 0)",
+		currency.NewEmptyChunk())
+	b, err = db.GetBlock(ctx, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Chunk == nil || b.ChunkHash != "" {
+		t.Fatalf("bad old-style block: %+v", b)
+	}
+}
+
+func TestGetAccountAtSlot(t *testing.T) {
+	DropTestData(0)
+	db := NewTestDatabase(0)
+	ctx := context.Background()
+	for i := 1; i <= 3; i++ {
+		chunk := currency.NewEmptyChunk()
+		if i != 2 {
+			chunk.State["bob"] = &currency.Account{Sequence: uint32(i), Balance: uint64(i)}
+		}
+		err := db.InsertBlock(ctx, &Block{Slot: i, Chunk: chunk})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	account, err := db.GetAccountAtSlot(ctx, "bob", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Balance != 1 {
+		t.Fatalf("expected balance 1 at slot 2 but got %+v", account)
+	}
+	account, err = db.GetAccountAtSlot(ctx, "bob", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Balance != 3 {
+		t.Fatalf("expected balance 3 at slot 3 but got %+v", account)
+	}
+	account, err = db.GetAccountAtSlot(ctx, "alice", 3)
+	if err != nil || account != nil {
+		t.Fatalf("expected no data for alice but got %+v %+v", account, err)
+	}
+}
+
+func TestAccountActivity(t *testing.T) {
+	DropTestData(0)
+	db := NewTestDatabase(0)
+	ctx := context.Background()
+	for i := 1; i <= 4; i++ {
+		// These blocks are from before accounts kept track of their activity
+		chunk := currency.NewEmptyChunk()
+		if i <= 3 {
+			// bob's account exists before he has any money
+			chunk.State["bob"] = &currency.Account{Sequence: uint32(i), Balance: uint64(i - 1)}
+		}
+		if i == 1 || i == 4 {
+			chunk.State["carol"] = &currency.Account{Balance: uint64(i)}
+		}
+		if err := db.InsertBlock(ctx, &Block{Slot: i, Chunk: chunk}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	chunk := currency.NewEmptyChunk()
+	chunk.State["dave"] = &currency.Account{Balance: 1, Created: 5, LastActive: 5}
+	if err := db.InsertBlock(ctx, &Block{Slot: 5, Chunk: chunk}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Opening the database again fills in the activity of the old deltas
+	db = NewTestDatabase(0)
+	account, err := db.GetAccount(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Created != 2 || account.LastActive != 3 {
+		t.Fatalf("bad activity for bob: %+v", account)
+	}
+	account, err = db.GetAccountAtSlot(ctx, "carol", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Created != 1 || account.LastActive != 1 {
+		t.Fatalf("bad activity for carol at slot 3: %+v", account)
+	}
+
+	activity, err := db.GetAccountActivity(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if activity.Recent != 3 {
+		t.Fatalf("bad activity for bob: %+v", activity)
+	}
+	activity, err = db.GetAccountActivity(ctx, "alice")
+	if err != nil || activity != nil {
+		t.Fatalf("expected no activity for alice but got %+v %+v", activity, err)
+	}
+	batch, err := db.GetAccountsActivity(ctx, []string{"alice", "bob", "carol"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 2 || batch["bob"].Recent != 3 || batch["carol"].Recent != 2 {
+		t.Fatalf("bad batch activity: %+v", batch)
+	}
+	inactive, err := db.GetInactiveAccounts(ctx, 4, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inactive) != 2 || inactive[0].Owner != "bob" || inactive[1].Owner != "carol" ||
+		inactive[1].Created != 1 || inactive[1].LastActive != 4 {
+		t.Fatalf("bob and carol should be inactive since slot 4, but got %+v", inactive)
+	}
+}
+
+func TestScoreboard(t *testing.T) {
+	DropTestData(0)
+	db := NewTestDatabase(0)
+	ctx := context.Background()
+	validators := []string{"alice", "bob", "carol"}
+	for i := 1; i <= 3; i++ {
+		p := &consensus.Participation{
+			Validators: validators,
+			Nominated:  []string{"alice"},
+			Balloted:   []string{"alice", "bob"},
+			Committed:  []string{"alice", "bob"},
+			Missed:     []string{"carol"},
+		}
+		if i == 3 {
+			p.Committed = []string{"alice"}
+		}
+		block := &Block{Slot: i, Chunk: currency.NewEmptyChunk(), Participation: p}
+		if err := db.InsertBlock(ctx, block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scores, err := db.GetScoreboard(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scores) != 3 {
+		t.Fatalf("expected three validators but got %+v", scores)
+	}
+	alice, bob, carol := scores[0], scores[1], scores[2]
+	if alice.Validator != "alice" || alice.Slots != 2 || alice.Nominated != 2 ||
+		alice.Committed != 2 {
+		t.Fatalf("bad score for alice: %+v", alice)
+	}
+	if bob.Validator != "bob" || bob.Balloted != 2 || bob.Committed != 1 {
+		t.Fatalf("bad score for bob: %+v", bob)
+	}
+	if carol.Validator != "carol" || carol.Missed != 2 || carol.Balloted != 0 {
+		t.Fatalf("bad score for carol: %+v", carol)
+	}
+}
+
 func TestTotalSizeInfo(t *testing.T) {
 	DropTestData(0)
 	db := NewTestDatabase(0)
+	ctx := context.Background()
 	b := &Block{
 		Slot:  1,
 		Chunk: currency.NewEmptyChunk(),
 		C:     8,
 	}
-	err := db.InsertBlock(b)
+	err := db.InsertBlock(ctx, b)
 	if err != nil {
 		t.Fatalf("could not save. got error: %s", err)
 	}
-	log.Print(db.TotalSizeInfo())
+	log.Print(db.TotalSizeInfo(ctx))
 }
 
 func TestGetDocuments(t *testing.T) {
 	DropTestData(0)
 	db := NewTestDatabase(0)
+	ctx := context.Background()
 	for a := 1; a <= 2; a++ {
 		for b := 1; b <= 2; b++ {
 			d := NewDocument(uint64(10*a+b), map[string]interface{}{
 				"a": a,
 				"b": b,
 			})
-			err := db.InsertDocument(d)
+			err := db.InsertDocument(ctx, d)
 			if err != nil {
 				t.Fatal(err)
 			}
 		}
 	}
-	docs := db.GetDocuments(map[string]interface{}{"a": 2, "b": 1}, 2)
+	docs, err := db.GetDocuments(ctx, map[string]interface{}{"a": 2, "b": 1}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(docs) != 1 {
 		t.Fatalf("expected one doc but got: %+v", docs)
 	}
 }
 
+func TestGetDocumentsPage(t *testing.T) {
+	DropTestData(0)
+	db := NewTestDatabase(0)
+	ctx := context.Background()
+	for i := 1; i <= 5; i++ {
+		d := NewDocument(uint64(i), map[string]interface{}{"color": "blue"})
+		if err := db.InsertDocument(ctx, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	match := map[string]interface{}{"color": "blue"}
+	ids := []uint64{}
+	cursor := Cursor("")
+	for pages := 1; ; pages++ {
+		docs, next, err := db.GetDocumentsPage(ctx, match, cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, d := range docs {
+			ids = append(ids, d.Id)
+		}
+		if next == "" {
+			if pages != 3 {
+				t.Fatalf("expected 3 pages but got %d", pages)
+			}
+			break
+		}
+		cursor = next
+	}
+	for i, id := range ids {
+		if id != uint64(i+1) {
+			t.Fatalf("bad page order: %+v", ids)
+		}
+	}
+	if len(ids) != 5 {
+		t.Fatalf("expected 5 docs but got: %+v", ids)
+	}
+
+	_, _, err := db.GetDocumentsPage(ctx, match, Cursor("bogus"), 2)
+	if err != ErrInvalidCursor {
+		t.Fatalf("expected an invalid cursor error but got: %+v", err)
+	}
+}
+
+func TestGetBlocksPage(t *testing.T) {
+	DropTestData(0)
+	db := NewTestDatabase(0)
+	ctx := context.Background()
+	for i := 1; i <= 3; i++ {
+		b := &Block{
+			Slot:  i,
+			Chunk: currency.NewEmptyChunk(),
+		}
+		if err := db.InsertBlock(ctx, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	blocks, next, err := db.GetBlocksPage(ctx, "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 2 || blocks[1].Slot != 2 || next == "" {
+		t.Fatalf("bad first page: %+v %s", blocks, next)
+	}
+	blocks, next, err = db.GetBlocksPage(ctx, next, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 1 || blocks[0].Slot != 3 || next != "" {
+		t.Fatalf("bad second page: %+v %s", blocks, next)
+	}
+}
+
+func TestAggregateDocuments(t *testing.T) {
+	DropTestData(0)
+	db := NewTestDatabase(0)
+	ctx := context.Background()
+	prices := []interface{}{3, 10, 2.5, "free"}
+	for i, price := range prices {
+		d := NewDocument(uint64(i+1), map[string]interface{}{
+			"kind":  "hat",
+			"price": price,
+		})
+		if err := db.InsertDocument(ctx, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	match := map[string]interface{}{"kind": "hat"}
+	count, err := db.CountDocuments(ctx, match)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 4 {
+		t.Fatalf("expected 4 hats but got %d", count)
+	}
+
+	stats, err := db.AggregateDocuments(ctx, match, "price")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Count != 4 || stats.NumValues != 3 {
+		t.Fatalf("bad counts: %+v", stats)
+	}
+	if stats.Sum != 15.5 || stats.Min != 2.5 || stats.Max != 10 {
+		t.Fatalf("bad aggregates: %+v", stats)
+	}
+
+	stats, err = db.AggregateDocuments(ctx, map[string]interface{}{"kind": "shoe"}, "price")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Count != 0 || stats.NumValues != 0 {
+		t.Fatalf("expected no shoes but got: %+v", stats)
+	}
+}
+
+func TestSearchDocuments(t *testing.T) {
+	DropTestData(0)
+	db := NewTestDatabase(0)
+	db.searchFields = []string{"title", "body"}
+	ctx := context.Background()
+	docs := []map[string]interface{}{
+		{"collection": "posts", "title": "Running fast", "body": "about runners"},
+		{"collection": "posts", "title": "Sleeping", "body": "dreams"},
+		{"collection": "notes", "title": "ran home", "author": "running"},
+	}
+	for i, data := range docs {
+		if err := db.InsertDocument(ctx, NewDocument(uint64(i+1), data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Stemming should match the "Running" title, but the note's author field
+	// isn't designated for search, so it should not match
+	results, err := db.SearchDocuments(ctx, "run", "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Id != 1 {
+		t.Fatalf("expected one result but got: %+v", results)
+	}
+
+	// Only the requested collection should be searched
+	results, err = db.SearchDocuments(ctx, "home", "posts", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Fatalf("expected the collection to filter results but got: %+v", results)
+	}
+	results, err = db.SearchDocuments(ctx, "home", "notes", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Id != 3 {
+		t.Fatalf("expected the note but got: %+v", results)
+	}
+}
+
+func TestTailEvents(t *testing.T) {
+	DropTestData(0)
+	db := NewTestDatabase(0)
+	ctx := context.Background()
+
+	events, cursor, err := db.TailEvents(ctx, "", nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 || cursor != "" {
+		t.Fatalf("expected no events but got %+v, %q", events, cursor)
+	}
+
+	chunk := currency.NewEmptyChunk()
+	chunk.Operations = []*util.SignedOperation{makeSendOperation("bob", "carol", 10)}
+	if err := db.InsertBlock(ctx, &Block{Slot: 1, Chunk: chunk}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertDocument(ctx, NewDocument(1, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	events, cursor, err = db.TailEvents(ctx, "", nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Type != EventAccountDebited ||
+		events[1].Type != EventAccountCredited || events[0].Seq >= events[1].Seq {
+		t.Fatalf("bad first events: %+v", events)
+	}
+	events, cursor, err = db.TailEvents(ctx, cursor, nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Type != EventDocumentCreated || events[0].Slot != 0 {
+		t.Fatalf("bad last event: %+v", events)
+	}
+
+	// Tailing from the end returns the same cursor until there are new events
+	events, next, err := db.TailEvents(ctx, cursor, nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 || next != cursor {
+		t.Fatalf("expected to stay at the end but got %+v, %q", events, next)
+	}
+
+	events, _, err = db.TailEvents(ctx, "", []string{EventAccountCredited}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Type != EventAccountCredited {
+		t.Fatalf("expected only the credit event but got %+v", events)
+	}
+}
+
 func TestGetDocumentsNoResults(t *testing.T) {
 	DropTestData(0)
 	db := NewTestDatabase(0)
-	docs := db.GetDocuments(map[string]interface{}{"blorp": "hi"}, 3)
+	ctx := context.Background()
+	docs, err := db.GetDocuments(ctx, map[string]interface{}{"blorp": "hi"}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(docs) != 0 {
 		t.Fatalf("expected zero docs but got: %+v", docs)
 	}
 }
 
+func TestCanceledContext(t *testing.T) {
+	db := NewTestDatabase(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := db.GetDocuments(ctx, map[string]interface{}{"a": 1}, 1)
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled but got: %+v", err)
+	}
+	_, err = db.LastBlock(ctx)
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled but got: %+v", err)
+	}
+}
+
 const benchmarkMax = 400
 
 func databaseForBenchmarking() *Database {
 	DropTestData(0)
 	db := NewTestDatabase(0)
+	ctx := context.Background()
 	log.Printf("populating db for benchmarking")
 	items := 0
 	for a := 0; a < benchmarkMax; a++ {
@@ -162,7 +906,7 @@ func databaseForBenchmarking() *Database {
 				"b": b,
 				"c": c,
 			})
-			err := db.InsertDocument(d)
+			err := db.InsertDocument(ctx, d)
 			if err != nil {
 				log.Fatal(err)
 			}
@@ -173,13 +917,42 @@ func databaseForBenchmarking() *Database {
 	return db
 }
 
+func BenchmarkForBlocks(b *testing.B) {
+	DropTestData(0)
+	db := NewTestDatabase(0)
+	ctx := context.Background()
+	mint := util.NewKeyPairFromSecretPhrase("mint")
+	for i := 1; i <= 2000; i++ {
+		chunk := currency.NewEmptyChunk()
+		for j := 0; j < 10; j++ {
+			chunk.Operations = append(chunk.Operations, util.NewSignedOperation(
+				&currency.SendOperation{
+					Signer:   mint.PublicKey().String(),
+					Sequence: uint32(10*i + j),
+					To:       mint.PublicKey().String(),
+				}, mint))
+		}
+		if err := db.InsertBlock(ctx, &Block{Slot: i, Chunk: chunk}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		count, err := db.ForBlocks(ctx, func(block *Block) {})
+		if err != nil || count != 2000 {
+			log.Fatalf("replay failed after %d blocks: %s", count, err)
+		}
+	}
+}
+
 func BenchmarkOneConstraint(b *testing.B) {
 	db := databaseForBenchmarking()
+	ctx := context.Background()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		c := i%(benchmarkMax*benchmarkMax) + 1
-		docs := db.GetDocuments(map[string]interface{}{"c": c}, 2)
-		if len(docs) != 1 {
+		docs, err := db.GetDocuments(ctx, map[string]interface{}{"c": c}, 2)
+		if err != nil || len(docs) != 1 {
 			log.Fatalf("expected one doc for c = %d but got: %+v", c, docs)
 		}
 	}
@@ -187,12 +960,13 @@ func BenchmarkOneConstraint(b *testing.B) {
 
 func BenchmarkTwoConstraints(b *testing.B) {
 	db := databaseForBenchmarking()
+	ctx := context.Background()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		a := i % benchmarkMax
 		b := ((i - a) / benchmarkMax) % benchmarkMax
-		docs := db.GetDocuments(map[string]interface{}{"a": a, "b": b}, 2)
-		if len(docs) != 1 {
+		docs, err := db.GetDocuments(ctx, map[string]interface{}{"a": a, "b": b}, 2)
+		if err != nil || len(docs) != 1 {
 			log.Fatalf("expected one doc but got: %+v", docs)
 		}
 	}
@@ -205,3 +979,104 @@ func TestMain(m *testing.M) {
 	DropTestData(0)
 	os.Exit(answer)
 }
+
+func TestForks(t *testing.T) {
+	DropTestData(0)
+	db := NewTestDatabase(0)
+	ctx := context.Background()
+	qs, _ := consensus.MakeTestQuorumSlice(4)
+	bob := util.NewKeyPairFromSecretPhrase("bob")
+	e := &consensus.ExternalizeMessage{I: 7, X: "theirs", Cn: 1, Hn: 1, D: qs}
+	f := &Fork{
+		Slot:        7,
+		Stored:      "ours",
+		Conflicting: "theirs",
+		Messages: map[string]string{
+			bob.PublicKey().String(): util.NewSignedMessage(e, bob).Serialize(),
+		},
+	}
+	if err := db.InsertFork(ctx, f); err != nil {
+		t.Fatal(err)
+	}
+	forks, err := db.GetForks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(forks) != 1 || forks[0].Slot != 7 || forks[0].Stored != "ours" {
+		t.Fatalf("bad forks: %+v", forks)
+	}
+	signed, err := forks[0].SignedMessages()
+	if err != nil || signed[bob.PublicKey().String()] == nil {
+		t.Fatalf("the saved evidence should still check out: %v", err)
+	}
+}
+
+func TestGetAccounts(t *testing.T) {
+	DropTestData(0)
+	db := NewTestDatabase(0)
+	ctx := context.Background()
+	for slot := 1; slot <= 2; slot++ {
+		chunk := currency.NewEmptyChunk()
+		chunk.State["bob"] = &currency.Account{Sequence: uint32(slot), Balance: uint64(10 * slot)}
+		if slot == 1 {
+			chunk.State["carol"] = &currency.Account{Sequence: 1, Balance: 5}
+		}
+		if err := db.InsertBlock(ctx, &Block{Slot: slot, Chunk: chunk}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A fresh handle has nothing cached, so this has to use the query
+	db = NewTestDatabase(0)
+	accounts, err := db.GetAccounts(ctx, []string{"bob", "carol", "dave", "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 3 || accounts["bob"].Balance != 20 ||
+		accounts["carol"].Balance != 5 || accounts["dave"] != nil {
+		t.Fatalf("bad accounts: %+v", accounts)
+	}
+	if db.AccountCacheStats().Misses != 3 {
+		t.Fatalf("each account should miss the cache once: %+v", db.AccountCacheStats())
+	}
+}
+
+func TestGetBlockDelta(t *testing.T) {
+	DropTestData(0)
+	db := NewTestDatabase(0)
+	ctx := context.Background()
+	chunk := currency.NewEmptyChunk()
+	chunk.State["bob"] = &currency.Account{Sequence: 1, Balance: 10}
+	chunk.State["carol"] = &currency.Account{Sequence: 0, Balance: 5}
+	if err := db.InsertBlock(ctx, &Block{Slot: 1, Chunk: chunk}); err != nil {
+		t.Fatal(err)
+	}
+	chunk = currency.NewEmptyChunk()
+	chunk.State["bob"] = &currency.Account{Sequence: 2, Balance: 7}
+	chunk.State["carol"] = &currency.Account{
+		Sequence: 0, Balance: 5, Data: map[string]string{"name": "carol"}}
+	chunk.State["dave"] = &currency.Account{Sequence: 0, Balance: 3}
+	if err := db.InsertBlock(ctx, &Block{Slot: 2, Chunk: chunk}); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := db.GetBlockDelta(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("only bob and dave changed their balance or sequence: %+v", changes)
+	}
+	bob, dave := changes[0], changes[1]
+	if bob.Owner != "bob" || !bob.Existed || bob.SequenceBefore != 1 ||
+		bob.BalanceBefore != 10 || bob.SequenceAfter != 2 || bob.BalanceAfter != 7 {
+		t.Fatalf("bad change for bob: %+v", bob)
+	}
+	if dave.Owner != "dave" || dave.Existed || dave.BalanceBefore != 0 || dave.BalanceAfter != 3 {
+		t.Fatalf("bad change for dave: %+v", dave)
+	}
+	changes, err = db.GetBlockDelta(ctx, 3)
+	if err != nil || len(changes) != 0 {
+		t.Fatalf("there is no block 3: %+v %s", changes, err)
+	}
+}
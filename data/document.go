@@ -2,8 +2,11 @@ package data
 
 import (
 	"encoding/json"
+	"strings"
 
 	"github.com/jmoiron/sqlx/types"
+
+	"github.com/lacker/coinkit/currency"
 )
 
 type Document struct {
@@ -12,7 +15,9 @@ type Document struct {
 	// Naming convention is namedLikeThis.
 	// Some fields are required on every object:
 	// id: a unique integer
-	// TODO: collection, owner, createdAt, updatedAt
+	// Some fields are optional:
+	// collection: a string naming the group this document is in
+	// TODO: owner, createdAt, updatedAt
 	Data types.JSONText
 
 	// Every document has a unique id. It is stored twice in the
@@ -43,3 +48,55 @@ func NewDocument(id uint64, data map[string]interface{}) *Document {
 		Id:   id,
 	}
 }
+
+// SearchText returns the text from the provided fields that should be indexed
+// for full-text search. Fields that are missing or not strings are skipped.
+func (d *Document) SearchText(fields []string) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	data := map[string]interface{}{}
+	if err := d.Data.Unmarshal(&data); err != nil {
+		panic(err)
+	}
+	parts := []string{}
+	for _, field := range fields {
+		if value, ok := data[field].(string); ok {
+			parts = append(parts, value)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// References returns the ids of the documents that the provided fields of
+// this document refer to. Fields that are missing or aren't ids are skipped.
+func (d *Document) References(fields []string) []uint64 {
+	if len(fields) == 0 {
+		return nil
+	}
+	data := map[string]interface{}{}
+	if err := d.Data.Unmarshal(&data); err != nil {
+		panic(err)
+	}
+	answer := []uint64{}
+	for _, field := range fields {
+		if id, ok := currency.DocumentReference(data[field]); ok {
+			answer = append(answer, id)
+		}
+	}
+	return answer
+}
+
+// DocumentStats summarizes a set of documents.
+type DocumentStats struct {
+	// How many documents there are
+	Count int
+
+	// How many of the documents have a number for the aggregated field.
+	// Sum, Min, and Max are only meaningful when this is positive.
+	NumValues int
+
+	Sum float64
+	Min float64
+	Max float64
+}
@@ -0,0 +1,198 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/types"
+	"github.com/lib/pq"
+
+	"github.com/lacker/coinkit/currency"
+)
+
+// A DocumentUpdate is an UpdateDocumentOperation from a block, ready to be
+// applied to the document store.
+type DocumentUpdate struct {
+	Slot   int
+	Signer string
+
+	// The revision the update expects to replace, zero for a new document
+	Revision int
+
+	// The ids of the documents that must exist for the update to happen
+	References []uint64
+
+	// The slot the document expires at, zero if it doesn't expire
+	ExpiresAtSlot int
+
+	// The document as the update writes it, with its id and owner filled in
+	Document *Document
+}
+
+// DocumentUpdates returns the document updates in this block, in order.
+func (b *Block) DocumentUpdates() []*DocumentUpdate {
+	answer := []*DocumentUpdate{}
+	if b.Chunk == nil {
+		return answer
+	}
+	for _, op := range b.Chunk.Operations {
+		update, ok := op.Operation.(*currency.UpdateDocumentOperation)
+		if !ok {
+			continue
+		}
+		data := map[string]interface{}{"owner": update.Signer}
+		if update.ExpiresAtSlot != 0 {
+			data["expiresAtSlot"] = update.ExpiresAtSlot
+		}
+		for key, value := range update.Data {
+			data[key] = value
+		}
+		references := []uint64{}
+		for _, field := range update.References {
+			id, _ := currency.DocumentReference(update.Data[field])
+			references = append(references, id)
+		}
+		answer = append(answer, &DocumentUpdate{
+			Slot:          b.Slot,
+			Signer:        update.Signer,
+			Revision:      update.Revision,
+			References:    references,
+			ExpiresAtSlot: update.ExpiresAtSlot,
+			Document:      NewDocument(update.Id, data),
+		})
+	}
+	return answer
+}
+
+// A DocumentRevision is one version of a document that an operation wrote.
+// The first revision is 1, and each update by the owner adds one.
+type DocumentRevision struct {
+	Id       uint64
+	Revision int
+
+	// The slot of the block with the update
+	Slot int
+
+	// Who wrote this revision
+	Updater string
+
+	// The whole document, as of this revision
+	Data types.JSONText
+}
+
+// applyDocumentUpdate writes a document update within a transaction, and
+// returns the event for it. The first update creates the document. Later
+// updates by its owner replace it. Updates by anyone else, updates that
+// expect a different revision than the current one, updates that refer to
+// documents that don't exist or have expired, and updates that have already
+// expired themselves are skipped, in which case the event is nil.
+// Every write is also saved as a revision. When the database keeps a limited
+// number of revisions, the oldest ones are pruned.
+func (db *Database) applyDocumentUpdate(
+	ctx context.Context, tx *sqlx.Tx, u *DocumentUpdate) (*Event, error) {
+	d := u.Document
+	if u.ExpiresAtSlot != 0 && u.ExpiresAtSlot <= u.Slot {
+		return nil, nil
+	}
+	ok, err := documentsExist(ctx, tx, u.References, u.Slot)
+	if err != nil || !ok {
+		return nil, err
+	}
+	expiry := sql.NullInt64{Int64: int64(u.ExpiresAtSlot), Valid: u.ExpiresAtSlot != 0}
+	var owner sql.NullString
+	revision := 0
+	err = tx.QueryRowxContext(ctx,
+		"SELECT owner, revision FROM documents WHERE id=$1 FOR UPDATE",
+		d.Id).Scan(&owner, &revision)
+	eventType := EventDocumentUpdated
+	switch {
+	case err == sql.ErrNoRows:
+		if u.Revision != 0 {
+			// The document this update expects isn't there
+			return nil, nil
+		}
+		eventType = EventDocumentCreated
+		_, err = tx.ExecContext(ctx, `
+INSERT INTO documents (id, data, search, owner, revision, expires_at_slot)
+VALUES ($1, $2, to_tsvector('english', $3), $4, 1, $5)`,
+			d.Id, d.Data, d.SearchText(db.searchFields), u.Signer, expiry)
+	case err != nil:
+	case !owner.Valid || owner.String != u.Signer:
+		// Only the owner can update a document
+		return nil, nil
+	case revision != u.Revision:
+		// Another update got here first
+		return nil, nil
+	default:
+		_, err = tx.ExecContext(ctx, `
+UPDATE documents
+SET data=$2, search=to_tsvector('english', $3), revision=$4, expires_at_slot=$5
+WHERE id=$1`,
+			d.Id, d.Data, d.SearchText(db.searchFields), revision+1, expiry)
+	}
+	if err = checkError(ctx, err); err != nil {
+		return nil, err
+	}
+	revision++
+
+	_, err = tx.ExecContext(ctx, `
+INSERT INTO document_revisions (id, revision, slot, updater, data)
+VALUES ($1, $2, $3, $4, $5)`,
+		d.Id, revision, u.Slot, u.Signer, d.Data)
+	if err = checkError(ctx, err); err != nil {
+		return nil, err
+	}
+	if db.documentRevisions > 0 && revision > db.documentRevisions {
+		_, err = tx.ExecContext(ctx,
+			"DELETE FROM document_revisions WHERE id=$1 AND revision<=$2",
+			d.Id, revision-db.documentRevisions)
+		if err = checkError(ctx, err); err != nil {
+			return nil, err
+		}
+	}
+	return newEvent(u.Slot, eventType, d.Data), nil
+}
+
+// documentsExist returns whether there are documents with all of these ids
+// that haven't expired as of the slot.
+func documentsExist(
+	ctx context.Context, tx *sqlx.Tx, ids []uint64, slot int) (bool, error) {
+	if len(ids) == 0 {
+		return true, nil
+	}
+	distinct := map[uint64]bool{}
+	keys := []int64{}
+	for _, id := range ids {
+		if !distinct[id] {
+			distinct[id] = true
+			keys = append(keys, int64(id))
+		}
+	}
+	count := 0
+	err := tx.GetContext(ctx, &count, `
+SELECT COUNT(*) FROM documents
+WHERE id = ANY($1) AND (expires_at_slot IS NULL OR expires_at_slot > $2)`,
+		pq.Array(keys), slot)
+	if err = checkError(ctx, err); err != nil {
+		return false, err
+	}
+	return count == len(distinct), nil
+}
+
+// GetDocumentHistory returns the revisions of a document that the database
+// still has, oldest first. The last one is the current document.
+// Documents that no operation wrote, like messages, have no history.
+// It only returns an error if the context is done, or ErrQueryCanceled if
+// the statement timeout cancels the query.
+func (db *Database) GetDocumentHistory(
+	ctx context.Context, id uint64) ([]*DocumentRevision, error) {
+	answer := []*DocumentRevision{}
+	err := db.postgres.SelectContext(ctx, &answer, `
+SELECT id, revision, slot, updater, data FROM document_revisions
+WHERE id=$1 ORDER BY revision`, id)
+	if err = checkError(ctx, err); err != nil {
+		return nil, err
+	}
+	return answer, nil
+}
@@ -0,0 +1,140 @@
+package data
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/util"
+)
+
+func makeUpdateDocumentOperation(signer string, sequence uint32, id uint64,
+	revision int, title string) *util.SignedOperation {
+	kp := util.NewKeyPairFromSecretPhrase(signer)
+	return util.NewSignedOperation(&currency.UpdateDocumentOperation{
+		Signer:   kp.PublicKey().String(),
+		Sequence: sequence,
+		Id:       id,
+		Revision: revision,
+		Data:     map[string]interface{}{"title": title},
+	}, kp)
+}
+
+func TestBlockDocumentUpdates(t *testing.T) {
+	chunk := currency.NewEmptyChunk()
+	chunk.Operations = []*util.SignedOperation{
+		makeSendOperation("carol", "dave", 20),
+		makeUpdateDocumentOperation("alice", 1, 5, 0, "hello"),
+	}
+	b := &Block{Slot: 3, Chunk: chunk}
+	updates := b.DocumentUpdates()
+	if len(updates) != 1 {
+		t.Fatalf("expected 1 update but got %d", len(updates))
+	}
+	alice := util.NewKeyPairFromSecretPhrase("alice").PublicKey().String()
+	u := updates[0]
+	if u.Slot != 3 || u.Signer != alice || u.Document.Id != 5 {
+		t.Fatalf("bad update: %+v", u)
+	}
+	fields := map[string]interface{}{}
+	if err := u.Document.Data.Unmarshal(&fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields["owner"] != alice || fields["title"] != "hello" {
+		t.Fatalf("bad document: %s", u.Document)
+	}
+}
+
+func TestDocumentHistory(t *testing.T) {
+	DropTestData(0)
+	config := NewTestConfig(0)
+	config.DocumentRevisions = 2
+	db := NewDatabase(config)
+	ctx := context.Background()
+	ops := []*util.SignedOperation{
+		makeUpdateDocumentOperation("alice", 1, 5, 0, "first"),
+		makeUpdateDocumentOperation("bob", 1, 5, 1, "hijacked"),
+		makeUpdateDocumentOperation("alice", 2, 5, 1, "second"),
+		makeUpdateDocumentOperation("alice", 3, 5, 1, "stale"),
+		makeUpdateDocumentOperation("alice", 4, 5, 2, "third"),
+	}
+	for i, op := range ops {
+		chunk := currency.NewEmptyChunk()
+		chunk.Operations = []*util.SignedOperation{op}
+		if err := db.InsertBlock(ctx, &Block{Slot: i + 1, Chunk: chunk}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	alice := util.NewKeyPairFromSecretPhrase("alice").PublicKey().String()
+	docs, err := db.GetDocuments(ctx, map[string]interface{}{"owner": alice}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 || docs[0].Id != 5 {
+		t.Fatalf("expected alice to own document 5: %+v", docs)
+	}
+	fields := map[string]interface{}{}
+	if err := docs[0].Data.Unmarshal(&fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields["title"] != "third" {
+		t.Fatalf("bob should not be able to update alice's document: %s", docs[0])
+	}
+
+	history, err := db.GetDocumentHistory(ctx, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("only the last 2 revisions should be kept: %+v", history)
+	}
+	if history[0].Revision != 2 || history[0].Slot != 3 ||
+		history[1].Revision != 3 || history[1].Slot != 5 ||
+		history[1].Updater != alice {
+		t.Fatalf("bad history: %+v", history)
+	}
+}
+
+func TestDocumentReferences(t *testing.T) {
+	DropTestData(0)
+	db := NewTestDatabase(0)
+	ctx := context.Background()
+	post := func(sequence uint32, id uint64, author uint64) *util.SignedOperation {
+		kp := util.NewKeyPairFromSecretPhrase("alice")
+		return util.NewSignedOperation(&currency.UpdateDocumentOperation{
+			Signer:     kp.PublicKey().String(),
+			Sequence:   sequence,
+			Id:         id,
+			Data:       map[string]interface{}{"author": author},
+			References: []string{"author"},
+		}, kp)
+	}
+	ops := []*util.SignedOperation{
+		makeUpdateDocumentOperation("bob", 1, 1, 0, "bob"),
+		post(1, 2, 1),
+		post(2, 3, 4),
+	}
+	for i, op := range ops {
+		chunk := currency.NewEmptyChunk()
+		chunk.Operations = []*util.SignedOperation{op}
+		if err := db.InsertBlock(ctx, &Block{Slot: i + 1, Chunk: chunk}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	alice := util.NewKeyPairFromSecretPhrase("alice").PublicKey().String()
+	posts, err := db.GetDocuments(ctx, map[string]interface{}{"owner": alice}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 1 || posts[0].Id != 2 {
+		t.Fatalf("a post by a missing author should be skipped: %+v", posts)
+	}
+	authors, err := db.GetReferencedDocuments(ctx, posts, []string{"author"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(authors) != 1 || authors[0].Id != 1 {
+		t.Fatalf("bad referenced documents: %+v", authors)
+	}
+}
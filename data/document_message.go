@@ -0,0 +1,120 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/lacker/coinkit/util"
+)
+
+// A DocumentMessage is used for querying the document store. Like an
+// AccountMessage, this is client-server rather than peer-peer.
+// The client sends a DocumentMessage with just the query, and the server
+// sends one back with the results filled in.
+type DocumentMessage struct {
+	// The query is over all documents whose data contains Match.
+	Match map[string]interface{}
+
+	// When Field is nonempty, the server also aggregates over this numeric field.
+	Field string
+
+	// When Search is nonempty, this is a full-text search instead of an
+	// aggregate query. The search is restricted to Collection if it's nonempty,
+	// and returns up to Limit documents.
+	Search     string
+	Collection string
+	Limit      int
+
+	// When List is true, this lists up to Limit documents whose data contains
+	// Match, in order of id, instead of aggregating over them.
+	List bool
+
+	// For lists, Cursor is where the page starts. It is empty for the first
+	// page.
+	Cursor Cursor `json:",omitempty"`
+
+	// For searches and lists, the server also sends the documents that these
+	// fields of the results refer to, so the client doesn't need to ask for
+	// them one at a time.
+	Expand []string
+
+	// Stats is filled in by the server for aggregate queries.
+	Stats *DocumentStats
+
+	// Documents is filled in by the server for searches and lists.
+	Documents []*Document
+
+	// Referenced is filled in by the server with the documents that the
+	// Expand fields of Documents refer to, in order of id.
+	Referenced []*Document
+
+	// Next is filled in by the server for lists, with the cursor for the
+	// page after this one. It is empty when there are no more documents.
+	Next Cursor `json:",omitempty"`
+
+	// Error is set by the server when it could not answer the query.
+	Error string
+}
+
+// IsQuery returns whether this message is a query, rather than a response.
+func (m *DocumentMessage) IsQuery() bool {
+	return m.Stats == nil && m.Documents == nil && m.Error == ""
+}
+
+func (m *DocumentMessage) Slot() int {
+	return 0
+}
+
+func (m *DocumentMessage) MessageType() string {
+	return "D"
+}
+
+func (m *DocumentMessage) String() string {
+	parts := []string{"document"}
+	if m.Match != nil {
+		bytes, err := json.Marshal(m.Match)
+		if err != nil {
+			panic(err)
+		}
+		parts = append(parts, fmt.Sprintf("match=%s", bytes))
+	}
+	if m.Field != "" {
+		parts = append(parts, fmt.Sprintf("field=%s", m.Field))
+	}
+	if m.Search != "" {
+		parts = append(parts, fmt.Sprintf("search=%q", m.Search))
+	}
+	if m.Collection != "" {
+		parts = append(parts, fmt.Sprintf("collection=%s", m.Collection))
+	}
+	if m.List {
+		parts = append(parts, "list")
+	}
+	if m.Cursor != "" {
+		parts = append(parts, fmt.Sprintf("cursor=%s", m.Cursor))
+	}
+	if len(m.Expand) > 0 {
+		parts = append(parts, fmt.Sprintf("expand=%s", strings.Join(m.Expand, ",")))
+	}
+	if m.Documents != nil {
+		parts = append(parts, fmt.Sprintf("documents=%d", len(m.Documents)))
+	}
+	if m.Referenced != nil {
+		parts = append(parts, fmt.Sprintf("referenced=%d", len(m.Referenced)))
+	}
+	if m.Next != "" {
+		parts = append(parts, fmt.Sprintf("next=%s", m.Next))
+	}
+	if m.Stats != nil {
+		parts = append(parts, fmt.Sprintf("count=%d", m.Stats.Count))
+	}
+	if m.Error != "" {
+		parts = append(parts, fmt.Sprintf("error=%q", m.Error))
+	}
+	return strings.Join(parts, " ")
+}
+
+func init() {
+	util.RegisterMessageType(&DocumentMessage{})
+}
@@ -0,0 +1,33 @@
+package data
+
+import (
+	"testing"
+)
+
+func TestSearchText(t *testing.T) {
+	d := NewDocument(1, map[string]interface{}{
+		"title": "hello",
+		"body":  "world",
+		"count": 3,
+	})
+	text := d.SearchText([]string{"title", "count", "missing", "body"})
+	if text != "hello world" {
+		t.Fatalf("unexpected search text: %q", text)
+	}
+	if d.SearchText(nil) != "" {
+		t.Fatalf("expected no search text without search fields")
+	}
+}
+
+func TestReferences(t *testing.T) {
+	d := NewDocument(1, map[string]interface{}{
+		"author": 7,
+		"editor": 7,
+		"title":  "hello",
+		"parent": 1.5,
+	})
+	refs := d.References([]string{"author", "title", "parent", "missing", "editor"})
+	if len(refs) != 2 || refs[0] != 7 || refs[1] != 7 {
+		t.Fatalf("unexpected references: %v", refs)
+	}
+}
@@ -0,0 +1,102 @@
+package data
+
+import (
+	"encoding/json"
+
+	"github.com/jmoiron/sqlx/types"
+
+	"github.com/lacker/coinkit/currency"
+)
+
+// Event types
+const (
+	// Money left an account. Data is an EventData with the amount and fee.
+	EventAccountDebited = "account_debited"
+
+	// Money arrived in an account. Data is an EventData with the amount.
+	EventAccountCredited = "account_credited"
+
+	// A document was created. Data is the document's data.
+	EventDocumentCreated = "document_created"
+
+	// A document was updated by its owner. Data is the document's new data.
+	EventDocumentUpdated = "document_updated"
+
+	// An expired document was purged. Data has just the document's id.
+	EventDocumentPurged = "document_purged"
+)
+
+// An Event is one change to the chain, in a form that is easy for things like
+// indexers to consume without parsing chunks.
+// Events are saved in the same transaction as the change they describe.
+type Event struct {
+	// Seq is assigned by the database. Later events have larger seqs.
+	Seq int64
+
+	// The slot of the block this event happened in. Zero for events that
+	// don't come from a block, like creating a document.
+	Slot int
+
+	Type string
+	Data types.JSONText
+}
+
+// EventData is the data for the account events.
+type EventData struct {
+	Owner     string `json:"owner"`
+	Amount    uint64 `json:"amount"`
+	Fee       uint64 `json:"fee,omitempty"`
+	Signature string `json:"signature"`
+
+	// For money sent to a deposit account, the deposit account, so the owner
+	// can tell which deposit it was
+	Deposit string `json:"deposit,omitempty"`
+}
+
+func newEvent(slot int, eventType string, data interface{}) *Event {
+	bytes, err := json.Marshal(data)
+	if err != nil {
+		panic(err)
+	}
+	return &Event{
+		Slot: slot,
+		Type: eventType,
+		Data: types.JSONText(bytes),
+	}
+}
+
+// deposit returns the deposit account a send is to, or "" if it isn't to one.
+func deposit(send *currency.SendOperation) string {
+	if send.Recipient() == send.To {
+		return ""
+	}
+	return send.To
+}
+
+// Events returns the events for the operations in this block, in order.
+func (b *Block) Events() []*Event {
+	answer := []*Event{}
+	if b.Chunk == nil {
+		return answer
+	}
+	for _, op := range b.Chunk.Operations {
+		send, ok := op.Operation.(*currency.SendOperation)
+		if !ok {
+			continue
+		}
+		answer = append(answer,
+			newEvent(b.Slot, EventAccountDebited, &EventData{
+				Owner:     send.Signer,
+				Amount:    send.Amount,
+				Fee:       send.Fee,
+				Signature: op.Signature,
+			}),
+			newEvent(b.Slot, EventAccountCredited, &EventData{
+				Owner:     send.Recipient(),
+				Amount:    send.Amount,
+				Signature: op.Signature,
+				Deposit:   deposit(send),
+			}))
+	}
+	return answer
+}
@@ -0,0 +1,61 @@
+package data
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/util"
+)
+
+func makeSendOperation(from string, to string, amount uint64) *util.SignedOperation {
+	kp := util.NewKeyPairFromSecretPhrase(from)
+	return util.NewSignedOperation(&currency.SendOperation{
+		Signer:   kp.PublicKey().String(),
+		Sequence: 1,
+		To:       util.NewKeyPairFromSecretPhrase(to).PublicKey().String(),
+		Amount:   amount,
+		Fee:      1,
+	}, kp)
+}
+
+func TestBlockEvents(t *testing.T) {
+	chunk := currency.NewEmptyChunk()
+	chunk.Operations = []*util.SignedOperation{
+		makeSendOperation("bob", "carol", 10),
+		makeSendOperation("carol", "dave", 20),
+	}
+	b := &Block{Slot: 7, Chunk: chunk}
+	events := b.Events()
+	if len(events) != 4 {
+		t.Fatalf("expected 4 events but got %d", len(events))
+	}
+	expected := []struct {
+		eventType string
+		owner     string
+		amount    uint64
+		fee       uint64
+	}{
+		{EventAccountDebited, "bob", 10, 1},
+		{EventAccountCredited, "carol", 10, 0},
+		{EventAccountDebited, "carol", 20, 1},
+		{EventAccountCredited, "dave", 20, 0},
+	}
+	for i, e := range expected {
+		event := events[i]
+		data := &EventData{}
+		if err := json.Unmarshal(event.Data, data); err != nil {
+			t.Fatal(err)
+		}
+		if event.Slot != 7 || event.Type != e.eventType ||
+			data.Owner != util.NewKeyPairFromSecretPhrase(e.owner).PublicKey().String() ||
+			data.Amount != e.amount || data.Fee != e.fee ||
+			data.Signature != chunk.Operations[i/2].Signature {
+			t.Fatalf("event %d was %+v with data %+v", i, event, data)
+		}
+	}
+
+	if len((&Block{Slot: 8, Chunk: currency.NewEmptyChunk()}).Events()) != 0 {
+		t.Fatalf("an empty block should have no events")
+	}
+}
@@ -0,0 +1,53 @@
+package data
+
+import (
+	"context"
+	"sort"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Documents written by an UpdateDocumentOperation can have an expiresAtSlot.
+// Once the chain reaches that slot, queries stop returning the document, and
+// DocumentExpiryGrace slots later it is purged, along with its history.
+// The purge happens as blocks are saved, so every database that saves the
+// same blocks purges the same documents at the same slot.
+
+// DocumentExpiryGrace is how many slots an expired document is kept before it
+// is purged. Until then, its owner can still update it with a later expiry.
+const DocumentExpiryGrace = 1000
+
+// liveDocuments is a condition that leaves out documents that have expired
+// as of the last block.
+const liveDocuments = `(expires_at_slot IS NULL OR
+  expires_at_slot > (SELECT COALESCE(MAX(slot), 0) FROM blocks))`
+
+// purgeExpiredDocuments deletes the documents whose grace period ended at
+// this slot or earlier, within a transaction. It returns the events that
+// record the purge, in order of id.
+func purgeExpiredDocuments(ctx context.Context, tx *sqlx.Tx, slot int) ([]*Event, error) {
+	ids := []int64{}
+	err := tx.SelectContext(ctx, &ids,
+		"DELETE FROM documents WHERE expires_at_slot<=$1 RETURNING id",
+		slot-DocumentExpiryGrace)
+	if err = checkError(ctx, err); err != nil {
+		return nil, err
+	}
+	answer := []*Event{}
+	if len(ids) == 0 {
+		return answer, nil
+	}
+	_, err = tx.ExecContext(ctx,
+		"DELETE FROM document_revisions WHERE id = ANY($1)", pq.Array(ids))
+	if err = checkError(ctx, err); err != nil {
+		return nil, err
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		answer = append(answer, newEvent(slot, EventDocumentPurged, map[string]interface{}{
+			"id": id,
+		}))
+	}
+	return answer, nil
+}
@@ -0,0 +1,65 @@
+package data
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/util"
+)
+
+func TestDocumentExpiry(t *testing.T) {
+	DropTestData(0)
+	db := NewTestDatabase(0)
+	ctx := context.Background()
+	kp := util.NewKeyPairFromSecretPhrase("alice")
+	chunk := currency.NewEmptyChunk()
+	chunk.Operations = []*util.SignedOperation{
+		util.NewSignedOperation(&currency.UpdateDocumentOperation{
+			Signer:        kp.PublicKey().String(),
+			Sequence:      1,
+			Id:            9,
+			Data:          map[string]interface{}{"title": "soon gone"},
+			ExpiresAtSlot: 3,
+		}, kp),
+	}
+	if err := db.InsertBlock(ctx, &Block{Slot: 1, Chunk: chunk}); err != nil {
+		t.Fatal(err)
+	}
+	match := map[string]interface{}{"title": "soon gone"}
+	docs, err := db.GetDocuments(ctx, match, 10)
+	if err != nil || len(docs) != 1 {
+		t.Fatalf("the document should not have expired yet: %+v %s", docs, err)
+	}
+
+	blocks := []*Block{}
+	for slot := 2; slot <= 3+DocumentExpiryGrace; slot++ {
+		blocks = append(blocks, &Block{Slot: slot, Chunk: currency.NewEmptyChunk()})
+	}
+	if err := db.InsertBlocks(ctx, blocks[:2]); err != nil {
+		t.Fatal(err)
+	}
+	docs, err = db.GetDocuments(ctx, match, 10)
+	if err != nil || len(docs) != 0 {
+		t.Fatalf("the document should have expired: %+v %s", docs, err)
+	}
+	history, err := db.GetDocumentHistory(ctx, 9)
+	if err != nil || len(history) != 1 {
+		t.Fatalf("the history should be kept until the purge: %+v %s", history, err)
+	}
+
+	if err := db.InsertBlocks(ctx, blocks[2:]); err != nil {
+		t.Fatal(err)
+	}
+	history, err = db.GetDocumentHistory(ctx, 9)
+	if err != nil || len(history) != 0 {
+		t.Fatalf("the history should be purged: %+v %s", history, err)
+	}
+	events, _, err := db.TailEvents(ctx, "", []string{EventDocumentPurged}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Slot != 3+DocumentExpiryGrace {
+		t.Fatalf("the purge should be recorded: %+v", events)
+	}
+}
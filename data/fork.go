@@ -0,0 +1,89 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jmoiron/sqlx/types"
+
+	"github.com/lacker/coinkit/consensus"
+	"github.com/lacker/coinkit/util"
+)
+
+// A Fork is evidence that a quorum of validators externalized a different
+// value for a slot than the one this node stored. It should never happen, so
+// a node that sees one stops producing blocks until a person looks at it.
+type Fork struct {
+	Slot int `json:"slot"`
+
+	// The value we stored for the slot, and the one the quorum externalized
+	Stored      consensus.SlotValue `json:"stored"`
+	Conflicting consensus.SlotValue `json:"conflicting"`
+
+	// The conflicting externalize messages as their validators signed them,
+	// serialized and keyed by the validator that sent each one, so anyone can
+	// check that a quorum really signed the conflicting value
+	Messages map[string]string `json:"signedMessages"`
+}
+
+func (f *Fork) String() string {
+	return fmt.Sprintf("slot %d was externalized as %s by %d validators, but we stored %s",
+		f.Slot, util.Shorten(string(f.Conflicting)), len(f.Messages),
+		util.Shorten(string(f.Stored)))
+}
+
+// SignedMessages parses the conflicting messages and checks their signatures.
+// It returns an error if any of them is not a valid externalize message
+// signed by the validator it is keyed by.
+func (f *Fork) SignedMessages() (map[string]*util.SignedMessage, error) {
+	answer := make(map[string]*util.SignedMessage)
+	for key, serialized := range f.Messages {
+		sm, err := util.NewSignedMessageFromSerialized(serialized)
+		if err != nil {
+			return nil, fmt.Errorf("the message from %s is invalid: %s", util.Shorten(key), err)
+		}
+		e, ok := sm.Message().(*consensus.ExternalizeMessage)
+		if !ok || sm.Signer() != key || e.I != f.Slot || e.X != f.Conflicting {
+			return nil, fmt.Errorf("the message from %s is not the conflicting one", util.Shorten(key))
+		}
+		answer[key] = sm
+	}
+	return answer, nil
+}
+
+// InsertFork saves the evidence of a fork.
+// It returns an error if the database is read-only, or if the context is done.
+func (db *Database) InsertFork(ctx context.Context, f *Fork) error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		panic(err)
+	}
+	_, err = db.postgres.ExecContext(ctx,
+		"INSERT INTO forks (slot, data) VALUES ($1, $2)", f.Slot, types.JSONText(data))
+	return checkError(ctx, err)
+}
+
+// GetForks returns the evidence of every fork this node has seen, in the
+// order they were saved.
+// It only returns an error if the context is done, or ErrQueryCanceled if
+// the statement timeout cancels the query.
+func (db *Database) GetForks(ctx context.Context) ([]*Fork, error) {
+	rows := []types.JSONText{}
+	err := db.postgres.SelectContext(ctx, &rows, "SELECT data FROM forks ORDER BY seq")
+	if err = checkError(ctx, err); err != nil {
+		return nil, err
+	}
+	answer := []*Fork{}
+	for _, row := range rows {
+		f := &Fork{}
+		if err := json.Unmarshal(row, f); err != nil {
+			panic(err)
+		}
+		answer = append(answer, f)
+	}
+	return answer, nil
+}
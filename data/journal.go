@@ -0,0 +1,189 @@
+package data
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/lacker/coinkit/currency"
+)
+
+// Accounting software wants money movements as double-entry journal
+// entries, where the debits and credits in each entry add up to the same
+// amount. Each operation that moves money is one entry: the signer is
+// debited the amount and the fee, the recipient is credited the amount, and
+// JournalFees is credited the fee.
+
+// JournalFees is the journal account fees go to. Fees leave circulation, so
+// it isn't a real account.
+const JournalFees = "fees"
+
+// The formats a JournalWriter can write
+const (
+	// Comma-separated lines of slot, operation, type, account, debit, and
+	// credit, with a header.
+	JournalCSV = "csv"
+
+	// Transactions for ledger-cli and hledger. Debits are positive and
+	// credits are negative.
+	JournalLedger = "ledger"
+)
+
+// A JournalLine is one side of a journal entry. Only one of Debit and Credit
+// is nonzero.
+type JournalLine struct {
+	Account string
+	Debit   uint64
+	Credit  uint64
+}
+
+// A JournalEntry records how one operation moved money.
+type JournalEntry struct {
+	Slot int
+
+	// The operation's signature, which identifies it
+	Operation string
+
+	// The operation type, like "Send"
+	Type string
+
+	Lines []*JournalLine
+}
+
+// Balanced returns whether the debits add up to the credits.
+func (e *JournalEntry) Balanced() bool {
+	var debits, credits uint64
+	for _, line := range e.Lines {
+		debits += line.Debit
+		credits += line.Credit
+	}
+	return debits == credits
+}
+
+// Journal returns the journal entries for the operations in this block, in
+// order. Operations that don't move any money, like ones with no fee, have
+// no entry.
+func (b *Block) Journal() []*JournalEntry {
+	answer := []*JournalEntry{}
+	if b.Chunk == nil {
+		return answer
+	}
+	for _, op := range b.Chunk.Operations {
+		entry := &JournalEntry{
+			Slot:      b.Slot,
+			Operation: op.Signature,
+			Type:      op.Operation.OperationType(),
+		}
+		fee := op.GetFee()
+		var amount uint64
+		send, ok := op.Operation.(*currency.SendOperation)
+		if ok {
+			amount = send.Amount
+		}
+		if amount+fee == 0 {
+			continue
+		}
+		entry.Lines = append(entry.Lines, &JournalLine{
+			Account: op.GetSigner(),
+			Debit:   amount + fee,
+		})
+		if amount > 0 {
+			entry.Lines = append(entry.Lines, &JournalLine{
+				Account: send.Recipient(),
+				Credit:  amount,
+			})
+		}
+		if fee > 0 {
+			entry.Lines = append(entry.Lines, &JournalLine{
+				Account: JournalFees,
+				Credit:  fee,
+			})
+		}
+		answer = append(answer, entry)
+	}
+	return answer
+}
+
+// A JournalWriter writes journal entries in one of the journal formats.
+type JournalWriter struct {
+	format    string
+	decimals  int
+	commodity string
+	date      string
+
+	csv    *csv.Writer
+	ledger *bufio.Writer
+}
+
+// NewJournalWriter makes a writer for a format. Amounts are written with
+// decimals. The ledger format also needs the commodity to write amounts in,
+// and a date for every transaction, since blocks don't record the time.
+func NewJournalWriter(w io.Writer, format string, decimals int, commodity string,
+	date time.Time) (*JournalWriter, error) {
+	jw := &JournalWriter{
+		format:    format,
+		decimals:  decimals,
+		commodity: commodity,
+		date:      date.Format("2006/01/02"),
+	}
+	switch format {
+	case JournalCSV:
+		jw.csv = csv.NewWriter(w)
+		err := jw.csv.Write([]string{"slot", "operation", "type", "account", "debit", "credit"})
+		if err != nil {
+			return nil, err
+		}
+	case JournalLedger:
+		jw.ledger = bufio.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("unknown journal format: %q", format)
+	}
+	return jw, nil
+}
+
+// amount writes units, or nothing for zero.
+func (jw *JournalWriter) amount(units uint64) string {
+	if units == 0 {
+		return ""
+	}
+	return currency.FormatAmount(units, jw.decimals)
+}
+
+func (jw *JournalWriter) Write(e *JournalEntry) error {
+	if jw.csv != nil {
+		for _, line := range e.Lines {
+			err := jw.csv.Write([]string{
+				strconv.Itoa(e.Slot), e.Operation, e.Type, line.Account,
+				jw.amount(line.Debit), jw.amount(line.Credit),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// The slot is the transaction code, and the operation is a tag
+	fmt.Fprintf(jw.ledger, "%s (%d) %s\n    ; operation: %s\n", jw.date, e.Slot, e.Type, e.Operation)
+	for _, line := range e.Lines {
+		amount := jw.amount(line.Debit)
+		if line.Credit > 0 {
+			amount = "-" + jw.amount(line.Credit)
+		}
+		fmt.Fprintf(jw.ledger, "    coinkit:%s  %s %s\n", line.Account, amount, jw.commodity)
+	}
+	_, err := jw.ledger.WriteString("\n")
+	return err
+}
+
+// Flush writes out anything buffered.
+func (jw *JournalWriter) Flush() error {
+	if jw.csv != nil {
+		jw.csv.Flush()
+		return jw.csv.Error()
+	}
+	return jw.ledger.Flush()
+}
@@ -0,0 +1,100 @@
+package data
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/util"
+)
+
+func TestBlockJournal(t *testing.T) {
+	chunk := currency.NewEmptyChunk()
+	chunk.Operations = []*util.SignedOperation{
+		makeSendOperation("bob", "carol", 10),
+		// Sending nothing still pays a fee
+		makeSendOperation("carol", "dave", 0),
+	}
+	b := &Block{Slot: 7, Chunk: chunk}
+	entries := b.Journal()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries but got %d", len(entries))
+	}
+	bob := util.NewKeyPairFromSecretPhrase("bob").PublicKey().String()
+	carol := util.NewKeyPairFromSecretPhrase("carol").PublicKey().String()
+	expected := [][]JournalLine{
+		{{bob, 11, 0}, {carol, 0, 10}, {JournalFees, 0, 1}},
+		{{carol, 1, 0}, {JournalFees, 0, 1}},
+	}
+	for i, lines := range expected {
+		entry := entries[i]
+		if entry.Slot != 7 || entry.Type != "Send" ||
+			entry.Operation != chunk.Operations[i].Signature || !entry.Balanced() ||
+			len(entry.Lines) != len(lines) {
+			t.Fatalf("entry %d was %+v", i, entry)
+		}
+		for j, line := range lines {
+			if *entry.Lines[j] != line {
+				t.Fatalf("entry %d line %d was %+v", i, j, entry.Lines[j])
+			}
+		}
+	}
+
+	if len((&Block{Slot: 8, Chunk: currency.NewEmptyChunk()}).Journal()) != 0 {
+		t.Fatalf("an empty block should have no entries")
+	}
+}
+
+func TestJournalWriter(t *testing.T) {
+	entry := &JournalEntry{
+		Slot:      3,
+		Operation: "sig",
+		Type:      "Send",
+		Lines: []*JournalLine{
+			{"bob", 1500, 0}, {"carol", 0, 1000}, {JournalFees, 0, 500},
+		},
+	}
+	date := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	buf := &bytes.Buffer{}
+	w, err := NewJournalWriter(buf, JournalCSV, 3, "", date)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	csv := "slot,operation,type,account,debit,credit\n" +
+		"3,sig,Send,bob,1.5,\n" +
+		"3,sig,Send,carol,,1\n" +
+		"3,sig,Send,fees,,0.5\n"
+	if buf.String() != csv {
+		t.Fatalf("bad csv:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	w, err = NewJournalWriter(buf, JournalLedger, 3, "COIN", date)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(entry)
+	w.Flush()
+	ledger := "2020/01/02 (3) Send\n" +
+		"    ; operation: sig\n" +
+		"    coinkit:bob  1.5 COIN\n" +
+		"    coinkit:carol  -1 COIN\n" +
+		"    coinkit:fees  -0.5 COIN\n\n"
+	if buf.String() != ledger {
+		t.Fatalf("bad ledger:\n%s", buf.String())
+	}
+
+	if _, err := NewJournalWriter(buf, "xml", 3, "", date); err == nil ||
+		!strings.Contains(err.Error(), "unknown") {
+		t.Fatal("an unknown format should fail")
+	}
+}
@@ -0,0 +1,44 @@
+package data
+
+import (
+	"github.com/lacker/coinkit/currency"
+)
+
+// MessageCollection is the collection that messages between accounts are
+// saved in. Each message document has these fields:
+// from: the sender's public key
+// to: the recipient's public key
+// ciphertext: the encrypted message, which only the recipient can decrypt
+// slot: the slot of the block the message is in
+// signature: the signature of the operation that sent the message
+const MessageCollection = "messages"
+
+// Message documents are numbered by where their operation is in the chain,
+// starting at MessageDocumentIdBase so that they don't collide with documents
+// that are inserted directly or written by operations.
+const MessageDocumentIdBase = currency.MaxDocumentId + 1
+
+// Documents returns the documents that the operations in this block create,
+// in order.
+func (b *Block) Documents() []*Document {
+	answer := []*Document{}
+	if b.Chunk == nil {
+		return answer
+	}
+	for i, op := range b.Chunk.Operations {
+		message, ok := op.Operation.(*currency.MessageOperation)
+		if !ok {
+			continue
+		}
+		id := MessageDocumentIdBase + uint64(b.Slot)*currency.MaxChunkSize + uint64(i)
+		answer = append(answer, NewDocument(id, map[string]interface{}{
+			"collection": MessageCollection,
+			"from":       message.Signer,
+			"to":         message.To,
+			"ciphertext": message.Ciphertext,
+			"slot":       b.Slot,
+			"signature":  op.Signature,
+		}))
+	}
+	return answer
+}
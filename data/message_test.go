@@ -0,0 +1,41 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/util"
+)
+
+func TestBlockDocuments(t *testing.T) {
+	alice := util.NewKeyPairFromSecretPhrase("alice")
+	bob := util.NewKeyPairFromSecretPhrase("bob")
+	op, err := currency.NewMessageOperation(alice.PublicKey().String(), 1, 0,
+		bob.PublicKey().String(), "hi bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk := currency.NewEmptyChunk()
+	chunk.Operations = []*util.SignedOperation{
+		makeSendOperation("carol", "dave", 20),
+		util.NewSignedOperation(op, alice),
+	}
+	b := &Block{Slot: 3, Chunk: chunk}
+	docs := b.Documents()
+	if len(docs) != 1 {
+		t.Fatalf("expected 1 document but got %d", len(docs))
+	}
+	if docs[0].Id != MessageDocumentIdBase+3*currency.MaxChunkSize+1 {
+		t.Fatalf("bad id: %d", docs[0].Id)
+	}
+	fields := map[string]interface{}{}
+	if err := docs[0].Data.Unmarshal(&fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields["collection"] != MessageCollection ||
+		fields["from"] != alice.PublicKey().String() ||
+		fields["to"] != bob.PublicKey().String() ||
+		fields["ciphertext"] != op.Ciphertext {
+		t.Fatalf("bad document: %s", docs[0])
+	}
+}
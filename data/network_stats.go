@@ -0,0 +1,228 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// NetworkStats are running totals for the whole network as of one slot. A row
+// is saved along with each block, computed from the row for the slot before,
+// so stats never need a scan over history. Blocks saved before a database had
+// the network_stats table aren't counted.
+type NetworkStats struct {
+	Slot int
+
+	// When this node finalized the block
+	FinalizedAt time.Time `db:"finalized_at"`
+
+	// The money supply after the block
+	Supply uint64
+
+	// The fees paid so far, and how much of them went to a fee pool or the
+	// validators instead of being burned
+	Fees            uint64
+	FeesDistributed uint64 `db:"fees_distributed"`
+
+	// The money minted so far
+	Minted uint64
+
+	// The operations processed so far
+	Operations uint64
+
+	// How many accounts hold money
+	Accounts int
+}
+
+// FeesBurned returns how much of the fees paid so far were burned.
+func (s *NetworkStats) FeesBurned() uint64 {
+	return s.Fees - s.FeesDistributed
+}
+
+// A StatsWindow sums up the blocks in a trailing window of time, ending with
+// the last block.
+type StatsWindow struct {
+	Window time.Duration
+
+	// How many blocks and operations were finalized in the window
+	Blocks     int
+	Operations uint64
+
+	// The average time between blocks. Zero when there were none.
+	BlockInterval time.Duration
+
+	// Operations per second
+	Throughput float64
+}
+
+// StatsWindows are the trailing windows that GetNetworkReport sums up.
+var StatsWindows = []time.Duration{time.Minute, time.Hour, 24 * time.Hour}
+
+const networkStatsInsert = `
+INSERT INTO network_stats (slot, finalized_at, supply, fees, fees_distributed, minted, operations, accounts)
+VALUES (:slot, :finalized_at, :supply, :fees, :fees_distributed, :minted, :operations, :accounts)
+`
+
+// nextNetworkStats returns the stats after a block, given the stats before it.
+// It has to run in the block's transaction before the block's account deltas
+// are inserted, since it compares them to the balances before the block.
+// The block's derived fields must already be filled in.
+func nextNetworkStats(ctx context.Context, tx *sqlx.Tx,
+	previous *NetworkStats, b *Block) (*NetworkStats, error) {
+	next := *previous
+	next.Slot = b.Slot
+	next.FinalizedAt = b.FinalizedAt
+	if next.FinalizedAt.IsZero() {
+		next.FinalizedAt = time.Now()
+	}
+	next.Fees += b.TotalFees
+	next.Operations += uint64(b.NumOperations)
+	if b.Issuance != nil {
+		next.FeesDistributed += b.Issuance.FeesDistributed
+		next.Minted += b.Issuance.Minted
+		next.Supply = b.Issuance.Supply
+	} else if next.Supply >= b.TotalFees {
+		// Without knowing better, the fees were burned
+		next.Supply -= b.TotalFees
+	} else {
+		next.Supply = 0
+	}
+
+	deltas := b.AccountDeltas()
+	owners := []string{}
+	for _, delta := range deltas {
+		owners = append(owners, delta.Owner)
+	}
+	rows := []struct {
+		Owner   string
+		Balance uint64
+	}{}
+	err := tx.SelectContext(ctx, &rows,
+		"SELECT DISTINCT ON (owner) owner, balance FROM account_deltas "+
+			"WHERE owner=ANY($1) AND slot<$2 ORDER BY owner, slot DESC",
+		pq.Array(owners), b.Slot)
+	if err = checkError(ctx, err); err != nil {
+		return nil, err
+	}
+	before := make(map[string]uint64)
+	for _, row := range rows {
+		before[row.Owner] = row.Balance
+	}
+	for _, delta := range deltas {
+		if before[delta.Owner] == 0 && delta.Balance > 0 {
+			next.Accounts++
+		}
+		if before[delta.Owner] > 0 && delta.Balance == 0 {
+			next.Accounts--
+		}
+	}
+	return &next, nil
+}
+
+// getNetworkStats returns the stats for a slot from a transaction, or empty
+// stats if there are none.
+func getNetworkStats(ctx context.Context, tx *sqlx.Tx, slot int) (*NetworkStats, error) {
+	answer := &NetworkStats{}
+	err := tx.GetContext(ctx, answer, "SELECT * FROM network_stats WHERE slot=$1", slot)
+	if err == sql.ErrNoRows {
+		return &NetworkStats{}, nil
+	}
+	if err = checkError(ctx, err); err != nil {
+		return nil, err
+	}
+	return answer, nil
+}
+
+// GetNetworkStats returns the stats as of a slot, or nil if there are none.
+// It only returns an error if the context is done, or ErrQueryCanceled if
+// the statement timeout cancels the query.
+func (db *Database) GetNetworkStats(ctx context.Context, slot int) (*NetworkStats, error) {
+	return db.selectNetworkStats(ctx, "WHERE slot=$1", slot)
+}
+
+// LastNetworkStats returns the stats as of the last block, or nil if there
+// are none.
+// It only returns an error if the context is done, or ErrQueryCanceled if
+// the statement timeout cancels the query.
+func (db *Database) LastNetworkStats(ctx context.Context) (*NetworkStats, error) {
+	return db.selectNetworkStats(ctx, "ORDER BY slot DESC LIMIT 1")
+}
+
+func (db *Database) selectNetworkStats(
+	ctx context.Context, where string, args ...interface{}) (*NetworkStats, error) {
+	answer := &NetworkStats{}
+	err := db.postgres.GetContext(ctx, answer, "SELECT * FROM network_stats "+where, args...)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err = checkError(ctx, err); err != nil {
+		return nil, err
+	}
+	return answer, nil
+}
+
+// window sums up the blocks after the last ones finalized before the window
+// started, through last.
+func (db *Database) window(
+	ctx context.Context, last *NetworkStats, window time.Duration) (*StatsWindow, error) {
+	start, err := db.selectNetworkStats(ctx,
+		"WHERE finalized_at<=$1 ORDER BY slot DESC LIMIT 1",
+		last.FinalizedAt.Add(-window))
+	if err != nil {
+		return nil, err
+	}
+	if start == nil {
+		// The window goes back further than the stats do
+		start, err = db.selectNetworkStats(ctx, "ORDER BY slot LIMIT 1")
+		if err != nil {
+			return nil, err
+		}
+	}
+	answer := &StatsWindow{
+		Window:     window,
+		Blocks:     last.Slot - start.Slot,
+		Operations: last.Operations - start.Operations,
+	}
+	elapsed := last.FinalizedAt.Sub(start.FinalizedAt)
+	if answer.Blocks > 0 {
+		answer.BlockInterval = elapsed / time.Duration(answer.Blocks)
+	}
+	if elapsed > 0 {
+		answer.Throughput = float64(answer.Operations) / elapsed.Seconds()
+	}
+	return answer, nil
+}
+
+// GetNetworkReport returns the stats as of the last block, and sums up each
+// of StatsWindows. The report only changes when a block is saved, so it is
+// cached until then. The stats are nil if there are none.
+// It only returns an error if the context is done, or ErrQueryCanceled if
+// the statement timeout cancels the query.
+func (db *Database) GetNetworkReport(ctx context.Context) (*NetworkStats, []*StatsWindow, error) {
+	last, err := db.LastNetworkStats(ctx)
+	if last == nil || err != nil {
+		return nil, nil, err
+	}
+	db.reportMutex.Lock()
+	cached := db.report
+	db.reportMutex.Unlock()
+	if cached != nil && cached.Stats.Slot == last.Slot {
+		return cached.Stats, cached.Windows, nil
+	}
+
+	windows := []*StatsWindow{}
+	for _, w := range StatsWindows {
+		window, err := db.window(ctx, last, w)
+		if err != nil {
+			return nil, nil, err
+		}
+		windows = append(windows, window)
+	}
+	db.reportMutex.Lock()
+	db.report = &NetworkStatsMessage{Stats: last, Windows: windows}
+	db.reportMutex.Unlock()
+	return last, windows, nil
+}
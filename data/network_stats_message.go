@@ -0,0 +1,54 @@
+package data
+
+import (
+	"fmt"
+
+	"github.com/lacker/coinkit/util"
+)
+
+// A NetworkStatsMessage asks a server for the stats of the whole network,
+// like the money supply and recent throughput. Like a DocumentMessage, it is
+// client-server rather than peer-peer.
+// The client sends an empty NetworkStatsMessage, and the server sends one
+// back with the stats filled in.
+type NetworkStatsMessage struct {
+	// The stats as of the last block
+	Stats *NetworkStats
+
+	// The trailing windows, from shortest to longest. See StatsWindows
+	Windows []*StatsWindow
+
+	// Error is set by the server when it could not answer the query.
+	Error string
+}
+
+// IsQuery returns whether this message is a query, rather than a response.
+func (m *NetworkStatsMessage) IsQuery() bool {
+	return m.Stats == nil && m.Error == ""
+}
+
+func (m *NetworkStatsMessage) Slot() int {
+	if m.Stats == nil {
+		return 0
+	}
+	return m.Stats.Slot
+}
+
+func (m *NetworkStatsMessage) MessageType() string {
+	return "T"
+}
+
+func (m *NetworkStatsMessage) String() string {
+	if m.Error != "" {
+		return fmt.Sprintf("network stats error=%q", m.Error)
+	}
+	if m.Stats == nil {
+		return "network stats query"
+	}
+	return fmt.Sprintf("network stats i=%d supply=%d accounts=%d operations=%d",
+		m.Stats.Slot, m.Stats.Supply, m.Stats.Accounts, m.Stats.Operations)
+}
+
+func init() {
+	util.RegisterMessageType(&NetworkStatsMessage{})
+}
@@ -0,0 +1,85 @@
+package data
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/util"
+)
+
+func TestNetworkStats(t *testing.T) {
+	DropTestData(0)
+	db := NewTestDatabase(0)
+	ctx := context.Background()
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	chunk := currency.NewEmptyChunk()
+	chunk.Operations = []*util.SignedOperation{makeSendOperation("bob", "carol", 5)}
+	chunk.State["bob"] = &currency.Account{Sequence: 1, Balance: 10}
+	chunk.State["carol"] = &currency.Account{Balance: 5}
+	err := db.InsertBlock(ctx, &Block{
+		Slot:        1,
+		Chunk:       chunk,
+		FinalizedAt: start,
+		Issuance:    &currency.Issuance{Slot: 1, Fees: 1, Minted: 3, Supply: 102},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Blocks inserted together see each other's balances
+	blocks := []*Block{}
+	for slot := 2; slot <= 3; slot++ {
+		chunk = currency.NewEmptyChunk()
+		chunk.Operations = []*util.SignedOperation{makeSendOperation("carol", "dave", 1)}
+		if slot == 2 {
+			chunk.State["bob"] = &currency.Account{Sequence: 1, Balance: 0}
+			chunk.State["dave"] = &currency.Account{Balance: 3}
+		} else {
+			chunk.State["erin"] = &currency.Account{Balance: 4}
+		}
+		blocks = append(blocks, &Block{
+			Slot:        slot,
+			Chunk:       chunk,
+			FinalizedAt: start.Add(time.Duration(slot-1) * time.Minute),
+			Issuance: &currency.Issuance{
+				Slot: slot, Fees: 1, FeesDistributed: 1, Supply: 102},
+		})
+	}
+	if err := db.InsertBlocks(ctx, blocks); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, windows, err := db.GetNetworkReport(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Slot != 3 || stats.Supply != 102 || stats.Fees != 3 ||
+		stats.FeesDistributed != 2 || stats.FeesBurned() != 1 || stats.Minted != 3 ||
+		stats.Operations != 3 || stats.Accounts != 3 {
+		t.Fatalf("bad stats: %+v", stats)
+	}
+	if len(windows) != len(StatsWindows) {
+		t.Fatalf("expected a summary of every window: %+v", windows)
+	}
+	minute, day := windows[0], windows[2]
+	if minute.Blocks != 1 || minute.Operations != 1 || minute.BlockInterval != time.Minute {
+		t.Fatalf("bad minute: %+v", minute)
+	}
+	if day.Blocks != 2 || day.Operations != 2 || day.BlockInterval != time.Minute ||
+		day.Throughput != 1.0/60 {
+		t.Fatalf("bad day: %+v", day)
+	}
+
+	// The report is cached until the next block
+	_, again, err := db.GetNetworkReport(ctx)
+	if err != nil || again[0] != minute {
+		t.Fatal("the report should have been cached")
+	}
+	earlier, err := db.GetNetworkStats(ctx, 1)
+	if err != nil || earlier.Accounts != 2 || earlier.Supply != 102 {
+		t.Fatalf("bad stats for slot 1: %+v %v", earlier, err)
+	}
+}
@@ -0,0 +1,61 @@
+package data
+
+// A ParticipationRow records how one validator took part in one slot, as
+// this node saw it. The rows for a block are saved along with the block.
+type ParticipationRow struct {
+	Slot      int
+	Validator string
+	Nominated bool
+	Balloted  bool
+	Committed bool
+	Missed    bool
+}
+
+// ParticipationRows returns a row for each validator of the block's slot, or
+// nothing if this node doesn't know who took part.
+func (b *Block) ParticipationRows() []*ParticipationRow {
+	answer := []*ParticipationRow{}
+	p := b.Participation
+	if p == nil {
+		return answer
+	}
+	contains := func(list []string, validator string) bool {
+		for _, v := range list {
+			if v == validator {
+				return true
+			}
+		}
+		return false
+	}
+	for _, v := range p.Validators {
+		answer = append(answer, &ParticipationRow{
+			Slot:      b.Slot,
+			Validator: v,
+			Nominated: contains(p.Nominated, v),
+			Balloted:  contains(p.Balloted, v),
+			Committed: contains(p.Committed, v),
+			Missed:    contains(p.Missed, v),
+		})
+	}
+	return answer
+}
+
+// A ValidatorScore sums up how a validator took part in a range of slots.
+type ValidatorScore struct {
+	Validator string
+
+	// How many of the slots this node recorded the validator for
+	Slots int
+
+	// How many slots the validator nominated a value in
+	Nominated int
+
+	// How many slots the validator sent ballot messages in
+	Balloted int
+
+	// How many slots the validator was part of the quorum that externalized
+	Committed int
+
+	// How many slots this node didn't hear from the validator at all
+	Missed int
+}
@@ -0,0 +1,168 @@
+package mobile
+
+// A small API for wallets on iOS and Android, built with:
+//
+//   gomobile bind github.com/lacker/coinkit/mobile
+//
+// gomobile can't pass unsigned integers, so amounts are decimal strings in
+// coins, like "1.25". Signed operations are submitted by POSTing them to a
+// node's /operations url.
+
+import (
+	"fmt"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/util"
+)
+
+// Decimals is how many decimal places amounts can have.
+const Decimals = currency.Decimals
+
+// The prefix for addresses on the network the wallet uses
+var addressPrefix = util.DefaultAddressPrefix
+
+// SetAddressPrefix sets the prefix for addresses, for networks that don't use
+// the default one.
+func SetAddressPrefix(prefix string) error {
+	if !util.ValidAddressPrefix(prefix) {
+		return fmt.Errorf("the address prefix must be lowercase letters: %q", prefix)
+	}
+	addressPrefix = prefix
+	return nil
+}
+
+// readAddress reads an address, or a 0x public key, and returns the account
+// that operations use. Deposit addresses are allowed.
+func readAddress(address string) (string, error) {
+	account, prefix, err := util.ParseAccountAddress(address)
+	if err != nil {
+		return "", err
+	}
+	if prefix != "" && prefix != addressPrefix {
+		return "", fmt.Errorf("%s is an address for a different network", address)
+	}
+	return account, nil
+}
+
+// A Key is a key pair for one account.
+type Key struct {
+	kp *util.KeyPair
+}
+
+// NewKeyFromPhrase derives a key from a secret phrase. The same phrase always
+// gives the same key.
+func NewKeyFromPhrase(phrase string) *Key {
+	return &Key{kp: util.NewKeyPairFromSecretPhrase(phrase)}
+}
+
+// NewKey generates a random key.
+func NewKey() *Key {
+	return &Key{kp: util.NewKeyPair()}
+}
+
+// ReadKey reads a key that was saved with Serialize.
+func ReadKey(serialized string) (*Key, error) {
+	kp, err := util.DeserializeKeyPair([]byte(serialized))
+	if err != nil {
+		return nil, err
+	}
+	return &Key{kp: kp}, nil
+}
+
+// Serialize returns the key as JSON, private key included, to save it.
+func (k *Key) Serialize() string {
+	return string(k.kp.Serialize())
+}
+
+// Address is what other accounts send money to.
+func (k *Key) Address() string {
+	return k.kp.PublicKey().Address(addressPrefix)
+}
+
+// PublicKey is the 0x public key, which the account is known by in
+// operations and on the chain.
+func (k *Key) PublicKey() string {
+	return k.kp.PublicKey().String()
+}
+
+// ValidAddress returns whether an address is well formed and for this
+// network. 0x public keys are valid too.
+func ValidAddress(address string) bool {
+	_, err := readAddress(address)
+	return err == nil
+}
+
+// ShortAddress abbreviates an address for display, keeping the start and the
+// end.
+func ShortAddress(address string) string {
+	if len(address) <= 20 {
+		return address
+	}
+	return address[:len(addressPrefix)+7] + "..." + address[len(address)-6:]
+}
+
+// ParseAmount checks that an amount in coins is valid, and returns the same
+// amount in nanocoins.
+func ParseAmount(amount string) (string, error) {
+	units, err := currency.ParseAmount(amount, Decimals)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d", units), nil
+}
+
+// FormatAmount converts an amount in nanocoins, like an account's balance, to
+// coins.
+func FormatAmount(units string) (string, error) {
+	n, err := currency.ParseAmount(units, 0)
+	if err != nil {
+		return "", err
+	}
+	return currency.FormatAmount(n, Decimals), nil
+}
+
+// SignSend creates a signed message that sends money to another account.
+// sequence must be one more than the account's current sequence number.
+// The message is what gets POSTed to /operations, and it has to arrive
+// within a minute or so of being signed.
+func (k *Key) SignSend(to string, amount string, fee string, sequence int64) (string, error) {
+	to, err := readAddress(to)
+	if err != nil {
+		return "", err
+	}
+	a, err := currency.ParseAmount(amount, Decimals)
+	if err != nil {
+		return "", err
+	}
+	f, err := currency.ParseAmount(fee, Decimals)
+	if err != nil {
+		return "", err
+	}
+	if sequence < 1 || sequence > int64(^uint32(0)) {
+		return "", fmt.Errorf("invalid sequence number: %d", sequence)
+	}
+	op := &currency.SendOperation{
+		Signer:   k.PublicKey(),
+		Sequence: uint32(sequence),
+		To:       to,
+		Amount:   a,
+		Fee:      f,
+	}
+	sop := util.NewSignedOperation(op, k.kp)
+	sm := util.NewSignedMessage(currency.NewTransactionMessage(sop), k.kp)
+	return sm.Serialize(), nil
+}
+
+// VerifyMessage checks the signature on a serialized signed message and
+// returns the address of who signed it.
+func VerifyMessage(serialized string) (string, error) {
+	sm, err := util.NewSignedMessageFromSerialized(serialized)
+	if err != nil {
+		return "", err
+	}
+	pk, err := util.ReadPublicKey(sm.Signer())
+	if err != nil {
+		return "", err
+	}
+	return pk.Address(addressPrefix), nil
+}
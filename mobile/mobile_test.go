@@ -0,0 +1,70 @@
+package mobile
+
+import (
+	"testing"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/util"
+)
+
+func TestSignSend(t *testing.T) {
+	key := NewKeyFromPhrase("alice")
+	if key.PublicKey() != util.NewKeyPairFromSecretPhrase("alice").PublicKey().String() {
+		t.Fatal("the key should come from the phrase")
+	}
+	if !ValidAddress(key.Address()) || !ValidAddress(key.PublicKey()) {
+		t.Fatalf("%s should be a valid address", key.Address())
+	}
+	saved, err := ReadKey(key.Serialize())
+	if err != nil || saved.Address() != key.Address() {
+		t.Fatalf("could not read the key back: %v", err)
+	}
+
+	bob := NewKeyFromPhrase("bob")
+	serialized, err := key.SignSend(bob.Address(), "1.25", "0.000000001", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := VerifyMessage(serialized)
+	if err != nil || signer != key.Address() {
+		t.Fatalf("the send should be signed by alice: %s %v", signer, err)
+	}
+	sm, err := util.NewSignedMessageFromSerialized(serialized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops := sm.Message().(*currency.TransactionMessage).Operations
+	send := ops[0].Operation.(*currency.SendOperation)
+	if len(ops) != 1 || !ops[0].Verify() || send.To != bob.PublicKey() ||
+		send.Amount != 1250000000 || send.Fee != 1 || send.Sequence != 3 {
+		t.Fatalf("bad send: %s", send)
+	}
+
+	if _, err := key.SignSend("bob", "1", "0", 1); err == nil {
+		t.Fatal("bob is not an address")
+	}
+	if _, err := key.SignSend(bob.Address(), "1.0000000001", "0", 1); err == nil {
+		t.Fatal("amounts can't be smaller than a nanocoin")
+	}
+	if _, err := key.SignSend(bob.Address(), "1", "0", 0); err == nil {
+		t.Fatal("sequence numbers start at 1")
+	}
+	other := util.NewKeyPairFromSecretPhrase("bob").PublicKey().Address("tcoin")
+	if _, err := key.SignSend(other, "1", "0", 1); err == nil {
+		t.Fatal("addresses for other networks should be rejected")
+	}
+}
+
+func TestAmounts(t *testing.T) {
+	units, err := ParseAmount("21000000")
+	if err != nil || units != "21000000000000000" {
+		t.Fatalf("bad units: %s %v", units, err)
+	}
+	coins, err := FormatAmount("1250000000")
+	if err != nil || coins != "1.25" {
+		t.Fatalf("bad coins: %s %v", coins, err)
+	}
+	if _, err := FormatAmount("-1"); err == nil {
+		t.Fatal("-1 is not an amount")
+	}
+}
@@ -0,0 +1,77 @@
+package network
+
+import (
+	"fmt"
+	"net"
+)
+
+// An ACL decides which IP addresses can connect to the validator port.
+// A denied address is always rejected. If there is an allowlist, only
+// addresses on it are accepted. A nil ACL accepts everything.
+type ACL struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	answer := []*net.IPNet{}
+	for _, cidr := range cidrs {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR: %q", cidr)
+		}
+		answer = append(answer, ipnet)
+	}
+	return answer, nil
+}
+
+// NewACL creates an ACL from lists of CIDRs, like "10.0.0.0/8".
+func NewACL(allow []string, deny []string) (*ACL, error) {
+	a, err := parseCIDRs(allow)
+	if err != nil {
+		return nil, err
+	}
+	d, err := parseCIDRs(deny)
+	if err != nil {
+		return nil, err
+	}
+	return &ACL{allow: a, deny: d}, nil
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipnet := range nets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Permits returns whether a connection from this address is acceptable.
+// Addresses that aren't IP addresses are only permitted with no allowlist.
+func (a *ACL) Permits(addr net.Addr) bool {
+	if a == nil {
+		return true
+	}
+	var ip net.IP
+	switch typed := addr.(type) {
+	case *net.TCPAddr:
+		ip = typed.IP
+	case *net.UDPAddr:
+		ip = typed.IP
+	}
+	if ip == nil {
+		return len(a.allow) == 0
+	}
+	if contains(a.deny, ip) {
+		return false
+	}
+	return len(a.allow) == 0 || contains(a.allow, ip)
+}
+
+func (a *ACL) String() string {
+	if a == nil {
+		return "allow all"
+	}
+	return fmt.Sprintf("allow %v, deny %v", a.allow, a.deny)
+}
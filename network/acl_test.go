@@ -0,0 +1,80 @@
+package network
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestACL(t *testing.T) {
+	acl, err := NewACL([]string{"10.0.0.0/8", "::1/128"}, []string{"10.0.0.1/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]bool{
+		"10.1.2.3": true,
+		"10.0.0.1": false,
+		"::1":      true,
+		"1.2.3.4":  false,
+	}
+	for ip, expected := range cases {
+		if acl.Permits(&net.TCPAddr{IP: net.ParseIP(ip)}) != expected {
+			t.Fatalf("expected permitting %s to be %t", ip, expected)
+		}
+	}
+
+	deny, err := NewACL(nil, []string{"1.2.3.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deny.Permits(&net.TCPAddr{IP: net.ParseIP("1.2.3.4")}) ||
+		!deny.Permits(&net.TCPAddr{IP: net.ParseIP("1.2.4.4")}) {
+		t.Fatal("a denylist alone should only reject the addresses on it")
+	}
+	var none *ACL
+	if !none.Permits(&net.TCPAddr{IP: net.ParseIP("1.2.3.4")}) {
+		t.Fatal("a nil ACL should permit everything")
+	}
+	if _, err := NewACL([]string{"10.0.0.0"}, nil); err == nil {
+		t.Fatal("a CIDR needs a prefix length")
+	}
+}
+
+func TestListenEnforcesACL(t *testing.T) {
+	s, _ := newIdleServer(t, 3)
+	defer s.Stop()
+	denied, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	acl, err := NewACL([]string{"10.0.0.0/8"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan bool)
+	go func() {
+		s.listen(denied, acl)
+		close(done)
+	}()
+
+	conn, err := net.Dial("tcp", denied.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("the server should have closed a connection from outside the allowlist")
+	} else if e, ok := err.(net.Error); ok && e.Timeout() {
+		t.Fatal("the connection was never closed")
+	}
+
+	// Closing the listener should stop listen, even though the server is
+	// still running
+	denied.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("listen kept going after its listener closed")
+	}
+}
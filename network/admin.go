@@ -0,0 +1,173 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/pprof"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	runtimepprof "runtime/pprof"
+	"time"
+)
+
+// The admin listener serves diagnostics that shouldn't be public: pprof
+// profiles, goroutine dumps, GC stats, and the consensus state. It only runs
+// when the config sets an admin port.
+
+// How many of the most recent GC pauses GCStats includes
+const recentPauses = 10
+
+// GCStats describes the garbage collector and the heap.
+type GCStats struct {
+	Goroutines int
+
+	NumGC      int64
+	LastGC     time.Time
+	PauseTotal time.Duration
+
+	// The most recent pauses, most recent first
+	Pauses []time.Duration
+
+	HeapAlloc   uint64
+	HeapSys     uint64
+	HeapObjects uint64
+
+	// The heap size that triggers the next GC
+	NextGC uint64
+}
+
+func ReadGCStats() *GCStats {
+	stats := &debug.GCStats{}
+	debug.ReadGCStats(stats)
+	mem := &runtime.MemStats{}
+	runtime.ReadMemStats(mem)
+	answer := &GCStats{
+		Goroutines:  runtime.NumGoroutine(),
+		NumGC:       stats.NumGC,
+		LastGC:      stats.LastGC,
+		PauseTotal:  stats.PauseTotal,
+		Pauses:      stats.Pause,
+		HeapAlloc:   mem.HeapAlloc,
+		HeapSys:     mem.HeapSys,
+		HeapObjects: mem.HeapObjects,
+		NextGC:      mem.NextGC,
+	}
+	if len(answer.Pauses) > recentPauses {
+		answer.Pauses = answer.Pauses[:recentPauses]
+	}
+	return answer
+}
+
+func (s *Server) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	// The standard pprof endpoints, so `go tool pprof` works against them
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	// /debug/goroutines dumps the stack of every goroutine, like a panic does
+	mux.HandleFunc("/debug/goroutines", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		runtimepprof.Lookup("goroutine").WriteTo(w, 2)
+	})
+
+	// /debug/gc returns GC and heap stats, as JSON
+	mux.HandleFunc("/debug/gc", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ReadGCStats())
+	})
+
+	// /debug/retention returns how much old consensus data is kept in
+	// memory, as JSON
+	mux.HandleFunc("/debug/retention", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.RetentionStats())
+	})
+
+	// /debug/consensus returns the nomination and ballot state of the slot
+	// this server is working on, as JSON
+	mux.HandleFunc("/debug/consensus", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(s.ConsensusState())
+	})
+
+	// /debug/consensus/graph returns who has voted for, accepted, or
+	// confirmed which values in the current slot, in the DOT language.
+	// Pass format=json to get JSON instead.
+	mux.HandleFunc("/debug/consensus/graph", func(w http.ResponseWriter, r *http.Request) {
+		g := s.ConsensusGraph()
+		if g == nil {
+			http.Error(w, "the server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Query().Get("format") == "json" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(g)
+			return
+		}
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		fmt.Fprint(w, g.Dot())
+	})
+
+	return mux
+}
+
+// ServeAdminInBackground spawns a goroutine to serve diagnostics on addr,
+// like "127.0.0.1:6060". Anyone who can reach it can profile the server, so
+// it should not be reachable from the internet.
+func (s *Server) ServeAdminInBackground(addr string) {
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: s.adminHandler(),
+	}
+
+	go srv.ListenAndServe()
+
+	go func() {
+		<-s.quit
+		srv.Shutdown(context.Background())
+	}()
+}
+
+// writeGraphsForever should be run in its own goroutine. If GraphDir is set,
+// it writes the consensus graph there as <key>.dot and <key>.json, where key
+// is this server's short name, so each node in a local cluster has its own.
+func (s *Server) writeGraphsForever() {
+	if s.GraphDir == "" {
+		return
+	}
+	ticker := time.NewTicker(s.GraphInterval)
+	defer ticker.Stop()
+	name := filepath.Join(s.GraphDir, s.keyPair.PublicKey().ShortName())
+	for {
+		select {
+		case <-ticker.C:
+			g := s.ConsensusGraph()
+			if g == nil {
+				return
+			}
+			encoded, err := json.MarshalIndent(g, "", "  ")
+			if err != nil {
+				panic(err)
+			}
+			if err := ioutil.WriteFile(name+".json", encoded, 0644); err != nil {
+				s.Logf("could not write the consensus graph: %s", err)
+				continue
+			}
+			if err := ioutil.WriteFile(name+".dot", []byte(g.Dot()), 0644); err != nil {
+				s.Logf("could not write the consensus graph: %s", err)
+			}
+		case <-s.quit:
+			return
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package network
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lacker/coinkit/consensus"
+)
+
+func TestAdminHandler(t *testing.T) {
+	s, kps := newIdleServer(t, 3)
+	defer s.Stop()
+	go s.processMessagesForever()
+	handler := s.adminHandler()
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s failed with %d", path, w.Code)
+		}
+		return w
+	}
+
+	state := &consensus.BlockState{}
+	if err := json.Unmarshal(get("/debug/consensus").Body.Bytes(), state); err != nil {
+		t.Fatal(err)
+	}
+	if state.Slot != 1 || state.Phase != "Prepare" {
+		t.Fatalf("bad consensus state: %+v", state)
+	}
+
+	gc := &GCStats{}
+	if err := json.Unmarshal(get("/debug/gc").Body.Bytes(), gc); err != nil {
+		t.Fatal(err)
+	}
+	if gc.Goroutines == 0 || gc.HeapSys == 0 {
+		t.Fatalf("bad gc stats: %+v", gc)
+	}
+
+	retention := &RetentionStats{}
+	if err := json.Unmarshal(get("/debug/retention").Body.Bytes(), retention); err != nil {
+		t.Fatal(err)
+	}
+	if retention.OldestSlot != 1 || retention.HeapAlloc == 0 {
+		t.Fatalf("bad retention stats: %+v", retention)
+	}
+
+	if !strings.Contains(get("/debug/goroutines").Body.String(), "processMessagesForever") {
+		t.Fatal("the goroutine dump should include the processing goroutine")
+	}
+	get("/debug/pprof/")
+
+	if !strings.HasPrefix(get("/debug/consensus/graph").Body.String(), "digraph slot1") {
+		t.Fatal("the graph should be in DOT by default")
+	}
+	g := &consensus.Graph{}
+	if err := json.Unmarshal(get("/debug/consensus/graph?format=json").Body.Bytes(), g); err != nil {
+		t.Fatal(err)
+	}
+	if g.Self != kps[0].PublicKey().String() || len(g.Validators) != 3 {
+		t.Fatalf("bad graph: %+v", g)
+	}
+}
+
+func TestWriteGraphs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "graphs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, kps := newIdleServer(t, 3)
+	defer s.Stop()
+	s.GraphDir = dir
+	s.GraphInterval = 10 * time.Millisecond
+	go s.processMessagesForever()
+	go s.writeGraphsForever()
+
+	name := filepath.Join(dir, kps[0].PublicKey().ShortName()+".dot")
+	for i := 0; ; i++ {
+		if _, err := os.Stat(name); err == nil {
+			break
+		}
+		if i == 500 {
+			t.Fatal("the graph was never written")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
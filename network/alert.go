@@ -0,0 +1,333 @@
+package network
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lacker/coinkit/util"
+)
+
+// Alerts tell a validator's operator about problems that need a person, like
+// a consensus stall or a database that can't be reached. Each alert goes to
+// every sink whose MinSeverity it meets. A sink can be a webhook, an email
+// sent over SMTP, or the PagerDuty events API.
+//
+// The same problem is only alerted once per alertDedupInterval, so a flapping
+// peer doesn't page anyone every minute.
+
+const (
+	// What an alert can be about
+	AlertStall             = "stall"
+	AlertDatabase          = "database"
+	AlertDiskSpace         = "disk"
+	AlertPeers             = "peers"
+	AlertInvalidSignatures = "invalidSignatures"
+	AlertFork              = "fork"
+
+	// Severities, from least to most severe
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+
+	// Kinds of sinks
+	AlertSinkWebhook   = "webhook"
+	AlertSinkSMTP      = "smtp"
+	AlertSinkPagerDuty = "pagerduty"
+
+	// Where PagerDuty sinks send events, unless their config has a URL
+	PagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+
+	alertDedupInterval = 30 * time.Minute
+	alertMaxAttempts   = 3
+	alertTimeout       = 10 * time.Second
+
+	// How many alerts can wait for a slow sink before new ones get dropped
+	alertQueueSize = 100
+)
+
+var severityRank = map[string]int{
+	SeverityInfo:     1,
+	SeverityWarning:  2,
+	SeverityCritical: 3,
+}
+
+// ValidSeverity returns whether s is one of the severities.
+func ValidSeverity(s string) bool {
+	return severityRank[s] > 0
+}
+
+// An Alert describes one problem with a node.
+type Alert struct {
+	// AlertStall, AlertDatabase, etc
+	Kind string `json:"kind"`
+
+	// SeverityInfo, SeverityWarning, or SeverityCritical
+	Severity string `json:"severity"`
+
+	// The public key of the node with the problem
+	Node string `json:"node"`
+
+	// What is wrong, for a person to read
+	Message string `json:"message"`
+
+	// Identifies the problem, so repeats of it can be deduplicated. Alerts
+	// about different peers have different keys, for example.
+	Key string `json:"key"`
+
+	Time time.Time `json:"time"`
+}
+
+func (a *Alert) String() string {
+	return fmt.Sprintf("[%s] %s: %s", a.Severity, a.Kind, a.Message)
+}
+
+type AlertConfig struct {
+	// AlertSinkWebhook, AlertSinkSMTP, or AlertSinkPagerDuty
+	Kind string
+
+	// The least severe alerts to send. Empty means all of them.
+	MinSeverity string
+
+	// For webhooks, where to POST, and the HMAC key for signing requests the
+	// same way block webhooks are signed.
+	// For PagerDuty, an optional replacement for PagerDutyURL.
+	URL    string
+	Secret string
+
+	// For PagerDuty, the integration's routing key
+	RoutingKey string
+
+	// For SMTP. Username and Password can be empty for servers that don't
+	// need authentication.
+	SMTPHost string
+	SMTPPort int
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+// wants returns whether this sink should get an alert.
+func (c *AlertConfig) wants(a *Alert) bool {
+	if c.MinSeverity == "" {
+		return true
+	}
+	return severityRank[a.Severity] >= severityRank[c.MinSeverity]
+}
+
+// An alertSink sends alerts somewhere.
+type alertSink interface {
+	send(a *Alert) error
+}
+
+func newAlertSink(config *AlertConfig) alertSink {
+	client := &http.Client{Timeout: alertTimeout}
+	switch config.Kind {
+	case AlertSinkWebhook:
+		return &webhookAlertSink{config: config, client: client}
+	case AlertSinkSMTP:
+		return &smtpAlertSink{config: config}
+	case AlertSinkPagerDuty:
+		return &pagerDutyAlertSink{config: config, client: client}
+	}
+	util.Logger.Fatalf("unknown alert sink: %q", config.Kind)
+	return nil
+}
+
+type webhookAlertSink struct {
+	config *AlertConfig
+	client *http.Client
+}
+
+func (s *webhookAlertSink) send(a *Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		panic(err)
+	}
+	request, err := http.NewRequest("POST", s.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(webhookEventHeader, "alert")
+	request.Header.Set(webhookNodeHeader, a.Node)
+	request.Header.Set(webhookSignatureHeader, SignWebhook(s.config.Secret, body))
+	return checkResponse(s.client.Do(request))
+}
+
+type smtpAlertSink struct {
+	config *AlertConfig
+}
+
+func (s *smtpAlertSink) send(a *Alert) error {
+	var auth smtp.Auth
+	if s.config.Username != "" {
+		auth = smtp.PlainAuth("", s.config.Username, s.config.Password, s.config.SMTPHost)
+	}
+	message := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: coinkit %s alert: %s\r\n\r\n"+
+		"%s\r\n\r\nnode: %s\r\ntime: %s\r\n",
+		s.config.From, strings.Join(s.config.To, ", "), a.Severity, a.Kind,
+		a.Message, a.Node, a.Time.Format(time.RFC3339))
+	addr := fmt.Sprintf("%s:%d", s.config.SMTPHost, s.config.SMTPPort)
+	return smtp.SendMail(addr, auth, s.config.From, s.config.To, []byte(message))
+}
+
+// pagerDutyAlertSink sends events in the format of the PagerDuty Events API
+// v2, which other incident tools accept too.
+type pagerDutyAlertSink struct {
+	config *AlertConfig
+	client *http.Client
+}
+
+func (s *pagerDutyAlertSink) send(a *Alert) error {
+	url := s.config.URL
+	if url == "" {
+		url = PagerDutyURL
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"routing_key":  s.config.RoutingKey,
+		"event_action": "trigger",
+		"dedup_key":    a.Node + " " + a.Key,
+		"payload": map[string]interface{}{
+			"summary":   a.Message,
+			"source":    a.Node,
+			"severity":  a.Severity,
+			"class":     a.Kind,
+			"component": "coinkit",
+			"timestamp": a.Time.Format(time.RFC3339),
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return checkResponse(s.client.Post(url, "application/json", bytes.NewReader(body)))
+}
+
+// checkResponse turns a non-2xx response into an error.
+func checkResponse(response *http.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("alert sink responded with status %d", response.StatusCode)
+	}
+	return nil
+}
+
+// An alerter deduplicates alerts and delivers them to the sinks, in its own
+// goroutine.
+// raise is threadsafe, since alerts come from different goroutines.
+type alerter struct {
+	node    string
+	configs []*AlertConfig
+	sinks   []alertSink
+
+	mutex sync.Mutex
+
+	// When each alert key was last sent
+	sent map[string]time.Time
+
+	queue chan *Alert
+	quit  chan bool
+
+	// How long to wait after the first failed attempt. It doubles each time.
+	initialBackoff time.Duration
+}
+
+func newAlerter(node string, quit chan bool) *alerter {
+	return &alerter{
+		node:           node,
+		sent:           make(map[string]time.Time),
+		queue:          make(chan *Alert, alertQueueSize),
+		quit:           quit,
+		initialBackoff: time.Second,
+	}
+}
+
+// addSink must be called before the alerter starts delivering.
+func (a *alerter) addSink(config *AlertConfig, sink alertSink) {
+	a.configs = append(a.configs, config)
+	a.sinks = append(a.sinks, sink)
+}
+
+// raise sends an alert unless the same key was alerted recently.
+// It never blocks. Whether or not it is sent, the alert is logged.
+// It returns whether the alert was queued.
+func (a *alerter) raise(kind string, severity string, key string,
+	format string, args ...interface{}) bool {
+	alert := &Alert{
+		Kind:     kind,
+		Severity: severity,
+		Node:     a.node,
+		Message:  fmt.Sprintf(format, args...),
+		Key:      key,
+		Time:     time.Now(),
+	}
+	util.Logger.Printf("alert %s", alert)
+	if len(a.sinks) == 0 {
+		return false
+	}
+
+	a.mutex.Lock()
+	last, ok := a.sent[key]
+	if ok && alert.Time.Sub(last) < alertDedupInterval {
+		a.mutex.Unlock()
+		return false
+	}
+	a.sent[key] = alert.Time
+	a.mutex.Unlock()
+
+	select {
+	case a.queue <- alert:
+		return true
+	default:
+		util.Logger.Printf("the alert queue is full, dropping %s", alert)
+		return false
+	}
+}
+
+// deliverForever should be run in its own goroutine.
+func (a *alerter) deliverForever() {
+	for {
+		select {
+		case <-a.quit:
+			return
+		case alert := <-a.queue:
+			for i, sink := range a.sinks {
+				if a.configs[i].wants(alert) {
+					a.deliver(a.configs[i], sink, alert)
+				}
+			}
+		}
+	}
+}
+
+// deliver sends one alert to one sink, retrying with backoff until it
+// succeeds, it runs out of attempts, or the server shuts down.
+func (a *alerter) deliver(config *AlertConfig, sink alertSink, alert *Alert) {
+	backoff := a.initialBackoff
+	for attempt := 1; ; attempt++ {
+		err := sink.send(alert)
+		if err == nil {
+			return
+		}
+		if attempt >= alertMaxAttempts {
+			util.Logger.Printf("giving up on the %s alert sink for %s: %s",
+				config.Kind, alert, err)
+			return
+		}
+		select {
+		case <-a.quit:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
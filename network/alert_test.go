@@ -0,0 +1,157 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeAlertSink records alerts, failing the first few sends.
+type fakeAlertSink struct {
+	failures int
+	alerts   chan *Alert
+}
+
+func (s *fakeAlertSink) send(a *Alert) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("down")
+	}
+	s.alerts <- a
+	return nil
+}
+
+func expectAlert(t *testing.T, alerts chan *Alert, kind string) *Alert {
+	select {
+	case a := <-alerts:
+		if a.Kind != kind {
+			t.Fatalf("expected a %s alert but got %s", kind, a)
+		}
+		return a
+	case <-time.After(10 * time.Second):
+		t.Fatalf("no %s alert was sent", kind)
+	}
+	return nil
+}
+
+func TestAlertDeduplication(t *testing.T) {
+	quit := make(chan bool)
+	defer close(quit)
+	a := newAlerter("node", quit)
+	a.initialBackoff = time.Millisecond
+	all := &fakeAlertSink{failures: 1, alerts: make(chan *Alert, 10)}
+	critical := &fakeAlertSink{alerts: make(chan *Alert, 10)}
+	a.addSink(&AlertConfig{Kind: "fake"}, all)
+	a.addSink(&AlertConfig{Kind: "fake", MinSeverity: SeverityCritical}, critical)
+	go a.deliverForever()
+
+	if !a.raise(AlertPeers, SeverityWarning, "peers", "only %d peers", 1) {
+		t.Fatal("the first alert should be sent")
+	}
+	if a.raise(AlertPeers, SeverityWarning, "peers", "only %d peers", 0) {
+		t.Fatal("a repeated alert should be deduplicated")
+	}
+	if !a.raise(AlertStall, SeverityCritical, "stall", "stuck") {
+		t.Fatal("an alert with a different key should be sent")
+	}
+
+	peers := expectAlert(t, all.alerts, AlertPeers)
+	if peers.Message != "only 1 peers" || peers.Node != "node" {
+		t.Fatalf("bad alert: %+v", peers)
+	}
+	expectAlert(t, all.alerts, AlertStall)
+	expectAlert(t, critical.alerts, AlertStall)
+	if len(critical.alerts) != 0 {
+		t.Fatal("a warning should not go to a critical-only sink")
+	}
+}
+
+func TestAlertSinks(t *testing.T) {
+	requests := make(chan *http.Request, 10)
+	bodies := make(chan []byte, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- r
+		bodies <- body
+	}))
+	defer receiver.Close()
+	alert := &Alert{
+		Kind:     AlertDatabase,
+		Severity: SeverityCritical,
+		Node:     "node",
+		Message:  "the database is unreachable",
+		Key:      AlertDatabase,
+		Time:     time.Now(),
+	}
+
+	webhook := newAlertSink(&AlertConfig{
+		Kind: AlertSinkWebhook, URL: receiver.URL, Secret: "secret"})
+	if err := webhook.send(alert); err != nil {
+		t.Fatal(err)
+	}
+	r, body := <-requests, <-bodies
+	if r.Header.Get(webhookEventHeader) != "alert" ||
+		!VerifyWebhookSignature("secret", body, r.Header.Get(webhookSignatureHeader)) {
+		t.Fatalf("bad webhook headers: %+v", r.Header)
+	}
+	decoded := &Alert{}
+	if err := json.Unmarshal(body, decoded); err != nil || decoded.Message != alert.Message {
+		t.Fatalf("bad webhook body: %s", body)
+	}
+
+	pagerDuty := newAlertSink(&AlertConfig{
+		Kind: AlertSinkPagerDuty, URL: receiver.URL, RoutingKey: "key"})
+	if err := pagerDuty.send(alert); err != nil {
+		t.Fatal(err)
+	}
+	<-requests
+	event := struct {
+		RoutingKey string `json:"routing_key"`
+		DedupKey   string `json:"dedup_key"`
+		Payload    struct {
+			Summary  string `json:"summary"`
+			Severity string `json:"severity"`
+		} `json:"payload"`
+	}{}
+	if err := json.Unmarshal(<-bodies, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.RoutingKey != "key" || event.DedupKey != "node database" ||
+		event.Payload.Severity != SeverityCritical {
+		t.Fatalf("bad pagerduty event: %+v", event)
+	}
+}
+
+func TestHealthAlerts(t *testing.T) {
+	s, kps := newIdleServer(t, 4)
+	defer s.Stop()
+	sink := &fakeAlertSink{alerts: make(chan *Alert, 10)}
+	s.alerts.addSink(&AlertConfig{Kind: "fake"}, sink)
+	go s.alerts.deliverForever()
+	s.Health.MinPeers = 2
+	s.Health.MaxInvalidSignatures = 3
+
+	peer := kps[1].PublicKey().String()
+	invalid := make(map[string]int)
+	for i := 0; i < 2; i++ {
+		s.peerTracker.invalidSignature(peer)
+	}
+	s.checkHealth(invalid)
+	expectAlert(t, sink.alerts, AlertPeers)
+
+	for i := 0; i < 3; i++ {
+		s.peerTracker.invalidSignature(peer)
+	}
+	s.checkHealth(invalid)
+	a := expectAlert(t, sink.alerts, AlertInvalidSignatures)
+	if a.Key != AlertInvalidSignatures+" "+peer {
+		t.Fatalf("bad key: %s", a.Key)
+	}
+	if len(sink.alerts) != 0 {
+		t.Fatal("repeated alerts should be deduplicated")
+	}
+}
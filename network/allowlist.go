@@ -0,0 +1,57 @@
+package network
+
+import (
+	"fmt"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/util"
+)
+
+// allowed returns the public keys allowed to submit operations, which are
+// the clients, the servers and the priority lane, or nil if anyone can.
+func (c *Config) allowed() map[string]bool {
+	if len(c.Clients) == 0 {
+		return nil
+	}
+	answer := make(map[string]bool)
+	for _, key := range c.Clients {
+		answer[key] = true
+	}
+	for key := range c.Servers {
+		answer[key] = true
+	}
+	for _, key := range c.Priority {
+		answer[key] = true
+	}
+	return answer
+}
+
+// checkClient returns an error if the message comes from someone this node
+// doesn't take it from. Operations have to come from an allowed client, both
+// the message and every operation in it. Anything else can come from anyone,
+// unless queries are private. Servers can always send anything, and the queue
+// filters the operations they relay.
+func (s *Server) checkClient(sm *util.SignedMessage) error {
+	if s.allowed == nil || sm.IsKeepAlive() || s.config.Servers[sm.Signer()] != nil {
+		return nil
+	}
+	if tm, ok := sm.Message().(*currency.TransactionMessage); ok {
+		if !s.allowed[sm.Signer()] {
+			return notAllowed(sm.Signer())
+		}
+		for _, op := range tm.Operations {
+			if !s.allowed[op.GetSigner()] {
+				return notAllowed(op.GetSigner())
+			}
+		}
+		return nil
+	}
+	if s.config.PrivateQueries && !s.allowed[sm.Signer()] {
+		return fmt.Errorf("%s is not allowed to query this node", util.Shorten(sm.Signer()))
+	}
+	return nil
+}
+
+func notAllowed(signer string) error {
+	return fmt.Errorf("%s is not allowed to submit operations to this node", util.Shorten(signer))
+}
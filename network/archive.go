@@ -0,0 +1,276 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/lacker/coinkit/consensus"
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/data"
+	"github.com/lacker/coinkit/util"
+)
+
+// An ArchiveServer answers queries about history straight from a database,
+// without taking part in consensus or gossip. It keeps no state of its own,
+// so any number of archive servers can share one database, or its read
+// replicas, behind a load balancer.
+// It speaks the same protocol as a Server, so clients can't tell the
+// difference, except that it doesn't accept operations.
+type ArchiveServer struct {
+	port int
+
+	// Archive servers aren't part of the network, so this is just a random key
+	// to sign responses with
+	keyPair *util.KeyPair
+
+	db       *data.Database
+	listener net.Listener
+
+	// We set shutdown to true and close the quit channel
+	// when the server is shutting down
+	shutdown bool
+	quit     chan bool
+
+	start time.Time
+}
+
+// How often archive GraphQL subscriptions check the database for new blocks
+const archivePollInterval = time.Second
+
+// How long an archive server spends on a single query
+const archiveQueryTimeout = 10 * time.Second
+
+func NewArchiveServer(port int, db *data.Database) *ArchiveServer {
+	return &ArchiveServer{
+		port:    port,
+		keyPair: util.NewKeyPair(),
+		db:      db,
+		quit:    make(chan bool),
+		start:   time.Now(),
+	}
+}
+
+func (a *ArchiveServer) Logf(format string, args ...interface{}) {
+	util.Logf("AR", a.keyPair.PublicKey().ShortName(), format, args...)
+}
+
+// lastSlot returns the slot of the last block in the database, or zero if
+// there are no blocks.
+func (a *ArchiveServer) lastSlot(ctx context.Context) (int, error) {
+	last, err := a.db.LastBlock(ctx)
+	if err != nil || last == nil {
+		return 0, err
+	}
+	return last.Slot, nil
+}
+
+// currentAccount returns the state of an account as of the last block, from
+// the account cache when it's there. The node that writes the database commits
+// blocks behind our back, so the cache has to catch up to them first.
+func (a *ArchiveServer) currentAccount(
+	ctx context.Context, owner string, last int) (*currency.Account, error) {
+	if err := a.db.CatchUpAccounts(ctx, last); err != nil {
+		return nil, err
+	}
+	return a.db.GetAccount(ctx, owner)
+}
+
+// Handle answers a query. Messages that aren't queries are ignored.
+// Account data is as of the last block in the database. Unlike a Server,
+// this is safe to call from multiple goroutines.
+func (a *ArchiveServer) Handle(ctx context.Context, message util.Message) (util.Message, bool) {
+	switch m := message.(type) {
+
+	case *util.InfoMessage:
+		if m.Account == "" {
+			return nil, false
+		}
+		last, err := a.lastSlot(ctx)
+		if err != nil {
+			return nil, false
+		}
+		slot := m.AccountSlot
+		if slot == 0 {
+			slot = last
+		} else if slot > last {
+			// We don't have this slot yet
+			return nil, false
+		}
+		var account *currency.Account
+		if m.AccountSlot == 0 {
+			account, err = a.currentAccount(ctx, m.Account, last)
+		} else {
+			account, err = a.db.GetAccountAtSlot(ctx, m.Account, slot)
+		}
+		if err != nil {
+			return nil, false
+		}
+		block, err := a.db.GetBlock(ctx, slot)
+		if err != nil {
+			return nil, false
+		}
+		answer := &currency.AccountMessage{
+			I:     m.AccountSlot,
+			State: map[string]*currency.Account{m.Account: account},
+		}
+		if block != nil {
+			answer.Hash = consensus.SlotValue(block.ChunkHash)
+		}
+		if m.AccountSlot == 0 {
+			// Like a node, report the slot we would be working on
+			answer.I = last + 1
+			addActivity(ctx, a.db, answer, m.Account)
+		}
+		return answer, true
+
+	case *data.DocumentMessage, *data.BlobMessage, *data.NetworkStatsMessage, *data.BlocksMessage:
+		return answerDatabaseQuery(ctx, a.db, m)
+
+	default:
+		return nil, false
+	}
+}
+
+// Handles an incoming connection.
+// This is likely to include many messages, all separated by endlines.
+func (a *ArchiveServer) handleConnection(connection net.Conn) {
+	defer connection.Close()
+	conn := NewBasicConnection(connection, make(chan *util.SignedMessage))
+
+	for {
+		var sm *util.SignedMessage
+		select {
+		case <-a.quit:
+			conn.Close()
+			return
+		case sm = <-conn.Receive():
+		}
+
+		if sm == nil {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), archiveQueryTimeout)
+		m, ok := a.Handle(ctx, sm.Message())
+		cancel()
+		if ok {
+			conn.Send(util.NewSignedMessage(m, a.keyPair))
+		}
+	}
+}
+
+func (a *ArchiveServer) listen() {
+	for {
+		conn, err := a.listener.Accept()
+		if a.shutdown || errors.Is(err, net.ErrClosed) {
+			break
+		}
+		if err != nil {
+			util.Logger.Print("incoming connection error: ", err)
+			continue
+		}
+		go a.handleConnection(conn)
+	}
+}
+
+// ServeInBackground spawns a goroutine to serve queries.
+// It returns once it has successfully bound to its port.
+func (a *ArchiveServer) ServeInBackground() {
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", a.port))
+	if err != nil {
+		util.Logger.Fatalf("could not acquire port %d: %s", a.port, err)
+	}
+	a.Logf("serving history on port %d", a.port)
+	a.listener = ln
+	go a.listen()
+}
+
+// ServeForever serves queries and never returns.
+func (a *ArchiveServer) ServeForever() {
+	a.ServeInBackground()
+	<-a.quit
+}
+
+// LocalhostAddress is the address to connect to this server on this machine.
+func (a *ArchiveServer) LocalhostAddress() *Address {
+	return &Address{
+		Host: "127.0.0.1",
+		Port: a.port,
+	}
+}
+
+// graphQL makes the GraphQL API for this archive server. Subscriptions poll
+// the database, since there is no consensus to tell us about new blocks.
+func (a *ArchiveServer) graphQL() *graphQLServer {
+	return &graphQLServer{
+		db: a.db,
+		getAccount: func(ctx context.Context, owner string) (*graphQLAccount, error) {
+			last, err := a.lastSlot(ctx)
+			if err != nil {
+				return nil, err
+			}
+			account, err := a.currentAccount(ctx, owner, last)
+			if account == nil || err != nil {
+				return nil, err
+			}
+			return &graphQLAccount{Account: account, Owner: owner}, nil
+		},
+		newBlock: func() <-chan bool {
+			answer := make(chan bool)
+			time.AfterFunc(archivePollInterval, func() { close(answer) })
+			return answer
+		},
+		quit: a.quit,
+	}
+}
+
+// ServeHttpInBackground spawns a goroutine to serve /healthz, /statusz, and
+// the GraphQL API.
+func (a *ArchiveServer) ServeHttpInBackground(port int) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "OK\n")
+	})
+
+	mux.HandleFunc("/statusz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "archive server\n")
+		fmt.Fprintf(w, "%.1fs uptime\n", time.Now().Sub(a.start).Seconds())
+		last, err := a.lastSlot(r.Context())
+		if err != nil {
+			fmt.Fprintf(w, "last slot: %s\n", err)
+		} else {
+			fmt.Fprintf(w, "last slot: %d\n", last)
+		}
+		fmt.Fprintf(w, "account cache: %s\n", a.db.AccountCacheStats())
+	})
+
+	g := a.graphQL()
+	schema := g.schema()
+	mux.HandleFunc("/graphql", g.handleGraphQL(schema))
+	mux.HandleFunc("/graphql/subscribe", g.handleGraphQLSubscribe(schema))
+
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: mux,
+	}
+
+	go srv.ListenAndServe()
+
+	go func() {
+		<-a.quit
+		srv.Shutdown(context.Background())
+	}()
+}
+
+func (a *ArchiveServer) Stop() {
+	a.shutdown = true
+	close(a.quit)
+	if a.listener != nil {
+		a.Logf("releasing port %d", a.port)
+		a.listener.Close()
+	}
+}
@@ -0,0 +1,110 @@
+package network
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/data"
+	"github.com/lacker/coinkit/util"
+)
+
+func TestArchiveServerReadsDatabase(t *testing.T) {
+	bob := util.NewKeyPairFromSecretPhrase("bob").PublicKey().String()
+	data.DropTestData(0)
+	writer := data.NewTestDatabase(0)
+	ctx := context.Background()
+	for slot := 1; slot <= 2; slot++ {
+		chunk := currency.NewEmptyChunk()
+		chunk.State[bob] = &currency.Account{Sequence: 0, Balance: uint64(10 * slot)}
+		if err := writer.InsertBlock(ctx, &data.Block{Slot: slot, Chunk: chunk}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.InsertDocument(ctx, data.NewDocument(1, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	config := data.NewTestConfig(0)
+	config.ReadOnly = true
+	reader := data.NewDatabase(config)
+	if err := reader.InsertDocument(ctx, data.NewDocument(2, nil)); err != data.ErrReadOnly {
+		t.Fatalf("expected a read-only error but got %v", err)
+	}
+
+	network, _ := NewUnitTestNetwork()
+	archive := NewArchiveServer(network.RandomAddress().Port, reader)
+	archive.ServeInBackground()
+	defer archive.Stop()
+
+	client := NewClient(NewRedialConnection(archive.LocalhostAddress(), nil))
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	account, err := client.GetAccount(ctx, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account == nil || account.Balance != 20 {
+		t.Fatalf("expected bob's latest balance but got %+v", account)
+	}
+	account, err = client.GetAccountAtSlot(ctx, bob, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account == nil || account.Balance != 10 {
+		t.Fatalf("expected bob's balance at slot 1 but got %+v", account)
+	}
+	count, err := client.CountDocuments(ctx, map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("expected 1 document but got %d", count)
+	}
+
+	// Blocks and documents come a page at a time
+	blocks, next, err := client.GetBlocksPage(ctx, "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 1 || blocks[0].Slot != 1 || next == "" {
+		t.Fatalf("expected the first block and a cursor but got %+v %q", blocks, next)
+	}
+	blocks, next, err = client.GetBlocksPage(ctx, next, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 1 || blocks[0].Slot != 2 || next != "" {
+		t.Fatalf("expected the last block but got %+v %q", blocks, next)
+	}
+	docs, next, err := client.GetDocumentsPage(ctx, map[string]interface{}{}, "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 || next != "" {
+		t.Fatalf("expected the only document but got %+v %q", docs, next)
+	}
+	if _, _, err := client.GetBlocksPage(ctx, "not a cursor", 1); err == nil {
+		t.Fatal("a bad cursor should be an error")
+	}
+
+	// The archive catches its account cache up to blocks the writer commits
+	chunk := currency.NewEmptyChunk()
+	chunk.State[bob] = &currency.Account{Sequence: 0, Balance: 30}
+	if err := writer.InsertBlock(ctx, &data.Block{Slot: 3, Chunk: chunk}); err != nil {
+		t.Fatal(err)
+	}
+	account, err = client.GetAccount(ctx, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account == nil || account.Balance != 30 {
+		t.Fatalf("expected bob's new balance but got %+v", account)
+	}
+	if reader.AccountCacheStats().Hits == 0 {
+		t.Fatalf("expected the account cache to be used: %s", reader.AccountCacheStats())
+	}
+}
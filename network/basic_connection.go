@@ -25,18 +25,30 @@ type BasicConnection struct {
 	quitOnce sync.Once
 	start    time.Time
 	stop     time.Time
+
+	// Called with the claimed signer when a message fails verification.
+	// May be nil
+	onInvalidSignature func(signer string)
 }
 
 // NewBasicConnection creates a new logical connection given a network connection.
 // inbox is the channel to send messages to.
 func NewBasicConnection(conn net.Conn, inbox chan *util.SignedMessage) *BasicConnection {
+	return newBasicConnection(conn, inbox, nil)
+}
+
+// newBasicConnection is like NewBasicConnection but also reports messages
+// with invalid signatures to onInvalidSignature, if it is non-nil.
+func newBasicConnection(conn net.Conn, inbox chan *util.SignedMessage,
+	onInvalidSignature func(signer string)) *BasicConnection {
 	c := &BasicConnection{
-		conn:   conn,
-		outbox: make(chan *util.SignedMessage, 100),
-		inbox:  inbox,
-		quit:   make(chan bool),
-		closed: false,
-		start:  time.Now(),
+		conn:               conn,
+		outbox:             make(chan *util.SignedMessage, 100),
+		inbox:              inbox,
+		quit:               make(chan bool),
+		closed:             false,
+		start:              time.Now(),
+		onInvalidSignature: onInvalidSignature,
 	}
 	go c.runIncoming()
 	go c.runOutgoing()
@@ -56,16 +68,25 @@ func (c *BasicConnection) IsClosed() bool {
 }
 
 func (c *BasicConnection) runIncoming() {
-	c.conn.SetReadDeadline(time.Now().Add(2 * keepalive * time.Second))
 	reader := bufio.NewReader(c.conn)
 	for {
 		// Wait for 2x the keepalive period
+		c.conn.SetReadDeadline(time.Now().Add(2 * keepalive * time.Second))
 		response, err := util.ReadSignedMessage(reader)
 		if c.closed {
 			break
 		}
 		if err != nil {
-			util.Logger.Printf("connection error: %+v", err)
+			invalid, ok := err.(*util.InvalidSignatureError)
+			if ok && c.onInvalidSignature != nil {
+				c.onInvalidSignature(invalid.Signer)
+			}
+			if limit, ok := err.(*util.LimitError); ok {
+				util.Logger.Printf("rejected message from %s: %s",
+					c.conn.RemoteAddr(), limit)
+			} else {
+				util.Logger.Printf("connection error: %+v", err)
+			}
 			c.Close()
 			break
 		}
@@ -0,0 +1,238 @@
+package network
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/lacker/coinkit/consensus"
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/util"
+)
+
+// Every CheckpointInterval slots, each validator signs the root of the
+// complete account state. Once a quorum has signed the same root, the
+// checkpoint can be trusted by anyone who knows the validator set, so a new
+// client can start from there instead of replaying every block since genesis.
+const CheckpointInterval = 100
+
+// A CheckpointMessage carries a checkpoint along with the signatures a node
+// knows about for it. Validators gossip these to collect each other's
+// signatures.
+// A client sends one with I = 0 to ask for the latest checkpoint that a
+// quorum has signed. The answer includes the account state, and is empty
+// when there is no such checkpoint yet.
+type CheckpointMessage struct {
+	// The slot this checkpoint comes right after
+	I int
+
+	// The StateRoot of all accounts right after slot I
+	Root string
+
+	// Signatures of Digest, keyed by the public key of the signer
+	Signatures map[string]string
+
+	// The signatures of a quorum, aggregated. Validators make it once the
+	// checkpoint is verified, and answer clients with it instead of the
+	// individual signatures.
+	CoSignature *CoSignature `json:",omitempty"`
+
+	// Every account as of this checkpoint. Only set in answers to clients.
+	State map[string]*currency.Account `json:",omitempty"`
+}
+
+func (m *CheckpointMessage) Slot() int {
+	return m.I
+}
+
+func (m *CheckpointMessage) MessageType() string {
+	return "K"
+}
+
+func (m *CheckpointMessage) String() string {
+	if len(m.Signatures) == 0 && m.CoSignature != nil {
+		return fmt.Sprintf("checkpoint i=%d root=%s with a co-signature",
+			m.I, util.Shorten(m.Root))
+	}
+	return fmt.Sprintf("checkpoint i=%d root=%s with %d signatures",
+		m.I, util.Shorten(m.Root), len(m.Signatures))
+}
+
+func (m *CheckpointMessage) IsQuery() bool {
+	return m.I == 0
+}
+
+// Digest is the string that validators sign.
+func (m *CheckpointMessage) Digest() string {
+	return fmt.Sprintf("checkpoint %d %s", m.I, m.Root)
+}
+
+func (m *CheckpointMessage) Sign(kp util.Signer) {
+	if m.Signatures == nil {
+		m.Signatures = make(map[string]string)
+	}
+	m.Signatures[kp.PublicKey().String()] = kp.Sign(m.Digest())
+}
+
+// validSigner returns whether signer is a member of the quorum slice that
+// provided a valid signature.
+func (m *CheckpointMessage) validSigner(qs consensus.QuorumSlice, signer string) bool {
+	signature, ok := m.Signatures[signer]
+	if !ok {
+		return false
+	}
+	pk, err := util.ReadPublicKey(signer)
+	if err != nil {
+		return false
+	}
+	for _, member := range qs.Members {
+		if member == signer {
+			return util.VerifySignature(pk, m.Digest(), signature)
+		}
+	}
+	return false
+}
+
+// Verify returns whether enough validators signed this checkpoint to meet
+// the quorum slice. It doesn't check the state.
+// A valid co-signature is enough on its own. Otherwise each signature is
+// checked.
+func (m *CheckpointMessage) Verify(qs consensus.QuorumSlice) bool {
+	if m.CoSignature != nil && m.verifyCoSignature(qs) {
+		return true
+	}
+	signers := []string{}
+	for signer, _ := range m.Signatures {
+		if m.validSigner(qs, signer) {
+			signers = append(signers, signer)
+		}
+	}
+	return qs.SatisfiedWith(signers)
+}
+
+// Merge adds the valid signatures from another message for the same
+// checkpoint. It returns whether any were added.
+func (m *CheckpointMessage) Merge(qs consensus.QuorumSlice, other *CheckpointMessage) bool {
+	if other.I != m.I || other.Root != m.Root {
+		return false
+	}
+	added := false
+	for signer, signature := range other.Signatures {
+		if _, ok := m.Signatures[signer]; ok || !other.validSigner(qs, signer) {
+			continue
+		}
+		m.Signatures[signer] = signature
+		added = true
+	}
+	return added
+}
+
+// gossip returns a copy of the message to send to other validators, which
+// don't need the state.
+func (m *CheckpointMessage) gossip() *CheckpointMessage {
+	signatures := make(map[string]string)
+	for signer, signature := range m.Signatures {
+		signatures[signer] = signature
+	}
+	return &CheckpointMessage{
+		I:          m.I,
+		Root:       m.Root,
+		Signatures: signatures,
+	}
+}
+
+// A CoSignature is the signatures of a checkpoint's Digest by a quorum,
+// aggregated so they can be checked all at once. See
+// util.AggregateSignatures.
+type CoSignature struct {
+	// Which validators signed, as a hex bitmap over the quorum slice's
+	// members in sorted order. Member i is bit i%8 of byte i/8.
+	Signers string
+
+	// The aggregate of their signatures, in the same order
+	Signature string
+}
+
+// sortedMembers returns the quorum slice's members in the order a
+// co-signature lists them.
+func sortedMembers(qs consensus.QuorumSlice) []string {
+	members := append([]string{}, qs.Members...)
+	sort.Strings(members)
+	return members
+}
+
+// CoSign aggregates the valid signatures from members of the quorum slice
+// into the co-signature. It returns whether they meet the quorum slice.
+func (m *CheckpointMessage) CoSign(qs consensus.QuorumSlice) bool {
+	members := sortedMembers(qs)
+	bitmap := make([]byte, (len(members)+7)/8)
+	signers := []string{}
+	keys := []util.PublicKey{}
+	signatures := []string{}
+	for i, member := range members {
+		if !m.validSigner(qs, member) {
+			continue
+		}
+		pk, _ := util.ReadPublicKey(member)
+		bitmap[i/8] |= 1 << uint(i%8)
+		signers = append(signers, member)
+		keys = append(keys, pk)
+		signatures = append(signatures, m.Signatures[member])
+	}
+	if !qs.SatisfiedWith(signers) {
+		return false
+	}
+	aggregate, err := util.AggregateSignatures(keys, m.Digest(), signatures)
+	if err != nil {
+		return false
+	}
+	m.CoSignature = &CoSignature{
+		Signers:   hex.EncodeToString(bitmap),
+		Signature: aggregate,
+	}
+	return true
+}
+
+// verifyCoSignature returns whether the co-signature is valid and its
+// signers meet the quorum slice.
+func (m *CheckpointMessage) verifyCoSignature(qs consensus.QuorumSlice) bool {
+	members := sortedMembers(qs)
+	bitmap, err := hex.DecodeString(m.CoSignature.Signers)
+	if err != nil || len(bitmap) != (len(members)+7)/8 {
+		return false
+	}
+	signers := []string{}
+	keys := []util.PublicKey{}
+	for i, member := range members {
+		if bitmap[i/8]&(1<<uint(i%8)) == 0 {
+			continue
+		}
+		pk, err := util.ReadPublicKey(member)
+		if err != nil {
+			return false
+		}
+		signers = append(signers, member)
+		keys = append(keys, pk)
+	}
+	return qs.SatisfiedWith(signers) &&
+		util.VerifyAggregate(keys, m.Digest(), m.CoSignature.Signature)
+}
+
+// forClients returns a copy of the message to answer clients with, which
+// has the co-signature instead of the individual signatures when there is
+// one.
+func (m *CheckpointMessage) forClients() *CheckpointMessage {
+	if m.CoSignature == nil {
+		return m
+	}
+	return &CheckpointMessage{
+		I:           m.I,
+		Root:        m.Root,
+		CoSignature: m.CoSignature,
+		State:       m.State,
+	}
+}
+
+func init() {
+	util.RegisterMessageType(&CheckpointMessage{})
+}
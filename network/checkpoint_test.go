@@ -0,0 +1,359 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lacker/coinkit/consensus"
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/util"
+)
+
+func TestCheckpointSignatures(t *testing.T) {
+	qs, _ := consensus.MakeTestQuorumSlice(4)
+	m := &CheckpointMessage{I: 10, Root: "root"}
+	for i := 0; i < 2; i++ {
+		m.Sign(util.NewKeyPairFromSecretPhrase(fmt.Sprintf("node%d", i)))
+	}
+	m.Sign(util.NewKeyPairFromSecretPhrase("not a validator"))
+	if m.Verify(qs) {
+		t.Fatal("two validators should not be enough")
+	}
+
+	other := &CheckpointMessage{I: 10, Root: "root"}
+	other.Sign(util.NewKeyPairFromSecretPhrase("node2"))
+	if !m.Merge(qs, other) {
+		t.Fatal("merging should have added a signature")
+	}
+	if !m.Verify(qs) {
+		t.Fatal("three validators should be enough")
+	}
+
+	forged := util.EncodeThenDecodeMessage(m).(*CheckpointMessage)
+	forged.Root = "another root"
+	if forged.Verify(qs) {
+		t.Fatal("the signatures should not carry over to a different root")
+	}
+}
+
+func TestCheckpointCoSignature(t *testing.T) {
+	qs, _ := consensus.MakeTestQuorumSlice(4)
+	m := &CheckpointMessage{I: 10, Root: "root"}
+	for i := 0; i < 2; i++ {
+		m.Sign(util.NewKeyPairFromSecretPhrase(fmt.Sprintf("node%d", i)))
+	}
+	if m.CoSign(qs) {
+		t.Fatal("two validators should not be enough to co-sign")
+	}
+	m.Sign(util.NewKeyPairFromSecretPhrase("node3"))
+	m.Sign(util.NewKeyPairFromSecretPhrase("not a validator"))
+	if !m.CoSign(qs) {
+		t.Fatal("three validators should be enough to co-sign")
+	}
+
+	answer := util.EncodeThenDecodeMessage(m.forClients()).(*CheckpointMessage)
+	if len(answer.Signatures) != 0 || answer.CoSignature == nil {
+		t.Fatalf("clients should only get the co-signature: %+v", answer)
+	}
+	if !answer.Verify(qs) {
+		t.Fatal("the co-signature should verify")
+	}
+
+	forged := util.EncodeThenDecodeMessage(answer).(*CheckpointMessage)
+	forged.Root = "another root"
+	if forged.Verify(qs) {
+		t.Fatal("the co-signature should not carry over to a different root")
+	}
+
+	// Claiming a signer that didn't sign breaks the aggregate
+	forged = util.EncodeThenDecodeMessage(answer).(*CheckpointMessage)
+	forged.CoSignature.Signers = "0f"
+	if forged.Verify(qs) {
+		t.Fatal("the co-signature should not verify with extra signers")
+	}
+
+	// A co-signature from fewer validators than the quorum needs isn't enough
+	bigger := consensus.QuorumSlice{Members: qs.Members, Threshold: 4}
+	if answer.Verify(bigger) {
+		t.Fatal("three validators should not meet a threshold of four")
+	}
+}
+
+// nodeConnection is a Connection that a node answers directly, so that
+// clients can be tested without a server.
+type nodeConnection struct {
+	node    *Node
+	keyPair util.Signer
+	inbox   chan *util.SignedMessage
+	closed  bool
+}
+
+func newNodeConnection(node *Node) *nodeConnection {
+	return &nodeConnection{
+		node:    node,
+		keyPair: util.NewKeyPair(),
+		inbox:   make(chan *util.SignedMessage, 10),
+	}
+}
+
+func (c *nodeConnection) Close() {
+	c.closed = true
+}
+
+func (c *nodeConnection) IsClosed() bool {
+	return c.closed
+}
+
+func (c *nodeConnection) Send(sm *util.SignedMessage) bool {
+	response, ok := c.node.Handle(sm.Signer(), sm.Message())
+	if ok {
+		serialized := util.NewSignedMessage(response, c.keyPair).Serialize()
+		decoded, err := util.NewSignedMessageFromSerialized(serialized)
+		if err != nil {
+			panic(err)
+		}
+		c.inbox <- decoded
+	}
+	return true
+}
+
+func (c *nodeConnection) Receive() chan *util.SignedMessage {
+	return c.inbox
+}
+
+// newCheckpointNetwork makes four validators that checkpoint every two slots,
+// with a mint that has 1000.
+func newCheckpointNetwork(mint *util.KeyPair) (consensus.QuorumSlice, []*Node) {
+	qs, names := consensus.MakeTestQuorumSlice(4)
+	nodes := []*Node{}
+	for i, name := range names {
+		node := NewNodeWithMint(name, qs, nil, mint.PublicKey(), 1000)
+		node.keyPair = util.NewKeyPairFromSecretPhrase(fmt.Sprintf("node%d", i))
+		node.checkpointInterval = 2
+		nodes = append(nodes, node)
+	}
+	return qs, nodes
+}
+
+// finishSlot gives a message to the first node and passes messages around
+// until every node finalizes the next slot. Rounds are timed out in between,
+// in case one is led by a validator that isn't running.
+func finishSlot(t *testing.T, nodes []*Node, sender string, m util.Message) {
+	t.Helper()
+	slot := nodes[0].Slot()
+	nodes[0].Handle(sender, m)
+	for round := 0; round < 5 && nodes[len(nodes)-1].Slot() == slot; round++ {
+		if round > 0 {
+			timeOutRounds(nodes)
+		}
+		for i := 0; i < 10; i++ {
+			for _, source := range nodes {
+				for _, target := range nodes {
+					if source != target {
+						sendNodeToNodeMessages(source, target, t)
+					}
+				}
+			}
+		}
+	}
+	for i, node := range nodes {
+		if node.Slot() != slot+1 {
+			t.Fatalf("nodes[%d] did not finish slot %d", i, slot)
+		}
+	}
+}
+
+func TestLightClientSync(t *testing.T) {
+	mint := util.NewKeyPairFromSecretPhrase("mint")
+	bob := util.NewKeyPairFromSecretPhrase("bob")
+	pool := util.NewKeyPairFromSecretPhrase("pool").PublicKey().String()
+	genesis := &Config{Emission: &currency.Emission{Amount: 7, Interval: 1, Pool: pool}}
+	qs, nodes := newCheckpointNetwork(mint)
+	for _, node := range nodes {
+		node.queue.SetEmission(genesis.Emission)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	lc := NewLightClient(newNodeConnection(nodes[0]), qs)
+	lc.SetGenesis(genesis)
+	if err := lc.Sync(ctx, 1); err == nil {
+		t.Fatal("there should be no checkpoint to sync from yet")
+	}
+
+	send := func(round int) {
+		finishSlot(t, nodes, mint.PublicKey().String(), newSendMessage(mint, bob, round, 10))
+	}
+	send(1)
+	send(2)
+	if err := lc.Sync(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if lc.Slot() != 2 {
+		t.Fatalf("expected to start from the checkpoint at slot 2 but got %d", lc.Slot())
+	}
+
+	for round := 3; round <= 7; round++ {
+		send(round)
+	}
+	for _, node := range nodes {
+		if node.verifiedCheckpoint == nil || node.verifiedCheckpoint.I != 6 {
+			t.Fatalf("expected a verified checkpoint at slot 6 but got %+v",
+				node.verifiedCheckpoint)
+		}
+	}
+
+	checkpoint, err := NewClient(newNodeConnection(nodes[0])).GetCheckpoint(ctx)
+	if err != nil || checkpoint.CoSignature == nil || len(checkpoint.Signatures) != 0 {
+		t.Fatalf("the checkpoint should come with just a co-signature: %+v %v", checkpoint, err)
+	}
+
+	// Slot 7 is finalized, but no quorum has signed anything past slot 6
+	if err := lc.Sync(ctx, 7); err == nil {
+		t.Fatal("there should be no checkpoint at slot 7 to sync to")
+	}
+	if lc.Slot() != 2 {
+		t.Fatalf("a failed sync should not move the client, but it is at %d", lc.Slot())
+	}
+
+	// Syncing through slot 3 goes on to the checkpoint after it
+	if err := lc.Sync(ctx, 3); err != nil {
+		t.Fatal(err)
+	}
+	if lc.Slot() != 6 {
+		t.Fatalf("expected to sync through slot 6 but got %d", lc.Slot())
+	}
+	if lc.Account(bob.PublicKey().String()).Balance != 60 {
+		t.Fatalf("bad balance for bob: %+v", lc.Account(bob.PublicKey().String()))
+	}
+	mintAccount := nodes[0].queue.OldChunk(6).State[mint.PublicKey().String()]
+	if lc.Account(mint.PublicKey().String()).Balance != mintAccount.Balance {
+		t.Fatal("the light client disagrees with the node about the mint")
+	}
+	if lc.Account(pool).Balance != 42 {
+		t.Fatalf("the pool should have been minted 7 a slot: %+v", lc.Account(pool))
+	}
+}
+
+// forgingConnection answers history queries with blocks the validators never
+// finalized. They are empty blocks, which are valid on their own, or when skip
+// is set, the block for the slot after the one asked for.
+type forgingConnection struct {
+	*nodeConnection
+	forge bool
+	skip  bool
+}
+
+func (c *forgingConnection) Send(sm *util.SignedMessage) bool {
+	m, ok := sm.Message().(*util.InfoMessage)
+	if !ok || m.I == 0 || !(c.forge || c.skip) {
+		return c.nodeConnection.Send(sm)
+	}
+	if c.skip {
+		return c.nodeConnection.Send(util.NewSignedMessage(
+			&util.InfoMessage{I: m.I + 1}, util.NewKeyPair()))
+	}
+	answer, _ := c.node.Handle(sm.Signer(), m)
+	history := answer.(*HistoryMessage)
+	chunk := currency.NewEmptyChunk()
+	e := *history.E
+	e.X = chunk.Hash()
+	forged := &HistoryMessage{
+		I: history.I,
+		T: &currency.TransactionMessage{
+			Operations: []*util.SignedOperation{},
+			Chunks:     map[consensus.SlotValue]*currency.LedgerChunk{e.X: chunk},
+		},
+		E: &e,
+	}
+	c.inbox <- util.NewSignedMessage(forged, c.keyPair)
+	return true
+}
+
+func TestLightClientRejectsForgedHistory(t *testing.T) {
+	mint := util.NewKeyPairFromSecretPhrase("mint")
+	bob := util.NewKeyPairFromSecretPhrase("bob")
+	qs, nodes := newCheckpointNetwork(mint)
+	send := func(round int) {
+		finishSlot(t, nodes, mint.PublicKey().String(), newSendMessage(mint, bob, round, 10))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn := &forgingConnection{nodeConnection: newNodeConnection(nodes[0]), forge: true}
+	lc := NewLightClient(conn, qs)
+	send(1)
+	send(2)
+	if err := lc.Sync(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	send(3)
+	send(4)
+
+	if err := lc.Sync(ctx, 4); err == nil {
+		t.Fatal("the empty blocks should not match the checkpoint")
+	}
+	conn.forge = false
+	conn.skip = true
+	if err := lc.Sync(ctx, 4); err == nil {
+		t.Fatal("a skipped slot should be rejected")
+	}
+	if lc.Slot() != 2 || lc.Account(bob.PublicKey().String()).Balance != 20 {
+		t.Fatalf("the client should still be at slot 2 but is at %d", lc.Slot())
+	}
+
+	conn.skip = false
+	if err := lc.Sync(ctx, 4); err != nil {
+		t.Fatal(err)
+	}
+	if lc.Account(bob.PublicKey().String()).Balance != 40 {
+		t.Fatalf("bad balance for bob: %+v", lc.Account(bob.PublicKey().String()))
+	}
+}
+
+func TestLightClientFollowsValidatorChanges(t *testing.T) {
+	mint := util.NewKeyPairFromSecretPhrase("mint")
+	bob := util.NewKeyPairFromSecretPhrase("bob")
+	qs, nodes := newCheckpointNetwork(mint)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	lc := NewLightClient(newNodeConnection(nodes[0]), qs)
+	finishSlot(t, nodes, mint.PublicKey().String(), newSendMessage(mint, bob, 1, 10))
+	finishSlot(t, nodes, mint.PublicKey().String(), newSendMessage(mint, bob, 2, 10))
+	if err := lc.Sync(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// Three of the four validators vote to add a fifth, starting at slot 4.
+	// The checkpoint at slot 4 is then signed under the new validator set.
+	newcomer := util.NewKeyPairFromSecretPhrase("node4").PublicKey().String()
+	votes := []*util.SignedOperation{}
+	for i := 0; i < 3; i++ {
+		kp := util.NewKeyPairFromSecretPhrase(fmt.Sprintf("node%d", i))
+		votes = append(votes, util.NewSignedOperation(&currency.ValidatorOperation{
+			Signer:     kp.PublicKey().String(),
+			Sequence:   1,
+			Action:     currency.ValidatorAdd,
+			Validator:  newcomer,
+			Threshold:  4,
+			Activation: 4,
+		}, kp))
+	}
+	finishSlot(t, nodes, mint.PublicKey().String(), currency.NewTransactionMessage(votes...))
+	finishSlot(t, nodes, mint.PublicKey().String(), newSendMessage(mint, bob, 3, 10))
+	if len(nodes[0].chain.D.Members) != 5 {
+		t.Fatalf("the validators should have changed: %+v", nodes[0].chain.D)
+	}
+
+	if err := lc.Sync(ctx, 4); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(lc.Validators(), nodes[0].chain.D) {
+		t.Fatalf("the light client has the wrong validators: %+v", lc.Validators())
+	}
+	if lc.Account(bob.PublicKey().String()).Balance != 30 {
+		t.Fatalf("bad balance for bob: %+v", lc.Account(bob.PublicKey().String()))
+	}
+}
@@ -0,0 +1,771 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/data"
+	"github.com/lacker/coinkit/util"
+)
+
+// A Client is used by endpoints that want to query the network or send it
+// operations, rather than participate in consensus.
+// Every query takes a context, so that callers can set deadlines. Otherwise a
+// dead peer would block the caller forever.
+type Client struct {
+	conn Connection
+
+	// The public key of the node on the other end, if we know it. When we
+	// do, account data has to be signed by that node.
+	nodeKey string
+
+	// The latest slot we have gotten current account data for. A response
+	// from before that is stale.
+	slot int
+
+	// How to retry calls that fail. Nil means no retries
+	policy *RetryPolicy
+
+	// The accounts right after operations that Submit saw clear, keyed by
+	// operation hash
+	submitted map[string]*currency.Account
+}
+
+func NewClient(conn Connection) *Client {
+	return &Client{conn: conn}
+}
+
+// NewVerifiedClient creates a client for the node with the provided public
+// key. It rejects account data that is tampered with, signed by any other
+// key, or older than data it has already seen.
+func NewVerifiedClient(conn Connection, nodeKey string) *Client {
+	return &Client{conn: conn, nodeKey: nodeKey}
+}
+
+func (c *Client) Close() {
+	c.conn.Close()
+}
+
+// Send sends a message without waiting for any response.
+// It returns whether the message entered the outbox.
+func (c *Client) Send(message *util.SignedMessage) bool {
+	return c.conn.Send(message)
+}
+
+// receiveSigned waits for the next message from the connection.
+// It returns an error if the context is done or the connection closes first.
+// Answers don't say which query they are for, so when we stop waiting for
+// one, the connection is replaced. Otherwise the answer could still arrive,
+// and be read as the answer to the next query.
+func (c *Client) receiveSigned(ctx context.Context) (*util.SignedMessage, error) {
+	select {
+	case sm := <-c.conn.Receive():
+		if sm == nil {
+			return nil, ErrConnectionClosed
+		}
+		return sm, nil
+	case <-ctx.Done():
+		c.redial()
+		return nil, ctx.Err()
+	}
+}
+
+// A redialer is a Connection that can make a new connection to the same
+// place, like a RedialConnection.
+type redialer interface {
+	Redial() Connection
+}
+
+// redial replaces the connection with a new one, so nothing still on its way
+// over the old one gets read. A connection that can't redial is closed
+// instead, since it can't tell late answers from new ones.
+func (c *Client) redial() {
+	if r, ok := c.conn.(redialer); ok {
+		c.conn = r.Redial()
+		return
+	}
+	c.conn.Close()
+}
+
+// receive is like receiveSigned, but only returns the message.
+func (c *Client) receive(ctx context.Context) (util.Message, error) {
+	sm, err := c.receiveSigned(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return sm.Message(), nil
+}
+
+// requestSigned sends a query and waits for the answer, retrying under the
+// client's policy.
+func (c *Client) requestSigned(
+	ctx context.Context, query util.Message) (*util.SignedMessage, error) {
+	var answer *util.SignedMessage
+	err := c.retry(ctx, c.attemptTimeout(), func(ctx context.Context) error {
+		SendAnonymousMessage(c.conn, query)
+		sm, err := c.receiveSigned(ctx)
+		answer = sm
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return answer, nil
+}
+
+// request is like requestSigned, but only returns the message.
+func (c *Client) request(ctx context.Context, query util.Message) (util.Message, error) {
+	sm, err := c.requestSigned(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return sm.Message(), nil
+}
+
+// getAccountMessage sends an account query and waits for the answer. If we
+// know the node's key, the answer has to be signed by it, recently, and if
+// it is for the current state, it can't be from before data we have
+// already gotten.
+// Under a retry policy, a stale answer is retried along with the query.
+func (c *Client) getAccountMessage(
+	ctx context.Context, query *util.InfoMessage) (*currency.AccountMessage, error) {
+	var answer *currency.AccountMessage
+	err := c.retry(ctx, c.attemptTimeout(), func(ctx context.Context) error {
+		m, err := c.getAccountMessageOnce(ctx, query)
+		answer = m
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return answer, nil
+}
+
+func (c *Client) getAccountMessageOnce(
+	ctx context.Context, query *util.InfoMessage) (*currency.AccountMessage, error) {
+	SendAnonymousMessage(c.conn, query)
+	sm, err := c.receiveSigned(ctx)
+	if err != nil {
+		return nil, err
+	}
+	accountMessage, ok := sm.Message().(*currency.AccountMessage)
+	if !ok {
+		return nil, fmt.Errorf("expected an account message but got: %+v", sm.Message())
+	}
+	if c.nodeKey == "" {
+		return accountMessage, nil
+	}
+	if sm.Signer() != c.nodeKey {
+		return nil, fmt.Errorf("account data was signed by %s instead of %s",
+			util.Shorten(sm.Signer()), util.Shorten(c.nodeKey))
+	}
+	age := time.Since(sm.Timestamp())
+	if age > DefaultReplayWindow || age < -DefaultReplayWindow {
+		return nil, &StaleError{fmt.Sprintf("account data was signed at %s",
+			sm.Timestamp().Format(time.RFC3339))}
+	}
+	if query.AccountSlot != 0 {
+		if accountMessage.I != query.AccountSlot {
+			return nil, fmt.Errorf("asked for account data at slot %d but got slot %d",
+				query.AccountSlot, accountMessage.I)
+		}
+		return accountMessage, nil
+	}
+	if accountMessage.I < c.slot {
+		return nil, &StaleError{fmt.Sprintf(
+			"got stale account data from slot %d after slot %d", accountMessage.I, c.slot)}
+	}
+	c.slot = accountMessage.I
+	return accountMessage, nil
+}
+
+// GetAccount returns the current state of an account.
+// It returns a nil account when the node does not know about the account.
+func (c *Client) GetAccount(ctx context.Context, user string) (*currency.Account, error) {
+	ctx, span := util.StartSpan(ctx, "client.GetAccount")
+	defer span.End()
+	accountMessage, err := c.getAccountMessage(ctx, &util.InfoMessage{Account: user})
+	if err != nil {
+		return nil, err
+	}
+	return accountMessage.State[user], nil
+}
+
+// GetAccounts returns the current state of many accounts, keyed by owner,
+// asking for up to util.MaxAccountsPerQuery of them per round trip. Accounts
+// the node does not know about map to nil.
+// Each batch can come from a different slot, when a block finishes partway
+// through.
+func (c *Client) GetAccounts(
+	ctx context.Context, users []string) (map[string]*currency.Account, error) {
+	ctx, span := util.StartSpan(ctx, "client.GetAccounts")
+	defer span.End()
+	reports, err := c.GetAccountReports(ctx, users)
+	if err != nil {
+		return nil, err
+	}
+	answer := make(map[string]*currency.Account)
+	for user, report := range reports {
+		answer[user] = report.Account
+	}
+	return answer, nil
+}
+
+// An AccountReport is what a node knows about an account: its current
+// state, how active it has been lately, and the sequence numbers of its
+// operations waiting in the node's queue.
+type AccountReport struct {
+	// Nil when the node does not know about the account
+	Account *currency.Account
+
+	// Nil when the node has no database, or the account has never changed
+	Activity *currency.AccountActivity
+
+	Pending []uint32
+}
+
+// GetAccountReports is like GetAccounts, but it also returns activity and
+// pending operations for each account.
+func (c *Client) GetAccountReports(
+	ctx context.Context, users []string) (map[string]*AccountReport, error) {
+	ctx, span := util.StartSpan(ctx, "client.GetAccountReports")
+	defer span.End()
+	answer := make(map[string]*AccountReport)
+	for len(users) > 0 {
+		batch := users
+		if len(batch) > util.MaxAccountsPerQuery {
+			batch = batch[:util.MaxAccountsPerQuery]
+		}
+		users = users[len(batch):]
+		accountMessage, err := c.getAccountMessage(ctx, &util.InfoMessage{Accounts: batch})
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range batch {
+			account, ok := accountMessage.State[user]
+			if !ok {
+				return nil, fmt.Errorf("asked for %s but the node did not answer for it",
+					util.Shorten(user))
+			}
+			answer[user] = &AccountReport{
+				Account:  account,
+				Activity: accountMessage.Activity[user],
+				Pending:  accountMessage.Pending[user],
+			}
+		}
+	}
+	return answer, nil
+}
+
+// GetAccountFromNodes asks several nodes for the current state of an
+// account, and only returns it when at least required of them agree on the
+// account, the slot, and the block it comes from. Each client has to be
+// verified and connected to a different node, so that no single node can
+// lie about an account.
+func GetAccountFromNodes(ctx context.Context,
+	clients []*Client, user string, required int) (*currency.Account, error) {
+	ctx, span := util.StartSpan(ctx, "client.GetAccountFromNodes")
+	defer span.End()
+	nodes := make(map[string]bool)
+	for _, c := range clients {
+		if c.nodeKey == "" {
+			return nil, errors.New("every client has to be verified")
+		}
+		nodes[c.nodeKey] = true
+	}
+	if len(nodes) < len(clients) {
+		return nil, errors.New("each client has to connect to a different node")
+	}
+	if required > len(clients) {
+		return nil, fmt.Errorf("need %d nodes to agree but only have %d",
+			required, len(clients))
+	}
+
+	type answer struct {
+		message *currency.AccountMessage
+		err     error
+	}
+	answers := make(chan answer, len(clients))
+	for _, c := range clients {
+		c := c
+		go func() {
+			m, err := c.getAccountMessage(ctx, &util.InfoMessage{Account: user})
+			answers <- answer{message: m, err: err}
+		}()
+	}
+
+	// Group the answers by what they say
+	votes := make(map[string]int)
+	problems := []string{}
+	for range clients {
+		a := <-answers
+		if a.err != nil {
+			problems = append(problems, a.err.Error())
+			continue
+		}
+		account := a.message.State[user]
+		key := fmt.Sprintf("%d %s %s", a.message.I, a.message.Hash,
+			currency.StringifyAccount(account))
+		votes[key]++
+		if votes[key] == required {
+			return account, nil
+		}
+	}
+	versions := []string{}
+	for key, count := range votes {
+		versions = append(versions, fmt.Sprintf("%d said %s", count, key))
+	}
+	sort.Strings(versions)
+	return nil, fmt.Errorf("fewer than %d nodes agreed: %s",
+		required, strings.Join(append(versions, problems...), "; "))
+}
+
+// GetAccountAtSlot returns the state of an account right after the provided
+// slot was finalized. If that slot is not finalized yet, this waits until it is.
+// It returns a nil account when no block up to that slot touched the account.
+func (c *Client) GetAccountAtSlot(
+	ctx context.Context, user string, slot int) (*currency.Account, error) {
+	ctx, span := util.StartSpan(ctx, "client.GetAccountAtSlot")
+	defer span.End()
+	accountMessage, err := c.getAccountMessage(
+		ctx, &util.InfoMessage{Account: user, AccountSlot: slot})
+	if err != nil {
+		return nil, err
+	}
+	return accountMessage.State[user], nil
+}
+
+// WaitToClear waits for the transaction with this sequence number to clear.
+func (c *Client) WaitToClear(
+	ctx context.Context, user string, sequence uint32) (*currency.Account, error) {
+	ctx, span := util.StartSpan(ctx, "client.WaitToClear")
+	defer span.End()
+	for {
+		accountMessage, err := c.getAccountMessage(ctx, &util.InfoMessage{Account: user})
+		if err != nil {
+			return nil, err
+		}
+		account := accountMessage.State[user]
+		if account != nil && account.Sequence >= sequence {
+			return account, nil
+		}
+
+		// Wait for the slot to finish before checking again
+		SendAnonymousMessage(c.conn, &util.InfoMessage{I: accountMessage.Slot()})
+		if _, err := c.receive(ctx); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// ErrRejected means an operation left the node's queue without clearing.
+var ErrRejected = errors.New("the operation was dropped without clearing")
+
+// AwaitOperation is like WaitToClear, but it gives up once the operation
+// can't clear. That is when the node has finished a slot since we started
+// waiting, and the operation has neither cleared nor is waiting in its queue.
+// Then it returns ErrRejected, along with the account.
+// If ctx is done first, it returns the context's error.
+func (c *Client) AwaitOperation(
+	ctx context.Context, user string, sequence uint32) (*currency.Account, error) {
+	ctx, span := util.StartSpan(ctx, "client.AwaitOperation")
+	defer span.End()
+	start := 0
+	for {
+		accountMessage, err := c.getAccountMessage(ctx, &util.InfoMessage{Account: user})
+		if err != nil {
+			return nil, err
+		}
+		account := accountMessage.State[user]
+		if account != nil && account.Sequence >= sequence {
+			return account, nil
+		}
+		if start == 0 {
+			start = accountMessage.Slot()
+		} else if accountMessage.Slot() > start &&
+			!containsSequence(accountMessage.Pending[user], sequence) {
+			return account, ErrRejected
+		}
+
+		// Wait for the slot to finish before checking again
+		SendAnonymousMessage(c.conn, &util.InfoMessage{I: accountMessage.Slot()})
+		if _, err := c.receive(ctx); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// The most cleared operations a client remembers for Submit
+const maxSubmitted = 1000
+
+// Submit sends a signed operation, in a message signed by kp, and waits for
+// it to clear like AwaitOperation. Nodes with a client list need kp to be on
+// it.
+// Submit is idempotent by operation hash. Submitting an operation that this
+// client already saw clear returns the account from then without sending
+// anything, and if the account's sequence is already past the operation's,
+// it counts as cleared too, since sending it again can't do anything. A retry
+// sends the same signed operation, which nodes that already have it ignore,
+// so a caller that doesn't know whether a submission went through can
+// always submit it again.
+// The queries Submit makes are retried on their own under a retry policy.
+// The operation itself is only sent again when it is rejected, if the policy
+// retries ErrorRejected.
+func (c *Client) Submit(ctx context.Context,
+	op *util.SignedOperation, kp *util.KeyPair) (*currency.Account, error) {
+	ctx, span := util.StartSpan(ctx, "client.Submit")
+	defer span.End()
+	hash := op.Hash()
+	if account, ok := c.submitted[hash]; ok {
+		return account, nil
+	}
+	user, sequence := op.GetSigner(), op.GetSequence()
+	var answer *currency.Account
+	var failed error
+	err := c.retry(ctx, 0, func(ctx context.Context) error {
+		account, err := c.GetAccount(ctx, user)
+		if err == nil && (account == nil || account.Sequence < sequence) {
+			c.Send(util.NewSignedMessage(currency.NewTransactionMessage(op), kp))
+			account, err = c.AwaitOperation(ctx, user, sequence)
+		}
+		answer = account
+		if err == ErrRejected {
+			return err
+		}
+		// Other errors were already retried
+		failed = err
+		return nil
+	})
+	if err == nil {
+		err = failed
+	}
+	if err != nil {
+		return answer, err
+	}
+	if c.submitted == nil || len(c.submitted) >= maxSubmitted {
+		c.submitted = make(map[string]*currency.Account)
+	}
+	c.submitted[hash] = answer
+	return answer, nil
+}
+
+func containsSequence(list []uint32, sequence uint32) bool {
+	for _, s := range list {
+		if s == sequence {
+			return true
+		}
+	}
+	return false
+}
+
+// queryDocuments sends a document query and waits for the server's response.
+func (c *Client) queryDocuments(
+	ctx context.Context, query *data.DocumentMessage) (*data.DocumentMessage, error) {
+	m, err := c.request(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	response, ok := m.(*data.DocumentMessage)
+	if !ok {
+		return nil, fmt.Errorf("expected a document message but got: %+v", m)
+	}
+	if response.Error != "" {
+		return nil, errors.New(response.Error)
+	}
+	return response, nil
+}
+
+// getStats runs an aggregate query.
+func (c *Client) getStats(
+	ctx context.Context, query *data.DocumentMessage) (*data.DocumentStats, error) {
+	response, err := c.queryDocuments(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if response.Stats == nil {
+		return nil, fmt.Errorf("expected document stats but got: %s", response)
+	}
+	return response.Stats, nil
+}
+
+// CountDocuments returns how many documents have data that contains match.
+func (c *Client) CountDocuments(
+	ctx context.Context, match map[string]interface{}) (int, error) {
+	ctx, span := util.StartSpan(ctx, "client.CountDocuments")
+	defer span.End()
+	stats, err := c.getStats(ctx, &data.DocumentMessage{Match: match})
+	if err != nil {
+		return 0, err
+	}
+	return stats.Count, nil
+}
+
+// AggregateDocuments returns statistics for the documents whose data contains
+// match, including the sum, min, and max of a numeric field.
+func (c *Client) AggregateDocuments(ctx context.Context,
+	match map[string]interface{}, field string) (*data.DocumentStats, error) {
+	ctx, span := util.StartSpan(ctx, "client.AggregateDocuments")
+	defer span.End()
+	return c.getStats(ctx, &data.DocumentMessage{Match: match, Field: field})
+}
+
+// GetDocuments returns up to limit documents whose data contains match, in
+// order of id.
+func (c *Client) GetDocuments(ctx context.Context,
+	match map[string]interface{}, limit int) ([]*data.Document, error) {
+	ctx, span := util.StartSpan(ctx, "client.GetDocuments")
+	defer span.End()
+	response, err := c.queryDocuments(ctx, &data.DocumentMessage{
+		Match: match,
+		Limit: limit,
+		List:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if response.Documents == nil {
+		return nil, fmt.Errorf("expected documents but got: %s", response)
+	}
+	return response.Documents, nil
+}
+
+// GetDocumentsPage is like GetDocuments, but it starts after cursor, and
+// also returns the cursor for the next page. The cursor is empty for the
+// first page, and the next cursor is empty when there are no more documents.
+func (c *Client) GetDocumentsPage(ctx context.Context, match map[string]interface{},
+	cursor data.Cursor, limit int) ([]*data.Document, data.Cursor, error) {
+	ctx, span := util.StartSpan(ctx, "client.GetDocumentsPage")
+	defer span.End()
+	response, err := c.queryDocuments(ctx, &data.DocumentMessage{
+		Match:  match,
+		Limit:  limit,
+		List:   true,
+		Cursor: cursor,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if response.Documents == nil {
+		return nil, "", fmt.Errorf("expected documents but got: %s", response)
+	}
+	return response.Documents, response.Next, nil
+}
+
+// GetDocumentsWithReferences is like GetDocuments, but it also returns the
+// documents that the expand fields of those documents refer to, in order of
+// id. It only takes one round trip to the node.
+func (c *Client) GetDocumentsWithReferences(ctx context.Context,
+	match map[string]interface{}, expand []string,
+	limit int) ([]*data.Document, []*data.Document, error) {
+	ctx, span := util.StartSpan(ctx, "client.GetDocumentsWithReferences")
+	defer span.End()
+	response, err := c.queryDocuments(ctx, &data.DocumentMessage{
+		Match:  match,
+		Limit:  limit,
+		List:   true,
+		Expand: expand,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if response.Documents == nil {
+		return nil, nil, fmt.Errorf("expected documents but got: %s", response)
+	}
+	return response.Documents, response.Referenced, nil
+}
+
+// SearchDocuments returns up to limit documents that match a full-text query,
+// best matches first. If collection is nonempty, only documents in that
+// collection are searched.
+func (c *Client) SearchDocuments(ctx context.Context,
+	query string, collection string, limit int) ([]*data.Document, error) {
+	ctx, span := util.StartSpan(ctx, "client.SearchDocuments")
+	defer span.End()
+	if query == "" {
+		return nil, errors.New("the search query is empty")
+	}
+	response, err := c.queryDocuments(ctx, &data.DocumentMessage{
+		Search:     query,
+		Collection: collection,
+		Limit:      limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if response.Documents == nil {
+		return nil, fmt.Errorf("expected documents but got: %s", response)
+	}
+	return response.Documents, nil
+}
+
+// queryBlob sends a blob message and waits for the server's response.
+func (c *Client) queryBlob(
+	ctx context.Context, query *data.BlobMessage) (*data.BlobMessage, error) {
+	m, err := c.request(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	response, ok := m.(*data.BlobMessage)
+	if !ok {
+		return nil, fmt.Errorf("expected a blob message but got: %+v", m)
+	}
+	if response.Error != "" {
+		return nil, errors.New(response.Error)
+	}
+	return response, nil
+}
+
+// UploadBlob stores a blob on the node. Some account has to have pinned it
+// with a PinBlobOperation first.
+func (c *Client) UploadBlob(ctx context.Context, blob []byte) error {
+	ctx, span := util.StartSpan(ctx, "client.UploadBlob")
+	defer span.End()
+	_, err := c.queryBlob(ctx, &data.BlobMessage{
+		Hash: currency.BlobHash(blob),
+		Data: blob,
+	})
+	return err
+}
+
+// GetBlob fetches a blob from the node, and checks that it has this hash.
+func (c *Client) GetBlob(ctx context.Context, hash string) ([]byte, error) {
+	ctx, span := util.StartSpan(ctx, "client.GetBlob")
+	defer span.End()
+	response, err := c.queryBlob(ctx, &data.BlobMessage{Hash: hash})
+	if err != nil {
+		return nil, err
+	}
+	if currency.BlobHash(response.Data) != hash {
+		return nil, fmt.Errorf("the node sent a blob that does not have the hash %s", hash)
+	}
+	return response.Data, nil
+}
+
+// GetNetworkStats returns the stats of the whole network as of the last block,
+// along with sums over recent windows of time. The node needs a database.
+func (c *Client) GetNetworkStats(ctx context.Context) (*data.NetworkStatsMessage, error) {
+	ctx, span := util.StartSpan(ctx, "client.GetNetworkStats")
+	defer span.End()
+	m, err := c.request(ctx, &data.NetworkStatsMessage{})
+	if err != nil {
+		return nil, err
+	}
+	response, ok := m.(*data.NetworkStatsMessage)
+	if !ok {
+		return nil, fmt.Errorf("expected network stats but got: %+v", m)
+	}
+	if response.Error != "" {
+		return nil, errors.New(response.Error)
+	}
+	return response, nil
+}
+
+// GetCheckpoint returns the latest checkpoint that the node thinks a quorum
+// has signed, including the account state. The caller should check it with
+// Verify. It returns an error if there is no such checkpoint yet.
+func (c *Client) GetCheckpoint(ctx context.Context) (*CheckpointMessage, error) {
+	ctx, span := util.StartSpan(ctx, "client.GetCheckpoint")
+	defer span.End()
+	m, err := c.request(ctx, &CheckpointMessage{})
+	if err != nil {
+		return nil, err
+	}
+	checkpoint, ok := m.(*CheckpointMessage)
+	if !ok {
+		return nil, fmt.Errorf("expected a checkpoint message but got: %+v", m)
+	}
+	if checkpoint.IsQuery() {
+		return nil, errors.New("there is no verified checkpoint yet")
+	}
+	return checkpoint, nil
+}
+
+// EstimateFee returns the fee that the node thinks an operation needs to get
+// into a block within the given number of slots.
+func (c *Client) EstimateFee(ctx context.Context, slots int) (uint64, error) {
+	ctx, span := util.StartSpan(ctx, "client.EstimateFee")
+	defer span.End()
+	m, err := c.request(ctx, &currency.FeeMessage{Slots: slots})
+	if err != nil {
+		return 0, err
+	}
+	estimate, ok := m.(*currency.FeeMessage)
+	if !ok || estimate.IsQuery() {
+		return 0, fmt.Errorf("expected a fee estimate but got: %+v", m)
+	}
+	return estimate.Fee, nil
+}
+
+// GetHistory returns the externalize message and chunk for a slot. If that
+// slot is not finalized yet, this waits until it is.
+func (c *Client) GetHistory(ctx context.Context, slot int) (*HistoryMessage, error) {
+	ctx, span := util.StartSpan(ctx, "client.GetHistory")
+	defer span.End()
+	m, err := c.request(ctx, &util.InfoMessage{I: slot})
+	if err != nil {
+		return nil, err
+	}
+	history, ok := m.(*HistoryMessage)
+	if !ok || history.I != slot || history.E == nil || history.T == nil {
+		return nil, fmt.Errorf("expected history for slot %d but got: %+v", slot, m)
+	}
+	return history, nil
+}
+
+// queryBlocks sends a blocks message and waits for the server's response.
+func (c *Client) queryBlocks(
+	ctx context.Context, query *data.BlocksMessage) ([]*data.Block, data.Cursor, error) {
+	m, err := c.request(ctx, query)
+	if err != nil {
+		return nil, "", err
+	}
+	response, ok := m.(*data.BlocksMessage)
+	if !ok {
+		return nil, "", fmt.Errorf("expected a blocks message but got: %+v", m)
+	}
+	if response.Error != "" {
+		return nil, "", errors.New(response.Error)
+	}
+	return response.Blocks, response.Next, nil
+}
+
+// GetBlocksPage returns up to limit blocks from the node's database, in
+// order, starting after cursor, along with the cursor for the next page.
+// The cursor is empty for the first page, and the next cursor is empty when
+// there are no more blocks. The node sends at most data.MaxBlocksPerPage.
+func (c *Client) GetBlocksPage(ctx context.Context,
+	cursor data.Cursor, limit int) ([]*data.Block, data.Cursor, error) {
+	ctx, span := util.StartSpan(ctx, "client.GetBlocksPage")
+	defer span.End()
+	return c.queryBlocks(ctx, &data.BlocksMessage{Cursor: cursor, Limit: limit})
+}
+
+// GetAccountBlocksPage is like GetBlocksPage, but it only returns the blocks
+// in which owner's account changed, most recent first.
+func (c *Client) GetAccountBlocksPage(ctx context.Context,
+	owner string, cursor data.Cursor, limit int) ([]*data.Block, data.Cursor, error) {
+	ctx, span := util.StartSpan(ctx, "client.GetAccountBlocksPage")
+	defer span.End()
+	return c.queryBlocks(ctx, &data.BlocksMessage{Owner: owner, Cursor: cursor, Limit: limit})
+}
+
+// GetPeerStats returns the node's stats about each of the other servers in
+// its network config.
+func (c *Client) GetPeerStats(ctx context.Context) ([]*PeerStats, error) {
+	ctx, span := util.StartSpan(ctx, "client.GetPeerStats")
+	defer span.End()
+	m, err := c.request(ctx, &PeersMessage{})
+	if err != nil {
+		return nil, err
+	}
+	peers, ok := m.(*PeersMessage)
+	if !ok || peers.Peers == nil {
+		return nil, fmt.Errorf("expected peer stats but got: %+v", m)
+	}
+	return peers.Peers, nil
+}
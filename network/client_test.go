@@ -0,0 +1,160 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lacker/coinkit/consensus"
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/util"
+)
+
+// cannedConnection answers every message with whatever is in its inbox.
+type cannedConnection struct {
+	inbox chan *util.SignedMessage
+}
+
+func (c *cannedConnection) Close()                           {}
+func (c *cannedConnection) IsClosed() bool                   { return false }
+func (c *cannedConnection) Send(sm *util.SignedMessage) bool { return true }
+func (c *cannedConnection) Receive() chan *util.SignedMessage {
+	return c.inbox
+}
+
+func TestVerifiedClient(t *testing.T) {
+	mint := util.NewKeyPairFromSecretPhrase("mint")
+	bob := util.NewKeyPairFromSecretPhrase("bob")
+	qs, names := consensus.MakeTestQuorumSlice(4)
+	nodes := []*Node{}
+	for i, name := range names {
+		node := NewNodeWithMint(name, qs, nil, mint.PublicKey(), 1000)
+		node.keyPair = util.NewKeyPairFromSecretPhrase(fmt.Sprintf("node%d", i))
+		nodes = append(nodes, node)
+	}
+	connect := func(node *Node) *nodeConnection {
+		conn := newNodeConnection(node)
+		conn.keyPair = node.keyPair
+		return conn
+	}
+	key := func(node *Node) string {
+		return node.keyPair.PublicKey().String()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Save an answer from slot 1 to replay later
+	old := make(chan *util.SignedMessage, 1)
+	conn := connect(nodes[0])
+	SendAnonymousMessage(conn, &util.InfoMessage{Account: bob.PublicKey().String()})
+	old <- <-conn.inbox
+
+	// Finalize slot 1 everywhere but the last node
+	nodes[0].Handle(mint.PublicKey().String(), newSendMessage(mint, bob, 1, 10))
+	for i := 0; i < 10; i++ {
+		for _, source := range nodes[:3] {
+			for _, target := range nodes[:3] {
+				if source != target {
+					sendNodeToNodeMessages(source, target, t)
+				}
+			}
+		}
+		timeOutRounds(nodes[:3])
+	}
+	if nodes[0].Slot() != 2 {
+		t.Fatal("slot 1 did not finish")
+	}
+
+	client := NewVerifiedClient(conn, key(nodes[0]))
+	account, err := client.GetAccount(ctx, bob.PublicKey().String())
+	if err != nil || account == nil || account.Balance != 10 {
+		t.Fatalf("bad account %+v: %v", account, err)
+	}
+	client.conn = &cannedConnection{inbox: old}
+	if _, err := client.GetAccount(ctx, bob.PublicKey().String()); err == nil ||
+		!strings.Contains(err.Error(), "stale") {
+		t.Fatalf("expected the old answer to be stale but got: %v", err)
+	}
+
+	impostor := NewVerifiedClient(connect(nodes[1]), key(nodes[0]))
+	if _, err := impostor.GetAccount(ctx, bob.PublicKey().String()); err == nil {
+		t.Fatal("expected an answer signed by the wrong node to be rejected")
+	}
+
+	// Nodes only agree on an account when they are on the same block
+	clients := []*Client{}
+	for _, node := range nodes {
+		clients = append(clients, NewVerifiedClient(connect(node), key(node)))
+	}
+	account, err = GetAccountFromNodes(ctx, clients, bob.PublicKey().String(), 3)
+	if err != nil || account == nil || account.Balance != 10 {
+		t.Fatalf("bad account %+v: %v", account, err)
+	}
+	if _, err := GetAccountFromNodes(ctx, clients, bob.PublicKey().String(), 4); err == nil {
+		t.Fatal("the last node is behind, so four nodes should not agree")
+	}
+	twice := []*Client{clients[0], NewVerifiedClient(connect(nodes[0]), key(nodes[0]))}
+	if _, err := GetAccountFromNodes(ctx, twice, bob.PublicKey().String(), 2); err == nil {
+		t.Fatal("one node should not count twice")
+	}
+}
+
+func TestEstimateFee(t *testing.T) {
+	mint := util.NewKeyPairFromSecretPhrase("mint")
+	qs, names := consensus.MakeTestQuorumSlice(1)
+	node := NewNodeWithMint(names[0], qs, nil, mint.PublicKey(), 1000)
+	node.keyPair = util.NewKeyPairFromSecretPhrase("node0")
+	conn := newNodeConnection(node)
+	conn.keyPair = node.keyPair
+	client := NewVerifiedClient(conn, node.keyPair.PublicKey().String())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	fee, err := client.EstimateFee(ctx, 1)
+	if err != nil || fee != 0 {
+		t.Fatalf("an idle node should estimate no fee but got %d: %v", fee, err)
+	}
+	answer, ok := node.Handle("client", &currency.FeeMessage{I: 3, Slots: 1})
+	if ok || answer != nil {
+		t.Fatalf("only queries should be answered but got %s", answer)
+	}
+}
+
+func TestGetNetworkStatsWithoutDatabase(t *testing.T) {
+	qs, names := consensus.MakeTestQuorumSlice(1)
+	node := NewNode(names[0], qs, nil)
+	client := NewClient(newNodeConnection(node))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := client.GetNetworkStats(ctx); err == nil ||
+		!strings.Contains(err.Error(), "no database") {
+		t.Fatalf("a node without a database should say so, not %v", err)
+	}
+}
+
+func TestGetAccountReports(t *testing.T) {
+	mint := util.NewKeyPairFromSecretPhrase("mint")
+	bob := util.NewKeyPairFromSecretPhrase("bob")
+	qs, names := consensus.MakeTestQuorumSlice(1)
+	node := NewNodeWithMint(names[0], qs, nil, mint.PublicKey(), 1000)
+	client := NewClient(newNodeConnection(node))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	node.Handle("client", newSendMessage(mint, bob, 1, 10))
+	users := []string{mint.PublicKey().String(), bob.PublicKey().String()}
+	reports, err := client.GetAccountReports(ctx, users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	minted := reports[users[0]]
+	if minted.Account == nil || minted.Account.Balance != 1000 ||
+		len(minted.Pending) != 1 || minted.Pending[0] != 1 {
+		t.Fatalf("the mint should have a pending send: %+v", minted)
+	}
+	if reports[users[1]].Account != nil || reports[users[1]].Activity != nil {
+		t.Fatalf("bob should not exist yet: %+v", reports[users[1]])
+	}
+}
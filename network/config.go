@@ -5,27 +5,128 @@ import (
 	"fmt"
 	"math"
 	"math/rand"
+	"sort"
 	"time"
 
 	"github.com/lacker/coinkit/consensus"
+	"github.com/lacker/coinkit/currency"
 	"github.com/lacker/coinkit/util"
 )
 
 type Address struct {
 	Host string
 	Port int
+
+	// An onion address for the same server, on the same port. It is dialed
+	// instead of Host when connecting through a proxy.
+	Onion string `json:",omitempty"`
 }
 
 func (a *Address) String() string {
 	return fmt.Sprintf("%s:%d", a.Host, a.Port)
 }
 
+// proxied is the address to give a proxy.
+func (a *Address) proxied() string {
+	if a.Onion != "" {
+		return fmt.Sprintf("%s:%d", a.Onion, a.Port)
+	}
+	return a.String()
+}
+
 type Config struct {
+	// Name is which built-in network this is, like "testnet". Servers refuse
+	// to start if their genesis doesn't match it. Empty means any network.
+	// See registry.go.
+	Name string `json:",omitempty"`
+
 	// Servers maps the public key to the address the node is expected to be at.
 	Servers map[string]*Address
 
 	// Threshold defines the quorum for the network
 	Threshold int
+
+	// Standby lists the public keys of servers that aren't validators, but
+	// that can be promoted to replace a validator that stops responding.
+	// The earlier ones are promoted first. Their addresses are in Servers.
+	Standby []string `json:",omitempty"`
+
+	// Encrypt makes servers connect to each other with Noise. Servers accept
+	// both encrypted and plain connections either way, so clients can use
+	// either.
+	Encrypt bool `json:",omitempty"`
+
+	// A SOCKS5 proxy url to dial the other servers through, like
+	// "socks5://127.0.0.1:9050" for Tor. Empty means to dial directly.
+	Proxy string `json:",omitempty"`
+
+	// QUIC is experimental. It connects servers to each other over QUIC,
+	// with consensus messages on their own stream. Clients still use TCP.
+	// See quic.go.
+	QUIC bool `json:",omitempty"`
+
+	// Pipeline makes validators start nominating the next slot while the
+	// current one finishes balloting, which helps on high latency networks.
+	// Validators with and without it can work together.
+	Pipeline bool `json:",omitempty"`
+
+	// VRF makes validators pick nomination leaders with a verifiable random
+	// function of their keys, so nobody knows who will lead until it starts.
+	// Every validator should turn it on together.
+	VRF bool `json:",omitempty"`
+
+	// Operations lists the operation types this node admits to its mempool,
+	// like "Send". Empty means every type. Blocks are accepted no matter
+	// what types of operations are in them.
+	Operations []string `json:",omitempty"`
+
+	// Clients lists the public keys allowed to submit operations to this
+	// node, for private deployments. Servers are always allowed. Empty means
+	// anyone can submit operations.
+	Clients []string `json:",omitempty"`
+
+	// Priority lists the public keys whose operations go in the priority
+	// lane, like a faucet. They are always admitted, even when the mempool
+	// is full or they aren't in Clients, and they go first in the blocks this
+	// node proposes. See currency/priority.go.
+	Priority []string `json:",omitempty"`
+
+	// PriorityShare is the most of a proposed block the priority lane can
+	// take, as a percentage. Zero means currency.DefaultPriorityShare.
+	PriorityShare int `json:",omitempty"`
+
+	// PrivateQueries makes the node answer only messages from Clients and
+	// servers, instead of letting anyone read. It needs Clients to be set.
+	PrivateQueries bool `json:",omitempty"`
+
+	// Decimals is how many decimal places amounts are written with, for
+	// display and for parsing what users type. Zero means raw units.
+	Decimals int `json:",omitempty"`
+
+	// The human-readable prefix for addresses on this network. Empty means
+	// util.DefaultAddressPrefix.
+	AddressPrefix string `json:",omitempty"`
+
+	// Reserve is the least balance an account can hold, other than zero, in
+	// raw units. It is part of the network's genesis, so every node has to
+	// agree on it. See currency.AccountMap.SetReserve.
+	Reserve uint64 `json:",omitempty"`
+
+	// FeePool is the public key of the account that every fee is credited
+	// to. Empty means fees are burned, unless FeesToValidators is set. It is
+	// part of the genesis. See currency/fee_distribution.go.
+	FeePool string `json:",omitempty"`
+
+	// FeesToValidators splits the fees in each block evenly among the
+	// validators for its slot. It can't be combined with FeePool, and it is
+	// part of the genesis too.
+	FeesToValidators bool `json:",omitempty"`
+
+	// Emission mints money on a schedule, to a pool or to the validators.
+	// Nil means no money is ever minted after the mint account starts out
+	// with currency.TotalMoney. It is part of the genesis. See
+	// currency/emission.go.
+	Emission *currency.Emission `json:",omitempty"`
 }
 
 func NewConfigFromSerialized(serialized []byte) *Config {
@@ -46,7 +147,139 @@ func (c *Config) Serialize() []byte {
 	return append(bytes, '\n')
 }
 
-func (c *Config) PeerAddresses(keyPair *util.KeyPair) []*Address {
+// Check returns an error if the config asks for incompatible transports, for
+// operation types that don't exist, for impossible amount or address
+// formats, or for fees or minted money to go somewhere impossible.
+func (c *Config) Check() error {
+	if err := c.checkTransport(); err != nil {
+		return err
+	}
+	if c.Decimals < 0 || c.Decimals > currency.MaxDecimals {
+		return fmt.Errorf("decimals must be between 0 and %d", currency.MaxDecimals)
+	}
+	if c.AddressPrefix != "" && !util.ValidAddressPrefix(c.AddressPrefix) {
+		return fmt.Errorf("the address prefix must be lowercase letters: %q", c.AddressPrefix)
+	}
+	for _, t := range c.Operations {
+		if _, ok := util.OperationTypeMap[t]; !ok {
+			return fmt.Errorf("unknown operation type: %q", t)
+		}
+	}
+	for _, key := range c.Clients {
+		if _, err := util.ReadPublicKey(key); err != nil {
+			return fmt.Errorf("invalid client public key: %q", key)
+		}
+	}
+	for _, key := range c.Priority {
+		if _, err := util.ReadPublicKey(key); err != nil {
+			return fmt.Errorf("invalid priority public key: %q", key)
+		}
+	}
+	if c.PriorityShare < 0 || c.PriorityShare > 100 {
+		return fmt.Errorf("the priority share must be between 0 and 100")
+	}
+	if c.FeePool != "" {
+		if _, err := util.ReadPublicKey(c.FeePool); err != nil {
+			return fmt.Errorf("invalid fee pool public key: %q", c.FeePool)
+		}
+		if c.FeesToValidators {
+			return fmt.Errorf("fees can't go to both a fee pool and the validators")
+		}
+	}
+	if c.Emission != nil {
+		if err := c.Emission.Check(); err != nil {
+			return err
+		}
+		if c.Emission.Pool != "" {
+			if _, err := util.ReadPublicKey(c.Emission.Pool); err != nil {
+				return fmt.Errorf("invalid emission pool public key: %q", c.Emission.Pool)
+			}
+		}
+	}
+	if c.PrivateQueries && len(c.Clients) == 0 {
+		return fmt.Errorf("private queries need a list of clients")
+	}
+	return nil
+}
+
+// ParseAmount converts an amount written with this network's decimals, like
+// "1.25", to units.
+func (c *Config) ParseAmount(s string) (uint64, error) {
+	return currency.ParseAmount(s, c.Decimals)
+}
+
+// FormatAmount writes units with this network's decimals.
+func (c *Config) FormatAmount(units uint64) string {
+	return currency.FormatAmount(units, c.Decimals)
+}
+
+func (c *Config) addressPrefix() string {
+	if c.AddressPrefix == "" {
+		return util.DefaultAddressPrefix
+	}
+	return c.AddressPrefix
+}
+
+// ParseAddress reads an address on this network, or a 0x public key, and
+// returns the 0x public key that the account is known by.
+func (c *Config) ParseAddress(s string) (string, error) {
+	pk, prefix, err := util.ParseAddress(s)
+	if err != nil {
+		return "", err
+	}
+	if prefix != "" && prefix != c.addressPrefix() {
+		return "", fmt.Errorf("%s is an address for a different network, %s", s, prefix)
+	}
+	return pk.String(), nil
+}
+
+// ParseRecipient is like ParseAddress, but it also accepts deposit addresses,
+// since money can be sent to them. Deposit addresses are returned as deposit
+// accounts.
+func (c *Config) ParseRecipient(s string) (string, error) {
+	account, prefix, err := util.ParseAccountAddress(s)
+	if err != nil {
+		return "", err
+	}
+	if prefix != "" && prefix != c.addressPrefix() {
+		return "", fmt.Errorf("%s is an address for a different network, %s", s, prefix)
+	}
+	return account, nil
+}
+
+// FormatAddress writes a 0x public key or a deposit account as an address on
+// this network. Anything else is returned unchanged.
+func (c *Config) FormatAddress(account string) string {
+	return util.AccountAddress(account, c.addressPrefix())
+}
+
+// admitted returns the operation types this node admits to its mempool, or
+// nil if it admits every type.
+func (c *Config) admitted() map[string]bool {
+	if len(c.Operations) == 0 {
+		return nil
+	}
+	answer := make(map[string]bool)
+	for _, t := range c.Operations {
+		answer[t] = true
+	}
+	return answer
+}
+
+// priority returns the public keys in the priority lane, or nil if there
+// aren't any.
+func (c *Config) priority() map[string]bool {
+	if len(c.Priority) == 0 {
+		return nil
+	}
+	answer := make(map[string]bool)
+	for _, key := range c.Priority {
+		answer[key] = true
+	}
+	return answer
+}
+
+func (c *Config) PeerAddresses(keyPair util.Signer) []*Address {
 	answer := []*Address{}
 	for pub, addr := range c.Servers {
 		if keyPair.PublicKey().String() != pub {
@@ -56,14 +289,30 @@ func (c *Config) PeerAddresses(keyPair *util.KeyPair) []*Address {
 	return answer
 }
 
+// QuorumSlice returns the validators we start with, which are all the servers
+// except the standby ones.
 func (c *Config) QuorumSlice() consensus.QuorumSlice {
 	members := []string{}
 	for key, _ := range c.Servers {
-		members = append(members, key)
+		if !c.IsStandby(key) {
+			members = append(members, key)
+		}
 	}
+	// Sorted, so every node built from the same config sends the same
+	// messages. Traces rely on that to replay.
+	sort.Strings(members)
 	return consensus.MakeQuorumSlice(members, c.Threshold)
 }
 
+func (c *Config) IsStandby(publicKey string) bool {
+	for _, key := range c.Standby {
+		if key == publicKey {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Config) GetPort(publicKey string, defaultPort int) int {
 	addr := c.Servers[publicKey]
 	if addr == nil {
@@ -74,12 +323,18 @@ func (c *Config) GetPort(publicKey string, defaultPort int) int {
 }
 
 func (c *Config) RandomAddress() *Address {
+	_, address := c.RandomServer()
+	return address
+}
+
+// RandomServer returns the public key and address of a random server.
+func (c *Config) RandomServer() (string, *Address) {
 	rand.Seed(int64(time.Now().Nanosecond()))
 	index := rand.Intn(len(c.Servers))
 	i := 0
-	for _, address := range c.Servers {
+	for key, address := range c.Servers {
 		if i == index {
-			return address
+			return key, address
 		}
 		i++
 	}
@@ -134,5 +389,6 @@ func NewUnitTestNetwork() (*Config, []*util.KeyPair) {
 
 func NewLocalNetworkConfig() *Config {
 	config, _ := NewLocalhostNetwork(9000, 4, 0)
+	config.Decimals = currency.Decimals
 	return config
 }
@@ -2,7 +2,11 @@ package network
 
 import (
 	"bytes"
+	"sort"
 	"testing"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/util"
 )
 
 func TestSerializingConfig(t *testing.T) {
@@ -22,3 +26,83 @@ func TestSerializingConfig(t *testing.T) {
 		t.Fatal("serialize-deserialize fail in config")
 	}
 }
+
+// Map order used to leave the members in a different order each time, so
+// two nodes built from one config encoded different messages.
+func TestQuorumSliceIsSorted(t *testing.T) {
+	c, _ := NewLocalhostNetwork(9000, 8, 0)
+	members := c.QuorumSlice().Members
+	if len(members) != 8 || !sort.StringsAreSorted(members) {
+		t.Fatalf("expected 8 sorted members but got %v", members)
+	}
+}
+
+func TestAddresses(t *testing.T) {
+	c := NewLocalNetworkConfig()
+	pk := util.NewKeyPairFromSecretPhrase("alice").PublicKey()
+	address := c.FormatAddress(pk.String())
+	for _, input := range []string{address, pk.String()} {
+		parsed, err := c.ParseAddress(input)
+		if err != nil || parsed != pk.String() {
+			t.Fatalf("%s should parse to %s but got %s %v", input, pk, parsed, err)
+		}
+	}
+	if _, err := c.ParseAddress(pk.Address("tcoin")); err == nil {
+		t.Fatal("addresses for other networks should be rejected")
+	}
+
+	// Deposit addresses can be sent to, but they aren't accounts themselves
+	deposit := c.FormatAddress(util.DepositAccount(pk, 42))
+	if _, err := c.ParseAddress(deposit); err == nil {
+		t.Fatal("ParseAddress should reject deposit addresses")
+	}
+	parsed, err := c.ParseRecipient(deposit)
+	if err != nil || parsed != pk.String()+"/42" {
+		t.Fatalf("bad deposit account: %s %v", parsed, err)
+	}
+	c.AddressPrefix = "Bad"
+	if c.Check() == nil {
+		t.Fatal("the prefix should be rejected")
+	}
+}
+
+func TestFeeDistributionConfig(t *testing.T) {
+	c := NewLocalNetworkConfig()
+	id := c.NetworkID()
+	c.FeePool = util.NewKeyPairFromSecretPhrase("pool").PublicKey().String()
+	if err := c.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if c.NetworkID() == id {
+		t.Fatal("the fee pool should be part of the network ID")
+	}
+	c.FeesToValidators = true
+	if c.Check() == nil {
+		t.Fatal("fees can't go to a pool and the validators")
+	}
+	c.FeePool = "pool"
+	c.FeesToValidators = false
+	if c.Check() == nil {
+		t.Fatal("the fee pool should be a public key")
+	}
+}
+
+func TestEmissionConfig(t *testing.T) {
+	c := NewLocalNetworkConfig()
+	id := c.NetworkID()
+	c.Emission = &currency.Emission{Amount: 10, Interval: 5}
+	if err := c.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if c.NetworkID() == id {
+		t.Fatal("the emission should be part of the network ID")
+	}
+	c2 := NewConfigFromSerialized(c.Serialize())
+	if c2.NetworkID() != c.NetworkID() {
+		t.Fatal("the emission should survive serialization")
+	}
+	c.Emission.Pool = "pool"
+	if c.Check() == nil {
+		t.Fatal("the emission pool should be a public key")
+	}
+}
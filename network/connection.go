@@ -1,7 +1,6 @@
 package network
 
 import (
-	"github.com/lacker/coinkit/currency"
 	"github.com/lacker/coinkit/util"
 )
 
@@ -13,48 +12,16 @@ type Connection interface {
 }
 
 // SendAnonymousMessage uses a new random key to send a single message.
-func SendAnonymousMessage(c Connection, message *util.InfoMessage) {
+func SendAnonymousMessage(c Connection, message util.Message) {
 	kp := util.NewKeyPair()
 	sm := util.NewSignedMessage(message, kp)
 	c.Send(sm)
 }
 
-// WaitToClear waits for the transaction with this sequence number to clear.
-func WaitToClear(c Connection, user string, sequence uint32) *currency.Account {
-	for {
-		SendAnonymousMessage(c, &util.InfoMessage{Account: user})
-		m := (<-c.Receive()).Message()
-		accountMessage, ok := m.(*currency.AccountMessage)
-		if !ok {
-			continue
-		}
-		account := accountMessage.State[user]
-		if account == nil {
-			continue
-		}
-		if account.Sequence >= sequence {
-			return account
-		}
-
-		SendAnonymousMessage(c, &util.InfoMessage{I: m.Slot()})
-		<-c.Receive()
-	}
-}
-
-func GetAccount(c Connection, user string) *currency.Account {
-	for {
-		SendAnonymousMessage(c, &util.InfoMessage{Account: user})
-		m := (<-c.Receive()).Message()
-		accountMessage, ok := m.(*currency.AccountMessage)
-		if !ok {
-			util.Logger.Fatalf("expected an account message but got: %+v", m)
-		}
-		return accountMessage.State[user]
-	}
-}
-
+// The answer channel is buffered so that the helper goroutine can exit even if
+// the caller stops waiting for the answer.
 func recHelper(inbox chan *util.SignedMessage, quit chan bool) chan *util.SignedMessage {
-	answer := make(chan *util.SignedMessage)
+	answer := make(chan *util.SignedMessage, 1)
 	go func() {
 		select {
 		case m := <-inbox:
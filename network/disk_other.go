@@ -0,0 +1,12 @@
+//go:build windows || js
+// +build windows js
+
+package network
+
+import (
+	"errors"
+)
+
+func diskSpace(path string) (uint64, uint64, error) {
+	return 0, 0, errors.New("checking disk space is not supported on this platform")
+}
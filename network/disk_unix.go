@@ -0,0 +1,18 @@
+//go:build !windows && !js
+// +build !windows,!js
+
+package network
+
+import (
+	"syscall"
+)
+
+// diskSpace returns how many bytes are available to us, and how big the disk
+// is, for the disk that path is on.
+func diskSpace(path string) (uint64, uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), stat.Blocks * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,117 @@
+package network
+
+import (
+	"context"
+	"sync"
+
+	"github.com/lacker/coinkit/consensus"
+	"github.com/lacker/coinkit/data"
+	"github.com/lacker/coinkit/util"
+)
+
+// If a quorum of validators externalizes a different value for a slot than
+// the one a node externalized, the network has forked, and something is badly
+// wrong with either the node or the network. Rather than keep building on a
+// chain nobody else agrees with, the node halts. It stops taking part in
+// consensus and stops accepting operations, but it keeps answering queries,
+// so the data it has can still be inspected. The evidence is saved to the
+// database, the operator is alerted, and /healthz starts failing.
+// Only a restart clears the halt.
+
+// Fork returns the evidence of the fork that halted this node, or nil if it
+// hasn't halted.
+func (node *Node) Fork() *data.Fork {
+	return node.fork
+}
+
+// halted returns whether the node has seen a fork and stopped producing blocks.
+func (node *Node) halted() bool {
+	return node.fork != nil
+}
+
+// checkFork looks at an externalize message for a slot we already
+// externalized, and halts the node if it and the other conflicting messages
+// we have seen add up to a quorum. Only the slots still kept in memory are
+// checked, since reading older ones from the database would stall the
+// processing goroutine.
+func (node *Node) checkFork(ctx context.Context,
+	sm *util.SignedMessage, m *consensus.ExternalizeMessage) {
+	sender := sm.Signer()
+	if node.halted() || m.I >= node.slot || !node.chain.D.Contains(sender) {
+		return
+	}
+	ours, ok := node.externalizedValue(m.I)
+	if !ok || ours == m.X {
+		return
+	}
+	util.Logger.Printf("%s externalized %s for slot %d, but we externalized %s",
+		util.Shorten(sender), util.Shorten(string(m.X)), m.I, util.Shorten(string(ours)))
+
+	// Only the latest conflict from each validator is kept, so this can't
+	// grow past the size of the quorum slice
+	if node.conflicts == nil {
+		node.conflicts = make(map[string]*util.SignedMessage)
+	}
+	node.conflicts[sender] = sm
+
+	agreeing := []string{}
+	messages := make(map[string]string)
+	for key, c := range node.conflicts {
+		e := c.Message().(*consensus.ExternalizeMessage)
+		if e.I == m.I && e.X == m.X {
+			agreeing = append(agreeing, key)
+			messages[key] = c.Serialize()
+		}
+	}
+	if !node.chain.D.SatisfiedWith(agreeing) {
+		return
+	}
+
+	node.fork = &data.Fork{
+		Slot:        m.I,
+		Stored:      ours,
+		Conflicting: m.X,
+		Messages:    messages,
+	}
+	util.Logger.Printf("halting on a fork: %s", node.fork)
+	if node.database != nil {
+		if err := node.database.InsertFork(ctx, node.fork); err != nil {
+			util.Logger.Printf("could not save the fork evidence: %s", err)
+		}
+	}
+}
+
+// forkHolder is read by the http goroutines, so it is threadsafe.
+type forkHolder struct {
+	mutex sync.Mutex
+	fork  *data.Fork
+}
+
+func (h *forkHolder) set(f *data.Fork) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.fork = f
+}
+
+func (h *forkHolder) get() *data.Fork {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.fork
+}
+
+// Fork returns the evidence of the fork that halted the server, or nil if it
+// hasn't halted.
+func (s *Server) Fork() *data.Fork {
+	return s.fork.get()
+}
+
+// unsafeCheckFork alerts the operator the first time the node halts on a fork.
+func (s *Server) unsafeCheckFork() {
+	f := s.node.Fork()
+	if f == nil || s.fork.get() != nil {
+		return
+	}
+	s.fork.set(f)
+	s.alerts.raise(AlertFork, SeverityCritical, AlertFork,
+		"halted on a fork: %s", f)
+}
@@ -0,0 +1,105 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/lacker/coinkit/consensus"
+	"github.com/lacker/coinkit/data"
+	"github.com/lacker/coinkit/util"
+)
+
+func TestForkHaltsNode(t *testing.T) {
+	kp := util.NewKeyPairFromSecretPhrase("client")
+	kp2 := util.NewKeyPairFromSecretPhrase("bob")
+	qs, names := consensus.MakeTestQuorumSlice(4)
+	nodes := []*Node{}
+	for _, name := range names {
+		node := NewNode(name, qs, nil)
+		node.queue.SetBalance(kp.PublicKey().String(), 100)
+		nodes = append(nodes, node)
+	}
+	nodes[0].Handle(kp.PublicKey().String(), newSendMessage(kp, kp2, 1, 1))
+	for i := 0; i < 10; i++ {
+		for _, source := range nodes {
+			for _, target := range nodes {
+				sendNodeToNodeMessages(source, target, t)
+			}
+		}
+	}
+	node := nodes[0]
+	if node.Slot() != 2 {
+		t.Fatalf("slot 1 did not finish")
+	}
+
+	// The validators' keys come from the same phrases as the test quorum
+	validator := func(i int) *util.KeyPair {
+		return util.NewKeyPairFromSecretPhrase(fmt.Sprintf("node%d", i))
+	}
+	ctx := context.Background()
+	e := &consensus.ExternalizeMessage{I: 1, X: "bogus", Cn: 1, Hn: 1, D: qs}
+	node.HandleSigned(ctx, util.NewSignedMessage(node.chain.GetLast(), validator(1)))
+	node.HandleSigned(ctx, util.NewSignedMessage(e, util.NewKeyPairFromSecretPhrase("stranger")))
+	for i := 1; i < 3; i++ {
+		node.HandleSigned(ctx, util.NewSignedMessage(e, validator(i)))
+	}
+	if node.Fork() != nil {
+		t.Fatalf("two validators are not a quorum")
+	}
+
+	// Without a signature a message is no evidence
+	node.Handle(names[3].String(), e)
+	if node.Fork() != nil {
+		t.Fatalf("an unsigned message should not count")
+	}
+	node.HandleSigned(ctx, util.NewSignedMessage(e, validator(3)))
+	f := node.Fork()
+	if f == nil || f.Slot != 1 || f.Conflicting != "bogus" || len(f.Messages) != 3 ||
+		f.Stored != nodes[1].chain.GetLast().X {
+		t.Fatalf("bad fork: %+v", f)
+	}
+	signed, err := f.SignedMessages()
+	if err != nil || len(signed) != 3 {
+		t.Fatalf("the evidence should check out: %v", err)
+	}
+	f.Messages[names[1].String()] = f.Messages[names[2].String()]
+	if _, err := f.SignedMessages(); err == nil {
+		t.Fatal("a message keyed by the wrong validator should not check out")
+	}
+
+	if len(node.OutgoingMessages()) != 0 {
+		t.Fatalf("a halted node should not send anything")
+	}
+	node.Handle(kp.PublicKey().String(), newSendMessage(kp, kp2, 2, 1))
+	if node.queue.Size() != 0 {
+		t.Fatalf("a halted node should not take operations")
+	}
+	response, ok := node.Handle("client", &HeadMessage{I: 1})
+	if !ok || response.(*HeadMessage).Head != 1 {
+		t.Fatalf("a halted node should still answer queries")
+	}
+}
+
+func TestForkAlert(t *testing.T) {
+	s, _ := newIdleServer(t, 4)
+	defer s.Stop()
+	sink := &fakeAlertSink{alerts: make(chan *Alert, 10)}
+	s.alerts.addSink(&AlertConfig{Kind: "fake"}, sink)
+	go s.alerts.deliverForever()
+
+	s.unsafeCheckFork()
+	if s.Fork() != nil {
+		t.Fatalf("there is no fork yet")
+	}
+	s.node.fork = &data.Fork{Slot: 1, Stored: "a", Conflicting: "b"}
+	s.unsafeCheckFork()
+	s.unsafeCheckFork()
+	a := expectAlert(t, sink.alerts, AlertFork)
+	if a.Severity != SeverityCritical || s.Fork() == nil {
+		t.Fatalf("bad alert: %+v", a)
+	}
+	if len(sink.alerts) != 0 {
+		t.Fatal("a fork should only be alerted once")
+	}
+}
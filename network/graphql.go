@@ -0,0 +1,996 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/data"
+	"github.com/lacker/coinkit/util"
+)
+
+// The GraphQL API exposes documents, accounts, blocks, and events.
+// Amounts are uint64, which doesn't fit in a GraphQL Int, so they are
+// represented as decimal strings.
+
+// The most items a single list field will return
+const maxGraphQLLimit = 100
+
+var errNoDatabase = errors.New("this server has no database")
+
+// A graphQLServer has what the GraphQL API needs to answer queries. Both the
+// consensus Server and the ArchiveServer make one.
+type graphQLServer struct {
+	// nil if there is no database
+	db *data.Database
+
+	// getAccount fetches the current state of an account, or nil if it
+	// doesn't exist
+	getAccount func(ctx context.Context, owner string) (*graphQLAccount, error)
+
+	// newBlock returns a channel that is closed when there may be a new block
+	newBlock func() <-chan bool
+
+	// nil if the server has no mempool of its own
+	mempool *mempoolFeed
+
+	// quit is closed when the server shuts down
+	quit chan bool
+}
+
+// graphQL makes the GraphQL API for this server.
+func (s *Server) graphQL() *graphQLServer {
+	return &graphQLServer{
+		db: s.db,
+		getAccount: func(ctx context.Context, owner string) (*graphQLAccount, error) {
+			return s.getAccount(owner)
+		},
+		newBlock: func() <-chan bool {
+			return s.currentBlock
+		},
+		mempool: s.mempool,
+		quit:    s.quit,
+	}
+}
+
+// graphQLOperation is an operation along with the slot of the block it is in.
+type graphQLOperation struct {
+	*util.SignedOperation
+	Slot int
+}
+
+// graphQLPage is one page of a list, along with the cursor for the next page.
+type graphQLPage struct {
+	Items interface{}
+	Next  data.Cursor
+}
+
+// cursorArg extracts the "after" argument.
+func cursorArg(p graphql.ResolveParams) data.Cursor {
+	after, _ := p.Args["after"].(string)
+	return data.Cursor(after)
+}
+
+// matchArg extracts the "match" argument, which is a JSON object.
+func matchArg(p graphql.ResolveParams) (map[string]interface{}, error) {
+	match := map[string]interface{}{}
+	if m, ok := p.Args["match"].(string); ok {
+		if err := json.Unmarshal([]byte(m), &match); err != nil {
+			return nil, fmt.Errorf("match must be a JSON object: %s", err)
+		}
+	}
+	return match, nil
+}
+
+// graphQLAccount is an account along with its owner.
+type graphQLAccount struct {
+	*currency.Account
+	Owner string
+}
+
+func formatAmount(amount uint64) string {
+	return strconv.FormatUint(amount, 10)
+}
+
+// limitArg extracts the "limit" argument, clamping it to a sensible range.
+func limitArg(p graphql.ResolveParams, defaultLimit int) int {
+	limit, ok := p.Args["limit"].(int)
+	if !ok {
+		limit = defaultLimit
+	}
+	if limit < 1 {
+		return 1
+	}
+	if limit > maxGraphQLLimit {
+		return maxGraphQLLimit
+	}
+	return limit
+}
+
+// blockOperations returns the operations in a block that involve owner.
+// If owner is empty, all operations in the block are returned.
+func blockOperations(b *data.Block, owner string) []*graphQLOperation {
+	answer := []*graphQLOperation{}
+	if b.Chunk == nil {
+		return answer
+	}
+	for _, op := range b.Chunk.Operations {
+		if owner == "" || involves(op, owner) {
+			answer = append(answer, &graphQLOperation{
+				SignedOperation: op,
+				Slot:            b.Slot,
+			})
+		}
+	}
+	return answer
+}
+
+// accountOperations returns the operations that involve owner, most recent
+// first, from up to limit blocks after the cursor.
+// A block can have several operations for the same account, so the page can
+// have more than limit operations.
+func (g *graphQLServer) accountOperations(ctx context.Context,
+	owner string, after data.Cursor, limit int) (*graphQLPage, error) {
+	if g.db == nil {
+		return nil, errNoDatabase
+	}
+	slots, next, err := g.db.GetAccountSlotsPage(ctx, owner, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	answer := []*graphQLOperation{}
+	for _, slot := range slots {
+		b, err := g.db.GetBlock(ctx, slot)
+		if err != nil {
+			return nil, err
+		}
+		if b == nil {
+			continue
+		}
+		ops := blockOperations(b, owner)
+		for i := len(ops) - 1; i >= 0; i-- {
+			answer = append(answer, ops[i])
+		}
+	}
+	return &graphQLPage{Items: answer, Next: next}, nil
+}
+
+// getAccount fetches the current state of an account, by sending the
+// processing goroutine the same message a client would.
+func (s *Server) getAccount(owner string) (*graphQLAccount, error) {
+	sm := util.NewSignedMessage(&util.InfoMessage{Account: owner}, util.NewKeyPair())
+	response, ok := s.handleMessage(sm)
+	if !ok {
+		return nil, errors.New("the server is shutting down")
+	}
+	if response == nil {
+		return nil, nil
+	}
+	m, ok := response.Message().(*currency.AccountMessage)
+	if !ok {
+		return nil, fmt.Errorf("unexpected response: %s", response.Message())
+	}
+	account := m.State[owner]
+	if account == nil {
+		return nil, nil
+	}
+	return &graphQLAccount{Account: account, Owner: owner}, nil
+}
+
+// waitForBlocks sends each newly finalized block to the returned channel,
+// starting after the last block currently in the database.
+// The channel is closed when the context is done.
+func (g *graphQLServer) waitForBlocks(ctx context.Context) (chan interface{}, error) {
+	if g.db == nil {
+		return nil, errNoDatabase
+	}
+	last, err := g.db.LastBlock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	next := 1
+	if last != nil {
+		next = last.Slot + 1
+	}
+
+	output := make(chan interface{})
+	go func() {
+		defer close(output)
+		for {
+			// Grab the channel before checking the database, so that we
+			// can't miss a block that gets finalized in between.
+			newBlock := g.newBlock()
+			blocks, err := g.db.GetBlocks(ctx, next, maxGraphQLLimit)
+			if err != nil {
+				return
+			}
+			for _, b := range blocks {
+				select {
+				case output <- b:
+				case <-ctx.Done():
+					return
+				}
+				next = b.Slot + 1
+			}
+			if len(blocks) > 0 {
+				continue
+			}
+			select {
+			case <-newBlock:
+			case <-ctx.Done():
+				return
+			case <-g.quit:
+				return
+			}
+		}
+	}()
+	return output, nil
+}
+
+// waitForPending sends each operation that gets admitted to the mempool and
+// matches filter to the returned channel. Operations that aren't in a block
+// yet have a slot of zero.
+// The channel is closed when the context is done.
+func (g *graphQLServer) waitForPending(
+	ctx context.Context, filter *PendingFilter) (chan interface{}, error) {
+	if g.mempool == nil {
+		return nil, errors.New("this server has no mempool")
+	}
+	ops, unsubscribe := g.mempool.subscribe(filter)
+	output := make(chan interface{})
+	go func() {
+		defer close(output)
+		defer unsubscribe()
+		for {
+			select {
+			case op := <-ops:
+				select {
+				case output <- &graphQLOperation{SignedOperation: op}:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			case <-g.quit:
+				return
+			}
+		}
+	}()
+	return output, nil
+}
+
+// stringsArg extracts an argument that is a list of strings.
+func stringsArg(p graphql.ResolveParams, name string) []string {
+	answer := []string{}
+	if list, ok := p.Args[name].([]interface{}); ok {
+		for _, item := range list {
+			if str, ok := item.(string); ok {
+				answer = append(answer, str)
+			}
+		}
+	}
+	return answer
+}
+
+// accountKey converts an address argument to the 0x public key that accounts
+// are stored under. Anything that isn't an address is left alone, so it just
+// won't match an account.
+func accountKey(s string) string {
+	pk, _, err := util.ParseAddress(s)
+	if err != nil {
+		return s
+	}
+	return pk.String()
+}
+
+func accountKeys(list []string) []string {
+	answer := []string{}
+	for _, s := range list {
+		answer = append(answer, accountKey(s))
+	}
+	return answer
+}
+
+// pageType makes a type for one page of a list of items.
+// next is the cursor for the following page, or null if this is the last page.
+func pageType(name string, itemType *graphql.Object) *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: name,
+		Fields: graphql.Fields{
+			"items": &graphql.Field{
+				Type: graphql.NewList(itemType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*graphQLPage).Items, nil
+				},
+			},
+			"next": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					next := p.Source.(*graphQLPage).Next
+					if next == "" {
+						return nil, nil
+					}
+					return string(next), nil
+				},
+			},
+		},
+	})
+}
+
+// pageArgs returns the arguments for a paginated field, along with any extra
+// arguments provided.
+func pageArgs(extra ...graphql.FieldConfigArgument) graphql.FieldConfigArgument {
+	args := graphql.FieldConfigArgument{
+		"after": &graphql.ArgumentConfig{Type: graphql.String},
+		"limit": &graphql.ArgumentConfig{Type: graphql.Int},
+	}
+	for _, e := range extra {
+		for key, value := range e {
+			args[key] = value
+		}
+	}
+	return args
+}
+
+func (g *graphQLServer) schema() graphql.Schema {
+	operationType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Operation",
+		Fields: graphql.Fields{
+			"type": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*graphQLOperation).Type, nil
+				},
+			},
+			"signer": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*graphQLOperation).GetSigner(), nil
+				},
+			},
+			"fee": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return formatAmount(p.Source.(*graphQLOperation).GetFee()), nil
+				},
+			},
+			"slot": &graphql.Field{
+				Type: graphql.Int,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*graphQLOperation).Slot, nil
+				},
+			},
+			"signature": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*graphQLOperation).Signature, nil
+				},
+			},
+			"description": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*graphQLOperation).String(), nil
+				},
+			},
+			// data is the whole operation, encoded as JSON
+			"data": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					bytes, err := json.Marshal(p.Source.(*graphQLOperation).Operation)
+					return string(bytes), err
+				},
+			},
+		},
+	})
+
+	scoreType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "ValidatorScore",
+		Fields: graphql.Fields{
+			"validator": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*data.ValidatorScore).Validator, nil
+				},
+			},
+			"slots": &graphql.Field{
+				Type: graphql.Int,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*data.ValidatorScore).Slots, nil
+				},
+			},
+			"nominated": &graphql.Field{
+				Type: graphql.Int,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*data.ValidatorScore).Nominated, nil
+				},
+			},
+			"balloted": &graphql.Field{
+				Type: graphql.Int,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*data.ValidatorScore).Balloted, nil
+				},
+			},
+			"committed": &graphql.Field{
+				Type: graphql.Int,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*data.ValidatorScore).Committed, nil
+				},
+			},
+			"missed": &graphql.Field{
+				Type: graphql.Int,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*data.ValidatorScore).Missed, nil
+				},
+			},
+		},
+	})
+
+	changeType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "AccountChange",
+		Fields: graphql.Fields{
+			"owner": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*data.AccountChange).Owner, nil
+				},
+			},
+			"sequenceBefore": &graphql.Field{
+				Type: graphql.Int,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*data.AccountChange).SequenceBefore, nil
+				},
+			},
+			"balanceBefore": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return formatAmount(p.Source.(*data.AccountChange).BalanceBefore), nil
+				},
+			},
+			"sequenceAfter": &graphql.Field{
+				Type: graphql.Int,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*data.AccountChange).SequenceAfter, nil
+				},
+			},
+			"balanceAfter": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return formatAmount(p.Source.(*data.AccountChange).BalanceAfter), nil
+				},
+			},
+			"existed": &graphql.Field{
+				Type: graphql.Boolean,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*data.AccountChange).Existed, nil
+				},
+			},
+		},
+	})
+
+	blockType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Block",
+		Fields: graphql.Fields{
+			"slot": &graphql.Field{
+				Type: graphql.Int,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*data.Block).Slot, nil
+				},
+			},
+			"c": &graphql.Field{
+				Type: graphql.Int,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*data.Block).C, nil
+				},
+			},
+			"h": &graphql.Field{
+				Type: graphql.Int,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*data.Block).H, nil
+				},
+			},
+			"numOperations": &graphql.Field{
+				Type: graphql.Int,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*data.Block).NumOperations, nil
+				},
+			},
+			"totalFees": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return formatAmount(p.Source.(*data.Block).TotalFees), nil
+				},
+			},
+			"blockHash": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*data.Block).BlockHash, nil
+				},
+			},
+			"previousHash": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*data.Block).PreviousHash, nil
+				},
+			},
+			"operations": &graphql.Field{
+				Type: graphql.NewList(operationType),
+				Args: graphql.FieldConfigArgument{
+					"signer": &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					signer, _ := p.Args["signer"].(string)
+					return blockOperations(p.Source.(*data.Block), accountKey(signer)), nil
+				},
+			},
+			// changes lists the accounts whose balance or sequence number the
+			// block changed
+			"changes": &graphql.Field{
+				Type: graphql.NewList(changeType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if g.db == nil {
+						return nil, errNoDatabase
+					}
+					return g.db.GetBlockDelta(p.Context, p.Source.(*data.Block).Slot)
+				},
+			},
+		},
+	})
+
+	// An operation can refer back to the block it is in
+	operationType.AddFieldConfig("block", &graphql.Field{
+		Type: blockType,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			if g.db == nil {
+				return nil, errNoDatabase
+			}
+			return g.db.GetBlock(p.Context, p.Source.(*graphQLOperation).Slot)
+		},
+	})
+
+	accountType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Account",
+		Fields: graphql.Fields{
+			"owner": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*graphQLAccount).Owner, nil
+				},
+			},
+			"sequence": &graphql.Field{
+				Type: graphql.Int,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return int(p.Source.(*graphQLAccount).Sequence), nil
+				},
+			},
+			"balance": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return formatAmount(p.Source.(*graphQLAccount).Balance), nil
+				},
+			},
+			// data is the metadata the owner set on the account, as a JSON
+			// object
+			"data": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					data := p.Source.(*graphQLAccount).Data
+					if data == nil {
+						data = map[string]string{}
+					}
+					bytes, err := json.Marshal(data)
+					return string(bytes), err
+				},
+			},
+			// storage is how many bytes of documents the account has
+			// written, and documentQuota is how many it can write
+			"storage": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return formatAmount(p.Source.(*graphQLAccount).Storage), nil
+				},
+			},
+			"documentQuota": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return formatAmount(p.Source.(*graphQLAccount).DocumentQuota()), nil
+				},
+			},
+			// created is the slot the account was first funded in, and
+			// lastActive is the last slot it changed in
+			"created": &graphql.Field{
+				Type: graphql.Int,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*graphQLAccount).Created, nil
+				},
+			},
+			"lastActive": &graphql.Field{
+				Type: graphql.Int,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*graphQLAccount).LastActive, nil
+				},
+			},
+			"operations": &graphql.Field{
+				Type: pageType("OperationPage", operationType),
+				Args: pageArgs(),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					owner := p.Source.(*graphQLAccount).Owner
+					return g.accountOperations(
+						p.Context, owner, cursorArg(p), limitArg(p, 10))
+				},
+			},
+		},
+	})
+
+	documentType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Document",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return formatAmount(p.Source.(*data.Document).Id), nil
+				},
+			},
+			// data is the document contents, encoded as JSON
+			"data": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*data.Document).Data.String(), nil
+				},
+			},
+		},
+	})
+
+	documentStatsType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "DocumentStats",
+		Fields: graphql.Fields{
+			"count": &graphql.Field{
+				Type: graphql.Int,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*data.DocumentStats).Count, nil
+				},
+			},
+			"numValues": &graphql.Field{
+				Type: graphql.Int,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*data.DocumentStats).NumValues, nil
+				},
+			},
+			"sum": &graphql.Field{
+				Type: graphql.Float,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*data.DocumentStats).Sum, nil
+				},
+			},
+			"min": &graphql.Field{
+				Type: graphql.Float,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*data.DocumentStats).Min, nil
+				},
+			},
+			"max": &graphql.Field{
+				Type: graphql.Float,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*data.DocumentStats).Max, nil
+				},
+			},
+		},
+	})
+
+	eventType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Event",
+		Fields: graphql.Fields{
+			"seq": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return strconv.FormatInt(p.Source.(*data.Event).Seq, 10), nil
+				},
+			},
+			"slot": &graphql.Field{
+				Type: graphql.Int,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*data.Event).Slot, nil
+				},
+			},
+			"type": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*data.Event).Type, nil
+				},
+			},
+			// data depends on the type, encoded as JSON
+			"data": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*data.Event).Data.String(), nil
+				},
+			},
+		},
+	})
+
+	queryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"block": &graphql.Field{
+				Type: blockType,
+				Args: graphql.FieldConfigArgument{
+					"slot": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if g.db == nil {
+						return nil, errNoDatabase
+					}
+					b, err := g.db.GetBlock(p.Context, p.Args["slot"].(int))
+					if b == nil || err != nil {
+						return nil, err
+					}
+					return b, nil
+				},
+			},
+			"blocks": &graphql.Field{
+				Type: pageType("BlockPage", blockType),
+				Args: pageArgs(),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if g.db == nil {
+						return nil, errNoDatabase
+					}
+					blocks, next, err := g.db.GetBlocksPage(
+						p.Context, cursorArg(p), limitArg(p, 10))
+					if err != nil {
+						return nil, err
+					}
+					return &graphQLPage{Items: blocks, Next: next}, nil
+				},
+			},
+			"account": &graphql.Field{
+				Type: accountType,
+				Args: graphql.FieldConfigArgument{
+					"owner": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					a, err := g.getAccount(p.Context, accountKey(p.Args["owner"].(string)))
+					if a == nil || err != nil {
+						return nil, err
+					}
+					return a, nil
+				},
+			},
+			// inactiveAccounts lists the accounts that have not changed in the
+			// last slots slots, least recently active first.
+			"inactiveAccounts": &graphql.Field{
+				Type: graphql.NewList(accountType),
+				Args: graphql.FieldConfigArgument{
+					"slots": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"limit": &graphql.ArgumentConfig{Type: graphql.Int},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if g.db == nil {
+						return nil, errNoDatabase
+					}
+					last, err := g.db.LastBlock(p.Context)
+					if last == nil || err != nil {
+						return []*graphQLAccount{}, err
+					}
+					since := last.Slot - p.Args["slots"].(int)
+					deltas, err := g.db.GetInactiveAccounts(p.Context, since, limitArg(p, 10))
+					if err != nil {
+						return nil, err
+					}
+					answer := []*graphQLAccount{}
+					for _, delta := range deltas {
+						answer = append(answer,
+							&graphQLAccount{Account: delta.Account(), Owner: delta.Owner})
+					}
+					return answer, nil
+				},
+			},
+			// scoreboard sums up how each validator took part in the last
+			// slots slots, as this node saw it.
+			"scoreboard": &graphql.Field{
+				Type: graphql.NewList(scoreType),
+				Args: graphql.FieldConfigArgument{
+					"slots": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if g.db == nil {
+						return nil, errNoDatabase
+					}
+					last, err := g.db.LastBlock(p.Context)
+					if last == nil || err != nil {
+						return []*data.ValidatorScore{}, err
+					}
+					return g.db.GetScoreboard(p.Context, last.Slot-p.Args["slots"].(int))
+				},
+			},
+			// match is a JSON object. Documents are returned if they contain it.
+			"documents": &graphql.Field{
+				Type: pageType("DocumentPage", documentType),
+				Args: pageArgs(graphql.FieldConfigArgument{
+					"match": &graphql.ArgumentConfig{Type: graphql.String},
+				}),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if g.db == nil {
+						return nil, errNoDatabase
+					}
+					match, err := matchArg(p)
+					if err != nil {
+						return nil, err
+					}
+					docs, next, err := g.db.GetDocumentsPage(
+						p.Context, match, cursorArg(p), limitArg(p, 10))
+					if err != nil {
+						return nil, err
+					}
+					return &graphQLPage{Items: docs, Next: next}, nil
+				},
+			},
+			// events tails the event log. Unlike the other pages, next is only null
+			// when there have been no events, so it can be polled for new events.
+			"events": &graphql.Field{
+				Type: pageType("EventPage", eventType),
+				Args: pageArgs(graphql.FieldConfigArgument{
+					"types": &graphql.ArgumentConfig{Type: graphql.NewList(graphql.String)},
+				}),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if g.db == nil {
+						return nil, errNoDatabase
+					}
+					events, next, err := g.db.TailEvents(
+						p.Context, cursorArg(p), stringsArg(p, "types"), limitArg(p, 10))
+					if err != nil {
+						return nil, err
+					}
+					return &graphQLPage{Items: events, Next: next}, nil
+				},
+			},
+			// documentStats aggregates over the documents that contain match.
+			// The sum, min, and max are over the numeric values of field.
+			"documentStats": &graphql.Field{
+				Type: documentStatsType,
+				Args: graphql.FieldConfigArgument{
+					"match": &graphql.ArgumentConfig{Type: graphql.String},
+					"field": &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if g.db == nil {
+						return nil, errNoDatabase
+					}
+					match, err := matchArg(p)
+					if err != nil {
+						return nil, err
+					}
+					field, _ := p.Args["field"].(string)
+					return g.db.AggregateDocuments(p.Context, match, field)
+				},
+			},
+		},
+	})
+
+	subscriptionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Subscription",
+		Fields: graphql.Fields{
+			"blocks": &graphql.Field{
+				Type: blockType,
+				Subscribe: func(p graphql.ResolveParams) (interface{}, error) {
+					return g.waitForBlocks(p.Context)
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source, nil
+				},
+			},
+			// pendingOperations streams operations as they are admitted to
+			// the mempool, optionally only the ones that involve one of
+			// accounts or have one of types.
+			"pendingOperations": &graphql.Field{
+				Type: operationType,
+				Args: graphql.FieldConfigArgument{
+					"accounts": &graphql.ArgumentConfig{Type: graphql.NewList(graphql.String)},
+					"types":    &graphql.ArgumentConfig{Type: graphql.NewList(graphql.String)},
+				},
+				Subscribe: func(p graphql.ResolveParams) (interface{}, error) {
+					return g.waitForPending(p.Context, &PendingFilter{
+						Accounts: accountKeys(stringsArg(p, "accounts")),
+						Types:    stringsArg(p, "types"),
+					})
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source, nil
+				},
+			},
+		},
+	})
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query:        queryType,
+		Subscription: subscriptionType,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+// graphQLRequest is the standard format for a GraphQL request over HTTP.
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// parseGraphQLRequest accepts either a GET with a query parameter, or a POST
+// with a JSON body.
+func parseGraphQLRequest(r *http.Request) (*graphQLRequest, error) {
+	req := &graphQLRequest{}
+	if r.Method == http.MethodGet {
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+		if v := r.URL.Query().Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				return nil, err
+			}
+		}
+		return req, nil
+	}
+	err := json.NewDecoder(r.Body).Decode(req)
+	return req, err
+}
+
+func (g *graphQLServer) params(r *http.Request, schema graphql.Schema) (*graphql.Params, error) {
+	req, err := parseGraphQLRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	return &graphql.Params{
+		Schema:         schema,
+		RequestString:  req.Query,
+		OperationName:  req.OperationName,
+		VariableValues: req.Variables,
+		Context:        r.Context(),
+	}, nil
+}
+
+// handleGraphQL serves queries. The response is a single JSON object.
+func (g *graphQLServer) handleGraphQL(schema graphql.Schema) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params, err := g.params(r, schema)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		result := graphql.Do(*params)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// handleGraphQLSubscribe serves subscriptions as server-sent events, with one
+// event per result. It runs until the client disconnects.
+func (g *graphQLServer) handleGraphQLSubscribe(schema graphql.Schema) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+		params, err := g.params(r, schema)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		for result := range graphql.Subscribe(*params) {
+			bytes, err := json.Marshal(result)
+			if err != nil {
+				panic(err)
+			}
+			fmt.Fprintf(w, "data: %s\n\n", bytes)
+			flusher.Flush()
+		}
+	}
+}
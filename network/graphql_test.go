@@ -0,0 +1,102 @@
+package network
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/data"
+	"github.com/lacker/coinkit/util"
+)
+
+type graphQLResponse struct {
+	Data   map[string]interface{}
+	Errors []map[string]interface{}
+}
+
+func queryGraphQL(t *testing.T, s *Server, query string) *graphQLResponse {
+	g := s.graphQL()
+	handler := g.handleGraphQL(g.schema())
+	r := httptest.NewRequest("GET", "/graphql?query="+url.QueryEscape(query), nil)
+	w := httptest.NewRecorder()
+	handler(w, r)
+	response := &graphQLResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), response); err != nil {
+		t.Fatalf("bad response %s: %s", w.Body.String(), err)
+	}
+	return response
+}
+
+func TestGraphQLAccount(t *testing.T) {
+	servers := makeServers()
+	defer stopServers(servers)
+	mint := util.NewKeyPairFromSecretPhrase("mint").PublicKey().String()
+
+	response := queryGraphQL(t, servers[0],
+		`{ account(owner: "`+mint+`") { owner sequence balance } }`)
+	if len(response.Errors) > 0 {
+		t.Fatalf("unexpected errors: %+v", response.Errors)
+	}
+	account := response.Data["account"].(map[string]interface{})
+	if account["owner"] != mint {
+		t.Fatalf("bad owner: %+v", account)
+	}
+	if account["balance"] != formatAmount(currency.TotalMoney) {
+		t.Fatalf("bad balance: %+v", account)
+	}
+
+	// Accounts can be looked up by address too
+	pk, _ := util.ReadPublicKey(mint)
+	response = queryGraphQL(t, servers[0],
+		`{ account(owner: "`+pk.Address(util.DefaultAddressPrefix)+`") { owner } }`)
+	account = response.Data["account"].(map[string]interface{})
+	if account["owner"] != mint {
+		t.Fatalf("bad owner for an address: %+v", account)
+	}
+
+	// Accounts that don't exist should be null
+	response = queryGraphQL(t, servers[0], `{ account(owner: "nobody") { owner } }`)
+	if response.Data["account"] != nil {
+		t.Fatalf("expected a null account but got: %+v", response.Data)
+	}
+}
+
+func TestGraphQLWithoutDatabase(t *testing.T) {
+	servers := makeServers()
+	defer stopServers(servers)
+	response := queryGraphQL(t, servers[0], `{ blocks { items { slot } } }`)
+	if len(response.Errors) != 1 ||
+		!strings.Contains(response.Errors[0]["message"].(string), "no database") {
+		t.Fatalf("expected a database error but got: %+v", response)
+	}
+}
+
+func TestBlockOperations(t *testing.T) {
+	bob := util.NewKeyPairFromSecretPhrase("bob")
+	carol := util.NewKeyPairFromSecretPhrase("carol")
+	send := func(from *util.KeyPair, to *util.KeyPair) *util.SignedOperation {
+		return util.NewSignedOperation(&currency.SendOperation{
+			Signer:   from.PublicKey().String(),
+			Sequence: 1,
+			To:       to.PublicKey().String(),
+			Amount:   1,
+		}, from)
+	}
+	chunk := currency.NewEmptyChunk()
+	chunk.Operations = []*util.SignedOperation{send(bob, carol), send(carol, carol)}
+	b := &data.Block{Slot: 3, Chunk: chunk}
+
+	if len(blockOperations(b, "")) != 2 {
+		t.Fatalf("expected all operations with no owner")
+	}
+	ops := blockOperations(b, bob.PublicKey().String())
+	if len(ops) != 1 || ops[0].Slot != 3 {
+		t.Fatalf("expected bob to be in one operation but got %+v", ops)
+	}
+	if len(blockOperations(b, carol.PublicKey().String())) != 2 {
+		t.Fatalf("expected the recipient to be involved in both operations")
+	}
+}
@@ -0,0 +1,290 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lacker/coinkit/consensus"
+	"github.com/lacker/coinkit/util"
+)
+
+// When a server restarts with blocks in its database, it checks its head
+// against the other servers before it serves anyone. If it is behind, it
+// catches up from them first. If it externalized something different from
+// them for the same slot, the database is on a divergent chain, and serving
+// from it would only spread bad data, so the server refuses to start.
+
+// How long the head check waits for the other servers to answer
+const DefaultHeadCheckTimeout = 5 * time.Second
+
+// A HeadMessage asks a server for the last slot it externalized, and for the
+// value it externalized for slot I. A query has Head set to zero.
+type HeadMessage struct {
+	I int
+
+	// The last slot the server externalized
+	Head int `json:",omitempty"`
+
+	// The value the server externalized for slot I. Empty if it hasn't
+	// externalized slot I, or no longer knows what it was.
+	X consensus.SlotValue `json:",omitempty"`
+}
+
+func (m *HeadMessage) Slot() int {
+	return m.I
+}
+
+func (m *HeadMessage) MessageType() string {
+	return "B"
+}
+
+func (m *HeadMessage) String() string {
+	if m.IsQuery() {
+		return fmt.Sprintf("head query i=%d", m.I)
+	}
+	return fmt.Sprintf("head=%d i=%d x=%s", m.Head, m.I, util.Shorten(string(m.X)))
+}
+
+// IsQuery returns whether this is a query. A server that has not
+// externalized anything answers with what looks like a query, which means
+// the same thing.
+func (m *HeadMessage) IsQuery() bool {
+	return m.Head == 0
+}
+
+func init() {
+	util.RegisterMessageType(&HeadMessage{})
+}
+
+// externalizedValue returns the value we externalized for a slot, if we
+// still keep it in memory.
+func (node *Node) externalizedValue(slot int) (consensus.SlotValue, bool) {
+	if e := node.chain.History(slot); e != nil {
+		return e.X, true
+	}
+	return "", false
+}
+
+// handleHeadMessage only looks in memory. For older slots the X is left
+// empty, and the server fills it in from the database.
+func (node *Node) handleHeadMessage(m *HeadMessage) *HeadMessage {
+	answer := &HeadMessage{I: m.I, Head: node.Slot() - 1}
+	if m.I > 0 && m.I <= answer.Head {
+		answer.X, _ = node.externalizedValue(m.I)
+	}
+	return answer
+}
+
+// handleHeadQuery answers a head query, loading the value from the database
+// when the node no longer keeps the slot in memory.
+func (s *Server) handleHeadQuery(sm *util.SignedMessage) (*util.SignedMessage, bool) {
+	response, ok := s.handleMessageOnce(sm)
+	if response == nil {
+		return response, ok
+	}
+	head, isHead := response.Message().(*HeadMessage)
+	if !isHead || head.X != "" || head.I < 1 || head.I > head.Head {
+		return response, ok
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	x, found := s.stored.value(ctx, head.I)
+	if !found {
+		return response, ok
+	}
+	answer := &HeadMessage{I: head.I, Head: head.Head, X: x}
+	return util.NewSignedMessageForVersion(answer, s.keyPair, sm.ProtocolVersion()), ok
+}
+
+// GetHead asks the node for its last externalized slot, and for what it
+// externalized for slot. If the client knows the node's key, the answer has
+// to be signed by it.
+func (c *Client) GetHead(ctx context.Context, slot int) (*HeadMessage, error) {
+	ctx, span := util.StartSpan(ctx, "client.GetHead")
+	defer span.End()
+	sm, err := c.requestSigned(ctx, &HeadMessage{I: slot})
+	if err != nil {
+		return nil, err
+	}
+	if c.nodeKey != "" && sm.Signer() != c.nodeKey {
+		return nil, fmt.Errorf("expected a message signed by %s but got one from %s",
+			c.nodeKey, sm.Signer())
+	}
+	head, ok := sm.Message().(*HeadMessage)
+	if !ok || head.I != slot {
+		return nil, fmt.Errorf("expected the head with slot %d but got: %+v", slot, sm.Message())
+	}
+	return head, nil
+}
+
+// HeadReport compares our head with the heads of the other servers.
+type HeadReport struct {
+	// The last slot we externalized
+	Local int
+
+	// The highest last slot that another server externalized
+	Network int
+
+	// How many of the other servers answered
+	Answered int
+
+	// The servers that externalized something different from us, and the
+	// slot it happened at for each of them
+	Divergent map[string]int
+}
+
+// compare checks what a server said against our own value for the slot it
+// told us about, which is the last one we both externalized. ours is empty
+// if we don't know it.
+func (r *HeadReport) compare(key string, peer *HeadMessage, ours consensus.SlotValue) {
+	r.Answered++
+	if peer.Head > r.Network {
+		r.Network = peer.Head
+	}
+	if peer.X != "" && ours != "" && peer.X != ours {
+		r.Divergent[key] = peer.I
+	}
+}
+
+// Behind returns whether another server has externalized further than us.
+func (r *HeadReport) Behind() bool {
+	return r.Network > r.Local
+}
+
+// Diverged returns whether any server externalized something different from
+// us for the same slot.
+func (r *HeadReport) Diverged() bool {
+	return len(r.Divergent) > 0
+}
+
+func (r *HeadReport) String() string {
+	parts := []string{fmt.Sprintf("local head %d, network head %d, %d answered",
+		r.Local, r.Network, r.Answered)}
+	for key, slot := range r.Divergent {
+		parts = append(parts, fmt.Sprintf("%s diverged at slot %d", util.Shorten(key), slot))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func closeClients(clients map[string]*Client) {
+	for _, c := range clients {
+		c.Close()
+	}
+}
+
+// headClients connects to each of the other servers, keyed by public key.
+func (s *Server) headClients() map[string]*Client {
+	answer := make(map[string]*Client)
+	for key, address := range s.config.Servers {
+		if key == s.keyPair.PublicKey().String() {
+			continue
+		}
+		conn := newRedialConnection(address, nil, nil, "", s.dialer)
+		answer[key] = NewVerifiedClient(conn, key)
+	}
+	return answer
+}
+
+// CheckHead asks the other servers for their heads and compares them with
+// ours. Servers that don't answer before ctx is done are left out.
+// It must be called before the server starts processing messages, since it
+// uses the node directly.
+func (s *Server) CheckHead(ctx context.Context) *HeadReport {
+	clients := s.headClients()
+	defer closeClients(clients)
+	return s.checkHead(ctx, clients)
+}
+
+func (s *Server) checkHead(ctx context.Context, clients map[string]*Client) *HeadReport {
+	report := &HeadReport{
+		Local:     s.node.Slot() - 1,
+		Divergent: make(map[string]int),
+	}
+
+	type answer struct {
+		key  string
+		head *HeadMessage
+	}
+	answers := make(chan *answer, len(clients))
+	wg := sync.WaitGroup{}
+	for key, client := range clients {
+		wg.Add(1)
+		go func(key string, client *Client) {
+			defer wg.Done()
+			head, err := client.GetHead(ctx, report.Local)
+			if err != nil {
+				s.Logf("no head from %s: %s", util.Shorten(key), err)
+				return
+			}
+			if head.Head > 0 && head.Head < report.Local {
+				// They are behind, so compare their last slot instead
+				head, err = client.GetHead(ctx, head.Head)
+				if err != nil {
+					return
+				}
+			}
+			answers <- &answer{key: key, head: head}
+		}(key, client)
+	}
+	wg.Wait()
+	close(answers)
+
+	for a := range answers {
+		ours, ok := s.node.externalizedValue(a.head.I)
+		if !ok {
+			ours, _ = s.stored.value(ctx, a.head.I)
+		}
+		report.compare(a.key, a.head, ours)
+	}
+	return report
+}
+
+// catchUp fetches the slots we are missing from the servers that have them,
+// and hands them to the node. It returns once the node has caught up to
+// head, or stops making progress. Each request gets the head check timeout.
+func (s *Server) catchUp(clients map[string]*Client, head int) {
+	for s.node.Slot() <= head {
+		slot := s.node.Slot()
+		for key, client := range clients {
+			ctx, cancel := context.WithTimeout(context.Background(), s.HeadCheckTimeout)
+			history, err := client.GetHistory(ctx, slot)
+			cancel()
+			if err != nil {
+				continue
+			}
+			s.node.Handle(key, history)
+			if s.node.Slot() > slot {
+				break
+			}
+		}
+		if s.node.Slot() == slot {
+			s.Logf("could not catch up past slot %d before serving", slot)
+			return
+		}
+	}
+}
+
+// checkHeadOnBoot runs the head check for a server whose database has
+// blocks in it. It refuses to start on a divergent chain, and catches up
+// first if it is behind.
+func (s *Server) checkHeadOnBoot() {
+	if s.db == nil || s.node.Slot() == 1 || s.HeadCheckTimeout == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.HeadCheckTimeout)
+	clients := s.headClients()
+	defer closeClients(clients)
+	report := s.checkHead(ctx, clients)
+	cancel()
+	s.Logf("head check: %s", report)
+	if report.Diverged() {
+		util.Logger.Fatalf("the database is on a divergent chain: %s", report)
+	}
+	if report.Behind() {
+		s.catchUp(clients, report.Network)
+		s.Logf("caught up to slot %d before serving", s.node.Slot()-1)
+	}
+}
@@ -0,0 +1,74 @@
+package network
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lacker/coinkit/consensus"
+	"github.com/lacker/coinkit/util"
+)
+
+func TestHeadMessage(t *testing.T) {
+	kp := util.NewKeyPairFromSecretPhrase("client")
+	kp2 := util.NewKeyPairFromSecretPhrase("bob")
+	qs, names := consensus.MakeTestQuorumSlice(4)
+	nodes := []*Node{}
+	for _, name := range names {
+		node := NewNode(name, qs, nil)
+		node.queue.SetBalance(kp.PublicKey().String(), 100)
+		nodes = append(nodes, node)
+	}
+	nodes[0].Handle(kp.PublicKey().String(), newSendMessage(kp, kp2, 1, 1))
+	for i := 0; i < 10; i++ {
+		for _, source := range nodes {
+			for _, target := range nodes {
+				sendNodeToNodeMessages(source, target, t)
+			}
+		}
+	}
+	if nodes[0].Slot() != 2 {
+		t.Fatalf("slot 1 did not finish")
+	}
+
+	response, ok := nodes[0].Handle("client", &HeadMessage{I: 1})
+	head := response.(*HeadMessage)
+	if !ok || head.IsQuery() || head.Head != 1 || head.X != nodes[1].chain.GetLast().X {
+		t.Fatalf("bad head: %s", head)
+	}
+	response, _ = nodes[0].Handle("client", &HeadMessage{I: 2})
+	if head := response.(*HeadMessage); head.Head != 1 || head.X != "" {
+		t.Fatalf("slot 2 should not have a value yet: %s", head)
+	}
+	if _, ok := nodes[0].Handle("client", head); ok {
+		t.Fatalf("an answer should not get an answer")
+	}
+}
+
+func TestHeadReport(t *testing.T) {
+	r := &HeadReport{Local: 5, Divergent: make(map[string]int)}
+	r.compare("same", &HeadMessage{I: 5, Head: 7, X: "a"}, "a")
+	r.compare("unknown", &HeadMessage{I: 3, Head: 3, X: "b"}, "")
+	r.compare("fresh", &HeadMessage{I: 5}, "a")
+	if r.Answered != 3 || r.Network != 7 || !r.Behind() || r.Diverged() {
+		t.Fatalf("bad report: %s", r)
+	}
+	r.compare("forked", &HeadMessage{I: 4, Head: 4, X: "c"}, "d")
+	if !r.Diverged() || r.Divergent["forked"] != 4 {
+		t.Fatalf("the fork should be reported: %s", r)
+	}
+}
+
+func TestCheckHead(t *testing.T) {
+	servers := makeServers()
+	defer stopServers(servers)
+
+	// A server restarting with the same key checks itself against the others
+	restarted := NewServer(servers[0].keyPair, servers[0].config, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	report := restarted.CheckHead(ctx)
+	if report.Answered != 3 || report.Local != 0 || report.Behind() || report.Diverged() {
+		t.Fatalf("bad report: %s", report)
+	}
+}
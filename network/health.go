@@ -0,0 +1,113 @@
+package network
+
+import (
+	"context"
+	"time"
+)
+
+// How often the server checks the things that can raise alerts, other than
+// stalls, which the watchdog checks.
+const DefaultHealthCheckInterval = time.Minute
+
+// HealthThresholds are the limits that raise alerts. Zero turns a check off.
+type HealthThresholds struct {
+	// The directory whose disk to watch, like the database's data directory.
+	// Empty means not to watch any disk.
+	DiskPath string
+
+	// Alert when less than this percent of the disk is free. Below half of
+	// it, the alert is critical.
+	MinFreeDiskPercent int
+
+	// Alert when fewer than this many of the other servers are connected
+	MinPeers int
+
+	// Alert when one of the other servers sends this many messages with
+	// invalid signatures between two health checks
+	MaxInvalidSignatures int
+}
+
+func DefaultHealthThresholds() HealthThresholds {
+	return HealthThresholds{
+		MinFreeDiskPercent:   10,
+		MaxInvalidSignatures: 100,
+	}
+}
+
+// AddAlertSink sends alerts to a sink. It must be called before the server
+// starts serving.
+func (s *Server) AddAlertSink(config *AlertConfig) {
+	if len(s.alerts.sinks) == 0 {
+		go s.alerts.deliverForever()
+	}
+	s.alerts.addSink(config, newAlertSink(config))
+}
+
+// monitorForever should be run in its own goroutine. It only runs when there
+// are alert sinks.
+func (s *Server) monitorForever() {
+	if len(s.alerts.sinks) == 0 {
+		return
+	}
+	ticker := time.NewTicker(s.HealthCheckInterval)
+	defer ticker.Stop()
+	invalid := make(map[string]int)
+	for {
+		select {
+		case <-ticker.C:
+			s.checkHealth(invalid)
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// checkHealth raises an alert for each threshold that is crossed.
+// invalid has the invalid signature counts as of the last check, and gets
+// updated.
+func (s *Server) checkHealth(invalid map[string]int) {
+	if s.db != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), s.HealthCheckInterval)
+		err := s.db.Ping(ctx)
+		cancel()
+		if err != nil {
+			s.alerts.raise(AlertDatabase, SeverityCritical, AlertDatabase,
+				"the database is unreachable: %s", err)
+		}
+	}
+
+	h := s.Health
+	if h.DiskPath != "" && h.MinFreeDiskPercent > 0 {
+		free, total, err := diskSpace(h.DiskPath)
+		if err != nil {
+			s.alerts.raise(AlertDiskSpace, SeverityWarning, AlertDiskSpace,
+				"could not check the disk space of %s: %s", h.DiskPath, err)
+		} else if total > 0 && free*100 < total*uint64(h.MinFreeDiskPercent) {
+			severity := SeverityWarning
+			if free*200 < total*uint64(h.MinFreeDiskPercent) {
+				severity = SeverityCritical
+			}
+			s.alerts.raise(AlertDiskSpace, severity, AlertDiskSpace+" "+severity,
+				"only %.1f%% of the disk for %s is free", 100*float64(free)/float64(total),
+				h.DiskPath)
+		}
+	}
+
+	if h.MinPeers > 0 {
+		if n := s.numPeersConnected(); n < h.MinPeers {
+			s.alerts.raise(AlertPeers, SeverityWarning, AlertPeers,
+				"only %d of the other servers are connected", n)
+		}
+	}
+
+	for _, ps := range s.peerTracker.snapshot() {
+		count := ps.InvalidSignatures - invalid[ps.PublicKey]
+		invalid[ps.PublicKey] = ps.InvalidSignatures
+		if h.MaxInvalidSignatures > 0 && count >= h.MaxInvalidSignatures {
+			s.alerts.raise(AlertInvalidSignatures, SeverityWarning,
+				AlertInvalidSignatures+" "+ps.PublicKey,
+				"%d messages claiming to be from %s had invalid signatures",
+				count, ps.PublicKey)
+		}
+	}
+}
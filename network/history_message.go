@@ -25,6 +25,18 @@ func (m *HistoryMessage) MessageType() string {
 	return "H"
 }
 
+func (m *HistoryMessage) CheckLimits() error {
+	if m.T != nil {
+		if err := m.T.CheckLimits(); err != nil {
+			return err
+		}
+	}
+	if m.E != nil {
+		return m.E.CheckLimits()
+	}
+	return nil
+}
+
 func (m *HistoryMessage) String() string {
 	return fmt.Sprintf("history i=%d: %s %s", m.I, m.T, m.E)
 }
@@ -0,0 +1,193 @@
+package network
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lacker/coinkit/consensus"
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/util"
+)
+
+// A LightClient keeps track of every account without replaying the chain
+// from genesis. The only thing it trusts is the validator set it is given.
+// It starts from the latest checkpoint that a quorum of validators signed,
+// and checks the accounts it gets against the checkpoint's root. From then on
+// it moves a checkpoint at a time: it applies each block up to the next
+// checkpoint itself, checking every operation as it goes, and only keeps the
+// blocks if they lead to the root that a quorum signed. So a node can't feed
+// it blocks that the validators never finalized.
+// It follows validator set changes in the blocks it applies. Votes for a
+// change that were finalized before the checkpoint it starts from aren't in
+// the checkpoint, so if one of those takes effect later, the client can't
+// verify checkpoints from then on, and needs to start again with the new
+// validator set.
+// LightClient is not threadsafe.
+type LightClient struct {
+	client *Client
+
+	// The validators for the slot after the last one we have applied
+	validators *currency.ValidatorSet
+
+	// The last slot we have applied, or zero before the first sync
+	slot int
+
+	accounts *currency.AccountMap
+
+	// The network's genesis, for how blocks are applied. See SetGenesis
+	genesis *Config
+}
+
+// NewLightClient creates a light client that trusts the validators that
+// signed the latest checkpoint.
+func NewLightClient(conn Connection, validators consensus.QuorumSlice) *LightClient {
+	return &LightClient{
+		client:     NewClient(conn),
+		validators: currency.NewValidatorSet(validators),
+	}
+}
+
+// SetGenesis tells the client how the network applies blocks, since it has
+// to apply them the same way the validators do. Without it, the client
+// expects blocks with no fee distribution or emission. It should be called
+// before the first sync.
+func (lc *LightClient) SetGenesis(c *Config) {
+	lc.genesis = c
+}
+
+func (lc *LightClient) Close() {
+	lc.client.Close()
+}
+
+// Slot returns the last slot this client has synced.
+func (lc *LightClient) Slot() int {
+	return lc.slot
+}
+
+// Validators returns the validators for the slot after Slot().
+func (lc *LightClient) Validators() consensus.QuorumSlice {
+	return lc.validators.QuorumSlice()
+}
+
+// Account returns the state of an account as of Slot().
+// It returns nil for an account that has never been touched, or before the
+// first sync.
+func (lc *LightClient) Account(owner string) *currency.Account {
+	if lc.accounts == nil {
+		return nil
+	}
+	return lc.accounts.Get(owner)
+}
+
+// newAccounts makes an account map with the given state that applies blocks
+// the way the network does.
+func (lc *LightClient) newAccounts(state map[string]*currency.Account) *currency.AccountMap {
+	accounts := currency.NewAccountMapFromState(state)
+	accounts.SetValidators(lc.validators.QuorumSlice().Members)
+	if lc.genesis != nil {
+		accounts.SetReserve(lc.genesis.Reserve)
+		accounts.SetFeePool(lc.genesis.FeePool)
+		accounts.SetFeesToValidators(lc.genesis.FeesToValidators)
+		accounts.SetEmission(lc.genesis.Emission)
+	}
+	return accounts
+}
+
+// start loads the latest verified checkpoint.
+func (lc *LightClient) start(ctx context.Context) error {
+	checkpoint, err := lc.client.GetCheckpoint(ctx)
+	if err != nil {
+		return err
+	}
+	if !checkpoint.Verify(lc.validators.QuorumSlice()) {
+		return fmt.Errorf("not enough validators signed the checkpoint for slot %d",
+			checkpoint.I)
+	}
+	if currency.StateRoot(checkpoint.State) != checkpoint.Root {
+		return fmt.Errorf("the state for slot %d does not match its checkpoint",
+			checkpoint.I)
+	}
+	lc.accounts = lc.newAccounts(checkpoint.State)
+	lc.slot = checkpoint.I
+	return nil
+}
+
+// Sync catches up through at least the given slot. Only checkpoints are
+// signed by a quorum, so it syncs to the latest one, which can be past slot.
+// It returns an error if there is no checkpoint at or past slot yet.
+// The first sync starts from the latest checkpoint. Later ones apply the
+// blocks since the last sync.
+// If the context is done partway through, the client stays at the last
+// checkpoint it reached.
+func (lc *LightClient) Sync(ctx context.Context, slot int) error {
+	if lc.accounts == nil {
+		if err := lc.start(ctx); err != nil {
+			return err
+		}
+	}
+	if lc.slot >= slot {
+		return nil
+	}
+	checkpoint, err := lc.client.GetCheckpoint(ctx)
+	if err != nil {
+		return err
+	}
+	if checkpoint.I < slot {
+		return fmt.Errorf("there is no checkpoint at or past slot %d yet", slot)
+	}
+	return lc.syncTo(ctx, checkpoint)
+}
+
+// syncTo applies the blocks up through a checkpoint, and only keeps them if
+// a quorum signed the state they lead to.
+func (lc *LightClient) syncTo(ctx context.Context, checkpoint *CheckpointMessage) error {
+	accounts := lc.accounts.CowCopy()
+	validators := lc.validators.Copy()
+	for i := lc.slot + 1; i <= checkpoint.I; i++ {
+		history, err := lc.client.GetHistory(ctx, i)
+		if err != nil {
+			return err
+		}
+		if history.I != i {
+			return fmt.Errorf("asked for slot %d but got slot %d", i, history.I)
+		}
+		chunk := history.T.Chunks[history.E.X]
+		if chunk == nil {
+			return fmt.Errorf("the history for slot %d is missing its chunk", i)
+		}
+		if chunk.Hash() != history.E.X {
+			return fmt.Errorf("the chunk for slot %d does not match its hash", i)
+		}
+
+		if !util.VerifyOperations(chunk.Operations) {
+			return fmt.Errorf("slot %d has a badly signed operation", i)
+		}
+
+		// This checks that the operations are valid and that they lead to the
+		// account state the chunk claims
+		accounts.SetSlot(i)
+		if !accounts.ApplyChunk(chunk) {
+			return fmt.Errorf("the chunk for slot %d is invalid", i)
+		}
+		validators.ProcessChunk(i, chunk)
+		if validators.Advance(i + 1) {
+			accounts.SetValidators(validators.QuorumSlice().Members)
+		}
+	}
+
+	// Validators sign a checkpoint once they have moved on to the next slot,
+	// so it is checked against the validators for that slot
+	if !checkpoint.Verify(validators.QuorumSlice()) {
+		return fmt.Errorf("not enough validators signed the checkpoint for slot %d",
+			checkpoint.I)
+	}
+	state := accounts.Snapshot()
+	if currency.StateRoot(state) != checkpoint.Root {
+		return fmt.Errorf("the blocks through slot %d do not match its checkpoint",
+			checkpoint.I)
+	}
+	lc.validators = validators
+	lc.accounts = lc.newAccounts(state)
+	lc.slot = checkpoint.I
+	return nil
+}
@@ -0,0 +1,126 @@
+package network
+
+import (
+	"sync"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/util"
+)
+
+// The mempool feed lets applications watch operations as soon as a node
+// admits them to its queue, before they are in any block. Operations that
+// come straight from clients and ones gossiped from other servers both show
+// up. An admitted operation can still be dropped later, so this is a view of
+// what might happen rather than what did.
+
+// How many operations a subscriber can fall behind by before it starts
+// missing them. The processing goroutine never waits on a slow subscriber.
+const mempoolBufferSize = 100
+
+// A PendingFilter picks which admitted operations a subscriber gets.
+// Empty lists match everything.
+type PendingFilter struct {
+	// Operations that involve any of these accounts, as the signer or the
+	// recipient of a send
+	Accounts []string
+
+	// Operations with any of these types, like "Send"
+	Types []string
+}
+
+// involves returns whether an operation involves owner. Operations involve
+// their signer, and sends and messages also involve their recipient. Sends to
+// a deposit account involve the account it credits.
+func involves(op *util.SignedOperation, owner string) bool {
+	if op.GetSigner() == owner {
+		return true
+	}
+	switch t := op.Operation.(type) {
+	case *currency.SendOperation:
+		return t.Recipient() == owner
+	case *currency.MessageOperation:
+		return t.To == owner
+	}
+	return false
+}
+
+// Matches returns whether the filter lets an operation through.
+func (f *PendingFilter) Matches(op *util.SignedOperation) bool {
+	if len(f.Types) > 0 {
+		found := false
+		for _, t := range f.Types {
+			if op.Type == t {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Accounts) == 0 {
+		return true
+	}
+	for _, account := range f.Accounts {
+		if involves(op, account) {
+			return true
+		}
+	}
+	return false
+}
+
+type mempoolSubscriber struct {
+	filter *PendingFilter
+	ops    chan *util.SignedOperation
+}
+
+// mempoolFeed sends admitted operations to its subscribers.
+// It is threadsafe.
+type mempoolFeed struct {
+	mutex       sync.Mutex
+	subscribers map[*mempoolSubscriber]bool
+}
+
+func newMempoolFeed() *mempoolFeed {
+	return &mempoolFeed{
+		subscribers: make(map[*mempoolSubscriber]bool),
+	}
+}
+
+// subscribe returns a channel of the operations that match filter, and a
+// function to call when done with it.
+func (f *mempoolFeed) subscribe(filter *PendingFilter) (<-chan *util.SignedOperation, func()) {
+	sub := &mempoolSubscriber{
+		filter: filter,
+		ops:    make(chan *util.SignedOperation, mempoolBufferSize),
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.subscribers[sub] = true
+	return sub.ops, func() {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		delete(f.subscribers, sub)
+	}
+}
+
+// publish hands an admitted operation to every subscriber that wants it.
+// A subscriber whose buffer is full misses it.
+func (f *mempoolFeed) publish(op *util.SignedOperation) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for sub := range f.subscribers {
+		if !sub.filter.Matches(op) {
+			continue
+		}
+		select {
+		case sub.ops <- op:
+		default:
+		}
+	}
+}
+
+// SubscribePending returns a channel of the operations this server admits to
+// its queue that match filter, and a function to call to unsubscribe.
+func (s *Server) SubscribePending(filter *PendingFilter) (<-chan *util.SignedOperation, func()) {
+	return s.mempool.subscribe(filter)
+}
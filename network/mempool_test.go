@@ -0,0 +1,96 @@
+package network
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/util"
+)
+
+func TestPendingOperations(t *testing.T) {
+	s, _ := newIdleServer(t, 3)
+	defer s.Stop()
+	mint := util.NewKeyPairFromSecretPhrase("mint")
+	bob := util.NewKeyPairFromSecretPhrase("bob")
+	carol := util.NewKeyPairFromSecretPhrase("carol")
+
+	toBob, unsubscribe := s.SubscribePending(&PendingFilter{
+		Accounts: []string{bob.PublicKey().String()},
+	})
+	defer unsubscribe()
+	validators, unsubscribeValidators := s.SubscribePending(&PendingFilter{
+		Types: []string{"Validator"},
+	})
+	defer unsubscribeValidators()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	g := s.graphQL()
+	results := graphql.Subscribe(graphql.Params{
+		Schema: g.schema(),
+		RequestString: `subscription { pendingOperations(types: ["Send"]) ` +
+			`{ type signer slot } }`,
+		Context: ctx,
+	})
+
+	// The subscription starts in the background
+	for i := 0; ; i++ {
+		s.mempool.mutex.Lock()
+		subscribed := len(s.mempool.subscribers) == 3
+		s.mempool.mutex.Unlock()
+		if subscribed {
+			break
+		}
+		if i == 100 {
+			t.Fatal("the graphql subscription never started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	admit := func(m util.Message) {
+		s.unsafeProcessMessage(util.NewSignedMessage(m, mint))
+	}
+	admit(newSendMessage(mint, bob, 1, 10))
+	admit(newSendMessage(mint, bob, 1, 10))
+
+	// Replacing the send with a higher fee admits the replacement
+	replacement := &currency.SendOperation{
+		Signer:   mint.PublicKey().String(),
+		Sequence: 1,
+		To:       carol.PublicKey().String(),
+		Amount:   10,
+		Fee:      1,
+	}
+	admit(currency.NewTransactionMessage(util.NewSignedOperation(replacement, mint)))
+
+	select {
+	case op := <-toBob:
+		if op.Operation.GetSequence() != 1 {
+			t.Fatalf("bad operation: %s", op.Operation)
+		}
+	case <-ctx.Done():
+		t.Fatal("the send to bob never showed up")
+	}
+	select {
+	case op := <-toBob:
+		t.Fatalf("got an extra operation: %s", op.Operation)
+	case op := <-validators:
+		t.Fatalf("sends are not validator operations: %s", op.Operation)
+	default:
+	}
+
+	for i := 0; i < 2; i++ {
+		result := <-results
+		if len(result.Errors) > 0 {
+			t.Fatalf("unexpected errors: %+v", result.Errors)
+		}
+		op := result.Data.(map[string]interface{})["pendingOperations"].(map[string]interface{})
+		if op["type"] != "Send" || op["signer"] != mint.PublicKey().String() || op["slot"] != 0 {
+			t.Fatalf("bad pending operation: %+v", op)
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package network
 
 import (
+	"context"
+	"reflect"
+	"time"
+
 	"github.com/lacker/coinkit/consensus"
 	"github.com/lacker/coinkit/currency"
 	"github.com/lacker/coinkit/data"
@@ -17,13 +21,50 @@ type Node struct {
 	queue     *currency.OperationQueue
 	database  *data.Database
 	slot      int
+
+	// The most recently finalized block, or nil if none has been finalized
+	// since this node started
+	lastBlock *data.Block
+
+	// Signs this node's checkpoints. Nil for a node that doesn't sign them.
+	keyPair util.Signer
+
+	// How many slots apart checkpoints are
+	checkpointInterval int
+
+	// The most recent checkpoint, which we are collecting signatures for, and
+	// the most recent one that enough validators signed.
+	// Either can be nil.
+	checkpoint         *CheckpointMessage
+	verifiedCheckpoint *CheckpointMessage
+
+	// For a standby node, its request to be promoted to a validator
+	promotion *PromotionMessage
+
+	// How many recent slots we keep in memory. Zero keeps all of them.
+	retention int
+
+	// The latest conflicting externalize message from each validator, and
+	// the evidence of the fork we halted on, if we did
+	conflicts map[string]*util.SignedMessage
+	fork      *data.Fork
 }
 
 // Creates a node for a blockchain that starts with one mint account having a balance.
 func NewNodeWithMint(publicKey util.PublicKey, qs consensus.QuorumSlice,
 	db *data.Database, mint util.PublicKey, balance uint64) *Node {
+	node := newGenesisNode(publicKey, qs, db, mint, balance)
+	node.loadBlocks()
+	return node
+}
+
+// newGenesisNode creates a node without loading any blocks from its database,
+// so that the rest of the genesis can be set up on its queue first.
+func newGenesisNode(publicKey util.PublicKey, qs consensus.QuorumSlice,
+	db *data.Database, mint util.PublicKey, balance uint64) *Node {
 
 	queue := currency.NewOperationQueue(publicKey)
+	queue.SetQuorumSlice(qs)
 	if balance != 0 {
 		queue.SetBalance(mint.String(), balance)
 	}
@@ -34,19 +75,37 @@ func NewNodeWithMint(publicKey util.PublicKey, qs consensus.QuorumSlice,
 		database:  db,
 		chain:     consensus.NewEmptyChain(publicKey, qs, queue),
 		slot:      1,
+
+		checkpointInterval: CheckpointInterval,
 	}
 
-	if db != nil {
-		loaded := db.ForBlocks(func(b *data.Block) {
+	node.chain.AddExternalizeHandler(node)
+	return node
+}
+
+// loadBlocks finalizes the blocks in the database, if there is one.
+func (node *Node) loadBlocks() {
+	if node.database == nil {
+		return
+	}
+	qs := node.queue.QuorumSlice()
+	node.SetRetention(DefaultRetention)
+	options := data.DefaultForBlocksOptions()
+	options.Progress = func(count int) {
+		util.Logger.Printf("loaded %d blocks so far", count)
+	}
+	loaded, err := node.database.ForBlocksWithOptions(context.Background(), options,
+		func(b *data.Block) {
 			m := b.ExternalizeMessage(qs)
 			node.chain.AlreadyExternalized(m)
 			node.queue.FinalizeChunk(b.Chunk)
 		})
-		util.Logger.Printf("loaded %d old blocks from the database", loaded)
-		node.slot = loaded + 1
+	if err != nil {
+		panic(err)
 	}
-
-	return node
+	util.Logger.Printf("loaded %d old blocks from the database", loaded)
+	node.slot = loaded + 1
+	node.updateQuorumSlice()
 }
 
 func NewNode(
@@ -60,51 +119,120 @@ func (node *Node) Slot() int {
 	return node.slot
 }
 
+// LastBlock returns the most recently finalized block, or nil if no block has
+// been finalized since this node started.
+func (node *Node) LastBlock() *data.Block {
+	return node.lastBlock
+}
+
 // Handle handles an incoming message.
 // It may return a message to be sent back to the original sender
 // The bool flag tells whether it has a response or not.
 func (node *Node) Handle(sender string, message util.Message) (util.Message, bool) {
+	return node.HandleContext(context.Background(), sender, message)
+}
+
+// HandleSigned is like HandleContext, but it has the message as its sender
+// signed it. Only signed externalize messages can show a fork, since the
+// signatures are the evidence.
+func (node *Node) HandleSigned(
+	ctx context.Context, sm *util.SignedMessage) (util.Message, bool) {
+	if m, ok := sm.Message().(*consensus.ExternalizeMessage); ok {
+		node.checkFork(ctx, sm, m)
+	}
+	return node.HandleContext(ctx, sm.Signer(), sm.Message())
+}
+
+// HandleContext is like Handle, but the context is used for any database
+// writes, and to trace the handling of the message.
+func (node *Node) HandleContext(
+	ctx context.Context, sender string, message util.Message) (util.Message, bool) {
 	if sender == node.publicKey.String() {
 		return nil, false
 	}
 	switch m := message.(type) {
 
 	case *HistoryMessage:
-		node.Handle(sender, m.T)
-		node.Handle(sender, m.E)
+		node.HandleContext(ctx, sender, m.T)
+		node.HandleContext(ctx, sender, m.E)
 		return nil, false
 
 	case *currency.AccountMessage:
 		return nil, false
 
 	case *util.InfoMessage:
-		if m.Account != "" {
+		if m.Account != "" && m.AccountSlot != 0 {
+			answer := node.handleAccountSlotMessage(ctx, m)
+			return answer, answer != nil
+		}
+		if m.Account != "" || len(m.Accounts) > 0 {
 			answer := node.queue.HandleInfoMessage(m)
+			if answer != nil {
+				addActivity(ctx, node.database, answer, m.Owners()...)
+			}
 			return answer, answer != nil
 		}
 		if m.I != 0 {
+			// Slots that are no longer in memory are loaded from the
+			// database by the server, off the processing goroutine
 			answer, ok := node.chain.Handle(sender, m)
-			return answer, ok
+			if !ok {
+				return nil, false
+			}
+			return node.historyMessage(answer.(*consensus.ExternalizeMessage)), true
+		}
+		return nil, false
+
+	case *currency.FeeMessage:
+		if !m.IsQuery() {
+			return nil, false
+		}
+		return node.queue.HandleFeeMessage(m), true
+
+	case *CheckpointMessage:
+		if m.IsQuery() {
+			if node.verifiedCheckpoint == nil {
+				return &CheckpointMessage{}, true
+			}
+			return node.verifiedCheckpoint.forClients(), true
 		}
+		node.handleCheckpointMessage(m)
 		return nil, false
 
+	case *HeadMessage:
+		if !m.IsQuery() {
+			return nil, false
+		}
+		return node.handleHeadMessage(m), true
+
+	case *PromotionMessage:
+		// The server decides whether to vote for promotions, since that
+		// depends on which validators it has heard from lately
+		return nil, false
+
+	case *data.DocumentMessage, *data.BlobMessage, *data.NetworkStatsMessage, *data.BlocksMessage:
+		return answerDatabaseQuery(ctx, node.database, m)
+
 	case *currency.TransactionMessage:
+		if node.halted() {
+			return nil, false
+		}
 		if node.queue.HandleTransactionMessage(m) {
 			node.chain.ValueStoreUpdated()
 		}
 		return nil, false
 
 	case *consensus.NominationMessage:
-		answer, ok := node.handleChainMessage(sender, m)
+		answer, ok := node.handleChainMessage(ctx, sender, m)
 		return answer, ok
 	case *consensus.PrepareMessage:
-		answer, ok := node.handleChainMessage(sender, m)
+		answer, ok := node.handleChainMessage(ctx, sender, m)
 		return answer, ok
 	case *consensus.ConfirmMessage:
-		answer, ok := node.handleChainMessage(sender, m)
+		answer, ok := node.handleChainMessage(ctx, sender, m)
 		return answer, ok
 	case *consensus.ExternalizeMessage:
-		answer, ok := node.handleChainMessage(sender, m)
+		answer, ok := node.handleChainMessage(ctx, sender, m)
 		return answer, ok
 
 	default:
@@ -113,30 +241,254 @@ func (node *Node) Handle(sender string, message util.Message) (util.Message, boo
 	}
 }
 
-// A helper to handle the messages
-func (node *Node) handleChainMessage(sender string, message util.Message) (util.Message, bool) {
-	response, hasResponse := node.chain.Handle(sender, message)
+// handleAccountSlotMessage returns nil if the requested slot is not finalized yet.
+// When we have a database, historical account data comes from there. Otherwise
+// we fall back to the chunks in memory.
+func (node *Node) handleAccountSlotMessage(
+	ctx context.Context, m *util.InfoMessage) *currency.AccountMessage {
+	if m.AccountSlot >= node.Slot() {
+		return nil
+	}
+	var account *currency.Account
+	var hash consensus.SlotValue
+	if node.database != nil {
+		var err error
+		account, err = node.database.GetAccountAtSlot(ctx, m.Account, m.AccountSlot)
+		if err != nil {
+			util.Logger.Printf("could not get historical account data: %s", err)
+			return nil
+		}
+		block, err := node.database.GetBlock(ctx, m.AccountSlot)
+		if err != nil {
+			util.Logger.Printf("could not get historical block: %s", err)
+			return nil
+		}
+		if block != nil {
+			hash = consensus.SlotValue(block.ChunkHash)
+		}
+	} else {
+		account = node.queue.AccountAtSlot(m.Account, m.AccountSlot)
+		if chunk := node.queue.OldChunk(m.AccountSlot); chunk != nil {
+			hash = chunk.Hash()
+		}
+	}
+	return &currency.AccountMessage{
+		I:     m.AccountSlot,
+		State: map[string]*currency.Account{m.Account: account},
+		Hash:  hash,
+	}
+}
 
-	if node.chain.Slot() > node.Slot() {
-		// We have advanced.
-		node.slot += 1
-
-		if node.database != nil {
-			// Let's save the old block.
-			last := node.chain.GetLast()
-			chunk := node.queue.OldChunk(last.I)
-			block := &data.Block{
-				Slot:  last.I,
-				C:     last.Cn,
-				H:     last.Hn,
-				Chunk: chunk,
-			}
-			err := node.database.InsertBlock(block)
-			if err != nil {
-				panic(err)
-			}
+// addActivity fills in how active accounts have been lately, from a
+// database, which may be nil.
+func addActivity(ctx context.Context,
+	db *data.Database, m *currency.AccountMessage, owners ...string) {
+	if db == nil {
+		return
+	}
+	activity, err := db.GetAccountsActivity(ctx, owners)
+	if err != nil {
+		util.Logger.Printf("could not get account activity: %s", err)
+		return
+	}
+	if len(activity) > 0 {
+		m.Activity = activity
+	}
+}
+
+// The most documents a single search or list returns
+const maxSearchResults = 100
+
+// answerDatabaseQuery answers the queries that only need the database, from
+// a database, which may be nil. Since they don't touch the node, a server
+// answers them without the processing goroutine. It returns (nil, false)
+// for any other message.
+func answerDatabaseQuery(ctx context.Context,
+	db *data.Database, message util.Message) (util.Message, bool) {
+	switch m := message.(type) {
+	case *data.DocumentMessage:
+		if m.IsQuery() {
+			return answerDocumentMessage(ctx, db, m), true
+		}
+	case *data.BlobMessage:
+		if m.IsQuery() {
+			return answerBlobMessage(ctx, db, m), true
+		}
+	case *data.NetworkStatsMessage:
+		if m.IsQuery() {
+			return answerNetworkStatsMessage(ctx, db), true
+		}
+	case *data.BlocksMessage:
+		if m.IsQuery() {
+			return answerBlocksMessage(ctx, db, m), true
+		}
+	}
+	return nil, false
+}
+
+// queryError describes an error from a database query, for a client.
+func queryError(err error) string {
+	if err == context.DeadlineExceeded {
+		return "the query took too long"
+	}
+	return err.Error()
+}
+
+// answerDocumentMessage answers a query about the document store from a
+// database, which may be nil.
+func answerDocumentMessage(ctx context.Context,
+	db *data.Database, m *data.DocumentMessage) *data.DocumentMessage {
+	answer := &data.DocumentMessage{
+		Match:      m.Match,
+		Field:      m.Field,
+		Search:     m.Search,
+		Collection: m.Collection,
+		Limit:      m.Limit,
+		List:       m.List,
+		Cursor:     m.Cursor,
+		Expand:     m.Expand,
+	}
+	if db == nil {
+		answer.Error = "this node has no database"
+		return answer
+	}
+	limit := m.Limit
+	if limit < 1 || limit > maxSearchResults {
+		limit = maxSearchResults
+	}
+	if m.List || m.Search != "" {
+		var docs []*data.Document
+		var err error
+		if m.List {
+			docs, answer.Next, err = db.GetDocumentsPage(ctx, m.Match, m.Cursor, limit)
+		} else {
+			docs, err = db.SearchDocuments(ctx, m.Search, m.Collection, limit)
+		}
+		if err == nil && len(m.Expand) > 0 {
+			answer.Referenced, err = db.GetReferencedDocuments(ctx, docs, m.Expand)
+		}
+		if err != nil {
+			answer.Error = queryError(err)
+			return answer
+		}
+		answer.Documents = docs
+		return answer
+	}
+	stats, err := db.AggregateDocuments(ctx, m.Match, m.Field)
+	if err != nil {
+		answer.Error = queryError(err)
+		return answer
+	}
+	answer.Stats = stats
+	return answer
+}
+
+// answerBlobMessage stores or fetches a blob from a database, which may be nil.
+func answerBlobMessage(ctx context.Context,
+	db *data.Database, m *data.BlobMessage) *data.BlobMessage {
+	answer := &data.BlobMessage{Hash: m.Hash, Response: true}
+	if db == nil {
+		answer.Error = "this node has no database"
+		return answer
+	}
+	if m.Data != nil {
+		if currency.BlobHash(m.Data) != m.Hash {
+			answer.Error = "the blob does not have this hash"
+			return answer
+		}
+		if err := db.SaveBlob(ctx, m.Data); err != nil {
+			answer.Error = queryError(err)
+		}
+		return answer
+	}
+	blob, err := db.GetBlob(ctx, m.Hash)
+	if err != nil {
+		answer.Error = queryError(err)
+		return answer
+	}
+	if blob == nil {
+		answer.Error = "this node does not have the blob"
+		return answer
+	}
+	answer.Data = blob
+	return answer
+}
+
+// answerBlocksMessage lists a page of blocks from a database, which may be
+// nil.
+func answerBlocksMessage(ctx context.Context,
+	db *data.Database, m *data.BlocksMessage) *data.BlocksMessage {
+	answer := &data.BlocksMessage{Owner: m.Owner, Cursor: m.Cursor, Limit: m.Limit}
+	if db == nil {
+		answer.Error = "this node has no database"
+		return answer
+	}
+	limit := m.Limit
+	if limit < 1 || limit > data.MaxBlocksPerPage {
+		limit = data.MaxBlocksPerPage
+	}
+	if m.Owner == "" {
+		blocks, next, err := db.GetBlocksPage(ctx, m.Cursor, limit)
+		if err != nil {
+			answer.Error = queryError(err)
+			return answer
 		}
+		answer.Blocks = blocks
+		answer.Next = next
+		return answer
+	}
+	slots, next, err := db.GetAccountSlotsPage(ctx, m.Owner, m.Cursor, limit)
+	if err != nil {
+		answer.Error = queryError(err)
+		return answer
+	}
+	answer.Blocks = []*data.Block{}
+	for _, slot := range slots {
+		block, err := db.GetBlock(ctx, slot)
+		if err != nil {
+			answer.Blocks = nil
+			answer.Error = queryError(err)
+			return answer
+		}
+		if block != nil {
+			answer.Blocks = append(answer.Blocks, block)
+		}
+	}
+	answer.Next = next
+	return answer
+}
+
+// answerNetworkStatsMessage reports the network stats from a database, which
+// may be nil.
+func answerNetworkStatsMessage(ctx context.Context, db *data.Database) *data.NetworkStatsMessage {
+	answer := &data.NetworkStatsMessage{}
+	if db == nil {
+		answer.Error = "this node has no database"
+		return answer
+	}
+	stats, windows, err := db.GetNetworkReport(ctx)
+	if err != nil {
+		answer.Error = queryError(err)
+		return answer
+	}
+	if stats == nil {
+		answer.Error = "there are no network stats yet"
+		return answer
+	}
+	answer.Stats = stats
+	answer.Windows = windows
+	return answer
+}
+
+// A helper to handle the messages
+func (node *Node) handleChainMessage(
+	ctx context.Context, sender string, message util.Message) (util.Message, bool) {
+	if node.halted() {
+		return nil, false
 	}
+	_, span := util.StartSpan(ctx, "consensus")
+	response, hasResponse := node.chain.Handle(sender, message)
+	span.End()
 
 	if !hasResponse {
 		return nil, false
@@ -147,17 +499,108 @@ func (node *Node) handleChainMessage(sender string, message util.Message) (util.
 		return response, true
 	}
 
-	// Augment externalize messages into history messages
+	return node.historyMessage(externalize), true
+}
+
+// Externalized records the block the chain just finalized, and saves it to
+// the database along with its documents. The queue has already finalized
+// the chunk by the time it is called.
+func (node *Node) Externalized(slot int, v consensus.SlotValue) {
+	node.slot = slot + 1
+	node.updateQuorumSlice()
+
+	last := node.chain.GetLast()
+	node.lastBlock = &data.Block{
+		Slot:  slot,
+		C:     last.Cn,
+		H:     last.Hn,
+		Chunk: node.queue.OldChunk(slot),
+
+		Participation: node.chain.LastParticipation(),
+		FinalizedAt:   time.Now(),
+	}
+	if issuance := node.queue.LastIssuance(); issuance != nil && issuance.Slot == slot {
+		node.lastBlock.Issuance = issuance
+	}
+
+	if slot%node.checkpointInterval == 0 {
+		node.startCheckpoint(slot)
+	}
+
+	if node.database != nil {
+		// The chain can't pass along a context, and the block has to be
+		// saved either way.
+		err := node.database.SaveBlock(context.Background(), node.lastBlock)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// updateQuorumSlice makes the chain use the validators that finalized
+// validator operations have chosen for the current slot.
+func (node *Node) updateQuorumSlice() {
+	qs := node.queue.QuorumSlice()
+	if !reflect.DeepEqual(qs, node.chain.D) {
+		util.Logger.Printf("switching to validators %v at slot %d", qs, node.chain.Slot())
+		node.chain.SetQuorumSlice(qs)
+	}
+}
+
+// historyMessage augments an externalize message with the chunk it refers to.
+func (node *Node) historyMessage(externalize *consensus.ExternalizeMessage) *HistoryMessage {
 	t := node.queue.OldChunkMessage(externalize.I)
 	return &HistoryMessage{
 		T: t,
 		E: externalize,
 		I: externalize.I,
-	}, true
+	}
+}
+
+// startCheckpoint makes a checkpoint for the slot that was just finalized,
+// and signs it if we can.
+func (node *Node) startCheckpoint(slot int) {
+	state := node.queue.Snapshot()
+	node.checkpoint = &CheckpointMessage{
+		I:          slot,
+		Root:       currency.StateRoot(state),
+		Signatures: make(map[string]string),
+		State:      state,
+	}
+	if node.keyPair != nil {
+		node.checkpoint.Sign(node.keyPair)
+	}
+	node.updateVerifiedCheckpoint()
+}
+
+// handleCheckpointMessage collects signatures from other validators.
+func (node *Node) handleCheckpointMessage(m *CheckpointMessage) {
+	if node.checkpoint == nil || m.I != node.checkpoint.I {
+		return
+	}
+	if m.Root != node.checkpoint.Root {
+		util.Logger.Printf("checkpoint mismatch at slot %d: we have %s but got %s",
+			m.I, node.checkpoint.Root, m.Root)
+		return
+	}
+	if node.checkpoint.Merge(node.chain.D, m) {
+		node.updateVerifiedCheckpoint()
+	}
+}
+
+func (node *Node) updateVerifiedCheckpoint() {
+	if node.checkpoint != node.verifiedCheckpoint &&
+		node.checkpoint.Verify(node.chain.D) {
+		node.checkpoint.CoSign(node.chain.D)
+		node.verifiedCheckpoint = node.checkpoint
+	}
 }
 
 func (node *Node) OutgoingMessages() []util.Message {
 	answer := []util.Message{}
+	if node.halted() {
+		return answer
+	}
 	sharing := node.queue.TransactionMessage()
 	if sharing != nil {
 		answer = append(answer, sharing)
@@ -165,9 +608,54 @@ func (node *Node) OutgoingMessages() []util.Message {
 	for _, m := range node.chain.OutgoingMessages() {
 		answer = append(answer, m)
 	}
+	if node.checkpoint != nil {
+		answer = append(answer, node.checkpoint.gossip())
+	}
+	if node.promotion != nil && node.promotion.I > node.slot {
+		answer = append(answer, node.promotion)
+	}
 	return answer
 }
 
+// QueueStats describes the operations waiting to get into a block
+func (node *Node) QueueStats() *currency.QueueStats {
+	return node.queue.QueueStats(time.Now())
+}
+
+// Pending describes the queued operations that pass the filter.
+func (node *Node) Pending(filter *PendingFilter) []*currency.PendingOperation {
+	return node.queue.Pending(time.Now(), filter.Matches)
+}
+
+func (node *Node) CheckAdmission(op util.Operation) []*currency.AdmissionCheck {
+	return node.queue.CheckAdmission(op)
+}
+
+// NominationRound returns the slot the node is working on, and the
+// nomination round it is in.
+func (node *Node) NominationRound() (int, int) {
+	return node.chain.NominationRound()
+}
+
+// NextNominationRound starts the next nomination round, once the current one
+// has timed out. A halted node stays where it is.
+func (node *Node) NextNominationRound() {
+	if node.halted() {
+		return
+	}
+	node.chain.NextNominationRound()
+}
+
+// ConsensusState returns a snapshot of the block the chain is working on
+func (node *Node) ConsensusState() *consensus.BlockState {
+	return node.chain.State()
+}
+
+// ConsensusGraph returns a graph of the block the chain is working on
+func (node *Node) ConsensusGraph() *consensus.Graph {
+	return node.chain.Graph()
+}
+
 func (node *Node) Stats() {
 	node.chain.Stats()
 	node.queue.Stats()
@@ -28,12 +28,32 @@ func sendNodeToNodeMessages(source *Node, target *Node, t *testing.T) {
 	}
 }
 
-func maxAccountBalance(nodes []*Node) uint64 {
+// timeOutRounds starts the next nomination round on each node. Tests that
+// pass messages between nodes by hand call it to stand in for the clock that
+// times out rounds on a server.
+func timeOutRounds(nodes []*Node) {
+	for _, node := range nodes {
+		node.NextNominationRound()
+	}
+}
+
+// balance returns what a node thinks the balance of an account is
+func balance(node *Node, owner *util.KeyPair) uint64 {
+	account := node.queue.Account(owner.PublicKey().String())
+	if account == nil {
+		return 0
+	}
+	return account.Balance
+}
+
+func maxAccountBalance(nodes []*Node, owners []*util.KeyPair) uint64 {
 	answer := uint64(0)
 	for _, node := range nodes {
-		b := node.queue.MaxBalance()
-		if b > answer {
-			answer = b
+		for _, owner := range owners {
+			b := balance(node, owner)
+			if b > answer {
+				answer = b
+			}
 		}
 	}
 	return answer
@@ -74,6 +94,7 @@ func TestNodeCatchup(t *testing.T) {
 			sendNodeToNodeMessages(nodes[1], nodes[0], t)
 			sendNodeToNodeMessages(nodes[2], nodes[0], t)
 			sendNodeToNodeMessages(nodes[2], nodes[1], t)
+			timeOutRounds(nodes[:3])
 		}
 		for i := 0; i <= 2; i++ {
 			if nodes[i].Slot() != round+1 {
@@ -82,6 +103,11 @@ func TestNodeCatchup(t *testing.T) {
 		}
 	}
 
+	p := nodes[0].LastBlock().Participation
+	if p == nil || len(p.Missed) != 1 || p.Missed[0] != names[3].String() {
+		t.Fatalf("the last node should have missed the slot: %+v", p)
+	}
+
 	// The last node should be able to catch up
 	for i := 0; i < 10; i++ {
 		sendNodeToNodeMessages(nodes[0], nodes[3], t)
@@ -94,6 +120,35 @@ func TestNodeCatchup(t *testing.T) {
 	if nodes[3].Slot() != 4 {
 		t.Fatalf("catchup failed")
 	}
+
+	// We should be able to look up the account as of an old slot
+	info := &util.InfoMessage{Account: kp.PublicKey().String(), AccountSlot: 2}
+	response, ok := nodes[3].Handle("client", info)
+	if !ok {
+		t.Fatalf("expected a response for slot 2")
+	}
+	account := response.(*currency.AccountMessage).State[kp.PublicKey().String()]
+	if account == nil || account.Sequence != 2 {
+		t.Fatalf("expected sequence 2 but got %+v", account)
+	}
+	info.AccountSlot = 4
+	if _, ok := nodes[3].Handle("client", info); ok {
+		t.Fatalf("slot 4 is not finalized yet")
+	}
+
+	// Without a database, old slots are gone once they leave the retention
+	// window
+	nodes[0].SetRetention(1)
+	stats := nodes[0].RetentionStats()
+	if stats.OldestSlot != 3 || stats.Slots != 1 || stats.Chunks != 1 || stats.Operations != 1 {
+		t.Fatalf("unexpected retention stats: %s", stats)
+	}
+	if _, ok := nodes[0].Handle("client", &util.InfoMessage{I: 2}); ok {
+		t.Fatalf("slot 2 should have been dropped")
+	}
+	if _, ok := nodes[0].Handle("client", &util.InfoMessage{I: 3}); !ok {
+		t.Fatalf("slot 3 should have been kept")
+	}
 }
 
 func TestNodeRestarting(t *testing.T) {
@@ -138,12 +193,67 @@ func TestNodeRestarting(t *testing.T) {
 		sendNodeToNodeMessages(nodes[2], nodes[1], t)
 	}
 
-	if nodes[1].queue.MaxBalance() != 980 {
+	if balance(nodes[1], mint) != 980 {
 		t.Fatalf("recovery failed")
 	}
 }
 
-func nodeFuzzTest(seed int64, t *testing.T) {
+// A node with batch durability loses its pending blocks when it is knocked out
+// without a clean shutdown, and should catch up on them from its peers.
+func TestNodeRestartingWithBatchDurability(t *testing.T) {
+	mint := util.NewKeyPairFromSecretPhrase("mint")
+	bob := util.NewKeyPairFromSecretPhrase("bob")
+	qs, names := consensus.MakeTestQuorumSlice(4)
+	newDatabase := func(i int) *data.Database {
+		config := data.NewTestConfig(i)
+		config.Durability = data.DurabilityBatch
+		config.CommitInterval = 100
+		return data.NewDatabase(config)
+	}
+	nodes := []*Node{}
+	for i, name := range names {
+		data.DropTestData(i)
+		node := NewNodeWithMint(name, qs, newDatabase(i), mint.PublicKey(), 1000)
+		nodes = append(nodes, node)
+	}
+
+	m := newSendMessage(mint, bob, 1, 10)
+	nodes[0].Handle(mint.PublicKey().String(), m)
+	for i := 0; i < 10; i++ {
+		sendNodeToNodeMessages(nodes[0], nodes[1], t)
+		sendNodeToNodeMessages(nodes[0], nodes[2], t)
+		sendNodeToNodeMessages(nodes[1], nodes[2], t)
+		sendNodeToNodeMessages(nodes[1], nodes[0], t)
+		sendNodeToNodeMessages(nodes[2], nodes[0], t)
+		sendNodeToNodeMessages(nodes[2], nodes[1], t)
+	}
+	if nodes[1].Slot() < 2 {
+		t.Fatalf("the first block was not finalized")
+	}
+
+	// Knock out node 1 before it commits anything
+	nodes[1] = NewNodeWithMint(names[1], qs, newDatabase(1), mint.PublicKey(), 1000)
+	if nodes[1].Slot() != 1 {
+		t.Fatalf("expected the restarted node to lose its pending block")
+	}
+
+	m = newSendMessage(mint, bob, 2, 10)
+	nodes[0].Handle(mint.PublicKey().String(), m)
+	for i := 0; i < 10; i++ {
+		sendNodeToNodeMessages(nodes[0], nodes[1], t)
+		sendNodeToNodeMessages(nodes[0], nodes[2], t)
+		sendNodeToNodeMessages(nodes[1], nodes[2], t)
+		sendNodeToNodeMessages(nodes[1], nodes[0], t)
+		sendNodeToNodeMessages(nodes[2], nodes[0], t)
+		sendNodeToNodeMessages(nodes[2], nodes[1], t)
+	}
+
+	if balance(nodes[1], mint) != 980 {
+		t.Fatalf("recovery failed")
+	}
+}
+
+func nodeFuzzTest(seed int64, pipeline bool, t *testing.T) {
 	initialMoney := uint64(4)
 
 	numClients := 5
@@ -181,6 +291,7 @@ func nodeFuzzTest(seed int64, t *testing.T) {
 	nodes := []*Node{}
 	for _, name := range names {
 		node := NewNode(name, qs, nil)
+		node.chain.SetPipelining(pipeline)
 		for _, client := range clients {
 			node.queue.SetBalance(client.PublicKey().String(), initialMoney)
 		}
@@ -205,12 +316,12 @@ func nodeFuzzTest(seed int64, t *testing.T) {
 		}
 
 		// Check if we are done
-		if maxAccountBalance(nodes) == 1 {
+		if maxAccountBalance(nodes, clients) == 1 {
 			break
 		}
 	}
 
-	if maxAccountBalance(nodes) != 1 {
+	if maxAccountBalance(nodes, clients) != 1 {
 		for _, node := range nodes {
 			node.Log()
 		}
@@ -222,6 +333,77 @@ func nodeFuzzTest(seed int64, t *testing.T) {
 func TestNodeFullCluster(t *testing.T) {
 	var i int64
 	for i = 1; i <= util.GetTestLoopLength(2, 1000); i++ {
-		nodeFuzzTest(i, t)
+		nodeFuzzTest(i, false, t)
+	}
+}
+
+func TestPipelinedNodeFullCluster(t *testing.T) {
+	var i int64
+	for i = 1; i <= util.GetTestLoopLength(2, 1000); i++ {
+		nodeFuzzTest(i, true, t)
+	}
+}
+
+func TestValidatorSetChange(t *testing.T) {
+	mint := util.NewKeyPairFromSecretPhrase("mint")
+	bob := util.NewKeyPairFromSecretPhrase("bob")
+	qs, names := consensus.MakeTestQuorumSlice(4)
+	nodes := []*Node{}
+	for _, name := range names {
+		nodes = append(nodes, NewNodeWithMint(name, qs, nil, mint.PublicKey(), 1000))
+	}
+	newcomer := util.NewKeyPairFromSecretPhrase("node4").PublicKey().String()
+
+	runRound := func() {
+		for i := 0; i < 10; i++ {
+			for _, source := range nodes {
+				for _, target := range nodes {
+					if source != target {
+						sendNodeToNodeMessages(source, target, t)
+					}
+				}
+			}
+			timeOutRounds(nodes)
+		}
+	}
+
+	// Three of the four validators vote to add a fifth, starting at slot 3
+	votes := []*util.SignedOperation{}
+	for i := 0; i < 3; i++ {
+		kp := util.NewKeyPairFromSecretPhrase(fmt.Sprintf("node%d", i))
+		votes = append(votes, util.NewSignedOperation(&currency.ValidatorOperation{
+			Signer:     kp.PublicKey().String(),
+			Sequence:   1,
+			Action:     currency.ValidatorAdd,
+			Validator:  newcomer,
+			Threshold:  4,
+			Activation: 3,
+		}, kp))
+	}
+	nodes[0].Handle(mint.PublicKey().String(), currency.NewTransactionMessage(votes...))
+	runRound()
+	if nodes[0].Slot() != 2 {
+		t.Fatalf("the votes were not finalized")
+	}
+	if len(nodes[0].chain.D.Members) != 4 {
+		t.Fatal("the change should not take effect before slot 3")
+	}
+
+	nodes[0].Handle(mint.PublicKey().String(), newSendMessage(mint, bob, 1, 10))
+	runRound()
+	for i, node := range nodes {
+		if node.Slot() != 3 {
+			t.Fatalf("nodes[%d] did not finish slot 2", i)
+		}
+		if len(node.chain.D.Members) != 5 || node.chain.D.Threshold != 4 {
+			t.Fatalf("nodes[%d] has the wrong validators: %+v", i, node.chain.D)
+		}
+	}
+
+	// The four original validators still meet the new threshold
+	nodes[0].Handle(mint.PublicKey().String(), newSendMessage(mint, bob, 2, 10))
+	runRound()
+	if balance(nodes[3], bob) != 20 {
+		t.Fatal("the network should keep going with the new validators")
 	}
 }
@@ -0,0 +1,484 @@
+package network
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+
+	"github.com/lacker/coinkit/util"
+)
+
+// Connections between servers can be encrypted with the Noise protocol
+// framework, using the XX handshake:
+//
+//   -> e
+//   <- e, ee, s, es
+//   -> s, se
+//
+// Noise static keys are X25519 keys, but node identities are ed25519 keys.
+// So each side generates a fresh static key for the connection, and sends a
+// payload signed by its identity that vouches for the static key. Both sides
+// end up knowing who they are talking to, without any certificates.
+//
+// The dialer starts the connection with noiseProtocolName and a newline. The
+// plain protocol always starts with a signed message or a keepalive, so a
+// listener can accept both kinds of connection on the same port.
+
+const noiseProtocolName = "Noise_XX_25519_ChaChaPoly_SHA256"
+
+// Mixed into the handshake so that it can't be confused with another
+// application's Noise handshake
+const noisePrologue = "coinkit"
+
+// What an identity signs to vouch for a Noise static key
+const noiseStaticKeyPrefix = "coinkit noise static key "
+
+// Messages are framed with a two-byte length
+const noiseMaxMessageSize = 65535
+
+// The largest plaintext that fits in one frame, after the authentication tag
+const noiseMaxPlaintextSize = noiseMaxMessageSize - chacha20poly1305.Overhead
+
+// How long a handshake can take before we give up on the connection
+const noiseHandshakeTimeout = 10 * time.Second
+
+// A noiseCipher is a Noise CipherState, which encrypts with an incrementing
+// nonce.
+type noiseCipher struct {
+	aead  cipher.AEAD
+	nonce uint64
+}
+
+func newNoiseCipher(key []byte) *noiseCipher {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		panic(err)
+	}
+	return &noiseCipher{aead: aead}
+}
+
+func (c *noiseCipher) nextNonce() []byte {
+	if c.nonce == ^uint64(0) {
+		panic("the noise nonce ran out")
+	}
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint64(nonce[4:], c.nonce)
+	c.nonce++
+	return nonce
+}
+
+func (c *noiseCipher) encrypt(ad []byte, plaintext []byte) []byte {
+	return c.aead.Seal(nil, c.nextNonce(), plaintext, ad)
+}
+
+func (c *noiseCipher) decrypt(ad []byte, ciphertext []byte) ([]byte, error) {
+	return c.aead.Open(nil, c.nextNonce(), ciphertext, ad)
+}
+
+// hkdf is the Noise HKDF function, returning two outputs.
+func hkdf(chainingKey []byte, input []byte) ([]byte, []byte) {
+	mac := hmac.New(sha256.New, chainingKey)
+	mac.Write(input)
+	temp := mac.Sum(nil)
+
+	mac = hmac.New(sha256.New, temp)
+	mac.Write([]byte{1})
+	out1 := mac.Sum(nil)
+
+	mac = hmac.New(sha256.New, temp)
+	mac.Write(out1)
+	mac.Write([]byte{2})
+	return out1, mac.Sum(nil)
+}
+
+// noiseHandshake is the Noise SymmetricState and HandshakeState for one side
+// of an XX handshake.
+type noiseHandshake struct {
+	chainingKey []byte
+	hash        []byte
+	cipher      *noiseCipher
+
+	staticPrivate    []byte
+	staticPublic     []byte
+	ephemeralPrivate []byte
+	ephemeralPublic  []byte
+	remoteEphemeral  []byte
+	remoteStatic     []byte
+}
+
+func newNoiseHandshake() *noiseHandshake {
+	// The protocol name is exactly as long as a hash, so it is used directly
+	h := &noiseHandshake{hash: []byte(noiseProtocolName)}
+	h.chainingKey = h.hash
+	h.mixHash([]byte(noisePrologue))
+	h.staticPrivate, h.staticPublic = newNoiseKey()
+	return h
+}
+
+func newNoiseKey() ([]byte, []byte) {
+	private := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(private); err != nil {
+		panic(err)
+	}
+	public, err := curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		panic(err)
+	}
+	return private, public
+}
+
+func (h *noiseHandshake) mixHash(data []byte) {
+	sum := sha256.New()
+	sum.Write(h.hash)
+	sum.Write(data)
+	h.hash = sum.Sum(nil)
+}
+
+// mixKey mixes the Diffie-Hellman result of a private and a public key.
+func (h *noiseHandshake) mixKey(private []byte, public []byte) error {
+	shared, err := curve25519.X25519(private, public)
+	if err != nil {
+		return err
+	}
+	var key []byte
+	h.chainingKey, key = hkdf(h.chainingKey, shared)
+	h.cipher = newNoiseCipher(key)
+	return nil
+}
+
+func (h *noiseHandshake) encryptAndHash(plaintext []byte) []byte {
+	ciphertext := plaintext
+	if h.cipher != nil {
+		ciphertext = h.cipher.encrypt(h.hash, plaintext)
+	}
+	h.mixHash(ciphertext)
+	return ciphertext
+}
+
+func (h *noiseHandshake) decryptAndHash(ciphertext []byte) ([]byte, error) {
+	plaintext := ciphertext
+	if h.cipher != nil {
+		var err error
+		plaintext, err = h.cipher.decrypt(h.hash, ciphertext)
+		if err != nil {
+			return nil, err
+		}
+	}
+	h.mixHash(ciphertext)
+	return plaintext, nil
+}
+
+// split returns the ciphers for initiator-to-responder messages and for
+// responder-to-initiator messages.
+func (h *noiseHandshake) split() (*noiseCipher, *noiseCipher) {
+	k1, k2 := hkdf(h.chainingKey, nil)
+	return newNoiseCipher(k1), newNoiseCipher(k2)
+}
+
+// writeEphemeral handles the e token.
+func (h *noiseHandshake) writeEphemeral() []byte {
+	h.ephemeralPrivate, h.ephemeralPublic = newNoiseKey()
+	h.mixHash(h.ephemeralPublic)
+	return h.ephemeralPublic
+}
+
+func (h *noiseHandshake) readEphemeral(message []byte) ([]byte, error) {
+	if len(message) < curve25519.PointSize {
+		return nil, errors.New("noise message is too short")
+	}
+	h.remoteEphemeral = message[:curve25519.PointSize]
+	h.mixHash(h.remoteEphemeral)
+	return message[curve25519.PointSize:], nil
+}
+
+// readStatic handles the s token.
+func (h *noiseHandshake) readStatic(message []byte) ([]byte, error) {
+	size := curve25519.PointSize + chacha20poly1305.Overhead
+	if len(message) < size {
+		return nil, errors.New("noise message is too short")
+	}
+	static, err := h.decryptAndHash(message[:size])
+	if err != nil {
+		return nil, err
+	}
+	h.remoteStatic = static
+	return message[size:], nil
+}
+
+// A noiseIdentity is the handshake payload. It proves that the sender's
+// identity vouches for the sender's Noise static key.
+type noiseIdentity struct {
+	PublicKey string
+	Signature string
+}
+
+func (h *noiseHandshake) identityPayload(kp util.Signer) []byte {
+	bytes, err := json.Marshal(&noiseIdentity{
+		PublicKey: kp.PublicKey().String(),
+		Signature: kp.Sign(noiseStaticKeyPrefix + hex.EncodeToString(h.staticPublic)),
+	})
+	if err != nil {
+		panic(err)
+	}
+	return bytes
+}
+
+// readIdentity returns the public key that vouched for the remote static key.
+func (h *noiseHandshake) readIdentity(payload []byte) (string, error) {
+	identity := &noiseIdentity{}
+	if err := json.Unmarshal(payload, identity); err != nil {
+		return "", err
+	}
+	pk, err := util.ReadPublicKey(identity.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	message := noiseStaticKeyPrefix + hex.EncodeToString(h.remoteStatic)
+	if !util.VerifySignature(pk, message, identity.Signature) {
+		return "", errors.New("bad signature on the noise static key")
+	}
+	return identity.PublicKey, nil
+}
+
+func writeNoiseMessage(w io.Writer, message []byte) error {
+	if len(message) > noiseMaxMessageSize {
+		panic("noise message is too large")
+	}
+	frame := make([]byte, 2+len(message))
+	binary.BigEndian.PutUint16(frame, uint16(len(message)))
+	copy(frame[2:], message)
+	_, err := w.Write(frame)
+	return err
+}
+
+func readNoiseMessage(r io.Reader) ([]byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	message := make([]byte, binary.BigEndian.Uint16(header))
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// A noiseConn is a net.Conn that encrypts everything sent over it.
+type noiseConn struct {
+	net.Conn
+
+	// The identity of the other side, as proven by the handshake
+	remote string
+
+	reader  io.Reader
+	recv    *noiseCipher
+	pending []byte
+
+	writeMutex sync.Mutex
+	send       *noiseCipher
+}
+
+// Remote returns the public key of the other side of the connection.
+func (c *noiseConn) Remote() string {
+	return c.remote
+}
+
+func (c *noiseConn) Read(b []byte) (int, error) {
+	for len(c.pending) == 0 {
+		ciphertext, err := readNoiseMessage(c.reader)
+		if err != nil {
+			return 0, err
+		}
+		c.pending, err = c.recv.decrypt(nil, ciphertext)
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *noiseConn) Write(b []byte) (int, error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	written := 0
+	for written < len(b) {
+		end := written + noiseMaxPlaintextSize
+		if end > len(b) {
+			end = len(b)
+		}
+		if err := writeNoiseMessage(c.Conn, c.send.encrypt(nil, b[written:end])); err != nil {
+			return written, err
+		}
+		written = end
+	}
+	return written, nil
+}
+
+// DialNoise performs the initiator side of the handshake on a connection we
+// dialed. If remote is not empty, the other side must prove that it is remote.
+func DialNoise(conn net.Conn, kp util.Signer, remote string) (net.Conn, error) {
+	conn.SetDeadline(time.Now().Add(noiseHandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+	if _, err := io.WriteString(conn, noiseProtocolName+"\n"); err != nil {
+		return nil, err
+	}
+
+	h := newNoiseHandshake()
+	message := h.writeEphemeral()
+	message = append(message, h.encryptAndHash(nil)...)
+	if err := writeNoiseMessage(conn, message); err != nil {
+		return nil, err
+	}
+
+	message, err := readNoiseMessage(conn)
+	if err != nil {
+		return nil, err
+	}
+	if message, err = h.readEphemeral(message); err != nil {
+		return nil, err
+	}
+	if err := h.mixKey(h.ephemeralPrivate, h.remoteEphemeral); err != nil {
+		return nil, err
+	}
+	if message, err = h.readStatic(message); err != nil {
+		return nil, err
+	}
+	if err := h.mixKey(h.ephemeralPrivate, h.remoteStatic); err != nil {
+		return nil, err
+	}
+	payload, err := h.decryptAndHash(message)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := h.readIdentity(payload)
+	if err != nil {
+		return nil, err
+	}
+	if remote != "" && identity != remote {
+		return nil, fmt.Errorf("expected to reach %s but reached %s",
+			util.Shorten(remote), util.Shorten(identity))
+	}
+
+	message = h.encryptAndHash(h.staticPublic)
+	if err := h.mixKey(h.staticPrivate, h.remoteEphemeral); err != nil {
+		return nil, err
+	}
+	message = append(message, h.encryptAndHash(h.identityPayload(kp))...)
+	if err := writeNoiseMessage(conn, message); err != nil {
+		return nil, err
+	}
+
+	send, recv := h.split()
+	return &noiseConn{
+		Conn:   conn,
+		remote: identity,
+		reader: conn,
+		recv:   recv,
+		send:   send,
+	}, nil
+}
+
+// bufferedConn is a net.Conn that has had some of its input read into a buffer.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// AcceptNoise checks whether a connection we accepted wants to use Noise.
+// If it does, AcceptNoise performs the responder side of the handshake.
+// If it doesn't, the connection is returned unencrypted.
+func AcceptNoise(conn net.Conn, kp util.Signer) (net.Conn, error) {
+	// A plain connection might not send anything until its first keepalive
+	conn.SetDeadline(time.Now().Add(2 * keepalive * time.Second))
+	defer conn.SetDeadline(time.Time{})
+	reader := bufio.NewReader(conn)
+	buffered := &bufferedConn{Conn: conn, reader: reader}
+	first, err := reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] != noiseProtocolName[0] {
+		return buffered, nil
+	}
+	conn.SetDeadline(time.Now().Add(noiseHandshakeTimeout))
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if line != noiseProtocolName+"\n" {
+		return nil, fmt.Errorf("unsupported handshake: %q", line)
+	}
+
+	h := newNoiseHandshake()
+	message, err := readNoiseMessage(reader)
+	if err != nil {
+		return nil, err
+	}
+	if message, err = h.readEphemeral(message); err != nil {
+		return nil, err
+	}
+	if _, err := h.decryptAndHash(message); err != nil {
+		return nil, err
+	}
+
+	message = h.writeEphemeral()
+	if err := h.mixKey(h.ephemeralPrivate, h.remoteEphemeral); err != nil {
+		return nil, err
+	}
+	message = append(message, h.encryptAndHash(h.staticPublic)...)
+	if err := h.mixKey(h.staticPrivate, h.remoteEphemeral); err != nil {
+		return nil, err
+	}
+	message = append(message, h.encryptAndHash(h.identityPayload(kp))...)
+	if err := writeNoiseMessage(conn, message); err != nil {
+		return nil, err
+	}
+
+	message, err = readNoiseMessage(reader)
+	if err != nil {
+		return nil, err
+	}
+	if message, err = h.readStatic(message); err != nil {
+		return nil, err
+	}
+	if err := h.mixKey(h.ephemeralPrivate, h.remoteStatic); err != nil {
+		return nil, err
+	}
+	payload, err := h.decryptAndHash(message)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := h.readIdentity(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	recv, send := h.split()
+	return &noiseConn{
+		Conn:   conn,
+		remote: identity,
+		reader: reader,
+		recv:   recv,
+		send:   send,
+	}, nil
+}
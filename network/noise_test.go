@@ -0,0 +1,114 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lacker/coinkit/util"
+)
+
+// noisePair runs both sides of a handshake over an in-memory connection.
+func noisePair(dialer *util.KeyPair, listener *util.KeyPair, remote string) (
+	net.Conn, net.Conn, error) {
+	client, server := net.Pipe()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := AcceptNoise(server, listener)
+		if err != nil {
+			server.Close()
+		}
+		accepted <- conn
+	}()
+	conn, err := DialNoise(client, dialer, remote)
+	if err != nil {
+		client.Close()
+		<-accepted
+		return nil, nil, err
+	}
+	return conn, <-accepted, nil
+}
+
+func TestNoiseHandshake(t *testing.T) {
+	alice := util.NewKeyPairFromSecretPhrase("alice")
+	bob := util.NewKeyPairFromSecretPhrase("bob")
+	a, b, err := noisePair(alice, bob, bob.PublicKey().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	defer b.Close()
+	if a.(*noiseConn).Remote() != bob.PublicKey().String() ||
+		b.(*noiseConn).Remote() != alice.PublicKey().String() {
+		t.Fatal("the handshake should identify both sides")
+	}
+
+	// Big enough to need more than one frame
+	sent := bytes.Repeat([]byte("coinkit "), 20000)
+	go a.Write(sent)
+	received := make([]byte, len(sent))
+	if _, err := io.ReadFull(b, received); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sent, received) {
+		t.Fatal("the message was garbled")
+	}
+	go b.Write([]byte("reply"))
+	reply := make([]byte, 5)
+	if _, err := io.ReadFull(a, reply); err != nil || string(reply) != "reply" {
+		t.Fatalf("bad reply: %q %v", reply, err)
+	}
+}
+
+func TestNoiseWrongIdentity(t *testing.T) {
+	alice := util.NewKeyPairFromSecretPhrase("alice")
+	bob := util.NewKeyPairFromSecretPhrase("bob")
+	carol := util.NewKeyPairFromSecretPhrase("carol")
+	_, _, err := noisePair(alice, carol, bob.PublicKey().String())
+	if err == nil || !strings.Contains(err.Error(), "expected to reach") {
+		t.Fatalf("dialing the wrong server should fail, but got %v", err)
+	}
+}
+
+func TestAcceptPlainConnection(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go client.Write([]byte(util.OK + "\n"))
+	conn, err := AcceptNoise(server, util.NewKeyPair())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	line := make([]byte, 3)
+	if _, err := io.ReadFull(conn, line); err != nil || string(line) != util.OK+"\n" {
+		t.Fatalf("a plain connection should pass through, but got %q %v", line, err)
+	}
+}
+
+func TestServerAcceptsNoise(t *testing.T) {
+	s, kps := newIdleServer(t, 3)
+	go s.processMessagesForever()
+	defer s.Stop()
+
+	client, server := net.Pipe()
+	go s.handleConnection(server)
+	secure, err := DialNoise(client, util.NewKeyPair(), kps[0].PublicKey().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewClient(NewBasicConnection(secure, make(chan *util.SignedMessage)))
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stats, err := c.GetPeerStats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("expected stats for two peers but got %d", len(stats))
+	}
+}
@@ -0,0 +1,191 @@
+package network
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lacker/coinkit/util"
+)
+
+// PeerStats describes what we have gotten from one of the other servers in
+// the network config. It is meant to help debug flaky validators.
+type PeerStats struct {
+	PublicKey string
+	Address   string
+
+	// Whether our outgoing connection to the peer is up
+	Connected bool
+
+	// How many valid messages the peer has sent us, by message type
+	Messages map[string]int
+
+	// The total size of those messages, serialized
+	Bytes int
+
+	// How many messages claimed to be from the peer but had a bad signature
+	InvalidSignatures int
+
+	// How many messages from the peer were signed outside the replay window
+	Replays int
+
+	// When we last got a valid message from the peer. Zero if never
+	LastSeen time.Time
+
+	// How long our last successful dial to the peer took. Zero if never
+	Latency time.Duration
+}
+
+// Total returns how many valid messages the peer has sent us.
+func (ps *PeerStats) Total() int {
+	answer := 0
+	for _, count := range ps.Messages {
+		answer += count
+	}
+	return answer
+}
+
+// MessageCounts formats the message counts like "C=3 E=1", sorted by type.
+func (ps *PeerStats) MessageCounts() string {
+	types := []string{}
+	for t, _ := range ps.Messages {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	parts := []string{}
+	for _, t := range types {
+		parts = append(parts, fmt.Sprintf("%s=%d", t, ps.Messages[t]))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (ps *PeerStats) String() string {
+	lastSeen := "never"
+	if !ps.LastSeen.IsZero() {
+		lastSeen = time.Since(ps.LastSeen).Round(time.Millisecond).String() + " ago"
+	}
+	return fmt.Sprintf("%s at %s: connected=%t, %d messages (%s), %d bytes, "+
+		"%d invalid signatures, %d replays, last seen %s, latency %s",
+		util.Shorten(ps.PublicKey), ps.Address, ps.Connected, ps.Total(),
+		ps.MessageCounts(), ps.Bytes, ps.InvalidSignatures, ps.Replays, lastSeen,
+		ps.Latency)
+}
+
+// A PeersMessage asks a server for its PeerStats when Peers is nil, and
+// answers that query otherwise.
+type PeersMessage struct {
+	Peers []*PeerStats
+}
+
+func (m *PeersMessage) Slot() int {
+	return 0
+}
+
+func (m *PeersMessage) MessageType() string {
+	return "S"
+}
+
+func (m *PeersMessage) String() string {
+	if m.Peers == nil {
+		return "peers query"
+	}
+	return fmt.Sprintf("peers: %d", len(m.Peers))
+}
+
+// peerTracker counts the messages we get from each of the other servers.
+// Messages from keys that are not in the config are ignored, so that clients
+// can't make it grow without bound.
+// It is threadsafe, because invalid signatures are reported from the
+// connection goroutines.
+type peerTracker struct {
+	mutex sync.Mutex
+	stats map[string]*PeerStats
+}
+
+func newPeerTracker(config *Config, self string) *peerTracker {
+	t := &peerTracker{
+		stats: make(map[string]*PeerStats),
+	}
+	for key, address := range config.Servers {
+		if key == self {
+			continue
+		}
+		t.stats[key] = &PeerStats{
+			PublicKey: key,
+			Address:   address.String(),
+			Messages:  make(map[string]int),
+		}
+	}
+	return t
+}
+
+// received records a message with a valid signature.
+func (t *peerTracker) received(sm *util.SignedMessage) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	ps, ok := t.stats[sm.Signer()]
+	if !ok {
+		return
+	}
+	ps.Messages[sm.Message().MessageType()]++
+	ps.Bytes += sm.Size()
+	ps.LastSeen = time.Now()
+}
+
+// invalidSignature records a message whose signature did not match the key
+// it claimed to be from.
+func (t *peerTracker) invalidSignature(signer string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if ps, ok := t.stats[signer]; ok {
+		ps.InvalidSignatures++
+	}
+}
+
+// replayed records a message from outside the replay window.
+func (t *peerTracker) replayed(signer string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if ps, ok := t.stats[signer]; ok {
+		ps.Replays++
+	}
+}
+
+// snapshot returns a copy of the stats, sorted by public key.
+func (t *peerTracker) snapshot() []*PeerStats {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	answer := []*PeerStats{}
+	for _, ps := range t.stats {
+		copied := *ps
+		copied.Messages = make(map[string]int)
+		for messageType, count := range ps.Messages {
+			copied.Messages[messageType] = count
+		}
+		answer = append(answer, &copied)
+	}
+	sort.Slice(answer, func(i, j int) bool {
+		return answer[i].PublicKey < answer[j].PublicKey
+	})
+	return answer
+}
+
+// PeerStats returns stats for each of the other servers in the config.
+func (s *Server) PeerStats() []*PeerStats {
+	answer := s.peerTracker.snapshot()
+	for _, ps := range answer {
+		for _, peer := range s.peers {
+			if peer.address.String() == ps.Address {
+				ps.Connected = peer.IsConnected()
+				ps.Latency = peer.Latency()
+			}
+		}
+	}
+	return answer
+}
+
+func init() {
+	util.RegisterMessageType(&PeersMessage{})
+}
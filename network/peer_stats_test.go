@@ -0,0 +1,44 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/lacker/coinkit/util"
+)
+
+func TestPeerStats(t *testing.T) {
+	s, kps := newIdleServer(t, 3)
+
+	peer := kps[1].PublicKey().String()
+	sm := util.NewSignedMessage(&PromotionMessage{I: 1}, kps[1])
+	s.unsafeProcessMessage(sm)
+	s.unsafeProcessMessage(sm)
+	s.unsafeProcessMessage(util.NewSignedMessage(&PromotionMessage{I: 1}, util.NewKeyPair()))
+	s.peerTracker.invalidSignature(peer)
+	s.peerTracker.invalidSignature(util.NewKeyPair().PublicKey().String())
+
+	query := util.NewSignedMessage(&PeersMessage{}, util.NewKeyPair())
+	response := s.unsafeProcessMessage(query).sign(kps[0])
+	if response == nil {
+		t.Fatal("expected a response to the peers query")
+	}
+	decoded := util.EncodeThenDecodeMessage(response.Message()).(*PeersMessage)
+	if len(decoded.Peers) != 2 {
+		t.Fatalf("expected stats for two peers but got %d", len(decoded.Peers))
+	}
+	for _, ps := range decoded.Peers {
+		if ps.PublicKey != peer {
+			if ps.Total() != 0 || !ps.LastSeen.IsZero() {
+				t.Fatalf("nothing came from %s", ps)
+			}
+			continue
+		}
+		if ps.Messages["R"] != 2 || ps.Bytes != 2*sm.Size() ||
+			ps.InvalidSignatures != 1 || ps.LastSeen.IsZero() {
+			t.Fatalf("bad stats: %s", ps)
+		}
+		if ps.Address != s.config.Servers[peer].String() {
+			t.Fatalf("bad address: %s", ps.Address)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package network
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lacker/coinkit/currency"
+)
+
+// The most pending operations /operations/pending returns at once, and how
+// many it returns when the request doesn't say
+const (
+	maxPendingLimit     = 1000
+	defaultPendingLimit = 100
+)
+
+var errInvalidPendingCursor = errors.New("invalid cursor")
+
+// A PendingPage is one page of the pending operations, highest fee first.
+type PendingPage struct {
+	Operations []*currency.PendingOperation `json:"operations"`
+
+	// The cursor for the next page. It's empty on the last page
+	Next string `json:"next,omitempty"`
+}
+
+// pendingCursor makes a cursor for a page that ended on p. It holds the fee
+// and signature rather than a position, so the next page starts in the right
+// place even if operations were added or removed in between.
+func pendingCursor(p *currency.PendingOperation) string {
+	return base64.RawURLEncoding.EncodeToString(
+		[]byte(fmt.Sprintf("%d:%s", p.Fee, p.Signature)))
+}
+
+func parsePendingCursor(cursor string) (fee uint64, signature string, err error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, "", errInvalidPendingCursor
+	}
+	parts := strings.SplitN(string(bytes), ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return 0, "", errInvalidPendingCursor
+	}
+	fee, err = strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, "", errInvalidPendingCursor
+	}
+	return fee, parts[1], nil
+}
+
+// NewPendingPage returns up to limit of the operations that come after the
+// cursor. The operations should be in the order OperationQueue.Pending
+// returns them. An empty cursor starts from the beginning.
+func NewPendingPage(ops []*currency.PendingOperation, cursor string,
+	limit int) (*PendingPage, error) {
+	if cursor != "" {
+		fee, signature, err := parsePendingCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		for len(ops) > 0 && !ops[0].After(fee, signature) {
+			ops = ops[1:]
+		}
+	}
+	page := &PendingPage{Operations: ops}
+	if len(ops) > limit {
+		page.Operations = ops[:limit]
+		page.Next = pendingCursor(ops[limit-1])
+	}
+	return page, nil
+}
+
+type pendingRequest struct {
+	filter   *PendingFilter
+	response chan []*currency.PendingOperation
+}
+
+// Pending describes the operations waiting to get into a block that pass the
+// filter, highest fee first.
+// It returns nil if the server is shutting down.
+func (s *Server) Pending(filter *PendingFilter) []*currency.PendingOperation {
+	request := &pendingRequest{
+		filter:   filter,
+		response: make(chan []*currency.PendingOperation, 1),
+	}
+	select {
+	case s.pendingRequests <- request:
+		return <-request.response
+	case <-s.quit:
+		return nil
+	}
+}
+
+// handlePending lists the pending operations, so a wallet can show its user
+// that a payment is queued, along with how long it has waited and how many
+// operations are ahead of it. The account parameter limits the list to
+// operations that involve an account, and the type parameter to operations
+// of a type. Either can be repeated. The limit parameter sets the page size,
+// and the cursor parameter takes the next cursor from the previous page.
+// Nodes with private queries don't list anything.
+func (s *Server) handlePending(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "pending operations must be fetched with GET", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.config.PrivateQueries {
+		http.Error(w, "this node's queries are private", http.StatusForbidden)
+		return
+	}
+	query := r.URL.Query()
+	limit := defaultPendingLimit
+	if l := query.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > maxPendingLimit {
+			http.Error(w, fmt.Sprintf("the limit must be from 1 to %d", maxPendingLimit),
+				http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	filter := &PendingFilter{
+		Accounts: accountKeys(query["account"]),
+		Types:    query["type"],
+	}
+	ops := s.Pending(filter)
+	if ops == nil {
+		http.Error(w, "the server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	page, err := NewPendingPage(ops, query.Get("cursor"), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
@@ -0,0 +1,88 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lacker/coinkit/util"
+)
+
+func TestPendingEndpoint(t *testing.T) {
+	s, _ := newIdleServer(t, 3)
+	defer s.Stop()
+	bob := util.NewKeyPairFromSecretPhrase("bob")
+	carol := util.NewKeyPairFromSecretPhrase("carol")
+	senders := []*util.KeyPair{}
+	for i := 0; i < 3; i++ {
+		kp := util.NewKeyPairFromSecretPhrase(fmt.Sprintf("sender %d", i))
+		s.node.queue.SetBalance(kp.PublicKey().String(), 100)
+		senders = append(senders, kp)
+	}
+	go s.processMessagesForever()
+
+	for _, kp := range senders {
+		s.handleMessageOnce(util.NewSignedMessage(newSendMessage(kp, bob, 1, 10), kp))
+	}
+
+	get := func(url string) (int, *PendingPage) {
+		w := httptest.NewRecorder()
+		s.handlePending(w, httptest.NewRequest("GET", url, nil))
+		if w.Code != http.StatusOK {
+			return w.Code, nil
+		}
+		page := &PendingPage{}
+		if err := json.NewDecoder(w.Body).Decode(page); err != nil {
+			t.Fatal(err)
+		}
+		return w.Code, page
+	}
+
+	_, page := get("/operations/pending?limit=2")
+	if len(page.Operations) != 2 || page.Next == "" {
+		t.Fatalf("expected a full first page but got %+v", page)
+	}
+	seen := map[string]bool{}
+	for _, op := range page.Operations {
+		seen[op.Signature] = true
+	}
+	_, page = get("/operations/pending?limit=2&cursor=" + page.Next)
+	if len(page.Operations) != 1 || page.Next != "" {
+		t.Fatalf("expected one more operation on the last page but got %+v", page)
+	}
+	if seen[page.Operations[0].Signature] || page.Operations[0].Ahead != 2 {
+		t.Fatalf("bad last page: %+v", page.Operations[0])
+	}
+
+	// Filtering by account accepts addresses
+	_, page = get("/operations/pending?account=" + bob.PublicKey().Address(util.DefaultAddressPrefix))
+	if len(page.Operations) != 3 {
+		t.Fatalf("expected bob's three operations but got %d", len(page.Operations))
+	}
+	_, page = get("/operations/pending?account=" + carol.PublicKey().String())
+	if len(page.Operations) != 0 {
+		t.Fatalf("carol should have nothing pending but got %+v", page)
+	}
+	_, page = get("/operations/pending?type=Message")
+	if len(page.Operations) != 0 {
+		t.Fatalf("nothing but sends are pending but got %+v", page)
+	}
+
+	if code, _ := get("/operations/pending?cursor=garbage"); code != http.StatusBadRequest {
+		t.Fatalf("expected a bad cursor to be rejected but got %d", code)
+	}
+	if code, _ := get("/operations/pending?limit=0"); code != http.StatusBadRequest {
+		t.Fatalf("expected a bad limit to be rejected but got %d", code)
+	}
+	w := httptest.NewRecorder()
+	s.handlePending(w, httptest.NewRequest("POST", "/operations/pending", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST should not be allowed but got %d", w.Code)
+	}
+	s.config.PrivateQueries = true
+	if code, _ := get("/operations/pending"); code != http.StatusForbidden {
+		t.Fatalf("private queries should hide the pending operations but got %d", code)
+	}
+}
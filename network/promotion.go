@@ -0,0 +1,170 @@
+package network
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lacker/coinkit/consensus"
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/util"
+)
+
+// Standby servers follow the chain without being validators. When a
+// validator goes silent, the next standby server in line asks to replace it,
+// by gossiping a PromotionMessage. Each validator that has also not heard
+// from the silent validator for LivenessTimeout votes for the replacement
+// with a ValidatorOperation. So the promotion only happens once a quorum of
+// the remaining validators agree that the validator is gone.
+
+// How long a validator can go without sending us anything before we consider
+// it to be gone
+const DefaultLivenessTimeout = time.Minute
+
+// How often a standby server checks whether the validators are alive
+const livenessCheckInterval = time.Second
+
+// How many slots in the future a promotion takes effect, which gives the
+// validators time to vote
+const PromotionDelay = 10
+
+// A PromotionMessage is sent by a standby server asking to replace a
+// validator that has gone silent.
+type PromotionMessage struct {
+	// The slot the replacement should take effect at
+	I int
+
+	// The validator to replace
+	Validator string
+
+	// The standby server that replaces it
+	Standby string
+}
+
+func (m *PromotionMessage) Slot() int {
+	return m.I
+}
+
+func (m *PromotionMessage) MessageType() string {
+	return "R"
+}
+
+func (m *PromotionMessage) String() string {
+	return fmt.Sprintf("promotion i=%d: replace %s with %s",
+		m.I, util.Shorten(m.Validator), util.Shorten(m.Standby))
+}
+
+func isMember(qs consensus.QuorumSlice, key string) bool {
+	for _, member := range qs.Members {
+		if member == key {
+			return true
+		}
+	}
+	return false
+}
+
+// unsafeHeardFrom records that a message came from another server.
+func (s *Server) unsafeHeardFrom(key string) {
+	if _, ok := s.config.Servers[key]; ok {
+		s.lastHeard[key] = time.Now()
+	}
+}
+
+// unsafeSilent returns whether we haven't heard from a server for
+// LivenessTimeout. Servers get LivenessTimeout from when we started, too.
+func (s *Server) unsafeSilent(key string) bool {
+	last, ok := s.lastHeard[key]
+	if !ok || last.Before(s.start) {
+		last = s.start
+	}
+	return time.Now().Sub(last) > s.LivenessTimeout
+}
+
+// unsafeNextStandby returns the standby server that is next in line for a
+// promotion, or "" if there is none.
+func (s *Server) unsafeNextStandby() string {
+	qs := s.node.queue.QuorumSlice()
+	for _, key := range s.config.Standby {
+		if !isMember(qs, key) {
+			return key
+		}
+	}
+	return ""
+}
+
+// unsafeCheckLiveness asks for a promotion if we are the next standby server
+// in line and a validator has gone silent.
+func (s *Server) unsafeCheckLiveness() {
+	self := s.keyPair.PublicKey().String()
+	if s.unsafeNextStandby() != self {
+		return
+	}
+	if p := s.node.promotion; p != nil && p.I > s.node.Slot() {
+		// We are still waiting on the last request
+		return
+	}
+	for _, member := range s.node.queue.QuorumSlice().Members {
+		if s.unsafeSilent(member) {
+			m := &PromotionMessage{
+				I:         s.node.Slot() + PromotionDelay,
+				Validator: member,
+				Standby:   self,
+			}
+			s.Logf("validator %s is silent, requesting %s", util.Shorten(member), m)
+			s.node.RequestPromotion(m)
+			s.unsafeUpdateOutgoing()
+			return
+		}
+	}
+}
+
+// unsafeHandlePromotion votes for a promotion if we are a validator and we
+// agree that the validator being replaced is gone.
+func (s *Server) unsafeHandlePromotion(sender string, m *PromotionMessage) {
+	qs := s.node.queue.QuorumSlice()
+	if sender != m.Standby || m.Standby != s.unsafeNextStandby() ||
+		!isMember(qs, s.keyPair.PublicKey().String()) || !isMember(qs, m.Validator) ||
+		m.I <= s.node.Slot() || !s.unsafeSilent(m.Validator) {
+		return
+	}
+	key := m.String()
+	if s.promotionVotes[key] {
+		return
+	}
+	s.promotionVotes[key] = true
+	s.Logf("voting for %s", m)
+	s.node.voteForPromotion(m)
+}
+
+// RequestPromotion makes a standby node keep asking for the promotion until
+// its slot arrives.
+func (node *Node) RequestPromotion(m *PromotionMessage) {
+	node.promotion = m
+}
+
+// voteForPromotion signs a vote for the replacement a promotion asks for.
+func (node *Node) voteForPromotion(m *PromotionMessage) {
+	if node.keyPair == nil {
+		return
+	}
+	signer := node.keyPair.PublicKey().String()
+	sequence := uint32(1)
+	if account := node.queue.Account(signer); account != nil {
+		sequence = account.Sequence + 1
+	}
+	op := &currency.ValidatorOperation{
+		Signer:      signer,
+		Sequence:    sequence,
+		Action:      currency.ValidatorReplace,
+		Validator:   m.Validator,
+		Replacement: m.Standby,
+		Threshold:   node.queue.QuorumSlice().Threshold,
+		Activation:  m.I,
+	}
+	if node.queue.Add(util.NewSignedOperation(op, node.keyPair)) {
+		node.chain.ValueStoreUpdated()
+	}
+}
+
+func init() {
+	util.RegisterMessageType(&PromotionMessage{})
+}
@@ -0,0 +1,94 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/util"
+)
+
+func TestStandbyPromotion(t *testing.T) {
+	// Servers 0-3 are validators, server 4 is on standby, and server 3 dies.
+	// None of these servers listen, so they don't need unit test ports.
+	config, kps := NewLocalhostNetwork(9000, 5, 0)
+	config.Threshold = 3
+	config.Standby = []string{kps[4].PublicKey().String()}
+	dead := kps[3].PublicKey().String()
+	standby := kps[4].PublicKey().String()
+
+	servers := []*Server{}
+	for _, kp := range kps {
+		s := NewServer(kp, config, nil)
+		s.start = time.Now().Add(-time.Hour)
+		s.LivenessTimeout = time.Minute
+		for _, other := range kps[:3] {
+			s.lastHeard[other.PublicKey().String()] = time.Now()
+		}
+		s.lastHeard[standby] = time.Now()
+		servers = append(servers, s)
+	}
+
+	// Server 2 heard from the dead server recently, so it won't vote yet
+	servers[2].lastHeard[dead] = time.Now()
+
+	servers[4].unsafeCheckLiveness()
+	request := servers[4].node.promotion
+	if request == nil || request.Validator != dead || request.Standby != standby {
+		t.Fatalf("expected the standby to ask to replace server 3 but got %+v", request)
+	}
+	sm := util.NewSignedMessage(request, kps[4])
+	for _, s := range servers[:3] {
+		s.unsafeProcessMessage(sm)
+	}
+	if servers[2].node.queue.Size() != 0 {
+		t.Fatal("server 2 should not vote to replace a server it just heard from")
+	}
+	for _, s := range servers[:2] {
+		ops := s.node.queue.Operations()
+		if len(ops) != 1 {
+			t.Fatalf("expected one vote but got %d", len(ops))
+		}
+		vote, ok := ops[0].Operation.(*currency.ValidatorOperation)
+		if !ok || vote.Action != currency.ValidatorReplace ||
+			vote.Validator != dead || vote.Replacement != standby {
+			t.Fatalf("bad vote: %s", ops[0].Operation)
+		}
+	}
+
+	// Once server 2 agrees that server 3 is gone, there are enough votes
+	servers[2].lastHeard[dead] = time.Now().Add(-time.Hour)
+	servers[2].unsafeProcessMessage(sm)
+
+	// Run the remaining servers until the promotion takes effect
+	nodes := []*Node{}
+	for _, i := range []int{0, 1, 2, 4} {
+		nodes = append(nodes, servers[i].node)
+	}
+	mint := util.NewKeyPairFromSecretPhrase("mint")
+	bob := util.NewKeyPairFromSecretPhrase("bob")
+	for seq := 1; nodes[0].Slot() <= request.I; seq++ {
+		if seq > 2*PromotionDelay {
+			t.Fatalf("stuck at slot %d", nodes[0].Slot())
+		}
+		nodes[0].Handle(mint.PublicKey().String(), newSendMessage(mint, bob, seq, 1))
+		for i := 0; i < 10; i++ {
+			for _, source := range nodes {
+				for _, target := range nodes {
+					if source != target {
+						sendNodeToNodeMessages(source, target, t)
+					}
+				}
+			}
+			timeOutRounds(nodes)
+		}
+	}
+	for i, node := range nodes {
+		if isMember(node.chain.D, dead) || !isMember(node.chain.D, standby) {
+			t.Fatalf("nodes[%d] did not promote the standby: %+v", i, node.chain.D)
+		}
+	}
+	if servers[4].unsafeNextStandby() != "" {
+		t.Fatal("there should be no standby servers left")
+	}
+}
@@ -0,0 +1,36 @@
+package network
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/proxy"
+)
+
+// A server can dial its peers through a SOCKS5 proxy, like the one Tor runs,
+// for network-level privacy. The proxy resolves host names itself, so peers
+// can be listed by onion address. A server with both a regular host and an
+// onion address is dialed at the onion address when going through a proxy.
+
+// NewProxyDialer returns a dialer that connects through a SOCKS5 proxy, given
+// as a url like "socks5://127.0.0.1:9050". The url can include a username and
+// password.
+func NewProxyDialer(proxyURL string) (proxy.Dialer, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "socks5" && u.Scheme != "socks5h" {
+		return nil, fmt.Errorf("the proxy must be a socks5 url: %q", proxyURL)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("the proxy url has no host: %q", proxyURL)
+	}
+	return proxy.FromURL(u, proxy.Direct)
+}
+
+// IsOnion returns whether a host is a Tor onion address.
+func IsOnion(host string) bool {
+	return strings.HasSuffix(host, ".onion")
+}
@@ -0,0 +1,107 @@
+package network
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lacker/coinkit/util"
+)
+
+// fakeSOCKS5 accepts one SOCKS5 connection, reports where it was asked to
+// connect to, and then connects to target no matter what was asked for.
+func fakeSOCKS5(t *testing.T, target string) (net.Listener, chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	requested := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// The greeting lists auth methods, and we pick no auth
+		header := make([]byte, 2)
+		io.ReadFull(conn, header)
+		io.ReadFull(conn, make([]byte, header[1]))
+		conn.Write([]byte{5, 0})
+
+		// A connect request for a domain name
+		request := make([]byte, 5)
+		io.ReadFull(conn, request)
+		if request[3] != 3 {
+			t.Errorf("expected the proxy to resolve the host, but got type %d", request[3])
+			return
+		}
+		host := make([]byte, request[4])
+		io.ReadFull(conn, host)
+		port := make([]byte, 2)
+		io.ReadFull(conn, port)
+		requested <- fmt.Sprintf("%s:%d", host, binary.BigEndian.Uint16(port))
+		conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+		upstream, err := net.Dial("tcp", target)
+		if err != nil {
+			return
+		}
+		defer upstream.Close()
+		go io.Copy(upstream, conn)
+		io.Copy(conn, upstream)
+	}()
+	return ln, requested
+}
+
+func TestDialThroughProxy(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	proxyListener, requested := fakeSOCKS5(t, target.Addr().String())
+	defer proxyListener.Close()
+
+	dialer, err := NewProxyDialer("socks5://" + proxyListener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := &Address{Host: "10.1.2.3", Port: 9001, Onion: "coinkitexample.onion"}
+	c := newRedialConnection(address, nil, nil, "", dialer)
+	defer c.Close()
+	c.Send(util.NewSignedMessage(&PeersMessage{}, util.NewKeyPair()))
+
+	select {
+	case r := <-requested:
+		if r != "coinkitexample.onion:9001" {
+			t.Fatalf("expected to dial the onion address but dialed %s", r)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the proxy was never used")
+	}
+
+	conn, err := target.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "c:") {
+		t.Fatalf("expected a message through the proxy but got %q %v", line, err)
+	}
+}
+
+func TestBadProxy(t *testing.T) {
+	for _, url := range []string{"http://127.0.0.1:9050", "socks5://", "127.0.0.1:9050"} {
+		if _, err := NewProxyDialer(url); err == nil {
+			t.Fatalf("expected %q to be rejected", url)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package network
+
+import (
+	"context"
+	"time"
+
+	"github.com/lacker/coinkit/bus"
+	"github.com/lacker/coinkit/data"
+	"github.com/lacker/coinkit/util"
+)
+
+// How long to wait for the bus to accept a block before giving up on it
+const publishTimeout = 10 * time.Second
+
+// A blockPublisher publishes finalized blocks to a message bus in its own
+// goroutine, so that a slow bus doesn't slow down consensus.
+type blockPublisher struct {
+	publisher bus.Publisher
+
+	// Blocks are keyed by the node that publishes them
+	node string
+
+	queue chan *data.Block
+	quit  chan bool
+}
+
+func newBlockPublisher(publisher bus.Publisher, node string, quit chan bool) *blockPublisher {
+	return &blockPublisher{
+		publisher: publisher,
+		node:      node,
+		queue:     make(chan *data.Block, webhookQueueSize),
+		quit:      quit,
+	}
+}
+
+// notify queues up a block to publish. It never blocks. If the queue is full
+// the block is dropped.
+func (p *blockPublisher) notify(b *data.Block) {
+	select {
+	case p.queue <- b:
+	default:
+		util.Logger.Printf("bus queue is full, dropping slot %d", b.Slot)
+	}
+}
+
+// publishForever should be run in its own goroutine.
+func (p *blockPublisher) publishForever() {
+	defer p.publisher.Close()
+	for {
+		select {
+		case <-p.quit:
+			return
+		case b := <-p.queue:
+			ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+			err := p.publisher.Publish(ctx, p.node, bus.NewBlockMessage(b).Encode())
+			cancel()
+			if err != nil {
+				util.Logger.Printf("could not publish slot %d: %s", b.Slot, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/data"
+)
+
+// fakePublisher sends published values to a channel.
+type fakePublisher struct {
+	published chan string
+	closed    chan bool
+}
+
+func (p *fakePublisher) Publish(ctx context.Context, key string, value []byte) error {
+	p.published <- key + " " + string(value)
+	return nil
+}
+
+func (p *fakePublisher) Close() error {
+	close(p.closed)
+	return nil
+}
+
+func TestBlockPublisher(t *testing.T) {
+	fake := &fakePublisher{published: make(chan string, 10), closed: make(chan bool)}
+	quit := make(chan bool)
+	p := newBlockPublisher(fake, "node", quit)
+	go p.publishForever()
+
+	p.notify(&data.Block{Slot: 1, Chunk: currency.NewEmptyChunk()})
+	p.notify(&data.Block{Slot: 2, Chunk: currency.NewEmptyChunk()})
+	for slot := 1; slot <= 2; slot++ {
+		select {
+		case message := <-fake.published:
+			expected := fmt.Sprintf(`node {"slot":%d,"operations":[],"accounts":{}}`, slot)
+			if message != expected {
+				t.Fatalf("expected %s but got %s", expected, message)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("slot %d was never published", slot)
+		}
+	}
+
+	close(quit)
+	select {
+	case <-fake.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the publisher was never closed")
+	}
+}
@@ -0,0 +1,230 @@
+package network
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+
+	"github.com/lacker/coinkit/consensus"
+	"github.com/lacker/coinkit/util"
+)
+
+// The QUIC transport is an experiment in running the connections between
+// servers over QUIC instead of TCP, for validators on high latency links.
+// Each pair of servers shares one QUIC connection, with one stream for
+// consensus messages and another for everything else, like operations. A
+// lost packet only holds up its own stream, so a big batch of operations
+// doesn't hold up the ballots behind it the way a lost TCP segment holds up
+// everything sent after it. When a connection drops, the redial resumes the
+// last TLS session with 0-RTT, so the first messages go out with the
+// handshake instead of a round trip after it.
+// Clients still connect over TCP. Each server listens for QUIC on the same
+// port number it uses for TCP.
+//
+// The TLS certificates are throwaway, since every message is signed anyway.
+// Encrypt still works on top of QUIC, to authenticate the servers on each
+// stream with Noise. QUIC can't go through a SOCKS5 proxy, so the transport
+// can't be combined with Proxy.
+
+// The ALPN protocol for coinkit over QUIC
+const quicProtocol = "coinkit"
+
+// How long dialing a QUIC connection and opening a stream on it can take
+const quicDialTimeout = 5 * time.Second
+
+var quicConfig = &quic.Config{
+	Allow0RTT:       true,
+	KeepAlivePeriod: keepalive * time.Second,
+}
+
+// isConsensusMessage returns whether a message goes on the consensus stream.
+func isConsensusMessage(m util.Message) bool {
+	switch m.(type) {
+	case *consensus.NominationMessage, *consensus.PrepareMessage,
+		*consensus.ConfirmMessage, *consensus.ExternalizeMessage:
+		return true
+	}
+	return false
+}
+
+// checkTransport returns an error if the config combines the quic transport
+// with the settings it can't work with.
+func (c *Config) checkTransport() error {
+	if c.QUIC && c.Proxy != "" {
+		return fmt.Errorf("the quic transport can't go through a proxy")
+	}
+	return nil
+}
+
+// newQUICServerTLS makes a TLS config with a new self-signed certificate.
+// Its session tickets are only good until the server restarts, so a redial
+// to a restarted server falls back to a full handshake.
+func newQUICServerTLS() *tls.Config {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(10 * 365 * 24 * time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, pub, priv)
+	if err != nil {
+		panic(err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{cert}, PrivateKey: priv}},
+		NextProtos:   []string{quicProtocol},
+	}
+}
+
+// A streamConn is a QUIC stream that works as a net.Conn, so streams can be
+// handled like TCP connections.
+type streamConn struct {
+	*quic.Stream
+	conn *quic.Conn
+}
+
+func (c *streamConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *streamConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Close closes both directions of the stream. Closing a QUIC stream only
+// closes the side we send on.
+func (c *streamConn) Close() error {
+	c.Stream.CancelRead(0)
+	return c.Stream.Close()
+}
+
+// A quicDialer keeps one QUIC connection to another server, and opens streams
+// on it. The consensus and bulk connections to a server share one.
+// It implements proxy.Dialer, so a RedialConnection can dial through it.
+type quicDialer struct {
+	address *Address
+	tls     *tls.Config
+
+	mutex sync.Mutex
+	conn  *quic.Conn
+}
+
+func newQUICDialer(address *Address) *quicDialer {
+	return &quicDialer{
+		address: address,
+		tls: &tls.Config{
+			// The certificates are throwaway. See the top of this file
+			InsecureSkipVerify: true,
+			NextProtos:         []string{quicProtocol},
+			ServerName:         address.Host,
+
+			// Remembering the session is what lets a redial use 0-RTT.
+			// Every dialer has its own, since the servers can share a host.
+			ClientSessionCache: tls.NewLRUClientSessionCache(1),
+		},
+	}
+}
+
+// Dial opens a new stream to the server, dialing a new connection first if
+// the last one is gone. The arguments are ignored, since a quicDialer only
+// dials one server.
+func (d *quicDialer) Dial(network, address string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), quicDialTimeout)
+	defer cancel()
+	conn, err := d.connection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &streamConn{Stream: stream, conn: conn}, nil
+}
+
+// connection returns the live connection to the server, dialing one if there
+// isn't one.
+func (d *quicDialer) connection(ctx context.Context) (*quic.Conn, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.conn != nil && d.conn.Context().Err() == nil {
+		return d.conn, nil
+	}
+	conn, err := quic.DialAddrEarly(ctx, d.address.String(), d.tls, quicConfig)
+	if err != nil {
+		return nil, err
+	}
+	d.conn = conn
+	return conn, nil
+}
+
+func (d *quicDialer) Close() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.conn != nil {
+		d.conn.CloseWithError(0, "closed")
+	}
+}
+
+// acquireQUICPort starts listening for QUIC connections.
+func (s *Server) acquireQUICPort() {
+	ln, err := quic.ListenAddrEarly(fmt.Sprintf("127.0.0.1:%d", s.port),
+		newQUICServerTLS(), quicConfig)
+	if err != nil {
+		util.Logger.Fatalf("could not acquire quic port %d: %s", s.port, err)
+	}
+	s.quicListener = ln
+}
+
+// listenQUIC accepts QUIC connections that the ACL permits, and handles each
+// stream on them like a TCP connection.
+func (s *Server) listenQUIC() {
+	for {
+		conn, err := s.quicListener.Accept(context.Background())
+		if s.shutdown || errors.Is(err, quic.ErrServerClosed) {
+			return
+		}
+		if err != nil {
+			util.Logger.Print("incoming quic connection error: ", err)
+			continue
+		}
+		if !s.ACL.Permits(conn.RemoteAddr()) {
+			s.Logf("rejecting quic connection from %s", conn.RemoteAddr())
+			conn.CloseWithError(0, "not permitted")
+			continue
+		}
+		go s.acceptStreams(conn)
+	}
+}
+
+// acceptStreams handles the streams on a connection until it closes, or
+// until the server stops.
+func (s *Server) acceptStreams(conn *quic.Conn) {
+	go func() {
+		select {
+		case <-s.quit:
+			conn.CloseWithError(0, "server stopped")
+		case <-conn.Context().Done():
+		}
+	}()
+	for {
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		go s.handleConnection(&streamConn{Stream: stream, conn: conn})
+	}
+}
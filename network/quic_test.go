@@ -0,0 +1,367 @@
+package network
+
+import (
+	"bufio"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+
+	"github.com/lacker/coinkit/consensus"
+	"github.com/lacker/coinkit/util"
+)
+
+// expectRequest answers the next message the server gets with a nomination.
+func expectRequest(t *testing.T, s *Server, signer *util.KeyPair) {
+	t.Helper()
+	select {
+	case request := <-s.requests:
+		sm := request.Message
+		if sm.Signer() != signer.PublicKey().String() {
+			t.Fatalf("unexpected message: %s", sm.Message())
+		}
+		request.Response <- &Response{
+			Message: &consensus.NominationMessage{I: 1, D: s.config.QuorumSlice()},
+			Version: sm.ProtocolVersion(),
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the message never arrived")
+	}
+}
+
+func TestQUICStreams(t *testing.T) {
+	// The quic port is picked by the OS, so it doesn't collide with the
+	// unit test ports.
+	s, kps := newIdleServer(t, 3)
+	defer s.Stop()
+	ln, err := quic.ListenAddrEarly("127.0.0.1:0", newQUICServerTLS(), quicConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.quicListener = ln
+	go s.listenQUIC()
+	d := newQUICDialer(&Address{Host: "127.0.0.1", Port: ln.Addr().(*net.UDPAddr).Port})
+	defer d.Close()
+
+	// Each stream is handled like a tcp connection
+	nomination := &consensus.NominationMessage{I: 1, D: s.config.QuorumSlice()}
+	for i := 0; i < 2; i++ {
+		conn, err := d.Dial("udp", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		util.NewSignedMessage(nomination, kps[1]).Write(conn)
+		expectRequest(t, s, kps[1])
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		response, err := util.ReadSignedMessage(bufio.NewReader(conn))
+		if err != nil || response.Signer() != kps[0].PublicKey().String() {
+			t.Fatalf("bad response: %v %v", response, err)
+		}
+	}
+
+	// A redial resumes the session with 0-RTT, and the server takes the
+	// messages sent before the handshake is done
+	first := d.conn
+	first.CloseWithError(0, "")
+	conn, err := d.Dial("udp", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if d.conn == first {
+		t.Fatal("the closed connection should be redialed")
+	}
+	util.NewSignedMessage(nomination, kps[2]).Write(conn)
+	expectRequest(t, s, kps[2])
+	<-d.conn.HandshakeComplete()
+	if !d.conn.ConnectionState().Used0RTT {
+		t.Fatal("the redial should use 0-RTT")
+	}
+}
+
+func TestQUICNetwork(t *testing.T) {
+	config, kps := NewUnitTestNetwork()
+	config.QUIC = true
+	servers := []*Server{}
+	for _, kp := range kps {
+		server := NewServer(kp, config, nil)
+		server.ServeInBackground()
+		servers = append(servers, server)
+	}
+	defer stopServers(servers)
+	conn := NewRedialConnection(servers[0].LocalhostAddress(), nil)
+	defer conn.Close()
+
+	mint := util.NewKeyPairFromSecretPhrase("mint")
+	bob := util.NewKeyPairFromSecretPhrase("bob")
+	sendMoney(conn, mint, bob, 1)
+	for _, ps := range servers[0].PeerStats() {
+		if !ps.Connected {
+			t.Fatalf("the servers should be connected over quic: %s", ps)
+		}
+	}
+}
+
+// A latencyLink relays traffic to a server's port, over TCP and UDP both,
+// after a one-way delay, to simulate a WAN link. It listens on the same port
+// number for both, like a server does.
+//
+// With some loss, UDP datagrams are dropped, and QUIC has to notice and
+// resend them. TCP segments can't be dropped from user space, so instead a
+// lost segment is held back, along with everything behind it, for as long as
+// TCP takes to resend one: a retransmission timeout, at least 200ms on Linux,
+// plus a round trip.
+type latencyLink struct {
+	tcp    net.Listener
+	udp    *net.UDPConn
+	target string
+	delay  time.Duration
+	loss   float64
+
+	// The upstream conn for each client that sends us datagrams
+	mutex    sync.Mutex
+	upstream map[string]*net.UDPConn
+}
+
+func newLatencyLink(target *Address, delay time.Duration, loss float64) *latencyLink {
+	for {
+		tcp, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			panic(err)
+		}
+		udp, err := net.ListenUDP("udp", &net.UDPAddr{
+			IP: net.ParseIP("127.0.0.1"), Port: tcp.Addr().(*net.TCPAddr).Port})
+		if err != nil {
+			// The udp port is taken, so try another one
+			tcp.Close()
+			continue
+		}
+		link := &latencyLink{
+			tcp:      tcp,
+			udp:      udp,
+			target:   target.String(),
+			delay:    delay,
+			loss:     loss,
+			upstream: make(map[string]*net.UDPConn),
+		}
+		go link.relayTCP()
+		go link.relayUDP()
+		return link
+	}
+}
+
+func (l *latencyLink) address() *Address {
+	return &Address{Host: "127.0.0.1", Port: l.tcp.Addr().(*net.TCPAddr).Port}
+}
+
+func (l *latencyLink) lost() bool {
+	return rand.Float64() < l.loss
+}
+
+func (l *latencyLink) relayTCP() {
+	for {
+		conn, err := l.tcp.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			target, err := net.Dial("tcp", l.target)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			go l.delayCopy(target, conn)
+			go l.delayCopy(conn, target)
+		}()
+	}
+}
+
+// delayCopy copies from src to dst, holding each read back for the delay.
+func (l *latencyLink) delayCopy(dst io.WriteCloser, src io.ReadCloser) {
+	type segment struct {
+		data []byte
+		due  time.Time
+	}
+	segments := make(chan segment, 1000)
+	go func() {
+		defer dst.Close()
+		for s := range segments {
+			time.Sleep(time.Until(s.due))
+			if _, err := dst.Write(s.data); err != nil {
+				return
+			}
+		}
+	}()
+	defer src.Close()
+	buffer := make([]byte, 64*1024)
+	for {
+		n, err := src.Read(buffer)
+		if n > 0 {
+			due := time.Now().Add(l.delay)
+			if l.lost() {
+				due = due.Add(200*time.Millisecond + 2*l.delay)
+			}
+			segments <- segment{data: append([]byte{}, buffer[:n]...), due: due}
+		}
+		if err != nil {
+			close(segments)
+			return
+		}
+	}
+}
+
+// send sends a datagram after the delay, unless it is lost.
+func (l *latencyLink) send(conn *net.UDPConn, data []byte, to *net.UDPAddr) {
+	if l.lost() {
+		return
+	}
+	time.AfterFunc(l.delay, func() {
+		if to == nil {
+			conn.Write(data)
+		} else {
+			conn.WriteToUDP(data, to)
+		}
+	})
+}
+
+func (l *latencyLink) relayUDP() {
+	buffer := make([]byte, 64*1024)
+	for {
+		n, client, err := l.udp.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		data := append([]byte{}, buffer[:n]...)
+		l.mutex.Lock()
+		upstream, ok := l.upstream[client.String()]
+		if !ok {
+			target, err := net.ResolveUDPAddr("udp", l.target)
+			if err == nil {
+				upstream, err = net.DialUDP("udp", nil, target)
+			}
+			if err != nil {
+				l.mutex.Unlock()
+				continue
+			}
+			l.upstream[client.String()] = upstream
+			go l.relayReplies(upstream, client)
+		}
+		l.mutex.Unlock()
+		l.send(upstream, data, nil)
+	}
+}
+
+// relayReplies sends the datagrams that come back from the target to the
+// client they are for.
+func (l *latencyLink) relayReplies(upstream *net.UDPConn, client *net.UDPAddr) {
+	buffer := make([]byte, 64*1024)
+	for {
+		n, err := upstream.Read(buffer)
+		if err != nil {
+			return
+		}
+		l.send(l.udp, append([]byte{}, buffer[:n]...), client)
+	}
+}
+
+func (l *latencyLink) close() {
+	l.tcp.Close()
+	l.udp.Close()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, conn := range l.upstream {
+		conn.Close()
+	}
+}
+
+// benchmarkSlotLatency measures how long it takes to get an operation into a
+// block when the servers talk to each other over links with a 50ms one-way
+// delay and some packet loss. The client talks to its server directly.
+// Stopped servers can linger for a while, so compare the transports by running
+// each benchmark in its own process, like:
+// go test ./network -run xxx -bench SlotLatencyQUIC -benchtime 20x
+func benchmarkSlotLatency(useQUIC bool, loss float64, b *testing.B) {
+	config, kps := NewUnitTestNetwork()
+	config.QUIC = useQUIC
+	links := make(map[string]*latencyLink)
+	for key, address := range config.Servers {
+		links[key] = newLatencyLink(address, 50*time.Millisecond, loss)
+		defer links[key].close()
+	}
+	servers := []*Server{}
+	for _, kp := range kps {
+		// Each server dials the others through their links
+		c := *config
+		c.Servers = make(map[string]*Address)
+		for key, address := range config.Servers {
+			if key == kp.PublicKey().String() {
+				c.Servers[key] = address
+			} else {
+				c.Servers[key] = links[key].address()
+			}
+		}
+		server := NewServer(kp, &c, nil)
+		server.RebroadcastInterval = 4 * time.Second
+		server.ServeInBackground()
+		servers = append(servers, server)
+	}
+	defer stopServers(servers)
+	conn := NewRedialConnection(servers[0].LocalhostAddress(), nil)
+	defer conn.Close()
+
+	mint := util.NewKeyPairFromSecretPhrase("mint")
+	bob := util.NewKeyPairFromSecretPhrase("bob")
+
+	// The first send waits for the servers to connect
+	sendMoney(conn, mint, bob, 1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sendMoney(conn, mint, bob, 1)
+	}
+}
+
+func BenchmarkSlotLatencyTCP(b *testing.B) {
+	benchmarkSlotLatency(false, 0, b)
+}
+
+func BenchmarkSlotLatencyQUIC(b *testing.B) {
+	benchmarkSlotLatency(true, 0, b)
+}
+
+func BenchmarkSlotLatencyLossyTCP(b *testing.B) {
+	benchmarkSlotLatency(false, 0.02, b)
+}
+
+func BenchmarkSlotLatencyLossyQUIC(b *testing.B) {
+	benchmarkSlotLatency(true, 0.02, b)
+}
+
+// The consensus stream only carries consensus messages, until a message in
+// the batch has to go on the bulk stream.
+func TestQUICBroadcastSplitsStreams(t *testing.T) {
+	s, kps := newIdleServer(t, 2)
+	defer s.Stop()
+	s.peers[0].Close()
+
+	// Nothing sends what these connections queue up, so it can be counted
+	bulk := make(chan *util.SignedMessage, 10)
+	consensusStream := make(chan *util.SignedMessage, 10)
+	s.peers = []*RedialConnection{{outbox: bulk, quit: make(chan bool)}}
+	s.consensusPeers = []*RedialConnection{{outbox: consensusStream, quit: make(chan bool)}}
+
+	mint := util.NewKeyPairFromSecretPhrase("mint")
+	bob := util.NewKeyPairFromSecretPhrase("bob")
+	nomination := util.NewSignedMessage(
+		&consensus.NominationMessage{I: 1, D: s.config.QuorumSlice()}, kps[0])
+	send := util.NewSignedMessage(newSendMessage(mint, bob, 1, 1), kps[0])
+	s.broadcast([]*util.SignedMessage{nomination, send, nomination})
+	if len(consensusStream) != 1 || len(bulk) != 2 {
+		t.Fatalf("expected 1 consensus and 2 bulk messages but got %d and %d",
+			len(consensusStream), len(bulk))
+	}
+}
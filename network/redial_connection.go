@@ -3,8 +3,11 @@ package network
 import (
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"golang.org/x/net/proxy"
+
 	"github.com/lacker/coinkit/util"
 )
 
@@ -21,10 +24,38 @@ type RedialConnection struct {
 	quit     chan bool
 	closed   bool
 	quitOnce sync.Once
+
+	// How long the last successful dial took, in nanoseconds.
+	// Accessed atomically
+	latency int64
+
+	// When keyPair is set, the connection is encrypted with Noise. When remote
+	// is also set, the other side must prove that it has that public key.
+	keyPair util.Signer
+	remote  string
+
+	// The proxy to dial through, or nil to dial directly
+	dialer proxy.Dialer
 }
 
 func NewRedialConnection(address *Address,
 	inbox chan *util.SignedMessage) *RedialConnection {
+	return newRedialConnection(address, inbox, nil, "", nil)
+}
+
+// NewNoiseRedialConnection is like NewRedialConnection, but each connection
+// is encrypted with Noise, authenticated as keyPair. If remote is not empty,
+// the other side must prove that it has that public key.
+func NewNoiseRedialConnection(address *Address, inbox chan *util.SignedMessage,
+	keyPair util.Signer, remote string) *RedialConnection {
+	if keyPair == nil {
+		panic("keyPair is nil")
+	}
+	return newRedialConnection(address, inbox, keyPair, remote, nil)
+}
+
+func newRedialConnection(address *Address, inbox chan *util.SignedMessage,
+	keyPair util.Signer, remote string, dialer proxy.Dialer) *RedialConnection {
 	if address == nil {
 		panic("address is nil")
 	}
@@ -37,6 +68,9 @@ func NewRedialConnection(address *Address,
 		inbox:   inbox,
 		quit:    make(chan bool),
 		closed:  false,
+		keyPair: keyPair,
+		remote:  remote,
+		dialer:  dialer,
 	}
 	go c.runOutgoing()
 	return c
@@ -52,6 +86,14 @@ func (c *RedialConnection) Close() {
 	})
 }
 
+// Redial closes this connection and returns a new one to the same address,
+// set up the same way. The new one always has its own inbox, so nothing that
+// arrives over this one can be read from it.
+func (c *RedialConnection) Redial() Connection {
+	c.Close()
+	return newRedialConnection(c.address, nil, c.keyPair, c.remote, c.dialer)
+}
+
 func (c *RedialConnection) IsClosed() bool {
 	return c.closed
 }
@@ -60,6 +102,34 @@ func (c *RedialConnection) IsConnected() bool {
 	return c.conn != nil && !c.conn.IsClosed()
 }
 
+// Latency returns how long it took to dial the last time we connected, which
+// is roughly one round trip. It is zero if we have never connected.
+func (c *RedialConnection) Latency() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.latency))
+}
+
+func (c *RedialConnection) dial() (net.Conn, error) {
+	if c.dialer == nil {
+		return net.Dial("tcp", c.address.String())
+	}
+	return c.dialer.Dial("tcp", c.address.proxied())
+}
+
+// handshake sets up encryption on a new connection, if we are using it.
+// If the handshake fails, the connection is closed.
+func (c *RedialConnection) handshake(conn net.Conn) (net.Conn, error) {
+	if c.keyPair == nil {
+		return conn, nil
+	}
+	secure, err := DialNoise(conn, c.keyPair, c.remote)
+	if err != nil {
+		util.Logger.Printf("noise handshake with %s failed: %s", c.address, err)
+		conn.Close()
+		return nil, err
+	}
+	return secure, nil
+}
+
 // connect() is not threadsafe and should only be called from the
 // runOutgoing thread
 func (c *RedialConnection) connect() {
@@ -73,7 +143,12 @@ func (c *RedialConnection) connect() {
 	}
 	failCount := 0
 	for {
-		conn, err := net.Dial("tcp", c.address.String())
+		start := time.Now()
+		conn, err := c.dial()
+		if err == nil {
+			atomic.StoreInt64(&c.latency, int64(time.Since(start)))
+			conn, err = c.handshake(conn)
+		}
 		if err == nil {
 			c.conn = NewBasicConnection(conn, c.inbox)
 			return
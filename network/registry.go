@@ -0,0 +1,152 @@
+package network
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/util"
+)
+
+// A NamedNetwork is a network that clients and servers know by name, so
+// users can say "testnet" instead of passing around a network config.
+type NamedNetwork struct {
+	Name string
+
+	// ID is the network ID of the network's genesis. See Config.NetworkID.
+	// It is empty for a network that hasn't launched yet.
+	ID string
+
+	// The address prefix and decimals the network uses
+	AddressPrefix string
+	Decimals      int
+
+	// Seeds maps the public keys of the genesis validators to their addresses.
+	Seeds map[string]*Address
+
+	// How many of the seeds make a quorum
+	Threshold int
+}
+
+// The networks that are built in
+const (
+	Mainnet = "mainnet"
+	Testnet = "testnet"
+	Local   = "local"
+)
+
+var registry = map[string]*NamedNetwork{
+	Mainnet: {
+		Name:          Mainnet,
+		AddressPrefix: util.DefaultAddressPrefix,
+		Decimals:      currency.Decimals,
+	},
+	Testnet: {
+		Name:          Testnet,
+		AddressPrefix: "tcoin",
+		Decimals:      currency.Decimals,
+	},
+}
+
+func init() {
+	local := NewLocalNetworkConfig()
+	registry[Local] = &NamedNetwork{
+		Name:          Local,
+		ID:            local.NetworkID(),
+		AddressPrefix: util.DefaultAddressPrefix,
+		Decimals:      local.Decimals,
+		Seeds:         local.Servers,
+		Threshold:     local.Threshold,
+	}
+}
+
+// NetworkNames returns the names of the built-in networks, sorted.
+func NetworkNames() []string {
+	answer := []string{}
+	for name := range registry {
+		answer = append(answer, name)
+	}
+	sort.Strings(answer)
+	return answer
+}
+
+// LookupNetwork finds a built-in network by name.
+func LookupNetwork(name string) (*NamedNetwork, error) {
+	n, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown network %q. the known networks are %s",
+			name, strings.Join(NetworkNames(), ", "))
+	}
+	return n, nil
+}
+
+// Config returns a network config for talking to the network's seeds.
+// It fails for a network that hasn't launched, since there is nothing to
+// talk to yet.
+func (n *NamedNetwork) Config() (*Config, error) {
+	if len(n.Seeds) == 0 {
+		return nil, fmt.Errorf("the %s network has not launched yet", n.Name)
+	}
+	c := &Config{
+		Name:          n.Name,
+		Servers:       make(map[string]*Address),
+		Threshold:     n.Threshold,
+		Decimals:      n.Decimals,
+		AddressPrefix: n.AddressPrefix,
+	}
+	for key, address := range n.Seeds {
+		copied := *address
+		c.Servers[key] = &copied
+	}
+	return c, nil
+}
+
+// NetworkID identifies the genesis of a network: the validators it starts
+// with, its threshold, the mint, and the reserve, fee distribution and
+// emission if there are any. Servers and clients with the same network ID
+// are on the same chain. Addresses don't affect it, so servers can move
+// without changing the network ID.
+func (c *Config) NetworkID() string {
+	mint := util.NewKeyPairFromSecretPhrase("mint")
+	qs := c.QuorumSlice()
+	genesis := fmt.Sprintf("%s:%d:%s:%d", strings.Join(qs.Members, ","), qs.Threshold,
+		mint.PublicKey().String(), currency.TotalMoney)
+	if c.Reserve > 0 {
+		genesis += fmt.Sprintf(":%d", c.Reserve)
+	}
+	if c.FeePool != "" {
+		genesis += ":fees=" + c.FeePool
+	}
+	if c.FeesToValidators {
+		genesis += ":fees=validators"
+	}
+	if c.Emission != nil {
+		genesis += ":emission=" + c.Emission.String()
+	}
+	h := sha512.Sum512_256([]byte(genesis))
+	return base64.RawURLEncoding.EncodeToString(h[:12])
+}
+
+// CheckName checks that a config that names its network really has the
+// genesis of that network, so a node can't join a network it wasn't set up
+// for by mistake. A config without a name can be for any network.
+func (c *Config) CheckName() error {
+	if c.Name == "" {
+		return nil
+	}
+	n, err := LookupNetwork(c.Name)
+	if err != nil {
+		return err
+	}
+	if n.ID == "" {
+		return fmt.Errorf("the %s network has not launched yet", n.Name)
+	}
+	if id := c.NetworkID(); id != n.ID {
+		return fmt.Errorf("the network ID is %s but the %s network ID is %s",
+			id, n.Name, n.ID)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package network
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNamedNetworks(t *testing.T) {
+	local, err := LookupNetwork(Local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := local.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.CheckName(); err != nil {
+		t.Fatal(err)
+	}
+	if config.NetworkID() != NewLocalNetworkConfig().NetworkID() {
+		t.Fatalf("the local network should be the default local config")
+	}
+
+	// Moving a server doesn't change the genesis, but changing the validators does
+	for _, address := range config.Servers {
+		address.Port++
+	}
+	if err := config.CheckName(); err != nil {
+		t.Fatal(err)
+	}
+	fresh, _ := local.Config()
+	for key, address := range fresh.Servers {
+		if address.Port == config.Servers[key].Port {
+			t.Fatalf("changing a config should not change the registry")
+		}
+	}
+	config.Reserve = 1000
+	if err := config.CheckName(); err == nil || !strings.Contains(err.Error(), "network ID") {
+		t.Fatalf("the reserve should be part of the genesis, but got: %v", err)
+	}
+	config.Reserve = 0
+	config.Threshold = 4
+	if err := config.CheckName(); err == nil || !strings.Contains(err.Error(), "network ID") {
+		t.Fatalf("expected a network ID mismatch but got: %v", err)
+	}
+
+	testnet, err := LookupNetwork(Testnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testnet.Config(); err == nil {
+		t.Fatalf("the testnet has no seeds yet")
+	}
+	config.Name = Testnet
+	if err := config.CheckName(); err == nil {
+		t.Fatalf("a network that hasn't launched has no genesis to match")
+	}
+	if _, err := LookupNetwork("bogus"); err == nil {
+		t.Fatalf("bogus is not a network")
+	}
+}
@@ -0,0 +1,500 @@
+package network
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/util"
+)
+
+// A validator can keep its key pair in a separate signer process, and sign
+// everything over a socket, so the key is never on the host that talks to
+// the network. "cserver signer" runs one.
+//
+// The protocol is JSON lines. Both sides share a secret, and each
+// connection starts with both sides sending a signerHello with a random
+// nonce. Every line after that is a signerRequest or a signerResponse with
+// an HMAC-SHA256 keyed by the secret and both nonces, over which way it is
+// going and the rest of the line. Sequence numbers go up by one with each
+// request, so a line can't be forged, replayed, or moved to another
+// connection.
+
+// How long the remote signer has to answer a request. Once it stops
+// answering, it gets signerGiveUp in total, across every request, before the
+// validator exits. Messages are signed off the processing goroutine, so an
+// outage shorter than that, like a blip on the network to a TCP signer, only
+// delays them. VRF proofs, checkpoints, and promotion votes are still signed
+// on the processing goroutine, so while the signer is working on a request
+// the server drops requests it can't answer in time, instead of deciding it
+// is overloaded.
+const signerTimeout = time.Second
+const signerGiveUp = 30 * time.Second
+
+// The kinds of signer request
+const signerPublicKey = "publicKey"
+const signerSign = "sign"
+const signerVRF = "vrf"
+
+type signerHello struct {
+	Nonce string
+}
+
+type signerRequest struct {
+	Seq  int
+	Kind string
+
+	// The message for signerSign, or the base64 alpha for signerVRF
+	Data string `json:",omitempty"`
+
+	MAC string `json:",omitempty"`
+}
+
+type signerResponse struct {
+	Seq       int
+	PublicKey string `json:",omitempty"`
+	Signature string `json:",omitempty"`
+	Proof     string `json:",omitempty"`
+	Error     string `json:",omitempty"`
+	MAC       string `json:",omitempty"`
+}
+
+// signerSession is one side of an authenticated signer connection.
+type signerSession struct {
+	conn    net.Conn
+	scanner *bufio.Scanner
+	key     []byte
+	seq     int
+}
+
+// SignerAddress splits a signer address into the network and address to
+// dial. "unix:/path" is a unix socket, and anything else is a TCP address.
+func SignerAddress(address string) (string, string) {
+	if strings.HasPrefix(address, "unix:") {
+		return "unix", strings.TrimPrefix(address, "unix:")
+	}
+	return "tcp", address
+}
+
+// newSignerSession trades nonces over conn and derives the session key.
+// The client's nonce comes first in the key either way.
+func newSignerSession(conn net.Conn, secret []byte, client bool) (*signerSession, error) {
+	s := &signerSession{conn: conn, scanner: bufio.NewScanner(conn)}
+	s.scanner.Buffer(make([]byte, 4096), util.MaxLineSize)
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	ours := base64.RawStdEncoding.EncodeToString(nonce)
+	if err := s.write(&signerHello{Nonce: ours}); err != nil {
+		return nil, err
+	}
+	hello := &signerHello{}
+	if err := s.read(hello); err != nil {
+		return nil, err
+	}
+	theirs, err := base64.RawStdEncoding.DecodeString(hello.Nonce)
+	if err != nil || len(theirs) != len(nonce) {
+		return nil, errors.New("bad signer hello")
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("coinkit signer\n"))
+	if client {
+		mac.Write(nonce)
+		mac.Write(theirs)
+	} else {
+		mac.Write(theirs)
+		mac.Write(nonce)
+	}
+	s.key = mac.Sum(nil)
+	return s, nil
+}
+
+func (s *signerSession) write(v interface{}) error {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	_, err = s.conn.Write(append(bytes, '\n'))
+	return err
+}
+
+func (s *signerSession) read(v interface{}) error {
+	if !s.scanner.Scan() {
+		if s.scanner.Err() != nil {
+			return s.scanner.Err()
+		}
+		return io.EOF
+	}
+	return json.Unmarshal(s.scanner.Bytes(), v)
+}
+
+// mac authenticates a request or response, which has its MAC field empty.
+func (s *signerSession) mac(direction string, v interface{}) string {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(direction + "\n"))
+	mac.Write(bytes)
+	return base64.RawStdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// checkMAC checks the MAC that was taken out of a request or response.
+func (s *signerSession) checkMAC(direction string, v interface{}, mac string) bool {
+	return hmac.Equal([]byte(s.mac(direction, v)), []byte(mac))
+}
+
+// What a checkpoint signature and a Noise handshake sign. See
+// CheckpointMessage.Digest and noiseIdentity.
+var checkpointDigest = regexp.MustCompile(`^checkpoint [0-9]+ [A-Za-z0-9+/]+$`)
+var noiseStaticKey = regexp.MustCompile("^" + noiseStaticKeyPrefix + "[0-9a-f]{64}$")
+
+// checkSignable returns an error unless data is something a validator signs:
+// a message, a checkpoint, a Noise static key, or a vote to change the
+// validators that pays no fee. Anything else, like an operation that moves
+// money or a signed text, is refused, so whoever takes over the validator's
+// host can't use the signer to spend its money or prove it owns an address.
+func checkSignable(data string) error {
+	if checkpointDigest.MatchString(data) || noiseStaticKey.MatchString(data) {
+		return nil
+	}
+
+	// A message is signed with its timestamp in front
+	if i := strings.IndexByte(data, ':'); i > 0 {
+		if _, err := strconv.ParseInt(data[:i], 10, 64); err == nil {
+			if _, err := util.DecodeMessage(data[i+1:]); err != nil {
+				return fmt.Errorf("refusing to sign a bad message: %s", err)
+			}
+			return nil
+		}
+	}
+
+	// An operation is signed with its type in front. The only ones a
+	// validator signs are votes for promotions.
+	vote := &currency.ValidatorOperation{}
+	prefix := vote.OperationType()
+	if strings.HasPrefix(data, prefix+"{") {
+		if err := json.Unmarshal([]byte(data[len(prefix):]), vote); err != nil {
+			return fmt.Errorf("refusing to sign a bad validator vote: %s", err)
+		}
+		encoded, err := json.Marshal(vote)
+		if err != nil {
+			panic(err)
+		}
+		canonical, err := util.CanonicalJSON(encoded)
+		if err != nil || prefix+string(canonical) != data {
+			return errors.New("refusing to sign a validator vote that is not canonical")
+		}
+		if vote.Fee != 0 {
+			return errors.New("refusing to sign a validator vote with a fee")
+		}
+		return nil
+	}
+	return errors.New("refusing to sign something other than a message, checkpoint, " +
+		"noise key, or validator vote")
+}
+
+// ServeSigner signs for signer on every connection to listener that knows
+// the secret, until listener closes.
+func ServeSigner(listener net.Listener, signer util.Signer, secret []byte) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if err := serveSignerConn(conn, signer, secret); err != nil {
+				util.Logger.Printf("signer connection from %s ended: %s", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+func serveSignerConn(conn net.Conn, signer util.Signer, secret []byte) error {
+	conn.SetDeadline(time.Now().Add(signerTimeout))
+	s, err := newSignerSession(conn, secret, false)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+	for {
+		request := &signerRequest{}
+		if err := s.read(request); err != nil {
+			return err
+		}
+		mac := request.MAC
+		request.MAC = ""
+		if !s.checkMAC("request", request, mac) {
+			return errors.New("a request failed authentication")
+		}
+		if request.Seq != s.seq+1 {
+			return fmt.Errorf("expected request %d but got %d", s.seq+1, request.Seq)
+		}
+		s.seq = request.Seq
+
+		response := &signerResponse{Seq: request.Seq}
+		switch request.Kind {
+		case signerPublicKey:
+			response.PublicKey = signer.PublicKey().String()
+		case signerSign:
+			if err := checkSignable(request.Data); err != nil {
+				response.Error = err.Error()
+				break
+			}
+			response.Signature = signer.Sign(request.Data)
+		case signerVRF:
+			alpha, err := base64.RawStdEncoding.DecodeString(request.Data)
+			if err != nil {
+				response.Error = "bad vrf input"
+				break
+			}
+			// The client gets the output from verifying the proof
+			proof, _ := signer.ProveVRF(alpha)
+			response.Proof = base64.RawStdEncoding.EncodeToString(proof)
+		default:
+			response.Error = fmt.Sprintf("unknown request kind %q", request.Kind)
+		}
+		response.MAC = s.mac("response", response)
+		conn.SetWriteDeadline(time.Now().Add(signerTimeout))
+		if err := s.write(response); err != nil {
+			return err
+		}
+	}
+}
+
+// A RemoteSigner is a util.Signer that asks a signer process to sign.
+// It redials when the connection drops. It is threadsafe.
+type RemoteSigner struct {
+	address   string
+	secret    []byte
+	publicKey util.PublicKey
+
+	// signerTimeout and signerGiveUp, except in tests
+	timeout time.Duration
+	giveUp  time.Duration
+
+	// How many requests are waiting on the signer. Only used atomically
+	pending int32
+
+	mu      sync.Mutex
+	session *signerSession
+
+	// When the signer stopped answering. Zero while it answers
+	down time.Time
+}
+
+// NewRemoteSigner connects to the signer at address. See SignerAddress.
+func NewRemoteSigner(address string, secret []byte) (*RemoteSigner, error) {
+	r := &RemoteSigner{
+		address: address,
+		secret:  secret,
+		timeout: signerTimeout,
+		giveUp:  signerGiveUp,
+	}
+	response, err := r.request(&signerRequest{Kind: signerPublicKey},
+		time.Now().Add(signerTimeout))
+	if err != nil {
+		return nil, err
+	}
+	pk, err := util.ReadPublicKey(response.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("the signer sent a bad public key: %s", err)
+	}
+	r.publicKey = pk
+	return r, nil
+}
+
+// request sends one request to the signer, dialing it if need be, and
+// gives up at the deadline. The connection is dropped on any error, so the
+// next request starts fresh.
+func (r *RemoteSigner) request(request *signerRequest,
+	deadline time.Time) (*signerResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.session == nil {
+		network, address := SignerAddress(r.address)
+		conn, err := net.DialTimeout(network, address, time.Until(deadline))
+		if err != nil {
+			return nil, err
+		}
+		conn.SetDeadline(deadline)
+		s, err := newSignerSession(conn, r.secret, true)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		r.session = s
+	}
+	s := r.session
+	response, err := r.exchange(s, request, deadline)
+	if err != nil {
+		s.conn.Close()
+		r.session = nil
+		return nil, err
+	}
+	return response, nil
+}
+
+func (r *RemoteSigner) exchange(s *signerSession, request *signerRequest,
+	deadline time.Time) (*signerResponse, error) {
+	s.conn.SetDeadline(deadline)
+	s.seq++
+	request.Seq = s.seq
+	request.MAC = ""
+	request.MAC = s.mac("request", request)
+	if err := s.write(request); err != nil {
+		return nil, err
+	}
+	response := &signerResponse{}
+	if err := s.read(response); err != nil {
+		return nil, err
+	}
+	mac := response.MAC
+	response.MAC = ""
+	if !s.checkMAC("response", response, mac) || response.Seq != request.Seq {
+		return nil, errors.New("the signer's response failed authentication")
+	}
+	if response.Error != "" {
+		return nil, errors.New(response.Error)
+	}
+	return response, nil
+}
+
+// outage returns when the signer stopped answering, or start if it has been
+// answering, and marks it as down if failed is set.
+func (r *RemoteSigner) outage(start time.Time, failed bool) time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.down.IsZero() {
+		if !failed {
+			return start
+		}
+		r.down = start
+	}
+	return r.down
+}
+
+func (r *RemoteSigner) answered() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.down = time.Time{}
+}
+
+// retry retries a request until it works, or until the signer has been
+// down for signerGiveUp. A request always gets at least one try.
+func (r *RemoteSigner) retry(request *signerRequest,
+	check func(*signerResponse) error) (*signerResponse, error) {
+	atomic.AddInt32(&r.pending, 1)
+	defer atomic.AddInt32(&r.pending, -1)
+	start := time.Now()
+	backoff := 10 * time.Millisecond
+	for {
+		deadline := time.Now().Add(r.timeout)
+		giveUp := r.outage(start, false).Add(r.giveUp)
+		if giveUp.After(time.Now()) && giveUp.Before(deadline) {
+			deadline = giveUp
+		}
+		response, err := r.request(request, deadline)
+		if err == nil {
+			err = check(response)
+			if err == nil {
+				r.answered()
+				return response, nil
+			}
+		}
+		if !time.Now().Add(backoff).Before(r.outage(start, true).Add(r.giveUp)) {
+			return nil, err
+		}
+		util.Logger.Printf("remote signer request failed, retrying: %s", err)
+		time.Sleep(backoff)
+		if backoff < r.timeout {
+			backoff *= 2
+		}
+	}
+}
+
+// waiting returns whether any request is waiting on the signer.
+func (r *RemoteSigner) waiting() bool {
+	return atomic.LoadInt32(&r.pending) > 0
+}
+
+// mustRequest retries a request until it works, and exits if the signer
+// has been down for signerGiveUp.
+func (r *RemoteSigner) mustRequest(request *signerRequest,
+	check func(*signerResponse) error) *signerResponse {
+	response, err := r.retry(request, check)
+	if err != nil {
+		util.Logger.Fatalf("giving up on the remote signer at %s: %s", r.address, err)
+	}
+	return response
+}
+
+func (r *RemoteSigner) PublicKey() util.PublicKey {
+	return r.publicKey
+}
+
+// Sign asks the signer to sign, and checks the signature, so a signer with
+// the wrong key can't make this validator send bad messages.
+func (r *RemoteSigner) Sign(message string) string {
+	response := r.mustRequest(&signerRequest{Kind: signerSign, Data: message},
+		func(response *signerResponse) error {
+			if !util.VerifySignature(r.publicKey, message, response.Signature) {
+				return errors.New("the signer sent a bad signature")
+			}
+			return nil
+		})
+	return response.Signature
+}
+
+func (r *RemoteSigner) ProveVRF(alpha []byte) ([]byte, []byte) {
+	var proof, output []byte
+	r.mustRequest(&signerRequest{Kind: signerVRF, Data: base64.RawStdEncoding.EncodeToString(alpha)},
+		func(response *signerResponse) error {
+			var err error
+			proof, err = base64.RawStdEncoding.DecodeString(response.Proof)
+			if err != nil {
+				return err
+			}
+			var ok bool
+			output, ok = util.VerifyVRF(r.publicKey, alpha, proof)
+			if !ok {
+				return errors.New("the signer sent a bad VRF proof")
+			}
+			return nil
+		})
+	return proof, output
+}
+
+// waitingOnSigner returns whether the server's remote signer is working on a
+// request, which can hold up the processing goroutine.
+func (s *Server) waitingOnSigner() bool {
+	r, ok := s.keyPair.(*RemoteSigner)
+	return ok && r.waiting()
+}
+
+// Close drops the connection to the signer.
+func (r *RemoteSigner) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.session != nil {
+		r.session.conn.Close()
+		r.session = nil
+	}
+}
@@ -0,0 +1,259 @@
+package network
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lacker/coinkit/consensus"
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/util"
+)
+
+func listenSigner(t *testing.T, kp util.Signer, secret string) (string, func()) {
+	dir, err := ioutil.TempDir("", "signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := "unix:" + filepath.Join(dir, "signer.sock")
+	listener, err := net.Listen(SignerAddress(address))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ServeSigner(listener, kp, []byte(secret))
+	return address, func() {
+		listener.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRemoteSigner(t *testing.T) {
+	kp := util.NewKeyPairFromSecretPhrase("validator")
+	address, stop := listenSigner(t, kp, "shared secret")
+	defer stop()
+
+	if _, err := NewRemoteSigner(address, []byte("wrong secret")); err == nil {
+		t.Fatal("a signer with a different secret should not work")
+	}
+	r, err := NewRemoteSigner(address, []byte("shared secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if r.PublicKey() != kp.PublicKey() {
+		t.Fatalf("got public key %s", r.PublicKey())
+	}
+
+	m := &util.InfoMessage{Account: "bob"}
+	sm := util.NewSignedMessage(m, r)
+	if _, err := util.NewSignedMessageFromSerialized(sm.Serialize()); err != nil {
+		t.Fatalf("the remotely signed message is invalid: %s", err)
+	}
+
+	alpha := []byte("slot 7")
+	proof, output := r.ProveVRF(alpha)
+	localProof, localOutput := kp.ProveVRF(alpha)
+	if !bytes.Equal(proof, localProof) || !bytes.Equal(output, localOutput) {
+		t.Fatal("the remote VRF proof should match the local one")
+	}
+
+	// A dropped connection gets redialed
+	r.session.conn.Close()
+	digest := (&CheckpointMessage{I: 10, Root: "root"}).Digest()
+	if !util.VerifySignature(kp.PublicKey(), digest, r.Sign(digest)) {
+		t.Fatal("bad signature after redialing")
+	}
+}
+
+func TestSignerRejectsReplays(t *testing.T) {
+	kp := util.NewKeyPairFromSecretPhrase("validator")
+	address, stop := listenSigner(t, kp, "shared secret")
+	defer stop()
+	r, err := NewRemoteSigner(address, []byte("shared secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// Sending the same sequence number twice closes the connection
+	digest := (&CheckpointMessage{I: 10, Root: "root"}).Digest()
+	r.Sign(digest)
+	r.session.seq--
+	if _, err := r.request(&signerRequest{Kind: signerSign, Data: digest},
+		time.Now().Add(signerTimeout)); err == nil {
+		t.Fatal("a replayed sequence number should be rejected")
+	}
+}
+
+// recordingSigner remembers the last thing it signed.
+type recordingSigner struct {
+	*util.KeyPair
+	signed string
+}
+
+func (s *recordingSigner) Sign(message string) string {
+	s.signed = message
+	return s.KeyPair.Sign(message)
+}
+
+func TestSignerRefusesOperations(t *testing.T) {
+	kp := util.NewKeyPairFromSecretPhrase("validator")
+	address, stop := listenSigner(t, kp, "shared secret")
+	defer stop()
+	r, err := NewRemoteSigner(address, []byte("shared secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	sign := func(data string) error {
+		_, err := r.request(&signerRequest{Kind: signerSign, Data: data},
+			time.Now().Add(signerTimeout))
+		return err
+	}
+
+	// Only the validator's own key could sign these, so record what it signs
+	recorder := &recordingSigner{KeyPair: kp}
+	self := kp.PublicKey().String()
+	bob := util.NewKeyPairFromSecretPhrase("bob").PublicKey().String()
+	util.NewSignedOperation(&currency.SendOperation{
+		Signer: self, Sequence: 1, To: bob, Amount: 100}, recorder)
+	if err := sign(recorder.signed); err == nil {
+		t.Fatal("a send should be refused")
+	}
+	util.SignText("I own this address", recorder)
+	if err := sign(recorder.signed); err == nil {
+		t.Fatal("a signed text should be refused")
+	}
+	vote := &currency.ValidatorOperation{
+		Signer:      self,
+		Sequence:    1,
+		Action:      currency.ValidatorReplace,
+		Validator:   bob,
+		Replacement: self,
+		Threshold:   3,
+		Activation:  10,
+		Fee:         100,
+	}
+	util.NewSignedOperation(vote, recorder)
+	if err := sign(recorder.signed); err == nil {
+		t.Fatal("a vote that pays a fee should be refused")
+	}
+	if err := sign("anything else"); err == nil {
+		t.Fatal("arbitrary text should be refused")
+	}
+
+	// What a validator does sign still works
+	vote.Fee = 0
+	if !util.NewSignedOperation(vote, r).Verify() {
+		t.Fatal("a vote without a fee should be signed")
+	}
+	sm := util.NewSignedMessage(&consensus.NominationMessage{I: 1}, r)
+	if _, err := util.NewSignedMessageFromSerialized(sm.Serialize()); err != nil {
+		t.Fatalf("a message should be signed: %s", err)
+	}
+}
+
+// slowSigner takes delay nanoseconds to sign.
+type slowSigner struct {
+	*util.KeyPair
+	delay int64
+}
+
+func (s *slowSigner) Sign(message string) string {
+	time.Sleep(time.Duration(atomic.LoadInt64(&s.delay)))
+	return s.KeyPair.Sign(message)
+}
+
+func TestSlowSigner(t *testing.T) {
+	slow := &slowSigner{KeyPair: util.NewKeyPairFromSecretPhrase("validator")}
+	address, stop := listenSigner(t, slow, "shared secret")
+	defer stop()
+	r, err := NewRemoteSigner(address, []byte("shared secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// The real limits would make this test take a minute
+	r.timeout = 100 * time.Millisecond
+	r.giveUp = 500 * time.Millisecond
+	digest := (&CheckpointMessage{I: 10, Root: "root"}).Digest()
+	sign := func() (time.Duration, error) {
+		start := time.Now()
+		_, err := r.retry(&signerRequest{Kind: signerSign, Data: digest},
+			func(*signerResponse) error { return nil })
+		return time.Since(start), err
+	}
+
+	// A signer that is a little slow still works
+	atomic.StoreInt64(&slow.delay, int64(r.timeout/4))
+	if _, err := sign(); err != nil {
+		t.Fatal(err)
+	}
+
+	// One that is too slow gets given up on once it has been down for the
+	// give-up window, even across several requests
+	atomic.StoreInt64(&slow.delay, int64(time.Second))
+	total := time.Duration(0)
+	for i := 0; i < 3; i++ {
+		elapsed, err := sign()
+		if err == nil {
+			t.Fatal("a signer that never answers in time should fail")
+		}
+		total += elapsed
+	}
+	if total >= 2*r.giveUp {
+		t.Fatalf("waited %s on a slow signer", total)
+	}
+
+	// Once it speeds up again, it works
+	atomic.StoreInt64(&slow.delay, 0)
+	if _, err := sign(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sign(); err != nil || !r.down.IsZero() {
+		t.Fatalf("the signer should be back up: %v", err)
+	}
+}
+
+func TestSlowSignerDoesNotStallProcessing(t *testing.T) {
+	config, kps := NewLocalhostNetwork(9000, 1, 0)
+	slow := &slowSigner{KeyPair: kps[0]}
+	address, stop := listenSigner(t, slow, "shared secret")
+	defer stop()
+	r, err := NewRemoteSigner(address, []byte("shared secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	s := NewServer(r, config, nil)
+	defer s.Stop()
+
+	// The processing goroutine answers without signing
+	atomic.StoreInt64(&slow.delay, int64(time.Second))
+	start := time.Now()
+	query := util.NewSignedMessage(&PeersMessage{}, util.NewKeyPair())
+	if s.unsafeProcessMessage(query) == nil {
+		t.Fatal("expected an answer to the peers query")
+	}
+	s.unsafeUpdateOutgoing()
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Fatalf("processing waited %s on the signer", elapsed)
+	}
+
+	// When the processing goroutine is stuck on the signer, requests get
+	// dropped instead of the server exiting
+	atomic.StoreInt32(&r.pending, 1)
+	defer atomic.StoreInt32(&r.pending, 0)
+	go func() {
+		<-s.requests
+	}()
+	if _, ok := s.handleMessageOnce(query); ok {
+		t.Fatal("the request should have been dropped")
+	}
+}
@@ -0,0 +1,47 @@
+package network
+
+import (
+	"time"
+
+	"github.com/lacker/coinkit/util"
+)
+
+// Every signed message carries the time it was signed, and servers drop
+// messages signed too far from their own clock. So traffic captured off the
+// wire can only be replayed for a short while, even to a server that has
+// since restarted or reconnected. Within the window, replays are harmless
+// because handling the same message twice doesn't change anything.
+// Servers re-sign their messages when rebroadcasting, so a server that has
+// been stuck for a while still gets its old messages accepted.
+
+// DefaultReplayWindow is how far a message's timestamp can be from our clock.
+// It has to cover the clock skew between machines.
+const DefaultReplayWindow = time.Minute
+
+// How long the server keeps sending an outgoing message with the same
+// signature, when its content doesn't change. It is well inside the replay
+// window, so receivers don't drop the message before it is replaced.
+const signatureReuse = DefaultReplayWindow / 4
+
+// inWindow returns whether a message was signed within the replay window of
+// now. Keepalives have no timestamp and are always fine.
+func (s *Server) inWindow(sm *util.SignedMessage, now time.Time) bool {
+	if sm.IsKeepAlive() {
+		return true
+	}
+	skew := now.Sub(sm.Timestamp())
+	if skew <= s.ReplayWindow && skew >= -s.ReplayWindow {
+		return true
+	}
+	s.peerTracker.replayed(sm.Signer())
+	return false
+}
+
+// restamp signs the messages again, so they have a fresh timestamp.
+func (s *Server) restamp(messages []*util.SignedMessage) []*util.SignedMessage {
+	answer := []*util.SignedMessage{}
+	for _, m := range messages {
+		answer = append(answer, util.NewSignedMessage(m.Message(), s.keyPair))
+	}
+	return answer
+}
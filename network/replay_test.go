@@ -0,0 +1,50 @@
+package network
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lacker/coinkit/util"
+)
+
+func TestReplayWindow(t *testing.T) {
+	s, kps := newIdleServer(t, 3)
+	defer s.Stop()
+
+	sm := util.NewSignedMessage(&PromotionMessage{I: 1}, kps[1])
+	now := time.Now()
+	if !s.inWindow(sm, now) || !s.inWindow(util.KeepAlive(), now) {
+		t.Fatal("fresh messages should be in the window")
+	}
+	if s.inWindow(sm, now.Add(2*DefaultReplayWindow)) {
+		t.Fatal("an old message should be a replay")
+	}
+	if s.inWindow(sm, now.Add(-2*DefaultReplayWindow)) {
+		t.Fatal("a message from the future should be a replay")
+	}
+	for _, ps := range s.PeerStats() {
+		if ps.PublicKey == kps[1].PublicKey().String() && ps.Replays != 2 {
+			t.Fatalf("expected two replays: %s", ps)
+		}
+	}
+
+	// Rebroadcasts get a new signature but count as the same message
+	restamped := s.restamp([]*util.SignedMessage{sm})
+	if restamped[0].Signer() != kps[0].PublicKey().String() ||
+		len(subtract(restamped, []*util.SignedMessage{sm})) != 0 {
+		t.Fatal("restamping should only change the signature")
+	}
+
+	// A connection that replays a message gets dropped
+	s.ReplayWindow = time.Millisecond
+	time.Sleep(10 * time.Millisecond)
+	server, client := net.Pipe()
+	go s.handleConnection(server)
+	client.SetDeadline(time.Now().Add(10 * time.Second))
+	go sm.Write(client)
+	if _, err := bufio.NewReader(client).ReadString('\n'); err == nil {
+		t.Fatal("expected the connection to be closed")
+	}
+}
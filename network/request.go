@@ -12,7 +12,25 @@ import (
 type Request struct {
 	Message *util.SignedMessage
 
-	Response chan *util.SignedMessage
+	Response chan *Response
 
 	Timeout time.Duration
 }
+
+// A Response is the answer to a Request, before it is signed. The
+// processing goroutine never signs its answers itself, since a remote signer
+// can be slow, so whoever made the request signs it.
+type Response struct {
+	Message util.Message
+
+	// The protocol version to sign the answer in
+	Version int
+}
+
+// sign returns the response signed by signer, or nil for a nil response.
+func (r *Response) sign(signer util.Signer) *util.SignedMessage {
+	if r == nil {
+		return nil
+	}
+	return util.NewSignedMessageForVersion(r.Message, signer, r.Version)
+}
@@ -0,0 +1,176 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/lacker/coinkit/consensus"
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/data"
+	"github.com/lacker/coinkit/util"
+)
+
+// A node with a database only keeps the most recent blocks in memory, for
+// helping peers catch up. When a peer asks for an older one, the server
+// loads it from the database instead, off the processing goroutine. A node
+// without a database keeps every block, since memory is its only record of
+// them.
+
+// How many recent slots a node with a database keeps in memory
+const DefaultRetention = 1000
+
+// RetentionStats describes how much old consensus data a node keeps in memory.
+type RetentionStats struct {
+	// How many slots the node keeps. Zero means it keeps all of them.
+	Retention int `json:"retention"`
+
+	// The oldest slot we keep the externalize message for, and how many
+	// slots of externalize messages we keep
+	OldestSlot int `json:"oldestSlot"`
+	Slots      int `json:"slots"`
+
+	// How many finalized chunks we keep, and how many operations are in them
+	Chunks     int `json:"chunks"`
+	Operations int `json:"operations"`
+
+	// The heap memory allocated by the whole process
+	HeapAlloc uint64 `json:"heapAlloc"`
+}
+
+func (r *RetentionStats) String() string {
+	return fmt.Sprintf("retention=%d oldest=%d slots=%d chunks=%d operations=%d heap=%dKB",
+		r.Retention, r.OldestSlot, r.Slots, r.Chunks, r.Operations, r.HeapAlloc/1024)
+}
+
+// SetRetention sets how many recent slots the node keeps in memory. Zero
+// keeps all of them.
+func (node *Node) SetRetention(slots int) {
+	node.retention = slots
+	node.chain.SetRetention(slots)
+	node.queue.SetRetention(slots)
+}
+
+// RetentionStats doesn't fill in HeapAlloc, since the node shouldn't depend
+// on anything outside of it.
+func (node *Node) RetentionStats() *RetentionStats {
+	oldest, slots := node.chain.Retained()
+	chunks, ops := node.queue.RetainedChunks()
+	return &RetentionStats{
+		Retention:  node.retention,
+		OldestSlot: oldest,
+		Slots:      slots,
+		Chunks:     chunks,
+		Operations: ops,
+	}
+}
+
+// storedHistory loads the slots a server's node no longer keeps in memory
+// from the database. Reading the database can be slow, so this happens on
+// the goroutines that answer peers and clients, never on the processing
+// goroutine. It is threadsafe.
+type storedHistory struct {
+	db *data.Database
+
+	// The quorum slice the node is using, which the externalize messages we
+	// load get
+	mutex sync.Mutex
+	qs    consensus.QuorumSlice
+}
+
+func (h *storedHistory) setQuorumSlice(qs consensus.QuorumSlice) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.qs = qs
+}
+
+func (h *storedHistory) quorumSlice() consensus.QuorumSlice {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.qs
+}
+
+// block loads the block for a slot. It returns nil if there is no database
+// or no such block.
+func (h *storedHistory) block(ctx context.Context, slot int) *data.Block {
+	if h.db == nil || slot < 1 {
+		return nil
+	}
+	block, err := h.db.GetBlock(ctx, slot)
+	if err != nil {
+		util.Logger.Printf("could not load the history for slot %d: %s", slot, err)
+		return nil
+	}
+	return block
+}
+
+// history loads the history for an old slot. It returns nil if there is no
+// database or no such block.
+func (h *storedHistory) history(ctx context.Context, slot int) *HistoryMessage {
+	block := h.block(ctx, slot)
+	if block == nil {
+		return nil
+	}
+	e := block.ExternalizeMessage(h.quorumSlice())
+	return &HistoryMessage{
+		I: slot,
+		T: &currency.TransactionMessage{
+			Operations: []*util.SignedOperation{},
+			Chunks:     map[consensus.SlotValue]*currency.LedgerChunk{e.X: block.Chunk},
+		},
+		E: e,
+	}
+}
+
+// value loads the value that was externalized for an old slot.
+func (h *storedHistory) value(ctx context.Context, slot int) (consensus.SlotValue, bool) {
+	block := h.block(ctx, slot)
+	if block == nil {
+		return "", false
+	}
+	return block.Chunk.Hash(), true
+}
+
+// historyQuery returns the slot a message asks for the history of, or zero
+// if it isn't a history query.
+func historyQuery(m util.Message) int {
+	info, ok := m.(*util.InfoMessage)
+	if !ok || info.Account != "" || len(info.Accounts) > 0 {
+		return 0
+	}
+	return info.I
+}
+
+// loadHistory answers a history query for a slot the node no longer keeps
+// in memory. It returns nil if the message isn't a history query, or we
+// don't have the slot.
+func (s *Server) loadHistory(sm *util.SignedMessage) *util.SignedMessage {
+	slot := historyQuery(sm.Message())
+	if slot == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	history := s.stored.history(ctx, slot)
+	if history == nil {
+		return nil
+	}
+	return util.NewSignedMessageForVersion(history, s.keyPair, sm.ProtocolVersion())
+}
+
+// RetentionStats describes how much old consensus data the server keeps in
+// memory. It returns nil if the server is shutting down.
+func (s *Server) RetentionStats() *RetentionStats {
+	response := make(chan *RetentionStats, 1)
+	select {
+	case s.retentionStats <- response:
+	case <-s.quit:
+		return nil
+	}
+	stats := <-response
+	mem := &runtime.MemStats{}
+	runtime.ReadMemStats(mem)
+	stats.HeapAlloc = mem.HeapAlloc
+	return stats
+}
@@ -0,0 +1,183 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// A RetryPolicy says how a Client retries calls that fail for reasons that
+// might go away, like a dropped connection or a node that is slow to answer.
+// Without one, every call is tried once.
+// Each query is retried on its own, so a call that makes several round trips,
+// like GetAccounts, only repeats the one that failed.
+type RetryPolicy struct {
+	// The most times to try a call, including the first. Zero or one means
+	// no retries.
+	MaxAttempts int
+
+	// How long to wait before the first retry. It doubles each time, up to
+	// MaxBackoff. Zero MaxBackoff means no cap.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// How long each attempt at a query can take before it counts as a
+	// timeout. Zero means an attempt only ends with the caller's context.
+	// Waiting for an operation to clear isn't limited by this, but queries
+	// that wait for a slot, like GetHistory on a future slot, are, so they
+	// should have a deadline longer than a slot.
+	// An attempt that times out redials before the next one, so a late
+	// answer can't be read as the answer to the retry.
+	AttemptTimeout time.Duration
+
+	// The classes of error to retry. Nil means DefaultRetryable.
+	Retryable []ErrorClass
+}
+
+// An ErrorClass groups the errors a Client can return by whether trying
+// again could help.
+type ErrorClass string
+
+const (
+	// The connection to the node closed
+	ErrorClosed ErrorClass = "closed"
+
+	// An attempt took longer than the policy's AttemptTimeout
+	ErrorTimeout ErrorClass = "timeout"
+
+	// The node answered with data older than the client has seen, or
+	// signed too long ago. Another node, or the same one later, may be
+	// current.
+	ErrorStale ErrorClass = "stale"
+
+	// An operation left the node's queue without clearing. Submitting it
+	// again can work if it was pushed out by higher fees.
+	ErrorRejected ErrorClass = "rejected"
+
+	// The caller's context is done. This is never retried.
+	ErrorCanceled ErrorClass = "canceled"
+
+	// Anything else, like a node that refuses a query. Retrying won't
+	// change its mind.
+	ErrorOther ErrorClass = "other"
+)
+
+// DefaultRetryable is the classes of error a policy retries when it doesn't
+// say. An operation that was rejected isn't retried unless asked for, since
+// it usually fails the same way again.
+var DefaultRetryable = []ErrorClass{ErrorClosed, ErrorTimeout, ErrorStale}
+
+// DefaultRetryPolicy suits an interactive client talking to one node.
+var DefaultRetryPolicy = &RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: 250 * time.Millisecond,
+	MaxBackoff:     4 * time.Second,
+	AttemptTimeout: 10 * time.Second,
+}
+
+// ErrConnectionClosed means the connection closed while a call was waiting
+// for an answer.
+var ErrConnectionClosed = errors.New("connection closed")
+
+// ErrAttemptTimeout means the node didn't answer within the retry policy's
+// AttemptTimeout.
+var ErrAttemptTimeout = errors.New("the node did not answer in time")
+
+// A StaleError means a node answered with data older than the client has
+// already seen, or signed outside the replay window.
+type StaleError struct {
+	Reason string
+}
+
+func (e *StaleError) Error() string {
+	return e.Reason
+}
+
+// ClassifyError returns the class of an error from a Client.
+func ClassifyError(err error) ErrorClass {
+	switch err {
+	case nil:
+		return ""
+	case ErrConnectionClosed:
+		return ErrorClosed
+	case ErrAttemptTimeout:
+		return ErrorTimeout
+	case ErrRejected:
+		return ErrorRejected
+	case context.Canceled, context.DeadlineExceeded:
+		return ErrorCanceled
+	}
+	if _, ok := err.(*StaleError); ok {
+		return ErrorStale
+	}
+	return ErrorOther
+}
+
+// retries returns whether the policy retries errors of this class.
+func (p *RetryPolicy) retries(class ErrorClass) bool {
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = DefaultRetryable
+	}
+	for _, c := range retryable {
+		if c == class && c != ErrorCanceled {
+			return true
+		}
+	}
+	return false
+}
+
+// SetRetryPolicy makes the client retry calls that fail under this policy.
+// Nil turns retries off.
+func (c *Client) SetRetryPolicy(p *RetryPolicy) {
+	c.policy = p
+}
+
+// attemptTimeout is how long each attempt at a query gets.
+func (c *Client) attemptTimeout() time.Duration {
+	if c.policy == nil {
+		return 0
+	}
+	return c.policy.AttemptTimeout
+}
+
+// retry calls attempt until it succeeds, its error isn't retryable, or the
+// policy runs out of attempts, and returns the last error. Each attempt gets
+// a context that ends after timeout, unless timeout is zero.
+func (c *Client) retry(ctx context.Context, timeout time.Duration,
+	attempt func(context.Context) error) error {
+	if c.policy == nil {
+		return attempt(ctx)
+	}
+	backoff := c.policy.InitialBackoff
+	for n := 1; ; n++ {
+		actx, cancel := ctx, context.CancelFunc(func() {})
+		if timeout > 0 {
+			actx, cancel = context.WithTimeout(ctx, timeout)
+		}
+		err := attempt(actx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == context.DeadlineExceeded {
+			// Only the attempt's deadline passed
+			err = ErrAttemptTimeout
+		}
+		if n >= c.policy.MaxAttempts || !c.policy.retries(ClassifyError(err)) {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+		if c.policy.MaxBackoff > 0 && backoff > c.policy.MaxBackoff {
+			backoff = c.policy.MaxBackoff
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lacker/coinkit/consensus"
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/util"
+)
+
+// flakyConnection loses the first few messages sent over it.
+type flakyConnection struct {
+	*nodeConnection
+	lose int
+}
+
+func (c *flakyConnection) Send(sm *util.SignedMessage) bool {
+	if c.lose > 0 {
+		c.lose--
+		return true
+	}
+	return c.nodeConnection.Send(sm)
+}
+
+func TestRetryPolicy(t *testing.T) {
+	mint := util.NewKeyPairFromSecretPhrase("mint")
+	qs, names := consensus.MakeTestQuorumSlice(1)
+	node := NewNodeWithMint(names[0], qs, nil, mint.PublicKey(), 1000)
+	conn := &flakyConnection{nodeConnection: newNodeConnection(node)}
+	client := NewClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	policy := &RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		AttemptTimeout: 50 * time.Millisecond,
+	}
+	client.SetRetryPolicy(policy)
+
+	conn.lose = 2
+	if _, err := client.EstimateFee(ctx, 1); err != nil {
+		t.Fatalf("the third attempt should have worked: %s", err)
+	}
+	conn.lose = 3
+	if _, err := client.EstimateFee(ctx, 1); err != ErrAttemptTimeout {
+		t.Fatalf("expected every attempt to time out but got: %v", err)
+	}
+
+	// Timeouts aren't retried unless the policy says so
+	policy.Retryable = []ErrorClass{ErrorClosed}
+	conn.lose = 1
+	if _, err := client.EstimateFee(ctx, 1); err != ErrAttemptTimeout {
+		t.Fatalf("expected one attempt to time out but got: %v", err)
+	}
+
+	// Without a policy there are no retries or attempt timeouts
+	client.SetRetryPolicy(nil)
+	conn.lose = 1
+	short, cancelShort := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancelShort()
+	if _, err := client.EstimateFee(short, 1); err != context.DeadlineExceeded {
+		t.Fatalf("expected the caller's deadline but got: %v", err)
+	}
+}
+
+// slowConnection holds back its answers while slow is set, and delivers them
+// once it is redialed, like a node that answers after the client gave up.
+type slowConnection struct {
+	*nodeConnection
+	slow    bool
+	held    []*util.SignedMessage
+	redials int
+}
+
+func (c *slowConnection) Send(sm *util.SignedMessage) bool {
+	c.nodeConnection.Send(sm)
+	if c.slow {
+		c.held = append(c.held, <-c.inbox)
+	}
+	return true
+}
+
+func (c *slowConnection) Redial() Connection {
+	for _, sm := range c.held {
+		c.inbox <- sm
+	}
+	c.redials++
+	return &slowConnection{nodeConnection: newNodeConnection(c.node), redials: c.redials}
+}
+
+func TestLateAnswers(t *testing.T) {
+	mint := util.NewKeyPairFromSecretPhrase("mint")
+	bob := util.NewKeyPairFromSecretPhrase("bob")
+	qs, names := consensus.MakeTestQuorumSlice(1)
+	node := NewNodeWithMint(names[0], qs, nil, mint.PublicKey(), 1000)
+	conn := &slowConnection{nodeConnection: newNodeConnection(node), slow: true}
+	client := NewClient(conn)
+	client.SetRetryPolicy(&RetryPolicy{
+		MaxAttempts:    2,
+		InitialBackoff: time.Millisecond,
+		AttemptTimeout: 50 * time.Millisecond,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The first attempt times out, and the retry goes over a new connection
+	account, err := client.GetAccount(ctx, bob.PublicKey().String())
+	if err != nil || account != nil {
+		t.Fatalf("bob should have no account: %+v %v", account, err)
+	}
+	if client.conn.(*slowConnection).redials != 1 {
+		t.Fatal("the client should have redialed once")
+	}
+
+	// The answer about bob came late, so it can't be the answer about mint
+	account, err = client.GetAccount(ctx, mint.PublicKey().String())
+	if err != nil || account == nil || account.Balance != 1000 {
+		t.Fatalf("got the wrong account for mint: %+v %v", account, err)
+	}
+
+	// The same goes when the caller gives up
+	client.SetRetryPolicy(nil)
+	client.conn.(*slowConnection).slow = true
+	short, cancelShort := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancelShort()
+	_, err = client.GetAccount(short, bob.PublicKey().String())
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected the caller's deadline but got: %v", err)
+	}
+	account, err = client.GetAccount(ctx, mint.PublicKey().String())
+	if err != nil || account == nil || account.Balance != 1000 {
+		t.Fatalf("got the wrong account for mint: %+v %v", account, err)
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	cases := map[error]ErrorClass{
+		ErrConnectionClosed:   ErrorClosed,
+		ErrAttemptTimeout:     ErrorTimeout,
+		ErrRejected:           ErrorRejected,
+		context.Canceled:      ErrorCanceled,
+		&StaleError{"old"}:    ErrorStale,
+		errors.New("refused"): ErrorOther,
+	}
+	for err, class := range cases {
+		if ClassifyError(err) != class {
+			t.Fatalf("%s should be %s but got %s", err, class, ClassifyError(err))
+		}
+	}
+	if ClassifyError(nil) != "" {
+		t.Fatal("nil should have no class")
+	}
+	p := &RetryPolicy{Retryable: []ErrorClass{ErrorCanceled, ErrorRejected}}
+	if p.retries(ErrorCanceled) || !p.retries(ErrorRejected) || p.retries(ErrorTimeout) {
+		t.Fatal("the policy retries the wrong classes")
+	}
+}
+
+// ledgerConnection pretends to be a node with one account. Operations clear
+// as soon as they are sent, except for the first few, which are dropped.
+type ledgerConnection struct {
+	inbox    chan *util.SignedMessage
+	user     string
+	slot     int
+	sequence uint32
+	sent     int
+	drop     int
+}
+
+func (c *ledgerConnection) Close()         {}
+func (c *ledgerConnection) IsClosed() bool { return false }
+func (c *ledgerConnection) Receive() chan *util.SignedMessage {
+	return c.inbox
+}
+
+func (c *ledgerConnection) Send(sm *util.SignedMessage) bool {
+	switch m := sm.Message().(type) {
+	case *currency.TransactionMessage:
+		c.sent++
+		if c.sent > c.drop {
+			c.sequence = m.Operations[0].GetSequence()
+		}
+		return true
+	case *util.InfoMessage:
+		if m.Account == "" {
+			// Waiting for a slot to finish
+			c.slot++
+		}
+	}
+	answer := &currency.AccountMessage{
+		I: c.slot,
+		State: map[string]*currency.Account{
+			c.user: &currency.Account{Sequence: c.sequence, Balance: 100},
+		},
+	}
+	c.inbox <- util.NewSignedMessage(answer, util.NewKeyPair())
+	return true
+}
+
+func TestSubmitIsIdempotent(t *testing.T) {
+	alice := util.NewKeyPairFromSecretPhrase("alice")
+	bob := util.NewKeyPairFromSecretPhrase("bob")
+	conn := &ledgerConnection{
+		inbox: make(chan *util.SignedMessage, 10),
+		user:  alice.PublicKey().String(),
+		slot:  1,
+	}
+	client := NewClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	send := func(seq uint32) *util.SignedOperation {
+		return util.NewSignedOperation(&currency.SendOperation{
+			Signer:   alice.PublicKey().String(),
+			Sequence: seq,
+			To:       bob.PublicKey().String(),
+			Amount:   1,
+		}, alice)
+	}
+
+	op := send(1)
+	account, err := client.Submit(ctx, op, alice)
+	if err != nil || account.Sequence != 1 || conn.sent != 1 {
+		t.Fatalf("bad first submission %+v after %d sends: %v", account, conn.sent, err)
+	}
+	if _, err := client.Submit(ctx, op, alice); err != nil || conn.sent != 1 {
+		t.Fatalf("submitting again should not send anything, but sent %d: %v", conn.sent, err)
+	}
+
+	// A fresh client sees the sequence has passed, so it doesn't send either
+	other := NewClient(conn)
+	if _, err := other.Submit(ctx, op, alice); err != nil || conn.sent != 1 {
+		t.Fatalf("a used sequence should not be sent, but sent %d: %v", conn.sent, err)
+	}
+
+	// A dropped operation is only sent again if the policy says so
+	conn.drop = conn.sent + 1
+	if _, err := client.Submit(ctx, send(2), alice); err != ErrRejected {
+		t.Fatalf("expected a rejection but got: %v", err)
+	}
+	client.SetRetryPolicy(&RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		Retryable:      []ErrorClass{ErrorRejected},
+	})
+	conn.drop = conn.sent + 1
+	account, err = client.Submit(ctx, send(2), alice)
+	if err != nil || account.Sequence != 2 {
+		t.Fatalf("the resend should have cleared %+v: %v", account, err)
+	}
+}
@@ -2,12 +2,21 @@ package network
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"sync"
 	"time"
 
+	"github.com/quic-go/quic-go"
+	"golang.org/x/net/proxy"
+
+	"github.com/lacker/coinkit/bus"
+	"github.com/lacker/coinkit/consensus"
 	"github.com/lacker/coinkit/currency"
 	"github.com/lacker/coinkit/data"
 	"github.com/lacker/coinkit/util"
@@ -15,22 +24,72 @@ import (
 
 type Server struct {
 	port    int
-	keyPair *util.KeyPair
+	keyPair util.Signer
 	peers   []*RedialConnection
-	node    *Node
+
+	// With the QUIC transport, peers carry everything but consensus
+	// messages, which go on these instead. Nil without QUIC. See quic.go
+	consensusPeers []*RedialConnection
+	quicDialers    []*quicDialer
+
+	// The public key of each peer, in the same order as peers
+	peerKeys []string
+
+	// The protocol versions we speak, and the version we agreed on with each
+	// of the other servers. See version.go
+	protocols    util.ProtocolRange
+	versionMutex sync.Mutex
+	peerVersions map[string]int
+
+	// Dials the other servers. Nil dials them directly
+	dialer proxy.Dialer
+	node   *Node
+	config *Config
+
+	// The public keys that can submit operations, or nil if anyone can.
+	// It doesn't change, so any goroutine can read it.
+	allowed map[string]bool
 
 	// Whenever there is a new batch of outgoing messages, it is sent to the
 	// outgoing channel
-	outgoing chan []*util.SignedMessage
+	outgoing chan []util.Message
 
 	// Messages we are going to handle that do not require a response
 	inbox chan *util.SignedMessage
 
+	// Signs our outgoing messages. Only used by the broadcast goroutine, so
+	// the processing goroutine never waits on a remote signer for them.
+	signer *util.MessageSigner
+
 	// Requests we are going to handle that do require a response
 	requests chan *Request
 
+	// Requests for stats about the operation queue
+	queueStats chan chan *currency.QueueStats
+
+	// Operations to run the admission checks on
+	admissionChecks chan *admissionRequest
+
+	// Requests for the pending operations
+	pendingRequests chan *pendingRequest
+
+	// Requests for a snapshot of the consensus state
+	consensusStates chan chan *consensus.BlockState
+
+	// Requests for a graph of the consensus state
+	consensusGraphs chan chan *consensus.Graph
+
+	// Requests for stats about the old data the node keeps
+	retentionStats chan chan *RetentionStats
+
 	listener net.Listener
 
+	// Listens on ClientPort, if there is one
+	clientListener net.Listener
+
+	// Listens for QUIC on the same port number, if we are using it
+	quicListener *quic.EarlyListener
+
 	// We close the currentBlock channel whenever the current block is complete
 	currentBlock chan bool
 
@@ -44,40 +103,204 @@ type Server struct {
 
 	db *data.Database
 
+	// Loads the slots the node no longer keeps in memory
+	stored *storedHistory
+
+	// Each webhook and publisher is notified whenever a block is finalized
+	webhooks   []*webhook
+	publishers []*blockPublisher
+
 	start time.Time
 
+	// When we last heard from each of the other servers in the config.
+	// Only used by the processing goroutine.
+	lastHeard map[string]time.Time
+
+	// Message counts and other stats for each of the other servers
+	peerTracker *peerTracker
+
+	// Gets every operation the node admits to its queue
+	mempool *mempoolFeed
+
+	// When the node last externalized a slot, and the slot we last reported
+	// a stall for, or zero if we are not stalled.
+	// Only used by the processing goroutine.
+	lastProgress  time.Time
+	stallReported int
+
+	// The nomination round we are timing, and when we saw it start.
+	// Only used by the processing goroutine.
+	roundSlot  int
+	round      int
+	roundStart time.Time
+
+	stalls stallHistory
+
+	// The fork the node halted on, once the processing goroutine has seen it
+	fork forkHolder
+
+	// Sends alerts to the operator
+	alerts *alerter
+
+	// Records the messages the node handles and sends. Nil when we aren't
+	// recording a trace
+	trace *TraceRecorder
+
+	// The promotions we have voted for, so we only vote once.
+	// Only used by the processing goroutine.
+	promotionVotes map[string]bool
+
 	// How often we send out a rebroadcast, resending our redundant data
 	RebroadcastInterval time.Duration
-}
 
-func NewServer(keyPair *util.KeyPair, config *Config, db *data.Database) *Server {
-	peers := []*RedialConnection{}
-	inbox := make(chan *util.SignedMessage)
-	for _, address := range config.PeerAddresses(keyPair) {
-		peers = append(peers, NewRedialConnection(address, inbox))
-	}
-	qs := config.QuorumSlice()
+	// How long a validator can be silent before a standby server replaces it
+	LivenessTimeout time.Duration
+
+	// The watchdog reports a stall when operations are pending but no slot
+	// has been externalized for StallFactor * TargetSlotTime
+	TargetSlotTime time.Duration
+	StallFactor    int
+
+	// Which addresses can connect to the validator port. Nil allows all.
+	// It must be set before the server starts serving.
+	ACL *ACL
 
+	// Messages signed further than this from our clock are dropped as replays
+	ReplayWindow time.Duration
+
+	// When to raise alerts, and how often to check
+	Health              HealthThresholds
+	HealthCheckInterval time.Duration
+
+	// If GraphDir is set, the server writes a graph of its consensus state
+	// there every GraphInterval, for debugging
+	GraphDir      string
+	GraphInterval time.Duration
+
+	// If ClientPort is nonzero, the server also accepts connections on it,
+	// from any address. That way clients can still reach a server whose
+	// validator port is restricted to the other validators.
+	// It must be set before the server starts serving.
+	ClientPort int
+
+	// How long a restarting server waits for the other servers to answer
+	// the head check. Zero skips the check.
+	HeadCheckTimeout time.Duration
+}
+
+// newServerNode creates the node a server with this config runs.
+func newServerNode(keyPair util.Signer, config *Config, db *data.Database) *Node {
 	// At the start, all money is in the "mint" account
 	mint := util.NewKeyPairFromSecretPhrase("mint")
-	node := NewNodeWithMint(keyPair.PublicKey(), qs, db,
+	node := newGenesisNode(keyPair.PublicKey(), config.QuorumSlice(), db,
 		mint.PublicKey(), currency.TotalMoney)
+	node.keyPair = keyPair
+	node.queue.Admit = config.admitted()
+	node.queue.SetReserve(config.Reserve)
+	node.queue.SetFeePool(config.FeePool)
+	node.queue.SetFeesToValidators(config.FeesToValidators)
+	node.queue.SetEmission(config.Emission)
+	node.queue.Priority = config.priority()
+	node.queue.PriorityShare = config.PriorityShare
+	node.chain.SetPipelining(config.Pipeline)
+	if config.VRF {
+		node.chain.SetVRF(keyPair)
+	}
+
+	// The whole genesis has to be set up before replaying old blocks
+	node.loadBlocks()
+	return node
+}
+
+func NewServer(keyPair util.Signer, config *Config, db *data.Database) *Server {
+	if err := config.Check(); err != nil {
+		util.Logger.Fatalf("bad network config: %s", err)
+	}
+	var dialer proxy.Dialer
+	if config.Proxy != "" {
+		var err error
+		dialer, err = NewProxyDialer(config.Proxy)
+		if err != nil {
+			util.Logger.Fatalf("bad proxy: %s", err)
+		}
+	}
+	peers := []*RedialConnection{}
+	peerKeys := []string{}
+	var consensusPeers []*RedialConnection
+	var quicDialers []*quicDialer
+	inbox := make(chan *util.SignedMessage)
+	for key, address := range config.Servers {
+		if key == keyPair.PublicKey().String() {
+			continue
+		}
+		var noiseKeyPair util.Signer
+		remote := ""
+		if config.Encrypt {
+			noiseKeyPair, remote = keyPair, key
+		}
+		if config.QUIC {
+			d := newQUICDialer(address)
+			quicDialers = append(quicDialers, d)
+			peers = append(peers, newRedialConnection(address, inbox, noiseKeyPair, remote, d))
+			consensusPeers = append(consensusPeers,
+				newRedialConnection(address, inbox, noiseKeyPair, remote, d))
+		} else {
+			peers = append(peers, newRedialConnection(address, inbox, noiseKeyPair, remote, dialer))
+		}
+		peerKeys = append(peerKeys, key)
+	}
+	node := newServerNode(keyPair, config, db)
+	mempool := newMempoolFeed()
+	node.queue.OnAdmit = mempool.publish
+	allowed := config.allowed()
+	node.queue.Allow = allowed
 
+	quit := make(chan bool)
 	return &Server{
 		port:                config.GetPort(keyPair.PublicKey().String(), 9000),
 		keyPair:             keyPair,
 		peers:               peers,
+		consensusPeers:      consensusPeers,
+		quicDialers:         quicDialers,
+		peerKeys:            peerKeys,
+		protocols:           util.SupportedProtocols,
+		peerVersions:        make(map[string]int),
+		dialer:              dialer,
 		node:                node,
-		outgoing:            make(chan []*util.SignedMessage, 10),
+		config:              config,
+		allowed:             allowed,
+		outgoing:            make(chan []util.Message, 10),
 		inbox:               inbox,
 		requests:            make(chan *Request),
+		queueStats:          make(chan chan *currency.QueueStats),
+		admissionChecks:     make(chan *admissionRequest),
+		pendingRequests:     make(chan *pendingRequest),
+		consensusStates:     make(chan chan *consensus.BlockState),
+		consensusGraphs:     make(chan chan *consensus.Graph),
+		retentionStats:      make(chan chan *RetentionStats),
 		listener:            nil,
 		shutdown:            false,
-		quit:                make(chan bool),
+		quit:                quit,
+		alerts:              newAlerter(keyPair.PublicKey().String(), quit),
+		Health:              DefaultHealthThresholds(),
+		HealthCheckInterval: DefaultHealthCheckInterval,
+		GraphInterval:       time.Second,
 		currentBlock:        make(chan bool),
 		broadcasted:         0,
 		db:                  db,
+		stored:              &storedHistory{db: db, qs: node.chain.D},
+		lastHeard:           make(map[string]time.Time),
+		signer:              util.NewMessageSigner(keyPair, signatureReuse),
+		peerTracker:         newPeerTracker(config, keyPair.PublicKey().String()),
+		mempool:             mempool,
+		promotionVotes:      make(map[string]bool),
 		RebroadcastInterval: time.Second,
+		LivenessTimeout:     DefaultLivenessTimeout,
+		TargetSlotTime:      DefaultTargetSlotTime,
+		StallFactor:         DefaultStallFactor,
+		ReplayWindow:        DefaultReplayWindow,
+		HeadCheckTimeout:    DefaultHeadCheckTimeout,
+		lastProgress:        time.Now(),
 	}
 }
 
@@ -85,6 +308,23 @@ func (s *Server) Logf(format string, a ...interface{}) {
 	util.Logf("SE", s.keyPair.PublicKey().ShortName(), format, a...)
 }
 
+// AddWebhook registers a webhook to be notified of new blocks.
+// It must be called before the server starts serving.
+func (s *Server) AddWebhook(config *WebhookConfig) {
+	w := newWebhook(config, s.keyPair.PublicKey().String(), s.quit)
+	s.webhooks = append(s.webhooks, w)
+	go w.deliverForever()
+}
+
+// AddPublisher publishes each new block to a message bus. The server closes
+// the publisher when it stops.
+// It must be called before the server starts serving.
+func (s *Server) AddPublisher(publisher bus.Publisher) {
+	p := newBlockPublisher(publisher, s.keyPair.PublicKey().String(), s.quit)
+	s.publishers = append(s.publishers, p)
+	go p.publishForever()
+}
+
 // Only use for testing
 func (s *Server) setBalance(user string, amount uint64) {
 	s.node.queue.SetBalance(user, amount)
@@ -104,7 +344,13 @@ func (s *Server) numPeersConnected() int {
 // This is likely to include many messages, all separated by endlines.
 func (s *Server) handleConnection(connection net.Conn) {
 	defer connection.Close()
-	conn := NewBasicConnection(connection, make(chan *util.SignedMessage))
+	secure, err := AcceptNoise(connection, s.keyPair)
+	if err != nil {
+		s.Logf("dropping connection from %s: %s", connection.RemoteAddr(), err)
+		return
+	}
+	conn := newBasicConnection(secure, make(chan *util.SignedMessage),
+		s.peerTracker.invalidSignature)
 
 	for {
 		var sm *util.SignedMessage
@@ -118,6 +364,15 @@ func (s *Server) handleConnection(connection net.Conn) {
 		if sm == nil {
 			return
 		}
+		if !s.inWindow(sm, time.Now()) {
+			s.Logf("dropping connection from %s: message signed at %s is a replay",
+				connection.RemoteAddr(), sm.Timestamp().Format(time.RFC3339))
+			return
+		}
+		if err := s.checkClient(sm); err != nil {
+			s.Logf("dropping connection from %s: %s", connection.RemoteAddr(), err)
+			return
+		}
 
 		m, ok := s.handleMessage(sm)
 		if !ok {
@@ -137,15 +392,46 @@ func (s *Server) handleConnection(connection net.Conn) {
 // down or we are overloaded, (nil, false) is returned.
 // (nil, true) means we processed the message and there is a nil response.
 func (s *Server) handleMessage(sm *util.SignedMessage) (*util.SignedMessage, bool) {
-	if _, ok := sm.Message().(*util.InfoMessage); ok {
+	switch sm.Message().(type) {
+	case *util.InfoMessage:
 		return s.retryHandleMessage(sm)
+	case *HeadMessage:
+		return s.handleHeadQuery(sm)
+	}
+	if answer, ok := s.handleDatabaseQuery(sm); ok {
+		return answer, true
 	}
 	return s.handleMessageOnce(sm)
 }
 
+// How long handleMessageOnce waits for the processing goroutine before
+// deciding it is overloaded and exiting.
+const processingTimeout = time.Second
+
+// How long a query that only needs the database can run. Clients can ask
+// for searches and aggregates that take Postgres a long time, so these are
+// answered on the connection's goroutine instead of the processing one, and
+// cut off well before a client would give up on us.
+const queryTimeout = processingTimeout / 2
+
+// handleDatabaseQuery answers a query that only needs the database, like a
+// document search, without going through the processing goroutine. It
+// returns (nil, false) for any other message.
+func (s *Server) handleDatabaseQuery(sm *util.SignedMessage) (*util.SignedMessage, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	answer, ok := answerDatabaseQuery(ctx, s.db, sm.Message())
+	if !ok {
+		return nil, false
+	}
+	return util.NewSignedMessageForVersion(answer, s.keyPair, sm.ProtocolVersion()), true
+}
+
 // handleMessageOnce is like handleMessage but explicitly only tries once.
 func (s *Server) handleMessageOnce(sm *util.SignedMessage) (*util.SignedMessage, bool) {
-	response := make(chan *util.SignedMessage)
+	// The channel has room for the answer, so the processing goroutine
+	// doesn't get stuck if we stop waiting for it
+	response := make(chan *Response, 1)
 	request := &Request{
 		Message:  sm,
 		Response: response,
@@ -154,13 +440,17 @@ func (s *Server) handleMessageOnce(sm *util.SignedMessage) (*util.SignedMessage,
 	// Send our request to the processing goroutine, wait for the response,
 	// and return it down the connection
 	s.requests <- request
-	timer := time.NewTimer(time.Second)
+	timer := time.NewTimer(processingTimeout)
 	select {
-	case m := <-response:
-		return m, true
+	case r := <-response:
+		return r.sign(s.keyPair), true
 	case <-s.quit:
 		return nil, false
 	case <-timer.C:
+		if s.waitingOnSigner() {
+			util.Logger.Printf("dropping a request while the remote signer is slow")
+			return nil, false
+		}
 		util.Logger.Fatalf("the processing goroutine got overloaded")
 		return nil, false
 	}
@@ -178,6 +468,9 @@ func (s *Server) retryHandleMessage(sm *util.SignedMessage) (*util.SignedMessage
 		if m != nil {
 			return m, true
 		}
+		if history := s.loadHistory(sm); history != nil {
+			return history, true
+		}
 		select {
 		case <-s.currentBlock:
 			// There's another block, so let the loop retry
@@ -190,8 +483,8 @@ func (s *Server) retryHandleMessage(sm *util.SignedMessage) (*util.SignedMessage
 // Flushes the outgoing queue and returns the last value if there is any.
 // Returns [], false if there is none
 // Does not wait
-func (s *Server) getOutgoing() ([]*util.SignedMessage, bool) {
-	messages := []*util.SignedMessage{}
+func (s *Server) getOutgoing() ([]util.Message, bool) {
+	messages := []util.Message{}
 	ok := false
 	for {
 		select {
@@ -204,14 +497,15 @@ func (s *Server) getOutgoing() ([]*util.SignedMessage, bool) {
 }
 
 // unsafeUpdateOutgoing gets the outgoing messages from our node and uses
-// the outgoing channel to broadcast them.
+// the outgoing channel to broadcast them. The broadcast goroutine signs them.
 // Since it deals with the node directly, it should only be called from the
 // message-processing thread.
 func (s *Server) unsafeUpdateOutgoing() {
-	// Sign our messages
-	out := []*util.SignedMessage{}
-	for _, m := range s.node.OutgoingMessages() {
-		out = append(out, util.NewSignedMessage(m, s.keyPair))
+	out := s.node.OutgoingMessages()
+	if s.trace != nil {
+		for _, m := range out {
+			s.trace.recordSent(m)
+		}
 	}
 
 	// Clear the outgoing queue
@@ -221,25 +515,88 @@ func (s *Server) unsafeUpdateOutgoing() {
 	s.outgoing <- out
 }
 
+// How often the processing goroutine checks whether the nomination round
+// has timed out
+const roundCheckInterval = 100 * time.Millisecond
+
+// unsafeCheckRound starts the next nomination round once the current one has
+// lasted consensus.RoundTimeout. Rounds are timed from when we first notice
+// them.
+func (s *Server) unsafeCheckRound(now time.Time) {
+	slot, round := s.node.NominationRound()
+	if slot != s.roundSlot || round != s.round {
+		s.roundSlot, s.round, s.roundStart = slot, round, now
+		return
+	}
+	if now.Sub(s.roundStart) < consensus.RoundTimeout(round) {
+		return
+	}
+	s.node.NextNominationRound()
+	if s.trace != nil {
+		s.trace.recordRound()
+	}
+	s.roundSlot, s.round = s.node.NominationRound()
+	s.roundStart = now
+	s.unsafeUpdateOutgoing()
+}
+
 // unsafeProcessMessage handles a message by interacting with the node directly.
 // It should be only be called from the message-processing thread.
-func (s *Server) unsafeProcessMessage(m *util.SignedMessage) *util.SignedMessage {
+func (s *Server) unsafeProcessMessage(m *util.SignedMessage) *Response {
+	s.unsafeHeardFrom(m.Signer())
+	s.peerTracker.received(m)
+	s.unsafeObserveVersion(m)
+	if s.trace != nil {
+		s.trace.record(m)
+	}
+	switch message := m.Message().(type) {
+	case *VersionMessage:
+		return s.unsafeHandleVersion(m, message)
+	case *PromotionMessage:
+		s.unsafeHandlePromotion(m.Signer(), message)
+	case *PeersMessage:
+		// The node doesn't know about peers, so the server answers this
+		if message.Peers != nil {
+			return nil
+		}
+		return &Response{Message: &PeersMessage{Peers: s.PeerStats()}, Version: m.ProtocolVersion()}
+	}
+
+	root := m.Span()
+	defer root.End()
+	ctx, span := util.StartSpan(util.ContextWithSpan(context.Background(), root), "handle")
 	prevSlot := s.node.Slot()
-	message, hasResponse := s.node.Handle(m.Signer(), m.Message())
+	message, hasResponse := s.node.HandleSigned(ctx, m)
 	postSlot := s.node.Slot()
+	span.SetAttribute("slot", strconv.Itoa(postSlot))
+	span.End()
 	s.unsafeUpdateOutgoing()
+	s.unsafeCheckFork()
 
 	if postSlot != prevSlot {
+		s.unsafeMadeProgress()
+		s.stored.setQuorumSlice(s.node.chain.D)
 		close(s.currentBlock)
 		s.currentBlock = make(chan bool)
+		if b := s.node.LastBlock(); b != nil {
+			for _, w := range s.webhooks {
+				w.notify(b)
+			}
+			for _, p := range s.publishers {
+				p.notify(b)
+			}
+		}
 	}
 
 	// Return the appropriate message
 	if !hasResponse {
 		return nil
 	}
-	sm := util.NewSignedMessage(message, s.keyPair)
-	return sm
+	if s.trace != nil {
+		s.trace.recordSent(message)
+	}
+	// Answer in the version we were asked in, so older clients can read it
+	return &Response{Message: message, Version: m.ProtocolVersion()}
 }
 
 // processMessagesForever should be run in its own goroutine. This is the only
@@ -248,6 +605,12 @@ func (s *Server) unsafeProcessMessage(m *util.SignedMessage) *util.SignedMessage
 func (s *Server) processMessagesForever() {
 	// TODO: run long tests to make sure this is ok
 	s.unsafeUpdateOutgoing()
+	s.lastProgress = time.Now()
+
+	liveness := time.NewTicker(livenessCheckInterval)
+	defer liveness.Stop()
+	rounds := time.NewTicker(roundCheckInterval)
+	defer rounds.Stop()
 
 	for {
 
@@ -262,64 +625,130 @@ func (s *Server) processMessagesForever() {
 			}
 
 		case message := <-s.inbox:
-			if message != nil {
+			if message != nil && s.inWindow(message, time.Now()) {
 				s.unsafeProcessMessage(message)
 			}
 
+		case response := <-s.queueStats:
+			response <- s.node.QueueStats()
+
+		case request := <-s.admissionChecks:
+			request.response <- s.node.CheckAdmission(request.op)
+
+		case request := <-s.pendingRequests:
+			request.response <- s.node.Pending(request.filter)
+
+		case response := <-s.consensusStates:
+			response <- s.node.ConsensusState()
+
+		case response := <-s.consensusGraphs:
+			response <- s.node.ConsensusGraph()
+
+		case response := <-s.retentionStats:
+			response <- s.node.RetentionStats()
+
+		case <-liveness.C:
+			s.unsafeCheckLiveness()
+			s.unsafeCheckStall()
+
+		case <-rounds.C:
+			s.unsafeCheckRound(time.Now())
+
 		case <-s.quit:
-			break
+			return
 		}
 	}
 }
 
-func (s *Server) listen() {
+// listen accepts connections that acl permits.
+func (s *Server) listen(listener net.Listener, acl *ACL) {
 	for {
-		conn, err := s.listener.Accept()
-		if s.shutdown {
+		conn, err := listener.Accept()
+		if s.shutdown || errors.Is(err, net.ErrClosed) {
 			break
 		}
 		if err != nil {
 			util.Logger.Print("incoming connection error: ", err)
 			continue
 		}
+		if !acl.Permits(conn.RemoteAddr()) {
+			s.Logf("rejecting connection from %s", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
 		go s.handleConnection(conn)
 	}
 }
 
-// Must be called before listen()
+// Must be called before listenInBackground()
+func (s *Server) acquirePorts() {
+	s.listener = s.acquirePort(s.port)
+	if s.ClientPort != 0 {
+		s.clientListener = s.acquirePort(s.ClientPort)
+	}
+	if s.config.QUIC {
+		s.acquireQUICPort()
+	}
+	s.start = time.Now()
+}
+
+// listenInBackground accepts connections on each of our ports.
+func (s *Server) listenInBackground() {
+	go s.listen(s.listener, s.ACL)
+	if s.clientListener != nil {
+		go s.listen(s.clientListener, nil)
+	}
+	if s.quicListener != nil {
+		go s.listenQUIC()
+	}
+}
+
 // Will retry up to 5 seconds
-func (s *Server) acquirePort() {
-	s.Logf("listening on port %d", s.port)
+func (s *Server) acquirePort(port int) net.Listener {
+	s.Logf("listening on port %d", port)
 	for i := 0; i < 100; i++ {
-		ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", s.port))
+		ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
 		if err == nil {
-			s.listener = ln
-			s.start = time.Now()
-			return
+			return ln
 		}
 		time.Sleep(time.Millisecond * time.Duration(50))
 	}
-	util.Logger.Fatalf("could not acquire port %d", s.port)
+	util.Logger.Fatalf("could not acquire port %d", port)
+	return nil
 }
 
 func (s *Server) broadcast(messages []*util.SignedMessage) {
+	// Consensus messages can refer to operations shared in an earlier
+	// message, so once one message goes on the bulk stream, the rest follow
+	// it there to keep them in order.
+	bulk := false
 	for _, message := range messages {
-		for _, peer := range s.peers {
-			peer.Send(message)
+		peers := s.peers
+		if !bulk && s.consensusPeers != nil && isConsensusMessage(message.Message()) {
+			peers = s.consensusPeers
+		} else {
+			bulk = true
+		}
+		for i, peer := range peers {
+			if sm := s.signFor(s.peerKeys[i], message); sm != nil {
+				peer.Send(sm)
+			}
 		}
 		s.broadcasted += 1
 	}
 }
 
 // Return a list of everything in a that is not in b.
+// Messages are compared by content, since the same message signed at two
+// different times has two different signatures.
 func subtract(a []*util.SignedMessage, b []*util.SignedMessage) []*util.SignedMessage {
-	sigs := make(map[string]bool)
+	contents := make(map[string]bool)
 	for _, m := range b {
-		sigs[m.Signature()] = true
+		contents[m.Encoded()] = true
 	}
 	answer := []*util.SignedMessage{}
 	for _, m := range a {
-		if !sigs[m.Signature()] {
+		if !contents[m.Encoded()] {
 			answer = append(answer, m)
 		}
 	}
@@ -332,32 +761,53 @@ func subtract(a []*util.SignedMessage, b []*util.SignedMessage) []*util.SignedMe
 func (s *Server) broadcastIntermittently() {
 	lastMessages := []*util.SignedMessage{}
 
+	// The rebroadcast timer only restarts when we actually send something.
+	// Outgoing updates that change nothing, like the ones after answering a
+	// client, must not put off the rebroadcast, or a node that is stuck
+	// behind while clients poll it never asks its peers for help.
+	timer := time.NewTimer(s.RebroadcastInterval)
+	restart := func() {
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(s.RebroadcastInterval)
+	}
+
 	for {
-		timer := time.NewTimer(s.RebroadcastInterval)
 		select {
 
 		case <-s.quit:
-			break
+			timer.Stop()
+			return
 
-		case messages := <-s.outgoing:
+		case unsigned := <-s.outgoing:
 			// See if there are even newer messages
 			newerMessages, ok := s.getOutgoing()
 			if ok {
-				messages = newerMessages
+				unsigned = newerMessages
 			}
+			messages := s.signer.SignBatch(unsigned)
 
 			// When we receive a new outgoing, we only need to send out the
 			// messages that have changed since last time.
 			changedMessages := subtract(messages, lastMessages)
 			lastMessages = messages
-			s.broadcast(changedMessages)
+			if len(changedMessages) > 0 {
+				s.broadcast(changedMessages)
+				restart()
+			}
 
 		case <-timer.C:
 			// It's time for a rebroadcast. Send out duplicate messages.
 			// This is a backstop against miscellaneous problems. If the
 			// network is functioning perfectly, this isn't necessary.
 			s.Logf("performing a backup rebroadcast")
+			lastMessages = s.restamp(lastMessages)
 			s.broadcast(lastMessages)
+			timer.Reset(s.RebroadcastInterval)
 		}
 	}
 }
@@ -373,10 +823,14 @@ func (s *Server) LocalhostAddress() *Address {
 // Stop() might not work when you run the server this way, because stopping
 // during startup does not work well
 func (s *Server) ServeForever() {
-	s.acquirePort()
+	s.acquirePorts()
+	s.checkHeadOnBoot()
 
 	go s.processMessagesForever()
-	go s.listen()
+	go s.monitorForever()
+	go s.writeGraphsForever()
+	s.listenInBackground()
+	s.announceVersion()
 	s.broadcastIntermittently()
 }
 
@@ -384,44 +838,112 @@ func (s *Server) ServeForever() {
 // It returns once it has successfully bound to its port.
 // Stop() should work if it is called after ServeInBackground returns.
 func (s *Server) ServeInBackground() {
-	s.acquirePort()
+	s.acquirePorts()
+	s.checkHeadOnBoot()
 	go s.processMessagesForever()
-	go s.listen()
+	go s.monitorForever()
+	go s.writeGraphsForever()
+	s.listenInBackground()
+	s.announceVersion()
 	go s.broadcastIntermittently()
 }
 
 // ServeHttpInBackground spawns a goroutine to serve the /somethingz urls.
 func (s *Server) ServeHttpInBackground(port int) {
-	// /healthz just returns OK as long as the server is healthy
-	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+	mux := http.NewServeMux()
+
+	// /healthz just returns OK as long as the server is healthy. A server
+	// that halted on a fork is not.
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if f := s.Fork(); f != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "HALTED: %s\n", f)
+			return
+		}
 		fmt.Fprintf(w, "OK\n")
 	})
 
-	http.HandleFunc("/uptimez", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/uptimez", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "%.2f\n", s.Uptime())
 	})
 
+	// /queuez returns stats about the operation queue, as JSON
+	mux.HandleFunc("/queuez", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.QueueStats())
+	})
+
+	// /forkz returns the evidence of the fork the server halted on, as JSON.
+	// It is null if the server hasn't halted.
+	mux.HandleFunc("/forkz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Fork())
+	})
+
+	// /stallz returns the report for the most recent consensus stall, as JSON
+	mux.HandleFunc("/stallz", func(w http.ResponseWriter, r *http.Request) {
+		_, report := s.Stalls()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	})
+
 	// /statusz returns more detailed information about this server
-	http.HandleFunc("/statusz", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/statusz", func(w http.ResponseWriter, r *http.Request) {
 		util.Logger.Print("got /statusz request")
 		fmt.Fprintf(w, "%.1fs uptime\n", s.Uptime())
 		fmt.Fprintf(w, "%d messages broadcasted\n", s.broadcasted)
 		fmt.Fprintf(w, "current slot: %d\n", s.node.Slot())
+		if f := s.Fork(); f != nil {
+			fmt.Fprintf(w, "HALTED on a fork: %s\n", f)
+		}
+		fmt.Fprintf(w, "operation queue: %s\n", s.QueueStats())
+		fmt.Fprintf(w, "retained: %s\n", s.RetentionStats())
+		stalls, report := s.Stalls()
+		fmt.Fprintf(w, "consensus stalls: %d\n", stalls)
+		if report != nil {
+			fmt.Fprintf(w, "last stall: %s\n", report)
+		}
 		fmt.Fprintf(w, "DB_USER: %s\n", os.Getenv("DB_USER"))
 		fmt.Fprintf(w, "public key: %s\n", s.keyPair.PublicKey())
+		for _, ps := range s.PeerStats() {
+			fmt.Fprintf(w, "peer %s\n", ps)
+		}
 		if s.db != nil {
-			last := s.db.LastBlock()
-			if last == nil {
+			last, err := s.db.LastBlock(r.Context())
+			if err != nil {
+				fmt.Fprintf(w, "last block: %s\n", err)
+			} else if last == nil {
 				fmt.Fprintf(w, "last block: nil\n")
 			} else {
 				fmt.Fprintf(w, "last block: %s\n", last.String())
 			}
-			fmt.Fprintf(w, "total database size: %s\n", s.db.TotalSizeInfo())
+			fmt.Fprintf(w, "total database size: %s\n", s.db.TotalSizeInfo(r.Context()))
+			fmt.Fprintf(w, "account cache: %s\n", s.db.AccountCacheStats())
 		}
 	})
 
+	// POSTing a serialized signed message to /operations submits the
+	// operations in it
+	mux.HandleFunc("/operations", s.handleSubmit)
+
+	// POSTing an encoded operation to /operations/check reports whether the
+	// queue would admit it, without it needing to be signed
+	mux.HandleFunc("/operations/check", s.handleCheck)
+
+	// /operations/pending lists the operations waiting to get into a block,
+	// a page at a time, as JSON
+	mux.HandleFunc("/operations/pending", s.handlePending)
+
+	// /graphql serves queries, and /graphql/subscribe streams subscription
+	// results as server-sent events
+	g := s.graphQL()
+	schema := g.schema()
+	mux.HandleFunc("/graphql", g.handleGraphQL(schema))
+	mux.HandleFunc("/graphql/subscribe", g.handleGraphQLSubscribe(schema))
+
 	srv := &http.Server{
-		Addr: fmt.Sprintf(":%d", port),
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: mux,
 	}
 
 	go srv.ListenAndServe()
@@ -432,6 +954,64 @@ func (s *Server) ServeHttpInBackground(port int) {
 	}()
 }
 
+// QueueStats describes the operations waiting to get into a block.
+// It returns nil if the server is shutting down.
+func (s *Server) QueueStats() *currency.QueueStats {
+	response := make(chan *currency.QueueStats, 1)
+	select {
+	case s.queueStats <- response:
+		return <-response
+	case <-s.quit:
+		return nil
+	}
+}
+
+type admissionRequest struct {
+	op       util.Operation
+	response chan []*currency.AdmissionCheck
+}
+
+// CheckAdmission reports which of the queue's admission checks an operation
+// passes, under the current state. It doesn't add the operation to the queue.
+// It returns nil if the server is shutting down.
+func (s *Server) CheckAdmission(op util.Operation) []*currency.AdmissionCheck {
+	request := &admissionRequest{
+		op:       op,
+		response: make(chan []*currency.AdmissionCheck, 1),
+	}
+	select {
+	case s.admissionChecks <- request:
+		return <-request.response
+	case <-s.quit:
+		return nil
+	}
+}
+
+// ConsensusState returns a snapshot of the block this server is working on.
+// It returns nil if the server is shutting down.
+func (s *Server) ConsensusState() *consensus.BlockState {
+	response := make(chan *consensus.BlockState, 1)
+	select {
+	case s.consensusStates <- response:
+		return <-response
+	case <-s.quit:
+		return nil
+	}
+}
+
+// ConsensusGraph returns who this server has seen vote for, accept, or
+// confirm which values in the slot it is working on.
+// It returns nil if the server is shutting down.
+func (s *Server) ConsensusGraph() *consensus.Graph {
+	response := make(chan *consensus.Graph, 1)
+	select {
+	case s.consensusGraphs <- response:
+		return <-response
+	case <-s.quit:
+		return nil
+	}
+}
+
 // Uptime returns uptime in seconds
 func (s *Server) Uptime() float64 {
 	return time.Now().Sub(s.start).Seconds()
@@ -452,8 +1032,34 @@ func (s *Server) Stop() {
 		s.Logf("releasing port %d", s.port)
 		s.listener.Close()
 	}
+	if s.clientListener != nil {
+		s.Logf("releasing port %d", s.ClientPort)
+		s.clientListener.Close()
+	}
+	if s.quicListener != nil {
+		s.quicListener.Close()
+	}
 
 	for _, peer := range s.peers {
 		peer.Close()
 	}
+	for _, peer := range s.consensusPeers {
+		peer.Close()
+	}
+	for _, d := range s.quicDialers {
+		d.Close()
+	}
+
+	if s.trace != nil {
+		if err := s.trace.Close(); err != nil {
+			s.Logf("could not close the trace: %s", err)
+		}
+	}
+
+	// A clean shutdown commits any blocks the durability policy left pending
+	if s.db != nil {
+		if err := s.db.Flush(context.Background()); err != nil {
+			s.Logf("could not commit pending blocks: %s", err)
+		}
+	}
 }
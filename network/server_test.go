@@ -1,14 +1,27 @@
 package network
 
 import (
+	"context"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/data"
 	"github.com/lacker/coinkit/util"
 )
 
+// newIdleServer makes a server for the first of n validators on localhost.
+// It doesn't listen or connect to its peers, so unlike makeServers it doesn't
+// need unit test ports. Nothing is running until the test starts it, and the
+// test is responsible for stopping it.
+func newIdleServer(t *testing.T, n int) (*Server, []*util.KeyPair) {
+	t.Helper()
+	config, kps := NewLocalhostNetwork(9000, n, 0)
+	return NewServer(kps[0], config, nil), kps
+}
+
 // FakeMessage implements util.Message but does not get registered
 type FakeMessage struct {
 	Number int
@@ -58,7 +71,12 @@ func TestStartStop(t *testing.T) {
 // sendMoney waits until the transaction clears
 // it fatals if from doesn't have the money
 func sendMoney(conn Connection, from *util.KeyPair, to *util.KeyPair, amount uint64) {
-	account := GetAccount(conn, from.PublicKey().String())
+	client := NewClient(conn)
+	ctx := context.Background()
+	account, err := client.GetAccount(ctx, from.PublicKey().String())
+	if err != nil {
+		util.Logger.Fatal(err)
+	}
 	if account == nil || account.Balance < amount {
 		util.Logger.Fatalf("%s did not have enough money", from.PublicKey().String())
 	}
@@ -73,8 +91,11 @@ func sendMoney(conn Connection, from *util.KeyPair, to *util.KeyPair, amount uin
 	op := util.NewSignedOperation(transaction, from)
 	tm := currency.NewTransactionMessage(op)
 	sm := util.NewSignedMessage(tm, from)
-	conn.Send(sm)
-	WaitToClear(conn, from.PublicKey().String(), seq)
+	client.Send(sm)
+	_, err = client.WaitToClear(ctx, from.PublicKey().String(), seq)
+	if err != nil {
+		util.Logger.Fatal(err)
+	}
 }
 
 func TestSendMoney(t *testing.T) {
@@ -91,6 +112,89 @@ func TestSendMoney(t *testing.T) {
 	go stopServers(servers)
 }
 
+func TestGetAccountTimeout(t *testing.T) {
+	config, _ := NewUnitTestNetwork()
+	conn := NewRedialConnection(config.RandomAddress(), nil)
+	defer conn.Close()
+	client := NewClient(conn)
+
+	// Nothing is listening, so this should time out rather than hang
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := client.GetAccount(ctx, "bob")
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected a deadline error but got: %+v", err)
+	}
+}
+
+func TestCountDocumentsWithoutDatabase(t *testing.T) {
+	servers := makeServers()
+	defer stopServers(servers)
+	conn := NewRedialConnection(servers[0].LocalhostAddress(), nil)
+	defer conn.Close()
+	client := NewClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := client.CountDocuments(ctx, map[string]interface{}{"a": 1})
+	if err == nil || !strings.Contains(err.Error(), "no database") {
+		t.Fatalf("expected a database error but got: %+v", err)
+	}
+}
+
+// Database queries don't wait on the processing goroutine, so a slow one
+// can't make the server look overloaded.
+func TestDatabaseQueriesSkipProcessing(t *testing.T) {
+	// The processing goroutine never runs, so anything sent to it would
+	// block until the server stops
+	s, kps := newIdleServer(t, 1)
+	defer s.Stop()
+	client := util.NewKeyPair()
+	queries := []util.Message{
+		&data.DocumentMessage{Match: map[string]interface{}{"a": 1}},
+		&data.BlobMessage{Hash: "nothing"},
+		&data.NetworkStatsMessage{},
+	}
+	for _, query := range queries {
+		response, ok := s.handleMessage(util.NewSignedMessage(query, client))
+		if !ok || response == nil || response.Signer() != kps[0].PublicKey().String() {
+			t.Fatalf("no answer for %+v", query)
+		}
+	}
+	if queryError(context.DeadlineExceeded) != "the query took too long" {
+		t.Fatal("a timeout should be reported as one")
+	}
+}
+
+func TestLoadHistorySkipsProcessing(t *testing.T) {
+	data.DropTestData(0)
+	db := data.NewTestDatabase(0)
+	chunk := currency.NewEmptyChunk()
+	if err := db.InsertBlock(context.Background(), &data.Block{Slot: 1, Chunk: chunk}); err != nil {
+		t.Fatal(err)
+	}
+
+	// As above, the processing goroutine never runs
+	config, kps := NewLocalhostNetwork(9000, 1, 0)
+	s := NewServer(kps[0], config, db)
+	defer s.Stop()
+	client := util.NewKeyPair()
+	response := s.loadHistory(util.NewSignedMessage(&util.InfoMessage{I: 1}, client))
+	if response == nil {
+		t.Fatal("expected the history for slot 1")
+	}
+	history := response.Message().(*HistoryMessage)
+	if history.E.X != chunk.Hash() || history.T.Chunks[chunk.Hash()] == nil {
+		t.Fatalf("unexpected history: %+v", history)
+	}
+	if s.loadHistory(util.NewSignedMessage(&util.InfoMessage{I: 2}, client)) != nil {
+		t.Fatal("slot 2 was never stored")
+	}
+	if s.loadHistory(util.NewSignedMessage(&util.InfoMessage{Account: "bob", I: 1}, client)) != nil {
+		t.Fatal("an account query is not a history query")
+	}
+}
+
 func makeConns(servers []*Server, n int) []Connection {
 	conns := []Connection{}
 	for {
@@ -182,3 +286,105 @@ func TestServerOkayWithFakeWellFormattedMessage(t *testing.T) {
 	// better assertion here
 	go s.Stop()
 }
+
+func TestBroadcastStopsWithServer(t *testing.T) {
+	s, _ := newIdleServer(t, 1)
+	done := make(chan bool)
+	go func() {
+		s.broadcastIntermittently()
+		done <- true
+	}()
+	s.Stop()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("broadcasting should stop when the server does")
+	}
+}
+
+func TestProcessingStopsWithServer(t *testing.T) {
+	s, _ := newIdleServer(t, 1)
+	done := make(chan bool)
+	go func() {
+		s.processMessagesForever()
+		done <- true
+	}()
+	s.Stop()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("processing should stop when the server does")
+	}
+}
+
+func TestQueueStats(t *testing.T) {
+	s, _ := newIdleServer(t, 1)
+	go s.processMessagesForever()
+	stats := s.QueueStats()
+	if stats == nil || stats.Pending != 0 {
+		t.Fatalf("expected an empty queue but got %+v", stats)
+	}
+	s.Stop()
+	if s.QueueStats() != nil {
+		t.Fatal("a stopped server should not report stats")
+	}
+}
+
+func TestAwaitOperation(t *testing.T) {
+	servers := makeServers()
+	defer stopServers(servers)
+	mint := util.NewKeyPairFromSecretPhrase("mint")
+	bob := util.NewKeyPairFromSecretPhrase("bob")
+	conn := NewRedialConnection(servers[0].LocalhostAddress(), nil)
+	defer conn.Close()
+	sendMoney(conn, mint, bob, 100)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client := NewClient(conn)
+	account, err := client.AwaitOperation(ctx, mint.PublicKey().String(), 1)
+	if err != nil || account.Sequence != 1 {
+		t.Fatalf("op 1 should have cleared: %+v %s", account, err)
+	}
+
+	// Nothing will ever clear with sequence 5, which we see once a slot passes
+	rejected := make(chan error)
+	go func() {
+		c := NewClient(NewRedialConnection(servers[1].LocalhostAddress(), nil))
+		defer c.Close()
+		_, err := c.AwaitOperation(ctx, mint.PublicKey().String(), 5)
+		rejected <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	sendMoney(conn, mint, bob, 100)
+	if err := <-rejected; err != ErrRejected {
+		t.Fatalf("expected a rejection but got: %+v", err)
+	}
+}
+
+func TestGetAccounts(t *testing.T) {
+	servers := makeServers()
+	defer stopServers(servers)
+	mint := util.NewKeyPairFromSecretPhrase("mint")
+	bob := util.NewKeyPairFromSecretPhrase("bob")
+	conn := NewRedialConnection(servers[0].LocalhostAddress(), nil)
+	defer conn.Close()
+	sendMoney(conn, mint, bob, 100)
+
+	// Enough users that it takes two round trips
+	users := []string{mint.PublicKey().String(), bob.PublicKey().String()}
+	for i := 0; i < util.MaxAccountsPerQuery; i++ {
+		kp := util.NewKeyPairFromSecretPhrase(fmt.Sprintf("user%d", i))
+		users = append(users, kp.PublicKey().String())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	accounts, err := NewClient(conn).GetAccounts(ctx, users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != len(users) || accounts[bob.PublicKey().String()].Balance != 100 ||
+		accounts[users[len(users)-1]] != nil {
+		t.Fatalf("bad accounts: %d of them", len(accounts))
+	}
+}
@@ -0,0 +1,96 @@
+package network
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/util"
+)
+
+// The largest body that can be POSTed to /operations
+const maxSubmissionSize = 64 * 1024
+
+// handleSubmit accepts a serialized signed message with operations in it,
+// like the ones the mobile package creates, and handles it the same way as
+// if a client had sent it over a connection. The response is 202 once the
+// node has seen the operations. It doesn't mean they are valid, so clients
+// should watch the account to see them clear.
+func (s *Server) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "operations must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxSubmissionSize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxSubmissionSize {
+		http.Error(w, "the message is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	sm, err := util.NewSignedMessageFromSerialized(strings.TrimSpace(string(body)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, ok := sm.Message().(*currency.TransactionMessage); !ok {
+		http.Error(w, "only operations can be submitted", http.StatusBadRequest)
+		return
+	}
+	if err := s.checkClient(sm); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if !s.inWindow(sm, time.Now()) {
+		http.Error(w, "the message was not signed within the replay window",
+			http.StatusBadRequest)
+		return
+	}
+	if _, ok := s.handleMessageOnce(sm); !ok {
+		http.Error(w, "the server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleCheck accepts an operation encoded the way util.EncodeOperation does
+// it, like {"T": "Send", "O": {...}}, and responds with the result of each of
+// the queue's admission checks, as JSON. The operation doesn't get signed or
+// added to the queue, so wallets can use this to explain why an operation
+// wouldn't go through before asking for a signature.
+func (s *Server) handleCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "operations must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxSubmissionSize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxSubmissionSize {
+		http.Error(w, "the operation is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	op, err := util.DecodeOperation(string(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	checks := s.CheckAdmission(op)
+	if checks == nil {
+		http.Error(w, "the server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"admitted": currency.AllPassed(checks),
+		"checks":   checks,
+	})
+}
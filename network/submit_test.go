@@ -0,0 +1,132 @@
+package network
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/util"
+)
+
+func TestSubmit(t *testing.T) {
+	s, _ := newIdleServer(t, 3)
+	defer s.Stop()
+	go s.processMessagesForever()
+	mint := util.NewKeyPairFromSecretPhrase("mint")
+	bob := util.NewKeyPairFromSecretPhrase("bob")
+
+	post := func(method string, body string) int {
+		w := httptest.NewRecorder()
+		s.handleSubmit(w, httptest.NewRequest(method, "/operations", strings.NewReader(body)))
+		return w.Code
+	}
+	send := util.NewSignedMessage(newSendMessage(mint, bob, 1, 10), mint).Serialize()
+	if code := post("GET", send); code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET should not be allowed but got %d", code)
+	}
+	if code := post("POST", "garbage"); code != http.StatusBadRequest {
+		t.Fatalf("expected a bad request but got %d", code)
+	}
+	info := util.NewSignedMessage(&util.InfoMessage{I: 1}, mint).Serialize()
+	if code := post("POST", info); code != http.StatusBadRequest {
+		t.Fatalf("only operations should be accepted but got %d", code)
+	}
+	if code := post("POST", send+"\n"); code != http.StatusAccepted {
+		t.Fatalf("expected the send to be accepted but got %d", code)
+	}
+	if stats := s.QueueStats(); stats.Pending != 1 {
+		t.Fatalf("expected the send to be pending: %s", stats)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	s, _ := newIdleServer(t, 3)
+	defer s.Stop()
+	go s.processMessagesForever()
+	mint := util.NewKeyPairFromSecretPhrase("mint")
+	bob := util.NewKeyPairFromSecretPhrase("bob")
+
+	check := func(op util.Operation) map[string]bool {
+		w := httptest.NewRecorder()
+		body := strings.NewReader(util.EncodeOperation(op))
+		s.handleCheck(w, httptest.NewRequest("POST", "/operations/check", body))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected a 200 but got %d", w.Code)
+		}
+		var response struct {
+			Admitted bool
+			Checks   []*currency.AdmissionCheck
+		}
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		answer := map[string]bool{"admitted": response.Admitted}
+		for _, c := range response.Checks {
+			answer[c.Name] = c.Passed
+		}
+		return answer
+	}
+
+	send := &currency.SendOperation{
+		Signer:   mint.PublicKey().String(),
+		Sequence: 1,
+		To:       bob.PublicKey().String(),
+		Amount:   10,
+	}
+	if checks := check(send); !checks["admitted"] {
+		t.Fatalf("the send should be admitted: %v", checks)
+	}
+	send.Sequence = 2
+	if checks := check(send); checks["admitted"] || checks["sequence"] || !checks["funds"] {
+		t.Fatalf("only the sequence check should fail: %v", checks)
+	}
+	if s.QueueStats().Pending != 0 {
+		t.Fatal("checking should not add anything to the queue")
+	}
+}
+
+func TestSubmitAllowlist(t *testing.T) {
+	config, kps := NewLocalhostNetwork(9000, 3, 0)
+	mint := util.NewKeyPairFromSecretPhrase("mint")
+	bob := util.NewKeyPairFromSecretPhrase("bob")
+	config.Clients = []string{mint.PublicKey().String()}
+	s := NewServer(kps[0], config, nil)
+	defer s.Stop()
+	go s.processMessagesForever()
+
+	post := func(sm *util.SignedMessage) int {
+		w := httptest.NewRecorder()
+		body := strings.NewReader(sm.Serialize())
+		s.handleSubmit(w, httptest.NewRequest("POST", "/operations", body))
+		return w.Code
+	}
+	fromBob := util.NewSignedMessage(newSendMessage(bob, mint, 1, 10), bob)
+	if code := post(fromBob); code != http.StatusForbidden {
+		t.Fatalf("bob should not be allowed to submit but got %d", code)
+	}
+	if code := post(util.NewSignedMessage(newSendMessage(mint, bob, 1, 10), mint)); code != http.StatusAccepted {
+		t.Fatalf("expected the send to be accepted but got %d", code)
+	}
+
+	// Relaying bob's operation through an allowed client doesn't help
+	relayed := util.NewSignedMessage(fromBob.Message(), mint)
+	if code := post(relayed); code != http.StatusForbidden {
+		t.Fatalf("bob's operation should not be allowed but got %d", code)
+	}
+
+	// Queries are open unless they are private
+	query := util.NewSignedMessage(&util.InfoMessage{Account: bob.PublicKey().String()}, bob)
+	if err := s.checkClient(query); err != nil {
+		t.Fatal(err)
+	}
+	config.PrivateQueries = true
+	if err := s.checkClient(query); err == nil {
+		t.Fatalf("bob should not be allowed to query")
+	}
+	if err := s.checkClient(util.NewSignedMessage(&HeadMessage{I: 1}, kps[1])); err != nil {
+		t.Fatalf("servers can always query: %s", err)
+	}
+}
@@ -0,0 +1,303 @@
+package network
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lacker/coinkit/util"
+)
+
+// A trace records every message a server's node handles and every message it
+// sends, so a problem seen in production can be reproduced by feeding the
+// same messages into a fresh node with ReplayTrace.
+//
+// A trace file is gzipped text. The first line is a header with the public
+// key of the server that recorded it. After that there is one line per
+// message, like:
+//
+//   in <unix nanoseconds> <serialized signed message>
+//   out <unix nanoseconds> <encoded message>
+//   round <unix nanoseconds>
+//
+// The out lines after an in line are what the node sent after handling it.
+// They are recorded before they are signed, since the signing happens off
+// the processing goroutine. Older traces have signed out lines, which still
+// load.
+// A round line is a nomination round timing out, which the node handles like
+// a message, since it depends on the clock.
+//
+// Replaying only reproduces the node. The server's own decisions, like
+// voting for a promotion after a validator goes silent, depend on the clock
+// and aren't replayed.
+
+const traceHeader = "coinkit-trace"
+
+type TraceEntry struct {
+	Time     time.Time
+	Outbound bool
+
+	// The message the node handled, for an inbound entry. Nil when the entry
+	// is a nomination round timing out
+	Message *util.SignedMessage
+
+	// The message the node sent, for an outbound entry
+	Sent util.Message
+}
+
+// NextRound returns whether the entry is a nomination round timing out.
+func (e *TraceEntry) NextRound() bool {
+	return !e.Outbound && e.Message == nil
+}
+
+type Trace struct {
+	// The public key of the server that recorded the trace
+	PublicKey string
+
+	Entries []*TraceEntry
+}
+
+// A TraceRecorder writes a trace file.
+// TraceRecorder is threadsafe, so a server can be stopped while it records.
+type TraceRecorder struct {
+	mutex  sync.Mutex
+	file   *os.File
+	gz     *gzip.Writer
+	closed bool
+}
+
+// NewTraceRecorder creates a trace file, replacing any file that is there.
+func NewTraceRecorder(filename string, publicKey string) (*TraceRecorder, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	r := &TraceRecorder{
+		file: file,
+		gz:   gzip.NewWriter(file),
+	}
+	fmt.Fprintf(r.gz, "%s %s\n", traceHeader, publicKey)
+	return r, nil
+}
+
+// record writes a message the node handled.
+func (r *TraceRecorder) record(sm *util.SignedMessage) {
+	if sm == nil || sm.IsKeepAlive() {
+		return
+	}
+	r.write("in", sm.Serialize())
+}
+
+// recordSent writes a message the node sent.
+func (r *TraceRecorder) recordSent(m util.Message) {
+	if m == nil {
+		return
+	}
+	r.write("out", util.EncodeMessage(m))
+}
+
+// recordRound writes a nomination round timing out.
+func (r *TraceRecorder) recordRound() {
+	r.write("round", "")
+}
+
+// write writes one line. Each line is flushed, so a trace is complete up to
+// the last message even if the server crashes.
+func (r *TraceRecorder) write(kind string, message string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return
+	}
+	line := fmt.Sprintf("%s %d", kind, time.Now().UnixNano())
+	if message != "" {
+		line += " " + message
+	}
+	fmt.Fprintln(r.gz, line)
+	if err := r.gz.Flush(); err != nil {
+		util.Logger.Printf("could not write to the trace: %s", err)
+	}
+}
+
+func (r *TraceRecorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	if err := r.gz.Close(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+// ReadTrace reads a trace that a TraceRecorder wrote.
+func ReadTrace(reader io.Reader) (*Trace, error) {
+	gz, err := gzip.NewReader(reader)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(gz)
+	header, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Fields(header)
+	if len(parts) != 2 || parts[0] != traceHeader {
+		return nil, errors.New("this is not a trace file")
+	}
+	trace := &Trace{PublicKey: parts[1], Entries: []*TraceEntry{}}
+	for n := 2; ; n++ {
+		line, err := r.ReadString('\n')
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// A trace from a server that was killed has no gzip footer, and
+			// maybe a partial last line
+			return trace, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		parts := strings.SplitN(strings.TrimSuffix(line, "\n"), " ", 3)
+		if len(parts) == 2 && parts[0] == "round" {
+			nanos, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad time on trace line %d", n)
+			}
+			trace.Entries = append(trace.Entries, &TraceEntry{Time: time.Unix(0, nanos)})
+			continue
+		}
+		if len(parts) != 3 || (parts[0] != "in" && parts[0] != "out") {
+			return nil, fmt.Errorf("bad trace line %d", n)
+		}
+		nanos, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad time on trace line %d", n)
+		}
+		entry := &TraceEntry{Time: time.Unix(0, nanos), Outbound: parts[0] == "out"}
+		if entry.Outbound {
+			entry.Sent, err = readSent(parts[2])
+		} else {
+			entry.Message, err = util.NewSignedMessageFromSerialized(parts[2])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("bad message on trace line %d: %s", n, err)
+		}
+		trace.Entries = append(trace.Entries, entry)
+	}
+}
+
+// readSent reads the message on an out line, which older traces signed.
+func readSent(s string) (util.Message, error) {
+	m, err := util.DecodeMessage(s)
+	if err == nil {
+		return m, nil
+	}
+	sm, signedErr := util.NewSignedMessageFromSerialized(s)
+	if signedErr != nil {
+		return nil, err
+	}
+	return sm.Message(), nil
+}
+
+// LoadTrace reads a trace file.
+func LoadTrace(filename string) (*Trace, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadTrace(file)
+}
+
+type ReplayResult struct {
+	// How many inbound messages the node handled
+	Handled int
+
+	// The slot the node ended up working on
+	Slot int
+
+	// The first message the recording server sent that the replayed node
+	// didn't, or nil if every message matched
+	Divergence *TraceEntry
+
+	// The inbound message the divergence came after, or nil if it was before
+	// the first one
+	Cause *TraceEntry
+}
+
+// ReplayTrace feeds a trace's inbound messages and round timeouts into a
+// node, the same way a server would, and checks that the node sends the same messages the
+// recording server did. For the replay to match, the node should start in
+// the same state the recording server did, like one from NewReplayNode for a
+// server that recorded from startup without a database.
+func ReplayTrace(trace *Trace, node *Node) *ReplayResult {
+	result := &ReplayResult{}
+	var cause *TraceEntry
+
+	// A server asks its node for outgoing messages when it starts, and after
+	// handling each message
+	outgoing := make(map[string]bool)
+	update := func() {
+		outgoing = make(map[string]bool)
+		for _, m := range node.OutgoingMessages() {
+			outgoing[util.EncodeMessage(m)] = true
+		}
+	}
+	update()
+
+	for _, entry := range trace.Entries {
+		if entry.Outbound {
+			if !outgoing[util.EncodeMessage(entry.Sent)] && result.Divergence == nil {
+				result.Divergence = entry
+				result.Cause = cause
+			}
+			continue
+		}
+		cause = entry
+		if entry.NextRound() {
+			node.NextNominationRound()
+			update()
+			continue
+		}
+		if _, ok := entry.Message.Message().(*PeersMessage); ok {
+			// The server answers these without the node
+			continue
+		}
+		result.Handled++
+		response, ok := node.HandleSigned(context.Background(), entry.Message)
+		update()
+		if ok {
+			outgoing[util.EncodeMessage(response)] = true
+		}
+	}
+	result.Slot = node.Slot()
+	return result
+}
+
+// NewReplayNode creates a node in the state a server with this key pair and
+// config starts in, when it has no database.
+func NewReplayNode(keyPair util.Signer, config *Config) *Node {
+	return newServerNode(keyPair, config, nil)
+}
+
+// RecordTrace makes the server record every message its node handles and
+// sends into a trace file. It must be called before the server starts
+// serving.
+func (s *Server) RecordTrace(filename string) error {
+	r, err := NewTraceRecorder(filename, s.keyPair.PublicKey().String())
+	if err != nil {
+		return err
+	}
+	s.trace = r
+	return nil
+}
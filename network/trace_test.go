@@ -0,0 +1,125 @@
+package network
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lacker/coinkit/consensus"
+	"github.com/lacker/coinkit/util"
+)
+
+func TestTraceReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "trace.gz")
+
+	// The other two validators are just nodes, and this test passes messages
+	// between them and the server.
+	s, kps := newIdleServer(t, 3)
+	if err := s.RecordTrace(filename); err != nil {
+		t.Fatal(err)
+	}
+	go s.processMessagesForever()
+	peers := []*Node{NewReplayNode(kps[1], s.config), NewReplayNode(kps[2], s.config)}
+
+	server := kps[0].PublicKey().String()
+	handle := func(sm *util.SignedMessage) *util.SignedMessage {
+		request := &Request{Message: sm, Response: make(chan *Response, 1)}
+		s.requests <- request
+		return (<-request.Response).sign(kps[0])
+	}
+	mint := util.NewKeyPairFromSecretPhrase("mint")
+	bob := util.NewKeyPairFromSecretPhrase("bob")
+	handle(util.NewSignedMessage(newSendMessage(mint, bob, 1, 10), mint))
+
+	outgoing := []util.Message{}
+	for i := 0; s.ConsensusState().Slot < 2; i++ {
+		if i == 100 {
+			t.Fatal("the server never finished slot 1")
+		}
+		for j, peer := range peers {
+			for _, m := range peer.OutgoingMessages() {
+				response := handle(util.NewSignedMessage(m, kps[j+1]))
+				if response != nil {
+					peer.Handle(server, response.Message())
+				}
+			}
+			if latest, ok := s.getOutgoing(); ok {
+				outgoing = latest
+			}
+			for _, m := range outgoing {
+				peer.Handle(server, util.EncodeThenDecodeMessage(m))
+			}
+		}
+		sendNodeToNodeMessages(peers[0], peers[1], t)
+		sendNodeToNodeMessages(peers[1], peers[0], t)
+	}
+	s.Stop()
+
+	trace, err := LoadTrace(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trace.PublicKey != server || len(trace.Entries) == 0 {
+		t.Fatalf("bad trace with %d entries from %s", len(trace.Entries), trace.PublicKey)
+	}
+	result := ReplayTrace(trace, NewReplayNode(kps[0], s.config))
+	if result.Divergence != nil {
+		t.Fatalf("the replay diverged at %s", result.Divergence.Sent)
+	}
+	if result.Slot < 2 || result.Handled == 0 {
+		t.Fatalf("bad replay result: %+v", result)
+	}
+
+	// A trace of a different server's messages should not replay cleanly
+	if result := ReplayTrace(trace, NewReplayNode(kps[1], s.config)); result.Divergence == nil {
+		t.Fatal("replaying into the wrong node should diverge")
+	}
+}
+
+func TestTraceReplaysRounds(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "trace.gz")
+
+	// The processing goroutine isn't running, so the rounds are timed by hand
+	s, kps := newIdleServer(t, 3)
+	if err := s.RecordTrace(filename); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	s.unsafeCheckRound(start)
+	s.unsafeCheckRound(start.Add(consensus.RoundTimeout(1) / 2))
+	if _, round := s.node.NominationRound(); round != 1 {
+		t.Fatalf("the round ended early, on round %d", round)
+	}
+	s.unsafeCheckRound(start.Add(consensus.RoundTimeout(1)))
+	if _, round := s.node.NominationRound(); round != 2 {
+		t.Fatalf("expected round 2 but got round %d", round)
+	}
+	s.Stop()
+
+	trace, err := LoadTrace(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trace.Entries) == 0 || !trace.Entries[0].NextRound() {
+		t.Fatal("the trace should start with the round timing out")
+	}
+	node := NewReplayNode(kps[0], s.config)
+	if result := ReplayTrace(trace, node); result.Divergence != nil {
+		t.Fatalf("the replay diverged at %s", result.Divergence.Sent)
+	}
+	if _, round := node.NominationRound(); round != 2 {
+		t.Fatalf("the replay should be on round 2, not %d", round)
+	}
+}
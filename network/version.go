@@ -0,0 +1,132 @@
+package network
+
+import (
+	"fmt"
+
+	"github.com/lacker/coinkit/util"
+)
+
+// A VersionMessage tells the other side which protocol versions we speak.
+// Each server sends one to every peer when it starts, and the peer answers
+// with its own versions and the version the two of them will talk in.
+// See util.ProtocolVersion.
+type VersionMessage struct {
+	Min int
+	Max int
+
+	// Whether this is an answer, and the version the two servers agreed on,
+	// zero if they have no version in common
+	Answer  bool `json:",omitempty"`
+	Version int  `json:",omitempty"`
+}
+
+func (m *VersionMessage) Slot() int {
+	return 0
+}
+
+func (m *VersionMessage) MessageType() string {
+	return "V"
+}
+
+func (m *VersionMessage) IsQuery() bool {
+	return !m.Answer
+}
+
+func (m *VersionMessage) String() string {
+	if m.IsQuery() {
+		return fmt.Sprintf("versions %d-%d", m.Min, m.Max)
+	}
+	return fmt.Sprintf("versions %d-%d, agreed on %d", m.Min, m.Max, m.Version)
+}
+
+func init() {
+	util.RegisterMessageType(&VersionMessage{})
+}
+
+// versionQuery is the message announcing our protocol versions. It is signed
+// in our oldest version, so that any server we could talk to can read it.
+func (s *Server) versionQuery() *util.SignedMessage {
+	return util.NewSignedMessageForVersion(
+		&VersionMessage{Min: s.protocols.Min, Max: s.protocols.Max}, s.keyPair, s.protocols.Min)
+}
+
+// announceVersion tells each peer which protocol versions we speak.
+func (s *Server) announceVersion() {
+	for _, peer := range s.peers {
+		peer.Send(s.versionQuery())
+	}
+}
+
+// unsafeHandleVersion records the version we agreed on with a server that
+// told us its versions, and answers it if it asked. A server that has no
+// version in common with us is recorded as zero, and we stop sending to it,
+// except for the answer, which is in the version it asked in.
+func (s *Server) unsafeHandleVersion(sm *util.SignedMessage, m *VersionMessage) *Response {
+	signer := sm.Signer()
+	version, ok := s.protocols.Negotiate(util.ProtocolRange{Min: m.Min, Max: m.Max})
+	if !ok {
+		s.Logf("%s speaks protocol versions %d-%d, which we don't",
+			util.Shorten(signer), m.Min, m.Max)
+	}
+	s.setPeerVersion(signer, version)
+	if !m.IsQuery() {
+		return nil
+	}
+	answer := &VersionMessage{
+		Min:     s.protocols.Min,
+		Max:     s.protocols.Max,
+		Answer:  true,
+		Version: version,
+	}
+	if !ok {
+		version = sm.ProtocolVersion()
+	}
+	return &Response{Message: answer, Version: version}
+}
+
+// unsafeObserveVersion notes the version a server signed a message in.
+// Servers from before VersionMessage existed never announce their versions,
+// but they only sign messages in the one version they speak, and newer
+// servers sign what they send us in the version they agreed on, so that is
+// the version to talk to them in.
+func (s *Server) unsafeObserveVersion(sm *util.SignedMessage) {
+	if _, ok := sm.Message().(*VersionMessage); ok {
+		return
+	}
+	s.setPeerVersion(sm.Signer(), sm.ProtocolVersion())
+}
+
+func (s *Server) setPeerVersion(key string, version int) {
+	if _, ok := s.config.Servers[key]; !ok {
+		return
+	}
+	s.versionMutex.Lock()
+	defer s.versionMutex.Unlock()
+	s.peerVersions[key] = version
+}
+
+// PeerVersion returns the protocol version we talk to a server in, zero if
+// we have none in common. Until we hear otherwise, we assume a server speaks
+// our newest version.
+func (s *Server) PeerVersion(key string) int {
+	s.versionMutex.Lock()
+	defer s.versionMutex.Unlock()
+	if version, ok := s.peerVersions[key]; ok {
+		return version
+	}
+	return s.protocols.Max
+}
+
+// signFor returns a message as it should be sent to a server, re-signed if
+// the server speaks an older version than the one it is signed in. It
+// returns nil if we can't talk to the server at all.
+func (s *Server) signFor(key string, sm *util.SignedMessage) *util.SignedMessage {
+	version := s.PeerVersion(key)
+	switch {
+	case version == 0:
+		return nil
+	case version == sm.ProtocolVersion() || sm.IsKeepAlive():
+		return sm
+	}
+	return util.NewSignedMessageForVersion(sm.Message(), s.keyPair, version)
+}
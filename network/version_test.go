@@ -0,0 +1,83 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/lacker/coinkit/util"
+)
+
+func TestVersionNegotiation(t *testing.T) {
+	cases := []struct {
+		a, b    util.ProtocolRange
+		version int
+	}{
+		{util.ProtocolRange{Min: 2, Max: 2}, util.ProtocolRange{Min: 2, Max: 3}, 2},
+		{util.ProtocolRange{Min: 2, Max: 3}, util.ProtocolRange{Min: 3, Max: 3}, 3},
+		{util.ProtocolRange{Min: 2, Max: 3}, util.ProtocolRange{Min: 2, Max: 3}, 3},
+		{util.ProtocolRange{Min: 2, Max: 3}, util.ProtocolRange{Min: 3, Max: 4}, 3},
+		{util.ProtocolRange{Min: 2, Max: 2}, util.ProtocolRange{Min: 3, Max: 3}, 0},
+	}
+	for _, c := range cases {
+		// These servers don't listen, so they don't need unit test ports
+		config, kps := NewLocalhostNetwork(9000, 2, 0)
+		a := NewServer(kps[0], config, nil)
+		b := NewServer(kps[1], config, nil)
+		a.protocols = c.a
+		b.protocols = c.b
+		aKey, bKey := kps[0].PublicKey().String(), kps[1].PublicKey().String()
+
+		answer := b.unsafeProcessMessage(a.versionQuery()).sign(kps[1])
+		if answer == nil {
+			t.Fatal("expected an answer to the version query")
+		}
+		if answer.ProtocolVersion() > c.a.Max {
+			t.Fatalf("%s answered %s in version %d", c.b, c.a, answer.ProtocolVersion())
+		}
+		if a.unsafeProcessMessage(answer) != nil {
+			t.Fatal("an answer should not get an answer")
+		}
+		if a.PeerVersion(bKey) != c.version || b.PeerVersion(aKey) != c.version {
+			t.Fatalf("%s and %s should agree on %d but got %d and %d",
+				c.a, c.b, c.version, a.PeerVersion(bKey), b.PeerVersion(aKey))
+		}
+
+		// What b broadcasts gets to a in the version they agreed on
+		sm := util.NewSignedMessage(&PromotionMessage{I: 1}, kps[1])
+		sent := b.signFor(aKey, sm)
+		if c.version == 0 {
+			if sent != nil {
+				t.Fatalf("%s should not send to %s", c.b, c.a)
+			}
+			continue
+		}
+		if sent.ProtocolVersion() != c.version {
+			t.Fatalf("%s sent %s version %d", c.b, c.a, sent.ProtocolVersion())
+		}
+		if _, err := util.NewSignedMessageFromSerialized(sent.Serialize()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestUnannouncedPeerVersion(t *testing.T) {
+	s, kps := newIdleServer(t, 2)
+	peer := kps[1].PublicKey().String()
+	if s.PeerVersion(peer) != util.ProtocolVersion {
+		t.Fatal("a peer we haven't heard from should get our newest version")
+	}
+
+	// A peer from before version negotiation just sends its own version
+	s.unsafeProcessMessage(util.NewSignedMessageForVersion(&PromotionMessage{I: 1}, kps[1], 2))
+	if s.PeerVersion(peer) != 2 {
+		t.Fatalf("expected version 2 but got %d", s.PeerVersion(peer))
+	}
+	if s.signFor(peer, util.NewSignedMessage(&PromotionMessage{I: 1}, kps[0])).ProtocolVersion() != 2 {
+		t.Fatal("messages to an old peer should be signed in its version")
+	}
+
+	// Clients get answers in the version they asked in
+	query := util.NewSignedMessageForVersion(&PeersMessage{}, util.NewKeyPair(), 2)
+	if response := s.unsafeProcessMessage(query); response.Version != 2 {
+		t.Fatalf("answered in version %d", response.Version)
+	}
+}
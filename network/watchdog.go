@@ -0,0 +1,147 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// The watchdog notices when consensus stops making progress. Slots only get
+// externalized when there are operations to put in them, so a quiet network
+// is not stalled. The node is stalled when operations are pending but no slot
+// has been externalized for StallFactor * TargetSlotTime.
+// Each stall is reported once: it is logged as JSON, counted in /statusz,
+// available from /stallz, and sent to every webhook that isn't restricted to
+// particular accounts.
+
+// How long we expect a slot to take when the network is healthy
+const DefaultTargetSlotTime = 5 * time.Second
+
+// How many target slot times can pass without progress before it's a stall
+const DefaultStallFactor = 6
+
+// A StallReport describes a node that is stuck on a slot, along with what it
+// knows about its peers, so that operators can tell which validators to look at.
+type StallReport struct {
+	// The public key of the node reporting the stall
+	Node string `json:"node"`
+
+	// The slot the node is stuck on
+	Slot int `json:"slot"`
+
+	// When the node last externalized a slot, or started if it hasn't yet
+	LastProgress time.Time `json:"lastProgress"`
+
+	// How long the node has been stuck, in nanoseconds
+	Duration time.Duration `json:"duration"`
+
+	// How many operations are waiting to get into a block
+	Pending int `json:"pending"`
+
+	// The validators we haven't heard from since the last progress
+	Silent []string `json:"silent"`
+
+	// Whether the validators we have heard from, including ourselves if we
+	// are a validator, could make a quorum on their own. If they could, the
+	// problem is probably not connectivity.
+	QuorumReachable bool `json:"quorumReachable"`
+
+	Peers []*PeerStats `json:"peers"`
+}
+
+func (r *StallReport) String() string {
+	return fmt.Sprintf("stuck on slot %d for %s with %d pending, %d silent validators, "+
+		"quorum reachable: %t", r.Slot, r.Duration.Round(time.Millisecond), r.Pending,
+		len(r.Silent), r.QuorumReachable)
+}
+
+// stallHistory is read by the http goroutines, so it is threadsafe.
+type stallHistory struct {
+	mutex sync.Mutex
+	count int
+	last  *StallReport
+}
+
+func (h *stallHistory) add(report *StallReport) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.count++
+	h.last = report
+}
+
+// get returns how many stalls there have been, and the most recent one.
+func (h *stallHistory) get() (int, *StallReport) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.count, h.last
+}
+
+// Stalls returns how many stalls the server has seen, and the report for the
+// most recent one, which is nil if there hasn't been any.
+func (s *Server) Stalls() (int, *StallReport) {
+	return s.stalls.get()
+}
+
+// unsafeStallReport returns a report if the node is stalled, or nil if it isn't.
+func (s *Server) unsafeStallReport(now time.Time) *StallReport {
+	pending := s.node.queue.Size()
+	stuck := now.Sub(s.lastProgress)
+	if pending == 0 || stuck <= time.Duration(s.StallFactor)*s.TargetSlotTime {
+		return nil
+	}
+
+	self := s.keyPair.PublicKey().String()
+	qs := s.node.queue.QuorumSlice()
+	heard := []string{}
+	silent := []string{}
+	for _, member := range qs.Members {
+		if member == self || s.lastHeard[member].After(s.lastProgress) {
+			heard = append(heard, member)
+		} else {
+			silent = append(silent, member)
+		}
+	}
+	return &StallReport{
+		Node:            self,
+		Slot:            s.node.Slot(),
+		LastProgress:    s.lastProgress,
+		Duration:        stuck,
+		Pending:         pending,
+		Silent:          silent,
+		QuorumReachable: qs.SatisfiedWith(heard),
+		Peers:           s.PeerStats(),
+	}
+}
+
+// unsafeCheckStall reports a stall, if there is a new one.
+func (s *Server) unsafeCheckStall() {
+	if s.stallReported == s.node.Slot() {
+		return
+	}
+	report := s.unsafeStallReport(time.Now())
+	if report == nil {
+		return
+	}
+	s.stallReported = report.Slot
+	s.stalls.add(report)
+	encoded, err := json.Marshal(report)
+	if err != nil {
+		panic(err)
+	}
+	s.Logf("consensus stall: %s", encoded)
+	for _, w := range s.webhooks {
+		w.notifyStall(report)
+	}
+	s.alerts.raise(AlertStall, SeverityCritical, AlertStall, "%s", report)
+}
+
+// unsafeMadeProgress should be called whenever the node externalizes a slot.
+func (s *Server) unsafeMadeProgress() {
+	if s.stallReported != 0 {
+		s.Logf("recovered from the stall on slot %d after %s", s.stallReported,
+			time.Since(s.lastProgress).Round(time.Millisecond))
+		s.stallReported = 0
+	}
+	s.lastProgress = time.Now()
+}
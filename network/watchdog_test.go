@@ -0,0 +1,53 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lacker/coinkit/util"
+)
+
+func TestStallWatchdog(t *testing.T) {
+	receiver, received := webhookReceiver(t, "secret", 0)
+	defer receiver.Close()
+
+	s, kps := newIdleServer(t, 4)
+	defer s.Stop()
+	s.AddWebhook(&WebhookConfig{URL: receiver.URL, Secret: "secret"})
+
+	s.lastProgress = time.Now().Add(-time.Hour)
+	s.unsafeCheckStall()
+	if count, _ := s.Stalls(); count != 0 {
+		t.Fatal("with nothing pending, the node is not stalled")
+	}
+
+	mint := util.NewKeyPairFromSecretPhrase("mint")
+	bob := util.NewKeyPairFromSecretPhrase("bob")
+	s.node.Handle(mint.PublicKey().String(), newSendMessage(mint, bob, 1, 10))
+	s.lastHeard[kps[1].PublicKey().String()] = time.Now()
+	s.unsafeCheckStall()
+	s.unsafeCheckStall()
+	count, report := s.Stalls()
+	if count != 1 {
+		t.Fatalf("expected the stall to be reported once but got %d", count)
+	}
+	if report.Slot != 1 || report.Pending != 1 || len(report.Silent) != 2 ||
+		report.QuorumReachable || len(report.Peers) != 3 {
+		t.Fatalf("bad report: %+v", report)
+	}
+
+	select {
+	case payload := <-received:
+		if payload.Event != WebhookEventStall || payload.Stall == nil ||
+			len(payload.Stall.Silent) != 2 {
+			t.Fatalf("bad payload: %+v", payload)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the webhook was never notified")
+	}
+
+	s.unsafeMadeProgress()
+	if s.stallReported != 0 || s.unsafeStallReport(time.Now()) != nil {
+		t.Fatal("progress should end the stall")
+	}
+}
@@ -0,0 +1,246 @@
+package network
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/data"
+	"github.com/lacker/coinkit/util"
+)
+
+// Webhooks let a service react to chain activity without keeping a connection
+// open to a node. The node POSTs a JSON WebhookPayload to each webhook url.
+//
+// Every request is signed with HMAC-SHA256, keyed by the webhook's secret,
+// over the request body. The signature is in the X-Coinkit-Signature header
+// as "sha256=<hex>". Receivers should check it with VerifyWebhookSignature
+// before trusting the payload.
+//
+// Deliveries to a single url happen in order. A delivery that fails is retried
+// with exponential backoff, and dropped after webhookMaxAttempts attempts.
+
+const (
+	// Events a webhook can be notified of
+	WebhookEventBlock    = "block"
+	WebhookEventAccounts = "accounts"
+	WebhookEventStall    = "stall"
+
+	webhookSignatureHeader = "X-Coinkit-Signature"
+	webhookEventHeader     = "X-Coinkit-Event"
+	webhookNodeHeader      = "X-Coinkit-Node"
+
+	webhookMaxAttempts = 6
+	webhookTimeout     = 10 * time.Second
+
+	// How many deliveries can wait for a slow url before new ones get dropped
+	webhookQueueSize = 100
+)
+
+type WebhookConfig struct {
+	// Where to POST to
+	URL string
+
+	// The HMAC key for signing requests
+	Secret string
+
+	// If Accounts is empty, the webhook is notified of every new block, and
+	// of consensus stalls.
+	// Otherwise, it is only notified when one of these accounts changes, and
+	// the payload only includes these accounts.
+	Accounts []string
+}
+
+// WebhookPayload is the body of a webhook request.
+type WebhookPayload struct {
+	// WebhookEventBlock, WebhookEventAccounts, or WebhookEventStall
+	Event string `json:"event"`
+
+	Slot int `json:"slot"`
+
+	// The operations in the block. Only set for block events.
+	Operations []*util.SignedOperation `json:"operations,omitempty"`
+
+	// The state of the changed accounts right after this block
+	Accounts map[string]*currency.Account `json:"accounts"`
+
+	// What the node knows about the stall. Only set for stall events.
+	Stall *StallReport `json:"stall,omitempty"`
+}
+
+// A webhook delivers payloads for one WebhookConfig, in its own goroutine.
+type webhook struct {
+	config  *WebhookConfig
+	watched map[string]bool
+
+	// The public key of the node, to identify who sent the request
+	node string
+
+	queue  chan *WebhookPayload
+	quit   chan bool
+	client *http.Client
+
+	// How long to wait after the first failed attempt. It doubles each time.
+	initialBackoff time.Duration
+}
+
+func newWebhook(config *WebhookConfig, node string, quit chan bool) *webhook {
+	watched := make(map[string]bool)
+	for _, account := range config.Accounts {
+		watched[account] = true
+	}
+	return &webhook{
+		config:         config,
+		watched:        watched,
+		node:           node,
+		queue:          make(chan *WebhookPayload, webhookQueueSize),
+		quit:           quit,
+		client:         &http.Client{Timeout: webhookTimeout},
+		initialBackoff: time.Second,
+	}
+}
+
+// payload returns what to send this webhook about a block, or nil if the
+// webhook doesn't care about this block.
+func (w *webhook) payload(b *data.Block) *WebhookPayload {
+	if b.Chunk == nil {
+		return nil
+	}
+	if len(w.watched) == 0 {
+		return &WebhookPayload{
+			Event:      WebhookEventBlock,
+			Slot:       b.Slot,
+			Operations: b.Chunk.Operations,
+			Accounts:   b.Chunk.State,
+		}
+	}
+	accounts := make(map[string]*currency.Account)
+	for owner, account := range b.Chunk.State {
+		if w.watched[owner] {
+			accounts[owner] = account
+		}
+	}
+	if len(accounts) == 0 {
+		return nil
+	}
+	return &WebhookPayload{
+		Event:    WebhookEventAccounts,
+		Slot:     b.Slot,
+		Accounts: accounts,
+	}
+}
+
+// notify queues up a notification for a block, if the webhook wants one.
+// It never blocks. If the queue is full the notification is dropped.
+func (w *webhook) notify(b *data.Block) {
+	payload := w.payload(b)
+	if payload == nil {
+		return
+	}
+	w.enqueue(payload)
+}
+
+// notifyStall queues up a notification for a stall, if the webhook wants one.
+// It never blocks.
+func (w *webhook) notifyStall(report *StallReport) {
+	if len(w.watched) > 0 {
+		return
+	}
+	w.enqueue(&WebhookPayload{
+		Event: WebhookEventStall,
+		Slot:  report.Slot,
+		Stall: report,
+	})
+}
+
+// enqueue drops the payload if the queue is full.
+func (w *webhook) enqueue(payload *WebhookPayload) {
+	select {
+	case w.queue <- payload:
+	default:
+		util.Logger.Printf("webhook queue for %s is full, dropping %s for slot %d",
+			w.config.URL, payload.Event, payload.Slot)
+	}
+}
+
+// deliverForever should be run in its own goroutine.
+func (w *webhook) deliverForever() {
+	for {
+		select {
+		case <-w.quit:
+			return
+		case payload := <-w.queue:
+			w.deliver(payload)
+		}
+	}
+}
+
+// deliver sends one payload, retrying with backoff until it succeeds, it runs
+// out of attempts, or the server shuts down.
+func (w *webhook) deliver(payload *WebhookPayload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		panic(err)
+	}
+	backoff := w.initialBackoff
+	for attempt := 1; ; attempt++ {
+		err := w.post(payload.Event, body)
+		if err == nil {
+			return
+		}
+		if attempt >= webhookMaxAttempts {
+			util.Logger.Printf("giving up on webhook %s for slot %d: %s",
+				w.config.URL, payload.Slot, err)
+			return
+		}
+		select {
+		case <-w.quit:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (w *webhook) post(event string, body []byte) error {
+	request, err := http.NewRequest("POST", w.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(webhookEventHeader, event)
+	request.Header.Set(webhookNodeHeader, w.node)
+	request.Header.Set(webhookSignatureHeader, SignWebhook(w.config.Secret, body))
+	response, err := w.client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", response.StatusCode)
+	}
+	return nil
+}
+
+// SignWebhook returns the signature header value for a webhook request body.
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature checks the signature header of a webhook request.
+func VerifyWebhookSignature(secret string, body []byte, signature string) bool {
+	if !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	expected := SignWebhook(secret, body)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
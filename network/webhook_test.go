@@ -0,0 +1,149 @@
+package network
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lacker/coinkit/currency"
+	"github.com/lacker/coinkit/data"
+	"github.com/lacker/coinkit/util"
+)
+
+func TestWebhookSignature(t *testing.T) {
+	body := []byte(`{"slot":3}`)
+	signature := SignWebhook("secret", body)
+	if !VerifyWebhookSignature("secret", body, signature) {
+		t.Fatalf("a signature should verify")
+	}
+	if VerifyWebhookSignature("other", body, signature) {
+		t.Fatalf("a signature should not verify with the wrong secret")
+	}
+	if VerifyWebhookSignature("secret", []byte(`{"slot":4}`), signature) {
+		t.Fatalf("a signature should not verify with a different body")
+	}
+	if VerifyWebhookSignature("secret", body, signature[len("sha256="):]) {
+		t.Fatalf("a signature should need its prefix")
+	}
+}
+
+func TestWebhookPayload(t *testing.T) {
+	bob := util.NewKeyPairFromSecretPhrase("bob").PublicKey().String()
+	carol := util.NewKeyPairFromSecretPhrase("carol").PublicKey().String()
+	dave := util.NewKeyPairFromSecretPhrase("dave").PublicKey().String()
+	chunk := currency.NewEmptyChunk()
+	chunk.State[bob] = &currency.Account{Sequence: 1, Balance: 10}
+	chunk.State[carol] = &currency.Account{Sequence: 0, Balance: 20}
+	b := &data.Block{Slot: 5, Chunk: chunk}
+
+	all := newWebhook(&WebhookConfig{URL: "http://x"}, "node", nil).payload(b)
+	if all.Event != WebhookEventBlock || all.Slot != 5 || len(all.Accounts) != 2 {
+		t.Fatalf("bad block payload: %+v", all)
+	}
+
+	watchDave := &WebhookConfig{URL: "http://x", Accounts: []string{dave}}
+	if p := newWebhook(watchDave, "node", nil).payload(b); p != nil {
+		t.Fatalf("expected no payload for an unchanged account but got %+v", p)
+	}
+
+	watchBob := &WebhookConfig{URL: "http://x", Accounts: []string{bob, dave}}
+	p := newWebhook(watchBob, "node", nil).payload(b)
+	if p.Event != WebhookEventAccounts || len(p.Accounts) != 1 ||
+		p.Accounts[bob].Balance != 10 {
+		t.Fatalf("bad accounts payload: %+v", p)
+	}
+}
+
+// webhookReceiver records valid webhook payloads, failing the first few
+// requests it gets.
+func webhookReceiver(t *testing.T, secret string, failures int) (
+	*httptest.Server, chan *WebhookPayload) {
+	received := make(chan *WebhookPayload, 10)
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if failures > 0 {
+				failures--
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !VerifyWebhookSignature(secret, body, r.Header.Get(webhookSignatureHeader)) {
+				t.Errorf("bad webhook signature")
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			payload := &WebhookPayload{}
+			if err := json.Unmarshal(body, payload); err != nil {
+				t.Errorf("bad webhook body: %s", body)
+			}
+			received <- payload
+		}))
+	return server, received
+}
+
+func TestWebhookRetries(t *testing.T) {
+	receiver, received := webhookReceiver(t, "secret", 2)
+	defer receiver.Close()
+
+	quit := make(chan bool)
+	defer close(quit)
+	w := newWebhook(&WebhookConfig{URL: receiver.URL, Secret: "secret"}, "node", quit)
+	w.initialBackoff = time.Millisecond
+	go w.deliverForever()
+
+	w.notify(&data.Block{Slot: 1, Chunk: currency.NewEmptyChunk()})
+	w.notify(&data.Block{Slot: 2, Chunk: currency.NewEmptyChunk()})
+	for slot := 1; slot <= 2; slot++ {
+		select {
+		case payload := <-received:
+			if payload.Slot != slot {
+				t.Fatalf("expected slot %d but got %+v", slot, payload)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("slot %d was never delivered", slot)
+		}
+	}
+}
+
+func TestWebhookWatchesAccount(t *testing.T) {
+	receiver, received := webhookReceiver(t, "secret", 0)
+	defer receiver.Close()
+
+	mint := util.NewKeyPairFromSecretPhrase("mint")
+	bob := util.NewKeyPairFromSecretPhrase("bob")
+	config, kps := NewUnitTestNetwork()
+	servers := []*Server{}
+	for _, kp := range kps {
+		servers = append(servers, NewServer(kp, config, nil))
+	}
+	servers[0].AddWebhook(&WebhookConfig{
+		URL:      receiver.URL,
+		Secret:   "secret",
+		Accounts: []string{bob.PublicKey().String()},
+	})
+	for _, server := range servers {
+		server.ServeInBackground()
+	}
+	defer stopServers(servers)
+
+	conn := NewRedialConnection(servers[0].LocalhostAddress(), nil)
+	defer conn.Close()
+	sendMoney(conn, mint, bob, 100)
+
+	select {
+	case payload := <-received:
+		account := payload.Accounts[bob.PublicKey().String()]
+		if payload.Event != WebhookEventAccounts || len(payload.Accounts) != 1 ||
+			account.Balance != 100 {
+			t.Fatalf("bad payload: %+v", payload)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("the webhook was never notified")
+	}
+}
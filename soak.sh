@@ -0,0 +1,21 @@
+#!/bin/bash
+
+# Runs a local network under load for hours, killing nodes and cutting them
+# off from each other, and fails if the nodes diverge or can't catch up.
+# Extra flags are passed along to cserver soak.
+
+if [ `pwd | sed s/.*src//` != "/github.com/lacker/coinkit" ]; then
+    echo "please run this from the coinkit directory"
+    exit 1
+fi
+
+echo rebuilding binaries...
+go install ./...
+
+if [ $? -ne 0 ]
+then
+    echo "not soaking due to error"
+    exit 1
+fi
+
+cserver soak --duration=4h --kill --partition "$@"
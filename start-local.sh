@@ -31,11 +31,7 @@ fi
 
 for i in `seq 0 3`;
 do
-    ARGS="--database=./local/database$i.json"
-    ARGS="$ARGS --keypair=./local/keypair$i.json"
-    ARGS="$ARGS --network=./local/network.json"
-    ARGS="$ARGS --http=800$i"
-    nohup cserver $ARGS &> $LOGS/cserver$i.log &
+    nohup cserver --config=./local/node$i.toml &> $LOGS/cserver$i.log &
 done
 
 sleep 0.1
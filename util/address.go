@@ -0,0 +1,173 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Addresses are the form of a public key that people copy around. They are
+// bech32, as in BIP 173, with a human-readable prefix for the network, so a
+// typo is caught by the checksum and an address for one network can't be
+// used on another by mistake.
+// Operations and account state still use PublicKey.String, the 0x form, so
+// that every account has exactly one key.
+
+// DefaultAddressPrefix is the prefix for networks that don't set their own.
+const DefaultAddressPrefix = "coin"
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+func bech32Polymod(values []byte) uint32 {
+	generator := []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32ExpandPrefix(prefix string) []byte {
+	answer := []byte{}
+	for _, c := range []byte(prefix) {
+		answer = append(answer, c>>5)
+	}
+	answer = append(answer, 0)
+	for _, c := range []byte(prefix) {
+		answer = append(answer, c&31)
+	}
+	return answer
+}
+
+func bech32Checksum(prefix string, data []byte) []byte {
+	values := append(bech32ExpandPrefix(prefix), data...)
+	values = append(values, 0, 0, 0, 0, 0, 0)
+	mod := bech32Polymod(values) ^ 1
+	answer := make([]byte, 6)
+	for i := range answer {
+		answer[i] = byte(mod>>uint(5*(5-i))) & 31
+	}
+	return answer
+}
+
+// convertBits regroups a slice of fromBits-bit values into toBits-bit values.
+func convertBits(data []byte, fromBits uint, toBits uint, pad bool) ([]byte, error) {
+	acc := uint32(0)
+	bits := uint(0)
+	answer := []byte{}
+	maxv := uint32(1)<<toBits - 1
+	for _, value := range data {
+		if uint32(value)>>fromBits != 0 {
+			return nil, errors.New("invalid data")
+		}
+		acc = acc<<fromBits | uint32(value)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			answer = append(answer, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			answer = append(answer, byte(acc<<(toBits-bits)&maxv))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&maxv != 0 {
+		return nil, errors.New("invalid padding")
+	}
+	return answer, nil
+}
+
+// encodeBech32 encodes 5-bit values with a prefix.
+func encodeBech32(prefix string, data []byte) string {
+	combined := append(data, bech32Checksum(prefix, data)...)
+	var b strings.Builder
+	b.WriteString(prefix)
+	b.WriteByte('1')
+	for _, d := range combined {
+		b.WriteByte(bech32Charset[d])
+	}
+	return b.String()
+}
+
+// decodeBech32 returns the prefix and 5-bit values of a bech32 string.
+func decodeBech32(input string) (string, []byte, error) {
+	if len(input) > 90 {
+		return "", nil, errors.New("too long")
+	}
+	if strings.ToLower(input) != input && strings.ToUpper(input) != input {
+		return "", nil, errors.New("mixed case")
+	}
+	input = strings.ToLower(input)
+	separator := strings.LastIndexByte(input, '1')
+	if separator < 1 || separator+7 > len(input) {
+		return "", nil, errors.New("missing separator")
+	}
+	prefix := input[:separator]
+	for _, c := range []byte(prefix) {
+		if c < 33 || c > 126 {
+			return "", nil, errors.New("invalid prefix")
+		}
+	}
+	data := []byte{}
+	for _, c := range []byte(input[separator+1:]) {
+		d := strings.IndexByte(bech32Charset, c)
+		if d < 0 {
+			return "", nil, fmt.Errorf("invalid character %q", c)
+		}
+		data = append(data, byte(d))
+	}
+	if bech32Polymod(append(bech32ExpandPrefix(prefix), data...)) != 1 {
+		return "", nil, errors.New("bad checksum")
+	}
+	return prefix, data[:len(data)-6], nil
+}
+
+// Address encodes the public key as an address with the given prefix.
+func (pk PublicKey) Address(prefix string) string {
+	data, err := convertBits(pk.WithoutChecksum(), 8, 5, true)
+	if err != nil {
+		panic(err)
+	}
+	return encodeBech32(prefix, data)
+}
+
+// ValidAddressPrefix returns whether a prefix can be used for addresses.
+// Prefixes are short enough that deposit addresses fit in the 90 characters
+// that bech32 allows.
+func ValidAddressPrefix(prefix string) bool {
+	if len(prefix) == 0 || len(prefix) > 16 {
+		return false
+	}
+	for _, c := range prefix {
+		if c < 'a' || c > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseAddress reads either an address or a 0x public key, which is how
+// addresses used to be written. It returns the public key along with the
+// address's prefix, which is empty for a 0x public key.
+func ParseAddress(input string) (PublicKey, string, error) {
+	var invalid PublicKey
+	if strings.HasPrefix(input, "0x") {
+		pk, err := ReadPublicKey(input)
+		return pk, "", err
+	}
+	prefix, data, err := decodeBech32(input)
+	if err != nil {
+		return invalid, "", fmt.Errorf("invalid address %s: %s", input, err)
+	}
+	bytes, err := convertBits(data, 5, 8, false)
+	if err != nil || len(bytes) != 32 {
+		return invalid, "", fmt.Errorf("address %s is the wrong length", input)
+	}
+	return GeneratePublicKey(bytes), prefix, nil
+}
@@ -0,0 +1,71 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBech32(t *testing.T) {
+	// Valid checksums from BIP 173
+	for _, s := range []string{
+		"A12UEL5L",
+		"a12uel5l",
+		"an83characterlonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1tt5tgs",
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw",
+		"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w",
+	} {
+		if _, _, err := decodeBech32(s); err != nil {
+			t.Fatalf("%s should be valid: %s", s, err)
+		}
+	}
+	for _, s := range []string{
+		"pzry9x0s0muk",
+		"1pzry9x0s0muk",
+		"x1b4n0q5v",
+		"li1dgmt3",
+		"A1G7SGD8",
+		"a12UEL5L",
+	} {
+		if _, _, err := decodeBech32(s); err == nil {
+			t.Fatalf("%s should be invalid", s)
+		}
+	}
+}
+
+func TestAddress(t *testing.T) {
+	pk := NewKeyPairFromSecretPhrase("alice").PublicKey()
+	address := pk.Address(DefaultAddressPrefix)
+	if !strings.HasPrefix(address, "coin1") {
+		t.Fatalf("bad address: %s", address)
+	}
+	for _, input := range []string{address, strings.ToUpper(address), pk.String()} {
+		parsed, prefix, err := ParseAddress(input)
+		if err != nil || !parsed.Equal(pk) {
+			t.Fatalf("%s should parse to %s but got %s %v", input, pk, parsed, err)
+		}
+		if (prefix == "") != (input == pk.String()) {
+			t.Fatalf("bad prefix %q for %s", prefix, input)
+		}
+	}
+
+	// Any one typo is caught
+	typo := []byte(address)
+	if typo[10] == 'q' {
+		typo[10] = 'p'
+	} else {
+		typo[10] = 'q'
+	}
+	if _, _, err := ParseAddress(string(typo)); err == nil {
+		t.Fatalf("the typo in %s should be caught", typo)
+	}
+
+	if _, _, err := ParseAddress("coin1" + address[5:len(address)-8] + "qqqqqqqq"); err == nil {
+		t.Fatal("a made up address should not parse")
+	}
+	if ValidAddressPrefix("Coin") || ValidAddressPrefix("") || !ValidAddressPrefix("tcoin") {
+		t.Fatal("prefixes should be lowercase letters")
+	}
+	if !ValidAddressPrefix("abcdefghijklmnop") || ValidAddressPrefix("abcdefghijklmnopq") {
+		t.Fatal("prefixes should be at most 16 letters, so deposit addresses fit")
+	}
+}
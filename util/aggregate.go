@@ -0,0 +1,131 @@
+package util
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+
+	"filippo.io/edwards25519"
+)
+
+// Signatures from many keys on the same message can be half-aggregated into
+// one, which is about half the size and can be checked all at once. This is
+// the scheme from "Non-interactive half-aggregation of EdDSA and variants of
+// Schnorr signatures" by Chalkias, Garillot, Kondi and Nikolaenko.
+//
+// An ed25519 signature is a point R and a scalar s, with
+// sB = R + H(R, A, message)A for the signer's public key A. The aggregate
+// keeps every R but adds up the s values, each weighted by a hash z of all
+// the keys and R values, so no signature can be swapped out to cancel
+// another. Checking the aggregate is a single multiscalar multiplication:
+// (sum z s)B = sum z R + sum z H(R, A, message)A.
+// The aggregate is every R followed by the summed s, base64-encoded without
+// padding, and the keys have to be checked in the order they were combined.
+
+// aggregateWeights returns the weight for each signature.
+func aggregateWeights(keys [][]byte, rs [][]byte, message string) []*edwards25519.Scalar {
+	h := sha512.New()
+	h.Write([]byte("coinkit aggregate\n"))
+	h.Write([]byte(message))
+	for i := range keys {
+		h.Write(keys[i])
+		h.Write(rs[i])
+	}
+	seed := h.Sum(nil)
+	answer := []*edwards25519.Scalar{}
+	for i := range keys {
+		hi := sha512.New()
+		hi.Write(seed)
+		binary.Write(hi, binary.BigEndian, uint32(i))
+		z, err := new(edwards25519.Scalar).SetUniformBytes(hi.Sum(nil))
+		if err != nil {
+			panic(err)
+		}
+		answer = append(answer, z)
+	}
+	return answer
+}
+
+// AggregateSignatures combines signatures of message by keys, which should
+// already have been checked one by one, since the aggregate of any bad
+// signature is bad.
+func AggregateSignatures(keys []PublicKey, message string, signatures []string) (string, error) {
+	if len(keys) == 0 || len(keys) != len(signatures) {
+		return "", errors.New("need one signature for each key")
+	}
+	rawKeys := [][]byte{}
+	rs := [][]byte{}
+	ss := []*edwards25519.Scalar{}
+	for i, signature := range signatures {
+		raw, err := base64.RawStdEncoding.DecodeString(signature)
+		if err != nil || len(raw) != 64 {
+			return "", errors.New("bad signature")
+		}
+		s, err := new(edwards25519.Scalar).SetCanonicalBytes(raw[32:])
+		if err != nil {
+			return "", errors.New("bad signature")
+		}
+		rawKeys = append(rawKeys, keys[i].WithoutChecksum())
+		rs = append(rs, raw[:32])
+		ss = append(ss, s)
+	}
+	sum := edwards25519.NewScalar()
+	for i, z := range aggregateWeights(rawKeys, rs, message) {
+		sum.MultiplyAdd(z, ss[i], sum)
+	}
+	answer := []byte{}
+	for _, r := range rs {
+		answer = append(answer, r...)
+	}
+	answer = append(answer, sum.Bytes()...)
+	return base64.RawStdEncoding.EncodeToString(answer), nil
+}
+
+// VerifyAggregate checks an aggregate of signatures of message by keys, in
+// the order they were aggregated.
+func VerifyAggregate(keys []PublicKey, message string, aggregate string) bool {
+	raw, err := base64.RawStdEncoding.DecodeString(aggregate)
+	if err != nil || len(keys) == 0 || len(raw) != 32*(len(keys)+1) {
+		return false
+	}
+	sum, err := new(edwards25519.Scalar).SetCanonicalBytes(raw[32*len(keys):])
+	if err != nil {
+		return false
+	}
+	rawKeys := [][]byte{}
+	rs := [][]byte{}
+	scalars := []*edwards25519.Scalar{sum}
+	points := []*edwards25519.Point{edwards25519.NewGeneratorPoint()}
+	for i, key := range keys {
+		rawKey := key.WithoutChecksum()
+		a, err := new(edwards25519.Point).SetBytes(rawKey)
+		if err != nil {
+			return false
+		}
+		r := raw[32*i : 32*(i+1)]
+		rPoint, err := new(edwards25519.Point).SetBytes(r)
+		if err != nil {
+			return false
+		}
+		rawKeys = append(rawKeys, rawKey)
+		rs = append(rs, r)
+		points = append(points, rPoint, a)
+	}
+	for i, z := range aggregateWeights(rawKeys, rs, message) {
+		h := sha512.New()
+		h.Write(rs[i])
+		h.Write(rawKeys[i])
+		h.Write([]byte(message))
+		k, err := new(edwards25519.Scalar).SetUniformBytes(h.Sum(nil))
+		if err != nil {
+			panic(err)
+		}
+		zk := new(edwards25519.Scalar).Multiply(z, k)
+		scalars = append(scalars, new(edwards25519.Scalar).Negate(z), zk.Negate(zk))
+	}
+	// Checking with the cofactor matches ed25519 batch verification
+	check := new(edwards25519.Point).VarTimeMultiScalarMult(scalars, points)
+	check.MultByCofactor(check)
+	return check.Equal(edwards25519.NewIdentityPoint()) == 1
+}
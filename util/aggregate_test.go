@@ -0,0 +1,51 @@
+package util
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestAggregateSignatures(t *testing.T) {
+	message := "checkpoint 100 root"
+	keys := []PublicKey{}
+	signatures := []string{}
+	for i := 0; i < 5; i++ {
+		kp := NewKeyPairFromSecretPhrase(fmt.Sprintf("node%d", i))
+		keys = append(keys, kp.PublicKey())
+		signatures = append(signatures, kp.Sign(message))
+	}
+	aggregate, err := AggregateSignatures(keys, message, signatures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aggregate) >= len(signatures[0])*len(signatures) {
+		t.Fatal("the aggregate should be smaller than the signatures")
+	}
+	if !VerifyAggregate(keys, message, aggregate) {
+		t.Fatal("the aggregate should verify")
+	}
+	if VerifyAggregate(keys, "checkpoint 100 other", aggregate) {
+		t.Fatal("the aggregate should not verify a different message")
+	}
+	swapped := append([]PublicKey{keys[1], keys[0]}, keys[2:]...)
+	if VerifyAggregate(swapped, message, aggregate) {
+		t.Fatal("the keys have to be in order")
+	}
+	if VerifyAggregate(keys[:4], message, aggregate) {
+		t.Fatal("every key has to be there")
+	}
+
+	// One signature from the wrong key spoils the aggregate
+	signatures[2] = NewKeyPairFromSecretPhrase("impostor").Sign(message)
+	bad, err := AggregateSignatures(keys, message, signatures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if VerifyAggregate(keys, message, bad) {
+		t.Fatal("an aggregate with a bad signature should not verify")
+	}
+
+	if _, err := AggregateSignatures(keys, message, []string{"garbage"}); err == nil {
+		t.Fatal("garbage should not aggregate")
+	}
+}
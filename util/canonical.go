@@ -0,0 +1,118 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// CanonicalJSON rewrites a JSON value in the form that gets signed, so that
+// signatures don't depend on how an implementation orders keys or spaces
+// things out. The canonical form:
+//
+//   - has no whitespace outside of strings
+//   - sorts the keys of every object by their UTF-8 bytes
+//   - writes strings the way encoding/json does, without escaping <, >, and &
+//   - keeps numbers exactly as they were written
+//
+// Objects with a repeated key are rejected, since implementations disagree
+// about which value wins.
+func CanonicalJSON(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var buffer bytes.Buffer
+	if err := writeCanonical(&buffer, decoder); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the json value")
+	}
+	return buffer.Bytes(), nil
+}
+
+// writeCanonical reads one value from the decoder and writes it out in
+// canonical form.
+func writeCanonical(buffer *bytes.Buffer, decoder *json.Decoder) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	switch t := token.(type) {
+	case json.Delim:
+		if t == '[' {
+			buffer.WriteByte('[')
+			for i := 0; decoder.More(); i++ {
+				if i > 0 {
+					buffer.WriteByte(',')
+				}
+				if err := writeCanonical(buffer, decoder); err != nil {
+					return err
+				}
+			}
+			_, err := decoder.Token()
+			buffer.WriteByte(']')
+			return err
+		}
+
+		// It's an object. Buffer each value so they can be sorted by key
+		values := make(map[string][]byte)
+		keys := []string{}
+		for decoder.More() {
+			token, err := decoder.Token()
+			if err != nil {
+				return err
+			}
+			key := token.(string)
+			if _, ok := values[key]; ok {
+				return fmt.Errorf("repeated key: %q", key)
+			}
+			var value bytes.Buffer
+			if err := writeCanonical(&value, decoder); err != nil {
+				return err
+			}
+			values[key] = value.Bytes()
+			keys = append(keys, key)
+		}
+		if _, err := decoder.Token(); err != nil {
+			return err
+		}
+		sort.Strings(keys)
+		buffer.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buffer.WriteByte(',')
+			}
+			writeString(buffer, key)
+			buffer.WriteByte(':')
+			buffer.Write(values[key])
+		}
+		buffer.WriteByte('}')
+
+	case string:
+		writeString(buffer, t)
+
+	case json.Number:
+		buffer.WriteString(t.String())
+
+	case bool:
+		if t {
+			buffer.WriteString("true")
+		} else {
+			buffer.WriteString("false")
+		}
+
+	case nil:
+		buffer.WriteString("null")
+	}
+	return nil
+}
+
+func writeString(buffer *bytes.Buffer, s string) {
+	var encoded bytes.Buffer
+	encoder := json.NewEncoder(&encoded)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s)
+	buffer.Write(bytes.TrimSuffix(encoded.Bytes(), []byte("\n")))
+}
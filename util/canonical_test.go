@@ -0,0 +1,97 @@
+package util
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+)
+
+type operationVector struct {
+	Secret    string
+	Type      string
+	Operation string
+	Payload   string
+	Signature string
+}
+
+type signingVectors struct {
+	Canonical []struct {
+		Input  string
+		Output string
+	}
+	Invalid          []string
+	Operations       []operationVector
+	LegacyOperations []operationVector
+	Messages         []struct {
+		Secret     string
+		Payload    string
+		Serialized string
+	}
+}
+
+func readSigningVectors(t *testing.T) *signingVectors {
+	bytes, err := ioutil.ReadFile("testdata/signing.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vectors := &signingVectors{}
+	if err := json.Unmarshal(bytes, vectors); err != nil {
+		t.Fatal(err)
+	}
+	return vectors
+}
+
+func TestCanonicalJSON(t *testing.T) {
+	vectors := readSigningVectors(t)
+	for _, v := range vectors.Canonical {
+		output, err := CanonicalJSON([]byte(v.Input))
+		if err != nil || string(output) != v.Output {
+			t.Fatalf("expected %s -> %s but got %s %v", v.Input, v.Output, output, err)
+		}
+		again, err := CanonicalJSON(output)
+		if err != nil || string(again) != v.Output {
+			t.Fatalf("canonical json should not change: %s -> %s", output, again)
+		}
+	}
+	for _, input := range vectors.Invalid {
+		if output, err := CanonicalJSON([]byte(input)); err == nil {
+			t.Fatalf("expected %q to be rejected but got %s", input, output)
+		}
+	}
+}
+
+func TestSigningVectors(t *testing.T) {
+	vectors := readSigningVectors(t)
+	for _, v := range vectors.Operations {
+		kp := NewKeyPairFromSecretPhrase(v.Secret)
+		payload, err := operationPayload(v.Type, []byte(v.Operation))
+		if err != nil || payload != v.Payload {
+			t.Fatalf("expected payload %s but got %s %v", v.Payload, payload, err)
+		}
+		if kp.Sign(payload) != v.Signature {
+			t.Fatalf("bad signature for %s", payload)
+		}
+		if !verifyOperation(kp.PublicKey(), v.Type, []byte(v.Operation), v.Signature) {
+			t.Fatalf("the signature for %s should verify", v.Operation)
+		}
+	}
+	for _, v := range vectors.LegacyOperations {
+		kp := NewKeyPairFromSecretPhrase(v.Secret)
+		if !verifyOperation(kp.PublicKey(), v.Type, []byte(v.Operation), v.Signature) {
+			t.Fatalf("the legacy signature for %s should verify", v.Operation)
+		}
+	}
+	for _, v := range vectors.Messages {
+		sm, err := NewSignedMessageFromSerialized(v.Serialized)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sm.Signer() != NewKeyPairFromSecretPhrase(v.Secret).PublicKey().String() {
+			t.Fatalf("bad signer %s", sm.Signer())
+		}
+		content, err := signedContent(sm.version, sm.timestamp, sm.Encoded())
+		if err != nil || content != v.Payload {
+			t.Fatalf("expected payload %s but got %s %v", v.Payload, content, err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package util
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Deposit accounts let an exchange give each of its users a different address
+// to deposit to, without a key for each one. A deposit account is a public
+// key along with a numeric tag, written as 0x<key>/<tag> in operations.
+// Money sent to a deposit account is credited to the public key's account
+// when the block is applied, and the tag is kept in the account's history.
+// Nobody has to sweep the deposits, and since the parent key is part of the
+// deposit account, a depositor can see exactly where the money goes.
+//
+// As an address, a deposit account is the 32 bytes of the public key followed
+// by the tag as 8 big-endian bytes.
+
+// DepositAccount returns the deposit account for a public key and a tag.
+func DepositAccount(pk PublicKey, tag uint64) string {
+	return fmt.Sprintf("%s/%d", pk, tag)
+}
+
+// ReadAccount reads an account that money can be sent to, which is either a
+// public key or a deposit account. It returns the public key that gets
+// credited and whether there is a tag.
+func ReadAccount(account string) (PublicKey, uint64, bool, error) {
+	parts := strings.SplitN(account, "/", 2)
+	pk, err := ReadPublicKey(parts[0])
+	if err != nil || len(parts) == 1 {
+		return pk, 0, false, err
+	}
+	tag, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil || strconv.FormatUint(tag, 10) != parts[1] {
+		return pk, 0, false, fmt.Errorf("invalid deposit tag: %s", parts[1])
+	}
+	return pk, tag, true, nil
+}
+
+// DepositAddress encodes a deposit account as an address with the given
+// prefix.
+func (pk PublicKey) DepositAddress(prefix string, tag uint64) string {
+	raw := make([]byte, 40)
+	copy(raw, pk.WithoutChecksum())
+	binary.BigEndian.PutUint64(raw[32:], tag)
+	data, err := convertBits(raw, 8, 5, true)
+	if err != nil {
+		panic(err)
+	}
+	return encodeBech32(prefix, data)
+}
+
+// AccountAddress encodes an account that ReadAccount accepts as an address.
+// Anything else is returned unchanged.
+func AccountAddress(account string, prefix string) string {
+	pk, tag, isDeposit, err := ReadAccount(account)
+	if err != nil {
+		return account
+	}
+	if isDeposit {
+		return pk.DepositAddress(prefix, tag)
+	}
+	return pk.Address(prefix)
+}
+
+// ParseAccountAddress is like ParseAddress, but it also accepts deposit
+// addresses and deposit accounts. It returns the account as it is written in
+// operations, along with the address's prefix.
+func ParseAccountAddress(input string) (string, string, error) {
+	if strings.HasPrefix(input, "0x") {
+		if _, _, _, err := ReadAccount(input); err != nil {
+			return "", "", err
+		}
+		return input, "", nil
+	}
+	prefix, data, err := decodeBech32(input)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid address %s: %s", input, err)
+	}
+	raw, err := convertBits(data, 5, 8, false)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid address %s: %s", input, err)
+	}
+	switch len(raw) {
+	case 32:
+		return GeneratePublicKey(raw).String(), prefix, nil
+	case 40:
+		pk := GeneratePublicKey(raw[:32])
+		return DepositAccount(pk, binary.BigEndian.Uint64(raw[32:])), prefix, nil
+	}
+	return "", "", fmt.Errorf("address %s is the wrong length", input)
+}
@@ -0,0 +1,39 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestDepositAccounts(t *testing.T) {
+	pk := NewKeyPairFromSecretPhrase("exchange").PublicKey()
+	account := DepositAccount(pk, 7)
+	parent, tag, isDeposit, err := ReadAccount(account)
+	if err != nil || !parent.Equal(pk) || tag != 7 || !isDeposit {
+		t.Fatalf("bad deposit account %s: %s %d %v %v", account, parent, tag, isDeposit, err)
+	}
+	if _, _, isDeposit, err := ReadAccount(pk.String()); err != nil || isDeposit {
+		t.Fatal("a public key is not a deposit account")
+	}
+	for _, bad := range []string{pk.String() + "/", pk.String() + "/x",
+		pk.String() + "/07", pk.String() + "/-1", "0xbad/1"} {
+		if _, _, _, err := ReadAccount(bad); err == nil {
+			t.Fatalf("%s should not be a valid account", bad)
+		}
+	}
+
+	for _, input := range []string{account, DepositAccount(pk, ^uint64(0)), pk.String()} {
+		address := AccountAddress(input, DefaultAddressPrefix)
+		parsed, prefix, err := ParseAccountAddress(address)
+		if err != nil || parsed != input || prefix != DefaultAddressPrefix {
+			t.Fatalf("%s encoded as %s but parsed as %s %s %v",
+				input, address, parsed, prefix, err)
+		}
+	}
+	long := pk.DepositAddress("abcdefghijklmnop", 1)
+	if _, _, err := ParseAccountAddress(long); err != nil {
+		t.Fatalf("deposit addresses with the longest prefix should work: %s", err)
+	}
+	if _, _, err := ParseAddress(pk.DepositAddress(DefaultAddressPrefix, 1)); err == nil {
+		t.Fatal("ParseAddress should not accept deposit addresses")
+	}
+}
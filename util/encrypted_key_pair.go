@@ -0,0 +1,390 @@
+package util
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// A key pair file can be encrypted, so a validator's private key doesn't sit
+// on disk in plaintext. The private key is sealed with ChaCha20-Poly1305
+// under a random data key, with the public key as additional data. The data
+// key is either derived from a passphrase with scrypt, or wrapped by a KMS
+// that holds a master key somewhere else.
+// The public key stays in plaintext, so tools can tell whose key it is
+// without unlocking it.
+
+// KeyPassphraseEnv is the environment variable that holds the passphrase for
+// an encrypted key pair file, for servers that start without a terminal.
+const KeyPassphraseEnv = "COINKIT_KEY_PASSPHRASE"
+
+// The scrypt parameters for new files. Decrypting uses the ones in the file.
+const scryptN = 1 << 15
+const scryptR = 8
+const scryptP = 1
+
+// The ways a data key can be protected
+const KeyFromPassphrase = "scrypt"
+const KeyFromKMS = "kms"
+
+// ErrWrongPassphrase means an encrypted key pair file didn't open with the
+// passphrase or KMS it was given.
+var ErrWrongPassphrase = errors.New("wrong passphrase, or the key pair file is corrupt")
+
+// EncryptedKeyPair is the format of an encrypted key pair file. Binary fields
+// are base64.
+type EncryptedKeyPair struct {
+	Public string
+
+	// KeyFromPassphrase or KeyFromKMS
+	KDF string
+
+	// The scrypt parameters, for KeyFromPassphrase
+	Salt string `json:",omitempty"`
+	N    int    `json:",omitempty"`
+	R    int    `json:",omitempty"`
+	P    int    `json:",omitempty"`
+
+	// The data key, wrapped by the KMS, for KeyFromKMS
+	WrappedKey string `json:",omitempty"`
+
+	Nonce  string
+	Sealed string
+}
+
+// A KMS keeps a master key outside of this process, and uses it to wrap and
+// unwrap the data keys of encrypted key pair files.
+type KMS interface {
+	WrapKey(key []byte) ([]byte, error)
+	UnwrapKey(wrapped []byte) ([]byte, error)
+}
+
+// CommandKMS is a KMS that runs an external program, so any KMS can be
+// hooked up with a small script. The program is run with "wrap" or "unwrap"
+// appended to its arguments. It reads a base64 key on stdin and writes the
+// base64 result on stdout.
+type CommandKMS []string
+
+func (c CommandKMS) run(action string, input []byte) ([]byte, error) {
+	if len(c) == 0 {
+		return nil, errors.New("the KMS command is empty")
+	}
+	args := append(append([]string{}, c[1:]...), action)
+	cmd := exec.Command(c[0], args...)
+	cmd.Stdin = strings.NewReader(base64.StdEncoding.EncodeToString(input))
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("KMS command %s %s failed: %s", c[0], action, err)
+	}
+	answer, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(output)))
+	if err != nil {
+		return nil, fmt.Errorf("KMS command %s %s did not write base64: %s", c[0], action, err)
+	}
+	return answer, nil
+}
+
+func (c CommandKMS) WrapKey(key []byte) ([]byte, error) {
+	return c.run("wrap", key)
+}
+
+func (c CommandKMS) UnwrapKey(wrapped []byte) ([]byte, error) {
+	return c.run("unwrap", wrapped)
+}
+
+// seal makes an encrypted key pair with a new data key, and returns the data
+// key so the caller can protect it.
+func seal(kp *KeyPair) (*EncryptedKeyPair, []byte) {
+	key := make([]byte, chacha20poly1305.KeySize)
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		panic(err)
+	}
+	public := kp.publicKey.String()
+	sealed := aead.Seal(nil, nonce, kp.privateKey.Seed(), []byte(public))
+	return &EncryptedKeyPair{
+		Public: public,
+		Nonce:  base64.RawStdEncoding.EncodeToString(nonce),
+		Sealed: base64.RawStdEncoding.EncodeToString(sealed),
+	}, key
+}
+
+// passphraseKey derives the key that wraps a data key from a passphrase.
+func passphraseKey(passphrase string, salt []byte, n, r, p int) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), salt, n, r, p, chacha20poly1305.KeySize)
+}
+
+// EncryptKeyPair encrypts a key pair with a passphrase.
+func EncryptKeyPair(kp *KeyPair, passphrase string) *EncryptedKeyPair {
+	e, key := seal(kp)
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		panic(err)
+	}
+	outer, err := passphraseKey(passphrase, salt, scryptN, scryptR, scryptP)
+	if err != nil {
+		panic(err)
+	}
+	// The passphrase key is fresh for every salt, so a zero nonce is safe
+	aead, err := chacha20poly1305.New(outer)
+	if err != nil {
+		panic(err)
+	}
+	e.KDF = KeyFromPassphrase
+	e.Salt = base64.RawStdEncoding.EncodeToString(salt)
+	e.N, e.R, e.P = scryptN, scryptR, scryptP
+	e.WrappedKey = base64.RawStdEncoding.EncodeToString(
+		aead.Seal(nil, make([]byte, chacha20poly1305.NonceSize), key, []byte(e.Public)))
+	return e
+}
+
+// EncryptKeyPairWithKMS encrypts a key pair with a data key that the KMS wraps.
+func EncryptKeyPairWithKMS(kp *KeyPair, kms KMS) (*EncryptedKeyPair, error) {
+	e, key := seal(kp)
+	wrapped, err := kms.WrapKey(key)
+	if err != nil {
+		return nil, err
+	}
+	e.KDF = KeyFromKMS
+	e.WrappedKey = base64.RawStdEncoding.EncodeToString(wrapped)
+	return e, nil
+}
+
+func (e *EncryptedKeyPair) Serialize() []byte {
+	bytes, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return append(bytes, '\n')
+}
+
+// DeserializeEncryptedKeyPair returns nil, with no error, if the data is a
+// key pair file that isn't encrypted.
+func DeserializeEncryptedKeyPair(serialized []byte) (*EncryptedKeyPair, error) {
+	e := &EncryptedKeyPair{}
+	if err := json.Unmarshal(serialized, e); err != nil {
+		return nil, err
+	}
+	if e.Sealed == "" {
+		return nil, nil
+	}
+	if e.KDF != KeyFromPassphrase && e.KDF != KeyFromKMS {
+		return nil, fmt.Errorf("unknown key pair encryption: %q", e.KDF)
+	}
+	return e, nil
+}
+
+// decrypt opens the private key with the data key.
+func (e *EncryptedKeyPair) decrypt(key []byte) (*KeyPair, error) {
+	nonce, err := base64.RawStdEncoding.DecodeString(e.Nonce)
+	if err != nil || len(nonce) != chacha20poly1305.NonceSize {
+		return nil, errors.New("bad nonce")
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(e.Sealed)
+	if err != nil {
+		return nil, errors.New("bad sealed private key")
+	}
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	seed, err := aead.Open(nil, nonce, sealed, []byte(e.Public))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, ErrWrongPassphrase
+	}
+	priv := ed25519.NewKeyFromSeed(seed)
+	kp := &KeyPair{
+		publicKey:  GeneratePublicKey(priv.Public().(ed25519.PublicKey)),
+		privateKey: priv,
+	}
+	if kp.publicKey.String() != e.Public {
+		return nil, errors.New("the private key does not match the public key")
+	}
+	return kp, nil
+}
+
+// Decrypt opens a key pair encrypted with a passphrase.
+func (e *EncryptedKeyPair) Decrypt(passphrase string) (*KeyPair, error) {
+	if e.KDF != KeyFromPassphrase {
+		return nil, fmt.Errorf("the key pair is encrypted with %s, not a passphrase", e.KDF)
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(e.Salt)
+	if err != nil {
+		return nil, errors.New("bad salt")
+	}
+	wrapped, err := base64.RawStdEncoding.DecodeString(e.WrappedKey)
+	if err != nil {
+		return nil, errors.New("bad wrapped key")
+	}
+	// The parameters come from the file, so a tampered file could make
+	// scrypt use any amount of memory. No file we write needs more.
+	if e.N > scryptN || e.R > scryptR || e.P > scryptP {
+		return nil, fmt.Errorf("the scrypt parameters N=%d r=%d p=%d are too high",
+			e.N, e.R, e.P)
+	}
+	outer, err := passphraseKey(passphrase, salt, e.N, e.R, e.P)
+	if err != nil {
+		return nil, fmt.Errorf("bad scrypt parameters: %s", err)
+	}
+	aead, err := chacha20poly1305.New(outer)
+	if err != nil {
+		panic(err)
+	}
+	key, err := aead.Open(nil, make([]byte, chacha20poly1305.NonceSize), wrapped, []byte(e.Public))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return e.decrypt(key)
+}
+
+// DecryptWithKMS opens a key pair whose data key the KMS wrapped.
+func (e *EncryptedKeyPair) DecryptWithKMS(kms KMS) (*KeyPair, error) {
+	if e.KDF != KeyFromKMS {
+		return nil, fmt.Errorf("the key pair is encrypted with %s, not a KMS", e.KDF)
+	}
+	wrapped, err := base64.RawStdEncoding.DecodeString(e.WrappedKey)
+	if err != nil {
+		return nil, errors.New("bad wrapped key")
+	}
+	key, err := kms.UnwrapKey(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	return e.decrypt(key)
+}
+
+// A KeyUnlocker opens encrypted key pair files. The zero value takes the
+// passphrase from KeyPassphraseEnv, or asks for it on a terminal, and can't
+// open files that need a KMS.
+type KeyUnlocker struct {
+	// Passphrase gets the passphrase for a file. Nil means PromptPassphrase.
+	Passphrase func(filename string) (string, error)
+
+	// The KMS for files encrypted with one
+	KMS KMS
+}
+
+// Unlock opens an encrypted key pair. filename is just for prompts.
+func (u *KeyUnlocker) Unlock(e *EncryptedKeyPair, filename string) (*KeyPair, error) {
+	if e.KDF == KeyFromKMS {
+		if u.KMS == nil {
+			return nil, errors.New("the key pair is encrypted with a KMS, but there is no KMS configured")
+		}
+		return e.DecryptWithKMS(u.KMS)
+	}
+	get := u.Passphrase
+	if get == nil {
+		get = PromptPassphrase
+	}
+	passphrase, err := get(filename)
+	if err != nil {
+		return nil, err
+	}
+	return e.Decrypt(passphrase)
+}
+
+// ReadKeyPairFromFile reads a key pair file, unlocking it if it's encrypted.
+func (u *KeyUnlocker) ReadKeyPairFromFile(filename string) (*KeyPair, error) {
+	bytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	e, err := DeserializeEncryptedKeyPair(bytes)
+	if err != nil {
+		return nil, fmt.Errorf("the keypair in %s is invalid: %s", filename, err)
+	}
+	if e == nil {
+		kp, err := DeserializeKeyPair(bytes)
+		if err != nil {
+			return nil, fmt.Errorf("the keypair in %s is invalid: %s", filename, err)
+		}
+		return kp, nil
+	}
+	kp, err := u.Unlock(e, filename)
+	if err != nil {
+		return nil, fmt.Errorf("could not unlock %s: %s", filename, err)
+	}
+	return kp, nil
+}
+
+// PromptPassphrase gets the passphrase for an encrypted key pair file from
+// KeyPassphraseEnv, or if that isn't set, asks for it on the terminal.
+func PromptPassphrase(filename string) (string, error) {
+	if passphrase, ok := os.LookupEnv(KeyPassphraseEnv); ok {
+		return passphrase, nil
+	}
+	passphrase, err := AskSecret(fmt.Sprintf("enter the passphrase for %s: ", filename))
+	if err != nil {
+		return "", fmt.Errorf("%s. to start without one, set %s", err, KeyPassphraseEnv)
+	}
+	return passphrase, nil
+}
+
+// AskSecret asks for a line on the terminal without echoing it, so a
+// passphrase doesn't show on screen or stay in the scrollback. It fails if
+// stdin isn't a terminal, like AskLine.
+func AskSecret(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return "", errors.New("stdin is not a terminal")
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	secret, err := terminal.ReadPassword(fd)
+
+	// The newline wasn't echoed either
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// AskLine asks for a line on the terminal. It fails if stdin isn't a
+// terminal, rather than waiting on input that will never come.
+// It reads a byte at a time, so it doesn't take input meant for anything
+// reading stdin after it.
+// What is typed is echoed, so secrets should use AskSecret.
+func AskLine(prompt string) (string, error) {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return "", errors.New("stdin is not a terminal")
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	var line bytes.Buffer
+	b := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				break
+			}
+			line.WriteByte(b[0])
+		}
+		if err != nil {
+			if line.Len() == 0 {
+				return "", errors.New("nothing was entered")
+			}
+			break
+		}
+	}
+	return strings.TrimRight(line.String(), "\r"), nil
+}
@@ -0,0 +1,132 @@
+package util
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncryptKeyPair(t *testing.T) {
+	kp := NewKeyPairFromSecretPhrase("validator")
+	e := EncryptKeyPair(kp, "hunter2 hunter3")
+	serialized := e.Serialize()
+	if strings.Contains(string(serialized), "Private") {
+		t.Fatal("the private key should not be in the file")
+	}
+	e2, err := DeserializeEncryptedKeyPair(serialized)
+	if err != nil || e2 == nil {
+		t.Fatalf("could not read back the encrypted key pair: %v", err)
+	}
+	if e2.Public != kp.PublicKey().String() {
+		t.Fatal("the public key should be in plaintext")
+	}
+	if _, err := e2.Decrypt("hunter2"); err != ErrWrongPassphrase {
+		t.Fatalf("expected the wrong passphrase to fail but got: %v", err)
+	}
+	kp2, err := e2.Decrypt("hunter2 hunter3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(kp2.Serialize()) != string(kp.Serialize()) {
+		t.Fatal("decrypting gave a different key pair")
+	}
+
+	// Parameters past the ones we write are refused before running scrypt
+	e2.N = scryptN * 2
+	if _, err := e2.Decrypt("hunter2 hunter3"); err == nil || err == ErrWrongPassphrase {
+		t.Fatalf("a file with a bigger N should be refused but got: %v", err)
+	}
+	e2.N, e2.R = scryptN, 1<<20
+	if _, err := e2.Decrypt("hunter2 hunter3"); err == nil || err == ErrWrongPassphrase {
+		t.Fatalf("a file with a bigger r should be refused but got: %v", err)
+	}
+
+	// A key pair file that isn't encrypted isn't an EncryptedKeyPair
+	if e, err := DeserializeEncryptedKeyPair(kp.Serialize()); e != nil || err != nil {
+		t.Fatalf("a plaintext file should deserialize to nil, got %v %v", e, err)
+	}
+}
+
+// testKMS wraps keys by xoring them with its master key.
+type testKMS struct {
+	master byte
+}
+
+func (k *testKMS) WrapKey(key []byte) ([]byte, error) {
+	answer := []byte{}
+	for _, b := range key {
+		answer = append(answer, b^k.master)
+	}
+	return answer, nil
+}
+
+func (k *testKMS) UnwrapKey(wrapped []byte) ([]byte, error) {
+	if k.master == 0 {
+		return nil, errors.New("access denied")
+	}
+	return k.WrapKey(wrapped)
+}
+
+func TestKeyUnlocker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	kp := NewKeyPairFromSecretPhrase("validator")
+	write := func(name string, data []byte) string {
+		filename := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(filename, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+	plain := write("plain.json", kp.Serialize())
+	locked := write("locked.json", EncryptKeyPair(kp, "open sesame").Serialize())
+	kms := &testKMS{master: 7}
+	e, err := EncryptKeyPairWithKMS(kp, kms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped := write("kms.json", e.Serialize())
+
+	asked := 0
+	u := &KeyUnlocker{Passphrase: func(filename string) (string, error) {
+		asked++
+		return "open sesame", nil
+	}}
+	for _, filename := range []string{plain, locked} {
+		kp2, err := u.ReadKeyPairFromFile(filename)
+		if err != nil || kp2.PublicKey() != kp.PublicKey() {
+			t.Fatalf("could not read %s: %v", filename, err)
+		}
+	}
+	if asked != 1 {
+		t.Fatalf("the passphrase should only be asked for once, not %d times", asked)
+	}
+	if _, err := u.ReadKeyPairFromFile(wrapped); err == nil {
+		t.Fatal("a KMS file should need a KMS")
+	}
+	u.KMS = kms
+	if kp2, err := u.ReadKeyPairFromFile(wrapped); err != nil || kp2.PublicKey() != kp.PublicKey() {
+		t.Fatalf("could not read the KMS file: %v", err)
+	}
+	u.KMS = &testKMS{}
+	if _, err := u.ReadKeyPairFromFile(wrapped); err == nil {
+		t.Fatal("a KMS that refuses should fail")
+	}
+
+	// The default unlocker takes the passphrase from the environment
+	os.Setenv(KeyPassphraseEnv, "open sesame")
+	defer os.Unsetenv(KeyPassphraseEnv)
+	if _, err := ReadKeyPairFromFile(locked); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(KeyPassphraseEnv, "wrong")
+	if _, err := ReadKeyPairFromFile(locked); err == nil {
+		t.Fatal("the wrong passphrase should fail")
+	}
+}
@@ -0,0 +1,133 @@
+package util
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"math/big"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+)
+
+// Accounts can encrypt data for each other with their existing keys. An
+// ed25519 public key is a point on the Edwards form of Curve25519, so it
+// converts to an X25519 public key, and the ed25519 seed hashes to the
+// matching X25519 private key, the same way libsodium converts them.
+//
+// Encrypting makes a fresh X25519 key, and the ChaCha20-Poly1305 key is the
+// SHA-256 of the shared secret followed by both X25519 public keys. Every key
+// is used once, so the nonce is always zero. The ciphertext is the ephemeral
+// public key followed by the sealed data, base64-encoded without padding.
+
+// EncryptionOverhead is how many bytes encryption adds, before base64.
+const EncryptionOverhead = curve25519.PointSize + chacha20poly1305.Overhead
+
+// The field prime for Curve25519, 2^255 - 19
+var curvePrime = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+// x25519 converts the public key to an X25519 public key, with the birational
+// map u = (1 + y) / (1 - y).
+func (pk PublicKey) x25519() ([]byte, error) {
+	raw := pk.WithoutChecksum()
+	if len(raw) != 32 {
+		return nil, errors.New("invalid public key")
+	}
+
+	// y is little-endian, with the top bit holding the sign of x
+	le := make([]byte, 32)
+	copy(le, raw)
+	le[31] &= 0x7f
+	y := new(big.Int).SetBytes(reverse(le))
+	if y.Cmp(curvePrime) >= 0 {
+		return nil, errors.New("invalid public key")
+	}
+	denominator := new(big.Int).Sub(big.NewInt(1), y)
+	denominator.Mod(denominator, curvePrime)
+	if denominator.Sign() == 0 {
+		return nil, errors.New("invalid public key")
+	}
+	u := new(big.Int).Add(big.NewInt(1), y)
+	u.Mul(u, new(big.Int).ModInverse(denominator, curvePrime))
+	u.Mod(u, curvePrime)
+
+	answer := make([]byte, 32)
+	u.FillBytes(answer)
+	return reverse(answer), nil
+}
+
+// x25519 returns the X25519 private key that matches the ed25519 key.
+func (kp *KeyPair) x25519() []byte {
+	h := sha512.Sum512(kp.privateKey.Seed())
+	return h[:curve25519.ScalarSize]
+}
+
+func reverse(b []byte) []byte {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
+
+func encryptionKey(shared []byte, ephemeral []byte, recipient []byte) []byte {
+	h := sha256.New()
+	h.Write(shared)
+	h.Write(ephemeral)
+	h.Write(recipient)
+	return h.Sum(nil)
+}
+
+// Encrypt encrypts data so that only the owner of recipient can read it.
+func Encrypt(recipient PublicKey, plaintext []byte) (string, error) {
+	public, err := recipient.x25519()
+	if err != nil {
+		return "", err
+	}
+	ephemeral := NewKeyPair().x25519()
+	ephemeralPublic, err := curve25519.X25519(ephemeral, curve25519.Basepoint)
+	if err != nil {
+		return "", err
+	}
+	shared, err := curve25519.X25519(ephemeral, public)
+	if err != nil {
+		return "", err
+	}
+	aead, err := chacha20poly1305.New(encryptionKey(shared, ephemeralPublic, public))
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	sealed := aead.Seal(ephemeralPublic, nonce, plaintext, nil)
+	return base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt decrypts data that was encrypted for this key pair.
+func (kp *KeyPair) Decrypt(ciphertext string) ([]byte, error) {
+	sealed, err := base64.RawStdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < EncryptionOverhead {
+		return nil, errors.New("the ciphertext is too short")
+	}
+	ephemeralPublic := sealed[:curve25519.PointSize]
+	public, err := curve25519.X25519(kp.x25519(), curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := curve25519.X25519(kp.x25519(), ephemeralPublic)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.New(encryptionKey(shared, ephemeralPublic, public))
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	plaintext, err := aead.Open(nil, nonce, sealed[curve25519.PointSize:], nil)
+	if err != nil {
+		return nil, errors.New("this data was not encrypted for this key")
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,57 @@
+package util
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+func TestX25519Conversion(t *testing.T) {
+	for _, phrase := range []string{"alice", "bob", "carol"} {
+		kp := NewKeyPairFromSecretPhrase(phrase)
+		converted, err := kp.PublicKey().x25519()
+		if err != nil {
+			t.Fatal(err)
+		}
+		derived, err := curve25519.X25519(kp.x25519(), curve25519.Basepoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(converted, derived) {
+			t.Fatalf("for %s the public key converts to %x but the private key gives %x",
+				phrase, converted, derived)
+		}
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	alice := NewKeyPairFromSecretPhrase("alice")
+	bob := NewKeyPairFromSecretPhrase("bob")
+	message := []byte("meet me at the usual place")
+	ciphertext, err := Encrypt(bob.PublicKey(), message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := bob.Decrypt(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plaintext, message) {
+		t.Fatalf("decrypted to %q", plaintext)
+	}
+	if _, err := alice.Decrypt(ciphertext); err == nil {
+		t.Fatal("only bob should be able to decrypt")
+	}
+
+	other, err := Encrypt(bob.PublicKey(), message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == ciphertext {
+		t.Fatal("encrypting twice should not give the same ciphertext")
+	}
+	if _, err := bob.Decrypt(ciphertext[:20]); err == nil {
+		t.Fatal("a truncated ciphertext should not decrypt")
+	}
+}
@@ -16,8 +16,22 @@ type InfoMessage struct {
 	// When Account is nonempty, the info message is requesting an AccountMessage
 	// for this particular user.
 	Account string
+
+	// When AccountSlot is nonzero, along with Account, the info message is
+	// requesting the state of the account right after that slot was finalized,
+	// rather than its current state.
+	AccountSlot int
+
+	// When Accounts is nonempty, the info message is requesting an
+	// AccountMessage with the current state of all of these users, along with
+	// Account if that is set too. A node answers for at most
+	// MaxAccountsPerQuery of them, so bigger lists have to be split up.
+	Accounts []string `json:",omitempty"`
 }
 
+// The most accounts one info message can ask about
+const MaxAccountsPerQuery = 1000
+
 func (m *InfoMessage) Slot() int {
 	return m.I
 }
@@ -34,9 +48,33 @@ func (m *InfoMessage) String() string {
 	if m.Account != "" {
 		parts = append(parts, fmt.Sprintf("account=%s", Shorten(m.Account)))
 	}
+	if m.AccountSlot != 0 {
+		parts = append(parts, fmt.Sprintf("accountslot=%d", m.AccountSlot))
+	}
+	if len(m.Accounts) > 0 {
+		parts = append(parts, fmt.Sprintf("accounts=%d", len(m.Accounts)))
+	}
 	return strings.Join(parts, " ")
 }
 
+// Owners returns the accounts this message asks about the current state of,
+// without duplicates, up to MaxAccountsPerQuery of them.
+func (m *InfoMessage) Owners() []string {
+	answer := []string{}
+	seen := make(map[string]bool)
+	for _, owner := range append([]string{m.Account}, m.Accounts...) {
+		if owner == "" || seen[owner] {
+			continue
+		}
+		if len(answer) == MaxAccountsPerQuery {
+			break
+		}
+		seen[owner] = true
+		answer = append(answer, owner)
+	}
+	return answer
+}
+
 func init() {
 	RegisterMessageType(&InfoMessage{})
 }
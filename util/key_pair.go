@@ -8,8 +8,6 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
-	"fmt"
-	"io/ioutil"
 
 	"golang.org/x/crypto/ed25519"
 )
@@ -83,16 +81,10 @@ func DeserializeKeyPair(serialized []byte) (*KeyPair, error) {
 	return kp, nil
 }
 
+// ReadKeyPairFromFile reads a key pair file. An encrypted one is unlocked
+// with the passphrase from KeyPassphraseEnv or the terminal.
 func ReadKeyPairFromFile(filename string) (*KeyPair, error) {
-	bytes, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return nil, err
-	}
-	kp, err := DeserializeKeyPair(bytes)
-	if err != nil {
-		return nil, fmt.Errorf("the keypair in %s is invalid: %s", filename, err)
-	}
-	return kp, nil
+	return (&KeyUnlocker{}).ReadKeyPairFromFile(filename)
 }
 
 func (kp *KeyPair) PublicKey() PublicKey {
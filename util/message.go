@@ -1,9 +1,11 @@
 package util
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"sync"
 )
 
 // Message is an interface for the network-level communication between nodes.
@@ -46,6 +48,44 @@ func RegisterMessageType(m Message) {
 	MessageTypeMap[name] = sv.Type()
 }
 
+// A LimitedMessage has limits on how big its fields can be. DecodeMessage
+// rejects any message that goes over its limits, so that handlers never see
+// them.
+type LimitedMessage interface {
+	Message
+
+	// CheckLimits returns a *LimitError if the message is too big
+	CheckLimits() error
+}
+
+// LimitError is returned when a decoded message is bigger than its limits.
+type LimitError struct {
+	// The type of the message, or empty if it couldn't be decoded
+	Type string
+
+	// What was too big
+	Field string
+
+	Size  int
+	Limit int
+}
+
+func (e *LimitError) Error() string {
+	if e.Type == "" {
+		return fmt.Sprintf("message has %d %s but the limit is %d", e.Size, e.Field, e.Limit)
+	}
+	return fmt.Sprintf("%s message has %d %s but the limit is %d",
+		e.Type, e.Size, e.Field, e.Limit)
+}
+
+// CheckLimit returns a *LimitError if size is over limit, and nil otherwise.
+func CheckLimit(m Message, field string, size int, limit int) error {
+	if size <= limit {
+		return nil
+	}
+	return &LimitError{Type: m.MessageType(), Field: field, Size: size, Limit: limit}
+}
+
 // DecodedMessage is just used for the encoding process.
 type DecodedMessage struct {
 	// The type of the message
@@ -60,18 +100,46 @@ type PartiallyDecodedMessage struct {
 	M json.RawMessage
 }
 
+// Buffers for encoding messages. Messages are encoded many times a second,
+// so reusing buffers takes a lot of pressure off the garbage collector.
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// Buffers bigger than this aren't reused, so that one huge message doesn't
+// pin its memory forever
+const maxPooledBuffer = 1 << 20
+
+func getBuffer() *bytes.Buffer {
+	buffer := bufferPool.Get().(*bytes.Buffer)
+	buffer.Reset()
+	return buffer
+}
+
+func putBuffer(buffer *bytes.Buffer) {
+	if buffer.Cap() <= maxPooledBuffer {
+		bufferPool.Put(buffer)
+	}
+}
+
+// EncodeMessage encodes a message the same way json.Marshal would, but
+// encodes it into a reused buffer.
 func EncodeMessage(m Message) string {
 	if m == nil || reflect.ValueOf(m).IsNil() {
 		panic("you should not EncodeMessage(nil)")
 	}
-	bytes, err := json.Marshal(DecodedMessage{
+	buffer := getBuffer()
+	defer putBuffer(buffer)
+	err := json.NewEncoder(buffer).Encode(DecodedMessage{
 		T: m.MessageType(),
 		M: m,
 	})
 	if err != nil {
 		panic(err)
 	}
-	return string(bytes)
+
+	// Encode adds a newline that Marshal doesn't
+	return string(bytes.TrimSuffix(buffer.Bytes(), []byte("\n")))
 }
 
 func DecodeMessage(encoded string) (Message, error) {
@@ -94,6 +162,11 @@ func DecodeMessage(encoded string) (Message, error) {
 	if m == nil {
 		return nil, fmt.Errorf("it looks like a nil message got encoded")
 	}
+	if lm, ok := m.(LimitedMessage); ok {
+		if err := lm.CheckLimits(); err != nil {
+			return nil, err
+		}
+	}
 
 	return m, nil
 }
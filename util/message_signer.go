@@ -0,0 +1,48 @@
+package util
+
+import (
+	"time"
+)
+
+// A MessageSigner signs the batches of messages a node sends out. Most of a
+// node's outgoing messages are the same from one batch to the next, since its
+// state only changes a little with each message it handles. When a message
+// encodes the same as one in the previous batch, the signer reuses that
+// envelope instead of signing it again, as long as it isn't older than
+// maxAge. maxAge should be well inside the window that receivers accept
+// timestamps in.
+// A MessageSigner is not threadsafe.
+type MessageSigner struct {
+	kp     Signer
+	maxAge time.Duration
+
+	// The envelopes from the previous batch, keyed by their encoding
+	previous map[string]*SignedMessage
+}
+
+func NewMessageSigner(kp Signer, maxAge time.Duration) *MessageSigner {
+	return &MessageSigner{
+		kp:       kp,
+		maxAge:   maxAge,
+		previous: make(map[string]*SignedMessage),
+	}
+}
+
+// SignBatch signs a batch of messages, reusing envelopes from the previous
+// batch where it can. Only this batch's envelopes are kept for next time.
+func (s *MessageSigner) SignBatch(messages []Message) []*SignedMessage {
+	answer := make([]*SignedMessage, 0, len(messages))
+	current := make(map[string]*SignedMessage, len(messages))
+	now := time.Now()
+	for _, m := range messages {
+		encoded := EncodeMessage(m)
+		sm, ok := s.previous[encoded]
+		if !ok || now.Sub(sm.Timestamp()) > s.maxAge {
+			sm = newSignedMessageFromEncoded(m, encoded, s.kp)
+		}
+		current[encoded] = sm
+		answer = append(answer, sm)
+	}
+	s.previous = current
+	return answer
+}
@@ -0,0 +1,62 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMessageSigner(t *testing.T) {
+	kp := NewKeyPairFromSecretPhrase("signer")
+	s := NewMessageSigner(kp, time.Hour)
+	first := s.SignBatch([]Message{&TestingMessage{Number: 1}, &TestingMessage{Number: 2}})
+	second := s.SignBatch([]Message{&TestingMessage{Number: 2}, &TestingMessage{Number: 3}})
+	if second[0] != first[1] {
+		t.Fatal("an unchanged message should keep its envelope")
+	}
+	if second[1].Message().(*TestingMessage).Number != 3 || second[1].Signer() != kp.PublicKey().String() {
+		t.Fatalf("bad envelope for a new message: %+v", second[1])
+	}
+	third := s.SignBatch([]Message{&TestingMessage{Number: 1}})
+	if third[0] == first[0] {
+		t.Fatal("only envelopes from the previous batch should be reused")
+	}
+	if _, err := NewSignedMessageFromSerialized(third[0].Serialize()); err != nil {
+		t.Fatal(err)
+	}
+
+	s = NewMessageSigner(kp, 0)
+	first = s.SignBatch([]Message{&TestingMessage{Number: 1}})
+	time.Sleep(2 * time.Millisecond)
+	second = s.SignBatch([]Message{&TestingMessage{Number: 1}})
+	if second[0] == first[0] || second[0].Timestamp() == first[0].Timestamp() {
+		t.Fatal("an envelope past its max age should be signed again")
+	}
+}
+
+// A batch like the ones a node sends after each message it handles, where
+// only one message changes from batch to batch
+func benchmarkBatch(i int) []Message {
+	return []Message{
+		&InfoMessage{I: i},
+		&InfoMessage{Account: "bob", Accounts: []string{"carol", "dave"}},
+		&InfoMessage{Account: "carol", AccountSlot: 3},
+	}
+}
+
+func BenchmarkSignEachMessage(b *testing.B) {
+	kp := NewKeyPairFromSecretPhrase("signer")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, m := range benchmarkBatch(i) {
+			NewSignedMessage(m, kp)
+		}
+	}
+}
+
+func BenchmarkSignBatch(b *testing.B) {
+	s := NewMessageSigner(NewKeyPairFromSecretPhrase("signer"), time.Hour)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s.SignBatch(benchmarkBatch(i))
+	}
+}
@@ -47,3 +47,22 @@ func TestDecodingInvalidMessage(t *testing.T) {
 		t.Fatal("an encoded nil message should fail to decode")
 	}
 }
+
+func TestEncodeMessageMatchesMarshal(t *testing.T) {
+	m := &InfoMessage{Account: "<bob & carol>", Accounts: []string{"dave"}}
+	bytes, err := json.Marshal(DecodedMessage{T: m.MessageType(), M: m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if EncodeMessage(m) != string(bytes) {
+		t.Fatalf("%s encoded as %s", bytes, EncodeMessage(m))
+	}
+}
+
+func BenchmarkEncodeMessage(b *testing.B) {
+	m := &InfoMessage{Account: "bob", Accounts: []string{"carol", "dave"}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		EncodeMessage(m)
+	}
+}
@@ -0,0 +1,78 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tyler-smith/go-bip39"
+	"golang.org/x/crypto/ed25519"
+)
+
+// A mnemonic is a standard BIP39 phrase of 24 words from the English
+// wordlist, which encodes 256 random bits and a checksum. Other wallets can
+// restore the same key from it: the BIP39 seed, with no extra passphrase,
+// becomes an ed25519 key the way SLIP-0010 derives the master key.
+
+// How many words a mnemonic has
+const MnemonicWords = 24
+
+// NewMnemonic returns a new random mnemonic.
+func NewMnemonic() string {
+	entropy, err := bip39.NewEntropy(256)
+	if err != nil {
+		panic(err)
+	}
+	mnemonic, err := bip39.NewMnemonic(entropy)
+	if err != nil {
+		panic(err)
+	}
+	return mnemonic
+}
+
+// normalizeMnemonic lowercases a mnemonic and puts a single space between
+// its words, since people copy them around by hand.
+func normalizeMnemonic(mnemonic string) string {
+	return strings.Join(strings.Fields(strings.ToLower(mnemonic)), " ")
+}
+
+// IsMnemonic returns whether s is a valid mnemonic, checksum included.
+func IsMnemonic(s string) bool {
+	s = normalizeMnemonic(s)
+	return len(strings.Fields(s)) == MnemonicWords && bip39.IsMnemonicValid(s)
+}
+
+// NewKeyPairFromMnemonic restores the key pair for a mnemonic. It returns an
+// error if the mnemonic has a word that isn't in the wordlist, the wrong
+// number of words, or a bad checksum, which usually means a typo.
+func NewKeyPairFromMnemonic(mnemonic string) (*KeyPair, error) {
+	mnemonic = normalizeMnemonic(mnemonic)
+	words := strings.Fields(mnemonic)
+	if len(words) != MnemonicWords {
+		return nil, fmt.Errorf("a mnemonic has %d words but this has %d",
+			MnemonicWords, len(words))
+	}
+	for _, word := range words {
+		if _, ok := bip39.GetWordIndex(word); !ok {
+			return nil, fmt.Errorf("%q is not a mnemonic word", word)
+		}
+	}
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, "")
+	if err != nil {
+		return nil, errors.New("the mnemonic checksum does not match, so a word is wrong")
+	}
+	return newKeyPairFromSeed(seed), nil
+}
+
+// newKeyPairFromSeed makes the SLIP-0010 master key for a BIP39 seed.
+func newKeyPairFromSeed(seed []byte) *KeyPair {
+	mac := hmac.New(sha512.New, []byte("ed25519 seed"))
+	mac.Write(seed)
+	priv := ed25519.NewKeyFromSeed(mac.Sum(nil)[:32])
+	return &KeyPair{
+		publicKey:  GeneratePublicKey(priv.Public().(ed25519.PublicKey)),
+		privateKey: priv,
+	}
+}
@@ -0,0 +1,56 @@
+package util
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestMnemonicKeyPair(t *testing.T) {
+	mnemonic := NewMnemonic()
+	if len(strings.Fields(mnemonic)) != MnemonicWords || !IsMnemonic(mnemonic) {
+		t.Fatalf("bad mnemonic: %s", mnemonic)
+	}
+	kp, err := NewKeyPairFromMnemonic(mnemonic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Case and spacing don't matter
+	messy := "  " + strings.ToUpper(strings.Replace(mnemonic, " ", "\n ", -1))
+	again, err := NewKeyPairFromMnemonic(messy)
+	if err != nil || again.PublicKey() != kp.PublicKey() {
+		t.Fatalf("the messy mnemonic should get the same key: %v", err)
+	}
+	if NewMnemonic() == mnemonic {
+		t.Fatal("mnemonics should be random")
+	}
+
+	words := strings.Fields(mnemonic)
+	if _, err := NewKeyPairFromMnemonic(strings.Join(words[1:], " ")); err == nil {
+		t.Fatal("23 words should not work")
+	}
+	words[0] = "notaword"
+	if _, err := NewKeyPairFromMnemonic(strings.Join(words, " ")); err == nil {
+		t.Fatal("a word outside the wordlist should not work")
+	}
+	checksum := strings.Repeat("abandon ", 24)
+	if IsMnemonic(checksum) {
+		t.Fatal("the checksum of 24 abandons is wrong")
+	}
+	if _, err := NewKeyPairFromMnemonic(checksum); err == nil {
+		t.Fatal("a bad checksum should not work")
+	}
+	if !IsMnemonic(strings.Repeat("abandon ", 23) + "art") {
+		t.Fatal("the all-zero mnemonic should be valid")
+	}
+}
+
+func TestNewKeyPairFromSeed(t *testing.T) {
+	// Test vector 1 for ed25519 from SLIP-0010
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	kp := newKeyPairFromSeed(seed)
+	expected := "a4b2856bfec510abab89753fac1ac0e1112364e7d250545963f135f2a33188ed"
+	if hex.EncodeToString(kp.PublicKey().WithoutChecksum()) != expected {
+		t.Fatalf("bad public key %x", kp.PublicKey().WithoutChecksum())
+	}
+}
@@ -0,0 +1,189 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// How many spans the OTLPExporter sends in one request
+const otlpBatchSize = 100
+
+// How often the OTLPExporter sends spans even when it does not have a full batch
+const otlpFlushInterval = 2 * time.Second
+
+// An OTLPExporter sends spans to an OpenTelemetry collector, using the OTLP
+// protocol encoded as JSON over HTTP.
+// The endpoint is the full url, typically ending with /v1/traces.
+// Spans are dropped rather than slowing down the caller when the collector
+// can't keep up.
+type OTLPExporter struct {
+	endpoint    string
+	serviceName string
+	spans       chan *Span
+	quit        chan bool
+	client      *http.Client
+}
+
+func NewOTLPExporter(endpoint string, serviceName string) *OTLPExporter {
+	e := &OTLPExporter{
+		endpoint:    endpoint,
+		serviceName: serviceName,
+		spans:       make(chan *Span, 10*otlpBatchSize),
+		quit:        make(chan bool),
+		client:      &http.Client{Timeout: 5 * time.Second},
+	}
+	go e.run()
+	return e
+}
+
+func (e *OTLPExporter) ExportSpan(span *Span) {
+	select {
+	case e.spans <- span:
+	default:
+		// The collector is falling behind, so drop this span
+	}
+}
+
+// Stop stops the background exporting. Spans that have not been sent yet
+// are dropped.
+func (e *OTLPExporter) Stop() {
+	close(e.quit)
+}
+
+func (e *OTLPExporter) run() {
+	batch := []*Span{}
+	ticker := time.NewTicker(otlpFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.quit:
+			return
+		case span := <-e.spans:
+			batch = append(batch, span)
+			if len(batch) < otlpBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		err := e.send(batch)
+		if err != nil {
+			Logger.Printf("could not export %d spans: %s", len(batch), err)
+		}
+		batch = []*Span{}
+	}
+}
+
+// The types below mirror the OTLP JSON encoding.
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceId           string          `json:"traceId"`
+	SpanId            string          `json:"spanId"`
+	ParentSpanId      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+func otlpAttributes(m map[string]string) []otlpAttribute {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	answer := []otlpAttribute{}
+	for _, key := range keys {
+		answer = append(answer, otlpAttribute{Key: key, Value: otlpValue{m[key]}})
+	}
+	return answer
+}
+
+func unixNanoString(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// EncodeOTLP converts spans to an OTLP JSON request body.
+func EncodeOTLP(serviceName string, spans []*Span) []byte {
+	encoded := []otlpSpan{}
+	for _, span := range spans {
+		encoded = append(encoded, otlpSpan{
+			TraceId:           span.TraceId,
+			SpanId:            span.SpanId,
+			ParentSpanId:      span.ParentId,
+			Name:              span.Name,
+			Kind:              1,
+			StartTimeUnixNano: unixNanoString(span.Start),
+			EndTimeUnixNano:   unixNanoString(span.Finish),
+			Attributes:        otlpAttributes(span.Attributes),
+		})
+	}
+	request := otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: otlpAttributes(map[string]string{"service.name": serviceName}),
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "coinkit"},
+				Spans: encoded,
+			}},
+		}},
+	}
+	bytes, err := json.Marshal(request)
+	if err != nil {
+		panic(err)
+	}
+	return bytes
+}
+
+func (e *OTLPExporter) send(spans []*Span) error {
+	body := EncodeOTLP(e.serviceName, spans)
+	response, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("collector returned status %s", response.Status)
+	}
+	return nil
+}
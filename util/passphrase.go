@@ -0,0 +1,174 @@
+package util
+
+import (
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/tyler-smith/go-bip39"
+)
+
+// A passphrase that a person picks is much weaker than its length suggests,
+// and anyone who guesses it gets the account. CheckPassphrase estimates how
+// many guesses it would take, in bits, for an attacker who tries common
+// passwords first, then words, then letters with the patterns people use,
+// like repeating a character or counting up.
+
+// Below MinPassphraseBits a passphrase shouldn't be used for a new key at
+// all, and below StrongPassphraseBits it deserves a warning. A mnemonic has
+// 256 bits.
+const MinPassphraseBits = 40
+const StrongPassphraseBits = 80
+
+// How many bits a common password or a dictionary word is worth. A word in
+// lowercase letters that isn't in the dictionary is probably still English,
+// so it is worth at most englishWordBits.
+const commonPasswordBits = 5
+const dictionaryWordBits = 11
+const englishWordBits = 16
+
+// Some of the passwords attackers try first
+var commonPasswords = map[string]bool{}
+
+func init() {
+	for _, p := range strings.Fields(`
+password passw0rd p@ssw0rd 123456 1234567 12345678 123456789 1234567890
+qwerty qwertyuiop asdfgh asdfghjkl zxcvbn 1q2w3e4r zaq12wsx abc123 111111
+000000 123123 654321 666666 696969 letmein welcome admin login root test
+default changeme secret hello monkey dragon master shadow sunshine princess
+football baseball iloveyou trustno1 superman batman starwars whatever
+freedom hunter2 mint coinkit bitcoin`) {
+		commonPasswords[p] = true
+	}
+}
+
+// PassphraseStrength is the estimated strength of a passphrase, and what
+// makes it weak, if anything.
+type PassphraseStrength struct {
+	Bits     float64
+	Problems []string
+}
+
+// Weak returns whether the passphrase is too weak to use for a new key.
+func (s *PassphraseStrength) Weak() bool {
+	return s.Bits < MinPassphraseBits
+}
+
+// Strong returns whether the passphrase is strong enough not to warn about.
+func (s *PassphraseStrength) Strong() bool {
+	return s.Bits >= StrongPassphraseBits
+}
+
+// CheckPassphrase estimates the strength of a passphrase.
+func CheckPassphrase(phrase string) *PassphraseStrength {
+	if IsMnemonic(phrase) {
+		return &PassphraseStrength{Bits: 8 * 32}
+	}
+	answer := &PassphraseStrength{}
+	words := strings.Fields(phrase)
+	if len(words) == 0 {
+		answer.Problems = append(answer.Problems, "it is empty")
+		return answer
+	}
+	common, dictionary, predictable := 0, 0, 0
+	for _, word := range words {
+		lower := strings.ToLower(word)
+		if commonPasswords[lower] {
+			common++
+			answer.Bits += commonPasswordBits
+			continue
+		}
+		// People tack digits and punctuation onto words
+		stem := strings.TrimRightFunc(lower, func(r rune) bool {
+			return !unicode.IsLetter(r)
+		})
+		if _, ok := bip39.GetWordIndex(stem); ok || commonPasswords[stem] {
+			dictionary++
+			answer.Bits += dictionaryWordBits
+			bits, n := characterBits(lower[len(stem):])
+			answer.Bits += bits
+			predictable += n
+			continue
+		}
+		bits, n := characterBits(word)
+		if isLowercaseWord(word) {
+			bits = math.Min(bits, englishWordBits)
+		}
+		answer.Bits += bits
+		predictable += n
+	}
+
+	if common > 0 {
+		answer.Problems = append(answer.Problems, "it has one of the most common passwords in it")
+	}
+	if len(phrase) < 12 {
+		answer.Problems = append(answer.Problems, "it is shorter than 12 characters")
+	}
+	if predictable >= 3 {
+		answer.Problems = append(answer.Problems, "it repeats characters or counts up")
+	}
+	if dictionary+common == len(words) && answer.Bits < StrongPassphraseBits {
+		answer.Problems = append(answer.Problems,
+			"it is only common words, so it needs more of them")
+	}
+	return answer
+}
+
+// characterBits estimates the bits in a string of characters with no
+// words in it, from the kinds of character it uses. It also returns how
+// many characters were predictable from the one before, since they repeat
+// it or count up or down from it, which are worth about one bit each.
+func characterBits(s string) (float64, int) {
+	pool := 0
+	var lower, upper, digit, other bool
+	for _, r := range s {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	if lower {
+		pool += 26
+	}
+	if upper {
+		pool += 26
+	}
+	if digit {
+		pool += 10
+	}
+	if other {
+		pool += 33
+	}
+	if pool == 0 {
+		return 0, 0
+	}
+
+	bits := 0.0
+	predictable := 0
+	var last rune = -1
+	for _, r := range s {
+		if last >= 0 && (r == last || r == last+1 || r == last-1) {
+			predictable++
+			bits++
+		} else {
+			bits += math.Log2(float64(pool))
+		}
+		last = r
+	}
+	return bits, predictable
+}
+
+func isLowercaseWord(s string) bool {
+	for _, r := range s {
+		if !unicode.IsLower(r) {
+			return false
+		}
+	}
+	return true
+}
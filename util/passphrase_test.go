@@ -0,0 +1,28 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestCheckPassphrase(t *testing.T) {
+	weak := []string{"", "password", "mint", "Password1", "aaaaaaaaaaaaaaaa",
+		"123456789012345", "monkey dragon"}
+	for _, phrase := range weak {
+		if s := CheckPassphrase(phrase); !s.Weak() || len(s.Problems) == 0 {
+			t.Fatalf("%q should be weak but got %.1f bits", phrase, s.Bits)
+		}
+	}
+	medium := []string{"correct horse battery staple", "Tr0ub4dor&3"}
+	for _, phrase := range medium {
+		if s := CheckPassphrase(phrase); s.Weak() || s.Strong() {
+			t.Fatalf("%q should be in between but got %.1f bits", phrase, s.Bits)
+		}
+	}
+	strong := []string{NewMnemonic(), "kq8#Vz!mW2pLx9&Rt4Yh",
+		"lunar tribe orbit jazz wagon pepper cactus dizzy"}
+	for _, phrase := range strong {
+		if s := CheckPassphrase(phrase); !s.Strong() || len(s.Problems) != 0 {
+			t.Fatalf("%q should be strong but got %.1f bits: %v", phrase, s.Bits, s.Problems)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package util
+
+import (
+	"fmt"
+)
+
+// The wire protocol has a version, so that nodes from different releases can
+// tell whether they understand each other. Each version so far changed how a
+// signed message is serialized, and the first part of a serialized message
+// says which version it is:
+//
+// 1. "e": the signer, the signature of the encoded message, and the message
+// 2. "t": a timestamp after the signature, which the signature covers too
+// 3. "c": the signature covers the canonical JSON of the message
+//
+// Nodes read and write every version from MinProtocolVersion to
+// ProtocolVersion, and talk to each peer in the highest version they both
+// speak. Version 1 messages have no timestamp, so they can be replayed, and
+// nothing speaks it any more.
+const ProtocolVersion = 3
+const MinProtocolVersion = 2
+
+// envelopes is how a serialized signed message starts, for each version.
+var envelopes = map[int]string{
+	1: "e",
+	2: "t",
+	3: "c",
+}
+
+// UnsupportedProtocolError is returned when decoding a message from a
+// protocol version that is too old or too new for us.
+type UnsupportedProtocolError struct {
+	// The version the message is in, zero if we don't recognize it
+	Version int
+}
+
+func (e *UnsupportedProtocolError) Error() string {
+	if e.Version == 0 {
+		return "unrecognized version"
+	}
+	return fmt.Sprintf("protocol version %d is not supported, only %d to %d",
+		e.Version, MinProtocolVersion, ProtocolVersion)
+}
+
+// A ProtocolRange is the protocol versions that a node speaks.
+type ProtocolRange struct {
+	Min int
+	Max int
+}
+
+// SupportedProtocols is the range of versions this release speaks.
+var SupportedProtocols = ProtocolRange{Min: MinProtocolVersion, Max: ProtocolVersion}
+
+func (r ProtocolRange) String() string {
+	return fmt.Sprintf("%d-%d", r.Min, r.Max)
+}
+
+// Contains returns whether version is in the range.
+func (r ProtocolRange) Contains(version int) bool {
+	return r.Min <= version && version <= r.Max
+}
+
+// Negotiate returns the version that a node speaking r and a node speaking
+// other should talk in, which is the highest one they both speak. It returns
+// false if there isn't one.
+func (r ProtocolRange) Negotiate(other ProtocolRange) (int, bool) {
+	version := r.Max
+	if other.Max < version {
+		version = other.Max
+	}
+	if !r.Contains(version) || !other.Contains(version) {
+		return 0, false
+	}
+	return version, true
+}
+
+// envelopeVersion returns the protocol version for the start of a serialized
+// message, or zero if there isn't one.
+func envelopeVersion(prefix string) int {
+	for version, envelope := range envelopes {
+		if envelope == prefix {
+			return version
+		}
+	}
+	return 0
+}
@@ -0,0 +1,54 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	cases := []struct {
+		a, b    ProtocolRange
+		version int
+	}{
+		{ProtocolRange{2, 2}, ProtocolRange{2, 3}, 2},
+		{ProtocolRange{2, 3}, ProtocolRange{3, 3}, 3},
+		{ProtocolRange{2, 3}, ProtocolRange{2, 3}, 3},
+		{ProtocolRange{2, 3}, ProtocolRange{3, 4}, 3},
+		{ProtocolRange{2, 2}, ProtocolRange{3, 3}, 0},
+		{ProtocolRange{1, 1}, ProtocolRange{2, 4}, 0},
+	}
+	for _, c := range cases {
+		for _, pair := range [][]ProtocolRange{{c.a, c.b}, {c.b, c.a}} {
+			version, ok := pair[0].Negotiate(pair[1])
+			if version != c.version || ok != (c.version != 0) {
+				t.Fatalf("%s with %s should agree on %d but got %d %v",
+					pair[0], pair[1], c.version, version, ok)
+			}
+		}
+	}
+}
+
+func TestOldProtocolVersion(t *testing.T) {
+	kp := NewKeyPairFromSecretPhrase("foo")
+	sm := NewSignedMessageForVersion(&TestingMessage{Number: 4}, kp, 2)
+	str := sm.Serialize()
+	if !strings.HasPrefix(str, "t:") {
+		t.Fatalf("a version 2 message should start with t: but got %s", str)
+	}
+	sm2, err := NewSignedMessageFromSerialized(str)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sm2.ProtocolVersion() != 2 || sm2.Serialize() != str {
+		t.Fatalf("bad round trip: %s", sm2.Serialize())
+	}
+
+	// Changing the envelope changes what the signature covers
+	if _, err := NewSignedMessageFromSerialized("c" + str[1:]); err == nil {
+		t.Fatal("a version 2 signature should not verify as version 3")
+	}
+	_, err = NewSignedMessageFromSerialized("e" + str[1:])
+	if _, ok := err.(*UnsupportedProtocolError); !ok {
+		t.Fatalf("expected an unsupported protocol error but got %v", err)
+	}
+}
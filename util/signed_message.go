@@ -2,37 +2,105 @@ package util
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"reflect"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const OK = "ok"
 
+// InvalidSignatureError is returned when a message's signature does not match
+// the public key it claims to be signed by.
+type InvalidSignatureError struct {
+	// Who the message claims to be from
+	Signer string
+}
+
+func (e *InvalidSignatureError) Error() string {
+	return "signature failed verification"
+}
+
 type SignedMessage struct {
 	message       Message
 	messageString string
 	signer        string
 	signature     string
 
+	// When the message was signed, in milliseconds since the epoch.
+	// The timestamp is covered by the signature, so receivers can reject
+	// captured messages that get replayed later.
+	timestamp int64
+
+	// The protocol version the message is signed and serialized for
+	version int
+
 	// Whenever keepalive is true, the SignedMessage has no real content, it's
 	// just a small value used to keep a network connection alive
 	keepalive bool
+
+	// When tracing is on, span covers the handling of a received message.
+	// Whoever finishes handling the message should end it.
+	span *Span
+}
+
+func NewSignedMessage(message Message, kp Signer) *SignedMessage {
+	if message == nil || reflect.ValueOf(message).IsNil() {
+		Logger.Fatal("cannot sign nil message")
+	}
+	return newSignedMessageFromEncoded(message, EncodeMessage(message), kp)
 }
 
-func NewSignedMessage(message Message, kp *KeyPair) *SignedMessage {
+// NewSignedMessageForVersion signs a message for a peer that speaks an
+// older protocol version. The version must be one that we speak.
+func NewSignedMessageForVersion(message Message, kp Signer, version int) *SignedMessage {
+	if !SupportedProtocols.Contains(version) {
+		Logger.Fatalf("cannot sign for protocol version %d", version)
+	}
 	if message == nil || reflect.ValueOf(message).IsNil() {
 		Logger.Fatal("cannot sign nil message")
 	}
-	ms := EncodeMessage(message)
+	return signEncoded(message, EncodeMessage(message), kp, version)
+}
+
+// newSignedMessageFromEncoded signs a message that is already encoded.
+func newSignedMessageFromEncoded(message Message, ms string, kp Signer) *SignedMessage {
+	return signEncoded(message, ms, kp, ProtocolVersion)
+}
+
+func signEncoded(message Message, ms string, kp Signer, version int) *SignedMessage {
+	timestamp := time.Now().UnixNano() / int64(time.Millisecond)
+	content, err := signedContent(version, timestamp, ms)
+	if err != nil {
+		Logger.Fatalf("cannot sign message: %s", err)
+	}
 	return &SignedMessage{
 		message:       message,
 		messageString: ms,
 		signer:        kp.PublicKey().String(),
-		signature:     kp.Sign(ms),
+		signature:     kp.Sign(content),
+		timestamp:     timestamp,
+		version:       version,
+	}
+}
+
+// signedContent is what actually gets signed: the timestamp along with the
+// canonical JSON for the encoded message. See CanonicalJSON. Before protocol
+// version 3, the encoded message was signed as it was.
+func signedContent(version int, timestamp int64, ms string) (string, error) {
+	if version < 3 {
+		return fmt.Sprintf("%d:%s", timestamp, ms), nil
+	}
+	canonical, err := CanonicalJSON([]byte(ms))
+	if err != nil {
+		return "", err
 	}
+	return fmt.Sprintf("%d:%s", timestamp, canonical), nil
 }
 
 func (sm *SignedMessage) Message() Message {
@@ -47,8 +115,51 @@ func (sm *SignedMessage) Signature() string {
 	return sm.signature
 }
 
+// ProtocolVersion is the protocol version the message is signed for.
+func (sm *SignedMessage) ProtocolVersion() int {
+	return sm.version
+}
+
+// Timestamp is when the message was signed.
+func (sm *SignedMessage) Timestamp() time.Time {
+	return time.Unix(0, sm.timestamp*int64(time.Millisecond))
+}
+
+// Encoded is the encoded message, without the signature or timestamp. Two
+// signed messages with the same content have the same encoding.
+func (sm *SignedMessage) Encoded() string {
+	return sm.messageString
+}
+
 func (sm *SignedMessage) Serialize() string {
-	return fmt.Sprintf("e:%s:%s:%s", sm.signer, sm.signature, sm.messageString)
+	buffer := getBuffer()
+	defer putBuffer(buffer)
+	sm.serializeTo(buffer)
+	return buffer.String()
+}
+
+func (sm *SignedMessage) serializeTo(buffer *bytes.Buffer) {
+	buffer.WriteString(envelopes[sm.version])
+	buffer.WriteByte(':')
+	buffer.WriteString(sm.signer)
+	buffer.WriteByte(':')
+	buffer.WriteString(sm.signature)
+	buffer.WriteByte(':')
+	var digits [20]byte
+	buffer.Write(strconv.AppendInt(digits[:0], sm.timestamp, 10))
+	buffer.WriteByte(':')
+	buffer.WriteString(sm.messageString)
+}
+
+// Size is the length of the serialized message, without serializing it.
+func (sm *SignedMessage) Size() int {
+	return len("c::::") + len(sm.signer) + len(sm.signature) +
+		len(strconv.FormatInt(sm.timestamp, 10)) + len(sm.messageString)
+}
+
+// Span returns the span for handling this message, or nil if it has none.
+func (sm *SignedMessage) Span() *Span {
+	return sm.span
 }
 
 func (sm *SignedMessage) IsKeepAlive() bool {
@@ -56,20 +167,29 @@ func (sm *SignedMessage) IsKeepAlive() bool {
 }
 
 func NewSignedMessageFromSerialized(serialized string) (*SignedMessage, error) {
-	parts := strings.SplitN(serialized, ":", 4)
-	if len(parts) != 4 {
-		return nil, errors.New("could not find 4 parts")
+	parts := strings.SplitN(serialized, ":", 5)
+	if len(parts) != 5 {
+		return nil, errors.New("could not find 5 parts")
 	}
-	version, signer, signature, ms := parts[0], parts[1], parts[2], parts[3]
-	if version != "e" {
-		return nil, errors.New("unrecognized version")
+	signer, signature, ms := parts[1], parts[2], parts[4]
+	version := envelopeVersion(parts[0])
+	if !SupportedProtocols.Contains(version) {
+		return nil, &UnsupportedProtocolError{Version: version}
+	}
+	timestamp, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return nil, errors.New("bad timestamp")
 	}
 	publicKey, err := ReadPublicKey(signer)
 	if err != nil {
 		return nil, err
 	}
-	if !VerifySignature(publicKey, ms, signature) {
-		return nil, errors.New("signature failed verification")
+	content, err := signedContent(version, timestamp, ms)
+	if err != nil {
+		return nil, err
+	}
+	if !VerifySignature(publicKey, content, signature) {
+		return nil, &InvalidSignatureError{Signer: signer}
 	}
 	m, err := DecodeMessage(ms)
 	if err != nil {
@@ -80,6 +200,8 @@ func NewSignedMessageFromSerialized(serialized string) (*SignedMessage, error) {
 		messageString: ms,
 		signer:        signer,
 		signature:     signature,
+		timestamp:     timestamp,
+		version:       version,
 	}, nil
 }
 
@@ -87,21 +209,45 @@ func KeepAlive() *SignedMessage {
 	return &SignedMessage{keepalive: true}
 }
 
+// Write writes the message as a single line, with a single call to w.
 func (sm *SignedMessage) Write(w io.Writer) {
-	var data string
+	buffer := getBuffer()
+	defer putBuffer(buffer)
 	if sm.keepalive {
-		data = OK + "\n"
+		buffer.WriteString(OK)
 	} else {
-		data = sm.Serialize() + "\n"
+		sm.serializeTo(buffer)
+	}
+	buffer.WriteByte('\n')
+	w.Write(buffer.Bytes())
+}
+
+// MaxLineSize is the longest line ReadSignedMessage will read. It leaves room
+// for a transaction message carrying several full-size chunks.
+const MaxLineSize = 16 * 1024 * 1024
+
+// readLine reads up to and including a newline, but gives up with a
+// *LimitError once the line is longer than limit, rather than buffering
+// however much a peer sends.
+func readLine(r *bufio.Reader, limit int) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(line)+len(chunk) > limit {
+			return "", &LimitError{Field: "bytes", Size: len(line) + len(chunk), Limit: limit}
+		}
+		line = append(line, chunk...)
+		if err != bufio.ErrBufferFull {
+			return string(line), err
+		}
 	}
-	fmt.Fprintf(w, data)
 }
 
 // ReadSignedMessage can return a nil message even when there is no error.
 // Specifically, a line with just "ok" indicates no message, but also no error.
 // The caller is responsible for setting any deadlines.
 func ReadSignedMessage(r *bufio.Reader) (*SignedMessage, error) {
-	data, err := r.ReadString('\n')
+	data, err := readLine(r, MaxLineSize)
 	if err != nil {
 		return nil, err
 	}
@@ -112,5 +258,17 @@ func ReadSignedMessage(r *bufio.Reader) (*SignedMessage, error) {
 		return &SignedMessage{keepalive: true}, nil
 	}
 
-	return NewSignedMessageFromSerialized(serialized)
+	ctx, span := StartSpan(context.Background(), "receive")
+	_, decodeSpan := StartSpan(ctx, "decode")
+	sm, err := NewSignedMessageFromSerialized(serialized)
+	decodeSpan.End()
+	if err != nil {
+		span.SetAttribute("error", err.Error())
+		span.End()
+		return nil, err
+	}
+	span.SetAttribute("type", sm.message.MessageType())
+	span.SetAttribute("signer", Shorten(sm.signer))
+	sm.span = span
+	return sm, nil
 }
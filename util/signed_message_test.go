@@ -1,6 +1,8 @@
 package util
 
 import (
+	"bufio"
+	"strings"
 	"testing"
 )
 
@@ -14,9 +16,51 @@ func TestSignedMessage(t *testing.T) {
 		Logger.Print(err)
 		t.Fatal("sm2 should not be nil")
 	}
-	if sm.signer != sm2.signer || sm.signature != sm2.signature {
+	if sm.signer != sm2.signer || sm.signature != sm2.signature ||
+		!sm.Timestamp().Equal(sm2.Timestamp()) {
 		Logger.Printf("sm: %+v", sm)
 		Logger.Printf("sm2: %+v", sm2)
 		t.Fatal("sm should equal sm2")
 	}
 }
+
+func TestInvalidSignature(t *testing.T) {
+	kp := NewKeyPairFromSecretPhrase("foo")
+	sm := NewSignedMessage(&TestingMessage{Number: 4}, kp)
+	if sm.Size() != len(sm.Serialize()) {
+		t.Fatalf("size %d does not match %s", sm.Size(), sm.Serialize())
+	}
+	forged := strings.Replace(sm.Serialize(), `"Number":4`, `"Number":5`, 1)
+	_, err := NewSignedMessageFromSerialized(forged)
+	invalid, ok := err.(*InvalidSignatureError)
+	if !ok || invalid.Signer != kp.PublicKey().String() {
+		t.Fatalf("expected an invalid signature from foo but got: %v", err)
+	}
+
+	// The timestamp is signed too
+	parts := strings.SplitN(sm.Serialize(), ":", 5)
+	parts[3] = "1"
+	_, err = NewSignedMessageFromSerialized(strings.Join(parts, ":"))
+	if _, ok := err.(*InvalidSignatureError); !ok {
+		t.Fatalf("expected a changed timestamp to fail verification but got: %v", err)
+	}
+}
+
+func TestReadLineLimit(t *testing.T) {
+	// A small buffer makes readLine read the line in several pieces
+	long := strings.Repeat("x", 100)
+	r := bufio.NewReaderSize(strings.NewReader(long+"\nok\n"+long+long+"\n"), 16)
+	line, err := readLine(r, 150)
+	if err != nil || line != long+"\n" {
+		t.Fatalf("bad line %q: %v", line, err)
+	}
+	line, err = readLine(r, 150)
+	if err != nil || line != "ok\n" {
+		t.Fatalf("bad line %q: %v", line, err)
+	}
+	_, err = readLine(r, 150)
+	limit, ok := err.(*LimitError)
+	if !ok || limit.Limit != 150 || limit.Size <= 150 {
+		t.Fatalf("expected a limit error but got: %v", err)
+	}
+}
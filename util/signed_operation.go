@@ -1,9 +1,14 @@
 package util
 
 import (
+	"crypto/sha512"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
 )
 
 type SignedOperation struct {
@@ -17,7 +22,7 @@ type SignedOperation struct {
 	Signature string
 }
 
-func NewSignedOperation(op Operation, kp *KeyPair) *SignedOperation {
+func NewSignedOperation(op Operation, kp Signer) *SignedOperation {
 	if op == nil || reflect.ValueOf(op).IsNil() {
 		Logger.Fatal("cannot sign nil operation")
 	}
@@ -30,7 +35,11 @@ func NewSignedOperation(op Operation, kp *KeyPair) *SignedOperation {
 	if err != nil {
 		Logger.Fatal("failed to sign operation because json encoding failed")
 	}
-	sig := kp.Sign(op.OperationType() + string(bytes))
+	payload, err := operationPayload(op.OperationType(), bytes)
+	if err != nil {
+		Logger.Fatalf("failed to sign operation: %s", err)
+	}
+	sig := kp.Sign(payload)
 
 	return &SignedOperation{
 		Operation: op,
@@ -39,6 +48,27 @@ func NewSignedOperation(op Operation, kp *KeyPair) *SignedOperation {
 	}
 }
 
+// operationPayload is what gets signed for an operation: its type followed by
+// the canonical JSON for the operation.
+func operationPayload(opType string, encoded []byte) (string, error) {
+	canonical, err := CanonicalJSON(encoded)
+	if err != nil {
+		return "", err
+	}
+	return opType + string(canonical), nil
+}
+
+// verifyOperation checks the signature on an encoded operation.
+// Operations used to be signed over their type and the exact bytes of their
+// encoding, so those signatures are still accepted, to keep old blocks valid.
+func verifyOperation(pk PublicKey, opType string, encoded []byte, signature string) bool {
+	payload, err := operationPayload(opType, encoded)
+	if err == nil && VerifySignature(pk, payload, signature) {
+		return true
+	}
+	return VerifySignature(pk, opType+string(encoded), signature)
+}
+
 type partiallyUnmarshaledSignedOperation struct {
 	Operation json.RawMessage
 	Type      string
@@ -68,7 +98,7 @@ func (s *SignedOperation) UnmarshalJSON(data []byte) error {
 	if err != nil {
 		return err
 	}
-	if !VerifySignature(pk, partial.Type+string(partial.Operation), partial.Signature) {
+	if !verifyOperation(pk, partial.Type, partial.Operation, partial.Signature) {
 		return fmt.Errorf("invalid signature on SignedOperation")
 	}
 
@@ -79,6 +109,14 @@ func (s *SignedOperation) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// Hash identifies a signed operation. Signing is deterministic, so the same
+// operation signed by the same key always has the same hash, and a blockchain
+// never has two operations with the same hash.
+func (s *SignedOperation) Hash() string {
+	h := sha512.Sum512_256([]byte(s.Signature))
+	return base64.RawStdEncoding.EncodeToString(h[:])
+}
+
 // TODO: can we get rid of this because verification happens on decode now
 func (s *SignedOperation) Verify() bool {
 	if s.Operation == nil || reflect.ValueOf(s.Operation).IsNil() {
@@ -92,7 +130,7 @@ func (s *SignedOperation) Verify() bool {
 	if err != nil {
 		return false
 	}
-	if !VerifySignature(pk, s.Type+string(bytes), s.Signature) {
+	if !verifyOperation(pk, s.Type, bytes, s.Signature) {
 		return false
 	}
 	if !s.Operation.Verify() {
@@ -102,6 +140,37 @@ func (s *SignedOperation) Verify() bool {
 	return true
 }
 
+// VerifyOperations returns whether every operation verifies. Checking
+// signatures is the slow part of validating a chunk, and each one is
+// independent of the others, so they are split up among GOMAXPROCS
+// goroutines.
+func VerifyOperations(ops []*SignedOperation) bool {
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(ops) {
+		workers = len(ops)
+	}
+	var failed int32
+	var next int64 = -1
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for atomic.LoadInt32(&failed) == 0 {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= len(ops) {
+					return
+				}
+				if ops[i] == nil || !ops[i].Verify() {
+					atomic.StoreInt32(&failed, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return failed == 0
+}
+
 // HighestPriorityFirst is a comparator in the emirpasic/gods comparator style.
 // Negative return indicates a < b
 // Positive return indicates a > b
@@ -2,7 +2,7 @@ package util
 
 import (
 	"encoding/json"
-
+	"fmt"
 	"testing"
 )
 
@@ -38,3 +38,65 @@ func TestSignedOperationJson(t *testing.T) {
 		t.Fatalf("so2.Operation is %+v", so2.Operation)
 	}
 }
+
+func TestSignedOperationHash(t *testing.T) {
+	kp := NewKeyPairFromSecretPhrase("hash")
+	op := &TestingOperation{Number: 3, Signer: kp.PublicKey().String()}
+	so := NewSignedOperation(op, kp)
+	if so.Hash() != NewSignedOperation(op, kp).Hash() {
+		t.Fatal("signing the same operation twice should get the same hash")
+	}
+	bytes, err := json.Marshal(so)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &SignedOperation{}
+	if err := json.Unmarshal(bytes, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Hash() != so.Hash() {
+		t.Fatal("the hash should survive encoding")
+	}
+	other := &TestingOperation{Number: 4, Signer: kp.PublicKey().String()}
+	if NewSignedOperation(other, kp).Hash() == so.Hash() {
+		t.Fatal("different operations should get different hashes")
+	}
+}
+
+func TestSignedOperationReordered(t *testing.T) {
+	kp := NewKeyPairFromSecretPhrase("hi")
+	op := &TestingOperation{
+		Number: 9,
+		Signer: kp.PublicKey().String(),
+	}
+	so := NewSignedOperation(op, kp)
+
+	// Another implementation might order the fields differently
+	reordered := fmt.Sprintf(`{"Operation": {"Signer": %q, "Number": 9}, "Type": "Testing", "Signature": %q}`,
+		op.Signer, so.Signature)
+	so2 := &SignedOperation{}
+	if err := json.Unmarshal([]byte(reordered), so2); err != nil {
+		t.Fatal(err)
+	}
+	if !so2.Verify() {
+		t.Fatal("so2 should Verify")
+	}
+}
+
+func TestVerifyOperations(t *testing.T) {
+	ops := []*SignedOperation{}
+	for i := 0; i < 20; i++ {
+		kp := NewKeyPairFromSecretPhrase(fmt.Sprintf("verify %d", i))
+		op := &TestingOperation{Number: i, Signer: kp.PublicKey().String()}
+		ops = append(ops, NewSignedOperation(op, kp))
+	}
+	if !VerifyOperations(ops) || !VerifyOperations(nil) {
+		t.Fatal("every operation should verify")
+	}
+	forged := *ops[13]
+	forged.Signature = ops[12].Signature
+	ops[13] = &forged
+	if VerifyOperations(ops) {
+		t.Fatal("a forged operation should not verify")
+	}
+}
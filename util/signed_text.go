@@ -0,0 +1,50 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SignedText is some text signed by an account, outside of any operation.
+// Services use it to check that someone owns an address, by asking them to
+// sign a challenge.
+// The signature covers signedTextPrefix followed by the text. Operation
+// signatures start with an operation type and message signatures start with
+// a timestamp, so a signed text can never be passed off as either one.
+type SignedText struct {
+	// The public key or address of the account that signed the text
+	Signer string `json:"signer"`
+
+	Text string `json:"text"`
+
+	Signature string `json:"signature"`
+}
+
+const signedTextPrefix = "coinkit signed text:\n"
+
+// SignText signs text with a key pair. The signer is the 0x public key.
+func SignText(text string, kp Signer) *SignedText {
+	return &SignedText{
+		Signer:    kp.PublicKey().String(),
+		Text:      text,
+		Signature: kp.Sign(signedTextPrefix + text),
+	}
+}
+
+// Verify checks the signature and returns the public key of the signer.
+// The signer can be written as an address with any prefix, so callers that
+// care which network an address is for should check it themselves.
+func (st *SignedText) Verify() (PublicKey, error) {
+	pk, _, err := ParseAddress(st.Signer)
+	if err != nil {
+		return pk, err
+	}
+	if !VerifySignature(pk, signedTextPrefix+st.Text, st.Signature) {
+		return pk, errors.New("the signature does not match")
+	}
+	return pk, nil
+}
+
+func (st *SignedText) String() string {
+	return fmt.Sprintf("%q signed by %s", st.Text, st.Signer)
+}
@@ -0,0 +1,50 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestSignedText(t *testing.T) {
+	kp := NewKeyPairFromSecretPhrase("alice")
+	st := SignText("log in to example.com, challenge 12345", kp)
+	pk, err := st.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pk.Equal(kp.PublicKey()) {
+		t.Fatalf("signed by %s, not %s", pk, kp.PublicKey())
+	}
+
+	// Services can name the signer by address
+	st.Signer = kp.PublicKey().Address(DefaultAddressPrefix)
+	if _, err := st.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	st.Text = "log in to example.com, challenge 12346"
+	if _, err := st.Verify(); err == nil {
+		t.Fatal("changing the text should break the signature")
+	}
+
+	other := NewKeyPairFromSecretPhrase("bob")
+	st = SignText("hello", kp)
+	st.Signer = other.PublicKey().String()
+	if _, err := st.Verify(); err == nil {
+		t.Fatal("the text should not verify for another signer")
+	}
+}
+
+func TestSignedTextIsNotAnOperation(t *testing.T) {
+	// A signed text that looks like an operation still can't be used as one
+	kp := NewKeyPairFromSecretPhrase("alice")
+	op := &TestingOperation{Number: 1, Signer: kp.PublicKey().String()}
+	sop := NewSignedOperation(op, kp)
+	payload, err := operationPayload(sop.Type, []byte(`{"Number":1,"Signer":"`+op.Signer+`"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := SignText(payload, kp)
+	if VerifySignature(kp.PublicKey(), payload, st.Signature) {
+		t.Fatal("a signed text should not be a valid operation signature")
+	}
+}
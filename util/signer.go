@@ -0,0 +1,18 @@
+package util
+
+// A Signer signs with a validator's key. A KeyPair is a Signer that holds
+// the private key itself. network.RemoteSigner is one that asks another
+// process, so the private key doesn't have to be on the host a validator
+// runs on.
+// Signing can't fail, since a validator that can't sign can't do anything.
+// A Signer that can fail should retry, and give up by exiting.
+type Signer interface {
+	PublicKey() PublicKey
+
+	// Sign interprets the message as utf8, then returns the signature as
+	// base64, like KeyPair.Sign.
+	Sign(message string) string
+
+	// ProveVRF is like KeyPair.ProveVRF.
+	ProveVRF(alpha []byte) (proof []byte, output []byte)
+}
@@ -0,0 +1,126 @@
+package util
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// A Span records how long one step of processing took. Spans that share a
+// TraceId are all part of handling the same thing, like a single message.
+// Tracing is off unless a SpanExporter is set. While it's off, StartSpan
+// returns nil and all the Span methods are no-ops.
+type Span struct {
+	TraceId  string
+	SpanId   string
+	ParentId string
+	Name     string
+	Start    time.Time
+	Finish   time.Time
+
+	// Small bits of information that help diagnose what happened
+	Attributes map[string]string
+
+	ended bool
+}
+
+// A SpanExporter ships finished spans somewhere they can be inspected.
+// ExportSpan must not block, since it is called from the consensus path.
+type SpanExporter interface {
+	ExportSpan(span *Span)
+}
+
+var exporter SpanExporter
+var exporterMutex sync.RWMutex
+
+// SetSpanExporter turns tracing on. Pass nil to turn it back off.
+func SetSpanExporter(e SpanExporter) {
+	exporterMutex.Lock()
+	defer exporterMutex.Unlock()
+	exporter = e
+}
+
+func getSpanExporter() SpanExporter {
+	exporterMutex.RLock()
+	defer exporterMutex.RUnlock()
+	return exporter
+}
+
+func TracingEnabled() bool {
+	return getSpanExporter() != nil
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a context that makes spans started from it children
+// of span.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	if span == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the current span, or nil if there is none.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+func randomHex(n int) string {
+	bytes := make([]byte, n)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(bytes)
+}
+
+// StartSpan starts a span that is a child of any span in ctx.
+// It returns a context containing the new span, for starting grandchildren.
+// When tracing is off, the span is nil.
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	if !TracingEnabled() {
+		return ctx, nil
+	}
+	span := &Span{
+		SpanId:     randomHex(8),
+		Name:       name,
+		Start:      time.Now(),
+		Attributes: make(map[string]string),
+	}
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		span.TraceId = randomHex(16)
+	} else {
+		span.TraceId = parent.TraceId
+		span.ParentId = parent.SpanId
+	}
+	return ContextWithSpan(ctx, span), span
+}
+
+func (s *Span) SetAttribute(key string, value string) {
+	if s == nil {
+		return
+	}
+	s.Attributes[key] = value
+}
+
+// End finishes the span and sends it to the exporter.
+// Ending a span twice only exports it once.
+func (s *Span) End() {
+	if s == nil || s.ended {
+		return
+	}
+	s.ended = true
+	s.Finish = time.Now()
+	if e := getSpanExporter(); e != nil {
+		e.ExportSpan(s)
+	}
+}
+
+func (s *Span) Duration() time.Duration {
+	return s.Finish.Sub(s.Start)
+}
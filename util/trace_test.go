@@ -0,0 +1,53 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+type testingExporter struct {
+	spans []*Span
+}
+
+func (e *testingExporter) ExportSpan(span *Span) {
+	e.spans = append(e.spans, span)
+}
+
+func TestTracingDisabled(t *testing.T) {
+	_, span := StartSpan(context.Background(), "foo")
+	if span != nil {
+		t.Fatal("spans should be nil when tracing is off")
+	}
+	span.SetAttribute("a", "b")
+	span.End()
+}
+
+func TestSpanNesting(t *testing.T) {
+	e := &testingExporter{}
+	SetSpanExporter(e)
+	defer SetSpanExporter(nil)
+
+	ctx, parent := StartSpan(context.Background(), "parent")
+	_, child := StartSpan(ctx, "child")
+	child.End()
+	child.End()
+	parent.End()
+
+	if len(e.spans) != 2 {
+		t.Fatalf("expected 2 spans but got %d", len(e.spans))
+	}
+	if child.TraceId != parent.TraceId || child.ParentId != parent.SpanId {
+		t.Fatalf("bad nesting: %+v %+v", parent, child)
+	}
+
+	var decoded otlpRequest
+	err := json.Unmarshal(EncodeOTLP("test", e.spans), &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spans := decoded.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 || spans[0].ParentSpanId != parent.SpanId {
+		t.Fatalf("bad encoding: %+v", spans)
+	}
+}
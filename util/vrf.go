@@ -0,0 +1,138 @@
+package util
+
+import (
+	"bytes"
+	"crypto/sha512"
+
+	"filippo.io/edwards25519"
+)
+
+// A verifiable random function, or VRF, gives each key pair its own random
+// output for any input. Nobody can compute the output without the private
+// key, but anyone can check it with the public key and a proof. Unlike a
+// signature, there is only one valid output and proof for each key and input,
+// so the holder of the key can't pick a different one.
+//
+// This is ECVRF-EDWARDS25519-SHA512-TAI from RFC 9381, which works with the
+// ed25519 keys accounts and validators already have.
+
+// The sizes of a VRF proof and output, in bytes
+const VRFProofSize = 80
+const VRFOutputSize = 64
+
+const vrfSuite = 0x03
+
+// vrfHashToCurve maps the public key and the input to a point whose discrete
+// log nobody knows, by hashing with a counter until the hash is a valid point.
+func vrfHashToCurve(pk []byte, alpha []byte) *edwards25519.Point {
+	for counter := 0; counter < 256; counter++ {
+		h := sha512.New()
+		h.Write([]byte{vrfSuite, 0x01})
+		h.Write(pk)
+		h.Write(alpha)
+		h.Write([]byte{byte(counter), 0x00})
+		p, err := new(edwards25519.Point).SetBytes(h.Sum(nil)[:32])
+		if err == nil {
+			return p.MultByCofactor(p)
+		}
+	}
+	// Each try works about half the time, so this never happens
+	panic("could not hash to the curve")
+}
+
+// vrfChallenge hashes the points of a proof into the 16-byte challenge.
+func vrfChallenge(points ...*edwards25519.Point) []byte {
+	h := sha512.New()
+	h.Write([]byte{vrfSuite, 0x02})
+	for _, p := range points {
+		h.Write(p.Bytes())
+	}
+	h.Write([]byte{0x00})
+	return h.Sum(nil)[:16]
+}
+
+// vrfOutput is the output for a proof's gamma point.
+func vrfOutput(gamma *edwards25519.Point) []byte {
+	h := sha512.New()
+	h.Write([]byte{vrfSuite, 0x03})
+	h.Write(new(edwards25519.Point).MultByCofactor(gamma).Bytes())
+	h.Write([]byte{0x00})
+	return h.Sum(nil)
+}
+
+// challengeScalar reads a 16-byte challenge as a scalar.
+func challengeScalar(c []byte) *edwards25519.Scalar {
+	padded := make([]byte, 32)
+	copy(padded, c)
+	s, err := new(edwards25519.Scalar).SetCanonicalBytes(padded)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// ProveVRF returns the VRF output for alpha, and a proof that anyone with our
+// public key can check with VerifyVRF.
+func (kp *KeyPair) ProveVRF(alpha []byte) (proof []byte, output []byte) {
+	expanded := sha512.Sum512(kp.privateKey.Seed())
+	x, err := new(edwards25519.Scalar).SetBytesWithClamping(expanded[:32])
+	if err != nil {
+		panic(err)
+	}
+	pk := kp.publicKey.WithoutChecksum()
+	y, err := new(edwards25519.Point).SetBytes(pk)
+	if err != nil {
+		panic(err)
+	}
+
+	h := vrfHashToCurve(pk, alpha)
+	gamma := new(edwards25519.Point).ScalarMult(x, h)
+	nonce := sha512.Sum512(append(append([]byte{}, expanded[32:]...), h.Bytes()...))
+	k, err := new(edwards25519.Scalar).SetUniformBytes(nonce[:])
+	if err != nil {
+		panic(err)
+	}
+	c := vrfChallenge(y, h, gamma,
+		new(edwards25519.Point).ScalarBaseMult(k),
+		new(edwards25519.Point).ScalarMult(k, h))
+	s := new(edwards25519.Scalar).MultiplyAdd(challengeScalar(c), x, k)
+
+	proof = append(append(gamma.Bytes(), c...), s.Bytes()...)
+	return proof, vrfOutput(gamma)
+}
+
+// VerifyVRF checks a proof that the owner of pk got a VRF output for alpha,
+// and returns the output. It returns false if the proof is invalid.
+func VerifyVRF(pk PublicKey, alpha []byte, proof []byte) ([]byte, bool) {
+	if len(proof) != VRFProofSize {
+		return nil, false
+	}
+	y, err := new(edwards25519.Point).SetBytes(pk.WithoutChecksum())
+	if err != nil {
+		return nil, false
+	}
+	identity := edwards25519.NewIdentityPoint()
+	if new(edwards25519.Point).MultByCofactor(y).Equal(identity) == 1 {
+		// A small order key would make every proof check out
+		return nil, false
+	}
+	gamma, err := new(edwards25519.Point).SetBytes(proof[:32])
+	if err != nil {
+		return nil, false
+	}
+	c := proof[32:48]
+	s, err := new(edwards25519.Scalar).SetCanonicalBytes(proof[48:])
+	if err != nil {
+		return nil, false
+	}
+
+	h := vrfHashToCurve(pk.WithoutChecksum(), alpha)
+	minusC := new(edwards25519.Scalar).Negate(challengeScalar(c))
+	u := new(edwards25519.Point).VarTimeDoubleScalarBaseMult(minusC, y, s)
+	v := new(edwards25519.Point).VarTimeMultiScalarMult(
+		[]*edwards25519.Scalar{s, minusC}, []*edwards25519.Point{h, gamma})
+	if !bytes.Equal(c, vrfChallenge(y, h, gamma, u, v)) {
+		return nil, false
+	}
+	return vrfOutput(gamma), true
+}
@@ -0,0 +1,63 @@
+package util
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// The first test vector for ECVRF-EDWARDS25519-SHA512-TAI in RFC 9381
+func TestVRFVector(t *testing.T) {
+	seed, _ := hex.DecodeString("9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60")
+	priv := ed25519.NewKeyFromSeed(seed)
+	kp := &KeyPair{
+		publicKey:  GeneratePublicKey(priv.Public().(ed25519.PublicKey)),
+		privateKey: priv,
+	}
+	proof, output := kp.ProveVRF([]byte{})
+	expectedProof := "8657106690b5526245a92b003bb079ccd1a92130477671f6fc01ad16f26f723f" +
+		"26f8a57ccaed74ee1b190bed1f479d9727d2d0f9b005a6e456a35d4fb0daab12" +
+		"68a1b0db10836d9826a528ca76567805"
+	expectedOutput := "90cf1df3b703cce59e2a35b925d411164068269d7b2d29f3301c03dd757876ff" +
+		"66b71dda49d2de59d03450451af026798e8f81cd2e333de5cdf4f3e140fdd8ae"
+	if hex.EncodeToString(proof) != expectedProof {
+		t.Fatalf("bad proof: %x", proof)
+	}
+	if hex.EncodeToString(output) != expectedOutput {
+		t.Fatalf("bad output: %x", output)
+	}
+	verified, ok := VerifyVRF(kp.PublicKey(), []byte{}, proof)
+	if !ok || hex.EncodeToString(verified) != expectedOutput {
+		t.Fatal("the proof should verify")
+	}
+}
+
+func TestVRFRejectsBadProofs(t *testing.T) {
+	kp := NewKeyPairFromSecretPhrase("foo")
+	alpha := []byte("slot 7")
+	proof, output := kp.ProveVRF(alpha)
+	if len(proof) != VRFProofSize || len(output) != VRFOutputSize {
+		t.Fatal("bad sizes")
+	}
+	if _, ok := VerifyVRF(kp.PublicKey(), alpha, proof); !ok {
+		t.Fatal("the proof should verify")
+	}
+	if _, ok := VerifyVRF(kp.PublicKey(), []byte("slot 8"), proof); ok {
+		t.Fatal("the proof should not verify for another input")
+	}
+	other := NewKeyPairFromSecretPhrase("bar")
+	if _, ok := VerifyVRF(other.PublicKey(), alpha, proof); ok {
+		t.Fatal("the proof should not verify for another key")
+	}
+	for i := 0; i < len(proof); i += 7 {
+		tampered := append([]byte{}, proof...)
+		tampered[i] ^= 1
+		if _, ok := VerifyVRF(kp.PublicKey(), alpha, tampered); ok {
+			t.Fatalf("a proof with byte %d changed should not verify", i)
+		}
+	}
+	if _, ok := VerifyVRF(kp.PublicKey(), alpha, proof[1:]); ok {
+		t.Fatal("a short proof should not verify")
+	}
+}